@@ -0,0 +1,202 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/coinbase/rosetta-validator/internal/transport"
+
+	"github.com/coinbase/rosetta-validator/internal/scheduler"
+)
+
+// concurrency is the response of the admin
+// /concurrency endpoint.
+type concurrency struct {
+	BlockConcurrency   int `json:"block_concurrency"`
+	AccountConcurrency int `json:"account_concurrency"`
+}
+
+// concurrencyHandler reports and adjusts the concurrency
+// enforced by limiter. Each block being fetched may have
+// up to transactionConcurrency requests in flight, so the
+// Fetch limit is blockConcurrency*transactionConcurrency.
+// Concurrency can be lowered and raised back up to its
+// starting value (the number of goroutines started by the
+// fetcher and reconciler) but not beyond it.
+type concurrencyHandler struct {
+	limiter                *scheduler.Limiter
+	transactionConcurrency int
+	max                    concurrency
+}
+
+// current returns the concurrency enforced by the limiter.
+func (h *concurrencyHandler) current() concurrency {
+	return concurrency{
+		BlockConcurrency:   h.limiter.Limit(scheduler.Fetch) / h.transactionConcurrency,
+		AccountConcurrency: h.limiter.Limit(scheduler.Reconcile),
+	}
+}
+
+// parseConcurrency parses the form value key of r, if
+// it is set, as a concurrency between 1 and max.
+func parseConcurrency(r *http.Request, key string, max int) (int, bool, error) {
+	value := r.FormValue(key)
+	if len(value) == 0 {
+		return 0, false, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > max {
+		return 0, false, fmt.Errorf("%s must be between 1 and %d", key, max)
+	}
+
+	return n, true, nil
+}
+
+// ServeHTTP returns the current concurrency. For POST
+// requests, the block_concurrency and account_concurrency
+// form values (if set) are applied first.
+func (h *concurrencyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		blocks, setBlocks, err := parseConcurrency(r, "block_concurrency", h.max.BlockConcurrency)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		accounts, setAccounts, err := parseConcurrency(r, "account_concurrency", h.max.AccountConcurrency)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if setBlocks {
+			h.limiter.SetLimit(scheduler.Fetch, blocks*h.transactionConcurrency)
+			log.Printf("Set BLOCK_CONCURRENCY to %d\n", blocks)
+		}
+
+		if setAccounts {
+			h.limiter.SetLimit(scheduler.Reconcile, accounts)
+			log.Printf("Set ACCOUNT_CONCURRENCY to %d\n", accounts)
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(h.current())
+}
+
+// newConcurrencyLimiter returns a limiter that starts at
+// the configured concurrency and a handler to adjust it.
+func newConcurrencyLimiter(cfg config) (*scheduler.Limiter, *concurrencyHandler) {
+	transactionConcurrency := int(cfg.TransactionConcurrency)
+	if transactionConcurrency < 1 {
+		transactionConcurrency = 1
+	}
+
+	max := concurrency{
+		BlockConcurrency:   int(cfg.BlockConcurrency),
+		AccountConcurrency: reconcileConcurrency(cfg),
+	}
+	limiter := scheduler.NewLimiter(
+		max.BlockConcurrency*transactionConcurrency,
+		max.AccountConcurrency,
+	)
+
+	return limiter, &concurrencyHandler{
+		limiter:                limiter,
+		transactionConcurrency: transactionConcurrency,
+		max:                    max,
+	}
+}
+
+// rateLimit is the response of the admin
+// /rate_limit endpoint. 0 means unlimited.
+type rateLimit struct {
+	MaxBlocksPerSecond float64 `json:"max_blocks_per_second"`
+}
+
+// rateLimitHandler reports and adjusts the rate
+// limit of block fetches.
+type rateLimitHandler struct {
+	blocks *transport.RateLimitedTransport
+}
+
+// ServeHTTP returns the current rate limit. For POST
+// requests, the max_blocks_per_second form value (if
+// set) is applied first.
+func (h *rateLimitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		value := r.FormValue("max_blocks_per_second")
+		if len(value) > 0 {
+			blocks, err := strconv.ParseFloat(value, 64)
+			if err != nil || blocks < 0 || math.IsInf(blocks, 0) || math.IsNaN(blocks) {
+				http.Error(w, "max_blocks_per_second must be a number >= 0", http.StatusBadRequest)
+				return
+			}
+
+			h.blocks.SetLimit(blocks)
+			log.Printf("Set MAX_BLOCKS_PER_SECOND to %v\n", blocks)
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(rateLimit{
+		MaxBlocksPerSecond: h.blocks.Limit(),
+	})
+}
+
+// serveAdmin serves the admin endpoints on addr
+// until the context is canceled.
+func serveAdmin(
+	ctx context.Context,
+	addr string,
+	concurrency *concurrencyHandler,
+	rateLimit *rateLimitHandler,
+) error {
+	mux := http.NewServeMux()
+	mux.Handle("/concurrency", concurrency)
+	mux.Handle("/rate_limit", rateLimit)
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		_ = server.Shutdown(context.Background())
+	}()
+
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
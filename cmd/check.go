@@ -0,0 +1,2007 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/coinbase/rosetta-validator/internal/checkpoint"
+	"github.com/coinbase/rosetta-validator/internal/endcondition"
+	"github.com/coinbase/rosetta-validator/internal/events"
+	"github.com/coinbase/rosetta-validator/internal/health"
+	"github.com/coinbase/rosetta-validator/internal/mempool"
+	"github.com/coinbase/rosetta-validator/internal/metrics"
+	"github.com/coinbase/rosetta-validator/internal/processor"
+	"github.com/coinbase/rosetta-validator/internal/reconciler"
+	"github.com/coinbase/rosetta-validator/internal/resources"
+	"github.com/coinbase/rosetta-validator/internal/scheduler"
+	"github.com/coinbase/rosetta-validator/internal/storage"
+	"github.com/coinbase/rosetta-validator/internal/syncer"
+	"github.com/coinbase/rosetta-validator/internal/transport"
+	"github.com/coinbase/rosetta-validator/internal/utils"
+
+	"github.com/coinbase/rosetta-sdk-go/fetcher"
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/caarlos0/env"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+)
+
+// stallCheckInterval is how often the StallDetector
+// checks whether the node has stalled.
+const stallCheckInterval = 10 * time.Second
+
+// blockEventsRetryDelay is the time waited before
+// fetching block events again after fetching them
+// failed.
+const blockEventsRetryDelay = 10 * time.Second
+
+// soakTestReportFile is the file in DataDir the
+// StabilityReport of a soak test is written to.
+const soakTestReportFile = "soak_report.json"
+
+// rangeSummaryFile is the file in DataDir the
+// rangeSummary of the network is written to once
+// END_INDEX is reached.
+const rangeSummaryFile = "range_summary.json"
+
+// healthSummaryFile is the file in DataDir the
+// healthSummary is written to when UNTIL_HEALTHY
+// is set.
+const healthSummaryFile = "health_summary.json"
+
+// defaultDataDir is the DATA_DIR used
+// if none is configured.
+const defaultDataDir = "validator-data"
+
+// redactedConfig are the environment variables
+// omitted from the logged configuration because
+// they may contain credentials (ex: a webhook
+// URL containing a token).
+var redactedConfig = map[string]bool{
+	"AUTH_CLIENT_SECRET":    true,
+	"EXTRA_HEADERS":         true,
+	"PROXY_URL":             true,
+	"ALERT_WEBHOOK_URL":     true,
+	"STALL_REMEDIATION_URL": true,
+}
+
+// errShutdown is returned when the validator
+// is interrupted by a signal.
+var errShutdown = errors.New("shutdown requested")
+
+// errViolationsRecorded is returned when ContinueOnError
+// is set and a run recorded violations.
+var errViolationsRecorded = errors.New("violations recorded")
+
+// errNotDataDir is returned by resetDataDir when the
+// directory was not populated by a validator.
+var errNotDataDir = errors.New("refusing to reset a directory without validator data")
+
+// badgerManifest is the file Badger creates in
+// the directory of every Database.
+const badgerManifest = "MANIFEST"
+
+// Validation modes (see config.Mode).
+const (
+	modeFull      = "full"
+	modeDataOnly  = "data-only"
+	modeSpotCheck = "spot-check"
+)
+
+// Exit codes of the validator when validation fails.
+// Other errors (ex: invalid configuration) exit with 1.
+const (
+	exitReconciliationFailure = 2
+	exitSyncFailure           = 3
+	exitAssertionFailure      = 4
+	exitNodeStalled           = 5
+)
+
+type config struct {
+	// DataDir defaults to defaultDataDir (or a temporary
+	// directory if InMemory is set).
+	DataDir                string `env:"DATA_DIR"`
+	ServerAddr             string `env:"SERVER_ADDR,required"`
+	BlockConcurrency       uint64 `env:"BLOCK_CONCURRENCY" envDefault:"8"`
+	TransactionConcurrency uint64 `env:"TRANSACTION_CONCURRENCY" envDefault:"8"`
+	AccountConcurrency     int    `env:"ACCOUNT_CONCURRENCY" envDefault:"8"`
+	LogTransactions        bool   `env:"LOG_TRANSACTIONS" envDefault:"false"`
+	LogBenchmarks          bool   `env:"LOG_BENCHMARKS" envDefault:"false"`
+	LogBalanceChanges      bool   `env:"LOG_BALANCE_CHANGES" envDefault:"false"`
+	LogReconciliations     bool   `env:"LOG_RECONCILIATIONS" envDefault:"false"`
+
+	// Mode is modeFull to validate blocks, compute balances,
+	// and reconcile them, or modeDataOnly to only validate
+	// blocks (without computing balances or reconciling). A
+	// DATA_DIR can only be used with the mode it was
+	// populated with. modeSpotCheck validates the
+	// SpotCheckDepth most recent blocks, walking back from
+	// the tip, and exits without using DATA_DIR.
+	Mode           string `env:"MODE" envDefault:"full"`
+	SpotCheckDepth int64  `env:"SPOT_CHECK_DEPTH" envDefault:"1000"`
+
+	// BlockStatsWindow is the number of blocks summarized in
+	// each log of block processing statistics (the averages
+	// and the blocks that were slowest to fetch and to apply).
+	// 0 disables it.
+	BlockStatsWindow int `env:"BLOCK_STATS_WINDOW" envDefault:"1000"`
+
+	// Connection pool settings for the fetcher's HTTP client. At
+	// high BLOCK_CONCURRENCY, the net/http defaults (2 idle connections
+	// per host) cause most requests to open a new connection.
+	MaxIdleConns        int           `env:"MAX_IDLE_CONNS" envDefault:"256"`
+	MaxIdleConnsPerHost int           `env:"MAX_IDLE_CONNS_PER_HOST" envDefault:"256"`
+	MaxConnsPerHost     int           `env:"MAX_CONNS_PER_HOST" envDefault:"0"`
+	IdleConnTimeout     time.Duration `env:"IDLE_CONN_TIMEOUT" envDefault:"90s"`
+	KeepAlive           time.Duration `env:"KEEP_ALIVE" envDefault:"30s"`
+	DisableKeepAlives   bool          `env:"DISABLE_KEEP_ALIVES" envDefault:"false"`
+	EnableHTTP2         bool          `env:"ENABLE_HTTP2" envDefault:"true"`
+
+	// TLS settings for connecting to the Rosetta Server (and its
+	// replicas). TLSCAFile is a PEM-encoded CA bundle used instead
+	// of the system roots. TLSCertFile and TLSKeyFile are a
+	// PEM-encoded client certificate and key for servers requiring
+	// mutual TLS. TLSInsecureSkipVerify disables verification of the
+	// server certificate and should only be used in development.
+	TLSCAFile             string `env:"TLS_CA_FILE"`
+	TLSCertFile           string `env:"TLS_CERT_FILE"`
+	TLSKeyFile            string `env:"TLS_KEY_FILE"`
+	TLSInsecureSkipVerify bool   `env:"TLS_INSECURE_SKIP_VERIFY" envDefault:"false"`
+
+	// ProxyURL is an http, https, or socks5 proxy that requests
+	// to the Rosetta Server (and its replicas) are sent through
+	// (ex: "socks5://proxy.internal:1080"). If it is not set, the
+	// standard HTTP_PROXY, HTTPS_PROXY, and NO_PROXY environment
+	// variables are respected.
+	ProxyURL string `env:"PROXY_URL"`
+
+	// CacheSize is the maximum number of immutable responses
+	// (blocks and transactions requested by hash) kept in memory.
+	// It is disabled by default (0).
+	CacheSize int `env:"CACHE_SIZE" envDefault:"0"`
+
+	// DeduplicateRequests collapses identical concurrent
+	// requests for blocks and transactions (ex: during reorg
+	// handling and retries) into a single request.
+	DeduplicateRequests bool `env:"DEDUPLICATE_REQUESTS" envDefault:"true"`
+
+	// MaxRequestsPerSecond limits all requests made to the
+	// Rosetta Server (blocks and balances) using a token
+	// bucket that allows bursts of RequestBurst requests.
+	// Set to 0 to disable rate limiting.
+	MaxRequestsPerSecond float64 `env:"MAX_REQUESTS_PER_SECOND" envDefault:"0"`
+	RequestBurst         int     `env:"REQUEST_BURST" envDefault:"1"`
+
+	// MaxBlocksPerSecond limits block fetches (but not the
+	// transactions fetched for each block) using a token bucket
+	// that allows bursts of BlockBurst blocks. Set to 0 to
+	// disable it. If AdminAddr is set, it can be changed while
+	// the validator runs.
+	MaxBlocksPerSecond float64 `env:"MAX_BLOCKS_PER_SECOND" envDefault:"0"`
+	BlockBurst         int     `env:"BLOCK_BURST" envDefault:"1"`
+
+	// ThrottleSchedule reduces MaxRequestsPerSecond during
+	// windows of the day in local time, formatted as
+	// start-end=fraction (ex: "09:00-17:00=0.2" allows 20% of
+	// MaxRequestsPerSecond during business hours). Outside of
+	// any window, MaxRequestsPerSecond is allowed.
+	ThrottleSchedule string `env:"THROTTLE_SCHEDULE"`
+
+	// PrefetchBlocks is the number of blocks fetched ahead of
+	// the block being processed. Blocks are fetched in ranges
+	// of PrefetchBlocks while the previous range is processed,
+	// which improves throughput on high-latency nodes. 0 fetches
+	// every block in a sync cycle before processing any of them.
+	PrefetchBlocks int64 `env:"PREFETCH_BLOCKS" envDefault:"0"`
+
+	// AllowOmittedBlocks skips block indexes that the Rosetta
+	// Server returns without a block (some blockchains do not
+	// produce a block at every index). The block after an
+	// omitted index must still reference the last block that
+	// was added as its parent.
+	AllowOmittedBlocks bool `env:"ALLOW_OMITTED_BLOCKS" envDefault:"false"`
+
+	// ContinueOnError records blocks, transactions, and
+	// operations that fail assertion (ex: an unknown
+	// operation status or a duplicate operation index) and
+	// balance changes that can't be applied (ex: a negative
+	// balance) as violations instead of stopping, so that a
+	// single run finds every violation. Invalid operations
+	// are not applied to balances and transactions without
+	// a valid identifier are not stored (a block without
+	// valid identifiers still stops the validator). The
+	// validator exits with an assertion failure if any
+	// violations were recorded.
+	ContinueOnError bool `env:"CONTINUE_ON_ERROR" envDefault:"false"`
+
+	// SkipBlocks lists known-problem blocks of the network (ex:
+	// historically malformed blocks acknowledged upstream) as
+	// index or index:hash entries. Their assertion, timestamp,
+	// and balance errors are logged instead of stopping
+	// validation (the balance changes that can't be applied
+	// are skipped).
+	SkipBlocks []string `env:"SKIP_BLOCKS" envSeparator:","`
+
+	// GenesisHash is the expected hash of the genesis block of
+	// the network. If it is set, syncing stops if the genesis
+	// block of the Rosetta Server has a different hash (ex:
+	// because it serves the wrong network).
+	GenesisHash string `env:"GENESIS_HASH"`
+
+	// TipPollInterval is the time waited before polling the
+	// Rosetta Server for a new block once every block up to
+	// its tip has been processed. A random duration of up to
+	// TipPollJitter is added to each wait.
+	TipPollInterval time.Duration `env:"TIP_POLL_INTERVAL" envDefault:"1s"`
+	TipPollJitter   time.Duration `env:"TIP_POLL_JITTER" envDefault:"500ms"`
+
+	// BlockEvents polls the block events of the Rosetta
+	// Server (if it supports them) every TipPollInterval so
+	// that new blocks are synced once they are added instead
+	// of polling the node for them at the tip. While block
+	// events can't be fetched (or if they are not
+	// supported), the tip is polled.
+	BlockEvents bool `env:"BLOCK_EVENTS" envDefault:"false"`
+
+	// DurableQueue stores fetched blocks in DATA_DIR before
+	// they are processed so that fetched blocks are not lost
+	// (or fetched again) if the validator restarts.
+	DurableQueue bool `env:"DURABLE_QUEUE" envDefault:"false"`
+
+	// InMemory stores validated data in memory instead of in
+	// DATA_DIR (ex: for a quick smoke test). Nothing is
+	// persisted, so validation starts from scratch on every
+	// run. If DATA_DIR is not set, logs are written to a
+	// temporary directory that is removed on exit (and
+	// reports to the working directory).
+	InMemory bool `env:"IN_MEMORY" envDefault:"false"`
+
+	// Resume requires DATA_DIR to contain a head block to
+	// continue from and Reset wipes DATA_DIR before syncing
+	// from genesis. They are set by the --resume and --reset
+	// flags of check. If neither is set, syncing continues
+	// from the head block in DATA_DIR, if any.
+	Resume bool
+	Reset  bool
+
+	// StorageCodec is the encoding ("gob", "json", or "msgpack")
+	// used for values written to DATA_DIR. Values written with a
+	// different codec remain readable, so it can be changed on an
+	// existing DATA_DIR.
+	StorageCodec string `env:"STORAGE_CODEC" envDefault:"gob"`
+
+	// KeyHash is the hash ("sha256" or "fnv") used to derive
+	// storage keys in DATA_DIR. "fnv" is cheaper but is not
+	// cryptographic. Unlike StorageCodec, it cannot be changed
+	// once DATA_DIR is populated.
+	KeyHash string `env:"KEY_HASH" envDefault:"sha256"`
+
+	// If AuthTokenURL is set, requests to the Rosetta Server
+	// are authenticated with short-lived bearer tokens fetched
+	// using the OAuth2 client credentials grant (through
+	// ProxyURL and with the TLS settings of the Rosetta Server).
+	AuthTokenURL     string   `env:"AUTH_TOKEN_URL"`
+	AuthClientID     string   `env:"AUTH_CLIENT_ID"`
+	AuthClientSecret string   `env:"AUTH_CLIENT_SECRET"`
+	AuthScopes       []string `env:"AUTH_SCOPES" envSeparator:","`
+
+	// UserAgent identifies the validator in the User-Agent
+	// header of every request to the Rosetta Server (ex: to
+	// distinguish validators sharing a node). Other metadata
+	// can be sent with ExtraHeaders.
+	UserAgent string `env:"USER_AGENT" envDefault:"rosetta-validator"`
+
+	// ExtraHeaders are set on every request to the Rosetta
+	// Server (and its replicas), formatted as semicolon-separated
+	// "Name: value" entries (ex: "Authorization: Bearer abc").
+	// They replace any header of the same name (including the
+	// bearer token fetched from AuthTokenURL).
+	ExtraHeaders string `env:"EXTRA_HEADERS"`
+
+	// LookupServerAddr is a Rosetta Server of the same network
+	// (ex: an archival node) that /account/balance requests made
+	// during reconciliation are sent to instead of SERVER_ADDR
+	// (ex: a pruned node). LookupConcurrency is the number of
+	// accounts reconciled concurrently against it (0 uses
+	// ACCOUNT_CONCURRENCY). Requests to it are not distributed
+	// across ReplicaAddrs or scheduled in the worker pool.
+	LookupServerAddr  string `env:"LOOKUP_SERVER_ADDR"`
+	LookupConcurrency int    `env:"LOOKUP_CONCURRENCY" envDefault:"0"`
+
+	// ReplicaAddrs are additional replicas of the Rosetta Server
+	// at SERVER_ADDR that requests are distributed across. Every
+	// ReplicaCheckInterval-th block request is sent to two
+	// replicas and their responses are compared (0 disables
+	// these consistency checks). A block requested by index is
+	// requested from the second replica by the identifier of the
+	// block returned by the first, so that replicas on different
+	// forks near the tip are not compared. A difference is
+	// recorded as a replica_mismatch finding. Network status
+	// requests are always sent to SERVER_ADDR.
+	ReplicaAddrs         []string `env:"REPLICA_ADDRS" envSeparator:","`
+	ReplicaCheckInterval uint64   `env:"REPLICA_CHECK_INTERVAL" envDefault:"100"`
+
+	// DoubleFetchBlocks determines if every block (and block
+	// transaction) is fetched twice over separate connections
+	// and the responses compared. Any difference is reported
+	// as a determinism bug in the Rosetta Server.
+	DoubleFetchBlocks bool `env:"DOUBLE_FETCH_BLOCKS" envDefault:"false"`
+
+	// WorkerPoolSize limits the number of concurrent requests
+	// to the Rosetta Server using a pool shared between block
+	// fetching and reconciliation. Slots shift to whichever has
+	// more pending requests, with each guaranteed at least
+	// WorkerPoolMinShare slots while both are busy. Set to 0 to
+	// disable the shared pool.
+	WorkerPoolSize     int `env:"WORKER_POOL_SIZE" envDefault:"0"`
+	WorkerPoolMinShare int `env:"WORKER_POOL_MIN_SHARE" envDefault:"1"`
+
+	// MaxMemoryMB and MaxGoroutines are ceilings on heap size
+	// and the number of goroutines (0 disables a ceiling). When
+	// usage approaches a ceiling, the number of blocks fetched
+	// per sync cycle and the number of concurrent requests to
+	// the Rosetta Server are reduced until usage falls. Usage
+	// is sampled every ResourceCheckInterval.
+	MaxMemoryMB           uint64        `env:"MAX_MEMORY_MB" envDefault:"0"`
+	MaxGoroutines         int           `env:"MAX_GOROUTINES" envDefault:"0"`
+	ResourceCheckInterval time.Duration `env:"RESOURCE_CHECK_INTERVAL" envDefault:"1s"`
+
+	// AnalyticsStreams retains every block event, balance
+	// change, and finding in DATA_DIR for analytics jobs
+	// (see BlockStorage.BlockEvents) until the entries
+	// recorded for blocks more than PruneDepth blocks below
+	// the head block are pruned with their transactions.
+	// Otherwise, only the entries of the last PastBlockSize
+	// blocks (read by utils:recover) are retained.
+	AnalyticsStreams bool `env:"ANALYTICS_STREAMS" envDefault:"false"`
+
+	// MaxDiskUsageMB is a ceiling on the size of DATA_DIR (0
+	// disables the ceiling), measured every DiskCheckInterval.
+	// As DATA_DIR approaches the ceiling, the transactions of
+	// blocks more than PruneDepth blocks below the head block
+	// are pruned (0 disables pruning) and space is reclaimed.
+	// If DATA_DIR still exceeds the ceiling, the validator
+	// halts with an error.
+	MaxDiskUsageMB    uint64        `env:"MAX_DISK_USAGE_MB" envDefault:"0"`
+	PruneDepth        int64         `env:"PRUNE_DEPTH" envDefault:"0"`
+	DiskCheckInterval time.Duration `env:"DISK_CHECK_INTERVAL" envDefault:"30s"`
+
+	// ReorgCompactionDepth triggers garbage collection of
+	// DATA_DIR once a reorg that orphaned at least this many
+	// blocks completes to reclaim the space used by orphaned
+	// blocks and reverted balances (0 disables it).
+	ReorgCompactionDepth int64 `env:"REORG_COMPACTION_DEPTH" envDefault:"10"`
+
+	// MaxReorgDepth halts the validator instead of orphaning
+	// more than this many consecutive blocks (0 allows reorgs
+	// of any depth). On most chains, a reorg this deep indicates
+	// a bug in the Rosetta Server rather than a real reorg.
+	MaxReorgDepth int64 `env:"MAX_REORG_DEPTH" envDefault:"0"`
+
+	// ConfirmationDepth delays applying the balance changes of
+	// each block until this many blocks have been added on top
+	// of it (0 applies them immediately). On chains with frequent
+	// shallow reorgs, this avoids reverting balances for blocks
+	// that are soon orphaned. Computed balances (and therefore
+	// reconciliation) trail the head by ConfirmationDepth blocks.
+	ConfirmationDepth int `env:"CONFIRMATION_DEPTH" envDefault:"0"`
+
+	// BalanceWorkers is the number of goroutines the balance
+	// changes of a block that modifies many accounts are applied
+	// with (1 applies them serially). Each goroutine updates the
+	// balances of different accounts, and the changes are
+	// applied serially instead if that would change the result.
+	BalanceWorkers int `env:"BALANCE_WORKERS" envDefault:"1"`
+
+	// TimestampUnit is the unit ("s", "ms", "us", or "ns") of
+	// the block timestamps returned by the Rosetta Server. The
+	// Rosetta specification requires milliseconds. It can be
+	// overridden for individual networks by TimestampUnits, a
+	// comma-separated list of "blockchain/network=unit" entries.
+	// Blocks with timestamps that appear to be in another unit
+	// are rejected.
+	TimestampUnit  string `env:"TIMESTAMP_UNIT" envDefault:"ms"`
+	TimestampUnits string `env:"TIMESTAMP_UNITS"`
+
+	// TimestampTolerance is how far the timestamp of a block
+	// may be before the timestamp of its parent (some chains,
+	// like Bitcoin, only require timestamps to increase over
+	// several blocks). Blocks after genesis with a zero
+	// timestamp are always rejected.
+	TimestampTolerance time.Duration `env:"TIMESTAMP_TOLERANCE" envDefault:"2h"`
+
+	// LogTimestampViolations logs blocks more than
+	// TimestampTolerance before their parent as structured
+	// (JSON) violations instead of stopping validation.
+	LogTimestampViolations bool `env:"LOG_TIMESTAMP_VIOLATIONS" envDefault:"false"`
+
+	// MetricsSink selects where metrics are recorded ("none",
+	// "prometheus", or "statsd"). For "prometheus", metrics are
+	// served on MetricsAddr at /metrics. For "statsd", metrics
+	// are sent to the StatsD server at MetricsAddr.
+	MetricsSink   string `env:"METRICS_SINK" envDefault:"none"`
+	MetricsAddr   string `env:"METRICS_ADDR" envDefault:":9090"`
+	MetricsPrefix string `env:"METRICS_PREFIX" envDefault:"rosetta_validator"`
+
+	// If PprofAddr is set (ex: "localhost:6060"), net/http/pprof
+	// profiles are served on it at /debug/pprof/ while the
+	// validator runs. It should not be reachable from untrusted
+	// networks.
+	PprofAddr string `env:"PPROF_ADDR"`
+
+	// If AdminAddr is set (ex: "localhost:6061"), the current
+	// BlockConcurrency and AccountConcurrency are served on it
+	// at /concurrency and can be lowered (ex: when the Rosetta
+	// Server starts rate limiting) by POSTing new values. Like
+	// PprofAddr, it should not be reachable from untrusted
+	// networks.
+	AdminAddr string `env:"ADMIN_ADDR"`
+
+	// Timeouts for each stage of validation. A hung call fails
+	// its stage once the timeout expires (0 disables a timeout).
+	// FetchTimeout bounds each request to the Rosetta Server,
+	// including the assertion of its response. StoreTimeout bounds
+	// storing (or orphaning) a block. ReconcileTimeout bounds the
+	// reconciliation of a single account, excluding the time spent
+	// waiting for syncing to reach the block of its live balance.
+	FetchTimeout     time.Duration `env:"FETCH_TIMEOUT" envDefault:"5m"`
+	StoreTimeout     time.Duration `env:"STORE_TIMEOUT" envDefault:"1m"`
+	ReconcileTimeout time.Duration `env:"RECONCILE_TIMEOUT" envDefault:"10m"`
+
+	// HTTPTimeout bounds each attempt of a request to the Rosetta
+	// Server. A failed attempt is retried (with exponential backoff)
+	// up to MaxRetries times, until MaxRetryElapsedTime has been
+	// spent retrying or the FetchTimeout of the request expires.
+	HTTPTimeout         time.Duration `env:"HTTP_TIMEOUT" envDefault:"10s"`
+	MaxRetries          uint64        `env:"MAX_RETRIES" envDefault:"10"`
+	MaxRetryElapsedTime time.Duration `env:"MAX_RETRY_ELAPSED_TIME" envDefault:"1m"`
+
+	// CircuitBreakerTimeout is the maximum time syncing is
+	// paused once a request to the Rosetta Server fails after
+	// its retries are exhausted (ex: because it is returning
+	// 5xx responses). While paused, syncing is attempted again
+	// after CircuitBreakerBackoff, which doubles after each
+	// failed attempt up to CircuitBreakerMaxBackoff. Syncing
+	// fails once it has been paused for the timeout. A timeout
+	// of 0 fails syncing immediately.
+	CircuitBreakerTimeout    time.Duration `env:"CIRCUIT_BREAKER_TIMEOUT" envDefault:"30m"`
+	CircuitBreakerBackoff    time.Duration `env:"CIRCUIT_BREAKER_BACKOFF" envDefault:"5s"`
+	CircuitBreakerMaxBackoff time.Duration `env:"CIRCUIT_BREAKER_MAX_BACKOFF" envDefault:"5m"`
+
+	// CheckpointsFile is a file of trusted block identifiers
+	// signed by the hex-encoded ed25519 CheckpointsPublicKey.
+	// Blocks at or below the last checkpoint are not asserted
+	// and their balance changes are not reconciled. Only their
+	// hash linkage (and any checkpoint hashes) are verified.
+	CheckpointsFile      string `env:"CHECKPOINTS_FILE"`
+	CheckpointsPublicKey string `env:"CHECKPOINTS_PUBLIC_KEY"`
+
+	// SnapshotFile is a trusted storage.BalanceSnapshot of
+	// the network. If no block has been synced, its balances
+	// are imported and syncing begins after its block instead
+	// of at genesis.
+	SnapshotFile string `env:"SNAPSHOT_FILE"`
+
+	// AccountLabelsFile is a JSON array of accounts and their
+	// labels (ex: [{"account": {"address": "..."}, "labels":
+	// ["hot-wallet"]}]). Labels are included in the findings
+	// and reconciliations of labeled accounts.
+	AccountLabelsFile string `env:"ACCOUNT_LABELS_FILE"`
+
+	// TrackNewCurrencies determines if currencies discovered in
+	// synced blocks (ex: contract-based tokens) are tracked (their
+	// balances computed and reconciled) until opted out with
+	// "utils:untrack-currency". If false, discovered currencies
+	// are untracked until opted in with "utils:track-currency".
+	TrackNewCurrencies bool `env:"TRACK_NEW_CURRENCIES" envDefault:"true"`
+
+	// If RecordFile is set, every request to the Rosetta Server
+	// and its response is appended to RecordFile. If ReplayFile
+	// is set to a recorded file, requests are answered from it
+	// instead of the Rosetta Server (in the order they were
+	// recorded) to reproduce a validation run deterministically.
+	// A replay fails once a request was not recorded.
+	RecordFile string `env:"RECORD_FILE"`
+	ReplayFile string `env:"REPLAY_FILE"`
+
+	// StallThreshold enables stalled node detection (0 disables
+	// it). If the tip of the node does not advance for
+	// StallThreshold while the validator is caught up to it, an
+	// alert is logged, recorded in the node_stalled metric, and
+	// posted to AlertWebhookURL (if set). StallRemediationURL (if
+	// set) is then called (ex: to restart the node). If
+	// ExitOnStall is set, the validator then exits with
+	// exitNodeStalled.
+	StallThreshold      time.Duration `env:"STALL_THRESHOLD" envDefault:"0"`
+	AlertWebhookURL     string        `env:"ALERT_WEBHOOK_URL"`
+	StallRemediationURL string        `env:"STALL_REMEDIATION_URL"`
+	ExitOnStall         bool          `env:"EXIT_ON_STALL" envDefault:"false"`
+
+	// ChainHaltThreshold enables chain halt detection (0
+	// disables it). If the timestamp of a synced block is more
+	// than ChainHaltThreshold after the timestamp of its
+	// parent, an alert is logged, counted in the chain_halts
+	// metric, and posted to AlertWebhookURL (if set), whether
+	// or not the tip of the node is advancing.
+	ChainHaltThreshold time.Duration `env:"CHAIN_HALT_THRESHOLD" envDefault:"0"`
+
+	// PauseOnHeadRegression pauses syncing while the tip
+	// reported by a node is below the highest tip it had
+	// reported (ex: while the node resyncs after a rollback).
+	// Each regression is logged, counted in the
+	// node_head_regressions metric, and posted to
+	// AlertWebhookURL (if set) whether or not syncing pauses.
+	PauseOnHeadRegression bool `env:"PAUSE_ON_HEAD_REGRESSION" envDefault:"false"`
+
+	// RecoverCorruption restores a stored block or balance
+	// that cannot be decoded while syncing or reconciling
+	// instead of stopping. The block (or the blocks needed
+	// to re-derive the balance) is fetched again.
+	RecoverCorruption bool `env:"RECOVER_CORRUPTION" envDefault:"false"`
+
+	// MempoolCheckInterval enables mempool monitoring (0
+	// disables it). The mempool of the network is fetched
+	// every MempoolCheckInterval and each new transaction in
+	// it is asserted. Transactions that are still not included
+	// in a synced block after MempoolStuckThreshold are
+	// reported as stuck (or dropped if they left the mempool).
+	MempoolCheckInterval  time.Duration `env:"MEMPOOL_CHECK_INTERVAL" envDefault:"0"`
+	MempoolStuckThreshold time.Duration `env:"MEMPOOL_STUCK_THRESHOLD" envDefault:"10m"`
+
+	// If SoakTestDuration is set, the validator stops after
+	// SoakTestDuration and writes a report of the stability
+	// indicators (goroutines, heap size, open file descriptors,
+	// reconciliation backlog, and synced head) sampled every
+	// SoakTestInterval to soak_report.json in DataDir.
+	SoakTestDuration time.Duration `env:"SOAK_TEST_DURATION" envDefault:"0"`
+	SoakTestInterval time.Duration `env:"SOAK_TEST_INTERVAL" envDefault:"1m"`
+
+	// StartIndex and EndIndex bound the blocks synced (-1
+	// leaves them unbounded). StartIndex only applies if no
+	// block has been synced. Balances before StartIndex are
+	// unknown, so if it is after genesis, balances are not
+	// computed (and no currencies are registered). Once
+	// the block at EndIndex is synced and the queued accounts
+	// are reconciled, the validator writes a summary of the
+	// range to range_summary.json in DataDir and exits.
+	StartIndex int64 `env:"START_INDEX" envDefault:"-1"`
+	EndIndex   int64 `env:"END_INDEX" envDefault:"-1"`
+
+	// ShutdownTimeout bounds the time spent reconciling the
+	// accounts still queued when the validator is interrupted
+	// (with SIGINT or SIGTERM) before it exits.
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"30s"`
+
+	// OneShot stops the validator once it has synced to the
+	// tip of each network (or END_INDEX) and reconciled every
+	// queued account. A summary is printed and the exit code
+	// indicates whether validation failed (see exitCode).
+	OneShot bool `env:"ONE_SHOT" envDefault:"false"`
+
+	// End conditions stop the validator (like ONE_SHOT once
+	// the tip is reached) once any is met: EndDuration has
+	// elapsed, EndReconciledAccounts accounts have been
+	// reconciled, or EndReconciliationCoverage (a fraction,
+	// ex: 0.95) of the accounts seen in operations have been
+	// reconciled (0 disables a condition). They are checked
+	// every EndConditionInterval and only apply to the
+	// network (not its sub-networks).
+	EndDuration               time.Duration `env:"END_DURATION" envDefault:"0"`
+	EndReconciledAccounts     int           `env:"END_RECONCILED_ACCOUNTS" envDefault:"0"`
+	EndReconciliationCoverage float64       `env:"END_RECONCILIATION_COVERAGE" envDefault:"0"`
+	EndConditionInterval      time.Duration `env:"END_CONDITION_INTERVAL" envDefault:"10s"`
+
+	// UntilHealthy stops the validator once every network
+	// (including sub-networks) is at the tip of the node with
+	// no accounts pending reconciliation. Any failure stops it
+	// sooner. Either way, a machine-readable summary (see
+	// healthSummary) is written to healthSummaryFile.
+	UntilHealthy bool `env:"UNTIL_HEALTHY" envDefault:"false"`
+}
+
+// resourceLimitsEnabled returns true if a resource
+// ceiling is set in config.
+func resourceLimitsEnabled(cfg config) bool {
+	return cfg.MaxMemoryMB > 0 || cfg.MaxGoroutines > 0
+}
+
+// newWorkerPool constructs the scheduler shared by block
+// fetching and reconciliation or returns nil if it is
+// disabled. If WORKER_POOL_SIZE is not set but resource
+// limits are, a pool large enough for the configured
+// concurrency is used so that it can be throttled.
+func newWorkerPool(cfg config) *scheduler.Scheduler {
+	size := cfg.WorkerPoolSize
+	if size == 0 && resourceLimitsEnabled(cfg) {
+		size = int(cfg.BlockConcurrency*cfg.TransactionConcurrency) + cfg.AccountConcurrency
+	}
+
+	if size == 0 {
+		return nil
+	}
+
+	return scheduler.New(size, cfg.WorkerPoolMinShare)
+}
+
+// newResourceMonitor constructs a resources.Monitor that
+// shrinks the blocks fetched per sync cycle (by each of
+// syncers) and the worker
+// pool (if any) as usage approaches the limits in config.
+func newResourceMonitor(
+	cfg config,
+	sink metrics.Sink,
+	syncers []*syncer.Syncer,
+	pool *scheduler.Scheduler,
+) *resources.Monitor {
+	monitor := resources.NewMonitor(
+		resources.Limits{
+			MaxMemory:     cfg.MaxMemoryMB << 20,
+			MaxGoroutines: cfg.MaxGoroutines,
+		},
+		resources.RuntimeSampler,
+		sink,
+	)
+
+	monitor.Register(func(scale float64) {
+		for _, s := range syncers {
+			s.SetMaxSync(int64(scale * syncer.DefaultMaxSync))
+		}
+	})
+
+	if pool != nil {
+		poolSize := pool.Capacity()
+		monitor.Register(func(scale float64) {
+			capacity := int(scale * float64(poolSize))
+			if capacity < 1 {
+				capacity = 1
+			}
+
+			pool.SetCapacity(capacity)
+		})
+	}
+
+	return monitor
+}
+
+// newDiskMonitor constructs a resources.DiskMonitor that
+// prunes blockStorages (if PRUNE_DEPTH is set) as DATA_DIR
+// approaches MAX_DISK_USAGE_MB.
+func newDiskMonitor(
+	cfg config,
+	sink metrics.Sink,
+	blockStorages []*storage.BlockStorage,
+) (*resources.DiskMonitor, error) {
+	var pruner resources.Pruner
+	if cfg.PruneDepth > 0 {
+		// Blocks within PastBlockSize of the head block
+		// must be complete to be orphaned in a reorg.
+		if cfg.PruneDepth <= syncer.PastBlockSize {
+			return nil, fmt.Errorf(
+				"PRUNE_DEPTH %d must be greater than %d",
+				cfg.PruneDepth,
+				syncer.PastBlockSize,
+			)
+		}
+
+		// Blocks pending confirmation must be complete
+		// to apply their balance changes.
+		if cfg.PruneDepth <= int64(cfg.ConfirmationDepth) {
+			return nil, fmt.Errorf(
+				"PRUNE_DEPTH %d must be greater than CONFIRMATION_DEPTH %d",
+				cfg.PruneDepth,
+				cfg.ConfirmationDepth,
+			)
+		}
+
+		// The BlockStorages share DATA_DIR, so it is
+		// only garbage collected once.
+		pruner = func(ctx context.Context) error {
+			for _, blockStorage := range blockStorages {
+				pruned, err := blockStorage.PruneBlocks(ctx, cfg.PruneDepth)
+				if err != nil {
+					return err
+				}
+
+				log.Printf("Pruned transactions of %d blocks\n", pruned)
+			}
+
+			return blockStorages[0].GarbageCollect(ctx)
+		}
+	}
+
+	return resources.NewDiskMonitor(
+		cfg.DataDir,
+		cfg.MaxDiskUsageMB<<20,
+		pruner,
+		sink,
+	), nil
+}
+
+// networkTimestampUnit returns the TimestampUnit
+// configured for network.
+func networkTimestampUnit(
+	cfg config,
+	network *rosetta.NetworkIdentifier,
+) (utils.TimestampUnit, error) {
+	units, err := utils.ParseTimestampUnits(cfg.TimestampUnits)
+	if err != nil {
+		return "", err
+	}
+
+	if unit, ok := units[network.Blockchain+"/"+network.Network]; ok {
+		return unit, nil
+	}
+
+	return utils.ParseTimestampUnit(cfg.TimestampUnit)
+}
+
+// clientTransports are the transports of an *http.Client
+// (nil if not enabled), returned so that their limits can
+// be adjusted and their mismatches observed.
+type clientTransports struct {
+	requests    *transport.RateLimitedTransport
+	blocks      *transport.RateLimitedTransport
+	doubleFetch *transport.DoubleFetchTransport
+	replica     *transport.ReplicaTransport
+
+	// archive is the RECORD_FILE responses are recorded
+	// to. It is closed by Close.
+	archive *os.File
+}
+
+// Close syncs and closes the RECORD_FILE (if any). It
+// must be called once no more requests are made so that
+// the last recorded responses are not lost.
+func (t *clientTransports) Close() error {
+	if t.archive == nil {
+		return nil
+	}
+
+	if err := t.archive.Sync(); err != nil {
+		t.archive.Close()
+		return err
+	}
+
+	return t.archive.Close()
+}
+
+// replicaMismatchFinding returns the Finding recorded for
+// a ReplicaMismatch. The hash of the block is empty if the
+// request identified the block only by index.
+func replicaMismatchFinding(mismatch *transport.ReplicaMismatch) *storage.Finding {
+	finding := &storage.Finding{
+		Type:       storage.ReplicaMismatchFinding,
+		Difference: fmt.Sprintf("%s != %s", mismatch.Replica, mismatch.CheckReplica),
+	}
+
+	if mismatch.Block != nil && mismatch.Block.Index != nil {
+		finding.Block = &rosetta.BlockIdentifier{Index: *mismatch.Block.Index}
+		if mismatch.Block.Hash != nil {
+			finding.Block.Hash = *mismatch.Block.Hash
+		}
+	}
+
+	return finding
+}
+
+// newHTTPClient constructs the *http.Client used by the
+// fetcher from the connection pool settings in config.
+// If pool is not nil, it bounds concurrent requests. Block
+// fetches are rate limited if MaxBlocksPerSecond or
+// AdminAddr is set, so that a limit can be set later.
+func newHTTPClient(
+	cfg config,
+	pool *scheduler.Scheduler,
+	limiter *scheduler.Limiter,
+) (*http.Client, *clientTransports, error) {
+	tlsConfig, err := transport.NewTLSConfig(
+		cfg.TLSCAFile,
+		cfg.TLSCertFile,
+		cfg.TLSKeyFile,
+		cfg.TLSInsecureSkipVerify,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	proxy, err := transport.NewProxy(cfg.ProxyURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	httpTransport := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: cfg.KeepAlive,
+		}).DialContext,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		DisableKeepAlives:     cfg.DisableKeepAlives,
+		ForceAttemptHTTP2:     cfg.EnableHTTP2,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	var roundTripper http.RoundTripper = httpTransport
+	headers, err := transport.ParseHeaders(cfg.ExtraHeaders)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(headers) > 0 {
+		roundTripper = transport.NewHeaderTransport(roundTripper, headers)
+	}
+
+	if len(cfg.RecordFile) > 0 && len(cfg.ReplayFile) > 0 {
+		return nil, nil, errors.New("RECORD_FILE and REPLAY_FILE cannot both be set")
+	}
+
+	if cfg.DoubleFetchBlocks && len(cfg.ReplayFile) > 0 {
+		return nil, nil, errors.New("DOUBLE_FETCH_BLOCKS and REPLAY_FILE cannot both be set")
+	}
+
+	transports := &clientTransports{}
+	if cfg.DoubleFetchBlocks {
+		// The second fetch of each block uses its own
+		// connections so that it is served independently.
+		var checkRoundTripper http.RoundTripper = httpTransport.Clone()
+		if len(headers) > 0 {
+			checkRoundTripper = transport.NewHeaderTransport(checkRoundTripper, headers)
+		}
+
+		transports.doubleFetch = transport.NewDoubleFetchTransport(roundTripper, checkRoundTripper)
+		roundTripper = transports.doubleFetch
+	}
+
+	if len(cfg.ReplayFile) > 0 {
+		archive, err := os.Open(cfg.ReplayFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer archive.Close()
+
+		roundTripper, err = transport.NewReplayTransport(archive)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if len(cfg.ReplicaAddrs) > 0 {
+		replicaTransport, err := transport.NewReplicaTransport(
+			roundTripper,
+			cfg.ServerAddr,
+			cfg.ReplicaAddrs,
+			cfg.ReplicaCheckInterval,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		transports.replica = replicaTransport
+		roundTripper = replicaTransport
+	}
+
+	// The archive is closed by clientTransports.Close
+	// when the validator exits.
+	if len(cfg.RecordFile) > 0 {
+		archive, err := os.OpenFile(
+			cfg.RecordFile,
+			os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+			0600,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		transports.archive = archive
+		roundTripper = transport.NewRecordingTransport(roundTripper, archive)
+	}
+
+	if cfg.MaxRequestsPerSecond > 0 {
+		transports.requests = transport.NewRateLimitedTransport(
+			roundTripper,
+			cfg.MaxRequestsPerSecond,
+			cfg.RequestBurst,
+		)
+		roundTripper = transports.requests
+	}
+
+	if cfg.MaxBlocksPerSecond > 0 || len(cfg.AdminAddr) > 0 {
+		transports.blocks = transport.NewRateLimitedTransport(
+			roundTripper,
+			cfg.MaxBlocksPerSecond,
+			cfg.BlockBurst,
+		)
+		transports.blocks.SetPaths("/block")
+		roundTripper = transports.blocks
+	}
+
+	if len(cfg.AuthTokenURL) > 0 {
+		// Tokens are fetched through the same proxy and with
+		// the same TLS settings as requests to the Rosetta
+		// Server, but without its headers or rate limits.
+		tokenClient := &http.Client{
+			Transport: httpTransport.Clone(),
+			Timeout:   10 * time.Second,
+		}
+
+		roundTripper = transport.NewAuthTransport(
+			roundTripper,
+			transport.NewClientCredentialsProvider(
+				cfg.AuthTokenURL,
+				cfg.AuthClientID,
+				cfg.AuthClientSecret,
+				cfg.AuthScopes,
+				tokenClient,
+			),
+		)
+	}
+
+	if pool != nil {
+		roundTripper = transport.NewScheduledTransport(roundTripper, pool)
+	}
+
+	// Requests waiting on the limiter do not
+	// occupy a worker pool slot.
+	if limiter != nil {
+		roundTripper = transport.NewScheduledTransport(roundTripper, limiter)
+	}
+
+	// Duplicate requests are collapsed before they
+	// occupy a worker pool slot or consume rate
+	// limit tokens.
+	if cfg.DeduplicateRequests {
+		roundTripper = transport.NewSingleflightTransport(roundTripper)
+	}
+
+	// Responses served from the cache do not consume
+	// rate limit tokens, require authentication, or
+	// occupy a worker pool slot.
+	if cfg.CacheSize > 0 {
+		roundTripper = transport.NewCachingTransport(roundTripper, cfg.CacheSize)
+	}
+
+	return &http.Client{
+		Transport: roundTripper,
+		Timeout:   cfg.HTTPTimeout,
+	}, transports, nil
+}
+
+// newEventsClient constructs the *http.Client block events
+// are polled with. Block events are not distributed across
+// replicas, recorded, or scheduled in the worker pool.
+func newEventsClient(cfg config) (*http.Client, error) {
+	if len(cfg.ReplayFile) > 0 {
+		return nil, errors.New("BLOCK_EVENTS cannot be used with REPLAY_FILE")
+	}
+
+	eventsCfg := cfg
+	eventsCfg.ReplicaAddrs = nil
+	eventsCfg.RecordFile = ""
+	eventsCfg.DoubleFetchBlocks = false
+	httpClient, _, err := newHTTPClient(eventsCfg, nil, nil)
+	return httpClient, err
+}
+
+// reconcileConcurrency returns the number of accounts
+// reconciled concurrently (LOOKUP_CONCURRENCY if balances
+// are fetched from LOOKUP_SERVER_ADDR and it is set).
+func reconcileConcurrency(cfg config) int {
+	if len(cfg.LookupServerAddr) > 0 && cfg.LookupConcurrency > 0 {
+		return cfg.LookupConcurrency
+	}
+
+	return cfg.AccountConcurrency
+}
+
+// newLookupFetcher constructs the fetcher used to reconcile
+// balances against LOOKUP_SERVER_ADDR. It returns an error if
+// LOOKUP_SERVER_ADDR is not serving the network at SERVER_ADDR.
+func newLookupFetcher(
+	ctx context.Context,
+	cfg config,
+	networkResponse *rosetta.NetworkStatusResponse,
+	limiter *scheduler.Limiter,
+) (*fetcher.Fetcher, error) {
+	if len(cfg.RecordFile) > 0 || len(cfg.ReplayFile) > 0 {
+		return nil, errors.New("LOOKUP_SERVER_ADDR cannot be used with RECORD_FILE or REPLAY_FILE")
+	}
+
+	lookupCfg := cfg
+	lookupCfg.ServerAddr = cfg.LookupServerAddr
+	lookupCfg.ReplicaAddrs = nil
+	httpClient, _, err := newHTTPClient(lookupCfg, nil, limiter)
+	if err != nil {
+		return nil, err
+	}
+
+	// Blocks and transactions are never fetched
+	// from LOOKUP_SERVER_ADDR.
+	lookupFetcher := fetcher.New(
+		ctx,
+		cfg.LookupServerAddr,
+		cfg.UserAgent,
+		httpClient,
+		1,
+		1,
+	)
+
+	lookupResponse, err := lookupFetcher.InitializeAsserter(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to initialize LOOKUP_SERVER_ADDR", err)
+	}
+
+	network := networkResponse.NetworkStatus
+	lookupNetwork := lookupResponse.NetworkStatus
+	if network.NetworkIdentifier.Blockchain != lookupNetwork.NetworkIdentifier.Blockchain ||
+		network.NetworkIdentifier.Network != lookupNetwork.NetworkIdentifier.Network {
+		return nil, fmt.Errorf(
+			"LOOKUP_SERVER_ADDR serves %s:%s, not %s:%s",
+			lookupNetwork.NetworkIdentifier.Blockchain,
+			lookupNetwork.NetworkIdentifier.Network,
+			network.NetworkIdentifier.Blockchain,
+			network.NetworkIdentifier.Network,
+		)
+	}
+
+	genesis := network.NetworkInformation.GenesisBlockIdentifier
+	lookupGenesis := lookupNetwork.NetworkInformation.GenesisBlockIdentifier
+	if genesis.Hash != lookupGenesis.Hash {
+		return nil, fmt.Errorf(
+			"LOOKUP_SERVER_ADDR genesis block %s does not match %s",
+			lookupGenesis.Hash,
+			genesis.Hash,
+		)
+	}
+
+	return lookupFetcher, nil
+}
+
+// newStallDetector constructs a health.StallDetector
+// from the webhooks configured in config.
+func newStallDetector(cfg config, sink metrics.Sink) *health.StallDetector {
+	var alert, remediation *health.Webhook
+	if len(cfg.AlertWebhookURL) > 0 {
+		alert = health.NewWebhook(cfg.AlertWebhookURL)
+	}
+
+	if len(cfg.StallRemediationURL) > 0 {
+		remediation = health.NewWebhook(cfg.StallRemediationURL)
+	}
+
+	return health.NewStallDetector(cfg.StallThreshold, alert, remediation, sink)
+}
+
+// newSoakMonitor constructs a resources.SoakMonitor that
+// samples the Go runtime, the backlog of r (if it is a
+// StatefulReconciler), and the head of blockStorage.
+func newSoakMonitor(
+	ctx context.Context,
+	blockStorage *storage.BlockStorage,
+	r reconciler.Reconciler,
+) *resources.SoakMonitor {
+	return resources.NewSoakMonitor(func() resources.StabilitySample {
+		usage := resources.RuntimeSampler()
+		sample := resources.StabilitySample{
+			Goroutines:      int64(usage.Goroutines),
+			HeapBytes:       int64(usage.Memory),
+			FileDescriptors: resources.OpenFileDescriptors(),
+			HeadIndex:       -1,
+		}
+
+		if stateful, ok := r.(*reconciler.StatefulReconciler); ok {
+			sample.QueueDepth = int64(stateful.Backlog())
+		}
+
+		transaction := blockStorage.NewDatabaseTransaction(ctx, false)
+		defer transaction.Discard(ctx)
+
+		head, err := blockStorage.GetHeadBlockIdentifier(ctx, transaction)
+		if err == nil {
+			sample.HeadIndex = head.Index
+		}
+
+		return sample
+	})
+}
+
+// writeStabilityReport writes report to path as JSON.
+func writeStabilityReport(path string, report *resources.StabilityReport) error {
+	return writeJSON(path, report)
+}
+
+// writeJSON writes v to path as indented JSON.
+func writeJSON(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", " ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, b, os.FileMode(0600))
+}
+
+// newMetricsSink constructs the metrics.Sink selected
+// in config. If metrics are served by the validator,
+// serve is non-nil and must be called to serve them.
+func newMetricsSink(
+	cfg config,
+) (sink metrics.Sink, serve func(context.Context) error, err error) {
+	switch cfg.MetricsSink {
+	case "none":
+		return &metrics.NoOpSink{}, nil, nil
+	case "prometheus":
+		prometheusSink := metrics.NewPrometheusSink(cfg.MetricsPrefix)
+		return prometheusSink, func(ctx context.Context) error {
+			return prometheusSink.Serve(ctx, cfg.MetricsAddr)
+		}, nil
+	case "statsd":
+		statsdSink, err := metrics.NewStatsDSink(cfg.MetricsAddr, cfg.MetricsPrefix)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return statsdSink, nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown METRICS_SINK %s", cfg.MetricsSink)
+	}
+}
+
+// logConfig logs every setting of cfg by the
+// name of its environment variable so that the
+// effective defaults are visible.
+func logConfig(cfg config) {
+	value := reflect.ValueOf(cfg)
+	for i := 0; i < value.NumField(); i++ {
+		name := strings.Split(value.Type().Field(i).Tag.Get("env"), ",")[0]
+		if len(name) == 0 {
+			continue
+		}
+
+		setting := fmt.Sprintf("%v", value.Field(i).Interface())
+		if redactedConfig[name] && len(setting) > 0 {
+			setting = "<redacted>"
+		}
+
+		log.Printf("%s=%s\n", name, setting)
+	}
+}
+
+// servePprof serves net/http/pprof profiles on
+// addr until the context is canceled.
+func servePprof(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		_ = server.Shutdown(context.Background())
+	}()
+
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Sync and reconcile the blocks and balances of a Rosetta Server",
+	Long: `Check syncs every block from the Rosetta Server at SERVER_ADDR
+into DATA_DIR, validating each one and computing the balance
+changes of its operations, and reconciles the computed balances
+against the live balances returned by the Rosetta Server.
+
+It is configured using environment variables (see README.md).`,
+	Args: cobra.NoArgs,
+	Run:  runCheck,
+}
+
+var (
+	checkResume  bool
+	checkReset   bool
+	checkProfile string
+)
+
+func init() {
+	checkCmd.Flags().BoolVar(
+		&checkResume,
+		"resume",
+		false,
+		"continue from the head block in DATA_DIR (fails if there is none)",
+	)
+	checkCmd.Flags().BoolVar(
+		&checkReset,
+		"reset",
+		false,
+		"wipe DATA_DIR and sync from genesis",
+	)
+	checkCmd.Flags().StringVar(
+		&checkProfile,
+		"profile",
+		"",
+		fmt.Sprintf(
+			"preset settings for a chain (%s), overridden by environment variables",
+			strings.Join(profileNames(), ", "),
+		),
+	)
+}
+
+// resetDataDir removes everything in the locked
+// DATA_DIR except its lock file. It refuses to remove
+// anything unless DATA_DIR contains a Database populated
+// by a validator, so that a mistyped DATA_DIR (ex: a home
+// directory) is never wiped.
+func resetDataDir(ctx context.Context, dir string, lock *storage.DirLock) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if path != lock.Path() {
+			paths = append(paths, path)
+		}
+	}
+
+	if len(paths) == 0 {
+		return nil
+	}
+
+	isDataDir, err := hasValidatorData(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	if !isDataDir {
+		return fmt.Errorf("%w: %s", errNotDataDir, dir)
+	}
+
+	for _, path := range paths {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hasValidatorData returns a boolean indicating if dir
+// contains a Database populated by a validator. A
+// directory without a Badger manifest is never opened
+// because opening it would create a Database in it.
+func hasValidatorData(ctx context.Context, dir string) (bool, error) {
+	if _, err := os.Stat(filepath.Join(dir, badgerManifest)); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	database, err := storage.NewBadgerStorage(ctx, dir)
+	if err != nil {
+		return false, err
+	}
+	defer database.Close(ctx)
+
+	return storage.HasValidatorData(ctx, database)
+}
+
+func runCheck(cmd *cobra.Command, args []string) {
+	ctx := context.Background()
+	log.Printf("Starting %s\n", build)
+
+	if len(checkProfile) > 0 {
+		if err := applyProfile(checkProfile); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Using profile %s\n", checkProfile)
+	}
+
+	cfg := config{}
+	if err := env.Parse(&cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	cfg.Resume = checkResume
+	cfg.Reset = checkReset
+	if cfg.Resume && cfg.Reset {
+		log.Fatal("--resume and --reset cannot both be set")
+	}
+
+	if cfg.Resume && cfg.InMemory {
+		log.Fatal("--resume is not supported with IN_MEMORY")
+	}
+
+	if cfg.RequestBurst < 1 {
+		log.Fatal("REQUEST_BURST must be at least 1")
+	}
+
+	if cfg.BlockBurst < 1 {
+		log.Fatal("BLOCK_BURST must be at least 1")
+	}
+
+	switch cfg.Mode {
+	case modeFull, modeDataOnly:
+	case modeSpotCheck:
+		runSpotCheck(ctx, cfg)
+		return
+	default:
+		log.Fatalf("MODE must be %q, %q, or %q", modeFull, modeDataOnly, modeSpotCheck)
+	}
+
+	if len(cfg.DataDir) == 0 && !cfg.InMemory {
+		cfg.DataDir = defaultDataDir
+	}
+
+	if cfg.InMemory && cfg.MaxDiskUsageMB > 0 {
+		log.Fatal("MAX_DISK_USAGE_MB is not supported with IN_MEMORY")
+	}
+
+	// Reports (ex: the range summary) are written to
+	// DATA_DIR unless it is a temporary directory, which is
+	// removed on exit, in which case they are written to the
+	// working directory.
+	reportDir := cfg.DataDir
+	var tempDir string
+	if len(cfg.DataDir) == 0 {
+		dir, err := ioutil.TempDir("", "rosetta-validator")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		tempDir = dir
+		cfg.DataDir = dir
+		reportDir = "."
+		log.Printf("Writing logs to %s\n", dir)
+	}
+
+	if err := os.MkdirAll(cfg.DataDir, os.FileMode(0700)); err != nil {
+		log.Fatal(err)
+	}
+
+	logConfig(cfg)
+
+	// Validators sharing DATA_DIR would corrupt each
+	// other's state.
+	lock, err := storage.LockDir(cfg.DataDir)
+	if err != nil {
+		log.Fatalf("%v: is another validator using DATA_DIR?", err)
+	}
+
+	if cfg.Reset {
+		if err := resetDataDir(ctx, cfg.DataDir, lock); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Reset %s\n", cfg.DataDir)
+	}
+
+	throttleSchedule, err := transport.ParseThrottleSchedule(cfg.ThrottleSchedule)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(throttleSchedule) > 0 && cfg.MaxRequestsPerSecond <= 0 {
+		log.Fatal("THROTTLE_SCHEDULE requires MAX_REQUESTS_PER_SECOND")
+	}
+
+	var limiter *scheduler.Limiter
+	var adminHandler *concurrencyHandler
+	if len(cfg.AdminAddr) > 0 {
+		limiter, adminHandler = newConcurrencyLimiter(cfg)
+	}
+
+	pool := newWorkerPool(cfg)
+	httpClient, transports, err := newHTTPClient(cfg, pool, limiter)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fetcher := fetcher.New(
+		ctx,
+		cfg.ServerAddr,
+		cfg.UserAgent,
+		httpClient,
+		cfg.BlockConcurrency,
+		cfg.TransactionConcurrency,
+	)
+
+	networkResponse, err := fetcher.InitializeAsserter(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var lookupFetcher reconciler.Fetcher = fetcher
+	if len(cfg.LookupServerAddr) > 0 {
+		lookupFetcher, err = newLookupFetcher(ctx, cfg, networkResponse, limiter)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Fetching balances from %s\n", cfg.LookupServerAddr)
+	}
+
+	sink, serveMetrics, err := newMetricsSink(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var database storage.Database
+	if cfg.InMemory {
+		database = storage.NewMemoryStorage()
+	} else {
+		database, err = storage.NewBadgerStorage(ctx, cfg.DataDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	localStore := storage.NewMeteredStorage(database, sink)
+
+	codec, err := storage.NewCodec(cfg.StorageCodec)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	keyHasher, err := storage.NewKeyHasher(cfg.KeyHash)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	g.Go(func() error {
+		select {
+		case <-ctx.Done():
+			return nil
+		case sig := <-signals:
+			log.Printf("Received %s, shutting down\n", sig)
+			return errShutdown
+		}
+	})
+
+	if serveMetrics != nil {
+		g.Go(func() error {
+			return serveMetrics(ctx)
+		})
+	}
+
+	if len(cfg.PprofAddr) > 0 {
+		g.Go(func() error {
+			return servePprof(ctx, cfg.PprofAddr)
+		})
+	}
+
+	if adminHandler != nil {
+		g.Go(func() error {
+			return serveAdmin(ctx, cfg.AdminAddr, adminHandler, &rateLimitHandler{
+				blocks: transports.blocks,
+			})
+		})
+	}
+
+	if len(throttleSchedule) > 0 {
+		g.Go(func() error {
+			return throttleSchedule.Run(ctx, transports.requests, cfg.MaxRequestsPerSecond, time.Minute)
+		})
+	}
+
+	// The fetcher retries failed requests, so a
+	// mismatch is not guaranteed to be returned by
+	// it and is instead observed here.
+	if transports.doubleFetch != nil {
+		g.Go(func() error {
+			select {
+			case <-ctx.Done():
+				return nil
+			case err := <-transports.doubleFetch.Mismatches():
+				return err
+			}
+		})
+	}
+
+	switch {
+	case cfg.Mode == modeDataOnly:
+		log.Printf("Data-only mode enabled, balances are not computed\n")
+	case reconciler.ShouldReconcile(networkResponse):
+		log.Printf("Balance reconciliation enabled\n")
+	}
+
+	var blockFetcher checkpoint.Fetcher = fetcher
+	if cfg.AllowOmittedBlocks {
+		log.Printf("Allowing omitted blocks\n")
+	}
+
+	switch {
+	case cfg.ContinueOnError:
+		log.Printf("Continuing after violations\n")
+		violationFetcher := syncer.NewViolationFetcher(
+			fetcher,
+			fetcher.Asserter,
+			cfg.BlockConcurrency,
+			cfg.AllowOmittedBlocks,
+		)
+		violationFetcher.SetRetries(cfg.MaxRetryElapsedTime, cfg.MaxRetries)
+		blockFetcher = violationFetcher
+	case cfg.AllowOmittedBlocks:
+		omittedFetcher := syncer.NewOmittedBlockFetcher(
+			fetcher,
+			fetcher.Asserter,
+			cfg.BlockConcurrency,
+		)
+		omittedFetcher.SetRetries(cfg.MaxRetryElapsedTime, cfg.MaxRetries)
+		blockFetcher = omittedFetcher
+	}
+
+	// The skip list and checkpoints only apply
+	// to the network (not its sub-networks).
+	skipList, err := utils.ParseSkipList(cfg.SkipBlocks)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	primaryFetcher := blockFetcher
+	if skipList.Len() > 0 {
+		log.Printf("Skipping errors in %d listed blocks\n", skipList.Len())
+		skipListFetcher := syncer.NewSkipListFetcher(blockFetcher, fetcher.Asserter, skipList)
+		skipListFetcher.SetRetries(cfg.MaxRetryElapsedTime, cfg.MaxRetries)
+		primaryFetcher = skipListFetcher
+	}
+
+	var syncFetcher checkpoint.Fetcher = primaryFetcher
+	var trusted *rosetta.BlockIdentifier
+	if len(cfg.CheckpointsFile) > 0 {
+		checkpoints, err := checkpoint.Load(cfg.CheckpointsFile, cfg.CheckpointsPublicKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		trusted = checkpoints.Last()
+		if trusted != nil {
+			log.Printf("Trusting blocks up to checkpoint %+v\n", trusted)
+		}
+		trustedFetcher := checkpoint.NewTrustedFetcher(primaryFetcher, checkpoints, cfg.BlockConcurrency)
+		trustedFetcher.SetRetries(cfg.MaxRetryElapsedTime, cfg.MaxRetries)
+		syncFetcher = trustedFetcher
+	}
+
+	var snapshot *storage.BalanceSnapshot
+	if len(cfg.SnapshotFile) > 0 {
+		if cfg.Mode == modeDataOnly {
+			log.Fatal(errors.New("SNAPSHOT_FILE cannot be used in data-only mode"))
+		}
+
+		snapshot, err = storage.LoadBalanceSnapshot(cfg.SnapshotFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	validators := []*networkValidator{}
+	for _, network := range networkIdentifiers(networkResponse) {
+		v := &networkValidator{
+			network:     network,
+			syncFetcher: blockFetcher,
+		}
+		if network.SubNetworkIdentifier == nil {
+			v.syncFetcher = syncFetcher
+			v.trusted = trusted
+			v.snapshot = snapshot
+		} else {
+			log.Printf("Validating sub-network %s\n", network.SubNetworkIdentifier.SubNetwork)
+		}
+
+		if err := v.initialize(
+			ctx,
+			cfg,
+			networkResponse,
+			localStore,
+			codec,
+			keyHasher,
+			fetcher,
+			lookupFetcher,
+			sink,
+		); err != nil {
+			log.Fatal(err)
+		}
+
+		validators = append(validators, v)
+	}
+
+	// START_INDEX and END_INDEX only apply to the
+	// network (not its sub-networks).
+	primary := validators[0]
+	genesis := networkResponse.NetworkStatus.NetworkInformation.GenesisBlockIdentifier
+	if cfg.StartIndex > genesis.Index+1 {
+		log.Printf("Balances are not computed when starting at block %d\n", cfg.StartIndex)
+		primary.handler.SetDataOnly(true)
+	}
+	primary.handler.SetSkipList(skipList)
+	primary.syncer.SetSkipList(skipList)
+	primary.syncer.SetExpectedGenesisHash(cfg.GenesisHash)
+	primary.syncer.SetStartIndex(cfg.StartIndex)
+	primary.syncer.SetEndIndex(cfg.EndIndex)
+
+	if cfg.StallThreshold > 0 {
+		detector := newStallDetector(cfg, sink)
+		detector.SetExitOnStall(cfg.ExitOnStall)
+		primary.syncer.SetTipObserver(detector)
+		g.Go(func() error {
+			return detector.Run(ctx, stallCheckInterval)
+		})
+	}
+
+	if cfg.ChainHaltThreshold > 0 {
+		var alert *health.Webhook
+		if len(cfg.AlertWebhookURL) > 0 {
+			alert = health.NewWebhook(cfg.AlertWebhookURL)
+		}
+
+		detector := health.NewHaltDetector(cfg.ChainHaltThreshold, alert, sink)
+		primary.syncer.SetBlockGapObserver(detector)
+		g.Go(func() error {
+			return detector.Run(ctx)
+		})
+	}
+
+	var regressionAlerter *health.RegressionAlerter
+	if len(cfg.AlertWebhookURL) > 0 {
+		regressionAlerter = health.NewRegressionAlerter(health.NewWebhook(cfg.AlertWebhookURL))
+		g.Go(func() error {
+			return regressionAlerter.Run(ctx)
+		})
+	}
+
+	for _, v := range validators {
+		v.syncer.SetPauseOnTipRegression(cfg.PauseOnHeadRegression)
+		if regressionAlerter != nil {
+			v.syncer.SetTipRegressionObserver(regressionAlerter)
+		}
+	}
+
+	if transports.replica != nil {
+		transports.replica.SetMismatchHandler(func(mismatch *transport.ReplicaMismatch) {
+			if err := primary.blockStorage.StoreFinding(ctx, replicaMismatchFinding(mismatch)); err != nil {
+				log.Printf("Unable to store finding %v\n", err)
+			}
+		})
+	}
+
+	if cfg.MempoolCheckInterval > 0 {
+		log.Printf("Mempool monitoring enabled\n")
+		monitor := mempool.NewMonitor(
+			primary.network,
+			fetcher,
+			fetcher.Asserter,
+			primary.blockStorage,
+			sink,
+			cfg.MempoolStuckThreshold,
+		)
+		g.Go(func() error {
+			return monitor.Run(ctx, cfg.MempoolCheckInterval)
+		})
+	}
+
+	endConditions := endcondition.Conditions{
+		Duration:               cfg.EndDuration,
+		ReconciledAccounts:     cfg.EndReconciledAccounts,
+		ReconciliationCoverage: cfg.EndReconciliationCoverage,
+		Healthy:                cfg.UntilHealthy,
+	}
+	if endConditions.Enabled() {
+		var progress endcondition.Progress
+		if primary.stateful != nil {
+			progress = primary.stateful
+		}
+
+		monitor, err := endcondition.NewMonitor(endConditions, progress, networksHealth(validators))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		g.Go(func() error {
+			return monitor.Run(ctx, cfg.EndConditionInterval)
+		})
+	}
+
+	if cfg.BlockEvents {
+		eventsClient, err := newEventsClient(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for _, v := range validators {
+			stream := events.NewStream(
+				cfg.ServerAddr,
+				eventsClient,
+				v.network,
+				cfg.TipPollInterval,
+				blockEventsRetryDelay,
+			)
+			v.syncer.SetBlockEvents(stream)
+			g.Go(func() error {
+				return stream.Run(ctx)
+			})
+		}
+	}
+
+	// In one-shot mode, the validator stops once
+	// every network has finished syncing.
+	syncing := int32(len(validators))
+	syncers := []*syncer.Syncer{}
+	blockStorages := []*storage.BlockStorage{}
+	for _, v := range validators {
+		v := v
+		v.syncer.SetExitAtTip(cfg.OneShot)
+		g.Go(func() error {
+			return v.reconciler.Reconcile(ctx)
+		})
+
+		// Garbage collection is stopped (and waited for)
+		// before DATA_DIR is closed.
+		if cfg.ReorgCompactionDepth > 0 {
+			g.Go(func() error {
+				return v.handler.RunCompaction(ctx)
+			})
+		}
+
+		g.Go(func() error {
+			err := v.sync(ctx)
+			if cfg.OneShot && syncCompleted(err) && atomic.AddInt32(&syncing, -1) > 0 {
+				return nil
+			}
+
+			return err
+		})
+
+		syncers = append(syncers, v.syncer)
+		blockStorages = append(blockStorages, v.blockStorage)
+	}
+
+	if resourceLimitsEnabled(cfg) {
+		monitor := newResourceMonitor(cfg, sink, syncers, pool)
+		g.Go(func() error {
+			return monitor.Run(ctx, cfg.ResourceCheckInterval)
+		})
+	}
+
+	if cfg.MaxDiskUsageMB > 0 {
+		monitor, err := newDiskMonitor(cfg, sink, blockStorages)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		g.Go(func() error {
+			return monitor.Run(ctx, cfg.DiskCheckInterval)
+		})
+	}
+
+	var soak *resources.SoakMonitor
+	if cfg.SoakTestDuration > 0 {
+		log.Printf("Running soak test for %s\n", cfg.SoakTestDuration)
+		soak = newSoakMonitor(ctx, primary.blockStorage, primary.reconciler)
+		g.Go(func() error {
+			return soak.Run(ctx, cfg.SoakTestInterval, cfg.SoakTestDuration)
+		})
+	}
+
+	err = g.Wait()
+	signal.Stop(signals)
+	if errors.Is(err, errShutdown) {
+		for _, v := range validators {
+			if err := v.drain(cfg.ShutdownTimeout); err != nil {
+				log.Printf("Unable to reconcile queued accounts: %v\n", err)
+			}
+		}
+	}
+
+	if errors.Is(err, endcondition.ErrReached) {
+		log.Printf("Stopping: %v\n", err)
+	}
+
+	// Once END_INDEX is reached, the range is complete
+	// only once every queued account is reconciled.
+	endIndexReached := errors.Is(err, syncer.ErrEndIndexReached)
+	if cfg.OneShot || endIndexReached || errors.Is(err, endcondition.ErrReached) {
+		// Once synced, every queued account is reconciled.
+		for _, v := range validators {
+			if !syncCompleted(err) {
+				break
+			}
+
+			if drainErr := v.drain(0); drainErr != nil {
+				err = drainErr
+			}
+		}
+
+		for _, v := range validators {
+			if err := v.summarize(context.Background()); err != nil {
+				log.Printf("Unable to summarize %s: %v\n", v.name(), err)
+			}
+		}
+	}
+
+	// No more requests are made to the Rosetta Server.
+	if err := transports.Close(); err != nil {
+		log.Printf("Unable to close RECORD_FILE: %v\n", err)
+	}
+
+	// The reconciliations performed since the last
+	// block stats summary are recorded before exiting.
+	for _, v := range validators {
+		if _, err := v.RecordLifetimeStats(context.Background()); err != nil {
+			log.Printf("Unable to record lifetime stats of %s: %v\n", v.name(), err)
+		}
+	}
+
+	// Chunked validation jobs collect the summary of each
+	// range once it has been synced and reconciled.
+	if endIndexReached && syncCompleted(err) {
+		path := filepath.Join(reportDir, rangeSummaryFile)
+		if err := writeJSON(path, primary.rangeSummary()); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Range summary written to %s\n", path)
+	}
+
+	// A run that recorded violations failed even
+	// though it completed.
+	if cfg.ContinueOnError && completed(err) {
+		for _, v := range validators {
+			violations, violationsErr := v.newViolations(context.Background())
+			if violationsErr != nil {
+				log.Printf("Unable to count violations of %s: %v\n", v.name(), violationsErr)
+				continue
+			}
+
+			if violations > 0 {
+				err = fmt.Errorf("%w: %d in %s", errViolationsRecorded, violations, v.name())
+				break
+			}
+		}
+	}
+
+	// The summary is written even if validation
+	// failed because it records the failure.
+	if cfg.UntilHealthy {
+		summary, err := newHealthSummary(context.Background(), validators, err)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		path := filepath.Join(reportDir, healthSummaryFile)
+		if err := writeJSON(path, summary); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Health summary written to %s\n", path)
+	}
+
+	if err := database.Close(context.Background()); err != nil {
+		log.Printf("Unable to close DATA_DIR: %v\n", err)
+	}
+
+	if err := lock.Unlock(); err != nil {
+		log.Printf("Unable to unlock DATA_DIR: %v\n", err)
+	}
+
+	if soak != nil {
+		// The report is written even if the validator stopped
+		// early because it may explain why.
+		path := filepath.Join(reportDir, soakTestReportFile)
+		if err := writeStabilityReport(path, soak.Report()); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Soak test stability report written to %s\n", path)
+	}
+
+	if len(tempDir) > 0 {
+		if err := os.RemoveAll(tempDir); err != nil {
+			log.Printf("Unable to remove %s: %v\n", tempDir, err)
+		}
+	}
+
+	if code := exitCode(err); code != 0 {
+		log.Printf("Validation failed: %v\n", err)
+		os.Exit(code)
+	}
+}
+
+// syncCompleted returns true if err indicates that
+// a syncer stopped because it finished syncing (or
+// an end condition was met).
+func syncCompleted(err error) bool {
+	return errors.Is(err, syncer.ErrEndIndexReached) ||
+		errors.Is(err, syncer.ErrTipReached) ||
+		errors.Is(err, endcondition.ErrReached)
+}
+
+// completed returns true if err indicates that the validator
+// stopped because it finished (ex: END_INDEX was reached)
+// or was interrupted.
+func completed(err error) bool {
+	return errors.Is(err, resources.ErrSoakTestComplete) ||
+		syncCompleted(err) ||
+		errors.Is(err, errShutdown)
+}
+
+// assertionFailed returns true if err is a failed
+// correctness check of the blocks returned by the
+// Rosetta Server.
+func assertionFailed(err error) bool {
+	var amountErr *storage.AmountError
+	var hookErr *processor.BlockHookError
+	return errors.As(err, &amountErr) ||
+		errors.As(err, &hookErr) ||
+		errors.Is(err, storage.ErrNegativeBalance) ||
+		errors.Is(err, storage.ErrDuplicateBlockHash) ||
+		errors.Is(err, storage.ErrDuplicateTransactionHash) ||
+		errors.Is(err, syncer.ErrMaxReorgDepthExceeded) ||
+		errors.Is(err, utils.ErrTimestampUnitMismatch) ||
+		errors.Is(err, syncer.ErrZeroTimestamp) ||
+		errors.Is(err, syncer.ErrTimestampBeforeParent) ||
+		errors.Is(err, syncer.ErrGenesisMismatch) ||
+		errors.Is(err, syncer.ErrParentMismatch) ||
+		errors.Is(err, syncer.ErrDuplicateHash) ||
+		errors.Is(err, checkpoint.ErrCheckpointMismatch) ||
+		errors.Is(err, errViolationsRecorded) ||
+		errors.Is(err, transport.ErrNondeterministicResponse)
+}
+
+// exitCode returns the code the validator exits with after
+// stopping because of err: 0 if it completed, or a code
+// indicating whether reconciliation, an assertion, or
+// syncing failed, or the node stalled.
+func exitCode(err error) int {
+	switch {
+	case err == nil || completed(err):
+		return 0
+	case errors.Is(err, reconciler.ErrBalanceMismatch):
+		return exitReconciliationFailure
+	case assertionFailed(err):
+		return exitAssertionFailure
+	case errors.Is(err, health.ErrNodeStalled):
+		return exitNodeStalled
+	default:
+		return exitSyncFailure
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coinbase/rosetta-validator/internal/health"
+	"github.com/coinbase/rosetta-validator/internal/processor"
+	"github.com/coinbase/rosetta-validator/internal/reconciler"
+	"github.com/coinbase/rosetta-validator/internal/storage"
+	"github.com/coinbase/rosetta-validator/internal/syncer"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResetDataDir(t *testing.T) {
+	ctx := context.Background()
+
+	// lockedDir returns a new locked directory
+	// and the function to remove it.
+	lockedDir := func(t *testing.T) (string, *storage.DirLock, func()) {
+		dir, err := storage.CreateTempDir()
+		assert.NoError(t, err)
+
+		lock, err := storage.LockDir(*dir)
+		assert.NoError(t, err)
+
+		return *dir, lock, func() {
+			assert.NoError(t, lock.Unlock())
+			storage.RemoveTempDir(*dir)
+		}
+	}
+
+	// entries returns the names of the entries of dir.
+	entries := func(t *testing.T, dir string) []string {
+		infos, err := ioutil.ReadDir(dir)
+		assert.NoError(t, err)
+
+		names := []string{}
+		for _, info := range infos {
+			names = append(names, info.Name())
+		}
+
+		return names
+	}
+
+	t.Run("Empty directory", func(t *testing.T) {
+		dir, lock, cleanup := lockedDir(t)
+		defer cleanup()
+
+		assert.NoError(t, resetDataDir(ctx, dir, lock))
+		assert.Equal(t, []string{filepath.Base(lock.Path())}, entries(t, dir))
+	})
+
+	t.Run("Not a data directory", func(t *testing.T) {
+		dir, lock, cleanup := lockedDir(t)
+		defer cleanup()
+
+		notes := filepath.Join(dir, "notes.txt")
+		assert.NoError(t, ioutil.WriteFile(notes, []byte("notes"), 0600))
+
+		err := resetDataDir(ctx, dir, lock)
+		assert.True(t, errors.Is(err, errNotDataDir))
+
+		_, err = os.Stat(notes)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Database without validator data", func(t *testing.T) {
+		dir, lock, cleanup := lockedDir(t)
+		defer cleanup()
+
+		database, err := storage.NewBadgerStorage(ctx, dir)
+		assert.NoError(t, err)
+		assert.NoError(t, database.Set(ctx, []byte("key"), []byte("value")))
+		assert.NoError(t, database.Close(ctx))
+
+		before := entries(t, dir)
+		err = resetDataDir(ctx, dir, lock)
+		assert.True(t, errors.Is(err, errNotDataDir))
+		assert.Equal(t, before, entries(t, dir))
+	})
+
+	t.Run("Data directory", func(t *testing.T) {
+		dir, lock, cleanup := lockedDir(t)
+		defer cleanup()
+
+		database, err := storage.NewBadgerStorage(ctx, dir)
+		assert.NoError(t, err)
+		blockStorage := storage.NewBlockStorage(ctx, database, &storage.GobCodec{}, &storage.SHA256KeyHasher{})
+		assert.NoError(t, blockStorage.InitializeNetwork(ctx, &rosetta.NetworkIdentifier{
+			Blockchain: "bitcoin",
+			Network:    "mainnet",
+		}))
+		assert.NoError(t, database.Close(ctx))
+
+		logs := filepath.Join(dir, "logs")
+		assert.NoError(t, os.Mkdir(logs, 0700))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(logs, "blocks.txt"), []byte("block"), 0600))
+
+		assert.NoError(t, resetDataDir(ctx, dir, lock))
+		assert.Equal(t, []string{filepath.Base(lock.Path())}, entries(t, dir))
+	})
+}
+
+func TestExitCode(t *testing.T) {
+	block := &rosetta.BlockIdentifier{
+		Hash:  "block 1",
+		Index: 1,
+	}
+
+	var tests = map[string]struct {
+		err      error
+		expected int
+	}{
+		"no error": {
+			expected: 0,
+		},
+		"tip reached": {
+			err:      syncer.ErrTipReached,
+			expected: 0,
+		},
+		"end index reached": {
+			err:      fmt.Errorf("%w: 10", syncer.ErrEndIndexReached),
+			expected: 0,
+		},
+		"shutdown requested": {
+			err:      errShutdown,
+			expected: 0,
+		},
+		"reconciliation failure": {
+			err:      fmt.Errorf("%w: account addr1", reconciler.ErrBalanceMismatch),
+			expected: exitReconciliationFailure,
+		},
+		"negative balance": {
+			err: &storage.AmountError{
+				Account: &rosetta.AccountIdentifier{
+					Address: "addr1",
+				},
+				Currency: &rosetta.Currency{
+					Symbol:   "BTC",
+					Decimals: 8,
+				},
+				Block: block,
+				Value: "-1",
+				Err:   storage.ErrNegativeBalance,
+			},
+			expected: exitAssertionFailure,
+		},
+		"duplicate block hash": {
+			err:      fmt.Errorf("%w: block 1", storage.ErrDuplicateBlockHash),
+			expected: exitAssertionFailure,
+		},
+		"block hook failure": {
+			err: &processor.BlockHookError{
+				Block: block,
+				Err:   errors.New("hook failed"),
+			},
+			expected: exitAssertionFailure,
+		},
+		"violations recorded": {
+			err:      errViolationsRecorded,
+			expected: exitAssertionFailure,
+		},
+		"node stalled": {
+			err:      health.ErrNodeStalled,
+			expected: exitNodeStalled,
+		},
+		"cancellation": {
+			err:      context.Canceled,
+			expected: exitSyncFailure,
+		},
+		"other": {
+			err:      errors.New("server unavailable"),
+			expected: exitSyncFailure,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, exitCode(test.err))
+		})
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/coinbase/rosetta-validator/internal/generator"
+	"github.com/coinbase/rosetta-validator/internal/logger"
+	"github.com/coinbase/rosetta-validator/internal/metrics"
+	"github.com/coinbase/rosetta-validator/internal/processor"
+	"github.com/coinbase/rosetta-validator/internal/reconciler"
+	"github.com/coinbase/rosetta-validator/internal/storage"
+	"github.com/coinbase/rosetta-validator/internal/syncer"
+
+	"github.com/coinbase/rosetta-sdk-go/asserter"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/caarlos0/env"
+	"github.com/spf13/cobra"
+)
+
+var loadTestCmd = &cobra.Command{
+	Use:   "load-test",
+	Short: "Sync a synthetic chain and report the throughput",
+	Long: `Load-test syncs a synthetic chain of LOAD_TEST_HEIGHT blocks into
+a temporary directory in DATA_DIR (instead of validating a Rosetta
+Server), reports its throughput, and exits.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg := loadTestConfig{}
+		if err := env.Parse(&cfg); err != nil {
+			return err
+		}
+
+		return runLoadTest(context.Background(), cfg)
+	},
+}
+
+// loadTestConfig is parsed separately from config so that
+// a load test can be run without a Rosetta Server.
+type loadTestConfig struct {
+	DataDir      string `env:"DATA_DIR"`
+	StorageCodec string `env:"STORAGE_CODEC" envDefault:"gob"`
+	KeyHash      string `env:"KEY_HASH" envDefault:"sha256"`
+
+	LoadTestHeight                   int64   `env:"LOAD_TEST_HEIGHT" envDefault:"10000"`
+	LoadTestTransactionsPerBlock     int     `env:"LOAD_TEST_TRANSACTIONS_PER_BLOCK" envDefault:"10"`
+	LoadTestOperationsPerTransaction int     `env:"LOAD_TEST_OPERATIONS_PER_TRANSACTION" envDefault:"2"`
+	LoadTestAccounts                 int     `env:"LOAD_TEST_ACCOUNTS" envDefault:"1000"`
+	LoadTestReorgRate                float64 `env:"LOAD_TEST_REORG_RATE" envDefault:"0.01"`
+	LoadTestReorgDepth               int64   `env:"LOAD_TEST_REORG_DEPTH" envDefault:"1"`
+	LoadTestSeed                     int64   `env:"LOAD_TEST_SEED" envDefault:"0"`
+}
+
+// loadTestHandler wraps a syncer.Handler to track
+// the synced head and count processed blocks.
+type loadTestHandler struct {
+	syncer.Handler
+
+	head     int64
+	added    int64
+	orphaned int64
+}
+
+func (h *loadTestHandler) BlockAdded(ctx context.Context, block *rosetta.Block) error {
+	if err := h.Handler.BlockAdded(ctx, block); err != nil {
+		return err
+	}
+
+	h.head = block.BlockIdentifier.Index
+	h.added++
+	return nil
+}
+
+func (h *loadTestHandler) BlockRemoved(
+	ctx context.Context,
+	block *rosetta.BlockIdentifier,
+) error {
+	if err := h.Handler.BlockRemoved(ctx, block); err != nil {
+		return err
+	}
+
+	h.head = block.Index - 1
+	h.orphaned++
+	return nil
+}
+
+// runLoadTest syncs a synthetic chain into a temporary
+// directory and reports the throughput of the syncer
+// and storage.
+func runLoadTest(ctx context.Context, cfg loadTestConfig) error {
+	if len(cfg.DataDir) == 0 {
+		return errors.New("DATA_DIR is required")
+	}
+
+	dir, err := ioutil.TempDir(cfg.DataDir, "load-test")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	codec, err := storage.NewCodec(cfg.StorageCodec)
+	if err != nil {
+		return err
+	}
+
+	keyHasher, err := storage.NewKeyHasher(cfg.KeyHash)
+	if err != nil {
+		return err
+	}
+
+	localStore, err := storage.NewBadgerStorage(ctx, dir)
+	if err != nil {
+		return err
+	}
+	defer localStore.Close(ctx)
+
+	g := generator.New(generator.Config{
+		Height:                   cfg.LoadTestHeight,
+		TransactionsPerBlock:     cfg.LoadTestTransactionsPerBlock,
+		OperationsPerTransaction: cfg.LoadTestOperationsPerTransaction,
+		Accounts:                 cfg.LoadTestAccounts,
+		ReorgRate:                cfg.LoadTestReorgRate,
+		ReorgDepth:               cfg.LoadTestReorgDepth,
+		Seed:                     cfg.LoadTestSeed,
+	})
+
+	logger := logger.NewLogger(dir, false, false, false, false)
+	handler := &loadTestHandler{
+		Handler: processor.NewSyncHandler(
+			ctx,
+			storage.NewBlockStorage(ctx, localStore, codec, keyHasher),
+			asserter.New(ctx, g.NetworkStatus()),
+			logger,
+			&reconciler.NoOpReconciler{},
+			nil,
+		),
+	}
+
+	s := syncer.New(
+		ctx,
+		nil,
+		g,
+		handler,
+		logger,
+		&metrics.NoOpSink{},
+		syncer.Timeouts{},
+		nil,
+		nil,
+	)
+
+	// Blocks are fetched in order so that the same
+	// LOAD_TEST_SEED generates the same reorgs.
+	s.SetBlockConcurrency(1)
+
+	start := time.Now()
+	for handler.head < cfg.LoadTestHeight {
+		if err := s.SyncCycle(ctx, false); err != nil {
+			return err
+		}
+	}
+	elapsed := time.Since(start)
+
+	operations := handler.added * int64(cfg.LoadTestTransactionsPerBlock*cfg.LoadTestOperationsPerTransaction)
+	log.Printf(
+		"Load test synced %d blocks (%d orphaned) in %s: %.2f blocks/s, %.2f operations/s\n",
+		handler.added,
+		handler.orphaned,
+		elapsed,
+		float64(handler.added)/elapsed.Seconds(),
+		float64(operations)/elapsed.Seconds(),
+	)
+
+	return nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// profiles are presets of settings for common chain shapes,
+// selected with the --profile flag of check. A setting in a
+// profile is only applied if its environment variable is
+// not set, so any of them can be overridden.
+var profiles = map[string]map[string]string{
+	// Large blocks with many transactions every ~10 minutes
+	// and rare reorgs of more than a few blocks.
+	"bitcoin": {
+		"BLOCK_CONCURRENCY":       "4",
+		"TRANSACTION_CONCURRENCY": "16",
+		"ACCOUNT_CONCURRENCY":     "16",
+		"REORG_COMPACTION_DEPTH":  "6",
+		"MAX_REORG_DEPTH":         "100",
+		"CONFIRMATION_DEPTH":      "0",
+		"TIMESTAMP_UNIT":          "ms",
+		"TIMESTAMP_TOLERANCE":     "2h",
+		"PRUNE_DEPTH":             "2000",
+	},
+
+	// Smaller blocks every ~12 seconds with frequent
+	// shallow reorgs.
+	"ethereum": {
+		"BLOCK_CONCURRENCY":       "16",
+		"TRANSACTION_CONCURRENCY": "8",
+		"ACCOUNT_CONCURRENCY":     "16",
+		"REORG_COMPACTION_DEPTH":  "32",
+		"MAX_REORG_DEPTH":         "64",
+		"CONFIRMATION_DEPTH":      "2",
+		"TIMESTAMP_UNIT":          "ms",
+		"TIMESTAMP_TOLERANCE":     "0s",
+		"PRUNE_DEPTH":             "10000",
+	},
+
+	// A local development network that is cheap to
+	// sync and is restarted from genesis often.
+	"devnet": {
+		"BLOCK_CONCURRENCY":       "2",
+		"TRANSACTION_CONCURRENCY": "2",
+		"ACCOUNT_CONCURRENCY":     "2",
+		"REORG_COMPACTION_DEPTH":  "0",
+		"MAX_REORG_DEPTH":         "0",
+		"CONFIRMATION_DEPTH":      "0",
+		"TIMESTAMP_UNIT":          "ms",
+		"TIMESTAMP_TOLERANCE":     "0s",
+		"PRUNE_DEPTH":             "0",
+	},
+}
+
+// profileNames returns the names of the profiles
+// in alphabetical order.
+func profileNames() []string {
+	names := []string{}
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// applyProfile sets the environment variables of the profile
+// name that are not already set.
+func applyProfile(name string) error {
+	settings, ok := profiles[name]
+	if !ok {
+		return fmt.Errorf(
+			"unknown profile %q (expected one of %s)",
+			name,
+			strings.Join(profileNames(), ", "),
+		)
+	}
+
+	for key, value := range settings {
+		if _, ok := os.LookupEnv(key); ok {
+			continue
+		}
+
+		if err := os.Setenv(key, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,469 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/coinbase/rosetta-validator/internal/resources"
+	"github.com/coinbase/rosetta-validator/internal/storage"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/spf13/cobra"
+)
+
+// defaultThroughputTolerance is the fraction by which the
+// throughput of a candidate run may fall below the base run
+// before compare reports a regression.
+const defaultThroughputTolerance = 0.1
+
+// errRegressions is returned by runCompare if the
+// candidate run regressed.
+var errRegressions = errors.New("candidate run regressed")
+
+var compareCmd = &cobra.Command{
+	Use:   "compare <base report> <candidate report>",
+	Short: "Compare the reports of two runs for regressions",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCompare(args, cmd.OutOrStdout())
+	},
+}
+
+var compareThroughputTolerance float64
+
+func init() {
+	compareCmd.Flags().Float64Var(
+		&compareThroughputTolerance,
+		"throughput-tolerance",
+		defaultThroughputTolerance,
+		"fraction by which throughput may fall before it is a regression",
+	)
+}
+
+// runReport is the output of view:report and
+// the input of compare.
+type runReport struct {
+	Index      int64                    `json:"index"`
+	Head       *rosetta.BlockIdentifier `json:"head"`
+	Findings   []*findingView           `json:"findings"`
+	Balances   []*balanceView           `json:"balances"`
+	Throughput *throughputView          `json:"throughput,omitempty"`
+}
+
+// findingView is a single finding in a runReport.
+type findingView struct {
+	Type       string                     `json:"type"`
+	Account    *rosetta.AccountIdentifier `json:"account"`
+	Currency   *rosetta.Currency          `json:"currency"`
+	Block      *rosetta.BlockIdentifier   `json:"block"`
+	Difference string                     `json:"difference"`
+	Labels     []string                   `json:"labels,omitempty"`
+}
+
+// throughputView is the sync throughput of a soak
+// test in a runReport.
+type throughputView struct {
+	Blocks          int64   `json:"blocks"`
+	Seconds         float64 `json:"seconds"`
+	BlocksPerSecond float64 `json:"blocks_per_second"`
+}
+
+// balanceDifference is a balance that differs
+// between two runs. A balance missing from a
+// run is empty.
+type balanceDifference struct {
+	Account   *rosetta.AccountIdentifier `json:"account"`
+	Currency  *rosetta.Currency          `json:"currency"`
+	Base      string                     `json:"base"`
+	Candidate string                     `json:"candidate"`
+}
+
+// throughputComparison compares the throughput of two runs.
+// Change is the fractional change from Base to Candidate.
+type throughputComparison struct {
+	Base      float64 `json:"base"`
+	Candidate float64 `json:"candidate"`
+	Change    float64 `json:"change"`
+}
+
+// comparisonView is the output of compare.
+type comparisonView struct {
+	Index              int64                 `json:"index"`
+	NewFindings        []*findingView        `json:"new_findings"`
+	ResolvedFindings   []*findingView        `json:"resolved_findings"`
+	BalanceDifferences []*balanceDifference  `json:"balance_differences"`
+	Throughput         *throughputComparison `json:"throughput,omitempty"`
+	Regressions        []string              `json:"regressions"`
+}
+
+// viewReport prints a runReport of the findings and
+// balances as of a block (or the most recently synced
+// block if --block is omitted) and, if DATA_DIR contains
+// a soak test report, the throughput of the soak test.
+func viewReport(ctx context.Context, cfg viewConfig, args []string, out io.Writer) error {
+	blockStorage, closeStorage, err := openBlockStorage(ctx, cfg, false)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+
+	txn := blockStorage.NewDatabaseTransaction(ctx, false)
+	defer txn.Discard(ctx)
+
+	head, err := blockStorage.GetHeadBlockIdentifier(ctx, txn)
+	if err != nil {
+		return err
+	}
+
+	index := viewReportBlock
+	if index < 0 || index > head.Index {
+		index = head.Index
+	}
+
+	report := &runReport{
+		Index:    index,
+		Head:     head,
+		Findings: []*findingView{},
+		Balances: []*balanceView{},
+	}
+
+	for cursor := int64(0); ; {
+		var findings []*storage.Finding
+		findings, cursor, err = blockStorage.Findings(ctx, cursor, viewReadLimit)
+		if err != nil {
+			return err
+		}
+
+		if len(findings) == 0 {
+			break
+		}
+
+		for _, finding := range findings {
+			if finding.Block != nil && finding.Block.Index > index {
+				continue
+			}
+
+			report.Findings = append(report.Findings, &findingView{
+				Type:       finding.Type,
+				Account:    finding.Account,
+				Currency:   finding.Currency,
+				Block:      finding.Block,
+				Difference: finding.Difference,
+				Labels:     finding.Labels,
+			})
+		}
+	}
+
+	report.Balances, err = reportBalances(ctx, blockStorage, txn, index)
+	if err != nil {
+		return err
+	}
+
+	report.Throughput, err = soakTestThroughput(cfg.DataDir)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// reportBalances returns the balances, as of the block at
+// index, of every account with a balance change at or
+// before it.
+func reportBalances(
+	ctx context.Context,
+	blockStorage *storage.BlockStorage,
+	txn storage.DatabaseTransaction,
+	index int64,
+) ([]*balanceView, error) {
+	accounts, err := blockStorage.BalanceAccounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	views := []*balanceView{}
+	for _, account := range accounts {
+		amounts, block, err := blockStorage.GetBalanceAt(ctx, txn, account, index)
+		if errors.Is(err, storage.ErrBalanceHistoryNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		view := &balanceView{
+			Account:  account,
+			Block:    block,
+			Balances: []*rosetta.Amount{},
+		}
+		for _, amount := range amounts {
+			view.Balances = append(view.Balances, amount)
+		}
+		sort.Slice(view.Balances, func(i, j int) bool {
+			return view.Balances[i].Currency.Symbol < view.Balances[j].Currency.Symbol
+		})
+
+		views = append(views, view)
+	}
+
+	sort.Slice(views, func(i, j int) bool {
+		return accountKey(views[i].Account) < accountKey(views[j].Account)
+	})
+
+	return views, nil
+}
+
+// soakTestThroughput returns the sync throughput recorded
+// in the soak test report in dataDir or nil if there is
+// no soak test report.
+func soakTestThroughput(dataDir string) (*throughputView, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dataDir, soakTestReportFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var report resources.StabilityReport
+	if err := json.Unmarshal(b, &report); err != nil {
+		return nil, fmt.Errorf("%w: unable to parse %s", err, soakTestReportFile)
+	}
+
+	seconds := report.End.Sub(report.Start).Seconds()
+	if seconds <= 0 {
+		return nil, nil
+	}
+
+	return &throughputView{
+		Blocks:          report.HeadIndex.Growth,
+		Seconds:         seconds,
+		BlocksPerSecond: float64(report.HeadIndex.Growth) / seconds,
+	}, nil
+}
+
+// accountKey returns a string that uniquely
+// identifies an account.
+func accountKey(account *rosetta.AccountIdentifier) string {
+	b, _ := json.Marshal(account)
+	return string(b)
+}
+
+// findingKey returns a string that identifies a finding
+// across runs. The difference is omitted so that a finding
+// whose difference changed is not reported as both new
+// and resolved.
+func findingKey(finding *findingView) string {
+	var index *int64
+	if finding.Block != nil {
+		index = &finding.Block.Index
+	}
+
+	b, _ := json.Marshal([]interface{}{
+		finding.Type,
+		finding.Account,
+		finding.Currency,
+		index,
+	})
+	return string(b)
+}
+
+// balanceKey returns a string that identifies the
+// balance of a currency of an account.
+func balanceKey(account *rosetta.AccountIdentifier, currency *rosetta.Currency) string {
+	b, _ := json.Marshal([]interface{}{account, currency})
+	return string(b)
+}
+
+// readRunReport reads a runReport written by view:report.
+func readRunReport(path string) (*runReport, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var report runReport
+	if err := json.Unmarshal(b, &report); err != nil {
+		return nil, fmt.Errorf("%w: unable to parse %s", err, path)
+	}
+
+	return &report, nil
+}
+
+// compareFindings returns the findings of candidate
+// that are not in base and those of base that are not
+// in candidate.
+func compareFindings(base, candidate []*findingView) ([]*findingView, []*findingView) {
+	baseKeys := map[string]bool{}
+	for _, finding := range base {
+		baseKeys[findingKey(finding)] = true
+	}
+
+	candidateKeys := map[string]bool{}
+	added := []*findingView{}
+	for _, finding := range candidate {
+		key := findingKey(finding)
+		candidateKeys[key] = true
+		if !baseKeys[key] {
+			added = append(added, finding)
+		}
+	}
+
+	resolved := []*findingView{}
+	for _, finding := range base {
+		if !candidateKeys[findingKey(finding)] {
+			resolved = append(resolved, finding)
+		}
+	}
+
+	return added, resolved
+}
+
+// compareBalances returns every balance that differs
+// between base and candidate.
+func compareBalances(base, candidate []*balanceView) []*balanceDifference {
+	differences := map[string]*balanceDifference{}
+	keys := []string{}
+	add := func(views []*balanceView, set func(*balanceDifference, string)) {
+		for _, view := range views {
+			for _, amount := range view.Balances {
+				key := balanceKey(view.Account, amount.Currency)
+				difference, ok := differences[key]
+				if !ok {
+					difference = &balanceDifference{
+						Account:  view.Account,
+						Currency: amount.Currency,
+					}
+					differences[key] = difference
+					keys = append(keys, key)
+				}
+
+				set(difference, amount.Value)
+			}
+		}
+	}
+
+	add(base, func(difference *balanceDifference, value string) {
+		difference.Base = value
+	})
+	add(candidate, func(difference *balanceDifference, value string) {
+		difference.Candidate = value
+	})
+
+	sort.Strings(keys)
+	different := []*balanceDifference{}
+	for _, key := range keys {
+		if differences[key].Base != differences[key].Candidate {
+			different = append(different, differences[key])
+		}
+	}
+
+	return different
+}
+
+// runCompare diffs the findings, balances, and throughput
+// of the runReports of two runs over the same block range
+// (ex: before and after a node upgrade) and prints the
+// differences. It returns errRegressions if the candidate
+// run has new findings, different balances, or throughput
+// more than --throughput-tolerance below the base run.
+func runCompare(args []string, out io.Writer) error {
+	base, err := readRunReport(args[0])
+	if err != nil {
+		return err
+	}
+
+	candidate, err := readRunReport(args[1])
+	if err != nil {
+		return err
+	}
+
+	if base.Index != candidate.Index {
+		index := base.Index
+		if candidate.Index < index {
+			index = candidate.Index
+		}
+
+		return fmt.Errorf(
+			"reports are at different blocks (%d and %d): rerun view:report with --block %d",
+			base.Index,
+			candidate.Index,
+			index,
+		)
+	}
+
+	comparison := &comparisonView{
+		Index:       base.Index,
+		Regressions: []string{},
+	}
+	comparison.NewFindings, comparison.ResolvedFindings = compareFindings(
+		base.Findings,
+		candidate.Findings,
+	)
+	comparison.BalanceDifferences = compareBalances(base.Balances, candidate.Balances)
+
+	if len(comparison.NewFindings) > 0 {
+		comparison.Regressions = append(
+			comparison.Regressions,
+			fmt.Sprintf("%d new findings", len(comparison.NewFindings)),
+		)
+	}
+
+	if len(comparison.BalanceDifferences) > 0 {
+		comparison.Regressions = append(
+			comparison.Regressions,
+			fmt.Sprintf("%d balances differ", len(comparison.BalanceDifferences)),
+		)
+	}
+
+	if base.Throughput != nil && candidate.Throughput != nil && base.Throughput.BlocksPerSecond > 0 {
+		comparison.Throughput = &throughputComparison{
+			Base:      base.Throughput.BlocksPerSecond,
+			Candidate: candidate.Throughput.BlocksPerSecond,
+			Change:    candidate.Throughput.BlocksPerSecond/base.Throughput.BlocksPerSecond - 1,
+		}
+
+		if comparison.Throughput.Change < -compareThroughputTolerance {
+			comparison.Regressions = append(
+				comparison.Regressions,
+				fmt.Sprintf("throughput fell by %.1f%%", -100*comparison.Throughput.Change),
+			)
+		}
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(comparison); err != nil {
+		return err
+	}
+
+	if len(comparison.Regressions) > 0 {
+		return errRegressions
+	}
+
+	return nil
+}
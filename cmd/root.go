@@ -0,0 +1,58 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var rootCmd = &cobra.Command{
+	Use:   "rosetta-validator",
+	Short: "Validate the correctness and performance of a Rosetta Server",
+
+	// Errors are printed by the caller of Execute and
+	// usage is only printed when a command is unknown.
+	SilenceErrors: true,
+	SilenceUsage:  true,
+}
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
+	rootCmd.AddCommand(versionCmd)
+
+	rootCmd.AddCommand(viewBalanceCmd)
+	rootCmd.AddCommand(viewBlockCmd)
+	rootCmd.AddCommand(viewCurrenciesCmd)
+	rootCmd.AddCommand(viewOrphansCmd)
+	rootCmd.AddCommand(viewReconciliationsCmd)
+	rootCmd.AddCommand(viewReportCmd)
+	rootCmd.AddCommand(viewViolationsCmd)
+
+	rootCmd.AddCommand(utilsRecoverCmd)
+	rootCmd.AddCommand(utilsTrackCurrencyCmd)
+	rootCmd.AddCommand(utilsUntrackCurrencyCmd)
+	rootCmd.AddCommand(utilsExportStateCmd)
+	rootCmd.AddCommand(utilsImportStateCmd)
+	rootCmd.AddCommand(utilsReprocessCmd)
+
+	rootCmd.AddCommand(loadTestCmd)
+	rootCmd.AddCommand(compareCmd)
+}
+
+// Execute runs the command selected by the
+// command line arguments.
+func Execute() error {
+	return rootCmd.Execute()
+}
@@ -0,0 +1,122 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/coinbase/rosetta-validator/internal/metrics"
+	"github.com/coinbase/rosetta-validator/internal/syncer"
+	"github.com/coinbase/rosetta-validator/internal/utils"
+
+	"github.com/coinbase/rosetta-sdk-go/fetcher"
+)
+
+// runSpotCheck checks the SpotCheckDepth most recent blocks
+// of the network (see syncer.SpotCheck), prints a summary of
+// them, and exits. DATA_DIR is not used.
+func runSpotCheck(ctx context.Context, cfg config) {
+	logConfig(cfg)
+
+	httpClient, transports, err := newHTTPClient(cfg, nil, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	f := fetcher.New(
+		ctx,
+		cfg.ServerAddr,
+		cfg.UserAgent,
+		httpClient,
+		cfg.BlockConcurrency,
+		cfg.TransactionConcurrency,
+	)
+
+	networkResponse, err := f.InitializeAsserter(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var blockFetcher syncer.UnsafeFetcher = f
+	if cfg.AllowOmittedBlocks {
+		omittedFetcher := syncer.NewOmittedBlockFetcher(f, f.Asserter, cfg.BlockConcurrency)
+		omittedFetcher.SetRetries(cfg.MaxRetryElapsedTime, cfg.MaxRetries)
+		blockFetcher = omittedFetcher
+	}
+
+	skipList, err := utils.ParseSkipList(cfg.SkipBlocks)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var spotFetcher syncer.Fetcher = blockFetcher
+	if skipList.Len() > 0 {
+		skipListFetcher := syncer.NewSkipListFetcher(blockFetcher, f.Asserter, skipList)
+		skipListFetcher.SetRetries(cfg.MaxRetryElapsedTime, cfg.MaxRetries)
+		spotFetcher = skipListFetcher
+	}
+
+	network := networkIdentifiers(networkResponse)[0]
+	timestampUnit, err := networkTimestampUnit(cfg, network)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s := syncer.New(
+		ctx,
+		network,
+		spotFetcher,
+		nil,
+		nil,
+		&metrics.NoOpSink{},
+		syncer.Timeouts{Fetch: cfg.FetchTimeout},
+		nil,
+		nil,
+	)
+	s.SetRetries(cfg.MaxRetryElapsedTime, cfg.MaxRetries)
+	s.SetBlockConcurrency(cfg.BlockConcurrency)
+	s.SetTimestampValidation(timestampUnit, cfg.TimestampTolerance)
+	s.SetLogTimestampViolations(cfg.LogTimestampViolations)
+	s.SetExpectedGenesisHash(cfg.GenesisHash)
+	s.SetSkipList(skipList)
+
+	summary, err := s.SpotCheck(ctx, cfg.SpotCheckDepth)
+	if err == nil && transports.doubleFetch != nil {
+		select {
+		case err = <-transports.doubleFetch.Mismatches():
+		default:
+		}
+	}
+	if err := transports.Close(); err != nil {
+		log.Printf("Unable to close RECORD_FILE: %v\n", err)
+	}
+
+	if summary != nil && summary.Tip != nil {
+		log.Printf(
+			"Summary of spot check: checked %d blocks (%d-%d) with %d transactions\n",
+			summary.Blocks,
+			summary.Oldest.Index,
+			summary.Tip.Index,
+			summary.Transactions,
+		)
+	}
+
+	if code := exitCode(err); code != 0 {
+		log.Printf("Validation failed: %v\n", err)
+		os.Exit(code)
+	}
+}
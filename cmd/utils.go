@@ -0,0 +1,406 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/coinbase/rosetta-validator/internal/processor"
+	"github.com/coinbase/rosetta-validator/internal/storage"
+	"github.com/coinbase/rosetta-validator/internal/syncer"
+
+	"github.com/coinbase/rosetta-sdk-go/asserter"
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/spf13/cobra"
+)
+
+// defaultExportBlocks is the number of recent blocks
+// included in an exported state bundle by default.
+const defaultExportBlocks = 100
+
+var utilsRecoverCmd = &cobra.Command{
+	Use:   "utils:recover",
+	Short: "Check DATA_DIR for inconsistencies and repair them",
+	Long: `Utils:recover checks that the head block, stored blocks, and
+recently updated balances are consistent (ex: after an unclean
+shutdown) and rolls back any partially processed block unless
+--dry-run is set.`,
+	Args: cobra.NoArgs,
+	RunE: withUtilsConfig(utilsRecover),
+}
+
+var utilsTrackCurrencyCmd = &cobra.Command{
+	Use:   "utils:track-currency <key>",
+	Short: "Opt a registered currency in to balance tracking",
+	Long: `Utils:track-currency opts a registered currency (by the key
+printed by view:currencies) in to balance tracking and
+reconciliation. The validator must be stopped (and the change
+takes effect when it is restarted).`,
+	Args: cobra.ExactArgs(1),
+	RunE: withUtilsConfig(func(ctx context.Context, cfg viewConfig, args []string, out io.Writer) error {
+		return utilsTrackCurrency(ctx, cfg, args, true, out)
+	}),
+}
+
+var utilsUntrackCurrencyCmd = &cobra.Command{
+	Use:   "utils:untrack-currency <key>",
+	Short: "Opt a registered currency out of balance tracking",
+	Long: `Utils:untrack-currency opts a registered currency (by the key
+printed by view:currencies) out of balance tracking and
+reconciliation. The validator must be stopped (and the change
+takes effect when it is restarted).`,
+	Args: cobra.ExactArgs(1),
+	RunE: withUtilsConfig(func(ctx context.Context, cfg viewConfig, args []string, out io.Writer) error {
+		return utilsTrackCurrency(ctx, cfg, args, false, out)
+	}),
+}
+
+var utilsExportStateCmd = &cobra.Command{
+	Use:   "utils:export-state <file>",
+	Short: "Write a bundle of the validated state to a file",
+	Long: `Utils:export-state writes a bundle of the validated state (the
+last confirmed block, recent blocks, balances, and currencies)
+that utils:import-state can load into an empty DATA_DIR.`,
+	Args: cobra.ExactArgs(1),
+	RunE: withUtilsConfig(utilsExportState),
+}
+
+var utilsImportStateCmd = &cobra.Command{
+	Use:   "utils:import-state <file>",
+	Short: "Load a state bundle into an empty DATA_DIR",
+	Long: `Utils:import-state loads a bundle written by utils:export-state
+into an empty DATA_DIR so that validation resumes from it.`,
+	Args: cobra.ExactArgs(1),
+	RunE: withUtilsConfig(utilsImportState),
+}
+
+var utilsReprocessCmd = &cobra.Command{
+	Use:   "utils:reprocess",
+	Short: "Re-derive every balance from the blocks in DATA_DIR",
+	Long: `Utils:reprocess re-derives every balance from the blocks stored
+in DATA_DIR (without fetching anything from the Rosetta Server)
+and compares them to the stored balances.`,
+	Args: cobra.NoArgs,
+	RunE: withUtilsConfig(utilsReprocess),
+}
+
+var (
+	utilsRecoverDryRun     bool
+	utilsExportStateBlocks int
+	utilsReprocessScratch  string
+)
+
+func init() {
+	utilsRecoverCmd.Flags().BoolVar(
+		&utilsRecoverDryRun,
+		"dry-run",
+		false,
+		"report inconsistencies without repairing them",
+	)
+	utilsExportStateCmd.Flags().IntVar(
+		&utilsExportStateBlocks,
+		"blocks",
+		defaultExportBlocks,
+		"number of recent blocks to export",
+	)
+	utilsReprocessCmd.Flags().StringVar(
+		&utilsReprocessScratch,
+		"scratch-dir",
+		"",
+		"directory to create the scratch database in (defaults to the system temp directory)",
+	)
+}
+
+// withUtilsConfig returns a cobra RunE function like
+// withViewConfig that calls run while holding the lock
+// on DATA_DIR.
+func withUtilsConfig(
+	run func(context.Context, viewConfig, []string, io.Writer) error,
+) func(*cobra.Command, []string) error {
+	return withViewConfig(func(ctx context.Context, cfg viewConfig, args []string, out io.Writer) error {
+		lock, err := storage.LockDir(cfg.DataDir)
+		if err != nil {
+			return fmt.Errorf("%w: is a validator using DATA_DIR?", err)
+		}
+		defer lock.Unlock()
+
+		return run(ctx, cfg, args, out)
+	})
+}
+
+// utilsRecover checks DATA_DIR for inconsistencies
+// and repairs them.
+func utilsRecover(ctx context.Context, cfg viewConfig, args []string, out io.Writer) error {
+	blockStorage, closeStorage, err := openBlockStorage(ctx, cfg, true)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+
+	// Only blocks that can still be orphaned may
+	// have been partially processed.
+	inconsistencies, err := blockStorage.Recover(ctx, syncer.PastBlockSize, !utilsRecoverDryRun)
+	if err != nil {
+		return err
+	}
+
+	unrepaired := 0
+	for _, inconsistency := range inconsistencies {
+		status := "repaired"
+		if !inconsistency.Repaired {
+			status = "not repaired"
+			unrepaired++
+		}
+
+		fmt.Fprintf(out, "%s (%s)\n", inconsistency.Description, status)
+	}
+
+	if unrepaired > 0 {
+		return fmt.Errorf("%d inconsistencies not repaired", unrepaired)
+	}
+
+	fmt.Fprintf(out, "DATA_DIR is consistent\n")
+	return nil
+}
+
+// utilsTrackCurrency opts a registered currency in to
+// or out of balance tracking and reconciliation.
+func utilsTrackCurrency(
+	ctx context.Context,
+	cfg viewConfig,
+	args []string,
+	tracked bool,
+	out io.Writer,
+) error {
+	blockStorage, closeStorage, err := openBlockStorage(ctx, cfg, true)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+
+	if err := blockStorage.SetCurrencyTracked(ctx, args[0], tracked); err != nil {
+		return err
+	}
+
+	status := "tracked"
+	if !tracked {
+		status = "untracked"
+	}
+
+	fmt.Fprintf(out, "Currency %s is %s\n", args[0], status)
+	return nil
+}
+
+// utilsExportState writes a gzipped JSON
+// storage.StateBundle to a file.
+func utilsExportState(ctx context.Context, cfg viewConfig, args []string, out io.Writer) error {
+	path := args[0]
+	if utilsExportStateBlocks < 1 {
+		return fmt.Errorf("--blocks %d must be at least 1", utilsExportStateBlocks)
+	}
+
+	blockStorage, closeStorage, err := openBlockStorage(ctx, cfg, true)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+
+	bundle, err := blockStorage.ExportState(ctx, utilsExportStateBlocks)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(0600))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	writer := gzip.NewWriter(f)
+	if err := json.NewEncoder(writer).Encode(bundle); err != nil {
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(
+		out,
+		"Exported state at %+v (%d balances, %d blocks) to %s\n",
+		bundle.Head,
+		len(bundle.Balances),
+		len(bundle.Blocks),
+		path,
+	)
+	return f.Close()
+}
+
+// utilsImportState loads a storage.StateBundle written
+// by utilsExportState into an empty DATA_DIR.
+func utilsImportState(ctx context.Context, cfg viewConfig, args []string, out io.Writer) error {
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%w: unable to read state bundle %s", err, args[0])
+	}
+
+	var bundle storage.StateBundle
+	if err := json.NewDecoder(reader).Decode(&bundle); err != nil {
+		return fmt.Errorf("%w: unable to parse state bundle %s", err, args[0])
+	}
+
+	blockStorage, closeStorage, err := openBlockStorage(ctx, cfg, true)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+
+	if err := blockStorage.ImportState(ctx, &bundle); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(
+		out,
+		"Imported state at %+v (%d balances, %d blocks)\n",
+		bundle.Head,
+		len(bundle.Balances),
+		len(bundle.Blocks),
+	)
+	return nil
+}
+
+// genesisBlockIdentifier returns the genesis block of
+// the network (or of SUB_NETWORK, if set) in networkStatus.
+func genesisBlockIdentifier(
+	cfg viewConfig,
+	networkStatus *rosetta.NetworkStatusResponse,
+) (*rosetta.BlockIdentifier, error) {
+	if len(cfg.SubNetwork) == 0 {
+		return networkStatus.NetworkStatus.NetworkInformation.GenesisBlockIdentifier, nil
+	}
+
+	for _, status := range networkStatus.SubNetworkStatus {
+		if status.SubNetworkIdentifier.SubNetwork == cfg.SubNetwork {
+			return status.NetworkInformation.GenesisBlockIdentifier, nil
+		}
+	}
+
+	return nil, fmt.Errorf("sub-network %s not found in stored network status", cfg.SubNetwork)
+}
+
+// utilsReprocess re-derives balances from the blocks stored
+// in DATA_DIR into a scratch database (removed afterwards)
+// and prints any balances that differ from those stored.
+func utilsReprocess(ctx context.Context, cfg viewConfig, args []string, out io.Writer) error {
+	source, closeSource, err := openBlockStorage(ctx, cfg, true)
+	if err != nil {
+		return err
+	}
+	defer closeSource()
+
+	txn := source.NewDatabaseTransaction(ctx, false)
+	networkStatus, err := source.GetNetworkStatus(ctx, txn)
+	txn.Discard(ctx)
+	if errors.Is(err, storage.ErrNetworkStatusNotFound) {
+		return fmt.Errorf("%w: run check against DATA_DIR once to store it", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Stored balances can only be compared if
+	// they were computed (see MODE).
+	if err := source.InitializeBalanceTracking(ctx, true); err != nil {
+		return err
+	}
+
+	genesis, err := genesisBlockIdentifier(cfg, networkStatus)
+	if err != nil {
+		return err
+	}
+
+	dir, err := ioutil.TempDir(utilsReprocessScratch, "reprocess")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	scratch, err := storage.NewBadgerStorage(ctx, dir)
+	if err != nil {
+		return err
+	}
+	defer scratch.Close(ctx)
+
+	keyHasher, err := storage.NewKeyHasher(cfg.KeyHash)
+	if err != nil {
+		return err
+	}
+
+	target := storage.NewBlockStorage(ctx, scratch, &storage.GobCodec{}, keyHasher)
+	if err := target.InitializeKeySchema(ctx); err != nil {
+		return err
+	}
+
+	result, err := processor.Reprocess(
+		ctx,
+		source,
+		target,
+		asserter.New(ctx, networkStatus),
+		genesis,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, mismatch := range result.Mismatches {
+		fmt.Fprintf(
+			out,
+			"Balance of %+v in %+v is %s (reprocessed: %s)\n",
+			mismatch.Account,
+			mismatch.Currency,
+			mismatch.Stored,
+			mismatch.Reprocessed,
+		)
+	}
+
+	fmt.Fprintf(
+		out,
+		"Reprocessed %d blocks up to %+v (%d accounts, %d mismatches)\n",
+		result.Blocks,
+		result.Head,
+		result.Accounts,
+		len(result.Mismatches),
+	)
+
+	if len(result.Mismatches) > 0 {
+		return fmt.Errorf("%d balances do not match", len(result.Mismatches))
+	}
+
+	return nil
+}
@@ -0,0 +1,637 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/coinbase/rosetta-validator/internal/checkpoint"
+	"github.com/coinbase/rosetta-validator/internal/endcondition"
+	"github.com/coinbase/rosetta-validator/internal/logger"
+	"github.com/coinbase/rosetta-validator/internal/metrics"
+	"github.com/coinbase/rosetta-validator/internal/processor"
+	"github.com/coinbase/rosetta-validator/internal/reconciler"
+	"github.com/coinbase/rosetta-validator/internal/storage"
+	"github.com/coinbase/rosetta-validator/internal/syncer"
+	"github.com/coinbase/rosetta-validator/internal/utils"
+	"github.com/coinbase/rosetta-validator/internal/validation"
+
+	"github.com/coinbase/rosetta-sdk-go/fetcher"
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+// subNetworksDir is the directory in DataDir that
+// contains a directory of logs for each sub-network.
+const subNetworksDir = "sub_networks"
+
+// networkIdentifiers returns the identifier of the network
+// in networkResponse followed by the identifier of each of
+// its sub-networks.
+func networkIdentifiers(
+	networkResponse *rosetta.NetworkStatusResponse,
+) []*rosetta.NetworkIdentifier {
+	network := &rosetta.NetworkIdentifier{
+		Network:    networkResponse.NetworkStatus.NetworkIdentifier.Network,
+		Blockchain: networkResponse.NetworkStatus.NetworkIdentifier.Blockchain,
+	}
+
+	networks := []*rosetta.NetworkIdentifier{network}
+	for _, status := range networkResponse.SubNetworkStatus {
+		networks = append(networks, &rosetta.NetworkIdentifier{
+			Network:              network.Network,
+			Blockchain:           network.Blockchain,
+			SubNetworkIdentifier: status.SubNetworkIdentifier,
+		})
+	}
+
+	return networks
+}
+
+// subNetworkNamespace returns the namespace in DATA_DIR
+// that the blocks and balances of subNetwork are stored
+// in. The network itself is not namespaced.
+func subNetworkNamespace(subNetwork string) string {
+	return "sub_network/" + subNetwork
+}
+
+// networkValidator syncs and reconciles a single network
+// or sub-network. Each sub-network is stored in its own
+// namespace of DATA_DIR and logged to its own directory
+// in DATA_DIR.
+type networkValidator struct {
+	network     *rosetta.NetworkIdentifier
+	syncFetcher checkpoint.Fetcher
+	trusted     *rosetta.BlockIdentifier
+	snapshot    *storage.BalanceSnapshot
+
+	blockStorage *storage.BlockStorage
+	reconciler   reconciler.Reconciler
+	stateful     *reconciler.StatefulReconciler
+	handler      *processor.SyncHandler
+	syncer       *syncer.Syncer
+
+	startHead     *rosetta.BlockIdentifier
+	startFindings int64
+
+	// violationCursor is the cursor of the first
+	// violation recorded after initialization.
+	violationCursor int64
+
+	// recordedReconciliations is the number of
+	// reconciliations performed by this run that have
+	// been added to the storage.LifetimeStats.
+	lifetimeMutex           sync.Mutex
+	recordedReconciliations int64
+}
+
+// initialize constructs the storage, reconciler, and
+// syncer of the network.
+func (v *networkValidator) initialize(
+	ctx context.Context,
+	cfg config,
+	networkResponse *rosetta.NetworkStatusResponse,
+	db storage.Database,
+	codec storage.Codec,
+	keyHasher storage.KeyHasher,
+	f *fetcher.Fetcher,
+	lookup reconciler.Fetcher,
+	sink metrics.Sink,
+) error {
+	logDir := cfg.DataDir
+	if subNetwork := v.network.SubNetworkIdentifier; subNetwork != nil {
+		db = storage.NewNamespacedStorage(db, subNetworkNamespace(subNetwork.SubNetwork))
+		logDir = filepath.Join(cfg.DataDir, subNetworksDir, url.PathEscape(subNetwork.SubNetwork))
+		if err := os.MkdirAll(logDir, os.FileMode(0700)); err != nil {
+			return err
+		}
+	}
+
+	dataOnly := cfg.Mode == modeDataOnly
+	blockStorage, err := validation.OpenStorage(
+		ctx,
+		db,
+		codec,
+		keyHasher,
+		v.network,
+		networkResponse,
+		!dataOnly,
+	)
+	if errors.Is(err, storage.ErrNetworkMismatch) || errors.Is(err, storage.ErrBalanceTrackingMismatch) {
+		return fmt.Errorf("%w (use --reset to wipe DATA_DIR)", err)
+	}
+	if err != nil {
+		return err
+	}
+	if !cfg.AnalyticsStreams {
+		blockStorage.SetStreamRetention(syncer.PastBlockSize)
+	}
+	v.blockStorage = blockStorage
+
+	// The findings (and head) before syncing
+	// are compared to those after in the summary.
+	startFindings, err := v.blockStorage.FindingCount(ctx)
+	if err != nil {
+		return err
+	}
+	v.startFindings = startFindings
+
+	violationCursor, err := v.blockStorage.ViolationCursor(ctx)
+	if err != nil {
+		return err
+	}
+	v.violationCursor = violationCursor
+
+	logger := logger.NewLogger(
+		logDir,
+		cfg.LogTransactions,
+		cfg.LogBenchmarks,
+		cfg.LogBalanceChanges,
+		cfg.LogReconciliations,
+	)
+
+	timestampUnit, err := networkTimestampUnit(cfg, v.network)
+	if err != nil {
+		return err
+	}
+	logger.SetTimestampUnit(timestampUnit)
+	logger.SetBlockStatsWindow(v.name(), cfg.BlockStatsWindow)
+	logger.SetLifetimeStatsRecorder(v)
+
+	v.reconciler = &reconciler.NoOpReconciler{}
+	if !dataOnly && reconciler.ShouldReconcile(networkResponse) {
+		v.stateful = reconciler.NewStateful(
+			ctx,
+			v.network,
+			v.blockStorage,
+			lookup,
+			logger,
+			sink,
+			reconciler.Timeouts{
+				Fetch:     cfg.FetchTimeout,
+				Reconcile: cfg.ReconcileTimeout,
+			},
+			reconcileConcurrency(cfg),
+		)
+
+		if len(cfg.AccountLabelsFile) > 0 {
+			labels, err := reconciler.LoadAccountLabels(cfg.AccountLabelsFile)
+			if err != nil {
+				return err
+			}
+			v.stateful.SetAccountLabels(labels)
+		}
+
+		v.stateful.SetRetries(cfg.MaxRetryElapsedTime, cfg.MaxRetries)
+		v.stateful.SetSkipFetchErrors(cfg.CircuitBreakerTimeout > 0)
+		v.reconciler = v.stateful
+	}
+
+	v.handler = processor.NewSyncHandler(
+		ctx,
+		v.blockStorage,
+		f.Asserter,
+		logger,
+		v.reconciler,
+		v.trusted,
+	)
+	v.handler.SetReorgCompactionDepth(cfg.ReorgCompactionDepth)
+	v.handler.SetConfirmationDepth(cfg.ConfirmationDepth)
+	v.handler.SetTimestampUnit(timestampUnit)
+	v.handler.SetTrackNewCurrencies(cfg.TrackNewCurrencies)
+	v.handler.SetDataOnly(dataOnly)
+	v.handler.SetBalanceWorkers(cfg.BalanceWorkers)
+	v.handler.SetContinueOnError(cfg.ContinueOnError)
+	if cfg.RecoverCorruption {
+		v.handler.SetCorruptionRecovery(v.network, v.syncFetcher)
+		v.handler.SetRetries(cfg.MaxRetryElapsedTime, cfg.MaxRetries)
+		if v.stateful != nil {
+			v.stateful.SetCorruptionRecoverer(v.handler)
+		}
+	}
+
+	var queue syncer.Queue
+	if cfg.DurableQueue {
+		queue = processor.NewBlockQueue(v.blockStorage)
+	}
+
+	if v.snapshot != nil {
+		if err := v.importSnapshot(ctx, cfg, f); err != nil {
+			return err
+		}
+	}
+
+	var intent *storage.ReorgIntent
+	v.syncer, intent, err = validation.NewSyncer(
+		ctx,
+		v.network,
+		v.blockStorage,
+		v.syncFetcher,
+		v.handler,
+		logger,
+		sink,
+		syncer.Timeouts{
+			Fetch:   cfg.FetchTimeout,
+			Process: cfg.StoreTimeout,
+		},
+		queue,
+	)
+	if err != nil {
+		return err
+	}
+
+	if intent != nil {
+		log.Printf(
+			"Resuming reorg from %+v (%d blocks orphaned)\n",
+			intent.Head,
+			len(intent.Orphaned),
+		)
+	}
+
+	txn := v.blockStorage.NewDatabaseTransaction(ctx, false)
+	head, err := v.blockStorage.GetHeadBlockIdentifier(ctx, txn)
+	txn.Discard(ctx)
+	if err != nil && !errors.Is(err, storage.ErrHeadBlockNotFound) {
+		return err
+	}
+
+	if head != nil {
+		v.startHead = head
+		if err := v.recordRestart(ctx); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Resume && v.startHead == nil {
+		return fmt.Errorf(
+			"%w: nothing to resume for %s (omit --resume to sync from genesis)",
+			storage.ErrHeadBlockNotFound,
+			v.name(),
+		)
+	}
+
+	v.syncer.SetRetries(cfg.MaxRetryElapsedTime, cfg.MaxRetries)
+	v.syncer.SetBlockConcurrency(cfg.BlockConcurrency)
+	v.syncer.SetCircuitBreaker(
+		cfg.CircuitBreakerBackoff,
+		cfg.CircuitBreakerMaxBackoff,
+		cfg.CircuitBreakerTimeout,
+	)
+	v.syncer.SetPrefetch(cfg.PrefetchBlocks)
+	v.syncer.SetTipPolling(cfg.TipPollInterval, cfg.TipPollJitter)
+	v.syncer.SetMaxReorgDepth(cfg.MaxReorgDepth)
+	v.syncer.SetTimestampValidation(timestampUnit, cfg.TimestampTolerance)
+	v.syncer.SetLogTimestampViolations(cfg.LogTimestampViolations)
+
+	return nil
+}
+
+// sync syncs the network until syncing fails or ctx is
+// canceled. If RECOVER_CORRUPTION is set, a stored value
+// that cannot be decoded is restored and syncing resumes,
+// unless the same value is still corrupted afterwards.
+func (v *networkValidator) sync(ctx context.Context) error {
+	recovered := ""
+	for {
+		err := v.syncer.Sync(ctx)
+		var corruptionErr *storage.CorruptionError
+		if !errors.As(err, &corruptionErr) || corruptionErr.Error() == recovered {
+			return err
+		}
+
+		log.Printf("Recovering %s from %v\n", v.name(), corruptionErr)
+		if err := v.handler.RecoverCorruption(ctx, corruptionErr); err != nil {
+			return err
+		}
+
+		recovered = corruptionErr.Error()
+	}
+}
+
+// name returns the name of the network or
+// sub-network in log messages.
+func (v *networkValidator) name() string {
+	if v.network.SubNetworkIdentifier != nil {
+		return fmt.Sprintf("sub-network %s", v.network.SubNetworkIdentifier.SubNetwork)
+	}
+
+	return fmt.Sprintf("%s %s", v.network.Blockchain, v.network.Network)
+}
+
+// drain reconciles the accounts still queued for
+// reconciliation, waiting up to timeout (0 waits
+// until every account is reconciled).
+func (v *networkValidator) drain(timeout time.Duration) error {
+	if v.stateful == nil || v.stateful.Backlog() == 0 {
+		return nil
+	}
+
+	log.Printf("Reconciling %d queued accounts of %s\n", v.stateful.Backlog(), v.name())
+	ctx, cancel := utils.ContextWithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return v.stateful.Drain(ctx)
+}
+
+// importSnapshot imports the balance snapshot of the
+// network unless a block has already been synced (ex:
+// after the snapshot was imported by a previous run). The
+// block of the snapshot is fetched from the Rosetta Server
+// to verify that it is on the chain.
+func (v *networkValidator) importSnapshot(
+	ctx context.Context,
+	cfg config,
+	f *fetcher.Fetcher,
+) error {
+	txn := v.blockStorage.NewDatabaseTransaction(ctx, false)
+	head, err := v.blockStorage.GetHeadBlockIdentifier(ctx, txn)
+	txn.Discard(ctx)
+	if err == nil {
+		log.Printf("Ignoring SNAPSHOT_FILE, %s has already synced block %+v\n", v.name(), head)
+		return nil
+	}
+	if !errors.Is(err, storage.ErrHeadBlockNotFound) {
+		return err
+	}
+
+	identifier := v.snapshot.BlockIdentifier
+	block, err := f.BlockRetry(
+		ctx,
+		v.network,
+		&rosetta.PartialBlockIdentifier{
+			Index: &identifier.Index,
+			Hash:  &identifier.Hash,
+		},
+		cfg.MaxRetryElapsedTime,
+		cfg.MaxRetries,
+	)
+	if err != nil {
+		return fmt.Errorf("%w: unable to fetch snapshot block %+v", err, identifier)
+	}
+
+	if err := v.blockStorage.ImportSnapshot(ctx, v.snapshot, block); err != nil {
+		return err
+	}
+
+	log.Printf(
+		"Imported balance snapshot of %s at %+v (%d balances)\n",
+		v.name(),
+		identifier,
+		len(v.snapshot.Balances),
+	)
+	return nil
+}
+
+// recordRestart adds a restart to the LifetimeStats
+// and logs them.
+func (v *networkValidator) recordRestart(ctx context.Context) error {
+	err := v.blockStorage.Update(ctx, func(txn storage.DatabaseTransaction) error {
+		return v.blockStorage.AddLifetimeStats(ctx, txn, &storage.LifetimeStats{Restarts: 1})
+	})
+	if err != nil {
+		return err
+	}
+
+	stats, err := v.lifetimeStats(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Lifetime stats of %s: %s\n", v.name(), stats)
+	return nil
+}
+
+// RecordLifetimeStats adds the reconciliations performed
+// since they were last recorded to the LifetimeStats and
+// returns them. Blocks are recorded as they are processed.
+func (v *networkValidator) RecordLifetimeStats(ctx context.Context) (*storage.LifetimeStats, error) {
+	v.lifetimeMutex.Lock()
+	defer v.lifetimeMutex.Unlock()
+
+	delta := &storage.LifetimeStats{}
+	if v.stateful != nil {
+		delta.Reconciliations = v.stateful.Reconciliations() - v.recordedReconciliations
+	}
+
+	err := v.blockStorage.Update(ctx, func(txn storage.DatabaseTransaction) error {
+		return v.blockStorage.AddLifetimeStats(ctx, txn, delta)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	v.recordedReconciliations += delta.Reconciliations
+	return v.lifetimeStats(ctx)
+}
+
+// lifetimeStats returns the LifetimeStats. They are read
+// in their own transaction so that recording them does not
+// conflict with the syncer adding blocks.
+func (v *networkValidator) lifetimeStats(ctx context.Context) (*storage.LifetimeStats, error) {
+	txn := v.blockStorage.NewDatabaseTransaction(ctx, false)
+	defer txn.Discard(ctx)
+
+	return v.blockStorage.GetLifetimeStats(ctx, txn)
+}
+
+// rangeSummary summarizes the blocks synced and the
+// accounts reconciled by a networkValidator.
+type rangeSummary struct {
+	Network string `json:"network"`
+	syncer.RangeSummary
+
+	ModifiedAccounts   int   `json:"modified_accounts"`
+	Reconciliations    int64 `json:"reconciliations"`
+	ReconciledAccounts int   `json:"reconciled_accounts"`
+}
+
+// rangeSummary returns a summary of the blocks synced and
+// the accounts reconciled. It must be called after syncing
+// and reconciliation have stopped.
+func (v *networkValidator) rangeSummary() *rangeSummary {
+	summary := &rangeSummary{
+		Network:      v.name(),
+		RangeSummary: v.syncer.Summary(),
+	}
+
+	if v.stateful != nil {
+		summary.ModifiedAccounts = v.stateful.ModifiedAccounts()
+		summary.Reconciliations = v.stateful.Reconciliations()
+		summary.ReconciledAccounts = v.stateful.ReconciledAccounts()
+	}
+
+	return summary
+}
+
+// networkSummary is a rangeSummary along with the
+// head block and the number of findings recorded since
+// the network was initialized.
+type networkSummary struct {
+	*rangeSummary
+
+	Head          *rosetta.BlockIdentifier `json:"head"`
+	NewFindings   int64                    `json:"new_findings"`
+	NewViolations int64                    `json:"new_violations"`
+}
+
+// networkSummary returns a summary of the network. It
+// must be called after syncing and reconciliation have
+// stopped.
+func (v *networkValidator) networkSummary(ctx context.Context) (*networkSummary, error) {
+	txn := v.blockStorage.NewDatabaseTransaction(ctx, false)
+	defer txn.Discard(ctx)
+
+	head, err := v.blockStorage.GetHeadBlockIdentifier(ctx, txn)
+	if err != nil && !errors.Is(err, storage.ErrHeadBlockNotFound) {
+		return nil, err
+	}
+
+	findings, err := v.blockStorage.FindingCount(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	violations, err := v.newViolations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &networkSummary{
+		rangeSummary:  v.rangeSummary(),
+		Head:          head,
+		NewFindings:   findings - v.startFindings,
+		NewViolations: violations,
+	}, nil
+}
+
+// newViolations returns the number of violations
+// recorded since the network was initialized (not
+// including those of blocks orphaned since).
+func (v *networkValidator) newViolations(ctx context.Context) (int64, error) {
+	return v.blockStorage.ViolationCount(ctx, v.violationCursor)
+}
+
+// summarize logs the head block, the number of findings
+// and violations recorded since the network was
+// initialized, and the
+// blocks synced and accounts reconciled.
+func (v *networkValidator) summarize(ctx context.Context) error {
+	summary, err := v.networkSummary(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Printf(
+		"Summary of %s: head block %+v (was %+v), %d new findings, %d new violations\n",
+		v.name(),
+		summary.Head,
+		v.startHead,
+		summary.NewFindings,
+		summary.NewViolations,
+	)
+
+	log.Printf(
+		"Summary of %s: processed %d blocks (%d-%d, %d orphaned, %d omitted), %d modified accounts, %d reconciliations\n",
+		v.name(),
+		summary.BlocksProcessed,
+		summary.StartIndex,
+		summary.EndIndex,
+		summary.BlocksOrphaned,
+		summary.BlocksOmitted,
+		summary.ModifiedAccounts,
+		summary.Reconciliations,
+	)
+
+	if v.stateful != nil {
+		log.Printf(
+			"Summary of %s: reconciled %d accounts (%.1f%% coverage)\n",
+			v.name(),
+			v.stateful.ReconciledAccounts(),
+			v.stateful.ReconciliationCoverage()*100,
+		)
+	}
+
+	stats, err := v.RecordLifetimeStats(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Lifetime stats of %s: %s\n", v.name(), stats)
+	return nil
+}
+
+// healthy returns true if every block up to the tip
+// of the node has been processed and no accounts are
+// pending reconciliation.
+func (v *networkValidator) healthy() bool {
+	return v.syncer.AtTip() && (v.stateful == nil || v.stateful.Idle())
+}
+
+// networksHealth is the endcondition.Health
+// of a set of networks.
+type networksHealth []*networkValidator
+
+// Healthy returns true if every network is healthy.
+func (h networksHealth) Healthy() bool {
+	for _, v := range h {
+		if !v.healthy() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// healthSummary is the machine-readable summary
+// written when UNTIL_HEALTHY is set.
+type healthSummary struct {
+	Healthy  bool              `json:"healthy"`
+	Error    string            `json:"error,omitempty"`
+	Networks []*networkSummary `json:"networks"`
+}
+
+// newHealthSummary returns the healthSummary of
+// validators that stopped because of err.
+func newHealthSummary(
+	ctx context.Context,
+	validators []*networkValidator,
+	err error,
+) (*healthSummary, error) {
+	summary := &healthSummary{
+		Healthy:  errors.Is(err, endcondition.ErrHealthy),
+		Networks: []*networkSummary{},
+	}
+	if !summary.Healthy && err != nil {
+		summary.Error = err.Error()
+	}
+
+	for _, v := range validators {
+		network, err := v.networkSummary(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		summary.Networks = append(summary.Networks, network)
+	}
+
+	return summary, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/spf13/cobra"
+)
+
+// sdkModule is the module path of rosetta-sdk-go,
+// whose version is read from the build info.
+const sdkModule = "github.com/coinbase/rosetta-sdk-go"
+
+// buildInfo identifies the build of the validator
+// in bug reports.
+type buildInfo struct {
+	Version        string
+	Commit         string
+	SDKVersion     string
+	RosettaVersion string
+}
+
+// String returns a single line description of
+// the build (ex: for the startup log).
+func (b buildInfo) String() string {
+	return fmt.Sprintf(
+		"rosetta-validator %s (commit %s, rosetta-sdk-go %s, Rosetta API %s)",
+		b.Version,
+		b.Commit,
+		b.SDKVersion,
+		b.RosettaVersion,
+	)
+}
+
+var build = buildInfo{
+	Version:        "dev",
+	Commit:         "unknown",
+	SDKVersion:     sdkVersion(),
+	RosettaVersion: rosetta.APIVersion,
+}
+
+// SetBuildInfo sets the version and git commit of the
+// build, which are compiled into the main package. It
+// must be called before Execute.
+func SetBuildInfo(version string, commit string) {
+	build.Version = version
+	build.Commit = commit
+}
+
+// sdkVersion returns the version of rosetta-sdk-go
+// the validator was built with.
+func sdkVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Path != sdkModule {
+			continue
+		}
+
+		if dep.Replace != nil {
+			return fmt.Sprintf("%s => %s %s", dep.Version, dep.Replace.Path, dep.Replace.Version)
+		}
+
+		return dep.Version
+	}
+
+	return "unknown"
+}
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print the version of the validator",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "Version:        %s\n", build.Version)
+		fmt.Fprintf(out, "Git commit:     %s\n", build.Commit)
+		fmt.Fprintf(out, "rosetta-sdk-go: %s\n", build.SDKVersion)
+		fmt.Fprintf(out, "Rosetta API:    %s\n", build.RosettaVersion)
+	},
+}
@@ -0,0 +1,639 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coinbase/rosetta-validator/internal/storage"
+	"github.com/coinbase/rosetta-validator/internal/syncer"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/caarlos0/env"
+	"github.com/spf13/cobra"
+)
+
+// viewReadLimit is the number of stream entries
+// read at a time when exporting a stream.
+const viewReadLimit = 1000
+
+var viewBalanceCmd = &cobra.Command{
+	Use:     "view:balance <address>",
+	Aliases: []string{"view:account"},
+	Short:   "Print the computed balances of an account",
+	Long: `View:balance prints the computed balances of an account (in every
+currency) as of a block, or as of the most recently synced block
+if --block is omitted, and the block they were last updated at.`,
+	Args: cobra.ExactArgs(1),
+	RunE: withViewConfig(viewBalance),
+}
+
+var viewReconciliationsCmd = &cobra.Command{
+	Use:   "view:reconciliations <address>",
+	Short: "Export every reconciliation of an account",
+	Args:  cobra.ExactArgs(1),
+	RunE:  withViewConfig(viewReconciliations),
+}
+
+var viewCurrenciesCmd = &cobra.Command{
+	Use:   "view:currencies",
+	Short: "Print every currency discovered in synced blocks",
+	Long: `View:currencies prints every currency discovered in synced blocks
+and whether it is tracked.`,
+	Args: cobra.NoArgs,
+	RunE: withViewConfig(viewCurrencies),
+}
+
+var viewBlockCmd = &cobra.Command{
+	Use:   "view:block",
+	Short: "Print a stored block",
+	Long: `View:block prints a block (or the head block if neither --index
+nor --hash is set) as it was stored, including its transactions
+and operations.`,
+	Args: cobra.NoArgs,
+	RunE: withViewConfig(viewBlock),
+}
+
+var viewOrphansCmd = &cobra.Command{
+	Use:   "view:orphans",
+	Short: "Print every block orphaned by a reorg",
+	Long: `View:orphans prints every block orphaned by a reorg, the head
+block when the reorg began, and how many blocks the reorg had
+orphaned (including the block).`,
+	Args: cobra.NoArgs,
+	RunE: withViewConfig(viewOrphans),
+}
+
+var viewViolationsCmd = &cobra.Command{
+	Use:   "view:violations",
+	Short: "Print every violation recorded with CONTINUE_ON_ERROR",
+	Args:  cobra.NoArgs,
+	RunE:  withViewConfig(viewViolations),
+}
+
+var viewReportCmd = &cobra.Command{
+	Use:   "view:report",
+	Short: "Print the findings and balances as of a block for compare",
+	Long: `View:report prints the findings and balances as of a block (and
+the soak test throughput, if any) for compare.`,
+	Args: cobra.NoArgs,
+	RunE: withViewConfig(viewReport),
+}
+
+var (
+	viewBalanceBlock      int64
+	viewBalanceSubAccount string
+
+	viewReconciliationsFormat     string
+	viewReconciliationsSubAccount string
+
+	viewBlockIndex int64
+	viewBlockHash  string
+
+	viewOrphansFull bool
+
+	viewViolationsType string
+
+	viewReportBlock int64
+)
+
+func init() {
+	viewBalanceCmd.Flags().Int64Var(
+		&viewBalanceBlock,
+		"block",
+		-1,
+		"index of the block to view the balance at",
+	)
+	viewBalanceCmd.Flags().StringVar(
+		&viewBalanceSubAccount,
+		"sub-account",
+		"",
+		"sub-account of the account",
+	)
+
+	viewReconciliationsCmd.Flags().StringVar(
+		&viewReconciliationsFormat,
+		"format",
+		"json",
+		"output format (json or csv)",
+	)
+	viewReconciliationsCmd.Flags().StringVar(
+		&viewReconciliationsSubAccount,
+		"sub-account",
+		"",
+		"sub-account of the account",
+	)
+
+	viewBlockCmd.Flags().Int64Var(&viewBlockIndex, "index", -1, "index of the block")
+	viewBlockCmd.Flags().StringVar(&viewBlockHash, "hash", "", "hash of the block")
+
+	viewOrphansCmd.Flags().BoolVar(
+		&viewOrphansFull,
+		"full",
+		false,
+		"include the transactions of each orphaned block",
+	)
+
+	viewViolationsCmd.Flags().StringVar(
+		&viewViolationsType,
+		"type",
+		"",
+		"only print violations of this type (ex: negative_balance)",
+	)
+
+	viewReportCmd.Flags().Int64Var(
+		&viewReportBlock,
+		"block",
+		-1,
+		"index of the block to report at",
+	)
+}
+
+// withViewConfig returns a cobra RunE function that
+// calls run with the viewConfig parsed from the
+// environment and the positional arguments.
+func withViewConfig(
+	run func(context.Context, viewConfig, []string, io.Writer) error,
+) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		cfg := viewConfig{}
+		if err := env.Parse(&cfg); err != nil {
+			return err
+		}
+
+		if len(cfg.DataDir) == 0 {
+			return errors.New("DATA_DIR is required")
+		}
+
+		return run(context.Background(), cfg, args, cmd.OutOrStdout())
+	}
+}
+
+// viewConfig is parsed separately from config so that
+// DATA_DIR can be inspected without a Rosetta Server.
+type viewConfig struct {
+	DataDir string `env:"DATA_DIR"`
+	KeyHash string `env:"KEY_HASH" envDefault:"sha256"`
+
+	// AnalyticsStreams is ANALYTICS_STREAMS of check
+	// (only used by the utils:* commands that write).
+	AnalyticsStreams bool `env:"ANALYTICS_STREAMS" envDefault:"false"`
+
+	// SubNetwork selects the sub-network to inspect
+	// instead of the network.
+	SubNetwork string `env:"SUB_NETWORK"`
+}
+
+// balanceView is the output of view:balance.
+type balanceView struct {
+	Account  *rosetta.AccountIdentifier `json:"account"`
+	Block    *rosetta.BlockIdentifier   `json:"block"`
+	Balances []*rosetta.Amount          `json:"balances"`
+}
+
+// currencyView is a single currency in the
+// output of view:currencies.
+type currencyView struct {
+	Key       string                   `json:"key"`
+	Currency  *rosetta.Currency        `json:"currency"`
+	FirstSeen *rosetta.BlockIdentifier `json:"first_seen"`
+	Tracked   bool                     `json:"tracked"`
+}
+
+// reconciliationView is a single reconciliation in
+// the JSON output of view:reconciliations.
+type reconciliationView struct {
+	Type     string                   `json:"type"`
+	Currency *rosetta.Currency        `json:"currency"`
+	Block    *rosetta.BlockIdentifier `json:"block"`
+	Computed string                   `json:"computed,omitempty"`
+	Live     string                   `json:"live"`
+	Outcome  string                   `json:"outcome"`
+	Labels   []string                 `json:"labels,omitempty"`
+
+	// ComputedFormatted and LiveFormatted render Computed
+	// and Live in standard units (ex: "1.25 BTC").
+	ComputedFormatted string `json:"computed_formatted,omitempty"`
+	LiveFormatted     string `json:"live_formatted"`
+}
+
+// orphanView is a single block in the
+// output of view:orphans.
+type orphanView struct {
+	Block        *rosetta.BlockIdentifier `json:"block"`
+	ParentBlock  *rosetta.BlockIdentifier `json:"parent_block"`
+	Transactions int                      `json:"transactions"`
+	ReorgHead    *rosetta.BlockIdentifier `json:"reorg_head"`
+	ReorgDepth   int64                    `json:"reorg_depth"`
+	OrphanedAt   time.Time                `json:"orphaned_at"`
+
+	// Full is the orphaned block, set only if
+	// --full is set.
+	Full *rosetta.Block `json:"full,omitempty"`
+}
+
+// violationView is a single violation in the
+// output of view:violations.
+type violationView struct {
+	Type        string                         `json:"type"`
+	Block       *rosetta.BlockIdentifier       `json:"block"`
+	Transaction *rosetta.TransactionIdentifier `json:"transaction,omitempty"`
+	Operation   *rosetta.OperationIdentifier   `json:"operation,omitempty"`
+	Account     *rosetta.AccountIdentifier     `json:"account,omitempty"`
+	Currency    *rosetta.Currency              `json:"currency,omitempty"`
+	Message     string                         `json:"message"`
+}
+
+// accountIdentifier returns the AccountIdentifier of
+// an address and (optional) sub-account.
+func accountIdentifier(address string, subAccount string) *rosetta.AccountIdentifier {
+	account := &rosetta.AccountIdentifier{
+		Address: address,
+	}
+	if len(subAccount) > 0 {
+		account.SubAccount = &rosetta.SubAccountIdentifier{
+			SubAccount: subAccount,
+		}
+	}
+
+	return account
+}
+
+// openBlockStorage opens the BlockStorage in DATA_DIR (of
+// SUB_NETWORK, if set). Unless writable is set, DATA_DIR is
+// opened read-only (and must exist). The returned function
+// must be called to close it.
+func openBlockStorage(
+	ctx context.Context,
+	cfg viewConfig,
+	writable bool,
+) (*storage.BlockStorage, func(), error) {
+	keyHasher, err := storage.NewKeyHasher(cfg.KeyHash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	open := storage.NewReadOnlyBadgerStorage
+	if writable {
+		open = storage.NewBadgerStorage
+	}
+
+	localStore, err := open(ctx, cfg.DataDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: unable to open DATA_DIR (is the validator running?)", err)
+	}
+
+	var db storage.Database = localStore
+	if len(cfg.SubNetwork) > 0 {
+		db = storage.NewNamespacedStorage(localStore, subNetworkNamespace(cfg.SubNetwork))
+	}
+
+	// Values are decoded using the codec recorded with
+	// each value, so the encoding codec is irrelevant.
+	blockStorage := storage.NewBlockStorage(ctx, db, &storage.GobCodec{}, keyHasher)
+	if !cfg.AnalyticsStreams {
+		blockStorage.SetStreamRetention(syncer.PastBlockSize)
+	}
+	checkKeySchema := blockStorage.CheckKeySchema
+	if writable {
+		checkKeySchema = blockStorage.InitializeKeySchema
+	}
+
+	if err := checkKeySchema(ctx); err != nil {
+		localStore.Close(ctx)
+		return nil, nil, err
+	}
+
+	return blockStorage, func() { localStore.Close(ctx) }, nil
+}
+
+// viewBalance prints the balances of an account.
+func viewBalance(ctx context.Context, cfg viewConfig, args []string, out io.Writer) error {
+	account := accountIdentifier(args[0], viewBalanceSubAccount)
+	blockStorage, closeStorage, err := openBlockStorage(ctx, cfg, false)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+
+	txn := blockStorage.NewDatabaseTransaction(ctx, false)
+	defer txn.Discard(ctx)
+
+	var amounts map[string]*rosetta.Amount
+	var block *rosetta.BlockIdentifier
+	if viewBalanceBlock < 0 {
+		amounts, block, err = blockStorage.GetBalance(ctx, txn, account)
+	} else {
+		amounts, block, err = blockStorage.GetBalanceAt(ctx, txn, account, viewBalanceBlock)
+	}
+	if err != nil {
+		return err
+	}
+
+	view := &balanceView{
+		Account:  account,
+		Block:    block,
+		Balances: []*rosetta.Amount{},
+	}
+	for _, amount := range amounts {
+		view.Balances = append(view.Balances, amount)
+	}
+	sort.Slice(view.Balances, func(i, j int) bool {
+		return view.Balances[i].Currency.Symbol < view.Balances[j].Currency.Symbol
+	})
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(view)
+}
+
+// viewReconciliations exports the reconciliation
+// history of an account.
+func viewReconciliations(ctx context.Context, cfg viewConfig, args []string, out io.Writer) error {
+	format := viewReconciliationsFormat
+	if format != "json" && format != "csv" {
+		return fmt.Errorf("unsupported format %s", format)
+	}
+
+	account := accountIdentifier(args[0], viewReconciliationsSubAccount)
+
+	blockStorage, closeStorage, err := openBlockStorage(ctx, cfg, false)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+
+	reconciliations := []*storage.Reconciliation{}
+	for cursor := int64(0); ; {
+		var page []*storage.Reconciliation
+		page, cursor, err = blockStorage.Reconciliations(ctx, account, cursor, viewReadLimit)
+		if err != nil {
+			return err
+		}
+
+		if len(page) == 0 {
+			break
+		}
+
+		reconciliations = append(reconciliations, page...)
+	}
+
+	if format == "csv" {
+		return writeReconciliationsCSV(reconciliations, out)
+	}
+
+	views := []*reconciliationView{}
+	for _, reconciliation := range reconciliations {
+		views = append(views, &reconciliationView{
+			Type:     reconciliation.Type,
+			Currency: reconciliation.Currency,
+			Block:    reconciliation.Block,
+			Computed: reconciliation.Computed,
+			Live:     reconciliation.Live,
+			Outcome:  reconciliation.Outcome,
+			Labels:   reconciliation.Labels,
+
+			ComputedFormatted: formatReconciliationAmount(reconciliation.Computed, reconciliation.Currency),
+			LiveFormatted:     formatReconciliationAmount(reconciliation.Live, reconciliation.Currency),
+		})
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(views)
+}
+
+// formatReconciliationAmount renders an amount of a
+// reconciliation in standard units or returns "" if the
+// amount is unknown (ex: the computed balance of a skipped
+// reconciliation).
+func formatReconciliationAmount(value string, currency *rosetta.Currency) string {
+	if len(value) == 0 {
+		return ""
+	}
+
+	return storage.FormatAmount(value, currency)
+}
+
+// writeReconciliationsCSV writes reconciliations
+// as CSV with a header row.
+func writeReconciliationsCSV(reconciliations []*storage.Reconciliation, out io.Writer) error {
+	writer := csv.NewWriter(out)
+	err := writer.Write([]string{
+		"type",
+		"block_index",
+		"block_hash",
+		"currency",
+		"computed",
+		"live",
+		"outcome",
+		"labels",
+		"computed_formatted",
+		"live_formatted",
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, reconciliation := range reconciliations {
+		err := writer.Write([]string{
+			reconciliation.Type,
+			strconv.FormatInt(reconciliation.Block.Index, 10),
+			reconciliation.Block.Hash,
+			reconciliation.Currency.Symbol,
+			reconciliation.Computed,
+			reconciliation.Live,
+			reconciliation.Outcome,
+			strings.Join(reconciliation.Labels, ";"),
+			formatReconciliationAmount(reconciliation.Computed, reconciliation.Currency),
+			formatReconciliationAmount(reconciliation.Live, reconciliation.Currency),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// viewCurrencies prints every registered currency.
+func viewCurrencies(ctx context.Context, cfg viewConfig, args []string, out io.Writer) error {
+	blockStorage, closeStorage, err := openBlockStorage(ctx, cfg, false)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+
+	currencies, err := blockStorage.Currencies(ctx)
+	if err != nil {
+		return err
+	}
+
+	views := []*currencyView{}
+	for _, currency := range currencies {
+		views = append(views, &currencyView{
+			Key:       currency.Key,
+			Currency:  currency.Currency,
+			FirstSeen: currency.FirstSeen,
+			Tracked:   currency.Tracked,
+		})
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(views)
+}
+
+// blockView is a stored block printed by view:block. Pruned
+// is set if its transactions were removed by PRUNE_DEPTH
+// pruning (so it is printed without them).
+type blockView struct {
+	*rosetta.Block
+
+	Pruned bool `json:"pruned,omitempty"`
+}
+
+// viewBlock prints a stored block.
+func viewBlock(ctx context.Context, cfg viewConfig, args []string, out io.Writer) error {
+	identifier := &rosetta.PartialBlockIdentifier{}
+	if viewBlockIndex >= 0 {
+		identifier.Index = &viewBlockIndex
+	}
+	if len(viewBlockHash) > 0 {
+		identifier.Hash = &viewBlockHash
+	}
+
+	blockStorage, closeStorage, err := openBlockStorage(ctx, cfg, false)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+
+	txn := blockStorage.NewDatabaseTransaction(ctx, false)
+	defer txn.Discard(ctx)
+
+	block, err := blockStorage.FindBlock(ctx, txn, identifier)
+	if err != nil {
+		return err
+	}
+
+	view := &blockView{Block: block}
+	_, err = blockStorage.GetUnprunedBlock(ctx, txn, block.BlockIdentifier)
+	switch {
+	case errors.Is(err, storage.ErrBlockPruned):
+		view.Pruned = true
+	case err != nil:
+		return err
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(view)
+}
+
+// viewOrphans prints the orphan archive.
+func viewOrphans(ctx context.Context, cfg viewConfig, args []string, out io.Writer) error {
+	blockStorage, closeStorage, err := openBlockStorage(ctx, cfg, false)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+
+	views := []*orphanView{}
+	for cursor := int64(0); ; {
+		var orphans []*storage.OrphanedBlock
+		orphans, cursor, err = blockStorage.OrphanedBlocks(ctx, cursor, viewReadLimit)
+		if err != nil {
+			return err
+		}
+
+		if len(orphans) == 0 {
+			break
+		}
+
+		for _, orphan := range orphans {
+			view := &orphanView{
+				Block:        orphan.Block.BlockIdentifier,
+				ParentBlock:  orphan.Block.ParentBlockIdentifier,
+				Transactions: len(orphan.Block.Transactions),
+				ReorgHead:    orphan.ReorgHead,
+				ReorgDepth:   orphan.ReorgDepth,
+				OrphanedAt:   orphan.OrphanedAt,
+			}
+			if viewOrphansFull {
+				view.Full = orphan.Block
+			}
+
+			views = append(views, view)
+		}
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(views)
+}
+
+// viewViolations prints the recorded violations.
+func viewViolations(ctx context.Context, cfg viewConfig, args []string, out io.Writer) error {
+	blockStorage, closeStorage, err := openBlockStorage(ctx, cfg, false)
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+
+	views := []*violationView{}
+	for cursor := int64(0); ; {
+		violations, next, err := blockStorage.Violations(ctx, cursor, viewReadLimit)
+		if err != nil {
+			return err
+		}
+
+		if next == cursor {
+			break
+		}
+		cursor = next
+
+		for _, violation := range violations {
+			if len(viewViolationsType) > 0 && violation.Type != viewViolationsType {
+				continue
+			}
+
+			views = append(views, &violationView{
+				Type:        violation.Type,
+				Block:       violation.Block,
+				Transaction: violation.Transaction,
+				Operation:   violation.Operation,
+				Account:     violation.Account,
+				Currency:    violation.Currency,
+				Message:     violation.Message,
+			})
+		}
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(views)
+}
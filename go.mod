@@ -7,6 +7,9 @@ require (
 	github.com/coinbase/rosetta-sdk-go v0.0.1
 	github.com/davecgh/go-spew v1.1.1
 	github.com/dgraph-io/badger v1.6.0
+	github.com/spf13/cobra v1.0.0
 	github.com/stretchr/testify v1.5.1
+	github.com/vmihailenco/msgpack/v4 v4.3.11
 	golang.org/x/sync v0.0.0-20200317015054-43a5402ce75a
+	golang.org/x/time v0.0.0-20191024005414-555d28b269f0
 )
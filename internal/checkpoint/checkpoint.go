@@ -0,0 +1,163 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package checkpoint
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+var (
+	// ErrInvalidSignature is returned when a checkpoints
+	// file is not signed by the trusted key.
+	ErrInvalidSignature = errors.New("Invalid checkpoints signature")
+
+	// ErrCheckpointMismatch is returned when a fetched block
+	// does not match the checkpoint at its index.
+	ErrCheckpointMismatch = errors.New("Block does not match checkpoint")
+)
+
+// File is the format of a checkpoints file. Signature
+// is the hex-encoded ed25519 signature of the JSON
+// encoding of Checkpoints.
+type File struct {
+	Checkpoints []*rosetta.BlockIdentifier `json:"checkpoints"`
+	Signature   string                     `json:"signature"`
+}
+
+// Checkpoints are trusted block identifiers. Blocks at or
+// below the last checkpoint are considered final, so they
+// only need to be checked for hash linkage.
+type Checkpoints struct {
+	hashes map[int64]string
+	last   *rosetta.BlockIdentifier
+}
+
+// Sign returns a File containing checkpoints signed
+// with privateKey.
+func Sign(
+	checkpoints []*rosetta.BlockIdentifier,
+	privateKey ed25519.PrivateKey,
+) (*File, error) {
+	message, err := json.Marshal(checkpoints)
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{
+		Checkpoints: checkpoints,
+		Signature:   hex.EncodeToString(ed25519.Sign(privateKey, message)),
+	}, nil
+}
+
+// Verify returns Checkpoints if the file is signed
+// by publicKey.
+func (f *File) Verify(publicKey ed25519.PublicKey) (*Checkpoints, error) {
+	message, err := json.Marshal(f.Checkpoints)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := hex.DecodeString(f.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+
+	if len(publicKey) != ed25519.PublicKeySize || !ed25519.Verify(publicKey, message, signature) {
+		return nil, ErrInvalidSignature
+	}
+
+	return New(f.Checkpoints), nil
+}
+
+// Load reads a checkpoints file from path and verifies
+// that it is signed by the hex-encoded ed25519 publicKey.
+func Load(path string, publicKey string) (*Checkpoints, error) {
+	key, err := hex.DecodeString(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid public key: %v", ErrInvalidSignature, err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file File
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("unable to parse checkpoints file %s: %w", path, err)
+	}
+
+	return file.Verify(ed25519.PublicKey(key))
+}
+
+// New returns Checkpoints containing the provided
+// block identifiers.
+func New(checkpoints []*rosetta.BlockIdentifier) *Checkpoints {
+	sorted := make([]*rosetta.BlockIdentifier, len(checkpoints))
+	copy(sorted, checkpoints)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Index < sorted[j].Index
+	})
+
+	c := &Checkpoints{
+		hashes: make(map[int64]string, len(sorted)),
+	}
+	for _, checkpoint := range sorted {
+		c.hashes[checkpoint.Index] = checkpoint.Hash
+	}
+
+	if len(sorted) > 0 {
+		c.last = sorted[len(sorted)-1]
+	}
+
+	return c
+}
+
+// Last returns the checkpoint with the largest
+// index or nil if there are no checkpoints.
+func (c *Checkpoints) Last() *rosetta.BlockIdentifier {
+	return c.last
+}
+
+// Trusted returns a boolean indicating if the block
+// at index is at or below the last checkpoint.
+func (c *Checkpoints) Trusted(index int64) bool {
+	return c.last != nil && index <= c.last.Index
+}
+
+// Check returns an error if there is a checkpoint at
+// the index of blockIdentifier with a different hash.
+func (c *Checkpoints) Check(blockIdentifier *rosetta.BlockIdentifier) error {
+	hash, ok := c.hashes[blockIdentifier.Index]
+	if !ok || hash == blockIdentifier.Hash {
+		return nil
+	}
+
+	return fmt.Errorf(
+		"%w: got %s at %d, expected %s",
+		ErrCheckpointMismatch,
+		blockIdentifier.Hash,
+		blockIdentifier.Index,
+		hash,
+	)
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package checkpoint
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+	"github.com/stretchr/testify/assert"
+)
+
+var testCheckpoints = []*rosetta.BlockIdentifier{
+	{
+		Index: 10,
+		Hash:  "block 10",
+	},
+	{
+		Index: 5,
+		Hash:  "block 5",
+	},
+}
+
+func TestSignAndLoad(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+
+	file, err := Sign(testCheckpoints, privateKey)
+	assert.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "checkpoints")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(file *File) string {
+		data, err := json.Marshal(file)
+		assert.NoError(t, err)
+
+		filePath := path.Join(dir, "checkpoints.json")
+		assert.NoError(t, ioutil.WriteFile(filePath, data, 0600))
+		return filePath
+	}
+
+	t.Run("Valid signature", func(t *testing.T) {
+		checkpoints, err := Load(write(file), hex.EncodeToString(publicKey))
+		assert.NoError(t, err)
+		assert.Equal(t, testCheckpoints[0], checkpoints.Last())
+	})
+
+	t.Run("Wrong key", func(t *testing.T) {
+		otherKey, _, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err)
+
+		checkpoints, err := Load(write(file), hex.EncodeToString(otherKey))
+		assert.True(t, errors.Is(err, ErrInvalidSignature))
+		assert.Nil(t, checkpoints)
+	})
+
+	t.Run("Modified checkpoints", func(t *testing.T) {
+		modified := &File{
+			Checkpoints: []*rosetta.BlockIdentifier{
+				{
+					Index: 10,
+					Hash:  "other block 10",
+				},
+			},
+			Signature: file.Signature,
+		}
+
+		checkpoints, err := Load(write(modified), hex.EncodeToString(publicKey))
+		assert.True(t, errors.Is(err, ErrInvalidSignature))
+		assert.Nil(t, checkpoints)
+	})
+}
+
+func TestCheckpoints(t *testing.T) {
+	checkpoints := New(testCheckpoints)
+
+	assert.True(t, checkpoints.Trusted(1))
+	assert.True(t, checkpoints.Trusted(10))
+	assert.False(t, checkpoints.Trusted(11))
+
+	assert.NoError(t, checkpoints.Check(&rosetta.BlockIdentifier{Index: 5, Hash: "block 5"}))
+	assert.NoError(t, checkpoints.Check(&rosetta.BlockIdentifier{Index: 6, Hash: "block 6"}))
+
+	err := checkpoints.Check(&rosetta.BlockIdentifier{Index: 5, Hash: "other block 5"})
+	assert.True(t, errors.Is(err, ErrCheckpointMismatch))
+
+	empty := New(nil)
+	assert.Nil(t, empty.Last())
+	assert.False(t, empty.Trusted(0))
+}
@@ -0,0 +1,291 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package checkpoint
+
+import (
+	"context"
+	"time"
+
+	"github.com/coinbase/rosetta-sdk-go/fetcher"
+	"golang.org/x/sync/errgroup"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+const (
+	// unsafeRetryInterval is the time waited between
+	// attempts to fetch a trusted block.
+	unsafeRetryInterval = time.Second
+)
+
+// Fetcher is the subset of *fetcher.Fetcher methods
+// used by the TrustedFetcher.
+type Fetcher interface {
+	NetworkStatusRetry(
+		ctx context.Context,
+		metadata *map[string]interface{},
+		maxElapsedTime time.Duration,
+		maxRetries uint64,
+	) (*rosetta.NetworkStatusResponse, error)
+
+	BlockRange(
+		ctx context.Context,
+		network *rosetta.NetworkIdentifier,
+		startIndex int64,
+		endIndex int64,
+	) (map[int64]*fetcher.BlockAndLatency, error)
+
+	BlockRetry(
+		ctx context.Context,
+		network *rosetta.NetworkIdentifier,
+		blockIdentifier *rosetta.PartialBlockIdentifier,
+		maxElapsedTime time.Duration,
+		maxRetries uint64,
+	) (*rosetta.Block, error)
+
+	UnsafeBlock(
+		ctx context.Context,
+		network *rosetta.NetworkIdentifier,
+		blockIdentifier *rosetta.PartialBlockIdentifier,
+	) (*rosetta.Block, error)
+}
+
+// TrustedFetcher wraps a Fetcher so that blocks at or below
+// the last checkpoint are fetched without being asserted.
+// Every fetched block at a checkpoint index must match the
+// checkpoint. Hash linkage is still verified by the Syncer.
+type TrustedFetcher struct {
+	Fetcher
+
+	checkpoints *Checkpoints
+	concurrency uint64
+
+	maxElapsedTime time.Duration
+	maxRetries     uint64
+}
+
+// NewTrustedFetcher returns a new TrustedFetcher that
+// fetches up to concurrency trusted blocks at once.
+func NewTrustedFetcher(
+	blockFetcher Fetcher,
+	checkpoints *Checkpoints,
+	concurrency uint64,
+) *TrustedFetcher {
+	if concurrency == 0 {
+		concurrency = 1
+	}
+
+	return &TrustedFetcher{
+		Fetcher:        blockFetcher,
+		checkpoints:    checkpoints,
+		concurrency:    concurrency,
+		maxElapsedTime: fetcher.DefaultElapsedTime,
+		maxRetries:     fetcher.DefaultRetries,
+	}
+}
+
+// SetRetries changes the number of times (and the time
+// spent) retrying a failed request for a trusted block in
+// BlockRange. Untrusted blocks are retried by the wrapped
+// Fetcher. It must be called before any blocks are fetched.
+func (f *TrustedFetcher) SetRetries(maxElapsedTime time.Duration, maxRetries uint64) {
+	f.maxElapsedTime = maxElapsedTime
+	f.maxRetries = maxRetries
+}
+
+// unsafeBlockRetry fetches a block without asserting it,
+// retrying up to maxRetries times (or until maxElapsedTime
+// has been spent retrying) on error.
+func (f *TrustedFetcher) unsafeBlockRetry(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	index int64,
+	maxElapsedTime time.Duration,
+	maxRetries uint64,
+) (*rosetta.Block, error) {
+	deadline := time.Now().Add(maxElapsedTime)
+	for attempt := uint64(0); ; attempt++ {
+		block, err := f.UnsafeBlock(ctx, network, &rosetta.PartialBlockIdentifier{
+			Index: &index,
+		})
+		if err == nil {
+			return block, nil
+		}
+
+		if attempt >= maxRetries || time.Now().Add(unsafeRetryInterval).After(deadline) {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(unsafeRetryInterval):
+		}
+	}
+}
+
+// unsafeBlockRange concurrently fetches the blocks from
+// startIndex to endIndex, inclusive, without asserting them.
+func (f *TrustedFetcher) unsafeBlockRange(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	startIndex int64,
+	endIndex int64,
+) (map[int64]*fetcher.BlockAndLatency, error) {
+	type indexAndBlock struct {
+		index int64
+		block *fetcher.BlockAndLatency
+	}
+
+	indices := make(chan int64)
+	results := make(chan *indexAndBlock)
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		defer close(indices)
+		for i := startIndex; i <= endIndex; i++ {
+			select {
+			case indices <- i:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		return nil
+	})
+
+	for i := uint64(0); i < f.concurrency; i++ {
+		g.Go(func() error {
+			for index := range indices {
+				start := time.Now()
+				block, err := f.unsafeBlockRetry(ctx, network, index, f.maxElapsedTime, f.maxRetries)
+				if err != nil {
+					return err
+				}
+
+				select {
+				case results <- &indexAndBlock{
+					index: index,
+					block: &fetcher.BlockAndLatency{
+						Block:   block,
+						Latency: time.Since(start).Seconds(),
+					},
+				}:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+
+			return nil
+		})
+	}
+
+	go func() {
+		_ = g.Wait()
+		close(results)
+	}()
+
+	// Blocks are keyed by the index requested
+	// because an omitted block is nil.
+	blocks := make(map[int64]*fetcher.BlockAndLatency)
+	for result := range results {
+		blocks[result.index] = result.block
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return blocks, nil
+}
+
+// BlockRange fetches the blocks from startIndex to endIndex,
+// inclusive. Blocks at or below the last checkpoint are not
+// asserted.
+func (f *TrustedFetcher) BlockRange(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	startIndex int64,
+	endIndex int64,
+) (map[int64]*fetcher.BlockAndLatency, error) {
+	blocks := make(map[int64]*fetcher.BlockAndLatency)
+	if f.checkpoints.Trusted(startIndex) {
+		trustedEnd := endIndex
+		if !f.checkpoints.Trusted(trustedEnd) {
+			trustedEnd = f.checkpoints.Last().Index
+		}
+
+		trusted, err := f.unsafeBlockRange(ctx, network, startIndex, trustedEnd)
+		if err != nil {
+			return nil, err
+		}
+
+		blocks = trusted
+		startIndex = trustedEnd + 1
+	}
+
+	if startIndex <= endIndex {
+		untrusted, err := f.Fetcher.BlockRange(ctx, network, startIndex, endIndex)
+		if err != nil {
+			return nil, err
+		}
+
+		for index, block := range untrusted {
+			blocks[index] = block
+		}
+	}
+
+	for _, block := range blocks {
+		if block.Block == nil {
+			continue
+		}
+
+		if err := f.checkpoints.Check(block.Block.BlockIdentifier); err != nil {
+			return nil, err
+		}
+	}
+
+	return blocks, nil
+}
+
+// BlockRetry fetches a single block. Blocks requested by an
+// index at or below the last checkpoint are not asserted.
+func (f *TrustedFetcher) BlockRetry(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	blockIdentifier *rosetta.PartialBlockIdentifier,
+	maxElapsedTime time.Duration,
+	maxRetries uint64,
+) (*rosetta.Block, error) {
+	var block *rosetta.Block
+	var err error
+	if blockIdentifier.Index != nil && f.checkpoints.Trusted(*blockIdentifier.Index) {
+		block, err = f.unsafeBlockRetry(ctx, network, *blockIdentifier.Index, maxElapsedTime, maxRetries)
+	} else {
+		block, err = f.Fetcher.BlockRetry(ctx, network, blockIdentifier, maxElapsedTime, maxRetries)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if block == nil {
+		return nil, nil
+	}
+
+	if err := f.checkpoints.Check(block.BlockIdentifier); err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package checkpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coinbase/rosetta-sdk-go/fetcher"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+	"github.com/stretchr/testify/assert"
+)
+
+// testFetcher returns blocks with the hash "block <index>"
+// and records which indices were asserted.
+type testFetcher struct {
+	mutex    sync.Mutex
+	asserted map[int64]bool
+	unsafe   map[int64]bool
+}
+
+func newTestFetcher() *testFetcher {
+	return &testFetcher{
+		asserted: map[int64]bool{},
+		unsafe:   map[int64]bool{},
+	}
+}
+
+func testBlock(index int64) *rosetta.Block {
+	return &rosetta.Block{
+		BlockIdentifier: &rosetta.BlockIdentifier{
+			Index: index,
+			Hash:  fmt.Sprintf("block %d", index),
+		},
+	}
+}
+
+func (f *testFetcher) NetworkStatusRetry(
+	ctx context.Context,
+	metadata *map[string]interface{},
+	maxElapsedTime time.Duration,
+	maxRetries uint64,
+) (*rosetta.NetworkStatusResponse, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f *testFetcher) BlockRange(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	startIndex int64,
+	endIndex int64,
+) (map[int64]*fetcher.BlockAndLatency, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	blocks := map[int64]*fetcher.BlockAndLatency{}
+	for i := startIndex; i <= endIndex; i++ {
+		f.asserted[i] = true
+		blocks[i] = &fetcher.BlockAndLatency{Block: testBlock(i)}
+	}
+
+	return blocks, nil
+}
+
+func (f *testFetcher) BlockRetry(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	blockIdentifier *rosetta.PartialBlockIdentifier,
+	maxElapsedTime time.Duration,
+	maxRetries uint64,
+) (*rosetta.Block, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.asserted[*blockIdentifier.Index] = true
+	return testBlock(*blockIdentifier.Index), nil
+}
+
+func (f *testFetcher) UnsafeBlock(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	blockIdentifier *rosetta.PartialBlockIdentifier,
+) (*rosetta.Block, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.unsafe[*blockIdentifier.Index] = true
+	return testBlock(*blockIdentifier.Index), nil
+}
+
+func TestTrustedFetcher(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Range spanning the last checkpoint", func(t *testing.T) {
+		f := newTestFetcher()
+		trusted := NewTrustedFetcher(f, New([]*rosetta.BlockIdentifier{testBlock(5).BlockIdentifier}), 2)
+
+		blocks, err := trusted.BlockRange(ctx, nil, 1, 8)
+		assert.NoError(t, err)
+		assert.Len(t, blocks, 8)
+		assert.Equal(t, map[int64]bool{1: true, 2: true, 3: true, 4: true, 5: true}, f.unsafe)
+		assert.Equal(t, map[int64]bool{6: true, 7: true, 8: true}, f.asserted)
+	})
+
+	t.Run("Single blocks", func(t *testing.T) {
+		f := newTestFetcher()
+		trusted := NewTrustedFetcher(f, New([]*rosetta.BlockIdentifier{testBlock(5).BlockIdentifier}), 2)
+
+		for _, index := range []int64{5, 6} {
+			block, err := trusted.BlockRetry(
+				ctx,
+				nil,
+				&rosetta.PartialBlockIdentifier{Index: &index},
+				fetcher.DefaultElapsedTime,
+				fetcher.DefaultRetries,
+			)
+			assert.NoError(t, err)
+			assert.Equal(t, testBlock(index), block)
+		}
+
+		assert.Equal(t, map[int64]bool{5: true}, f.unsafe)
+		assert.Equal(t, map[int64]bool{6: true}, f.asserted)
+	})
+
+	t.Run("Checkpoint mismatch", func(t *testing.T) {
+		trusted := NewTrustedFetcher(newTestFetcher(), New([]*rosetta.BlockIdentifier{
+			{
+				Index: 3,
+				Hash:  "other block 3",
+			},
+		}), 2)
+
+		blocks, err := trusted.BlockRange(ctx, nil, 1, 8)
+		assert.True(t, errors.Is(err, ErrCheckpointMismatch))
+		assert.Nil(t, blocks)
+
+		index := int64(3)
+		block, err := trusted.BlockRetry(
+			ctx,
+			nil,
+			&rosetta.PartialBlockIdentifier{Index: &index},
+			fetcher.DefaultElapsedTime,
+			fetcher.DefaultRetries,
+		)
+		assert.True(t, errors.Is(err, ErrCheckpointMismatch))
+		assert.Nil(t, block)
+	})
+}
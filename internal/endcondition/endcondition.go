@@ -0,0 +1,166 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package endcondition
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrReached is returned by Monitor.Run once
+// an end condition has been met.
+var ErrReached = errors.New("end condition reached")
+
+// ErrHealthy is returned by Monitor.Run once the
+// Healthy condition has been met. It wraps ErrReached.
+var ErrHealthy = fmt.Errorf("%w: healthy", ErrReached)
+
+// Progress reports the reconciliation progress
+// of the validator.
+type Progress interface {
+	ReconciledAccounts() int
+	ReconciliationCoverage() float64
+}
+
+// Health reports whether the validator has caught up
+// (ex: every network is at the tip of the node and no
+// accounts are pending reconciliation).
+type Health interface {
+	Healthy() bool
+}
+
+// Conditions end validation once any of them is met.
+// A condition with a zero value is disabled.
+type Conditions struct {
+	// Duration is the time validation runs for.
+	Duration time.Duration
+
+	// ReconciledAccounts is the number of accounts (and
+	// currencies) successfully reconciled at least once.
+	ReconciledAccounts int
+
+	// ReconciliationCoverage is the fraction of the accounts
+	// (and currencies) seen in operations that have been
+	// successfully reconciled at least once.
+	ReconciliationCoverage float64
+
+	// Healthy ends validation once Health reports that
+	// the validator has caught up. Because any failure stops
+	// validation, this is evidence that validation succeeded
+	// up to the tip.
+	Healthy bool
+}
+
+// Enabled returns true if any condition is enabled.
+func (c Conditions) Enabled() bool {
+	return c.Duration > 0 || c.reconciliationEnabled() || c.Healthy
+}
+
+// reconciliationEnabled returns true if any condition
+// on the reconciliation progress is enabled.
+func (c Conditions) reconciliationEnabled() bool {
+	return c.ReconciledAccounts > 0 || c.ReconciliationCoverage > 0
+}
+
+// Monitor periodically evaluates Conditions against
+// the time elapsed since it was created and the
+// reconciliation progress.
+type Monitor struct {
+	conditions Conditions
+	progress   Progress
+	health     Health
+	start      time.Time
+	now        func() time.Time
+}
+
+// NewMonitor returns a new Monitor. progress may only be
+// nil if no condition on the reconciliation progress is
+// enabled (ex: if the Rosetta Server does not support
+// balance lookups). health may only be nil if the Healthy
+// condition is not enabled.
+func NewMonitor(conditions Conditions, progress Progress, health Health) (*Monitor, error) {
+	if conditions.reconciliationEnabled() && progress == nil {
+		return nil, errors.New("reconciliation end conditions require reconciliation")
+	}
+
+	if conditions.Healthy && health == nil {
+		return nil, errors.New("the healthy end condition requires health")
+	}
+
+	if conditions.ReconciliationCoverage < 0 || conditions.ReconciliationCoverage > 1 {
+		return nil, fmt.Errorf(
+			"reconciliation coverage %f must be between 0 and 1",
+			conditions.ReconciliationCoverage,
+		)
+	}
+
+	return &Monitor{
+		conditions: conditions,
+		progress:   progress,
+		health:     health,
+		start:      time.Now(),
+		now:        time.Now,
+	}, nil
+}
+
+// Check returns an error wrapping ErrReached that
+// describes the first condition met, if any.
+func (m *Monitor) Check() error {
+	c := m.conditions
+	if elapsed := m.now().Sub(m.start); c.Duration > 0 && elapsed >= c.Duration {
+		return fmt.Errorf("%w: ran for %s", ErrReached, c.Duration)
+	}
+
+	if c.Healthy && m.health.Healthy() {
+		return ErrHealthy
+	}
+
+	if !c.reconciliationEnabled() {
+		return nil
+	}
+
+	reconciled := m.progress.ReconciledAccounts()
+	if c.ReconciledAccounts > 0 && reconciled >= c.ReconciledAccounts {
+		return fmt.Errorf("%w: reconciled %d accounts", ErrReached, reconciled)
+	}
+
+	coverage := m.progress.ReconciliationCoverage()
+	if c.ReconciliationCoverage > 0 && coverage >= c.ReconciliationCoverage {
+		return fmt.Errorf("%w: reconciliation coverage %.1f%%", ErrReached, coverage*100)
+	}
+
+	return nil
+}
+
+// Run checks the conditions every interval until one is
+// met (returning an error wrapping ErrReached) or the
+// context is canceled.
+func (m *Monitor) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := m.Check(); err != nil {
+				return err
+			}
+		}
+	}
+}
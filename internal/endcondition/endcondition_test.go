@@ -0,0 +1,158 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package endcondition
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type staticProgress struct {
+	reconciled int
+	coverage   float64
+}
+
+func (p *staticProgress) ReconciledAccounts() int {
+	return p.reconciled
+}
+
+func (p *staticProgress) ReconciliationCoverage() float64 {
+	return p.coverage
+}
+
+type staticHealth bool
+
+func (h staticHealth) Healthy() bool {
+	return bool(h)
+}
+
+func TestNewMonitor(t *testing.T) {
+	t.Run("Reconciliation conditions without progress", func(t *testing.T) {
+		monitor, err := NewMonitor(Conditions{ReconciledAccounts: 10}, nil, nil)
+		assert.Error(t, err)
+		assert.Nil(t, monitor)
+	})
+
+	t.Run("Invalid coverage", func(t *testing.T) {
+		monitor, err := NewMonitor(Conditions{ReconciliationCoverage: 95}, &staticProgress{}, nil)
+		assert.Error(t, err)
+		assert.Nil(t, monitor)
+	})
+
+	t.Run("Healthy without health", func(t *testing.T) {
+		monitor, err := NewMonitor(Conditions{Healthy: true}, nil, nil)
+		assert.Error(t, err)
+		assert.Nil(t, monitor)
+	})
+
+	t.Run("Duration without progress", func(t *testing.T) {
+		monitor, err := NewMonitor(Conditions{Duration: time.Hour}, nil, nil)
+		assert.NoError(t, err)
+		assert.NoError(t, monitor.Check())
+	})
+}
+
+func TestCheck(t *testing.T) {
+	var tests = map[string]struct {
+		conditions Conditions
+		elapsed    time.Duration
+		progress   *staticProgress
+		healthy    bool
+
+		reached string
+	}{
+		"none met": {
+			conditions: Conditions{
+				Duration:               2 * time.Hour,
+				ReconciledAccounts:     1000,
+				ReconciliationCoverage: 0.95,
+				Healthy:                true,
+			},
+			elapsed:  time.Hour,
+			progress: &staticProgress{reconciled: 999, coverage: 0.9},
+		},
+		"duration": {
+			conditions: Conditions{Duration: 2 * time.Hour},
+			elapsed:    2 * time.Hour,
+			progress:   &staticProgress{},
+			reached:    "ran for 2h0m0s",
+		},
+		"reconciled accounts": {
+			conditions: Conditions{ReconciledAccounts: 1000},
+			progress:   &staticProgress{reconciled: 1000},
+			reached:    "reconciled 1000 accounts",
+		},
+		"reconciliation coverage": {
+			conditions: Conditions{ReconciliationCoverage: 0.95},
+			progress:   &staticProgress{reconciled: 19, coverage: 0.95},
+			reached:    "reconciliation coverage 95.0%",
+		},
+		"healthy": {
+			conditions: Conditions{Healthy: true},
+			progress:   &staticProgress{},
+			healthy:    true,
+			reached:    "healthy",
+		},
+		"disabled conditions": {
+			progress: &staticProgress{reconciled: 1000, coverage: 1},
+			elapsed:  time.Hour,
+			healthy:  true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			monitor, err := NewMonitor(test.conditions, test.progress, staticHealth(test.healthy))
+			assert.NoError(t, err)
+
+			start := monitor.start
+			monitor.now = func() time.Time {
+				return start.Add(test.elapsed)
+			}
+
+			err = monitor.Check()
+			if len(test.reached) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.True(t, errors.Is(err, ErrReached))
+			assert.Equal(t, test.healthy && test.conditions.Healthy, errors.Is(err, ErrHealthy))
+			assert.Contains(t, err.Error(), test.reached)
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	progress := &staticProgress{}
+	monitor, err := NewMonitor(Conditions{ReconciledAccounts: 1}, progress, nil)
+	assert.NoError(t, err)
+
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.NoError(t, monitor.Run(ctx, time.Millisecond))
+	})
+
+	t.Run("Reached", func(t *testing.T) {
+		progress.reconciled = 1
+		err := monitor.Run(context.Background(), time.Millisecond)
+		assert.True(t, errors.Is(err, ErrReached))
+	})
+}
@@ -0,0 +1,286 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+const (
+	// blockEventsPath is the endpoint of the Rosetta
+	// Server that returns block events.
+	blockEventsPath = "/events/blocks"
+
+	// blockEventsLimit is the maximum number of
+	// block events requested at a time.
+	blockEventsLimit = 100
+)
+
+const (
+	// BlockAdded is the Type of a BlockEvent sent
+	// when a block is added to the chain.
+	BlockAdded = "block_added"
+
+	// BlockRemoved is the Type of a BlockEvent sent
+	// when a block is removed from the chain (ex:
+	// in a reorg).
+	BlockRemoved = "block_removed"
+)
+
+// ErrUnsupported is returned when the Rosetta Server
+// does not implement block events.
+var ErrUnsupported = errors.New("Rosetta Server does not support block events")
+
+// ErrInvalidEvent is returned when the Rosetta Server
+// returns a malformed block events response.
+var ErrInvalidEvent = errors.New("invalid block event")
+
+// BlockEvent is a change to the chain of the node,
+// identified by its sequence number in the block events
+// of the Rosetta Server.
+type BlockEvent struct {
+	Sequence        int64                    `json:"sequence"`
+	BlockIdentifier *rosetta.BlockIdentifier `json:"block_identifier"`
+	Type            string                   `json:"type"`
+}
+
+// blockEventsRequest is the request body of
+// /events/blocks. If Offset is not set, the most
+// recent events are returned.
+type blockEventsRequest struct {
+	NetworkIdentifier *rosetta.NetworkIdentifier `json:"network_identifier"`
+	Offset            *int64                     `json:"offset,omitempty"`
+	Limit             int64                      `json:"limit"`
+}
+
+// blockEventsResponse is the response body of
+// /events/blocks. MaxSequence is the sequence number
+// of the most recent event.
+type blockEventsResponse struct {
+	MaxSequence *int64        `json:"max_sequence"`
+	Events      []*BlockEvent `json:"events"`
+}
+
+// Stream polls the block events of a network and
+// notifies a Syncer at tip of new events, so that it
+// syncs new blocks once they are added instead of polling
+// the node for them. While block events can't be fetched,
+// the Syncer polls the node as usual.
+type Stream struct {
+	serverAddr   string
+	client       *http.Client
+	network      *rosetta.NetworkIdentifier
+	pollInterval time.Duration
+	retryDelay   time.Duration
+
+	// next is the sequence number of the next
+	// event (-1 until events are first fetched).
+	next int64
+
+	connected int32
+	events    chan struct{}
+}
+
+// NewStream returns a new Stream that fetches new block
+// events every pollInterval. After fetching them fails,
+// they are fetched again once retryDelay has elapsed.
+func NewStream(
+	serverAddr string,
+	client *http.Client,
+	network *rosetta.NetworkIdentifier,
+	pollInterval time.Duration,
+	retryDelay time.Duration,
+) *Stream {
+	return &Stream{
+		serverAddr:   serverAddr,
+		client:       client,
+		network:      network,
+		pollInterval: pollInterval,
+		retryDelay:   retryDelay,
+		next:         -1,
+		events:       make(chan struct{}, 1),
+	}
+}
+
+// Connected returns a boolean indicating if block
+// events are currently being received.
+func (s *Stream) Connected() bool {
+	return atomic.LoadInt32(&s.connected) == 1
+}
+
+// Events returns a channel that receives a value after
+// new block events are received and whenever the Stream
+// connects or disconnects. Events not yet received are
+// coalesced.
+func (s *Stream) Events() <-chan struct{} {
+	return s.events
+}
+
+// notify sends a value on the events channel
+// unless one is already waiting to be received.
+func (s *Stream) notify() {
+	select {
+	case s.events <- struct{}{}:
+	default:
+	}
+}
+
+// fetch fetches up to blockEventsLimit block events,
+// starting at offset (or the most recent events if
+// offset is nil).
+func (s *Stream) fetch(ctx context.Context, offset *int64) (*blockEventsResponse, error) {
+	body, err := json.Marshal(&blockEventsRequest{
+		NetworkIdentifier: s.network,
+		Offset:            offset,
+		Limit:             blockEventsLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(
+		http.MethodPost,
+		s.serverAddr+blockEventsPath,
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusNotImplemented:
+		return nil, ErrUnsupported
+	default:
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var response blockEventsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+
+	if response.MaxSequence == nil {
+		return nil, fmt.Errorf("%w: max_sequence is missing", ErrInvalidEvent)
+	}
+
+	for _, event := range response.Events {
+		if event == nil || event.BlockIdentifier == nil {
+			return nil, fmt.Errorf("%w: block_identifier is missing", ErrInvalidEvent)
+		}
+
+		if event.Type != BlockAdded && event.Type != BlockRemoved {
+			return nil, fmt.Errorf("%w: %d has type %q", ErrInvalidEvent, event.Sequence, event.Type)
+		}
+	}
+
+	return &response, nil
+}
+
+// poll fetches the block events after the last received
+// event (or, initially, the most recent sequence number)
+// and notifies if there are any. It returns true if more
+// events can be fetched immediately.
+func (s *Stream) poll(ctx context.Context) (bool, error) {
+	var offset *int64
+	if s.next >= 0 {
+		offset = &s.next
+	}
+
+	response, err := s.fetch(ctx, offset)
+	if err != nil {
+		return false, err
+	}
+
+	// Blocks may have been added before
+	// events were first received.
+	if atomic.SwapInt32(&s.connected, 1) == 0 {
+		log.Printf("Receiving block events\n")
+		s.notify()
+	}
+
+	// Events before the first poll are skipped, as are
+	// those of a Rosetta Server whose sequence restarted.
+	maxSequence := *response.MaxSequence
+	if s.next < 0 || maxSequence < s.next-1 {
+		s.next = maxSequence + 1
+		s.notify()
+		return false, nil
+	}
+
+	if len(response.Events) == 0 {
+		return false, nil
+	}
+
+	s.next = response.Events[len(response.Events)-1].Sequence + 1
+	s.notify()
+	return s.next <= maxSequence, nil
+}
+
+// Run polls block events until ctx is done. If the
+// Rosetta Server does not support block events, Run
+// returns nil and the Syncer keeps polling the node.
+func (s *Stream) Run(ctx context.Context) error {
+	defer atomic.StoreInt32(&s.connected, 0)
+
+	for {
+		more, err := s.poll(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if errors.Is(err, ErrUnsupported) {
+			log.Printf("%v, polling for new blocks\n", err)
+			return nil
+		}
+
+		delay := s.pollInterval
+		if err != nil {
+			// Wake the Syncer so that it polls the node
+			// until block events are received again.
+			if atomic.SwapInt32(&s.connected, 0) == 1 {
+				s.notify()
+			}
+
+			log.Printf("Unable to fetch block events (%v), retrying in %s\n", err, s.retryDelay)
+			delay = s.retryDelay
+		} else if more {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
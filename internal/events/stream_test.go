@@ -0,0 +1,216 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var network = &rosetta.NetworkIdentifier{
+	Blockchain: "blockchain",
+	Network:    "network",
+}
+
+// eventsServer serves /events/blocks
+// from a list of block events.
+type eventsServer struct {
+	t *testing.T
+
+	mutex   sync.Mutex
+	events  []*BlockEvent
+	offsets []*int64
+}
+
+func (s *eventsServer) add(index int64, eventType string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.events = append(s.events, &BlockEvent{
+		Sequence:        int64(len(s.events)),
+		BlockIdentifier: &rosetta.BlockIdentifier{Index: index, Hash: fmt.Sprintf("%d", index)},
+		Type:            eventType,
+	})
+}
+
+func (s *eventsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	assert.Equal(s.t, blockEventsPath, r.URL.Path)
+
+	var request blockEventsRequest
+	assert.NoError(s.t, json.NewDecoder(r.Body).Decode(&request))
+	assert.Equal(s.t, network, request.NetworkIdentifier)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.offsets = append(s.offsets, request.Offset)
+	start := int64(len(s.events)) - request.Limit
+	if request.Offset != nil {
+		start = *request.Offset
+	}
+	if start < 0 {
+		start = 0
+	}
+
+	end := start + request.Limit
+	if end > int64(len(s.events)) {
+		end = int64(len(s.events))
+	}
+
+	maxSequence := int64(len(s.events)) - 1
+	events := []*BlockEvent{}
+	if start < end {
+		events = s.events[start:end]
+	}
+
+	assert.NoError(s.t, json.NewEncoder(w).Encode(&blockEventsResponse{
+		MaxSequence: &maxSequence,
+		Events:      events,
+	}))
+}
+
+func int64Pointer(value int64) *int64 {
+	return &value
+}
+
+func TestStream(t *testing.T) {
+	t.Run("Events are received", func(t *testing.T) {
+		handler := &eventsServer{t: t}
+		handler.add(1, BlockAdded)
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		ctx := context.Background()
+		stream := NewStream(server.URL, &http.Client{}, network, time.Hour, time.Hour)
+		assert.False(t, stream.Connected())
+
+		// The first poll starts after the latest event.
+		more, err := stream.poll(ctx)
+		assert.NoError(t, err)
+		assert.False(t, more)
+		assert.True(t, stream.Connected())
+		<-stream.Events()
+
+		more, err = stream.poll(ctx)
+		assert.NoError(t, err)
+		assert.False(t, more)
+		assert.Len(t, stream.Events(), 0)
+
+		handler.add(2, BlockAdded)
+		handler.add(2, BlockRemoved)
+		more, err = stream.poll(ctx)
+		assert.NoError(t, err)
+		assert.False(t, more)
+		<-stream.Events()
+
+		assert.Equal(t, []*int64{nil, int64Pointer(1), int64Pointer(1)}, handler.offsets)
+	})
+
+	t.Run("More events than the limit", func(t *testing.T) {
+		handler := &eventsServer{t: t}
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		ctx := context.Background()
+		stream := NewStream(server.URL, &http.Client{}, network, time.Hour, time.Hour)
+		_, err := stream.poll(ctx)
+		assert.NoError(t, err)
+
+		for i := int64(0); i < blockEventsLimit+1; i++ {
+			handler.add(i, BlockAdded)
+		}
+
+		more, err := stream.poll(ctx)
+		assert.NoError(t, err)
+		assert.True(t, more)
+
+		more, err = stream.poll(ctx)
+		assert.NoError(t, err)
+		assert.False(t, more)
+		assert.Equal(t, int64(blockEventsLimit+1), stream.next)
+	})
+
+	t.Run("Run", func(t *testing.T) {
+		server := httptest.NewServer(&eventsServer{t: t})
+		defer server.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		stream := NewStream(server.URL, &http.Client{}, network, time.Millisecond, time.Hour)
+		done := make(chan error)
+		go func() {
+			done <- stream.Run(ctx)
+		}()
+
+		// Notified once events are first received.
+		<-stream.Events()
+		assert.True(t, stream.Connected())
+
+		cancel()
+		assert.NoError(t, <-done)
+		assert.False(t, stream.Connected())
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		for _, status := range []int{http.StatusNotFound, http.StatusNotImplemented} {
+			status := status
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(status)
+			}))
+
+			stream := NewStream(server.URL, &http.Client{}, network, time.Hour, time.Hour)
+			_, err := stream.poll(context.Background())
+			assert.True(t, errors.Is(err, ErrUnsupported))
+
+			// The Syncer keeps polling the node.
+			assert.NoError(t, stream.Run(context.Background()))
+			assert.False(t, stream.Connected())
+			server.Close()
+		}
+	})
+
+	t.Run("Server error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		stream := NewStream(server.URL, &http.Client{}, network, time.Hour, time.Hour)
+		_, err := stream.poll(context.Background())
+		assert.EqualError(t, err, "unexpected status 500")
+		assert.False(t, stream.Connected())
+	})
+
+	t.Run("Invalid event", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"max_sequence":3,"events":[{"sequence":3,"type":"block_added"}]}`))
+		}))
+		defer server.Close()
+
+		stream := NewStream(server.URL, &http.Client{}, network, time.Hour, time.Hour)
+		_, err := stream.poll(context.Background())
+		assert.True(t, errors.Is(err, ErrInvalidEvent))
+	})
+}
@@ -0,0 +1,259 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package generator
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coinbase/rosetta-sdk-go/fetcher"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+const (
+	// OperationType is the type of every
+	// generated operation.
+	OperationType = "Transfer"
+
+	// OperationStatus is the status of every
+	// generated operation. It is successful.
+	OperationStatus = "SUCCESS"
+
+	// genesisIndex is the index of the generated
+	// genesis block.
+	genesisIndex = 0
+)
+
+// Currency is the currency of every generated amount.
+var Currency = &rosetta.Currency{
+	Symbol:   "SYN",
+	Decimals: 0,
+}
+
+// Config determines the shape of generated blocks.
+type Config struct {
+	// Height is the index of the last block
+	// on the generated chain.
+	Height int64
+
+	TransactionsPerBlock     int
+	OperationsPerTransaction int
+
+	// Accounts is the number of distinct accounts
+	// that operations are applied to.
+	Accounts int
+
+	// ReorgRate is the probability (in [0, 1]) that the
+	// first fetch of a block orphans its ReorgDepth
+	// predecessors.
+	ReorgRate  float64
+	ReorgDepth int64
+
+	// Seed makes generated chains reproducible.
+	Seed int64
+}
+
+// Generator deterministically generates a synthetic chain
+// so that the Syncer and BlockStorage can be exercised
+// without a live Rosetta Server. It implements the subset
+// of *fetcher.Fetcher methods used by the Syncer.
+//
+// Every operation credits an account, so balances never
+// become negative regardless of reorgs.
+type Generator struct {
+	config Config
+
+	mutex sync.Mutex
+	rand  *rand.Rand
+
+	// versions is incremented for an index each time
+	// the block at the index is orphaned.
+	versions map[int64]int64
+
+	// maxFetched is the largest index fetched. A reorg
+	// can only be triggered by fetching a new block.
+	maxFetched int64
+}
+
+// New returns a new Generator.
+func New(config Config) *Generator {
+	if config.ReorgDepth < 1 {
+		config.ReorgDepth = 1
+	}
+
+	if config.Accounts < 1 {
+		config.Accounts = 1
+	}
+
+	return &Generator{
+		config:     config,
+		rand:       rand.New(rand.NewSource(config.Seed)),
+		versions:   map[int64]int64{},
+		maxFetched: genesisIndex,
+	}
+}
+
+// blockIdentifier returns the identifier of the
+// current block at index. The caller must hold
+// the mutex.
+func (g *Generator) blockIdentifier(index int64) *rosetta.BlockIdentifier {
+	return &rosetta.BlockIdentifier{
+		Index: index,
+		Hash:  fmt.Sprintf("block-%d-%d", index, g.versions[index]),
+	}
+}
+
+// NetworkStatus returns the status of the generated
+// network, including the options needed to construct
+// an asserter.Asserter.
+func (g *Generator) NetworkStatus() *rosetta.NetworkStatusResponse {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return &rosetta.NetworkStatusResponse{
+		NetworkStatus: &rosetta.NetworkStatus{
+			NetworkIdentifier: &rosetta.PartialNetworkIdentifier{
+				Blockchain: "synthetic",
+				Network:    "synthetic",
+			},
+			NetworkInformation: &rosetta.NetworkInformation{
+				CurrentBlockIdentifier: g.blockIdentifier(g.config.Height),
+				GenesisBlockIdentifier: g.blockIdentifier(genesisIndex),
+			},
+		},
+		Options: &rosetta.Options{
+			OperationStatuses: []*rosetta.OperationStatus{
+				{
+					Status:     OperationStatus,
+					Successful: true,
+				},
+			},
+			OperationTypes: []string{OperationType},
+		},
+	}
+}
+
+// Block returns the current block at index. The first
+// time a block is fetched, it may orphan its predecessors
+// (depending on the ReorgRate).
+func (g *Generator) Block(index int64) *rosetta.Block {
+	g.mutex.Lock()
+	if index > g.maxFetched {
+		g.maxFetched = index
+		if index-g.config.ReorgDepth > genesisIndex && g.rand.Float64() < g.config.ReorgRate {
+			for i := index - g.config.ReorgDepth; i < index; i++ {
+				g.versions[i]++
+			}
+		}
+	}
+
+	blockIdentifier := g.blockIdentifier(index)
+	parentBlockIdentifier := blockIdentifier
+	if index > genesisIndex {
+		parentBlockIdentifier = g.blockIdentifier(index - 1)
+	}
+	version := g.versions[index]
+	g.mutex.Unlock()
+
+	// Each version of a block is generated from its own
+	// source so that fetching it again returns the same
+	// block.
+	source := rand.New(rand.NewSource(g.config.Seed ^ (index << 16) ^ version))
+	transactions := make([]*rosetta.Transaction, g.config.TransactionsPerBlock)
+	for i := range transactions {
+		operations := make([]*rosetta.Operation, g.config.OperationsPerTransaction)
+		for j := range operations {
+			operations[j] = &rosetta.Operation{
+				OperationIdentifier: &rosetta.OperationIdentifier{
+					Index: int64(j),
+				},
+				Type:   OperationType,
+				Status: OperationStatus,
+				Account: &rosetta.AccountIdentifier{
+					Address: "account-" + strconv.Itoa(source.Intn(g.config.Accounts)),
+				},
+				Amount: &rosetta.Amount{
+					Value:    strconv.Itoa(source.Intn(1000) + 1),
+					Currency: Currency,
+				},
+			}
+		}
+
+		transactions[i] = &rosetta.Transaction{
+			TransactionIdentifier: &rosetta.TransactionIdentifier{
+				Hash: fmt.Sprintf("%s-tx-%d", blockIdentifier.Hash, i),
+			},
+			Operations: operations,
+		}
+	}
+
+	return &rosetta.Block{
+		BlockIdentifier:       blockIdentifier,
+		ParentBlockIdentifier: parentBlockIdentifier,
+		Timestamp:             index * 1000,
+		Transactions:          transactions,
+	}
+}
+
+// NetworkStatusRetry returns NetworkStatus.
+func (g *Generator) NetworkStatusRetry(
+	ctx context.Context,
+	metadata *map[string]interface{},
+	maxElapsedTime time.Duration,
+	maxRetries uint64,
+) (*rosetta.NetworkStatusResponse, error) {
+	return g.NetworkStatus(), nil
+}
+
+// BlockRange returns the blocks from startIndex
+// to endIndex, inclusive.
+func (g *Generator) BlockRange(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	startIndex int64,
+	endIndex int64,
+) (map[int64]*fetcher.BlockAndLatency, error) {
+	blocks := make(map[int64]*fetcher.BlockAndLatency, endIndex-startIndex+1)
+	for i := startIndex; i <= endIndex; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		blocks[i] = &fetcher.BlockAndLatency{Block: g.Block(i)}
+	}
+
+	return blocks, nil
+}
+
+// BlockRetry returns the block at the index
+// in blockIdentifier.
+func (g *Generator) BlockRetry(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	blockIdentifier *rosetta.PartialBlockIdentifier,
+	maxElapsedTime time.Duration,
+	maxRetries uint64,
+) (*rosetta.Block, error) {
+	if blockIdentifier.Index == nil {
+		return nil, fmt.Errorf("synthetic blocks can only be fetched by index")
+	}
+
+	return g.Block(*blockIdentifier.Index), nil
+}
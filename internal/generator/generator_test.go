@@ -0,0 +1,85 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package generator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coinbase/rosetta-sdk-go/fetcher"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlock(t *testing.T) {
+	g := New(Config{
+		Height:                   10,
+		TransactionsPerBlock:     3,
+		OperationsPerTransaction: 2,
+		Accounts:                 5,
+		Seed:                     1,
+	})
+
+	block := g.Block(2)
+	assert.Equal(t, int64(2), block.BlockIdentifier.Index)
+	assert.Equal(t, g.Block(1).BlockIdentifier, block.ParentBlockIdentifier)
+	assert.Len(t, block.Transactions, 3)
+	for _, tx := range block.Transactions {
+		assert.Len(t, tx.Operations, 2)
+	}
+
+	// Fetching a block again returns the same block.
+	assert.Equal(t, block, g.Block(2))
+
+	genesis := g.Block(0)
+	assert.Equal(t, genesis.BlockIdentifier, genesis.ParentBlockIdentifier)
+
+	status := g.NetworkStatus()
+	assert.Equal(t, int64(10), status.NetworkStatus.NetworkInformation.CurrentBlockIdentifier.Index)
+	assert.Equal(t, genesis.BlockIdentifier, status.NetworkStatus.NetworkInformation.GenesisBlockIdentifier)
+}
+
+func TestReorg(t *testing.T) {
+	ctx := context.Background()
+	g := New(Config{
+		Height:     10,
+		Accounts:   1,
+		ReorgDepth: 2,
+	})
+
+	blocks, err := g.BlockRange(ctx, nil, 1, 3)
+	assert.NoError(t, err)
+	g.config.ReorgRate = 1
+
+	// Fetching block 4 orphans blocks 2 and 3.
+	block4, err := g.BlockRetry(
+		ctx,
+		nil,
+		&rosetta.PartialBlockIdentifier{Index: &[]int64{4}[0]},
+		fetcher.DefaultElapsedTime,
+		fetcher.DefaultRetries,
+	)
+	assert.NoError(t, err)
+	assert.NotEqual(t, blocks[3].Block.BlockIdentifier, block4.ParentBlockIdentifier)
+
+	block3 := g.Block(3)
+	assert.Equal(t, block3.BlockIdentifier, block4.ParentBlockIdentifier)
+	assert.NotEqual(t, blocks[2].Block.BlockIdentifier, block3.ParentBlockIdentifier)
+	assert.Equal(t, blocks[1].Block.BlockIdentifier, g.Block(2).ParentBlockIdentifier)
+
+	// Fetching a block again does not cause another reorg.
+	assert.Equal(t, block4, g.Block(4))
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/coinbase/rosetta-validator/internal/metrics"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+// ChainHaltedAlert is the Type of the Alert raised when
+// the timestamps of consecutive blocks are further apart
+// than the halt threshold.
+const ChainHaltedAlert = "chain_halted"
+
+// maxQueuedHaltAlerts is the number of alerts waiting
+// to be posted before further alerts are only logged.
+const maxQueuedHaltAlerts = 100
+
+// HaltDetector raises an alert when the gap between the
+// timestamps of a block and its parent exceeds a threshold
+// (ex: 30 minutes on a chain that produces a block every
+// 15 seconds). Unlike the StallDetector, this notices
+// consensus-level halts (including past halts found while
+// syncing) whether or not the tip of the node is advancing.
+// The alert is logged, counted in the ChainHalts metric, and
+// posted to the alert webhook (if any) by Run.
+type HaltDetector struct {
+	threshold time.Duration
+	alert     *Webhook
+	sink      metrics.Sink
+
+	alerts chan *Alert
+	now    func() time.Time
+}
+
+// NewHaltDetector returns a new HaltDetector.
+// alert may be nil.
+func NewHaltDetector(
+	threshold time.Duration,
+	alert *Webhook,
+	sink metrics.Sink,
+) *HaltDetector {
+	return &HaltDetector{
+		threshold: threshold,
+		alert:     alert,
+		sink:      sink,
+		alerts:    make(chan *Alert, maxQueuedHaltAlerts),
+		now:       time.Now,
+	}
+}
+
+// ObserveBlockGap raises an alert if gap (the time between
+// the timestamps of block and its parent) exceeds the
+// threshold. It is called by the Syncer for each added
+// block and does not wait for the alert to be posted.
+func (d *HaltDetector) ObserveBlockGap(block *rosetta.BlockIdentifier, gap time.Duration) {
+	if gap <= d.threshold {
+		return
+	}
+
+	log.Printf("Chain halted for %s before block %+v\n", gap, block)
+	d.sink.IncrCounter(metrics.ChainHalts, 1)
+	if d.alert == nil {
+		return
+	}
+
+	select {
+	case d.alerts <- &Alert{
+		Type:    ChainHaltedAlert,
+		Message: "block timestamp gap exceeds threshold",
+		Time:    d.now(),
+		Block:   block,
+		Gap:     gap.String(),
+	}:
+	default:
+		log.Printf("Unable to queue chain halt alert for block %+v\n", block)
+	}
+}
+
+// Run posts queued alerts to the alert webhook until
+// the context is canceled. Webhook failures are logged.
+func (d *HaltDetector) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case alert := <-d.alerts:
+			if err := d.alert.Post(ctx, alert); err != nil {
+				log.Printf("Unable to send chain halt alert %v\n", err)
+			}
+		}
+	}
+}
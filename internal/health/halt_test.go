@@ -0,0 +1,75 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coinbase/rosetta-validator/internal/metrics"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// counterSink records the total of each counter.
+type counterSink struct {
+	metrics.NoOpSink
+	counters map[string]float64
+}
+
+func (s *counterSink) IncrCounter(name string, delta float64) {
+	s.counters[name] += delta
+}
+
+func TestHaltDetector(t *testing.T) {
+	alerts := make(chan *Alert, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&alert))
+		alerts <- &alert
+	}))
+	defer server.Close()
+
+	sink := &counterSink{counters: map[string]float64{}}
+	detector := NewHaltDetector(30*time.Minute, NewWebhook(server.URL), sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- detector.Run(ctx)
+	}()
+
+	block := &rosetta.BlockIdentifier{Hash: "10", Index: 10}
+	detector.ObserveBlockGap(block, 15*time.Second)
+	detector.ObserveBlockGap(block, 30*time.Minute)
+	assert.Equal(t, float64(0), sink.counters[metrics.ChainHalts])
+
+	detector.ObserveBlockGap(block, time.Hour)
+	alert := <-alerts
+	assert.Equal(t, ChainHaltedAlert, alert.Type)
+	assert.Equal(t, block, alert.Block)
+	assert.Equal(t, "1h0m0s", alert.Gap)
+	assert.Equal(t, float64(1), sink.counters[metrics.ChainHalts])
+
+	cancel()
+	assert.NoError(t, <-done)
+	assert.Len(t, alerts, 0)
+}
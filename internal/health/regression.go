@@ -0,0 +1,83 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"context"
+	"log"
+	"time"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+// NodeHeadRegressedAlert is the Type of the Alert raised
+// when the tip reported by the node falls below the highest
+// tip it had reported.
+const NodeHeadRegressedAlert = "node_head_regressed"
+
+// RegressionAlerter posts an alert to the alert webhook when
+// the tip reported by the node moves backwards (ex: because
+// the node is resyncing or was rolled back). The Syncer logs
+// and counts each regression before notifying it.
+type RegressionAlerter struct {
+	alert *Webhook
+
+	alerts chan *Alert
+	now    func() time.Time
+}
+
+// NewRegressionAlerter returns a new RegressionAlerter.
+func NewRegressionAlerter(alert *Webhook) *RegressionAlerter {
+	return &RegressionAlerter{
+		alert:  alert,
+		alerts: make(chan *Alert, maxQueuedHaltAlerts),
+		now:    time.Now,
+	}
+}
+
+// ObserveTipRegression queues an alert that the tip of the
+// node moved from previous back to tip. It is called by the
+// Syncer and does not wait for the alert to be posted.
+func (a *RegressionAlerter) ObserveTipRegression(
+	previous *rosetta.BlockIdentifier,
+	tip *rosetta.BlockIdentifier,
+) {
+	select {
+	case a.alerts <- &Alert{
+		Type:        NodeHeadRegressedAlert,
+		Message:     "node head moved backwards",
+		Time:        a.now(),
+		Tip:         tip,
+		PreviousTip: previous,
+	}:
+	default:
+		log.Printf("Unable to queue node head regression alert for tip %+v\n", tip)
+	}
+}
+
+// Run posts queued alerts to the alert webhook until
+// the context is canceled. Webhook failures are logged.
+func (a *RegressionAlerter) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case alert := <-a.alerts:
+			if err := a.alert.Post(ctx, alert); err != nil {
+				log.Printf("Unable to send node head regression alert %v\n", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegressionAlerter(t *testing.T) {
+	alerts := make(chan *Alert, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&alert))
+		alerts <- &alert
+	}))
+	defer server.Close()
+
+	alerter := NewRegressionAlerter(NewWebhook(server.URL))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- alerter.Run(ctx)
+	}()
+
+	previous := &rosetta.BlockIdentifier{Hash: "100", Index: 100}
+	tip := &rosetta.BlockIdentifier{Hash: "40", Index: 40}
+	alerter.ObserveTipRegression(previous, tip)
+	alert := <-alerts
+	assert.Equal(t, NodeHeadRegressedAlert, alert.Type)
+	assert.Equal(t, previous, alert.PreviousTip)
+	assert.Equal(t, tip, alert.Tip)
+
+	cancel()
+	assert.NoError(t, <-done)
+	assert.Len(t, alerts, 0)
+}
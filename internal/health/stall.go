@@ -0,0 +1,200 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/coinbase/rosetta-validator/internal/metrics"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+// NodeStalledAlert is the Type of the Alert raised
+// when the tip of the node stops advancing.
+const NodeStalledAlert = "node_stalled"
+
+// ErrNodeStalled is returned by Check once the node
+// stalls if the StallDetector exits on stall (see
+// SetExitOnStall).
+var ErrNodeStalled = errors.New("Node tip has not advanced")
+
+// Alert is the payload posted to alert and
+// remediation webhooks.
+type Alert struct {
+	Type       string                   `json:"type"`
+	Message    string                   `json:"message"`
+	Time       time.Time                `json:"time"`
+	Tip        *rosetta.BlockIdentifier `json:"tip,omitempty"`
+	StalledFor string                   `json:"stalled_for,omitempty"`
+
+	// Block and Gap are set in a ChainHaltedAlert.
+	Block *rosetta.BlockIdentifier `json:"block,omitempty"`
+	Gap   string                   `json:"gap,omitempty"`
+
+	// PreviousTip is set in a NodeHeadRegressedAlert.
+	PreviousTip *rosetta.BlockIdentifier `json:"previous_tip,omitempty"`
+}
+
+// StallDetector raises an alert when the tip of the node
+// has not advanced for a threshold while the validator is
+// caught up to it (a stalled node otherwise looks like a
+// quiet validator). The alert is logged, recorded in the
+// NodeStalled gauge, and posted to the alert webhook (if
+// any). The remediation webhook (if any) is then called
+// (ex: to restart the node). Each is done once per stall.
+type StallDetector struct {
+	threshold   time.Duration
+	alert       *Webhook
+	remediation *Webhook
+	sink        metrics.Sink
+
+	mutex    sync.Mutex
+	tip      *rosetta.BlockIdentifier
+	tipSeen  time.Time
+	caughtUp bool
+	stalled  bool
+	now      func() time.Time
+
+	// exitOnStall makes Check return
+	// ErrNodeStalled once the node stalls.
+	exitOnStall bool
+}
+
+// NewStallDetector returns a new StallDetector. alert
+// and remediation may be nil.
+func NewStallDetector(
+	threshold time.Duration,
+	alert *Webhook,
+	remediation *Webhook,
+	sink metrics.Sink,
+) *StallDetector {
+	return &StallDetector{
+		threshold:   threshold,
+		alert:       alert,
+		remediation: remediation,
+		sink:        sink,
+		now:         time.Now,
+	}
+}
+
+// SetExitOnStall makes Check (and Run) return ErrNodeStalled
+// once the node stalls, after raising the alert and calling
+// the remediation webhook. It must be called before Run.
+func (d *StallDetector) SetExitOnStall(exitOnStall bool) {
+	d.exitOnStall = exitOnStall
+}
+
+// ObserveTip records the tip reported by the node and
+// whether the validator has processed every block up
+// to it. It is called by the Syncer in each SyncCycle.
+func (d *StallDetector) ObserveTip(tip *rosetta.BlockIdentifier, caughtUp bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.caughtUp = caughtUp
+	if d.tip != nil && d.tip.Index == tip.Index && d.tip.Hash == tip.Hash {
+		return
+	}
+
+	if d.stalled {
+		log.Printf("Node tip advanced to %+v\n", tip)
+		d.sink.SetGauge(metrics.NodeStalled, 0)
+		d.stalled = false
+	}
+
+	d.tip = tip
+	d.tipSeen = d.now()
+}
+
+// stall returns an Alert if the node has just stalled.
+func (d *StallDetector) stall() *Alert {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.tip == nil || !d.caughtUp || d.stalled {
+		return nil
+	}
+
+	now := d.now()
+	stalledFor := now.Sub(d.tipSeen)
+	if stalledFor < d.threshold {
+		return nil
+	}
+
+	d.stalled = true
+	return &Alert{
+		Type:       NodeStalledAlert,
+		Message:    "node tip has not advanced",
+		Time:       now,
+		Tip:        d.tip,
+		StalledFor: stalledFor.String(),
+	}
+}
+
+// Check raises an alert (and calls the remediation
+// webhook) if the node has just stalled. Webhook
+// failures are logged. It returns ErrNodeStalled once
+// the node stalls if the StallDetector exits on stall.
+func (d *StallDetector) Check(ctx context.Context) error {
+	alert := d.stall()
+	if alert == nil {
+		return nil
+	}
+
+	log.Printf("Node tip %+v has not advanced in %s\n", alert.Tip, alert.StalledFor)
+	d.sink.SetGauge(metrics.NodeStalled, 1)
+
+	if d.alert != nil {
+		if err := d.alert.Post(ctx, alert); err != nil {
+			log.Printf("Unable to send stalled node alert %v\n", err)
+		}
+	}
+
+	if d.remediation != nil {
+		if err := d.remediation.Post(ctx, alert); err != nil {
+			log.Printf("Unable to call stalled node remediation webhook %v\n", err)
+		}
+	}
+
+	if d.exitOnStall {
+		return fmt.Errorf("%w: tip %+v stalled for %s", ErrNodeStalled, alert.Tip, alert.StalledFor)
+	}
+
+	return nil
+}
+
+// Run checks for a stalled node every interval until
+// the context is canceled or Check returns an error.
+func (d *StallDetector) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := d.Check(ctx); err != nil {
+				return err
+			}
+		}
+	}
+}
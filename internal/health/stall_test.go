@@ -0,0 +1,116 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coinbase/rosetta-validator/internal/metrics"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// gaugeSink records the last value of each gauge.
+type gaugeSink struct {
+	metrics.NoOpSink
+	gauges map[string]float64
+}
+
+func (s *gaugeSink) SetGauge(name string, value float64) {
+	s.gauges[name] = value
+}
+
+func TestStallDetector(t *testing.T) {
+	ctx := context.Background()
+	alerts := []*Alert{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&alert))
+		alerts = append(alerts, &alert)
+	}))
+	defer server.Close()
+
+	sink := &gaugeSink{gauges: map[string]float64{}}
+	detector := NewStallDetector(time.Minute, NewWebhook(server.URL), NewWebhook(server.URL), sink)
+	now := time.Now()
+	detector.now = func() time.Time { return now }
+
+	tip := &rosetta.BlockIdentifier{Hash: "10", Index: 10}
+	t.Run("Not caught up", func(t *testing.T) {
+		detector.ObserveTip(tip, false)
+		now = now.Add(time.Hour)
+		assert.NoError(t, detector.Check(ctx))
+		assert.Len(t, alerts, 0)
+	})
+
+	t.Run("Stalled", func(t *testing.T) {
+		detector.ObserveTip(tip, true)
+		assert.NoError(t, detector.Check(ctx))
+
+		// The alert and remediation webhooks are each
+		// called once.
+		assert.Len(t, alerts, 2)
+		assert.Equal(t, NodeStalledAlert, alerts[0].Type)
+		assert.Equal(t, tip, alerts[0].Tip)
+		assert.Equal(t, "1h0m0s", alerts[0].StalledFor)
+		assert.Equal(t, float64(1), sink.gauges[metrics.NodeStalled])
+
+		now = now.Add(time.Hour)
+		assert.NoError(t, detector.Check(ctx))
+		assert.Len(t, alerts, 2)
+	})
+
+	t.Run("Tip advances", func(t *testing.T) {
+		detector.ObserveTip(&rosetta.BlockIdentifier{Hash: "11", Index: 11}, true)
+		assert.Equal(t, float64(0), sink.gauges[metrics.NodeStalled])
+
+		now = now.Add(30 * time.Second)
+		assert.NoError(t, detector.Check(ctx))
+		assert.Len(t, alerts, 2)
+
+		now = now.Add(time.Minute)
+		assert.NoError(t, detector.Check(ctx))
+		assert.Len(t, alerts, 4)
+	})
+
+	t.Run("Exit on stall", func(t *testing.T) {
+		detector.SetExitOnStall(true)
+		detector.ObserveTip(&rosetta.BlockIdentifier{Hash: "12", Index: 12}, true)
+		now = now.Add(time.Minute)
+		err := detector.Check(ctx)
+		assert.True(t, errors.Is(err, ErrNodeStalled))
+		assert.Len(t, alerts, 6)
+	})
+}
+
+func TestWebhook(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	err := NewWebhook(server.URL).Post(ctx, &Alert{Type: NodeStalledAlert})
+	assert.EqualError(t, err, "webhook "+server.URL+" returned 500 Internal Server Error")
+}
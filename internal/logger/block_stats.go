@@ -0,0 +1,165 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logger
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/coinbase/rosetta-validator/internal/storage"
+)
+
+// BlockStats are the processing statistics of a block.
+type BlockStats struct {
+	Index        int64
+	Transactions int
+	Operations   int
+
+	// FetchLatency is the time taken to fetch the block
+	// and ApplyLatency is the time taken to store it and
+	// apply its balance changes (both in seconds).
+	FetchLatency float64
+	ApplyLatency float64
+}
+
+// blockStatsSummary aggregates the BlockStats
+// of consecutively processed blocks.
+type blockStatsSummary struct {
+	blocks       int
+	transactions int
+	operations   int
+	fetchLatency float64
+	applyLatency float64
+
+	slowestFetch *BlockStats
+	slowestApply *BlockStats
+}
+
+// add includes stats in the summary.
+func (s *blockStatsSummary) add(stats *BlockStats) {
+	s.blocks++
+	s.transactions += stats.Transactions
+	s.operations += stats.Operations
+	s.fetchLatency += stats.FetchLatency
+	s.applyLatency += stats.ApplyLatency
+
+	if s.slowestFetch == nil || stats.FetchLatency > s.slowestFetch.FetchLatency {
+		s.slowestFetch = stats
+	}
+
+	if s.slowestApply == nil || stats.ApplyLatency > s.slowestApply.ApplyLatency {
+		s.slowestApply = stats
+	}
+}
+
+// seconds returns a latency in seconds as a time.Duration.
+func seconds(latency float64) time.Duration {
+	return time.Duration(latency * float64(time.Second)).Round(time.Microsecond)
+}
+
+// String returns the averages of the summary and
+// the blocks that were slowest to fetch and apply.
+func (s *blockStatsSummary) String() string {
+	blocks := float64(s.blocks)
+	return fmt.Sprintf(
+		"%.1f txs and %.1f ops per block, fetch %s avg (max %s at block %d with %d txs and %d ops), "+
+			"apply %s avg (max %s at block %d with %d txs and %d ops)",
+		float64(s.transactions)/blocks,
+		float64(s.operations)/blocks,
+		seconds(s.fetchLatency/blocks),
+		seconds(s.slowestFetch.FetchLatency),
+		s.slowestFetch.Index,
+		s.slowestFetch.Transactions,
+		s.slowestFetch.Operations,
+		seconds(s.applyLatency/blocks),
+		seconds(s.slowestApply.ApplyLatency),
+		s.slowestApply.Index,
+		s.slowestApply.Transactions,
+		s.slowestApply.Operations,
+	)
+}
+
+// SetBlockStatsWindow logs a summary of the BlockStats of
+// every window blocks (0 disables it). name identifies the
+// network in the summary.
+func (l *Logger) SetBlockStatsWindow(name string, window int) {
+	l.blockStatsName = name
+	l.blockStatsWindow = window
+}
+
+// LifetimeStatsRecorder records the storage.LifetimeStats
+// that are not updated as blocks are processed (ex: the
+// reconciliations performed) and returns them.
+type LifetimeStatsRecorder interface {
+	RecordLifetimeStats(ctx context.Context) (*storage.LifetimeStats, error)
+}
+
+// SetLifetimeStatsRecorder logs the LifetimeStats recorded
+// by recorder along with each summary of BlockStats.
+func (l *Logger) SetLifetimeStatsRecorder(recorder LifetimeStatsRecorder) {
+	l.lifetimeStats = recorder
+}
+
+// recordBlockStats adds stats to the summary of the
+// current window, logging and resetting the summary
+// once the window is full.
+func (l *Logger) recordBlockStats(ctx context.Context, stats []*BlockStats) {
+	if l.blockStatsWindow <= 0 {
+		return
+	}
+
+	l.blockStatsMutex.Lock()
+	defer l.blockStatsMutex.Unlock()
+
+	for _, blockStats := range stats {
+		if l.blockStatsStart < 0 {
+			l.blockStatsStart = blockStats.Index
+		}
+		l.blockStatsSummary.add(blockStats)
+
+		if l.blockStatsSummary.blocks < l.blockStatsWindow {
+			continue
+		}
+
+		log.Printf(
+			"Block stats of %s blocks %d-%d: %s\n",
+			l.blockStatsName,
+			l.blockStatsStart,
+			blockStats.Index,
+			l.blockStatsSummary.String(),
+		)
+		l.blockStatsSummary = &blockStatsSummary{}
+		l.blockStatsStart = -1
+		l.logLifetimeStats(ctx)
+	}
+}
+
+// logLifetimeStats records and logs the
+// LifetimeStats (if a recorder is set).
+func (l *Logger) logLifetimeStats(ctx context.Context) {
+	if l.lifetimeStats == nil {
+		return
+	}
+
+	stats, err := l.lifetimeStats.RecordLifetimeStats(ctx)
+	if err != nil {
+		log.Printf("Unable to record lifetime stats of %s %v\n", l.blockStatsName, err)
+		return
+	}
+
+	log.Printf("Lifetime stats of %s: %s\n", l.blockStatsName, stats)
+}
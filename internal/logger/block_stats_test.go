@@ -0,0 +1,67 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coinbase/rosetta-validator/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingRecorder counts the calls to
+// RecordLifetimeStats.
+type countingRecorder struct {
+	calls int64
+}
+
+func (r *countingRecorder) RecordLifetimeStats(ctx context.Context) (*storage.LifetimeStats, error) {
+	r.calls++
+	return &storage.LifetimeStats{BlocksSynced: r.calls}, nil
+}
+
+func TestBlockStatsWindow(t *testing.T) {
+	ctx := context.Background()
+	l := NewLogger("", false, false, false, false)
+	l.SetBlockStatsWindow("bitcoin/mainnet", 2)
+	recorder := &countingRecorder{}
+	l.SetLifetimeStatsRecorder(recorder)
+
+	l.recordBlockStats(ctx, []*BlockStats{
+		{Index: 1, Transactions: 2, Operations: 4, FetchLatency: 0.1, ApplyLatency: 0.5},
+	})
+	assert.Equal(t, int64(1), l.blockStatsStart)
+	assert.Equal(t, 1, l.blockStatsSummary.blocks)
+	assert.Equal(
+		t,
+		"2.0 txs and 4.0 ops per block, fetch 100ms avg (max 100ms at block 1 with 2 txs and 4 ops), "+
+			"apply 500ms avg (max 500ms at block 1 with 2 txs and 4 ops)",
+		l.blockStatsSummary.String(),
+	)
+	assert.Equal(t, int64(0), recorder.calls)
+
+	// The summary (and the lifetime stats) are
+	// logged and reset once the window is full.
+	l.recordBlockStats(ctx, []*BlockStats{
+		{Index: 2, Transactions: 4, Operations: 8, FetchLatency: 0.3, ApplyLatency: 0.1},
+		{Index: 3, Transactions: 1, Operations: 1, FetchLatency: 0.2, ApplyLatency: 0.2},
+	})
+	assert.Equal(t, int64(3), l.blockStatsStart)
+	assert.Equal(t, 1, l.blockStatsSummary.blocks)
+	assert.Equal(t, int64(3), l.blockStatsSummary.slowestApply.Index)
+	assert.Equal(t, int64(1), recorder.calls)
+}
@@ -20,8 +20,11 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"sync"
+	"time"
 
-	"github.com/coinbase/rosetta-sdk-go/fetcher"
+	"github.com/coinbase/rosetta-validator/internal/storage"
+	"github.com/coinbase/rosetta-validator/internal/utils"
 
 	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
 )
@@ -39,9 +42,17 @@ const (
 	// when a block is orphaned.
 	removeBlock = "Remove"
 
+	// balanceStreamFile contains the stream of
+	// balance changes applied by the syncer.
+	balanceStreamFile = "balances.txt"
+
+	// reconcileStreamFile contains the stream of
+	// reconciliation results.
+	reconcileStreamFile = "reconciliations.txt"
+
 	// blockLatencyHeader is used as the CSV header
 	// to the blockBenchmarkFile.
-	blockLatencyHeader = "index,latency,txs,ops\n"
+	blockLatencyHeader = "index,latency,txs,ops,apply_latency\n"
 
 	// accountLatencyHeader is used as the CSV header
 	// to the accountBenchmarkFile.
@@ -63,20 +74,66 @@ const (
 // Logger contains all logic to record validator ouput
 // and benchmark a Rosetta Server.
 type Logger struct {
-	logDir          string
-	logTransactions bool
-	logBenchmarks   bool
+	logDir            string
+	logTransactions   bool
+	logBenchmarks     bool
+	logBalanceChanges bool
+	logReconciliation bool
+
+	// timestampUnit is used to render block
+	// timestamps, if set.
+	timestampUnit utils.TimestampUnit
+
+	// blockStatsSummary summarizes the BlockStats of
+	// the current window of blockStatsWindow blocks,
+	// starting at blockStatsStart (-1 if it is empty).
+	blockStatsName    string
+	blockStatsWindow  int
+	blockStatsMutex   sync.Mutex
+	blockStatsSummary *blockStatsSummary
+	blockStatsStart   int64
+
+	// lifetimeStats is optional. If it is set, the
+	// LifetimeStats are logged along with each summary
+	// of BlockStats.
+	lifetimeStats LifetimeStatsRecorder
 }
 
 // NewLogger constructs a new Logger.
-func NewLogger(logDir string, logTransactions bool, logBenchmarks bool) *Logger {
+func NewLogger(
+	logDir string,
+	logTransactions bool,
+	logBenchmarks bool,
+	logBalanceChanges bool,
+	logReconciliation bool,
+) *Logger {
 	return &Logger{
-		logDir:          logDir,
-		logTransactions: logTransactions,
-		logBenchmarks:   logBenchmarks,
+		logDir:            logDir,
+		logTransactions:   logTransactions,
+		logBenchmarks:     logBenchmarks,
+		logBalanceChanges: logBalanceChanges,
+		logReconciliation: logReconciliation,
+		blockStatsSummary: &blockStatsSummary{},
+		blockStatsStart:   -1,
 	}
 }
 
+// SetTimestampUnit renders block timestamps in the
+// block stream as times, assuming they are in unit.
+func (l *Logger) SetTimestampUnit(unit utils.TimestampUnit) {
+	l.timestampUnit = unit
+}
+
+// appendFile opens a file in the log directory
+// for appending, creating it if it doesn't exist.
+func (l *Logger) appendFile(name string) (*os.File, error) {
+	return os.OpenFile(
+		path.Join(l.logDir, name),
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+		logFilePermissions,
+	)
+}
+
 // BlockStream writes the next processed block to the end of the blocks.txt
 // output file.
 func (l *Logger) BlockStream(
@@ -84,11 +141,7 @@ func (l *Logger) BlockStream(
 	block *rosetta.Block,
 	orphan bool,
 ) error {
-	f, err := os.OpenFile(
-		path.Join(l.logDir, blockStreamFile),
-		os.O_APPEND|os.O_CREATE|os.O_WRONLY,
-		logFilePermissions,
-	)
+	f, err := l.appendFile(blockStreamFile)
 	if err != nil {
 		return err
 	}
@@ -99,70 +152,103 @@ func (l *Logger) BlockStream(
 		verb = removeBlock
 	}
 
+	timestamp := fmt.Sprintf("%d", block.Timestamp)
+	if len(l.timestampUnit) > 0 {
+		timestamp = fmt.Sprintf(
+			"%s (%s)",
+			timestamp,
+			l.timestampUnit.Time(block.Timestamp).Format(time.RFC3339Nano),
+		)
+	}
+
 	_, err = f.WriteString(fmt.Sprintf(
-		"%s Block %s %d %d\n",
+		"%s Block %s %d %s\n",
 		verb,
 		block.BlockIdentifier.Hash,
 		block.BlockIdentifier.Index,
-		block.Timestamp,
+		timestamp,
 	))
+
+	return err
+}
+
+// TransactionStream writes the transactions and operations
+// in a processed block to the end of the blocks.txt output
+// file, if transaction logging is enabled.
+func (l *Logger) TransactionStream(
+	ctx context.Context,
+	block *rosetta.Block,
+) error {
+	if !l.logTransactions {
+		return nil
+	}
+
+	f, err := l.appendFile(blockStreamFile)
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 
-	if l.logTransactions {
-		_, err = f.WriteString(fmt.Sprintf(
-			"Parent Block: %s %d\n",
-			block.ParentBlockIdentifier.Hash,
-			block.ParentBlockIdentifier.Index,
-		))
+	_, err = f.WriteString(fmt.Sprintf(
+		"Parent Block: %s %d\n",
+		block.ParentBlockIdentifier.Hash,
+		block.ParentBlockIdentifier.Index,
+	))
+	if err != nil {
+		return err
+	}
+
+	for _, tx := range block.Transactions {
+		_, err = f.WriteString(fmt.Sprintf("Tx %s\n", tx.TransactionIdentifier.Hash))
 		if err != nil {
 			return err
 		}
 
-		for _, tx := range block.Transactions {
-			_, err = f.WriteString(fmt.Sprintf("Tx %s\n", tx.TransactionIdentifier.Hash))
-			if err != nil {
-				return err
-			}
-
-			for _, op := range tx.Operations {
-				amount := ""
-				symbol := ""
-				if op.Amount != nil {
-					amount = op.Amount.Value
+		for _, op := range tx.Operations {
+			amount := ""
+			symbol := ""
+			if op.Amount != nil {
+				amount = op.Amount.Value
+				if op.Amount.Currency != nil {
 					symbol = op.Amount.Currency.Symbol
 				}
-				participant := ""
-				if op.Account != nil {
-					participant = op.Account.Address
-				}
+			}
+			participant := ""
+			if op.Account != nil {
+				participant = op.Account.Address
+			}
 
-				networkIndex := op.OperationIdentifier.Index
-				if op.OperationIdentifier.NetworkIndex != nil {
-					networkIndex = *op.OperationIdentifier.NetworkIndex
-				}
+			// Invalid operations are stored (without their
+			// balance changes) in CONTINUE_ON_ERROR mode.
+			identifier := op.OperationIdentifier
+			if identifier == nil {
+				identifier = &rosetta.OperationIdentifier{Index: -1}
+			}
 
-				_, err = f.WriteString(fmt.Sprintf(
-					"TxOp %d(%d) %s %s %s %s %s\n",
-					op.OperationIdentifier.Index,
-					networkIndex,
-					op.Type,
-					participant,
-					amount,
-					symbol,
-					op.Status,
-				))
+			networkIndex := identifier.Index
+			if identifier.NetworkIndex != nil {
+				networkIndex = *identifier.NetworkIndex
+			}
+
+			_, err = f.WriteString(fmt.Sprintf(
+				"TxOp %d(%d) %s %s %s %s %s\n",
+				identifier.Index,
+				networkIndex,
+				op.Type,
+				participant,
+				amount,
+				symbol,
+				op.Status,
+			))
+			if err != nil {
+				return err
+			}
+
+			if op.Account != nil && op.Account.Metadata != nil {
+				_, err = f.WriteString(fmt.Sprintf("Account Metadata: %+v\n", op.Account.Metadata))
 				if err != nil {
 					return err
 				}
-
-				if op.Account != nil && op.Account.Metadata != nil {
-					_, err = f.WriteString(fmt.Sprintf("Account Metadata: %+v\n", op.Account.Metadata))
-					if err != nil {
-						return err
-					}
-				}
 			}
 		}
 	}
@@ -170,6 +256,90 @@ func (l *Logger) BlockStream(
 	return nil
 }
 
+// BalanceStream writes the balance changes applied for a block
+// to the end of the balances.txt output file, if balance change
+// logging is enabled.
+func (l *Logger) BalanceStream(
+	ctx context.Context,
+	balanceChanges []*storage.BalanceChange,
+) error {
+	if !l.logBalanceChanges {
+		return nil
+	}
+
+	f, err := l.appendFile(balanceStreamFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for _, change := range balanceChanges {
+		_, err = f.WriteString(fmt.Sprintf(
+			"Account: %s Change: %s Block: %d:%s\n",
+			simpleAccount(change.Account),
+			formatAmount(change.Difference, change.Currency),
+			change.Block.Index,
+			change.Block.Hash,
+		))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReconcileStream writes the result of a reconciliation
+// to the end of the reconciliations.txt output file, if
+// reconciliation logging is enabled.
+func (l *Logger) ReconcileStream(
+	ctx context.Context,
+	reconciliationType string,
+	account *rosetta.AccountIdentifier,
+	currency *rosetta.Currency,
+	difference string,
+	block *rosetta.BlockIdentifier,
+) error {
+	if !l.logReconciliation {
+		return nil
+	}
+
+	f, err := l.appendFile(reconcileStreamFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(fmt.Sprintf(
+		"Type: %s Account: %s Currency: %s Difference: %s Block: %d:%s\n",
+		reconciliationType,
+		simpleAccount(account),
+		currency.Symbol,
+		formatAmount(difference, currency),
+		block.Index,
+		block.Hash,
+	))
+
+	return err
+}
+
+// simpleAccount returns a string that is a simple
+// representation of a rosetta.AccountIdentifier.
+func simpleAccount(account *rosetta.AccountIdentifier) string {
+	addressString := account.Address
+	if account.SubAccount != nil {
+		addressString += account.SubAccount.SubAccount
+	}
+
+	return addressString
+}
+
+// formatAmount renders an amount in standard units
+// followed by its raw value (ex: "1.25 BTC (125000000)").
+func formatAmount(value string, currency *rosetta.Currency) string {
+	return fmt.Sprintf("%s (%s)", storage.FormatAmount(value, currency), value)
+}
+
 // writeCSVHeader writes a header to a file if it
 // doesn't yet exist.
 func writeCSVHeader(header string, file string) error {
@@ -192,12 +362,15 @@ func writeCSVHeader(header string, file string) error {
 	return err
 }
 
-// BlockLatency writes the Rosetta Server performance for block fetch
-// benchmarks to the block_benchmarks.csv file.
-func (l *Logger) BlockLatency(
+// BlockStats writes the Rosetta Server performance for block fetch
+// benchmarks (and the time taken to apply each block) to the
+// block_benchmarks.csv file and adds them to the summary logged
+// every block stats window (see SetBlockStatsWindow).
+func (l *Logger) BlockStats(
 	ctx context.Context,
-	blocks []*fetcher.BlockAndLatency,
+	blocks []*BlockStats,
 ) error {
+	l.recordBlockStats(ctx, blocks)
 	if !l.logBenchmarks {
 		return nil
 	}
@@ -220,18 +393,13 @@ func (l *Logger) BlockLatency(
 	defer f.Close()
 
 	for _, block := range blocks {
-		txs := len(block.Block.Transactions)
-		ops := 0
-		for _, tx := range block.Block.Transactions {
-			ops += len(tx.Operations)
-		}
-
 		_, err := f.WriteString(fmt.Sprintf(
-			"%d,%f,%d,%d\n",
-			block.Block.BlockIdentifier.Index,
-			block.Latency,
-			txs,
-			ops,
+			"%d,%f,%d,%d,%f\n",
+			block.Index,
+			block.FetchLatency,
+			block.Transactions,
+			block.Operations,
+			block.ApplyLatency,
 		))
 		if err != nil {
 			return err
@@ -270,14 +438,9 @@ func (l *Logger) AccountLatency(
 	}
 	defer f.Close()
 
-	addressString := account.Address
-	if account.SubAccount != nil {
-		addressString += account.SubAccount.SubAccount
-	}
-
 	_, err = f.WriteString(fmt.Sprintf(
 		"%s,%f,%d\n",
-		addressString,
+		simpleAccount(account),
 		latency,
 		balances,
 	))
@@ -0,0 +1,269 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mempool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/coinbase/rosetta-validator/internal/metrics"
+	"github.com/coinbase/rosetta-validator/internal/storage"
+
+	"github.com/coinbase/rosetta-sdk-go/asserter"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+// ErrTransactionIdentifierMismatch is returned when the
+// transaction returned for a mempool transaction identifier
+// has a different identifier.
+var ErrTransactionIdentifierMismatch = errors.New("Mempool transaction identifier mismatch")
+
+// Fetcher fetches the mempool of the node
+// (ex: a *fetcher.Fetcher).
+type Fetcher interface {
+	UnsafeMempool(
+		ctx context.Context,
+		network *rosetta.NetworkIdentifier,
+	) ([]*rosetta.TransactionIdentifier, error)
+
+	UnsafeMempoolTransaction(
+		ctx context.Context,
+		network *rosetta.NetworkIdentifier,
+		transaction *rosetta.TransactionIdentifier,
+	) (*rosetta.Transaction, *map[string]interface{}, error)
+}
+
+// TransactionAsserter asserts the correctness of a
+// transaction (ex: a *asserter.Asserter).
+type TransactionAsserter interface {
+	Transaction(transaction *rosetta.Transaction) error
+}
+
+// Storage looks up the transactions of synced
+// blocks (ex: a *storage.BlockStorage).
+type Storage interface {
+	NewDatabaseTransaction(ctx context.Context, write bool) storage.DatabaseTransaction
+	HasTransaction(
+		ctx context.Context,
+		transaction storage.DatabaseTransaction,
+		hash string,
+	) (bool, error)
+}
+
+// entry is a transaction seen in the mempool.
+type entry struct {
+	firstSeen time.Time
+	inMempool bool
+	malformed bool
+	stuck     bool
+}
+
+// Monitor periodically fetches the mempool of the node,
+// asserts each new mempool transaction, and tracks whether
+// it is eventually included in a synced block. Malformed
+// transactions are reported once. A transaction is reported
+// as stuck once it has been in the mempool for the stuck
+// threshold without being included in a synced block, and
+// as dropped if it leaves the mempool without being included
+// within the threshold. Reports are logged and recorded in
+// the Mempool metrics.
+//
+// Inclusion is checked against synced blocks, so reports
+// are only meaningful once the validator is synced to the
+// tip of the node.
+type Monitor struct {
+	network        *rosetta.NetworkIdentifier
+	fetcher        Fetcher
+	asserter       TransactionAsserter
+	storage        Storage
+	sink           metrics.Sink
+	stuckThreshold time.Duration
+
+	// entries are keyed by transaction hash. They are only
+	// accessed by Check, which must not be called concurrently.
+	entries map[string]*entry
+	now     func() time.Time
+}
+
+// NewMonitor returns a new Monitor.
+func NewMonitor(
+	network *rosetta.NetworkIdentifier,
+	fetcher Fetcher,
+	asserter TransactionAsserter,
+	storage Storage,
+	sink metrics.Sink,
+	stuckThreshold time.Duration,
+) *Monitor {
+	return &Monitor{
+		network:        network,
+		fetcher:        fetcher,
+		asserter:       asserter,
+		storage:        storage,
+		sink:           sink,
+		stuckThreshold: stuckThreshold,
+		entries:        map[string]*entry{},
+		now:            time.Now,
+	}
+}
+
+// assertTransaction returns an error if transaction is
+// invalid or does not match identifier.
+func (m *Monitor) assertTransaction(
+	identifier *rosetta.TransactionIdentifier,
+	transaction *rosetta.Transaction,
+) error {
+	if err := m.asserter.Transaction(transaction); err != nil {
+		return err
+	}
+
+	if transaction.TransactionIdentifier.Hash != identifier.Hash {
+		return fmt.Errorf(
+			"%w: requested %s but got %s",
+			ErrTransactionIdentifierMismatch,
+			identifier.Hash,
+			transaction.TransactionIdentifier.Hash,
+		)
+	}
+
+	return nil
+}
+
+// observe starts tracking a transaction seen in the
+// mempool for the first time. A transaction that cannot
+// be fetched is not tracked so that it is fetched again
+// in the next Check.
+func (m *Monitor) observe(
+	ctx context.Context,
+	identifier *rosetta.TransactionIdentifier,
+	hash string,
+) {
+	e := &entry{firstSeen: m.now(), inMempool: true}
+	err := asserter.TransactionIdentifier(identifier)
+	if err == nil {
+		var transaction *rosetta.Transaction
+		transaction, _, err = m.fetcher.UnsafeMempoolTransaction(ctx, m.network, identifier)
+		if err != nil {
+			log.Printf("Unable to fetch mempool transaction %s %v\n", hash, err)
+			return
+		}
+
+		err = m.assertTransaction(identifier, transaction)
+	}
+
+	if err != nil {
+		log.Printf("Malformed mempool transaction %q: %v\n", hash, err)
+		m.sink.IncrCounter(metrics.MempoolTransactionsMalformed, 1)
+		e.malformed = true
+	}
+
+	m.entries[hash] = e
+}
+
+// Check fetches the mempool, asserts any new mempool
+// transactions, and reports any transactions that have
+// just become stuck or were dropped.
+func (m *Monitor) Check(ctx context.Context) error {
+	identifiers, err := m.fetcher.UnsafeMempool(ctx, m.network)
+	if err != nil {
+		return fmt.Errorf("%w: unable to fetch mempool", err)
+	}
+	m.sink.SetGauge(metrics.MempoolTransactions, float64(len(identifiers)))
+
+	for _, e := range m.entries {
+		e.inMempool = false
+	}
+
+	for _, identifier := range identifiers {
+		hash := ""
+		if identifier != nil {
+			hash = identifier.Hash
+		}
+
+		if e, ok := m.entries[hash]; ok {
+			e.inMempool = true
+			continue
+		}
+
+		m.observe(ctx, identifier, hash)
+	}
+
+	dbTx := m.storage.NewDatabaseTransaction(ctx, false)
+	defer dbTx.Discard(ctx)
+
+	now := m.now()
+	stuck := 0
+	for hash, e := range m.entries {
+		if e.malformed {
+			if !e.inMempool {
+				delete(m.entries, hash)
+			}
+
+			continue
+		}
+
+		included, err := m.storage.HasTransaction(ctx, dbTx, hash)
+		if err != nil {
+			return err
+		}
+
+		if included {
+			m.sink.IncrCounter(metrics.MempoolTransactionsIncluded, 1)
+			delete(m.entries, hash)
+			continue
+		}
+
+		pending := now.Sub(e.firstSeen)
+		if pending < m.stuckThreshold {
+			continue
+		}
+
+		if !e.inMempool {
+			log.Printf("Mempool transaction %s was dropped without being included in a block\n", hash)
+			delete(m.entries, hash)
+			continue
+		}
+
+		if !e.stuck {
+			log.Printf("Mempool transaction %s has not been included in a block in %s\n", hash, pending)
+			e.stuck = true
+		}
+		stuck++
+	}
+	m.sink.SetGauge(metrics.MempoolTransactionsStuck, float64(stuck))
+
+	return nil
+}
+
+// Run checks the mempool every interval until the
+// context is canceled. Failed checks are logged.
+func (m *Monitor) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := m.Check(ctx); err != nil {
+				log.Printf("Unable to check mempool %v\n", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mempool
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/coinbase/rosetta-validator/internal/metrics"
+	"github.com/coinbase/rosetta-validator/internal/storage"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mempoolFetcher serves a mempool of transactions. A
+// transaction without operations is malformed.
+type mempoolFetcher struct {
+	mempool      []*rosetta.TransactionIdentifier
+	transactions map[string]*rosetta.Transaction
+	fetches      int
+}
+
+func (f *mempoolFetcher) UnsafeMempool(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+) ([]*rosetta.TransactionIdentifier, error) {
+	return f.mempool, nil
+}
+
+func (f *mempoolFetcher) UnsafeMempoolTransaction(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	transaction *rosetta.TransactionIdentifier,
+) (*rosetta.Transaction, *map[string]interface{}, error) {
+	f.fetches++
+	t, ok := f.transactions[transaction.Hash]
+	if !ok {
+		return nil, nil, errors.New("transaction not found")
+	}
+
+	return t, nil, nil
+}
+
+type operationsAsserter struct{}
+
+func (a *operationsAsserter) Transaction(transaction *rosetta.Transaction) error {
+	if len(transaction.Operations) == 0 {
+		return errors.New("transaction has no operations")
+	}
+
+	return nil
+}
+
+// metricsSink records the last value of each
+// gauge and the total of each counter.
+type metricsSink struct {
+	metrics.NoOpSink
+	counters map[string]float64
+	gauges   map[string]float64
+}
+
+func (s *metricsSink) IncrCounter(name string, delta float64) {
+	s.counters[name] += delta
+}
+
+func (s *metricsSink) SetGauge(name string, value float64) {
+	s.gauges[name] = value
+}
+
+func transaction(hash string, operations int) *rosetta.Transaction {
+	t := &rosetta.Transaction{
+		TransactionIdentifier: &rosetta.TransactionIdentifier{Hash: hash},
+		Operations:            []*rosetta.Operation{},
+	}
+	for i := 0; i < operations; i++ {
+		t.Operations = append(t.Operations, &rosetta.Operation{
+			OperationIdentifier: &rosetta.OperationIdentifier{Index: int64(i)},
+		})
+	}
+
+	return t
+}
+
+func TestMonitor(t *testing.T) {
+	ctx := context.Background()
+	blockStorage := storage.NewBlockStorage(
+		ctx,
+		storage.NewMemoryStorage(),
+		&storage.GobCodec{},
+		&storage.SHA256KeyHasher{},
+	)
+
+	transactions := map[string]*rosetta.Transaction{
+		"included":  transaction("included", 1),
+		"stuck":     transaction("stuck", 1),
+		"dropped":   transaction("dropped", 1),
+		"malformed": transaction("malformed", 0),
+		"mismatch":  transaction("other", 1),
+	}
+	f := &mempoolFetcher{transactions: transactions}
+	for _, hash := range []string{"included", "stuck", "dropped", "malformed", "mismatch"} {
+		f.mempool = append(f.mempool, &rosetta.TransactionIdentifier{Hash: hash})
+	}
+
+	sink := &metricsSink{counters: map[string]float64{}, gauges: map[string]float64{}}
+	monitor := NewMonitor(nil, f, &operationsAsserter{}, blockStorage, sink, time.Minute)
+	now := time.Now()
+	monitor.now = func() time.Time { return now }
+
+	t.Run("New transactions", func(t *testing.T) {
+		assert.NoError(t, monitor.Check(ctx))
+		assert.Equal(t, 5, f.fetches)
+		assert.Len(t, monitor.entries, 5)
+		assert.Equal(t, float64(5), sink.gauges[metrics.MempoolTransactions])
+		assert.Equal(t, float64(2), sink.counters[metrics.MempoolTransactionsMalformed])
+		assert.Equal(t, float64(0), sink.gauges[metrics.MempoolTransactionsStuck])
+	})
+
+	t.Run("Included and dropped", func(t *testing.T) {
+		dbTx := blockStorage.NewDatabaseTransaction(ctx, true)
+		assert.NoError(t, blockStorage.StoreBlock(ctx, dbTx, &rosetta.Block{
+			BlockIdentifier: &rosetta.BlockIdentifier{Hash: "block 1", Index: 1},
+			Transactions:    []*rosetta.Transaction{transactions["included"]},
+		}))
+		assert.NoError(t, dbTx.Commit(ctx))
+
+		f.mempool = []*rosetta.TransactionIdentifier{{Hash: "stuck"}, {Hash: "malformed"}}
+
+		// Transactions are not fetched again.
+		assert.NoError(t, monitor.Check(ctx))
+		assert.Equal(t, 5, f.fetches)
+		assert.Equal(t, float64(1), sink.counters[metrics.MempoolTransactionsIncluded])
+		assert.Equal(t, float64(2), sink.counters[metrics.MempoolTransactionsMalformed])
+
+		// A transaction that left the mempool is only
+		// dropped once the stuck threshold has passed.
+		assert.Contains(t, monitor.entries, "dropped")
+		assert.NotContains(t, monitor.entries, "mismatch")
+	})
+
+	t.Run("Stuck", func(t *testing.T) {
+		now = now.Add(time.Hour)
+		assert.NoError(t, monitor.Check(ctx))
+		assert.Equal(t, float64(1), sink.gauges[metrics.MempoolTransactionsStuck])
+		assert.True(t, monitor.entries["stuck"].stuck)
+		assert.NotContains(t, monitor.entries, "dropped")
+		assert.Len(t, monitor.entries, 2)
+	})
+
+	t.Run("Unavailable transaction", func(t *testing.T) {
+		f.mempool = append(f.mempool, &rosetta.TransactionIdentifier{Hash: "unknown"})
+		assert.NoError(t, monitor.Check(ctx))
+		assert.NotContains(t, monitor.entries, "unknown")
+		assert.Equal(t, 6, f.fetches)
+
+		// It is fetched again in the next check.
+		assert.NoError(t, monitor.Check(ctx))
+		assert.Equal(t, 7, f.fetches)
+	})
+}
@@ -0,0 +1,141 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+const (
+	// BlocksSynced counts blocks added to the canonical chain.
+	BlocksSynced = "blocks_synced"
+
+	// BlocksOrphaned counts blocks removed in a reorg.
+	BlocksOrphaned = "blocks_orphaned"
+
+	// SyncHeadIndex is the index of the last processed block.
+	SyncHeadIndex = "sync_head_index"
+
+	// BlockFetchSeconds is the time taken to fetch a block.
+	BlockFetchSeconds = "block_fetch_seconds"
+
+	// BlockApplySeconds is the time taken to store a block
+	// and apply its balance changes.
+	BlockApplySeconds = "block_apply_seconds"
+
+	// Reconciliations counts successful balance reconciliations.
+	Reconciliations = "reconciliations"
+
+	// ReconciliationFailures counts balance mismatches.
+	ReconciliationFailures = "reconciliation_failures"
+
+	// ReconciliationsSkipped counts accounts that could not
+	// be reconciled (ex: the account was updated before its
+	// live balance could be compared).
+	ReconciliationsSkipped = "reconciliations_skipped"
+
+	// ReconciliationBacklog is the number of accounts
+	// waiting for active reconciliation.
+	ReconciliationBacklog = "reconciliation_backlog"
+
+	// AccountFetchSeconds is the time taken to fetch
+	// an account balance.
+	AccountFetchSeconds = "account_fetch_seconds"
+
+	// StorageCommitSeconds is the time taken to commit
+	// a storage transaction.
+	StorageCommitSeconds = "storage_commit_seconds"
+
+	// StorageCommitErrors counts failed storage commits.
+	StorageCommitErrors = "storage_commit_errors"
+
+	// StorageCommitConflicts counts storage commits that
+	// failed because of a conflicting transaction.
+	StorageCommitConflicts = "storage_commit_conflicts"
+
+	// ResourceScale is the fraction of the configured buffer
+	// sizes and concurrency in use after adaptive throttling.
+	ResourceScale = "resource_scale"
+
+	// DiskUsageBytes is the size of DATA_DIR.
+	DiskUsageBytes = "disk_usage_bytes"
+
+	// NodeStalled is 1 while the tip of the node has not
+	// advanced for the stall threshold and 0 otherwise.
+	NodeStalled = "node_stalled"
+
+	// NodeHeadRegressions counts the times the tip reported
+	// by the node fell below the highest tip it had reported
+	// (ex: because the node is resyncing or rolled back).
+	NodeHeadRegressions = "node_head_regressions"
+
+	// NodeHeadRegressed is 1 while the tip reported by the
+	// node is below the highest tip it had reported and 0
+	// otherwise.
+	NodeHeadRegressed = "node_head_regressed"
+
+	// ChainHalts counts blocks with a timestamp more than
+	// the chain halt threshold after their parent.
+	ChainHalts = "chain_halts"
+
+	// TimestampViolations counts blocks with a timestamp
+	// more than the tolerance before their parent (only
+	// if violations are logged instead of rejected).
+	TimestampViolations = "timestamp_violations"
+
+	// SyncPaused is 1 while syncing is paused because
+	// requests to the node are failing and 0 otherwise.
+	SyncPaused = "sync_paused"
+
+	// MempoolTransactions is the number of transactions
+	// in the mempool of the node.
+	MempoolTransactions = "mempool_transactions"
+
+	// MempoolTransactionsIncluded counts mempool transactions
+	// that were included in a synced block.
+	MempoolTransactionsIncluded = "mempool_transactions_included"
+
+	// MempoolTransactionsMalformed counts mempool transactions
+	// that failed assertion.
+	MempoolTransactionsMalformed = "mempool_transactions_malformed"
+
+	// MempoolTransactionsStuck is the number of transactions
+	// that have been in the mempool for the stuck threshold
+	// without being included in a synced block.
+	MempoolTransactionsStuck = "mempool_transactions_stuck"
+)
+
+// Sink records metrics. Implementations must be safe
+// for concurrent use.
+type Sink interface {
+	// IncrCounter adds delta to a monotonically
+	// increasing counter.
+	IncrCounter(name string, delta float64)
+
+	// SetGauge sets the current value of a gauge.
+	SetGauge(name string, value float64)
+
+	// ObserveHistogram records a single observation
+	// (ex: a latency in seconds) in a histogram.
+	ObserveHistogram(name string, value float64)
+}
+
+// NoOpSink is a Sink that discards all metrics.
+type NoOpSink struct{}
+
+// IncrCounter does nothing.
+func (s *NoOpSink) IncrCounter(name string, delta float64) {}
+
+// SetGauge does nothing.
+func (s *NoOpSink) SetGauge(name string, value float64) {}
+
+// ObserveHistogram does nothing.
+func (s *NoOpSink) ObserveHistogram(name string, value float64) {}
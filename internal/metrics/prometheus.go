@@ -0,0 +1,184 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	// prometheusPath is the path metrics are served on.
+	prometheusPath = "/metrics"
+)
+
+// DefaultBuckets are the upper bounds of the histogram
+// buckets used by the PrometheusSink. They are suited to
+// latencies measured in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// PrometheusSink is a Sink that exposes metrics in the
+// Prometheus text exposition format.
+type PrometheusSink struct {
+	prefix string
+
+	mutex      sync.Mutex
+	counters   map[string]float64
+	gauges     map[string]float64
+	histograms map[string]*histogram
+}
+
+// NewPrometheusSink returns a new PrometheusSink. Each metric
+// name is prefixed with prefix and an underscore.
+func NewPrometheusSink(prefix string) *PrometheusSink {
+	return &PrometheusSink{
+		prefix:     prefix,
+		counters:   make(map[string]float64),
+		gauges:     make(map[string]float64),
+		histograms: make(map[string]*histogram),
+	}
+}
+
+// IncrCounter adds delta to a counter.
+func (s *PrometheusSink) IncrCounter(name string, delta float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.counters[name] += delta
+}
+
+// SetGauge sets the value of a gauge.
+func (s *PrometheusSink) SetGauge(name string, value float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.gauges[name] = value
+}
+
+// ObserveHistogram records an observation in a histogram.
+func (s *PrometheusSink) ObserveHistogram(name string, value float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	h, ok := s.histograms[name]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(DefaultBuckets))}
+		s.histograms[name] = h
+	}
+
+	for i, bound := range DefaultBuckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+func (s *PrometheusSink) name(name string) string {
+	if len(s.prefix) == 0 {
+		return name
+	}
+
+	return s.prefix + "_" + name
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Render returns all metrics in the Prometheus
+// text exposition format.
+func (s *PrometheusSink) Render() []byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	buf := new(bytes.Buffer)
+	for _, name := range sortedKeys(s.counters) {
+		fmt.Fprintf(buf, "# TYPE %s counter\n", s.name(name))
+		fmt.Fprintf(buf, "%s %s\n", s.name(name), formatFloat(s.counters[name]))
+	}
+
+	for _, name := range sortedKeys(s.gauges) {
+		fmt.Fprintf(buf, "# TYPE %s gauge\n", s.name(name))
+		fmt.Fprintf(buf, "%s %s\n", s.name(name), formatFloat(s.gauges[name]))
+	}
+
+	histogramNames := make([]string, 0, len(s.histograms))
+	for name := range s.histograms {
+		histogramNames = append(histogramNames, name)
+	}
+	sort.Strings(histogramNames)
+
+	for _, name := range histogramNames {
+		h := s.histograms[name]
+		fullName := s.name(name)
+		fmt.Fprintf(buf, "# TYPE %s histogram\n", fullName)
+		for i, bound := range DefaultBuckets {
+			fmt.Fprintf(buf, "%s_bucket{le=\"%s\"} %d\n", fullName, formatFloat(bound), h.counts[i])
+		}
+		fmt.Fprintf(buf, "%s_bucket{le=\"+Inf\"} %d\n", fullName, h.count)
+		fmt.Fprintf(buf, "%s_sum %s\n", fullName, formatFloat(h.sum))
+		fmt.Fprintf(buf, "%s_count %d\n", fullName, h.count)
+	}
+
+	return buf.Bytes()
+}
+
+// ServeHTTP writes all metrics in response to a scrape.
+func (s *PrometheusSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write(s.Render())
+}
+
+// Serve serves metrics on addr until the context
+// is canceled.
+func (s *PrometheusSink) Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle(prometheusPath, s)
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		_ = server.Shutdown(context.Background())
+	}()
+
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
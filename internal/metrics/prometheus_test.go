@@ -0,0 +1,66 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusSink(t *testing.T) {
+	sink := NewPrometheusSink("test")
+	sink.IncrCounter(BlocksSynced, 1)
+	sink.IncrCounter(BlocksSynced, 2)
+	sink.SetGauge(SyncHeadIndex, 10)
+	sink.SetGauge(SyncHeadIndex, 12)
+	sink.ObserveHistogram(BlockFetchSeconds, 0.2)
+	sink.ObserveHistogram(BlockFetchSeconds, 20)
+
+	expected := `# TYPE test_blocks_synced counter
+test_blocks_synced 3
+# TYPE test_sync_head_index gauge
+test_sync_head_index 12
+# TYPE test_block_fetch_seconds histogram
+test_block_fetch_seconds_bucket{le="0.005"} 0
+test_block_fetch_seconds_bucket{le="0.01"} 0
+test_block_fetch_seconds_bucket{le="0.025"} 0
+test_block_fetch_seconds_bucket{le="0.05"} 0
+test_block_fetch_seconds_bucket{le="0.1"} 0
+test_block_fetch_seconds_bucket{le="0.25"} 1
+test_block_fetch_seconds_bucket{le="0.5"} 1
+test_block_fetch_seconds_bucket{le="1"} 1
+test_block_fetch_seconds_bucket{le="2.5"} 1
+test_block_fetch_seconds_bucket{le="5"} 1
+test_block_fetch_seconds_bucket{le="10"} 1
+test_block_fetch_seconds_bucket{le="+Inf"} 2
+test_block_fetch_seconds_sum 20.2
+test_block_fetch_seconds_count 2
+`
+	assert.Equal(t, expected, string(sink.Render()))
+
+	server := httptest.NewServer(sink)
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(body))
+}
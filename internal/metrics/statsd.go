@@ -0,0 +1,72 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"fmt"
+	"net"
+)
+
+// StatsDSink is a Sink that sends metrics to a
+// StatsD server over UDP.
+type StatsDSink struct {
+	prefix string
+	conn   net.Conn
+}
+
+// NewStatsDSink returns a new StatsDSink that sends metrics
+// to addr. Each metric name is prefixed with prefix and a
+// period.
+func NewStatsDSink(addr string, prefix string) (*StatsDSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StatsDSink{
+		prefix: prefix,
+		conn:   conn,
+	}, nil
+}
+
+// send writes a single metric. Metrics are sent on
+// a best-effort basis, so write errors are ignored.
+func (s *StatsDSink) send(name string, value float64, metricType string) {
+	if len(s.prefix) > 0 {
+		name = s.prefix + "." + name
+	}
+
+	_, _ = fmt.Fprintf(s.conn, "%s:%s|%s", name, formatFloat(value), metricType)
+}
+
+// IncrCounter sends a counter increment.
+func (s *StatsDSink) IncrCounter(name string, delta float64) {
+	s.send(name, delta, "c")
+}
+
+// SetGauge sends a gauge value.
+func (s *StatsDSink) SetGauge(name string, value float64) {
+	s.send(name, value, "g")
+}
+
+// ObserveHistogram sends a histogram observation.
+func (s *StatsDSink) ObserveHistogram(name string, value float64) {
+	s.send(name, value, "h")
+}
+
+// Close closes the connection to the StatsD server.
+func (s *StatsDSink) Close() error {
+	return s.conn.Close()
+}
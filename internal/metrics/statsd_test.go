@@ -0,0 +1,50 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatsDSink(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer server.Close()
+
+	sink, err := NewStatsDSink(server.LocalAddr().String(), "test")
+	assert.NoError(t, err)
+	defer sink.Close()
+
+	read := func() string {
+		buf := make([]byte, 1024)
+		assert.NoError(t, server.SetReadDeadline(time.Now().Add(time.Second)))
+		n, _, err := server.ReadFrom(buf)
+		assert.NoError(t, err)
+		return string(buf[:n])
+	}
+
+	sink.IncrCounter(BlocksSynced, 1)
+	assert.Equal(t, "test.blocks_synced:1|c", read())
+
+	sink.SetGauge(SyncHeadIndex, 12)
+	assert.Equal(t, "test.sync_head_index:12|g", read())
+
+	sink.ObserveHistogram(BlockFetchSeconds, 0.25)
+	assert.Equal(t, "test.block_fetch_seconds:0.25|h", read())
+}
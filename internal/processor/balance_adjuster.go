@@ -0,0 +1,40 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processor
+
+import (
+	"context"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+// BalanceAdjustment is a change to the balance of an
+// account that is not expressed by the operations of a
+// block.
+type BalanceAdjustment struct {
+	Account *rosetta.AccountIdentifier
+	Amount  *rosetta.Amount
+}
+
+// BalanceAdjuster is implemented for chains whose balances
+// change in ways their operations can't express (ex: coinbase
+// maturity or rent deductions). The BalanceAdjustments of a
+// block are applied (and reverted if the block is orphaned)
+// along with the balance changes of its operations, so
+// AdjustBalances must return the same BalanceAdjustments each
+// time it is called with a block.
+type BalanceAdjuster interface {
+	AdjustBalances(ctx context.Context, block *rosetta.Block) ([]*BalanceAdjustment, error)
+}
@@ -0,0 +1,48 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processor
+
+import (
+	"context"
+	"fmt"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+// BlockHook is implemented to enforce invariants specific
+// to a chain (ex: every block contains exactly one coinbase
+// operation). ValidateBlock is called with each block after
+// it has been asserted and before it is stored. Returning an
+// error stops validation.
+type BlockHook interface {
+	ValidateBlock(ctx context.Context, block *rosetta.Block) error
+}
+
+// BlockHookError is returned when a BlockHook
+// rejects a block.
+type BlockHookError struct {
+	Block *rosetta.BlockIdentifier
+	Err   error
+}
+
+// Error returns a description of the BlockHookError.
+func (e *BlockHookError) Error() string {
+	return fmt.Sprintf("block hook rejected block %+v: %v", e.Block, e.Err)
+}
+
+// Unwrap returns the error returned by the BlockHook.
+func (e *BlockHookError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,66 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processor
+
+import (
+	"context"
+	"errors"
+
+	"github.com/coinbase/rosetta-validator/internal/storage"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+// BlockQueue implements the syncer.Queue interface
+// on top of BlockStorage. Queued blocks are removed
+// by the SyncHandler as it processes them.
+type BlockQueue struct {
+	storage *storage.BlockStorage
+}
+
+// NewBlockQueue returns a new BlockQueue.
+func NewBlockQueue(storage *storage.BlockStorage) *BlockQueue {
+	return &BlockQueue{
+		storage: storage,
+	}
+}
+
+// QueueBlocks stores all blocks in a single
+// database transaction.
+func (q *BlockQueue) QueueBlocks(ctx context.Context, blocks []*rosetta.Block) error {
+	return q.storage.Update(ctx, func(tx storage.DatabaseTransaction) error {
+		for _, block := range blocks {
+			if err := q.storage.QueueBlock(ctx, tx, block); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// QueuedBlock returns the block queued at an index
+// or nil if no block is queued at the index.
+func (q *BlockQueue) QueuedBlock(ctx context.Context, index int64) (*rosetta.Block, error) {
+	tx := q.storage.NewDatabaseTransaction(ctx, false)
+	defer tx.Discard(ctx)
+
+	block, err := q.storage.GetQueuedBlock(ctx, tx, index)
+	if errors.Is(err, storage.ErrQueuedBlockNotFound) {
+		return nil, nil
+	}
+
+	return block, err
+}
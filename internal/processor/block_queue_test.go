@@ -0,0 +1,52 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coinbase/rosetta-validator/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockQueue(t *testing.T) {
+	ctx := context.Background()
+
+	newDir, err := storage.CreateTempDir()
+	assert.NoError(t, err)
+	defer storage.RemoveTempDir(*newDir)
+
+	database, err := storage.NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	queue := NewBlockQueue(storage.NewBlockStorage(ctx, database, &storage.GobCodec{}, &storage.SHA256KeyHasher{}))
+
+	t.Run("No block queued", func(t *testing.T) {
+		block, err := queue.QueuedBlock(ctx, 1)
+		assert.NoError(t, err)
+		assert.Nil(t, block)
+	})
+
+	t.Run("Queue blocks", func(t *testing.T) {
+		assert.NoError(t, queue.QueueBlocks(ctx, blockSequence))
+
+		block, err := queue.QueuedBlock(ctx, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, blockSequence[1], block)
+	})
+}
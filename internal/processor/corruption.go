@@ -0,0 +1,331 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/coinbase/rosetta-validator/internal/reconciler"
+	"github.com/coinbase/rosetta-validator/internal/storage"
+
+	"github.com/coinbase/rosetta-sdk-go/fetcher"
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+// corruptionFetchBatch is the number of blocks fetched
+// at once to re-derive a corrupted balance.
+const corruptionFetchBatch = 100
+
+// maxBalanceRecoveryAttempts is the number of times the
+// balance changes fetched to re-derive a corrupted balance
+// are discarded because blocks were confirmed meanwhile.
+const maxBalanceRecoveryAttempts = 3
+
+// errConfirmedChanged is returned when blocks are confirmed
+// while re-deriving a corrupted balance.
+var errConfirmedChanged = errors.New("confirmed block changed")
+
+// CorruptionFetcher fetches the blocks used to restore
+// corrupted values (see SetCorruptionRecovery).
+type CorruptionFetcher interface {
+	BlockRange(
+		ctx context.Context,
+		network *rosetta.NetworkIdentifier,
+		startIndex int64,
+		endIndex int64,
+	) (map[int64]*fetcher.BlockAndLatency, error)
+
+	BlockRetry(
+		ctx context.Context,
+		network *rosetta.NetworkIdentifier,
+		blockIdentifier *rosetta.PartialBlockIdentifier,
+		maxElapsedTime time.Duration,
+		maxRetries uint64,
+	) (*rosetta.Block, error)
+}
+
+// SetCorruptionRecovery enables RecoverCorruption, which
+// fetches the blocks of network with fetcher. It must be
+// called before any blocks are processed.
+func (h *SyncHandler) SetCorruptionRecovery(
+	network *rosetta.NetworkIdentifier,
+	fetcher CorruptionFetcher,
+) {
+	h.corruptionNetwork = network
+	h.corruptionFetcher = fetcher
+}
+
+// SetRetries changes the number of times (and the time
+// spent) retrying a failed request for a block fetched by
+// RecoverCorruption with BlockRetry. Blocks fetched with
+// BlockRange are retried by the CorruptionFetcher. It must
+// be called before any blocks are processed.
+func (h *SyncHandler) SetRetries(maxElapsedTime time.Duration, maxRetries uint64) {
+	h.maxElapsedTime = maxElapsedTime
+	h.maxRetries = maxRetries
+}
+
+// RecoverCorruption restores the stored value that caused
+// err instead of requiring DATA_DIR to be wiped. A corrupted
+// block is fetched again. A corrupted balance is restored
+// from its last balance history entry that can be decoded,
+// and the balance changes of any later confirmed blocks are
+// fetched and applied again. The restored balance is queued
+// for reconciliation. err is returned if recovery was not
+// enabled with SetCorruptionRecovery.
+func (h *SyncHandler) RecoverCorruption(
+	ctx context.Context,
+	err *storage.CorruptionError,
+) error {
+	if h.corruptionFetcher == nil {
+		return err
+	}
+
+	if err.Block != nil {
+		return h.recoverBlock(ctx, err.Block)
+	}
+
+	for attempt := 1; ; attempt++ {
+		recoverErr := h.recoverBalance(ctx, err.Account)
+		if !errors.Is(recoverErr, errConfirmedChanged) || attempt == maxBalanceRecoveryAttempts {
+			return recoverErr
+		}
+	}
+}
+
+// recoverBlock replaces a corrupted block with the
+// block fetched from the Rosetta Server.
+func (h *SyncHandler) recoverBlock(
+	ctx context.Context,
+	blockIdentifier *rosetta.BlockIdentifier,
+) error {
+	block, err := h.corruptionFetcher.BlockRetry(
+		ctx,
+		h.corruptionNetwork,
+		&rosetta.PartialBlockIdentifier{
+			Index: &blockIdentifier.Index,
+			Hash:  &blockIdentifier.Hash,
+		},
+		h.maxElapsedTime,
+		h.maxRetries,
+	)
+	if err != nil {
+		return fmt.Errorf("%w: unable to fetch corrupted block %+v", err, blockIdentifier)
+	}
+
+	if *block.BlockIdentifier != *blockIdentifier {
+		return fmt.Errorf(
+			"fetched block %+v instead of corrupted block %+v",
+			block.BlockIdentifier,
+			blockIdentifier,
+		)
+	}
+
+	if err := h.storage.RestoreBlock(ctx, block); err != nil {
+		return err
+	}
+
+	log.Printf("Restored corrupted block %+v\n", blockIdentifier)
+	return nil
+}
+
+// confirmedBlock returns the last block whose balance
+// changes have been applied.
+func (h *SyncHandler) confirmedBlock(
+	ctx context.Context,
+	dbTx storage.DatabaseTransaction,
+) (*rosetta.BlockIdentifier, error) {
+	pending, err := h.storage.GetPendingBlocks(ctx, dbTx)
+	if err != nil {
+		return nil, err
+	}
+
+	if pending != nil {
+		return pending.Confirmed, nil
+	}
+
+	return h.storage.GetHeadBlockIdentifier(ctx, dbTx)
+}
+
+// accountDelta is the net change to the balance of an
+// account in a currency from the operations in a block.
+type accountDelta struct {
+	block      *rosetta.BlockIdentifier
+	currency   *rosetta.Currency
+	difference *big.Int
+}
+
+// fetchAccountDeltas fetches the blocks after startIndex up
+// to confirmed and returns the balance changes of account in
+// each. The fetched blocks must end at confirmed.
+func (h *SyncHandler) fetchAccountDeltas(
+	ctx context.Context,
+	account *rosetta.AccountIdentifier,
+	startIndex int64,
+	confirmed *rosetta.BlockIdentifier,
+) ([]*accountDelta, error) {
+	accountKey := storage.GetAccountKey(account)
+	accountDeltas := []*accountDelta{}
+	var parent *rosetta.BlockIdentifier
+	for start := startIndex + 1; start <= confirmed.Index; start += corruptionFetchBatch {
+		end := start + corruptionFetchBatch - 1
+		if end > confirmed.Index {
+			end = confirmed.Index
+		}
+
+		blocks, err := h.corruptionFetcher.BlockRange(ctx, h.corruptionNetwork, start, end)
+		if err != nil {
+			return nil, fmt.Errorf("%w: unable to fetch blocks %d-%d", err, start, end)
+		}
+
+		for index := start; index <= end; index++ {
+			fetched, ok := blocks[index]
+			if !ok || fetched.Block == nil {
+				return nil, fmt.Errorf("block %d was not fetched", index)
+			}
+
+			block := fetched.Block
+			if parent != nil && *block.ParentBlockIdentifier != *parent {
+				return nil, fmt.Errorf(
+					"fetched block %+v does not follow %+v",
+					block.BlockIdentifier,
+					parent,
+				)
+			}
+			parent = block.BlockIdentifier
+
+			deltas, err := h.blockBalanceDeltas(ctx, block)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, delta := range deltas {
+				if storage.GetAccountKey(delta.account) != accountKey {
+					continue
+				}
+
+				accountDeltas = append(accountDeltas, &accountDelta{
+					block:      block.BlockIdentifier,
+					currency:   delta.currency,
+					difference: delta.difference,
+				})
+			}
+		}
+	}
+
+	if parent != nil && *parent != *confirmed {
+		return nil, fmt.Errorf("fetched block %+v instead of confirmed block %+v", parent, confirmed)
+	}
+
+	return accountDeltas, nil
+}
+
+// recoverBalance re-derives a corrupted balance from its
+// last balance history entry that can be decoded and the
+// balance changes of the confirmed blocks after it. It
+// returns errConfirmedChanged if blocks were confirmed
+// while the balance changes were fetched.
+func (h *SyncHandler) recoverBalance(
+	ctx context.Context,
+	account *rosetta.AccountIdentifier,
+) error {
+	dbTx := h.storage.NewDatabaseTransaction(ctx, false)
+	confirmed, err := h.confirmedBlock(ctx, dbTx)
+	if err != nil {
+		dbTx.Discard(ctx)
+		return err
+	}
+
+	amounts, updated, err := h.storage.LastIntactBalance(ctx, dbTx, account, confirmed.Index)
+	dbTx.Discard(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: unable to restore corrupted balance", err)
+	}
+
+	deltas, err := h.fetchAccountDeltas(ctx, account, updated.Index, confirmed)
+	if err != nil {
+		return err
+	}
+
+	accounts := []*reconciler.AccountAndCurrency{}
+	err = h.storage.Update(ctx, func(dbTx storage.DatabaseTransaction) error {
+		current, err := h.confirmedBlock(ctx, dbTx)
+		if err != nil {
+			return err
+		}
+
+		if *current != *confirmed {
+			return errConfirmedChanged
+		}
+
+		restored := make(map[string]*rosetta.Amount, len(amounts))
+		for key, amount := range amounts {
+			restored[key] = amount
+		}
+
+		block := updated
+		for _, delta := range deltas {
+			tracked, err := h.currencyTracked(ctx, dbTx, delta.currency, delta.block)
+			if err != nil {
+				return err
+			}
+
+			if !tracked {
+				continue
+			}
+
+			key := storage.GetCurrencyKey(delta.currency)
+			value := new(big.Int)
+			if amount, ok := restored[key]; ok {
+				if _, ok := value.SetString(amount.Value, 10); !ok {
+					return fmt.Errorf("%s is not an integer", amount.Value)
+				}
+			}
+
+			restored[key] = &rosetta.Amount{
+				Value:    value.Add(value, delta.difference).String(),
+				Currency: delta.currency,
+			}
+			block = delta.block
+		}
+
+		accounts = accounts[:0]
+		for _, amount := range restored {
+			accounts = append(accounts, &reconciler.AccountAndCurrency{
+				Account:  account,
+				Currency: amount.Currency,
+			})
+		}
+
+		return h.storage.RestoreBalance(ctx, dbTx, account, restored, block)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf(
+		"Restored corrupted balance of %+v at block %+v (%d blocks fetched)\n",
+		account,
+		confirmed,
+		confirmed.Index-updated.Index,
+	)
+	h.queueAccounts(ctx, confirmed.Index, accounts)
+	return nil
+}
@@ -0,0 +1,153 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processor
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/coinbase/rosetta-validator/internal/logger"
+	"github.com/coinbase/rosetta-validator/internal/reconciler"
+	"github.com/coinbase/rosetta-validator/internal/storage"
+	mockProcessor "github.com/coinbase/rosetta-validator/mocks/processor"
+	mockReconciler "github.com/coinbase/rosetta-validator/mocks/reconciler"
+
+	"github.com/coinbase/rosetta-sdk-go/asserter"
+	"github.com/coinbase/rosetta-sdk-go/fetcher"
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRecoverCorruption(t *testing.T) {
+	ctx := context.Background()
+
+	newDir, err := storage.CreateTempDir()
+	assert.NoError(t, err)
+	defer storage.RemoveTempDir(*newDir)
+
+	database, err := storage.NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	blockStorage := storage.NewBlockStorage(ctx, database, &storage.GobCodec{}, &storage.SHA256KeyHasher{})
+	logger := logger.NewLogger(*newDir, false, false, false, false)
+	asserter := asserter.New(ctx, networkStatusResponse)
+	rec := &mockReconciler.Reconciler{}
+	handler := NewSyncHandler(ctx, blockStorage, asserter, logger, rec, nil)
+
+	rec.On("QueueAccounts", mock.Anything, mock.Anything, mock.Anything).Twice()
+	assert.NoError(t, handler.BlockAdded(ctx, blockSequence[0]))
+	assert.NoError(t, handler.BlockAdded(ctx, blockSequence[1]))
+
+	t.Run("Recovery disabled", func(t *testing.T) {
+		corruptionErr := &storage.CorruptionError{Block: blockSequence[1].BlockIdentifier}
+		assert.Equal(t, corruptionErr, handler.RecoverCorruption(ctx, corruptionErr))
+	})
+
+	network := &rosetta.NetworkIdentifier{Blockchain: "blah", Network: "testnet"}
+	mockFetcher := &mockProcessor.CorruptionFetcher{}
+	handler.SetCorruptionRecovery(network, mockFetcher)
+
+	t.Run("Corrupted block", func(t *testing.T) {
+		mockFetcher.On(
+			"BlockRetry",
+			ctx,
+			network,
+			&rosetta.PartialBlockIdentifier{
+				Index: &blockSequence[1].BlockIdentifier.Index,
+				Hash:  &blockSequence[1].BlockIdentifier.Hash,
+			},
+			fetcher.DefaultElapsedTime,
+			uint64(fetcher.DefaultRetries),
+		).Return(blockSequence[1], nil).Once()
+
+		assert.NoError(t, handler.RecoverCorruption(ctx, &storage.CorruptionError{
+			Block: blockSequence[1].BlockIdentifier,
+		}))
+
+		tx := blockStorage.NewDatabaseTransaction(ctx, false)
+		block, err := blockStorage.GetBlock(ctx, tx, blockSequence[1].BlockIdentifier)
+		tx.Discard(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, blockSequence[1], block)
+	})
+
+	t.Run("Fetched block differs", func(t *testing.T) {
+		mockFetcher.On(
+			"BlockRetry",
+			ctx,
+			network,
+			mock.Anything,
+			fetcher.DefaultElapsedTime,
+			uint64(fetcher.DefaultRetries),
+		).Return(blockSequence[2], nil).Once()
+
+		err := handler.RecoverCorruption(ctx, &storage.CorruptionError{
+			Block: blockSequence[1].BlockIdentifier,
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("Corrupted balance", func(t *testing.T) {
+		// Only the balance history entry recorded before
+		// block 1 remains intact, so the balance change of
+		// block 1 is fetched and applied again.
+		assert.NoError(t, blockStorage.Update(ctx, func(tx storage.DatabaseTransaction) error {
+			return blockStorage.RestoreBalance(
+				ctx,
+				tx,
+				recipient,
+				map[string]*rosetta.Amount{},
+				blockSequence[0].BlockIdentifier,
+			)
+		}))
+
+		mockFetcher.On(
+			"BlockRange",
+			ctx,
+			network,
+			int64(1),
+			int64(1),
+		).Return(map[int64]*fetcher.BlockAndLatency{
+			1: {Block: blockSequence[1]},
+		}, nil).Once()
+		rec.On(
+			"QueueAccounts",
+			ctx,
+			int64(1),
+			[]*reconciler.AccountAndCurrency{{Account: recipient, Currency: currency}},
+		).Once()
+
+		assert.NoError(t, handler.RecoverCorruption(ctx, &storage.CorruptionError{
+			Account: recipient,
+			Err:     errors.New("corrupted"),
+		}))
+
+		tx := blockStorage.NewDatabaseTransaction(ctx, false)
+		amounts, block, err := blockStorage.GetBalance(ctx, tx, recipient)
+		tx.Discard(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, blockSequence[1].BlockIdentifier, block)
+		assert.Equal(t, map[string]*rosetta.Amount{
+			storage.GetCurrencyKey(currency): recipientAmount,
+		}, amounts)
+	})
+
+	mockFetcher.AssertExpectations(t)
+	rec.AssertExpectations(t)
+}
@@ -0,0 +1,170 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processor
+
+import (
+	"context"
+	"errors"
+
+	"github.com/coinbase/rosetta-validator/internal/storage"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// minParallelAccounts is the number of accounts a block
+// must modify for its balance changes to be applied in
+// parallel. Smaller blocks are applied faster serially.
+const minParallelAccounts = 64
+
+// updateBalance updates the balance of the account
+// of delta in dbTx.
+func (h *SyncHandler) updateBalance(
+	ctx context.Context,
+	dbTx storage.DatabaseTransaction,
+	delta *balanceDelta,
+	blockIdentifier *rosetta.BlockIdentifier,
+) error {
+	return h.storage.UpdateBalance(
+		ctx,
+		dbTx,
+		delta.account,
+		&rosetta.Amount{
+			Value:    delta.difference.String(),
+			Currency: delta.currency,
+		},
+		blockIdentifier,
+	)
+}
+
+// applyBalanceDeltas updates the balance of each account
+// by its delta at blockIdentifier and returns the deltas
+// that were applied (balance errors of blocks in the skip
+// list, or of any block if continueOnError is set, are
+// skipped).
+func (h *SyncHandler) applyBalanceDeltas(
+	ctx context.Context,
+	dbTx storage.DatabaseTransaction,
+	block *rosetta.Block,
+	blockIdentifier *rosetta.BlockIdentifier,
+	deltas []*balanceDelta,
+) ([]*balanceDelta, error) {
+	// Skipped errors are only handled serially
+	// so that each is logged once.
+	if h.balanceWorkers > 1 && !h.skipList.Contains(block.BlockIdentifier) {
+		accounts := groupByAccount(deltas)
+		if len(accounts) >= minParallelAccounts {
+			applied, err := h.applyBalanceDeltasInParallel(ctx, dbTx, blockIdentifier, accounts)
+			if err != nil {
+				return nil, err
+			}
+
+			if applied {
+				return deltas, nil
+			}
+		}
+	}
+
+	applied := make([]*balanceDelta, 0, len(deltas))
+	for _, delta := range deltas {
+		err := h.updateBalance(ctx, dbTx, delta, blockIdentifier)
+		if h.skipBalanceError(block.BlockIdentifier, err) {
+			err = h.storeBalanceViolation(ctx, dbTx, block.BlockIdentifier, delta, err)
+			if err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		applied = append(applied, delta)
+	}
+
+	return applied, nil
+}
+
+// groupByAccount groups deltas by account (each account
+// may have a delta in several currencies), preserving
+// the order of deltas within each group.
+func groupByAccount(deltas []*balanceDelta) [][]*balanceDelta {
+	groups := [][]*balanceDelta{}
+	groupIndices := map[string]int{}
+	for _, delta := range deltas {
+		key := storage.GetAccountKey(delta.account)
+		if i, ok := groupIndices[key]; ok {
+			groups[i] = append(groups[i], delta)
+			continue
+		}
+
+		groupIndices[key] = len(groups)
+		groups = append(groups, []*balanceDelta{delta})
+	}
+
+	return groups
+}
+
+// applyBalanceDeltasInParallel applies the deltas of each
+// account in accounts with up to balanceWorkers goroutines.
+// Their writes are buffered and only applied to dbTx once
+// every delta has been applied without error and no two
+// goroutines accessed the same key. Otherwise, dbTx is
+// unchanged and false is returned so that the deltas are
+// applied serially instead.
+func (h *SyncHandler) applyBalanceDeltasInParallel(
+	ctx context.Context,
+	dbTx storage.DatabaseTransaction,
+	blockIdentifier *rosetta.BlockIdentifier,
+	accounts [][]*balanceDelta,
+) (bool, error) {
+	workers := h.balanceWorkers
+	if workers > len(accounts) {
+		workers = len(accounts)
+	}
+
+	buffers := storage.NewBufferedTransactions(dbTx, workers)
+	g, gctx := errgroup.WithContext(ctx)
+	for i, buffer := range buffers {
+		i, buffer := i, buffer
+		g.Go(func() error {
+			for j := i; j < len(accounts); j += workers {
+				for _, delta := range accounts[j] {
+					if err := h.updateBalance(gctx, buffer, delta, blockIdentifier); err != nil {
+						return err
+					}
+				}
+			}
+
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return false, nil
+	}
+
+	err := storage.FlushBufferedTransactions(ctx, dbTx, buffers)
+	if errors.Is(err, storage.ErrBufferConflict) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,322 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/coinbase/rosetta-validator/internal/reconciler"
+	"github.com/coinbase/rosetta-validator/internal/storage"
+
+	"github.com/coinbase/rosetta-sdk-go/asserter"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+var (
+	// ErrBlocksNotStored is returned by Reprocess if the
+	// blocks between genesis and the head block are not all
+	// stored (ex: they were pruned or the validator started
+	// from a START_INDEX or an imported state bundle).
+	ErrBlocksNotStored = errors.New("blocks not stored")
+
+	// ErrReorgInProgress is returned by Reprocess if the
+	// source BlockStorage is in the middle of a reorg.
+	ErrReorgInProgress = errors.New("reorg in progress")
+)
+
+// discardLogger is a Logger that records nothing. It is
+// used when reprocessing blocks that were already logged
+// when they were first synced.
+type discardLogger struct{}
+
+func (l *discardLogger) BlockStream(
+	ctx context.Context,
+	block *rosetta.Block,
+	orphan bool,
+) error {
+	return nil
+}
+
+func (l *discardLogger) TransactionStream(ctx context.Context, block *rosetta.Block) error {
+	return nil
+}
+
+func (l *discardLogger) BalanceStream(
+	ctx context.Context,
+	balanceChanges []*storage.BalanceChange,
+) error {
+	return nil
+}
+
+// BalanceMismatch is a balance that differs between
+// the source and target of Reprocess.
+type BalanceMismatch struct {
+	Account     *rosetta.AccountIdentifier `json:"account"`
+	Currency    *rosetta.Currency          `json:"currency"`
+	Stored      string                     `json:"stored"`
+	Reprocessed string                     `json:"reprocessed"`
+}
+
+// ReprocessResult summarizes a call to Reprocess.
+type ReprocessResult struct {
+	// Head is the last block reprocessed.
+	Head *rosetta.BlockIdentifier
+
+	Blocks     int
+	Accounts   int
+	Mismatches []*BalanceMismatch
+}
+
+// canonicalBlocks returns the identifiers of the blocks
+// after genesis up to head (oldest first) by walking back
+// from head.
+func canonicalBlocks(
+	ctx context.Context,
+	source *storage.BlockStorage,
+	genesis *rosetta.BlockIdentifier,
+	head *rosetta.BlockIdentifier,
+) ([]*rosetta.BlockIdentifier, error) {
+	txn := source.NewDatabaseTransaction(ctx, false)
+	defer txn.Discard(ctx)
+
+	// Fail before walking back from head if
+	// any blocks were pruned.
+	pruned, err := source.PrunedIndex(ctx, txn)
+	if err != nil {
+		return nil, err
+	}
+
+	if pruned > genesis.Index {
+		return nil, fmt.Errorf("%w: blocks up to %d were pruned", ErrBlocksNotStored, pruned)
+	}
+
+	blocks := make([]*rosetta.BlockIdentifier, head.Index-genesis.Index)
+	for current := head; current.Index > genesis.Index; {
+		block, err := source.GetUnprunedBlock(ctx, txn, current)
+		if errors.Is(err, storage.ErrBlockNotFound) {
+			return nil, fmt.Errorf("%w: block %+v is missing", ErrBlocksNotStored, current)
+		}
+		if errors.Is(err, storage.ErrBlockPruned) {
+			return nil, fmt.Errorf("%w: block %+v was pruned", ErrBlocksNotStored, current)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		blocks[current.Index-genesis.Index-1] = current
+		current = block.ParentBlockIdentifier
+	}
+
+	return blocks, nil
+}
+
+// amountValue returns the value of the amount of
+// currencyKey in amounts (0 if it is missing).
+func amountValue(amounts map[string]*rosetta.Amount, currencyKey string) (*big.Int, error) {
+	amount, ok := amounts[currencyKey]
+	if !ok {
+		return new(big.Int), nil
+	}
+
+	value, ok := new(big.Int).SetString(amount.Value, 10)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an integer", amount.Value)
+	}
+
+	return value, nil
+}
+
+// balances returns the balances of account in
+// blockStorage (empty if it has no balance).
+func balances(
+	ctx context.Context,
+	blockStorage *storage.BlockStorage,
+	account *rosetta.AccountIdentifier,
+) (map[string]*rosetta.Amount, error) {
+	txn := blockStorage.NewDatabaseTransaction(ctx, false)
+	defer txn.Discard(ctx)
+
+	amounts, _, err := blockStorage.GetBalance(ctx, txn, account)
+	if errors.Is(err, storage.ErrAccountNotFound) {
+		return map[string]*rosetta.Amount{}, nil
+	}
+
+	return amounts, err
+}
+
+// compareBalances returns the tracked balances that
+// differ between source and target.
+func compareBalances(
+	ctx context.Context,
+	source *storage.BlockStorage,
+	target *storage.BlockStorage,
+	currencies []*storage.RegisteredCurrency,
+) (int, []*BalanceMismatch, error) {
+	sourceAccounts, err := source.BalanceAccounts(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	targetAccounts, err := target.BalanceAccounts(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	seen := map[string]bool{}
+	mismatches := []*BalanceMismatch{}
+	for _, account := range append(sourceAccounts, targetAccounts...) {
+		key := storage.GetAccountKey(account)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		stored, err := balances(ctx, source, account)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		reprocessed, err := balances(ctx, target, account)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		for _, currency := range currencies {
+			if !currency.Tracked {
+				continue
+			}
+
+			storedValue, err := amountValue(stored, currency.Key)
+			if err != nil {
+				return 0, nil, fmt.Errorf("%w: stored balance of %+v", err, account)
+			}
+
+			reprocessedValue, err := amountValue(reprocessed, currency.Key)
+			if err != nil {
+				return 0, nil, fmt.Errorf("%w: reprocessed balance of %+v", err, account)
+			}
+
+			if storedValue.Cmp(reprocessedValue) != 0 {
+				mismatches = append(mismatches, &BalanceMismatch{
+					Account:     account,
+					Currency:    currency.Currency,
+					Stored:      storedValue.String(),
+					Reprocessed: reprocessedValue.String(),
+				})
+			}
+		}
+	}
+
+	return len(seen), mismatches, nil
+}
+
+// Reprocess re-derives balances by applying the blocks stored
+// in source (after genesis up to its last confirmed block) to
+// an empty target BlockStorage without fetching anything from
+// the Rosetta Server, and then compares the balances of every
+// account in source and target. Currencies are registered in
+// target as they are in source, so only the balances of
+// currencies tracked in source are compared.
+func Reprocess(
+	ctx context.Context,
+	source *storage.BlockStorage,
+	target *storage.BlockStorage,
+	asserter *asserter.Asserter,
+	genesis *rosetta.BlockIdentifier,
+) (*ReprocessResult, error) {
+	txn := source.NewDatabaseTransaction(ctx, false)
+	intent, err := source.GetReorgIntent(ctx, txn)
+	if err != nil {
+		txn.Discard(ctx)
+		return nil, err
+	}
+
+	head, err := source.GetConfirmedBlockIdentifier(ctx, txn)
+	txn.Discard(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if intent != nil {
+		return nil, fmt.Errorf("%w: resume syncing to complete it", ErrReorgInProgress)
+	}
+
+	blocks, err := canonicalBlocks(ctx, source, genesis, head)
+	if err != nil {
+		return nil, err
+	}
+
+	currencies, err := source.Currencies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = target.Update(ctx, func(tx storage.DatabaseTransaction) error {
+		for _, currency := range currencies {
+			_, _, err := target.RegisterCurrency(
+				ctx,
+				tx,
+				currency.Currency,
+				currency.FirstSeen,
+				currency.Tracked,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	handler := NewSyncHandler(
+		ctx,
+		target,
+		asserter,
+		&discardLogger{},
+		&reconciler.NoOpReconciler{},
+		nil,
+	)
+	for _, blockIdentifier := range blocks {
+		txn := source.NewDatabaseTransaction(ctx, false)
+		block, err := source.GetUnprunedBlock(ctx, txn, blockIdentifier)
+		txn.Discard(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := handler.BlockAdded(ctx, block); err != nil {
+			return nil, fmt.Errorf("%w: unable to reprocess block %+v", err, blockIdentifier)
+		}
+	}
+
+	accounts, mismatches, err := compareBalances(ctx, source, target, currencies)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReprocessResult{
+		Head:       head,
+		Blocks:     len(blocks),
+		Accounts:   accounts,
+		Mismatches: mismatches,
+	}, nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processor
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/coinbase/rosetta-validator/internal/generator"
+	"github.com/coinbase/rosetta-validator/internal/reconciler"
+	"github.com/coinbase/rosetta-validator/internal/storage"
+
+	"github.com/coinbase/rosetta-sdk-go/asserter"
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReprocess(t *testing.T) {
+	ctx := context.Background()
+	gen := generator.New(generator.Config{
+		Height:                   20,
+		TransactionsPerBlock:     2,
+		OperationsPerTransaction: 2,
+		Accounts:                 5,
+		Seed:                     1,
+	})
+	networkStatus := gen.NetworkStatus()
+	genesis := networkStatus.NetworkStatus.NetworkInformation.GenesisBlockIdentifier
+	asserter := asserter.New(ctx, networkStatus)
+
+	newStorage := func() *storage.BlockStorage {
+		return storage.NewBlockStorage(
+			ctx,
+			storage.NewMemoryStorage(),
+			&storage.GobCodec{},
+			&storage.SHA256KeyHasher{},
+		)
+	}
+
+	source := newStorage()
+	handler := NewSyncHandler(ctx, source, asserter, &discardLogger{}, &reconciler.NoOpReconciler{}, nil)
+	for i := int64(1); i <= 20; i++ {
+		assert.NoError(t, handler.BlockAdded(ctx, gen.Block(i)))
+	}
+
+	t.Run("Balances match", func(t *testing.T) {
+		result, err := Reprocess(ctx, source, newStorage(), asserter, genesis)
+		assert.NoError(t, err)
+		assert.Equal(t, gen.Block(20).BlockIdentifier, result.Head)
+		assert.Equal(t, 20, result.Blocks)
+		assert.Equal(t, 5, result.Accounts)
+		assert.Len(t, result.Mismatches, 0)
+	})
+
+	t.Run("Balance mismatch", func(t *testing.T) {
+		account := &rosetta.AccountIdentifier{Address: "account-0"}
+		assert.NoError(t, source.Update(ctx, func(tx storage.DatabaseTransaction) error {
+			return source.UpdateBalance(
+				ctx,
+				tx,
+				account,
+				&rosetta.Amount{Value: "1", Currency: generator.Currency},
+				gen.Block(20).BlockIdentifier,
+			)
+		}))
+
+		result, err := Reprocess(ctx, source, newStorage(), asserter, genesis)
+		assert.NoError(t, err)
+		assert.Len(t, result.Mismatches, 1)
+		mismatch := result.Mismatches[0]
+		assert.Equal(t, account, mismatch.Account)
+		assert.Equal(t, generator.Currency, mismatch.Currency)
+
+		stored, _ := strconv.Atoi(mismatch.Stored)
+		reprocessed, _ := strconv.Atoi(mismatch.Reprocessed)
+		assert.Equal(t, 1, stored-reprocessed)
+	})
+
+	t.Run("Blocks not stored", func(t *testing.T) {
+		pruned := newStorage()
+		handler := NewSyncHandler(ctx, pruned, asserter, &discardLogger{}, &reconciler.NoOpReconciler{}, nil)
+		for i := int64(5); i <= 10; i++ {
+			assert.NoError(t, handler.BlockAdded(ctx, gen.Block(i)))
+		}
+
+		_, err := Reprocess(ctx, pruned, newStorage(), asserter, genesis)
+		assert.True(t, errors.Is(err, ErrBlocksNotStored))
+	})
+
+	t.Run("Blocks pruned", func(t *testing.T) {
+		pruned := newStorage()
+		handler := NewSyncHandler(ctx, pruned, asserter, &discardLogger{}, &reconciler.NoOpReconciler{}, nil)
+		for i := int64(1); i <= 10; i++ {
+			assert.NoError(t, handler.BlockAdded(ctx, gen.Block(i)))
+		}
+
+		count, err := pruned.PruneBlocks(ctx, 5)
+		assert.NoError(t, err)
+		assert.Equal(t, 5, count)
+
+		_, err = Reprocess(ctx, pruned, newStorage(), asserter, genesis)
+		assert.True(t, errors.Is(err, ErrBlocksNotStored))
+	})
+}
@@ -0,0 +1,869 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/coinbase/rosetta-validator/internal/reconciler"
+	"github.com/coinbase/rosetta-validator/internal/storage"
+	"github.com/coinbase/rosetta-validator/internal/utils"
+
+	"github.com/coinbase/rosetta-sdk-go/asserter"
+	"github.com/coinbase/rosetta-sdk-go/fetcher"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+// Logger is used by the SyncHandler to record
+// processed blocks and balance changes.
+type Logger interface {
+	BlockStream(ctx context.Context, block *rosetta.Block, orphan bool) error
+	TransactionStream(ctx context.Context, block *rosetta.Block) error
+	BalanceStream(ctx context.Context, balanceChanges []*storage.BalanceChange) error
+}
+
+// SyncHandler implements the syncer.Handler interface. It
+// stores each processed block, applies its balance changes
+// to BlockStorage, and queues modified accounts for
+// reconciliation.
+type SyncHandler struct {
+	storage    *storage.BlockStorage
+	asserter   *asserter.Asserter
+	logger     Logger
+	reconciler reconciler.Reconciler
+
+	// trusted is the last trusted checkpoint, if any.
+	// Accounts modified at or below it are not queued
+	// for reconciliation.
+	trusted *rosetta.BlockIdentifier
+
+	// compactionDepth is the number of blocks a reorg must
+	// orphan to trigger garbage collection once it completes
+	// (0 disables garbage collection). compactions holds
+	// the completed reorg awaiting garbage collection by
+	// RunCompaction, if any.
+	compactionDepth int64
+	compactions     chan *storage.ReorgIntent
+
+	// confirmationDepth is the number of blocks that must be
+	// added on top of a block before its balance changes are
+	// applied (0 applies them immediately).
+	confirmationDepth int
+
+	// timestampUnit is the expected unit of block
+	// timestamps (timestamps are not checked if empty).
+	timestampUnit utils.TimestampUnit
+
+	// trackNewCurrencies determines if currencies are tracked
+	// when they are first discovered. trackedCurrencies caches
+	// whether each registered currency is tracked. It is only
+	// invalidated when a currency is unregistered because
+	// tracking is only changed while no SyncHandler is
+	// running (see storage.SetCurrencyTracked).
+	trackNewCurrencies bool
+	trackedCurrencies  map[string]bool
+	currenciesMutex    sync.Mutex
+
+	// skipList contains the blocks whose balance
+	// errors are logged and skipped.
+	skipList *utils.SkipList
+
+	// dataOnly skips computing balances (blocks
+	// are only stored).
+	dataOnly bool
+
+	// adjuster provides the BalanceAdjustments of
+	// each block (if set).
+	adjuster BalanceAdjuster
+
+	// hooks validate each block before it is
+	// stored, in the order they were added.
+	hooks []BlockHook
+
+	// balanceWorkers is the number of goroutines the
+	// balance changes of a block are applied with (see
+	// SetBalanceWorkers).
+	balanceWorkers int
+
+	// continueOnError records Violations of blocks that
+	// fail assertion and skips the balance changes that
+	// can't be applied instead of returning an error.
+	continueOnError bool
+
+	// corruptionFetcher fetches the blocks of
+	// corruptionNetwork used by RecoverCorruption
+	// (which is disabled if it is nil).
+	corruptionNetwork *rosetta.NetworkIdentifier
+	corruptionFetcher CorruptionFetcher
+
+	// maxElapsedTime and maxRetries bound retrying a
+	// failed request for a block fetched by
+	// RecoverCorruption.
+	maxElapsedTime time.Duration
+	maxRetries     uint64
+}
+
+// NewSyncHandler returns a new SyncHandler. trusted
+// is the last trusted checkpoint or nil if there are
+// no checkpoints.
+func NewSyncHandler(
+	ctx context.Context,
+	blockStorage *storage.BlockStorage,
+	asserter *asserter.Asserter,
+	logger Logger,
+	reconciler reconciler.Reconciler,
+	trusted *rosetta.BlockIdentifier,
+) *SyncHandler {
+	return &SyncHandler{
+		storage:    blockStorage,
+		asserter:   asserter,
+		logger:     logger,
+		reconciler: reconciler,
+		trusted:    trusted,
+
+		compactions: make(chan *storage.ReorgIntent, 1),
+
+		trackNewCurrencies: true,
+		trackedCurrencies:  map[string]bool{},
+
+		maxElapsedTime: fetcher.DefaultElapsedTime,
+		maxRetries:     fetcher.DefaultRetries,
+	}
+}
+
+// SetReorgCompactionDepth enables garbage collection of
+// BlockStorage (by RunCompaction) after a reorg that
+// orphaned at least depth blocks completes. A depth of 0
+// disables it. It must be called before any blocks are
+// processed.
+func (h *SyncHandler) SetReorgCompactionDepth(depth int64) {
+	h.compactionDepth = depth
+}
+
+// SetConfirmationDepth delays applying the balance changes
+// of each block until depth blocks have been added on top
+// of it. Reorgs shallower than depth then orphan only pending
+// blocks, which never requires reverting balances. It must be
+// called before any blocks are processed.
+func (h *SyncHandler) SetConfirmationDepth(depth int) {
+	h.confirmationDepth = depth
+}
+
+// SetTimestampUnit rejects blocks with timestamps that
+// appear to be in a unit other than unit. It must be
+// called before any blocks are processed.
+func (h *SyncHandler) SetTimestampUnit(unit utils.TimestampUnit) {
+	h.timestampUnit = unit
+}
+
+// SetTrackNewCurrencies determines if currencies discovered
+// in blocks are tracked (their balances computed and
+// reconciled) until they are opted out. If track is false,
+// discovered currencies are untracked until they are opted in.
+// It must be called before any blocks are processed.
+func (h *SyncHandler) SetTrackNewCurrencies(track bool) {
+	h.trackNewCurrencies = track
+}
+
+// SetBalanceAdjuster applies the BalanceAdjustments returned
+// by adjuster for each block along with the balance changes of
+// its operations. It must be called before any blocks are
+// processed.
+func (h *SyncHandler) SetBalanceAdjuster(adjuster BalanceAdjuster) {
+	h.adjuster = adjuster
+}
+
+// AddBlockHook calls hook with each block before it is
+// stored. If it returns an error, the block is not stored
+// and a *BlockHookError is returned. It must be called
+// before any blocks are processed.
+func (h *SyncHandler) AddBlockHook(hook BlockHook) {
+	h.hooks = append(h.hooks, hook)
+}
+
+// SetSkipList skips (and logs) the balance changes of the
+// blocks in skipList that can't be applied (ex: because
+// they would make a balance negative) instead of returning
+// an error. It must be called before any blocks are
+// processed.
+func (h *SyncHandler) SetSkipList(skipList *utils.SkipList) {
+	h.skipList = skipList
+}
+
+// SetDataOnly stores blocks without computing the balance
+// changes of their operations, which validates blocks
+// faster when balances are not reconciled. It must be
+// called before any blocks are processed.
+func (h *SyncHandler) SetDataOnly(dataOnly bool) {
+	h.dataOnly = dataOnly
+}
+
+// SetBalanceWorkers applies the balance changes of blocks
+// that modify many accounts with up to workers goroutines,
+// each updating the balances of different accounts. If the
+// changes cannot be applied in parallel (ex: one would make
+// a balance negative), they are applied serially instead so
+// that errors are identical. A value of 1 or less applies
+// every change serially. It must be called before any blocks
+// are processed.
+func (h *SyncHandler) SetBalanceWorkers(workers int) {
+	h.balanceWorkers = workers
+}
+
+// skipBalanceError returns true (and logs err) if err
+// is a balance error of a block in the skip list or, if
+// continueOnError is set, of any block.
+func (h *SyncHandler) skipBalanceError(block *rosetta.BlockIdentifier, err error) bool {
+	listed := h.skipList.Contains(block)
+	if !listed && !h.continueOnError {
+		return false
+	}
+
+	var amountErr *storage.AmountError
+	if !errors.As(err, &amountErr) && !errors.Is(err, storage.ErrNegativeBalance) {
+		return false
+	}
+
+	if listed {
+		log.Printf("Skipping balance error in listed block %+v: %v\n", block, err)
+	} else {
+		log.Printf("Skipping balance error in block %+v: %v\n", block, err)
+	}
+
+	return true
+}
+
+// currencyTracked registers a currency the first time it
+// appears in a block and returns true if it is tracked.
+// Only currencies registered in a committed transaction
+// are cached.
+func (h *SyncHandler) currencyTracked(
+	ctx context.Context,
+	dbTx storage.DatabaseTransaction,
+	currency *rosetta.Currency,
+	block *rosetta.BlockIdentifier,
+) (bool, error) {
+	key := storage.GetCurrencyKey(currency)
+	h.currenciesMutex.Lock()
+	tracked, ok := h.trackedCurrencies[key]
+	h.currenciesMutex.Unlock()
+	if ok {
+		return tracked, nil
+	}
+
+	registered, discovered, err := h.storage.RegisterCurrency(
+		ctx,
+		dbTx,
+		currency,
+		block,
+		h.trackNewCurrencies,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	if discovered {
+		log.Printf(
+			"Discovered currency %+v in block %+v (tracked: %t)\n",
+			currency,
+			block,
+			registered.Tracked,
+		)
+		return registered.Tracked, nil
+	}
+
+	h.currenciesMutex.Lock()
+	h.trackedCurrencies[key] = registered.Tracked
+	h.currenciesMutex.Unlock()
+	return registered.Tracked, nil
+}
+
+// unregisterCurrencies removes the registration of the
+// currencies first seen in an orphaned block so that they
+// are registered again if they appear in another block.
+func (h *SyncHandler) unregisterCurrencies(
+	ctx context.Context,
+	dbTx storage.DatabaseTransaction,
+	block *rosetta.BlockIdentifier,
+) error {
+	keys, err := h.storage.UnregisterCurrencies(ctx, dbTx, block)
+	if err != nil {
+		return err
+	}
+
+	h.currenciesMutex.Lock()
+	defer h.currenciesMutex.Unlock()
+	for _, key := range keys {
+		log.Printf("Unregistered currency %s first seen in orphaned block %+v\n", key, block)
+		delete(h.trackedCurrencies, key)
+	}
+
+	return nil
+}
+
+// compactionDue returns true if a completed reorg
+// orphaned enough blocks to trigger garbage collection.
+func (h *SyncHandler) compactionDue(completed *storage.ReorgIntent) bool {
+	return completed != nil &&
+		h.compactionDepth > 0 &&
+		int64(len(completed.Orphaned)) >= h.compactionDepth
+}
+
+// compact schedules garbage collection of BlockStorage by
+// RunCompaction to reclaim the space used by orphaned blocks
+// and reverted balances. It does nothing if garbage
+// collection is already scheduled.
+func (h *SyncHandler) compact(completed *storage.ReorgIntent) {
+	select {
+	case h.compactions <- completed:
+	default:
+	}
+}
+
+// RunCompaction garbage collects BlockStorage after each
+// reorg deep enough to trigger it (see
+// SetReorgCompactionDepth) until ctx is canceled. BlockStorage
+// must not be closed until it returns.
+func (h *SyncHandler) RunCompaction(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case completed := <-h.compactions:
+			log.Printf(
+				"Garbage collecting storage after reorg of %d blocks from %+v\n",
+				len(completed.Orphaned),
+				completed.Head,
+			)
+
+			err := h.storage.GarbageCollect(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Unable to garbage collect storage %v\n", err)
+			}
+		}
+	}
+}
+
+// queueAccounts queues modified accounts for reconciliation
+// unless blockIndex is at or below the trusted checkpoint.
+func (h *SyncHandler) queueAccounts(
+	ctx context.Context,
+	blockIndex int64,
+	accounts []*reconciler.AccountAndCurrency,
+) {
+	if h.trusted != nil && blockIndex <= h.trusted.Index {
+		return
+	}
+
+	h.reconciler.QueueAccounts(ctx, blockIndex, accounts)
+}
+
+// balanceDelta is the net change to the balance of an
+// account in a currency from all operations in a block.
+type balanceDelta struct {
+	account    *rosetta.AccountIdentifier
+	currency   *rosetta.Currency
+	difference *big.Int
+}
+
+// blockBalanceDeltas sums the amounts of all successful
+// operations in a block (and any BalanceAdjustments of the
+// block) by account and currency. The deltas are returned
+// in the order each account and currency first appears in
+// the block.
+func (h *SyncHandler) blockBalanceDeltas(
+	ctx context.Context,
+	block *rosetta.Block,
+) ([]*balanceDelta, error) {
+	deltas := make([]*balanceDelta, 0)
+	deltaIndices := make(map[string]int)
+	addDelta := func(account *rosetta.AccountIdentifier, amount *rosetta.Amount) error {
+		if amount == nil || amount.Currency == nil {
+			return errors.New("invalid amount")
+		}
+
+		value, err := storage.ParseAmountValue(amount.Value)
+		if err != nil {
+			return &storage.AmountError{
+				Account:  account,
+				Currency: amount.Currency,
+				Block:    block.BlockIdentifier,
+				Value:    amount.Value,
+				Err:      err,
+			}
+		}
+
+		key := storage.GetAccountKey(account) + ":" + storage.GetCurrencyKey(amount.Currency)
+		if i, ok := deltaIndices[key]; ok {
+			deltas[i].difference.Add(deltas[i].difference, value)
+			return nil
+		}
+
+		deltaIndices[key] = len(deltas)
+		deltas = append(deltas, &balanceDelta{
+			account:    account,
+			currency:   amount.Currency,
+			difference: value,
+		})
+		return nil
+	}
+
+	for _, tx := range block.Transactions {
+		if tx == nil {
+			continue
+		}
+
+		for i, op := range tx.Operations {
+			// Invalid operations are recorded as Violations
+			// when the block is added.
+			if h.continueOnError && h.asserter.Operation(op, int64(i)) != nil {
+				continue
+			}
+
+			successful, err := h.asserter.OperationSuccessful(op)
+			if err != nil {
+				// Could only occur if responses not validated
+				return nil, err
+			}
+
+			if !successful {
+				continue
+			}
+
+			if op.Account == nil {
+				continue
+			}
+
+			if err := addDelta(op.Account, op.Amount); err != nil {
+				if h.skipBalanceError(block.BlockIdentifier, err) {
+					continue
+				}
+
+				return nil, err
+			}
+		}
+	}
+
+	if h.adjuster == nil {
+		return deltas, nil
+	}
+
+	adjustments, err := h.adjuster.AdjustBalances(ctx, block)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, adjustment := range adjustments {
+		if err := addDelta(adjustment.Account, adjustment.Amount); err != nil {
+			return nil, err
+		}
+	}
+
+	return deltas, nil
+}
+
+// storeBlockBalanceChanges updates the balance of each
+// account modified by a successful operation in a block.
+// Operations affecting the same account and currency are
+// aggregated so that each balance is only updated once.
+// Balances of untracked currencies are not updated.
+// These modified accounts are returned to the reconciler
+// for active reconciliation.
+func (h *SyncHandler) storeBlockBalanceChanges(
+	ctx context.Context,
+	dbTx storage.DatabaseTransaction,
+	block *rosetta.Block,
+	orphan bool,
+) ([]*reconciler.AccountAndCurrency, []*storage.BalanceChange, error) {
+	deltas, err := h.blockBalanceDeltas(ctx, block)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	blockIdentifier := block.BlockIdentifier
+	if orphan {
+		blockIdentifier = block.ParentBlockIdentifier
+	}
+
+	trackedDeltas := make([]*balanceDelta, 0, len(deltas))
+	for _, delta := range deltas {
+		tracked, err := h.currencyTracked(ctx, dbTx, delta.currency, block.BlockIdentifier)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !tracked {
+			continue
+		}
+
+		if orphan {
+			delta.difference.Neg(delta.difference)
+		}
+
+		trackedDeltas = append(trackedDeltas, delta)
+	}
+
+	applied, err := h.applyBalanceDeltas(ctx, dbTx, block, blockIdentifier, trackedDeltas)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if orphan {
+		if err := h.unregisterCurrencies(ctx, dbTx, block.BlockIdentifier); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	modifiedAccounts := make([]*reconciler.AccountAndCurrency, 0, len(applied))
+	balanceChanges := make([]*storage.BalanceChange, 0, len(applied))
+	for _, delta := range applied {
+		modifiedAccounts = append(modifiedAccounts, &reconciler.AccountAndCurrency{
+			Account:  delta.account,
+			Currency: delta.currency,
+		})
+		balanceChanges = append(balanceChanges, &storage.BalanceChange{
+			Account:    delta.account,
+			Currency:   delta.currency,
+			Block:      blockIdentifier,
+			Difference: delta.difference.String(),
+		})
+	}
+
+	if err := h.storage.StoreBalanceChanges(ctx, dbTx, balanceChanges); err != nil {
+		return nil, nil, err
+	}
+
+	return modifiedAccounts, balanceChanges, nil
+}
+
+// appliedBalances are the balance changes applied
+// for a block in storeBlockBalanceChanges.
+type appliedBalances struct {
+	block    *rosetta.BlockIdentifier
+	accounts []*reconciler.AccountAndCurrency
+	changes  []*storage.BalanceChange
+}
+
+// confirmBlocks adds block to the PendingBlocks and applies
+// the balance changes of any pending blocks that are now
+// confirmationDepth blocks deep, in the order they were
+// added. If there are no PendingBlocks and confirmationDepth
+// is 0, the balance changes of block are applied immediately.
+func (h *SyncHandler) confirmBlocks(
+	ctx context.Context,
+	dbTx storage.DatabaseTransaction,
+	block *rosetta.Block,
+) ([]*appliedBalances, error) {
+	pending, err := h.storage.GetPendingBlocks(ctx, dbTx)
+	if err != nil {
+		return nil, err
+	}
+
+	if pending == nil {
+		if h.confirmationDepth == 0 {
+			accounts, changes, err := h.storeBlockBalanceChanges(ctx, dbTx, block, false)
+			if err != nil {
+				return nil, err
+			}
+
+			return []*appliedBalances{{
+				block:    block.BlockIdentifier,
+				accounts: accounts,
+				changes:  changes,
+			}}, nil
+		}
+
+		pending = &storage.PendingBlocks{
+			Confirmed: block.ParentBlockIdentifier,
+		}
+	}
+
+	pending.Blocks = append(pending.Blocks, block.BlockIdentifier)
+	applied := []*appliedBalances{}
+	for len(pending.Blocks) > h.confirmationDepth {
+		confirmed := block
+		if pending.Blocks[0] != block.BlockIdentifier {
+			confirmed, err = h.storage.GetUnprunedBlock(ctx, dbTx, pending.Blocks[0])
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		accounts, changes, err := h.storeBlockBalanceChanges(ctx, dbTx, confirmed, false)
+		if err != nil {
+			return nil, err
+		}
+
+		applied = append(applied, &appliedBalances{
+			block:    confirmed.BlockIdentifier,
+			accounts: accounts,
+			changes:  changes,
+		})
+		pending.Confirmed = pending.Blocks[0]
+		pending.Blocks = pending.Blocks[1:]
+	}
+
+	return applied, h.storage.StorePendingBlocks(ctx, dbTx, pending)
+}
+
+// removePendingBlock removes an orphaned block from the
+// PendingBlocks. It returns false if there are no
+// PendingBlocks, in which case the balance changes of the
+// orphaned block were applied and must be reverted.
+func (h *SyncHandler) removePendingBlock(
+	ctx context.Context,
+	dbTx storage.DatabaseTransaction,
+	blockIdentifier *rosetta.BlockIdentifier,
+) (bool, error) {
+	pending, err := h.storage.GetPendingBlocks(ctx, dbTx)
+	if err != nil || pending == nil {
+		return false, err
+	}
+
+	// Only the head block can be orphaned, which is
+	// always the most recently added pending block.
+	last := pending.Blocks[len(pending.Blocks)-1]
+	if last.Hash != blockIdentifier.Hash {
+		return false, fmt.Errorf(
+			"orphaned block %+v is not the last pending block %+v",
+			blockIdentifier,
+			last,
+		)
+	}
+
+	pending.Blocks = pending.Blocks[:len(pending.Blocks)-1]
+	return true, h.storage.StorePendingBlocks(ctx, dbTx, pending)
+}
+
+// storeAmountFinding records a Finding if err was caused
+// by an amount that could not be applied to a balance.
+func (h *SyncHandler) storeAmountFinding(ctx context.Context, err error) {
+	var amountErr *storage.AmountError
+	if !errors.As(err, &amountErr) {
+		return
+	}
+
+	if err := h.storage.StoreFinding(ctx, amountErr.Finding()); err != nil {
+		log.Printf("Unable to store finding %v\n", err)
+	}
+}
+
+// BlockAdded stores a block, updates the head block
+// identifier, dequeues the block, stores the balance changes of any newly
+// confirmed blocks, and completes any reorg in progress.
+func (h *SyncHandler) BlockAdded(
+	ctx context.Context,
+	block *rosetta.Block,
+) error {
+	log.Printf("Adding block %+v\n", block.BlockIdentifier)
+	if len(h.timestampUnit) > 0 {
+		if err := h.timestampUnit.Validate(block.Timestamp); err != nil {
+			return fmt.Errorf("block %+v: %w", block.BlockIdentifier, err)
+		}
+	}
+
+	for _, hook := range h.hooks {
+		if err := hook.ValidateBlock(ctx, block); err != nil {
+			return &BlockHookError{Block: block.BlockIdentifier, Err: err}
+		}
+	}
+
+	// The Violations of the block are only recorded
+	// if the block is committed.
+	var violations []*storage.Violation
+	if h.continueOnError {
+		violations = h.blockViolations(ctx, block)
+		block = storableBlock(block)
+	}
+
+	var applied []*appliedBalances
+	var completed *storage.ReorgIntent
+	err := h.storage.Update(ctx, func(tx storage.DatabaseTransaction) error {
+		err := h.storage.StoreBlock(ctx, tx, block)
+		if err != nil {
+			return err
+		}
+
+		err = h.storage.StoreViolations(ctx, tx, violations)
+		if err != nil {
+			return err
+		}
+
+		err = h.storage.StoreHeadBlockIdentifier(ctx, tx, block.BlockIdentifier)
+		if err != nil {
+			return err
+		}
+
+		// The block is dequeued (see BlockQueue) in the same
+		// transaction so that it is never processed twice.
+		err = h.storage.DequeueBlock(ctx, tx, block.BlockIdentifier.Index)
+		if err != nil {
+			return err
+		}
+
+		// Adding a block completes any reorg in progress.
+		completed, err = h.storage.ClearReorgIntent(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		err = h.storage.AddLifetimeStats(ctx, tx, &storage.LifetimeStats{BlocksSynced: 1})
+		if err != nil {
+			return err
+		}
+
+		if h.dataOnly {
+			return nil
+		}
+
+		applied, err = h.confirmBlocks(ctx, tx, block)
+		return err
+	})
+	if err != nil {
+		h.storeAmountFinding(ctx, err)
+		return err
+	}
+
+	logViolations(violations)
+	for _, balances := range applied {
+		err = h.logger.BalanceStream(ctx, balances.changes)
+		if err != nil {
+			log.Printf("Unable to log balance changes %v\n", err)
+		}
+	}
+
+	err = h.logger.BlockStream(ctx, block, false)
+	if err != nil {
+		log.Printf("Unable to log block %v\n", err)
+	}
+
+	err = h.logger.TransactionStream(ctx, block)
+	if err != nil {
+		log.Printf("Unable to log transactions %v\n", err)
+	}
+
+	if h.compactionDue(completed) {
+		h.compact(completed)
+	}
+
+	for _, balances := range applied {
+		h.queueAccounts(ctx, balances.block.Index, balances.accounts)
+	}
+
+	return nil
+}
+
+// BlockRemoved removes a block from the database, reverts
+// all its balance changes (unless it was still pending
+// confirmation), records it in the ReorgIntent of the
+// reorg in progress, and retains it in the orphan archive.
+func (h *SyncHandler) BlockRemoved(
+	ctx context.Context,
+	blockIdentifier *rosetta.BlockIdentifier,
+) error {
+	log.Printf("Orphaning block %+v\n", blockIdentifier)
+	var block *rosetta.Block
+	var modifiedAccounts []*reconciler.AccountAndCurrency
+	var balanceChanges []*storage.BalanceChange
+	err := h.storage.Update(ctx, func(tx storage.DatabaseTransaction) error {
+		var err error
+		// The balance changes of a pruned block
+		// can't be reverted.
+		block, err = h.storage.GetUnprunedBlock(ctx, tx, blockIdentifier)
+		if err != nil {
+			return err
+		}
+
+		err = h.storage.StoreHeadBlockIdentifier(ctx, tx, block.ParentBlockIdentifier)
+		if err != nil {
+			return err
+		}
+
+		// The queued child that caused the reorg (if any)
+		// is stale once its parent is orphaned.
+		err = h.storage.DequeueBlock(ctx, tx, blockIdentifier.Index+1)
+		if err != nil {
+			return err
+		}
+
+		wasPending := h.dataOnly
+		if !wasPending {
+			wasPending, err = h.removePendingBlock(ctx, tx, blockIdentifier)
+			if err != nil {
+				return err
+			}
+		}
+
+		if !wasPending {
+			modifiedAccounts, balanceChanges, err = h.storeBlockBalanceChanges(ctx, tx, block, true)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = h.storage.RecordOrphanedBlock(ctx, tx, blockIdentifier)
+		if err != nil {
+			return err
+		}
+
+		err = h.storage.ArchiveOrphanedBlock(ctx, tx, block)
+		if err != nil {
+			return err
+		}
+
+		err = h.storage.AddLifetimeStats(ctx, tx, &storage.LifetimeStats{BlocksOrphaned: 1})
+		if err != nil {
+			return err
+		}
+
+		// The Violations of an orphaned block (including
+		// those of its reverted balance changes) no longer
+		// concern the chain.
+		err = h.storage.RemoveViolations(ctx, tx, blockIdentifier)
+		if err != nil {
+			return err
+		}
+
+		return h.storage.RemoveBlock(ctx, tx, blockIdentifier)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = h.logger.BalanceStream(ctx, balanceChanges)
+	if err != nil {
+		log.Printf("Unable to log balance changes %v\n", err)
+	}
+
+	err = h.logger.BlockStream(ctx, block, true)
+	if err != nil {
+		log.Printf("Unable to log block %v\n", err)
+	}
+
+	// No balances are reverted when orphaning
+	// a block pending confirmation.
+	if modifiedAccounts != nil {
+		h.queueAccounts(ctx, block.ParentBlockIdentifier.Index, modifiedAccounts)
+	}
+
+	return nil
+}
@@ -0,0 +1,1199 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/coinbase/rosetta-validator/internal/logger"
+	"github.com/coinbase/rosetta-validator/internal/reconciler"
+	"github.com/coinbase/rosetta-validator/internal/storage"
+	"github.com/coinbase/rosetta-validator/internal/utils"
+	mockReconciler "github.com/coinbase/rosetta-validator/mocks/reconciler"
+
+	"github.com/coinbase/rosetta-sdk-go/asserter"
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var (
+	currency = &rosetta.Currency{
+		Symbol:   "Blah",
+		Decimals: 2,
+	}
+
+	recipient = &rosetta.AccountIdentifier{
+		Address: "acct1",
+	}
+
+	recipientAmount = &rosetta.Amount{
+		Value:    "100",
+		Currency: currency,
+	}
+
+	recipientOperation = &rosetta.Operation{
+		OperationIdentifier: &rosetta.OperationIdentifier{
+			Index: 0,
+		},
+		Type:    "Transfer",
+		Status:  "Success",
+		Account: recipient,
+		Amount:  recipientAmount,
+	}
+
+	recipientFailureOperation = &rosetta.Operation{
+		OperationIdentifier: &rosetta.OperationIdentifier{
+			Index: 1,
+		},
+		Type:    "Transfer",
+		Status:  "Failure",
+		Account: recipient,
+		Amount:  recipientAmount,
+	}
+
+	recipientTransaction = &rosetta.Transaction{
+		TransactionIdentifier: &rosetta.TransactionIdentifier{
+			Hash: "tx1",
+		},
+		Operations: []*rosetta.Operation{
+			recipientOperation,
+			recipientFailureOperation,
+		},
+	}
+
+	sender = &rosetta.AccountIdentifier{
+		Address: "acct2",
+	}
+
+	senderAmount = &rosetta.Amount{
+		Value:    "-100",
+		Currency: currency,
+	}
+
+	senderOperation = &rosetta.Operation{
+		OperationIdentifier: &rosetta.OperationIdentifier{
+			Index: 0,
+		},
+		Type:    "Transfer",
+		Status:  "Success",
+		Account: sender,
+		Amount:  senderAmount,
+	}
+
+	senderTransaction = &rosetta.Transaction{
+		TransactionIdentifier: &rosetta.TransactionIdentifier{
+			Hash: "tx2",
+		},
+		Operations: []*rosetta.Operation{
+			senderOperation,
+		},
+	}
+
+	blockSequence = []*rosetta.Block{
+		&rosetta.Block{ // genesis
+			BlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "0",
+				Index: 0,
+			},
+			ParentBlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "0",
+				Index: 0,
+			},
+		},
+		&rosetta.Block{
+			BlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "1",
+				Index: 1,
+			},
+			ParentBlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "0",
+				Index: 0,
+			},
+			Transactions: []*rosetta.Transaction{
+				recipientTransaction,
+			},
+		},
+		&rosetta.Block{
+			BlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "2",
+				Index: 2,
+			},
+			ParentBlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "1",
+				Index: 1,
+			},
+			Transactions: []*rosetta.Transaction{
+				senderTransaction,
+			},
+		},
+	}
+
+	operationStatuses = []*rosetta.OperationStatus{
+		&rosetta.OperationStatus{
+			Status:     "Success",
+			Successful: true,
+		},
+		&rosetta.OperationStatus{
+			Status:     "Failure",
+			Successful: false,
+		},
+	}
+
+	networkStatusResponse = &rosetta.NetworkStatusResponse{
+		NetworkStatus: &rosetta.NetworkStatus{
+			NetworkInformation: &rosetta.NetworkInformation{
+				GenesisBlockIdentifier: &rosetta.BlockIdentifier{
+					Index: 0,
+				},
+			},
+		},
+		Options: &rosetta.Options{
+			OperationStatuses: operationStatuses,
+		},
+	}
+)
+
+func TestSyncHandler(t *testing.T) {
+	ctx := context.Background()
+
+	newDir, err := storage.CreateTempDir()
+	assert.NoError(t, err)
+	defer storage.RemoveTempDir(*newDir)
+
+	database, err := storage.NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	blockStorage := storage.NewBlockStorage(ctx, database, &storage.GobCodec{}, &storage.SHA256KeyHasher{})
+	logger := logger.NewLogger(*newDir, false, false, false, false)
+	asserter := asserter.New(ctx, networkStatusResponse)
+	rec := &mockReconciler.Reconciler{}
+	handler := NewSyncHandler(ctx, blockStorage, asserter, logger, rec, nil)
+
+	recipientModified := []*reconciler.AccountAndCurrency{
+		&reconciler.AccountAndCurrency{
+			Account:  recipient,
+			Currency: currency,
+		},
+	}
+
+	t.Run("Add block without transactions", func(t *testing.T) {
+		rec.On(
+			"QueueAccounts",
+			mock.Anything,
+			int64(0),
+			[]*reconciler.AccountAndCurrency{},
+		).Once()
+		assert.NoError(t, handler.BlockAdded(ctx, blockSequence[0]))
+
+		tx := blockStorage.NewDatabaseTransaction(ctx, false)
+		head, err := blockStorage.GetHeadBlockIdentifier(ctx, tx)
+		tx.Discard(ctx)
+		assert.Equal(t, blockSequence[0].BlockIdentifier, head)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Add block with transaction", func(t *testing.T) {
+		rec.On(
+			"QueueAccounts",
+			mock.Anything,
+			int64(1),
+			recipientModified,
+		).Once()
+		assert.NoError(t, handler.BlockAdded(ctx, blockSequence[1]))
+
+		tx := blockStorage.NewDatabaseTransaction(ctx, false)
+		head, err := blockStorage.GetHeadBlockIdentifier(ctx, tx)
+		assert.Equal(t, blockSequence[1].BlockIdentifier, head)
+		assert.NoError(t, err)
+
+		amounts, block, err := blockStorage.GetBalance(ctx, tx, recipient)
+		tx.Discard(ctx)
+
+		// Ensure amount only increases by successful operation
+		assert.Equal(t, map[string]*rosetta.Amount{
+			storage.GetCurrencyKey(currency): recipientAmount,
+		}, amounts)
+		assert.Equal(t, blockSequence[1].BlockIdentifier, block)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Add block with invalid transaction", func(t *testing.T) {
+		err := handler.BlockAdded(ctx, blockSequence[2])
+		assert.True(t, errors.Is(err, storage.ErrNegativeBalance))
+
+		tx := blockStorage.NewDatabaseTransaction(ctx, false)
+		head, err := blockStorage.GetHeadBlockIdentifier(ctx, tx)
+		assert.Equal(t, blockSequence[1].BlockIdentifier, head)
+		assert.NoError(t, err)
+
+		amounts, block, err := blockStorage.GetBalance(ctx, tx, sender)
+		tx.Discard(ctx)
+		assert.Nil(t, amounts)
+		assert.Nil(t, block)
+		assert.EqualError(t, err, fmt.Errorf(
+			"%w %+v",
+			storage.ErrAccountNotFound,
+			sender,
+		).Error())
+	})
+
+	t.Run("Remove block", func(t *testing.T) {
+		rec.On(
+			"QueueAccounts",
+			mock.Anything,
+			int64(0),
+			recipientModified,
+		).Once()
+		assert.NoError(t, handler.BlockRemoved(ctx, blockSequence[1].BlockIdentifier))
+
+		// Assert head is back to genesis
+		tx := blockStorage.NewDatabaseTransaction(ctx, false)
+		defer tx.Discard(ctx)
+		head, err := blockStorage.GetHeadBlockIdentifier(ctx, tx)
+		assert.Equal(t, blockSequence[0].BlockIdentifier, head)
+		assert.NoError(t, err)
+
+		// Assert that balance change was reverted
+		// only by the successful operation
+		amounts, block, err := blockStorage.GetBalance(ctx, tx, recipient)
+		assert.Equal(t, map[string]*rosetta.Amount{
+			storage.GetCurrencyKey(currency): &rosetta.Amount{
+				Value:    "0",
+				Currency: currency,
+			},
+		}, amounts)
+		assert.Equal(t, blockSequence[0].BlockIdentifier, block)
+		assert.NoError(t, err)
+
+		// Assert block is gone
+		orphanBlock, err := blockStorage.GetBlock(ctx, tx, blockSequence[1].BlockIdentifier)
+		assert.Nil(t, orphanBlock)
+		assert.True(t, errors.Is(err, storage.ErrBlockNotFound))
+
+		// Assert block is archived
+		orphans, _, err := blockStorage.OrphanedBlocks(ctx, 0, 10)
+		assert.NoError(t, err)
+		assert.Len(t, orphans, 1)
+		assert.Equal(t, blockSequence[1].BlockIdentifier, orphans[0].Block.BlockIdentifier)
+		assert.Equal(t, int64(1), orphans[0].ReorgDepth)
+
+		stats, err := blockStorage.GetLifetimeStats(ctx, tx)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), stats.BlocksOrphaned)
+	})
+
+	t.Run("Reorg intent", func(t *testing.T) {
+		tx := blockStorage.NewDatabaseTransaction(ctx, false)
+		intent, err := blockStorage.GetReorgIntent(ctx, tx)
+		tx.Discard(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, &storage.ReorgIntent{
+			Head:     blockSequence[1].BlockIdentifier,
+			Orphaned: []*rosetta.BlockIdentifier{blockSequence[1].BlockIdentifier},
+		}, intent)
+
+		rec.On(
+			"QueueAccounts",
+			mock.Anything,
+			int64(1),
+			recipientModified,
+		).Once()
+		assert.NoError(t, handler.BlockAdded(ctx, blockSequence[1]))
+
+		tx = blockStorage.NewDatabaseTransaction(ctx, false)
+		intent, err = blockStorage.GetReorgIntent(ctx, tx)
+		tx.Discard(ctx)
+		assert.NoError(t, err)
+		assert.Nil(t, intent)
+	})
+
+	rec.AssertExpectations(t)
+}
+
+func TestSyncHandlerTrusted(t *testing.T) {
+	ctx := context.Background()
+
+	newDir, err := storage.CreateTempDir()
+	assert.NoError(t, err)
+	defer storage.RemoveTempDir(*newDir)
+
+	database, err := storage.NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	blockStorage := storage.NewBlockStorage(ctx, database, &storage.GobCodec{}, &storage.SHA256KeyHasher{})
+	logger := logger.NewLogger(*newDir, false, false, false, false)
+	asserter := asserter.New(ctx, networkStatusResponse)
+
+	// Accounts modified in trusted blocks are not queued
+	// for reconciliation (the mock panics if they are).
+	rec := &mockReconciler.Reconciler{}
+	handler := NewSyncHandler(ctx, blockStorage, asserter, logger, rec, blockSequence[1].BlockIdentifier)
+
+	assert.NoError(t, handler.BlockAdded(ctx, blockSequence[0]))
+	assert.NoError(t, handler.BlockAdded(ctx, blockSequence[1]))
+
+	tx := blockStorage.NewDatabaseTransaction(ctx, false)
+	defer tx.Discard(ctx)
+	amounts, _, err := blockStorage.GetBalance(ctx, tx, recipient)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]*rosetta.Amount{
+		storage.GetCurrencyKey(currency): recipientAmount,
+	}, amounts)
+
+	rec.AssertExpectations(t)
+}
+
+func TestSyncHandlerConfirmationDepth(t *testing.T) {
+	ctx := context.Background()
+
+	newDir, err := storage.CreateTempDir()
+	assert.NoError(t, err)
+	defer storage.RemoveTempDir(*newDir)
+
+	database, err := storage.NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	blockStorage := storage.NewBlockStorage(ctx, database, &storage.GobCodec{}, &storage.SHA256KeyHasher{})
+	logger := logger.NewLogger(*newDir, false, false, false, false)
+	asserter := asserter.New(ctx, networkStatusResponse)
+	rec := &mockReconciler.Reconciler{}
+	handler := NewSyncHandler(ctx, blockStorage, asserter, logger, rec, nil)
+	handler.SetConfirmationDepth(1)
+
+	confirmed := func() *rosetta.BlockIdentifier {
+		tx := blockStorage.NewDatabaseTransaction(ctx, false)
+		defer tx.Discard(ctx)
+		block, err := blockStorage.GetConfirmedBlockIdentifier(ctx, tx)
+		assert.NoError(t, err)
+		return block
+	}
+
+	recipientBalance := func() (map[string]*rosetta.Amount, error) {
+		tx := blockStorage.NewDatabaseTransaction(ctx, false)
+		defer tx.Discard(ctx)
+		amounts, _, err := blockStorage.GetBalance(ctx, tx, recipient)
+		return amounts, err
+	}
+
+	t.Run("Pending block", func(t *testing.T) {
+		assert.NoError(t, handler.BlockAdded(ctx, blockSequence[0]))
+
+		rec.On(
+			"QueueAccounts",
+			mock.Anything,
+			int64(0),
+			[]*reconciler.AccountAndCurrency{},
+		).Once()
+		assert.NoError(t, handler.BlockAdded(ctx, blockSequence[1]))
+		assert.Equal(t, blockSequence[0].BlockIdentifier, confirmed())
+
+		_, err := recipientBalance()
+		assert.True(t, errors.Is(err, storage.ErrAccountNotFound))
+	})
+
+	t.Run("Remove pending block", func(t *testing.T) {
+		// No balances are reverted (the mock
+		// panics if accounts are queued).
+		assert.NoError(t, handler.BlockRemoved(ctx, blockSequence[1].BlockIdentifier))
+		assert.Equal(t, blockSequence[0].BlockIdentifier, confirmed())
+
+		tx := blockStorage.NewDatabaseTransaction(ctx, false)
+		pending, err := blockStorage.GetPendingBlocks(ctx, tx)
+		tx.Discard(ctx)
+		assert.NoError(t, err)
+		assert.Nil(t, pending)
+	})
+
+	t.Run("Confirm pending blocks", func(t *testing.T) {
+		assert.NoError(t, handler.BlockAdded(ctx, blockSequence[1]))
+
+		// Reducing the confirmation depth confirms
+		// every pending block in order.
+		handler.SetConfirmationDepth(0)
+		block := &rosetta.Block{
+			BlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "2",
+				Index: 2,
+			},
+			ParentBlockIdentifier: blockSequence[1].BlockIdentifier,
+		}
+		rec.On(
+			"QueueAccounts",
+			mock.Anything,
+			int64(1),
+			[]*reconciler.AccountAndCurrency{
+				{
+					Account:  recipient,
+					Currency: currency,
+				},
+			},
+		).Once()
+		rec.On(
+			"QueueAccounts",
+			mock.Anything,
+			int64(2),
+			[]*reconciler.AccountAndCurrency{},
+		).Once()
+		assert.NoError(t, handler.BlockAdded(ctx, block))
+		assert.Equal(t, block.BlockIdentifier, confirmed())
+
+		amounts, err := recipientBalance()
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]*rosetta.Amount{
+			storage.GetCurrencyKey(currency): recipientAmount,
+		}, amounts)
+	})
+
+	rec.AssertExpectations(t)
+}
+
+func TestSyncHandlerTimestampUnit(t *testing.T) {
+	ctx := context.Background()
+
+	newDir, err := storage.CreateTempDir()
+	assert.NoError(t, err)
+	defer storage.RemoveTempDir(*newDir)
+
+	database, err := storage.NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	blockStorage := storage.NewBlockStorage(ctx, database, &storage.GobCodec{}, &storage.SHA256KeyHasher{})
+	logger := logger.NewLogger(*newDir, false, false, false, false)
+	asserter := asserter.New(ctx, networkStatusResponse)
+	rec := &mockReconciler.Reconciler{}
+	handler := NewSyncHandler(ctx, blockStorage, asserter, logger, rec, nil)
+	handler.SetTimestampUnit(utils.Milliseconds)
+
+	// A timestamp in seconds is rejected
+	block := &rosetta.Block{
+		BlockIdentifier:       blockSequence[0].BlockIdentifier,
+		ParentBlockIdentifier: blockSequence[0].ParentBlockIdentifier,
+		Timestamp:             1585742400,
+	}
+	err = handler.BlockAdded(ctx, block)
+	assert.True(t, errors.Is(err, utils.ErrTimestampUnitMismatch))
+
+	rec.On(
+		"QueueAccounts",
+		mock.Anything,
+		int64(0),
+		[]*reconciler.AccountAndCurrency{},
+	).Once()
+	block.Timestamp *= 1000
+	assert.NoError(t, handler.BlockAdded(ctx, block))
+
+	rec.AssertExpectations(t)
+}
+
+func TestSyncHandlerSkipList(t *testing.T) {
+	ctx := context.Background()
+	blockStorage := storage.NewBlockStorage(
+		ctx,
+		storage.NewMemoryStorage(),
+		&storage.GobCodec{},
+		&storage.SHA256KeyHasher{},
+	)
+	asserter := asserter.New(ctx, networkStatusResponse)
+	handler := NewSyncHandler(ctx, blockStorage, asserter, &discardLogger{}, &reconciler.NoOpReconciler{}, nil)
+	assert.NoError(t, handler.BlockAdded(ctx, blockSequence[0]))
+
+	// Block 2 makes the balance of the sender negative.
+	err := handler.BlockAdded(ctx, blockSequence[2])
+	assert.True(t, errors.Is(err, storage.ErrNegativeBalance))
+
+	skipList, err := utils.ParseSkipList([]string{"2:2"})
+	assert.NoError(t, err)
+	handler.SetSkipList(skipList)
+	assert.NoError(t, handler.BlockAdded(ctx, blockSequence[2]))
+
+	txn := blockStorage.NewDatabaseTransaction(ctx, false)
+	defer txn.Discard(ctx)
+	head, err := blockStorage.GetHeadBlockIdentifier(ctx, txn)
+	assert.NoError(t, err)
+	assert.Equal(t, blockSequence[2].BlockIdentifier, head)
+
+	_, _, err = blockStorage.GetBalance(ctx, txn, sender)
+	assert.True(t, errors.Is(err, storage.ErrAccountNotFound))
+}
+
+// newContinueOnErrorHandler returns a SyncHandler with
+// continueOnError set that has added the genesis block.
+func newContinueOnErrorHandler(
+	t *testing.T,
+	ctx context.Context,
+) (*SyncHandler, *storage.BlockStorage) {
+	blockStorage := storage.NewBlockStorage(
+		ctx,
+		storage.NewMemoryStorage(),
+		&storage.GobCodec{},
+		&storage.SHA256KeyHasher{},
+	)
+	asserter := asserter.New(ctx, &rosetta.NetworkStatusResponse{
+		NetworkStatus: networkStatusResponse.NetworkStatus,
+		Options: &rosetta.Options{
+			OperationStatuses: operationStatuses,
+			OperationTypes:    []string{"Transfer"},
+		},
+	})
+	handler := NewSyncHandler(ctx, blockStorage, asserter, &discardLogger{}, &reconciler.NoOpReconciler{}, nil)
+	handler.SetContinueOnError(true)
+	assert.NoError(t, handler.BlockAdded(ctx, timestamped(blockSequence[0])))
+
+	return handler, blockStorage
+}
+
+// timestamped returns a copy of block with a timestamp
+// (the asserter rejects blocks without one).
+func timestamped(block *rosetta.Block) *rosetta.Block {
+	copied := *block
+	copied.Timestamp = 1585742400000
+	return &copied
+}
+
+func TestSyncHandlerContinueOnError(t *testing.T) {
+	ctx := context.Background()
+	handler, blockStorage := newContinueOnErrorHandler(t, ctx)
+
+	// Block 1 has an operation with an unknown status and
+	// an operation with a duplicate index.
+	unknownStatus := *recipientOperation
+	unknownStatus.Status = "Unknown"
+	duplicateIndex := *recipientOperation
+	invalidBlock := &rosetta.Block{
+		BlockIdentifier:       blockSequence[1].BlockIdentifier,
+		ParentBlockIdentifier: blockSequence[1].ParentBlockIdentifier,
+		Timestamp:             1585742400000,
+		Transactions: []*rosetta.Transaction{
+			&rosetta.Transaction{
+				TransactionIdentifier: recipientTransaction.TransactionIdentifier,
+				Operations: []*rosetta.Operation{
+					&unknownStatus,
+					&duplicateIndex,
+				},
+			},
+		},
+	}
+	assert.NoError(t, handler.BlockAdded(ctx, invalidBlock))
+
+	// Block 2 makes the balance of the sender negative.
+	assert.NoError(t, handler.BlockAdded(ctx, timestamped(blockSequence[2])))
+
+	violations, _, err := blockStorage.Violations(ctx, 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, violations, 3)
+
+	assert.Equal(t, storage.OperationViolation, violations[0].Type)
+	assert.Equal(t, invalidBlock.BlockIdentifier, violations[0].Block)
+	assert.Equal(t, recipientTransaction.TransactionIdentifier, violations[0].Transaction)
+	assert.Equal(t, unknownStatus.OperationIdentifier, violations[0].Operation)
+	assert.Equal(t, recipient, violations[0].Account)
+
+	assert.Equal(t, storage.OperationViolation, violations[1].Type)
+	assert.Equal(t, int64(0), violations[1].Operation.Index)
+
+	assert.Equal(t, storage.NegativeBalanceViolation, violations[2].Type)
+	assert.Equal(t, blockSequence[2].BlockIdentifier, violations[2].Block)
+	assert.Equal(t, sender, violations[2].Account)
+	assert.Equal(t, currency, violations[2].Currency)
+
+	txn := blockStorage.NewDatabaseTransaction(ctx, false)
+	defer txn.Discard(ctx)
+	head, err := blockStorage.GetHeadBlockIdentifier(ctx, txn)
+	assert.NoError(t, err)
+	assert.Equal(t, blockSequence[2].BlockIdentifier, head)
+
+	// The invalid operations were not applied.
+	_, _, err = blockStorage.GetBalance(ctx, txn, recipient)
+	assert.True(t, errors.Is(err, storage.ErrAccountNotFound))
+
+	// The violations of an orphaned block are removed.
+	assert.NoError(t, handler.BlockRemoved(ctx, blockSequence[2].BlockIdentifier))
+	violations, _, err = blockStorage.Violations(ctx, 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, violations, 2)
+	for _, violation := range violations {
+		assert.Equal(t, invalidBlock.BlockIdentifier, violation.Block)
+	}
+
+	count, err := blockStorage.ViolationCount(ctx, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+}
+
+func TestSyncHandlerContinueOnErrorMalformedTransactions(t *testing.T) {
+	ctx := context.Background()
+	handler, blockStorage := newContinueOnErrorHandler(t, ctx)
+
+	// Transactions that are nil or have no identifier
+	// are recorded and not stored.
+	block := timestamped(blockSequence[1])
+	block.Transactions = []*rosetta.Transaction{
+		nil,
+		&rosetta.Transaction{
+			Operations: []*rosetta.Operation{recipientOperation},
+		},
+		&rosetta.Transaction{
+			TransactionIdentifier: recipientTransaction.TransactionIdentifier,
+			Operations:            []*rosetta.Operation{recipientOperation},
+		},
+	}
+	assert.NoError(t, handler.BlockAdded(ctx, block))
+
+	violations, _, err := blockStorage.Violations(ctx, 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, violations, 2)
+	for _, violation := range violations {
+		assert.Equal(t, storage.TransactionViolation, violation.Type)
+		assert.Equal(t, block.BlockIdentifier, violation.Block)
+	}
+
+	txn := blockStorage.NewDatabaseTransaction(ctx, false)
+	defer txn.Discard(ctx)
+	stored, err := blockStorage.GetBlock(ctx, txn, block.BlockIdentifier)
+	assert.NoError(t, err)
+	assert.Equal(t, []*rosetta.Transaction{block.Transactions[2]}, stored.Transactions)
+
+	amounts, _, err := blockStorage.GetBalance(ctx, txn, recipient)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]*rosetta.Amount{
+		storage.GetCurrencyKey(currency): recipientAmount,
+	}, amounts)
+}
+
+func TestSyncHandlerDataOnly(t *testing.T) {
+	ctx := context.Background()
+	blockStorage := storage.NewBlockStorage(
+		ctx,
+		storage.NewMemoryStorage(),
+		&storage.GobCodec{},
+		&storage.SHA256KeyHasher{},
+	)
+	asserter := asserter.New(ctx, networkStatusResponse)
+	handler := NewSyncHandler(ctx, blockStorage, asserter, &discardLogger{}, &reconciler.NoOpReconciler{}, nil)
+	handler.SetDataOnly(true)
+
+	// Block 2 would make the balance of the sender
+	// negative if balances were computed.
+	assert.NoError(t, handler.BlockAdded(ctx, blockSequence[0]))
+	assert.NoError(t, handler.BlockAdded(ctx, blockSequence[2]))
+
+	txn := blockStorage.NewDatabaseTransaction(ctx, false)
+	_, _, err := blockStorage.GetBalance(ctx, txn, sender)
+	txn.Discard(ctx)
+	assert.True(t, errors.Is(err, storage.ErrAccountNotFound))
+
+	assert.NoError(t, handler.BlockRemoved(ctx, blockSequence[2].BlockIdentifier))
+
+	txn = blockStorage.NewDatabaseTransaction(ctx, false)
+	defer txn.Discard(ctx)
+	head, err := blockStorage.GetHeadBlockIdentifier(ctx, txn)
+	assert.NoError(t, err)
+	assert.Equal(t, blockSequence[2].ParentBlockIdentifier, head)
+
+	currencies, err := blockStorage.Currencies(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, currencies)
+}
+
+func TestSyncHandlerDequeuesBlocks(t *testing.T) {
+	ctx := context.Background()
+	blockStorage := storage.NewBlockStorage(
+		ctx,
+		storage.NewMemoryStorage(),
+		&storage.GobCodec{},
+		&storage.SHA256KeyHasher{},
+	)
+	asserter := asserter.New(ctx, networkStatusResponse)
+	handler := NewSyncHandler(ctx, blockStorage, asserter, &discardLogger{}, &reconciler.NoOpReconciler{}, nil)
+	handler.SetDataOnly(true)
+
+	queue := NewBlockQueue(blockStorage)
+	assert.NoError(t, queue.QueueBlocks(ctx, blockSequence))
+
+	t.Run("Added block is dequeued", func(t *testing.T) {
+		assert.NoError(t, handler.BlockAdded(ctx, blockSequence[0]))
+		assert.NoError(t, handler.BlockAdded(ctx, blockSequence[1]))
+
+		block, err := queue.QueuedBlock(ctx, 1)
+		assert.NoError(t, err)
+		assert.Nil(t, block)
+
+		block, err = queue.QueuedBlock(ctx, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, blockSequence[2], block)
+	})
+
+	t.Run("Child of orphaned block is dequeued", func(t *testing.T) {
+		assert.NoError(t, handler.BlockRemoved(ctx, blockSequence[1].BlockIdentifier))
+
+		block, err := queue.QueuedBlock(ctx, 2)
+		assert.NoError(t, err)
+		assert.Nil(t, block)
+	})
+}
+
+// senderHook rejects blocks containing
+// operations of the sender.
+type senderHook struct {
+	blocks int
+}
+
+var errSenderOperation = errors.New("sender operation")
+
+func (h *senderHook) ValidateBlock(ctx context.Context, block *rosetta.Block) error {
+	h.blocks++
+	for _, tx := range block.Transactions {
+		for _, op := range tx.Operations {
+			if op.Account.Address == sender.Address {
+				return errSenderOperation
+			}
+		}
+	}
+
+	return nil
+}
+
+func TestSyncHandlerBlockHook(t *testing.T) {
+	ctx := context.Background()
+	blockStorage := storage.NewBlockStorage(
+		ctx,
+		storage.NewMemoryStorage(),
+		&storage.GobCodec{},
+		&storage.SHA256KeyHasher{},
+	)
+	asserter := asserter.New(ctx, networkStatusResponse)
+	handler := NewSyncHandler(ctx, blockStorage, asserter, &discardLogger{}, &reconciler.NoOpReconciler{}, nil)
+	hook := &senderHook{}
+	handler.AddBlockHook(hook)
+
+	assert.NoError(t, handler.BlockAdded(ctx, blockSequence[0]))
+	assert.NoError(t, handler.BlockAdded(ctx, blockSequence[1]))
+
+	err := handler.BlockAdded(ctx, blockSequence[2])
+	var hookErr *BlockHookError
+	assert.True(t, errors.As(err, &hookErr))
+	assert.Equal(t, blockSequence[2].BlockIdentifier, hookErr.Block)
+	assert.True(t, errors.Is(err, errSenderOperation))
+	assert.Equal(t, 3, hook.blocks)
+
+	// The rejected block is not stored.
+	txn := blockStorage.NewDatabaseTransaction(ctx, false)
+	defer txn.Discard(ctx)
+	head, err := blockStorage.GetHeadBlockIdentifier(ctx, txn)
+	assert.NoError(t, err)
+	assert.Equal(t, blockSequence[1].BlockIdentifier, head)
+}
+
+// manyAccountsBlock returns a block in which each of
+// count accounts receives value in two currencies.
+func manyAccountsBlock(index int64, count int, value string) *rosetta.Block {
+	otherCurrency := &rosetta.Currency{Symbol: "Other", Decimals: 2}
+	transactions := []*rosetta.Transaction{}
+	for i := 0; i < count; i++ {
+		account := &rosetta.AccountIdentifier{Address: fmt.Sprintf("account %d", i)}
+		operations := []*rosetta.Operation{}
+		for j, c := range []*rosetta.Currency{currency, otherCurrency} {
+			operations = append(operations, &rosetta.Operation{
+				OperationIdentifier: &rosetta.OperationIdentifier{Index: int64(j)},
+				Type:                "Transfer",
+				Status:              "Success",
+				Account:             account,
+				Amount:              &rosetta.Amount{Value: value, Currency: c},
+			})
+		}
+
+		transactions = append(transactions, &rosetta.Transaction{
+			TransactionIdentifier: &rosetta.TransactionIdentifier{
+				Hash: fmt.Sprintf("tx %d %d", index, i),
+			},
+			Operations: operations,
+		})
+	}
+
+	return &rosetta.Block{
+		BlockIdentifier: &rosetta.BlockIdentifier{
+			Hash:  fmt.Sprintf("%d", index),
+			Index: index,
+		},
+		ParentBlockIdentifier: &rosetta.BlockIdentifier{
+			Hash:  fmt.Sprintf("%d", index-1),
+			Index: index - 1,
+		},
+		Transactions: transactions,
+	}
+}
+
+func TestSyncHandlerBalanceWorkers(t *testing.T) {
+	ctx := context.Background()
+	count := minParallelAccounts * 2
+	newHandler := func(workers int) (*SyncHandler, *storage.BlockStorage) {
+		blockStorage := storage.NewBlockStorage(
+			ctx,
+			storage.NewMemoryStorage(),
+			&storage.GobCodec{},
+			&storage.SHA256KeyHasher{},
+		)
+		asserter := asserter.New(ctx, networkStatusResponse)
+		handler := NewSyncHandler(ctx, blockStorage, asserter, &discardLogger{}, &reconciler.NoOpReconciler{}, nil)
+		handler.SetBalanceWorkers(workers)
+		return handler, blockStorage
+	}
+
+	serial, serialStorage := newHandler(1)
+	parallel, parallelStorage := newHandler(4)
+	for _, handler := range []*SyncHandler{serial, parallel} {
+		assert.NoError(t, handler.BlockAdded(ctx, blockSequence[0]))
+		assert.NoError(t, handler.BlockAdded(ctx, manyAccountsBlock(1, count, "10")))
+		assert.NoError(t, handler.BlockAdded(ctx, manyAccountsBlock(2, count, "5")))
+	}
+
+	t.Run("Balances match serial application", func(t *testing.T) {
+		serialTxn := serialStorage.NewDatabaseTransaction(ctx, false)
+		defer serialTxn.Discard(ctx)
+		parallelTxn := parallelStorage.NewDatabaseTransaction(ctx, false)
+		defer parallelTxn.Discard(ctx)
+
+		for i := 0; i < count; i++ {
+			account := &rosetta.AccountIdentifier{Address: fmt.Sprintf("account %d", i)}
+			expected, _, err := serialStorage.GetBalance(ctx, serialTxn, account)
+			assert.NoError(t, err)
+			assert.Equal(t, "15", expected[storage.GetCurrencyKey(currency)].Value)
+
+			balances, block, err := parallelStorage.GetBalance(ctx, parallelTxn, account)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, balances)
+			assert.Equal(t, int64(2), block.Index)
+		}
+	})
+
+	t.Run("Errors match serial application", func(t *testing.T) {
+		block := manyAccountsBlock(3, count, "-20")
+		serialErr := serial.BlockAdded(ctx, block)
+		assert.True(t, errors.Is(serialErr, storage.ErrNegativeBalance))
+		parallelErr := parallel.BlockAdded(ctx, block)
+		assert.True(t, errors.Is(parallelErr, storage.ErrNegativeBalance))
+		assert.Contains(t, parallelErr.Error(), "account 0")
+	})
+}
+
+func TestSyncHandlerUntrackedCurrencies(t *testing.T) {
+	ctx := context.Background()
+
+	newDir, err := storage.CreateTempDir()
+	assert.NoError(t, err)
+	defer storage.RemoveTempDir(*newDir)
+
+	database, err := storage.NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	blockStorage := storage.NewBlockStorage(ctx, database, &storage.GobCodec{}, &storage.SHA256KeyHasher{})
+	logger := logger.NewLogger(*newDir, false, false, false, false)
+	asserter := asserter.New(ctx, networkStatusResponse)
+	rec := &mockReconciler.Reconciler{}
+	handler := NewSyncHandler(ctx, blockStorage, asserter, logger, rec, nil)
+	handler.SetTrackNewCurrencies(false)
+
+	// Balances of an untracked currency are not
+	// updated or queued for reconciliation
+	rec.On(
+		"QueueAccounts",
+		mock.Anything,
+		mock.Anything,
+		[]*reconciler.AccountAndCurrency{},
+	).Twice()
+	assert.NoError(t, handler.BlockAdded(ctx, blockSequence[0]))
+	assert.NoError(t, handler.BlockAdded(ctx, blockSequence[1]))
+
+	tx := blockStorage.NewDatabaseTransaction(ctx, false)
+	_, _, err = blockStorage.GetBalance(ctx, tx, recipient)
+	tx.Discard(ctx)
+	assert.True(t, errors.Is(err, storage.ErrAccountNotFound))
+
+	currencies, err := blockStorage.Currencies(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []*storage.RegisteredCurrency{
+		{
+			Key:       storage.GetCurrencyKey(currency),
+			Currency:  currency,
+			FirstSeen: blockSequence[1].BlockIdentifier,
+			Tracked:   false,
+		},
+	}, currencies)
+
+	// Opting in tracks balance changes in later blocks
+	assert.NoError(t, blockStorage.SetCurrencyTracked(ctx, storage.GetCurrencyKey(currency), true))
+	rec.On(
+		"QueueAccounts",
+		mock.Anything,
+		int64(2),
+		[]*reconciler.AccountAndCurrency{
+			{
+				Account:  recipient,
+				Currency: currency,
+			},
+		},
+	).Once()
+	assert.NoError(t, handler.BlockAdded(ctx, &rosetta.Block{
+		BlockIdentifier: &rosetta.BlockIdentifier{
+			Hash:  "2",
+			Index: 2,
+		},
+		ParentBlockIdentifier: blockSequence[1].BlockIdentifier,
+		Transactions: []*rosetta.Transaction{
+			{
+				TransactionIdentifier: &rosetta.TransactionIdentifier{
+					Hash: "tx3",
+				},
+				Operations: []*rosetta.Operation{
+					recipientOperation,
+				},
+			},
+		},
+	}))
+
+	tx = blockStorage.NewDatabaseTransaction(ctx, false)
+	amounts, _, err := blockStorage.GetBalance(ctx, tx, recipient)
+	tx.Discard(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]*rosetta.Amount{
+		storage.GetCurrencyKey(currency): recipientAmount,
+	}, amounts)
+
+	rec.AssertExpectations(t)
+}
+
+func TestSyncHandlerOrphanedCurrencies(t *testing.T) {
+	ctx := context.Background()
+
+	newDir, err := storage.CreateTempDir()
+	assert.NoError(t, err)
+	defer storage.RemoveTempDir(*newDir)
+
+	database, err := storage.NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	blockStorage := storage.NewBlockStorage(ctx, database, &storage.GobCodec{}, &storage.SHA256KeyHasher{})
+	logger := logger.NewLogger(*newDir, false, false, false, false)
+	asserter := asserter.New(ctx, networkStatusResponse)
+	rec := &mockReconciler.Reconciler{}
+	rec.On("QueueAccounts", mock.Anything, mock.Anything, mock.Anything)
+	handler := NewSyncHandler(ctx, blockStorage, asserter, logger, rec, nil)
+
+	assert.NoError(t, handler.BlockAdded(ctx, blockSequence[0]))
+	assert.NoError(t, handler.BlockAdded(ctx, blockSequence[1]))
+
+	currencies, err := blockStorage.Currencies(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, currencies, 1)
+
+	// A currency first seen in an orphaned
+	// block is no longer registered.
+	assert.NoError(t, handler.BlockRemoved(ctx, blockSequence[1].BlockIdentifier))
+
+	currencies, err = blockStorage.Currencies(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, currencies, 0)
+
+	// It is registered again with the block
+	// it is seen in next.
+	handler.SetTrackNewCurrencies(false)
+	assert.NoError(t, handler.BlockAdded(ctx, blockSequence[1]))
+
+	currencies, err = blockStorage.Currencies(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []*storage.RegisteredCurrency{
+		{
+			Key:       storage.GetCurrencyKey(currency),
+			Currency:  currency,
+			FirstSeen: blockSequence[1].BlockIdentifier,
+			Tracked:   false,
+		},
+	}, currencies)
+}
+
+func TestBlockBalanceDeltas(t *testing.T) {
+	ctx := context.Background()
+	handler := NewSyncHandler(ctx, nil, asserter.New(ctx, networkStatusResponse), nil, nil, nil)
+
+	operation := func(account *rosetta.AccountIdentifier, value string, status string) *rosetta.Operation {
+		return &rosetta.Operation{
+			Type:    "Transfer",
+			Status:  status,
+			Account: account,
+			Amount: &rosetta.Amount{
+				Value:    value,
+				Currency: currency,
+			},
+		}
+	}
+
+	block := &rosetta.Block{
+		BlockIdentifier: &rosetta.BlockIdentifier{
+			Hash:  "1",
+			Index: 1,
+		},
+		Transactions: []*rosetta.Transaction{
+			{
+				Operations: []*rosetta.Operation{
+					operation(sender, "-40", "Success"),
+					operation(recipient, "40", "Success"),
+					operation(recipient, "1000", "Failure"),
+				},
+			},
+			{
+				Operations: []*rosetta.Operation{
+					operation(recipient, "-10", "Success"),
+					operation(sender, "100", "Success"),
+				},
+			},
+		},
+	}
+
+	deltas, err := handler.blockBalanceDeltas(ctx, block)
+	assert.NoError(t, err)
+	assert.Len(t, deltas, 2)
+	assert.Equal(t, sender, deltas[0].account)
+	assert.Equal(t, "60", deltas[0].difference.String())
+	assert.Equal(t, recipient, deltas[1].account)
+	assert.Equal(t, "30", deltas[1].difference.String())
+
+	// Operations are not modified.
+	assert.Equal(t, "-40", block.Transactions[0].Operations[0].Amount.Value)
+
+	block.Transactions[0].Operations[0].Amount.Value = "1.5"
+	deltas, err = handler.blockBalanceDeltas(ctx, block)
+	assert.True(t, errors.Is(err, storage.ErrInvalidAmountValue))
+	assert.Nil(t, deltas)
+
+	var amountErr *storage.AmountError
+	assert.True(t, errors.As(err, &amountErr))
+	assert.Equal(t, &storage.Finding{
+		Type:       storage.InvalidAmountFinding,
+		Account:    sender,
+		Currency:   currency,
+		Block:      block.BlockIdentifier,
+		Difference: "1.5",
+	}, amountErr.Finding())
+}
+
+// rentAdjuster deducts rent from an account in every block.
+type rentAdjuster struct {
+	account *rosetta.AccountIdentifier
+	rent    string
+}
+
+func (a *rentAdjuster) AdjustBalances(
+	ctx context.Context,
+	block *rosetta.Block,
+) ([]*BalanceAdjustment, error) {
+	return []*BalanceAdjustment{
+		{
+			Account: a.account,
+			Amount: &rosetta.Amount{
+				Value:    a.rent,
+				Currency: currency,
+			},
+		},
+	}, nil
+}
+
+func TestBlockBalanceDeltasAdjusted(t *testing.T) {
+	ctx := context.Background()
+	handler := NewSyncHandler(ctx, nil, asserter.New(ctx, networkStatusResponse), nil, nil, nil)
+	handler.SetBalanceAdjuster(&rentAdjuster{account: recipient, rent: "-5"})
+
+	// Adjustments are aggregated with operations
+	deltas, err := handler.blockBalanceDeltas(ctx, blockSequence[1])
+	assert.NoError(t, err)
+	assert.Len(t, deltas, 1)
+	assert.Equal(t, recipient, deltas[0].account)
+	assert.Equal(t, "95", deltas[0].difference.String())
+
+	deltas, err = handler.blockBalanceDeltas(ctx, blockSequence[0])
+	assert.NoError(t, err)
+	assert.Len(t, deltas, 1)
+	assert.Equal(t, "-5", deltas[0].difference.String())
+
+	handler.SetBalanceAdjuster(&rentAdjuster{account: recipient, rent: "five"})
+	_, err = handler.blockBalanceDeltas(ctx, blockSequence[0])
+	assert.True(t, errors.Is(err, storage.ErrInvalidAmountValue))
+}
+
+func TestCompactionDue(t *testing.T) {
+	handler := &SyncHandler{}
+	intent := &storage.ReorgIntent{
+		Head: &rosetta.BlockIdentifier{
+			Hash:  "head",
+			Index: 10,
+		},
+		Orphaned: make([]*rosetta.BlockIdentifier, 3),
+	}
+
+	assert.False(t, handler.compactionDue(intent))
+
+	handler.SetReorgCompactionDepth(3)
+	assert.False(t, handler.compactionDue(nil))
+	assert.True(t, handler.compactionDue(intent))
+
+	handler.SetReorgCompactionDepth(4)
+	assert.False(t, handler.compactionDue(intent))
+}
+
+func TestRunCompaction(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	blockStorage := storage.NewBlockStorage(
+		ctx,
+		storage.NewMemoryStorage(),
+		&storage.GobCodec{},
+		&storage.SHA256KeyHasher{},
+	)
+	handler := NewSyncHandler(ctx, blockStorage, nil, &discardLogger{}, &reconciler.NoOpReconciler{}, nil)
+	intent := &storage.ReorgIntent{
+		Head: &rosetta.BlockIdentifier{
+			Hash:  "head",
+			Index: 10,
+		},
+	}
+
+	// A compaction scheduled while another is
+	// pending is dropped without blocking.
+	handler.compact(intent)
+	handler.compact(intent)
+	assert.Len(t, handler.compactions, 1)
+
+	done := make(chan error)
+	go func() {
+		done <- handler.RunCompaction(ctx)
+	}()
+
+	for len(handler.compactions) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	assert.NoError(t, <-done)
+}
@@ -0,0 +1,187 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/coinbase/rosetta-validator/internal/storage"
+
+	"github.com/coinbase/rosetta-sdk-go/asserter"
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+// SetContinueOnError records a storage.Violation for each
+// block, transaction, or operation that fails assertion and
+// for each balance change that can't be applied (ex: because
+// it would make a balance negative) instead of returning an
+// error, so that a single run finds every violation. The
+// balance changes of invalid operations are skipped. Blocks
+// that fail assertion must still be returned by the Fetcher
+// (see syncer.ViolationFetcher). It must be called before any
+// blocks are processed.
+func (h *SyncHandler) SetContinueOnError(continueOnError bool) {
+	h.continueOnError = continueOnError
+}
+
+// logViolations logs Violations that were recorded.
+func logViolations(violations []*storage.Violation) {
+	for _, violation := range violations {
+		log.Printf("Violation %s in block %+v: %s\n", violation.Type, violation.Block, violation.Message)
+	}
+}
+
+// storeBalanceViolation stores a Violation in dbTx for a
+// balance change of block that was skipped because of err,
+// unless the block is in the skip list. The skipped error
+// is logged by skipBalanceError.
+func (h *SyncHandler) storeBalanceViolation(
+	ctx context.Context,
+	dbTx storage.DatabaseTransaction,
+	block *rosetta.BlockIdentifier,
+	delta *balanceDelta,
+	err error,
+) error {
+	if !h.continueOnError || h.skipList.Contains(block) {
+		return nil
+	}
+
+	violationType := storage.NegativeBalanceViolation
+	var amountErr *storage.AmountError
+	if errors.As(err, &amountErr) {
+		violationType = amountErr.Finding().Type
+	}
+
+	return h.storage.StoreViolations(ctx, dbTx, []*storage.Violation{{
+		Type:     violationType,
+		Block:    block,
+		Account:  delta.account,
+		Currency: delta.currency,
+		Message:  err.Error(),
+	}})
+}
+
+// storableBlock returns block without its transactions that
+// have an invalid TransactionIdentifier (which can't be
+// stored), or block itself if it has none. The Violations
+// of these transactions are returned by blockViolations.
+func storableBlock(block *rosetta.Block) *rosetta.Block {
+	transactions := []*rosetta.Transaction{}
+	for _, tx := range block.Transactions {
+		if tx != nil && asserter.TransactionIdentifier(tx.TransactionIdentifier) == nil {
+			transactions = append(transactions, tx)
+		}
+	}
+
+	if len(transactions) == len(block.Transactions) {
+		return block
+	}
+
+	stored := *block
+	stored.Transactions = transactions
+	return &stored
+}
+
+// blockViolations returns a Violation for each part of block
+// (its header, transactions, and operations) that fails
+// assertion or has an amount that can't be applied.
+func (h *SyncHandler) blockViolations(ctx context.Context, block *rosetta.Block) []*storage.Violation {
+	violations := []*storage.Violation{}
+	header := *block
+	header.Transactions = nil
+	if err := h.asserter.Block(ctx, &header); err != nil {
+		violations = append(violations, &storage.Violation{
+			Type:    storage.BlockViolation,
+			Block:   block.BlockIdentifier,
+			Message: err.Error(),
+		})
+	}
+
+	for _, tx := range block.Transactions {
+		if tx == nil {
+			violations = append(violations, &storage.Violation{
+				Type:    storage.TransactionViolation,
+				Block:   block.BlockIdentifier,
+				Message: "Transaction is nil (not stored)",
+			})
+			continue
+		}
+
+		if err := asserter.TransactionIdentifier(tx.TransactionIdentifier); err != nil {
+			violations = append(violations, &storage.Violation{
+				Type:        storage.TransactionViolation,
+				Block:       block.BlockIdentifier,
+				Transaction: tx.TransactionIdentifier,
+				Message:     fmt.Sprintf("%v (not stored)", err),
+			})
+			continue
+		}
+
+		for i, op := range tx.Operations {
+			violation := h.operationViolation(op, int64(i))
+			if violation == nil {
+				continue
+			}
+
+			violation.Block = block.BlockIdentifier
+			violation.Transaction = tx.TransactionIdentifier
+			violations = append(violations, violation)
+		}
+	}
+
+	return violations
+}
+
+// operationViolation returns a Violation (without its block
+// and transaction) if the operation at index fails assertion
+// or its amount can't be applied, or nil otherwise.
+func (h *SyncHandler) operationViolation(op *rosetta.Operation, index int64) *storage.Violation {
+	if op == nil {
+		return &storage.Violation{
+			Type:    storage.OperationViolation,
+			Message: "Operation is nil",
+		}
+	}
+
+	violation := &storage.Violation{
+		Type:      storage.OperationViolation,
+		Operation: op.OperationIdentifier,
+		Account:   op.Account,
+	}
+	if op.Amount != nil {
+		violation.Currency = op.Amount.Currency
+	}
+
+	if err := h.asserter.Operation(op, index); err != nil {
+		violation.Message = err.Error()
+		return violation
+	}
+
+	if op.Amount == nil {
+		return nil
+	}
+
+	if _, err := storage.ParseAmountValue(op.Amount.Value); err != nil {
+		amountErr := &storage.AmountError{Value: op.Amount.Value, Err: err}
+		violation.Type = amountErr.Finding().Type
+		violation.Message = err.Error()
+		return violation
+	}
+
+	return nil
+}
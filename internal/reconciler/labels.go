@@ -0,0 +1,83 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/coinbase/rosetta-validator/internal/storage"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+// AccountLabel is an entry in an account labels file. It
+// attaches labels (ex: "hot-wallet" or "treasury") to an
+// account so that findings identify the business wallet
+// that diverged.
+type AccountLabel struct {
+	Account *rosetta.AccountIdentifier `json:"account"`
+	Labels  []string                   `json:"labels"`
+}
+
+// AccountLabels are the labels of each labeled account.
+type AccountLabels struct {
+	labels map[string][]string
+}
+
+// NewAccountLabels returns AccountLabels containing
+// the provided entries.
+func NewAccountLabels(entries []*AccountLabel) *AccountLabels {
+	labels := map[string][]string{}
+	for _, entry := range entries {
+		key := storage.GetAccountKey(entry.Account)
+		labels[key] = append(labels[key], entry.Labels...)
+	}
+
+	return &AccountLabels{labels: labels}
+}
+
+// LoadAccountLabels reads a JSON array of AccountLabel
+// entries from path.
+func LoadAccountLabels(path string) (*AccountLabels, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*AccountLabel
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("unable to parse account labels file %s: %w", path, err)
+	}
+
+	for i, entry := range entries {
+		if entry.Account == nil {
+			return nil, fmt.Errorf("account labels entry %d has no account", i)
+		}
+	}
+
+	return NewAccountLabels(entries), nil
+}
+
+// Labels returns the labels of account or nil
+// if it has none. l may be nil.
+func (l *AccountLabels) Labels(account *rosetta.AccountIdentifier) []string {
+	if l == nil {
+		return nil
+	}
+
+	return l.labels[storage.GetAccountKey(account)]
+}
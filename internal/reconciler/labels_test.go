@@ -0,0 +1,57 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadAccountLabels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "labels")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "labels.json")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`[
+		{"account": {"address": "hot"}, "labels": ["hot-wallet"]},
+		{"account": {"address": "hot", "sub_account": {"sub_account": "staking"}}, "labels": ["staking"]},
+		{"account": {"address": "hot"}, "labels": ["exchange"]}
+	]`), 0600))
+
+	labels, err := LoadAccountLabels(file)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hot-wallet", "exchange"}, labels.Labels(&rosetta.AccountIdentifier{
+		Address: "hot",
+	}))
+	assert.Equal(t, []string{"staking"}, labels.Labels(&rosetta.AccountIdentifier{
+		Address:    "hot",
+		SubAccount: &rosetta.SubAccountIdentifier{SubAccount: "staking"},
+	}))
+	assert.Nil(t, labels.Labels(&rosetta.AccountIdentifier{Address: "cold"}))
+
+	var noLabels *AccountLabels
+	assert.Nil(t, noLabels.Labels(&rosetta.AccountIdentifier{Address: "hot"}))
+
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`[{"labels": ["orphan"]}]`), 0600))
+	_, err = LoadAccountLabels(file)
+	assert.Error(t, err)
+}
@@ -16,16 +16,20 @@ package reconciler
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"math/big"
 	"math/rand"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/coinbase/rosetta-validator/internal/logger"
+	"github.com/coinbase/rosetta-validator/internal/metrics"
 	"github.com/coinbase/rosetta-validator/internal/storage"
+	"github.com/coinbase/rosetta-validator/internal/utils"
 
 	"github.com/coinbase/rosetta-sdk-go/fetcher"
 
@@ -89,16 +93,97 @@ var (
 	// does not exist in the store. This likely means
 	// that the block was orphaned.
 	ErrBlockGone = errors.New("block gone")
+
+	// ErrBalanceMismatch is returned when the balance
+	// of an account computed from its operations differs
+	// from its live balance.
+	ErrBalanceMismatch = errors.New("balance mismatch")
 )
 
-// Reconciler contains all logic to reconcile balances of
+// waitToCheckInterval is the time waited for the syncer
+// to reach the live block before checking again.
+var waitToCheckInterval = 5 * time.Second
+
+// Fetcher is the subset of *fetcher.Fetcher methods
+// used by the Reconciler to retrieve live balances.
+type Fetcher interface {
+	AccountBalanceRetry(
+		ctx context.Context,
+		network *rosetta.NetworkIdentifier,
+		account *rosetta.AccountIdentifier,
+		maxElapsedTime time.Duration,
+		maxRetries uint64,
+	) (*rosetta.BlockIdentifier, []*rosetta.Balance, error)
+}
+
+// Logger is used by the Reconciler to record
+// balance lookups and reconciliation results.
+type Logger interface {
+	AccountLatency(
+		ctx context.Context,
+		account *rosetta.AccountIdentifier,
+		latency float64,
+		balances int,
+	) error
+
+	ReconcileStream(
+		ctx context.Context,
+		reconciliationType string,
+		account *rosetta.AccountIdentifier,
+		currency *rosetta.Currency,
+		difference string,
+		block *rosetta.BlockIdentifier,
+	) error
+}
+
+// CorruptionRecoverer restores a stored balance or block
+// that cannot be decoded.
+type CorruptionRecoverer interface {
+	RecoverCorruption(ctx context.Context, err *storage.CorruptionError) error
+}
+
+// Reconciler is the interface implemented by every
+// reconciliation strategy. The syncer queues modified
+// accounts after each processed block and the strategy
+// decides how (and if) those accounts are checked.
+type Reconciler interface {
+	// QueueAccounts is called by the syncer with the accounts
+	// modified in the block at blockIndex. It must not block.
+	QueueAccounts(
+		ctx context.Context,
+		blockIndex int64,
+		accounts []*AccountAndCurrency,
+	)
+
+	// Reconcile runs the reconciliation strategy until
+	// an error occurs or the context is canceled.
+	Reconcile(ctx context.Context) error
+}
+
+// Timeouts bound the time spent in each stage of
+// reconciliation. A timeout of 0 disables the bound
+// for that stage.
+type Timeouts struct {
+	// Fetch bounds each request for a live balance.
+	Fetch time.Duration
+
+	// Reconcile bounds the reconciliation of a single
+	// account, excluding the time spent waiting for the
+	// syncer to reach the block of its live balance.
+	Reconcile time.Duration
+}
+
+// StatefulReconciler contains all logic to reconcile balances of
 // rosetta.AccountIdentifiers returned in rosetta.Operations
-// by a Rosetta Server.
-type Reconciler struct {
+// by a Rosetta Server. Balances computed by the syncer and
+// stored in BlockStorage are compared to live balances.
+type StatefulReconciler struct {
 	network            *rosetta.NetworkIdentifier
 	storage            *storage.BlockStorage
-	fetcher            *fetcher.Fetcher
-	logger             *logger.Logger
+	fetcher            Fetcher
+	logger             Logger
+	metrics            metrics.Sink
+	timeouts           Timeouts
 	accountConcurrency int
 	acctQueue          chan *IndexAndAccount
 
@@ -107,31 +192,115 @@ type Reconciler struct {
 	highWaterMark int64
 
 	// seenAccts are stored for inactive account
-	// reconciliation.
-	seenAccts []*AccountAndCurrency
+	// reconciliation. queuedAccts are the keys of every
+	// account queued for reconciliation (ex: to compute
+	// the reconciliation coverage). Both are guarded by
+	// acctsMutex.
+	acctsMutex  sync.Mutex
+	seenAccts   []*AccountAndCurrency
+	queuedAccts map[string]struct{}
+
+	// reconciliations is the number of successful
+	// reconciliations (active and inactive). It is
+	// accessed atomically.
+	reconciliations int64
+
+	// pending is the number of accounts queued or being
+	// reconciled from the queue. It is accessed atomically.
+	pending int64
+
+	// labels are included in the reconciliations
+	// and findings of labeled accounts.
+	labels *AccountLabels
+
+	// maxElapsedTime and maxRetries bound the
+	// retries of each failed balance request.
+	maxElapsedTime time.Duration
+	maxRetries     uint64
+
+	// draining is true once Drain has been called.
+	draining bool
+
+	// skipFetchErrors skips accounts whose live balance
+	// can't be fetched instead of returning an error.
+	skipFetchErrors bool
+
+	// corruption restores corrupted balances (if set).
+	corruption CorruptionRecoverer
 }
 
-// New creates a new Reconciler.
-func New(
+// NewStateful creates a new StatefulReconciler.
+func NewStateful(
 	ctx context.Context,
 	network *rosetta.NetworkIdentifier,
 	storage *storage.BlockStorage,
-	fetcher *fetcher.Fetcher,
-	logger *logger.Logger,
+	f Fetcher,
+	logger Logger,
+	sink metrics.Sink,
+	timeouts Timeouts,
 	accountConcurrency int,
-) *Reconciler {
-	return &Reconciler{
+) *StatefulReconciler {
+	return &StatefulReconciler{
 		network:            network,
 		storage:            storage,
-		fetcher:            fetcher,
+		fetcher:            f,
 		logger:             logger,
+		metrics:            sink,
+		timeouts:           timeouts,
 		accountConcurrency: accountConcurrency,
 		acctQueue:          make(chan *IndexAndAccount, backlogThreshold),
 		highWaterMark:      0,
 		seenAccts:          make([]*AccountAndCurrency, 0),
+		queuedAccts:        map[string]struct{}{},
+		maxElapsedTime:     fetcher.DefaultElapsedTime,
+		maxRetries:         fetcher.DefaultRetries,
 	}
 }
 
+// SetAccountLabels includes the labels of each labeled
+// account in its reconciliations and findings. It must be
+// called before Reconcile.
+func (r *StatefulReconciler) SetAccountLabels(labels *AccountLabels) {
+	r.labels = labels
+}
+
+// SetRetries changes the number of times (and the time
+// spent) retrying a failed request for a live balance with
+// exponential backoff. It must be called before Reconcile.
+func (r *StatefulReconciler) SetRetries(maxElapsedTime time.Duration, maxRetries uint64) {
+	r.maxElapsedTime = maxElapsedTime
+	r.maxRetries = maxRetries
+}
+
+// SetSkipFetchErrors logs and skips accounts whose live
+// balance can't be fetched (after its retries are exhausted)
+// instead of returning an error, so that reconciliation
+// continues while the node is unavailable (ex: while the
+// Syncer pauses syncing). It must be called before Reconcile.
+func (r *StatefulReconciler) SetSkipFetchErrors(skip bool) {
+	r.skipFetchErrors = skip
+}
+
+// SetCorruptionRecoverer restores a computed balance that
+// cannot be decoded with recoverer before comparing it
+// again, instead of returning an error. It must be called
+// before Reconcile.
+func (r *StatefulReconciler) SetCorruptionRecoverer(recoverer CorruptionRecoverer) {
+	r.corruption = recoverer
+}
+
+// Backlog returns the number of queued accounts
+// waiting to be reconciled.
+func (r *StatefulReconciler) Backlog() int {
+	return len(r.acctQueue)
+}
+
+// Idle returns true if no accounts are queued for
+// reconciliation or being reconciled from the queue.
+func (r *StatefulReconciler) Idle() bool {
+	return atomic.LoadInt64(&r.pending) == 0
+}
+
 // IndexAndAccount contains an AccountAndCurrency
 // and at what block index it was modified. This
 // struct is enqueued for later processing by
@@ -165,16 +334,16 @@ func ContainsAccountAndCurrency(
 
 // QueueAccounts adds an IndexAndAccount to the acctQueue
 // for reconciliation.
-func (r *Reconciler) QueueAccounts(
+func (r *StatefulReconciler) QueueAccounts(
 	ctx context.Context,
 	blockIndex int64,
 	accounts []*AccountAndCurrency,
 ) {
-	// If reconciliation is disabled,
-	// we should just return.
-	if r == nil {
-		return
+	r.acctsMutex.Lock()
+	for _, account := range accounts {
+		r.queuedAccts[accountAndCurrencyKey(account)] = struct{}{}
 	}
+	r.acctsMutex.Unlock()
 
 	if blockIndex < r.highWaterMark {
 		return
@@ -183,21 +352,78 @@ func (r *Reconciler) QueueAccounts(
 	// Use a buffered channel so don't need to
 	// spawn a goroutine to add accounts to channel.
 	for _, account := range accounts {
+		atomic.AddInt64(&r.pending, 1)
 		select {
 		case r.acctQueue <- &IndexAndAccount{
 			accountAndCurrency: account,
 			blockIndex:         blockIndex,
 		}:
 		default:
+			atomic.AddInt64(&r.pending, -1)
 			log.Printf("skipping enqueue because backlog\n")
 		}
 	}
+
+	r.metrics.SetGauge(metrics.ReconciliationBacklog, float64(len(r.acctQueue)))
+}
+
+// ReconciledAccounts returns the number of accounts
+// (and currencies) successfully reconciled at least once.
+func (r *StatefulReconciler) ReconciledAccounts() int {
+	r.acctsMutex.Lock()
+	defer r.acctsMutex.Unlock()
+
+	return len(r.seenAccts)
+}
+
+// ModifiedAccounts returns the number of accounts (and
+// currencies) queued for reconciliation because their
+// balance changed in a synced block.
+func (r *StatefulReconciler) ModifiedAccounts() int {
+	r.acctsMutex.Lock()
+	defer r.acctsMutex.Unlock()
+
+	return len(r.queuedAccts)
+}
+
+// Reconciliations returns the number of successful
+// reconciliations (of active and inactive accounts).
+func (r *StatefulReconciler) Reconciliations() int64 {
+	return atomic.LoadInt64(&r.reconciliations)
+}
+
+// ReconciliationCoverage returns the fraction of the
+// accounts (and currencies) queued for reconciliation
+// that have been successfully reconciled at least once
+// (0 if no account has been queued).
+func (r *StatefulReconciler) ReconciliationCoverage() float64 {
+	r.acctsMutex.Lock()
+	defer r.acctsMutex.Unlock()
+
+	if len(r.queuedAccts) == 0 {
+		return 0
+	}
+
+	return float64(len(r.seenAccts)) / float64(len(r.queuedAccts))
+}
+
+// accountAndCurrencyKey returns a key identifying
+// an AccountAndCurrency (including its metadata).
+func accountAndCurrencyKey(acct *AccountAndCurrency) string {
+	key, err := json.Marshal(acct)
+	if err != nil {
+		// Metadata decoded from a response can
+		// always be encoded.
+		return simpleAccountAndCurrency(acct)
+	}
+
+	return string(key)
 }
 
 // CompareBalance checks to see if the computed balance of an account
 // is equal to the live balance of an account. This function ensures
 // balance is checked correctly in the case of orphaned blocks.
-func (r *Reconciler) CompareBalance(
+func (r *StatefulReconciler) CompareBalance(
 	ctx context.Context,
 	accountAndCurrency *AccountAndCurrency,
 	liveAmount *rosetta.Amount,
@@ -206,8 +432,10 @@ func (r *Reconciler) CompareBalance(
 	txn := r.storage.NewDatabaseTransaction(ctx, false)
 	defer txn.Discard(ctx)
 
-	// Head block should be set before we CompareBalance
-	head, err := r.storage.GetHeadBlockIdentifier(ctx, txn)
+	// Head block should be set before we CompareBalance. Computed
+	// balances are only current as of the confirmed block, which
+	// trails the head if balance changes await confirmation.
+	head, err := r.storage.GetConfirmedBlockIdentifier(ctx, txn)
 	if err != nil {
 		return zeroString, 0, err
 	}
@@ -254,13 +482,13 @@ func (r *Reconciler) CompareBalance(
 	}
 
 	if computedAmount.Value != liveAmount.Value {
-		computed, ok := new(big.Int).SetString(computedAmount.Value, 10)
-		if !ok {
-			return zeroString, head.Index, fmt.Errorf("could not extract amount for %s", computedAmount.Value)
+		computed, err := storage.ParseAmountValue(computedAmount.Value)
+		if err != nil {
+			return zeroString, head.Index, fmt.Errorf("could not extract amount for %s: %w", computedAmount.Value, err)
 		}
-		live, ok := new(big.Int).SetString(liveAmount.Value, 10)
-		if !ok {
-			return zeroString, head.Index, fmt.Errorf("could not extract amount for %s", liveAmount.Value)
+		live, err := storage.ParseAmountValue(liveAmount.Value)
+		if err != nil {
+			return zeroString, head.Index, fmt.Errorf("could not extract amount for %s: %w", liveAmount.Value, err)
 		}
 
 		return new(big.Int).Sub(computed, live).String(), head.Index, nil
@@ -307,23 +535,46 @@ func ShouldReconcile(networkStatus *rosetta.NetworkStatusResponse) bool {
 // accountReconciliation returns an error if the provided
 // AccountAndCurrency's live balance cannot be reconciled
 // with the computed balance.
-func (r *Reconciler) accountReconciliation(
+func (r *StatefulReconciler) accountReconciliation(
 	ctx context.Context,
 	acct *AccountAndCurrency,
 	inactive bool,
 ) error {
+	parent := ctx
+	ctx, cancel := utils.ContextWithTimeout(parent, r.timeouts.Reconcile)
+	defer func() { cancel() }()
+
+	// spent is the time counted against the Reconcile
+	// timeout before resumed (waiting for the syncer
+	// is not counted).
+	var spent time.Duration
+	resumed := time.Now()
+
 	start := time.Now()
+	fetchCtx, cancelFetch := utils.ContextWithTimeout(ctx, r.timeouts.Fetch)
 	liveBlock, liveBalances, err := r.fetcher.AccountBalanceRetry(
-		ctx,
+		fetchCtx,
 		r.network,
 		acct.Account,
-		fetcher.DefaultElapsedTime,
-		fetcher.DefaultRetries,
+		r.maxElapsedTime,
+		r.maxRetries,
 	)
+	cancelFetch()
 	if err != nil {
-		return err
+		if !r.skipFetchErrors || ctx.Err() != nil {
+			return err
+		}
+
+		log.Printf(
+			"Skipping reconciliation for %s: %v\n",
+			simpleAccountAndCurrency(acct),
+			err,
+		)
+		r.metrics.IncrCounter(metrics.ReconciliationsSkipped, 1)
+		return nil
 	}
 
+	r.metrics.ObserveHistogram(metrics.AccountFetchSeconds, time.Since(start).Seconds())
 	err = r.logger.AccountLatency(ctx, acct.Account, time.Since(start).Seconds(), len(liveBalances))
 	if err != nil {
 		return err
@@ -334,6 +585,14 @@ func (r *Reconciler) accountReconciliation(
 		return err
 	}
 
+	reconciliationType := activeReconciliation
+	if inactive {
+		reconciliationType = inactiveReconciliation
+	}
+
+	// A corrupted computed balance is only
+	// restored once per reconciliation.
+	recovered := false
 	for ctx.Err() == nil {
 		difference, headIndex, err := r.CompareBalance(
 			ctx,
@@ -341,11 +600,30 @@ func (r *Reconciler) accountReconciliation(
 			liveAmount,
 			liveBlock,
 		)
+		var corruptionErr *storage.CorruptionError
 		if err != nil {
-			if errors.Is(err, ErrHeadBlockBehindLive) {
+			if errors.As(err, &corruptionErr) && r.corruption != nil && !recovered {
+				if err := r.corruption.RecoverCorruption(ctx, corruptionErr); err != nil {
+					return err
+				}
+
+				recovered = true
+				continue
+			} else if errors.Is(err, ErrHeadBlockBehindLive) {
+				// While draining, the syncer has stopped
+				// and will never reach the live block.
 				diff := liveBlock.Index - headIndex
-				if diff < waitToCheckDiff {
-					time.Sleep(5 * time.Second)
+				if diff < waitToCheckDiff && !r.draining {
+					spent += time.Since(resumed)
+					cancel()
+
+					select {
+					case <-parent.Done():
+					case <-time.After(waitToCheckInterval):
+					}
+
+					ctx, cancel = r.reconcileContext(parent, spent)
+					resumed = time.Now()
 					continue
 				}
 
@@ -356,38 +634,74 @@ func (r *Reconciler) accountReconciliation(
 					diff,
 				)
 				r.highWaterMark = liveBlock.Index
+				r.metrics.IncrCounter(metrics.ReconciliationsSkipped, 1)
+				r.storeReconciliation(ctx, reconciliationType, acct, liveAmount, liveBlock, "")
 				break
 			} else if errors.Is(err, ErrBlockGone) {
 				// Either the block has not been processed in a re-org yet
 				// or the block was orphaned
+				r.metrics.IncrCounter(metrics.ReconciliationsSkipped, 1)
+				r.storeReconciliation(ctx, reconciliationType, acct, liveAmount, liveBlock, "")
 				break
 			} else if errors.Is(err, ErrAccountUpdated) {
-				break // account will already be re-checked
+				// account will already be re-checked
+				r.metrics.IncrCounter(metrics.ReconciliationsSkipped, 1)
+				r.storeReconciliation(ctx, reconciliationType, acct, liveAmount, liveBlock, "")
+				break
 			} else {
 				return err
 			}
 		}
 
-		reconciliationType := activeReconciliation
-		if inactive {
-			reconciliationType = inactiveReconciliation
+		err = r.logger.ReconcileStream(
+			ctx,
+			reconciliationType,
+			acct.Account,
+			acct.Currency,
+			difference,
+			liveBlock,
+		)
+		if err != nil {
+			log.Printf("Unable to log reconciliation %v\n", err)
 		}
 
+		r.storeReconciliation(ctx, reconciliationType, acct, liveAmount, liveBlock, difference)
+
 		if difference != zeroString {
+			r.metrics.IncrCounter(metrics.ReconciliationFailures, 1)
+			err = r.storage.StoreFinding(ctx, &storage.Finding{
+				Type:       reconciliationType,
+				Account:    acct.Account,
+				Currency:   acct.Currency,
+				Block:      liveBlock,
+				Difference: difference,
+				Labels:     r.labels.Labels(acct.Account),
+			})
+			if err != nil {
+				log.Printf("Unable to store finding %v\n", err)
+			}
+
 			return fmt.Errorf(
-				"\n%s balance mismatch\naccount: %+v\ncurrency: %+v\nblock: %+v\nbalance difference(computed-live):%s",
+				"\n%s %w\naccount: %+v\nlabels: %v\ncurrency: %+v\nblock: %+v\nbalance difference(computed-live):%s (%s)",
 				reconciliationType,
+				ErrBalanceMismatch,
 				spew.Sdump(acct.Account),
+				r.labels.Labels(acct.Account),
 				spew.Sdump(acct.Currency),
 				spew.Sdump(liveBlock),
+				storage.FormatAmount(difference, acct.Currency),
 				difference,
 			)
 		}
 
+		r.acctsMutex.Lock()
 		if !inactive && !ContainsAccountAndCurrency(r.seenAccts, acct) {
 			r.seenAccts = append(r.seenAccts, acct)
 		}
+		r.acctsMutex.Unlock()
 
+		atomic.AddInt64(&r.reconciliations, 1)
+		r.metrics.IncrCounter(metrics.Reconciliations, 1)
 		log.Printf(
 			"Reconciled %s %s at %d\n",
 			reconciliationType,
@@ -397,9 +711,87 @@ func (r *Reconciler) accountReconciliation(
 		break
 	}
 
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf(
+			"%w: unable to reconcile %s",
+			ctx.Err(),
+			simpleAccountAndCurrency(acct),
+		)
+	}
+
 	return nil
 }
 
+// reconcileContext returns a context that expires once
+// the rest of the Reconcile timeout has elapsed, given
+// that spent of it has already been used.
+func (r *StatefulReconciler) reconcileContext(
+	ctx context.Context,
+	spent time.Duration,
+) (context.Context, context.CancelFunc) {
+	if r.timeouts.Reconcile > 0 && spent >= r.timeouts.Reconcile {
+		return context.WithDeadline(ctx, time.Now())
+	}
+
+	return utils.ContextWithTimeout(ctx, r.timeouts.Reconcile-spent)
+}
+
+// storeReconciliation records a reconciliation in the
+// reconciliation history of its account. If difference is
+// empty, the reconciliation was skipped. Failing to record
+// a reconciliation does not stop reconciliation.
+func (r *StatefulReconciler) storeReconciliation(
+	ctx context.Context,
+	reconciliationType string,
+	acct *AccountAndCurrency,
+	liveAmount *rosetta.Amount,
+	liveBlock *rosetta.BlockIdentifier,
+	difference string,
+) {
+	reconciliation := &storage.Reconciliation{
+		Type:     reconciliationType,
+		Account:  acct.Account,
+		Currency: acct.Currency,
+		Block:    liveBlock,
+		Live:     liveAmount.Value,
+		Outcome:  storage.ReconciliationSkipped,
+		Labels:   r.labels.Labels(acct.Account),
+	}
+
+	if len(difference) > 0 {
+		reconciliation.Outcome = storage.ReconciliationSucceeded
+		if difference != zeroString {
+			reconciliation.Outcome = storage.ReconciliationFailed
+		}
+
+		// difference is computed-live
+		computed, err := addAmountValues(liveAmount.Value, difference)
+		if err != nil {
+			log.Printf("Unable to compute balance of reconciliation %v\n", err)
+		}
+		reconciliation.Computed = computed
+	}
+
+	if err := r.storage.StoreReconciliation(ctx, reconciliation); err != nil {
+		log.Printf("Unable to store reconciliation %v\n", err)
+	}
+}
+
+// addAmountValues returns the sum of two amount values.
+func addAmountValues(a string, b string) (string, error) {
+	aVal, err := storage.ParseAmountValue(a)
+	if err != nil {
+		return "", err
+	}
+
+	bVal, err := storage.ParseAmountValue(b)
+	if err != nil {
+		return "", err
+	}
+
+	return new(big.Int).Add(aVal, bVal).String(), nil
+}
+
 // simpleAccountAndCurrency returns a string that is a simple
 // representation of an AccountAndCurrency struct.
 func simpleAccountAndCurrency(acct *AccountAndCurrency) string {
@@ -413,55 +805,106 @@ func simpleAccountAndCurrency(acct *AccountAndCurrency) string {
 	return acctString
 }
 
+// reconcileQueuedAccount reconciles the balance of an
+// account taken from the reconciler account queue.
+func (r *StatefulReconciler) reconcileQueuedAccount(
+	ctx context.Context,
+	acctIndex *IndexAndAccount,
+) error {
+	defer atomic.AddInt64(&r.pending, -1)
+	if acctIndex.blockIndex < r.highWaterMark {
+		return nil
+	}
+
+	return r.accountReconciliation(
+		ctx,
+		acctIndex.accountAndCurrency,
+		false,
+	)
+}
+
 // reconcileActiveAccounts selects an account
 // from the reconciler account queue and
 // reconciles the balance. This is useful
 // for detecting if balance changes in operations
 // were correct.
-func (r *Reconciler) reconcileActiveAccounts(
+func (r *StatefulReconciler) reconcileActiveAccounts(
 	ctx context.Context,
 ) error {
-	for acctIndex := range r.acctQueue {
-		if ctx.Err() != nil {
+	for {
+		select {
+		case <-ctx.Done():
 			return nil
+		case acctIndex := <-r.acctQueue:
+			if err := r.reconcileQueuedAccount(ctx, acctIndex); err != nil {
+				return err
+			}
 		}
+	}
+}
 
-		if acctIndex.blockIndex < r.highWaterMark {
-			continue
+// drainQueue reconciles accounts from the reconciler
+// account queue until it is empty.
+func (r *StatefulReconciler) drainQueue(ctx context.Context) error {
+	for ctx.Err() == nil {
+		select {
+		case acctIndex := <-r.acctQueue:
+			if err := r.reconcileQueuedAccount(ctx, acctIndex); err != nil {
+				return err
+			}
+		default:
+			return nil
 		}
+	}
 
-		err := r.accountReconciliation(
-			ctx,
-			acctIndex.accountAndCurrency,
-			false,
-		)
-		if err != nil {
-			return err
-		}
+	return ctx.Err()
+}
+
+// Drain reconciles the accounts remaining in the reconciler
+// account queue (ex: when shutting down) until it is empty
+// or ctx is canceled. Accounts with a live balance at a block
+// after the synced head are skipped instead of waiting for
+// the head to reach it. It must only be called after Reconcile
+// has returned and no more blocks are being synced.
+func (r *StatefulReconciler) Drain(ctx context.Context) error {
+	r.draining = true
+	g, ctx := errgroup.WithContext(ctx)
+	for j := 0; j < r.accountConcurrency; j++ {
+		g.Go(func() error {
+			return r.drainQueue(ctx)
+		})
 	}
 
-	return nil
+	return g.Wait()
 }
 
 // reconcileInactiveAccounts selects a random account
 // from all previously seen accounts and reconciles
 // the balance. This is useful for detecting balance
 // changes that were not returned in operations.
-func (r *Reconciler) reconcileInactiveAccounts(
+func (r *StatefulReconciler) reconcileInactiveAccounts(
 	ctx context.Context,
 ) error {
 	randSource := rand.NewSource(time.Now().UnixNano())
 	randGenerator := rand.New(randSource)
 	for ctx.Err() == nil {
+		var randAcct *AccountAndCurrency
+		r.acctsMutex.Lock()
 		if len(r.seenAccts) > 0 {
-			randAcct := r.seenAccts[randGenerator.Intn(len(r.seenAccts))]
+			randAcct = r.seenAccts[randGenerator.Intn(len(r.seenAccts))]
+		}
+		r.acctsMutex.Unlock()
 
+		if randAcct != nil {
 			err := r.accountReconciliation(ctx, randAcct, true)
 			if err != nil {
 				return err
 			}
 		} else {
-			time.Sleep(inactiveReconciliationSleep)
+			select {
+			case <-ctx.Done():
+			case <-time.After(inactiveReconciliationSleep):
+			}
 		}
 	}
 
@@ -470,7 +913,7 @@ func (r *Reconciler) reconcileInactiveAccounts(
 
 // Reconcile starts the active and inactive reconciler goroutines.
 // If either set of goroutines errors, the function will return an error.
-func (r *Reconciler) Reconcile(ctx context.Context) error {
+func (r *StatefulReconciler) Reconcile(ctx context.Context) error {
 	g, ctx := errgroup.WithContext(ctx)
 	for j := 0; j < r.accountConcurrency; j++ {
 		g.Go(func() error {
@@ -488,3 +931,21 @@ func (r *Reconciler) Reconcile(ctx context.Context) error {
 
 	return nil
 }
+
+// NoOpReconciler is a Reconciler that performs
+// no reconciliation. It is used when the Rosetta
+// Server does not support balance lookups.
+type NoOpReconciler struct{}
+
+// QueueAccounts discards the provided accounts.
+func (r *NoOpReconciler) QueueAccounts(
+	ctx context.Context,
+	blockIndex int64,
+	accounts []*AccountAndCurrency,
+) {
+}
+
+// Reconcile returns immediately.
+func (r *NoOpReconciler) Reconcile(ctx context.Context) error {
+	return nil
+}
@@ -16,10 +16,13 @@ package reconciler
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/coinbase/rosetta-validator/internal/logger"
+	"github.com/coinbase/rosetta-validator/internal/metrics"
 	"github.com/coinbase/rosetta-validator/internal/storage"
 
 	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
@@ -136,6 +139,20 @@ func TestAccountAndCurrencyExists(t *testing.T) {
 	})
 }
 
+func TestNoOpReconciler(t *testing.T) {
+	ctx := context.Background()
+	var r Reconciler = &NoOpReconciler{}
+
+	r.QueueAccounts(ctx, 1, []*AccountAndCurrency{
+		&AccountAndCurrency{
+			Account: &rosetta.AccountIdentifier{
+				Address: "test",
+			},
+		},
+	})
+	assert.NoError(t, r.Reconcile(ctx))
+}
+
 func TestShouldReconcile(t *testing.T) {
 	t.Run("should reconcile", func(t *testing.T) {
 		assert.True(t, ShouldReconcile(&rosetta.NetworkStatusResponse{
@@ -313,9 +330,9 @@ func TestCompareBalance(t *testing.T) {
 	assert.NoError(t, err)
 	defer database.Close(ctx)
 
-	blockStorage := storage.NewBlockStorage(ctx, database)
-	logger := logger.NewLogger(*newDir, false, false)
-	reconciler := New(ctx, nil, blockStorage, nil, logger, 1)
+	blockStorage := storage.NewBlockStorage(ctx, database, &storage.GobCodec{}, &storage.SHA256KeyHasher{})
+	logger := logger.NewLogger(*newDir, false, false, false, false)
+	reconciler := NewStateful(ctx, nil, blockStorage, nil, logger, &metrics.NoOpSink{}, Timeouts{}, 1)
 
 	t.Run("No head block yet", func(t *testing.T) {
 		difference, headIndex, err := reconciler.CompareBalance(
@@ -484,3 +501,432 @@ func TestCompareBalance(t *testing.T) {
 		assert.Contains(t, err.Error(), storage.ErrAccountNotFound.Error())
 	})
 }
+
+// blockingFetcher is a Fetcher that never
+// responds before the context is done.
+type blockingFetcher struct{}
+
+func (f *blockingFetcher) AccountBalanceRetry(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	account *rosetta.AccountIdentifier,
+	maxElapsedTime time.Duration,
+	maxRetries uint64,
+) (*rosetta.BlockIdentifier, []*rosetta.Balance, error) {
+	<-ctx.Done()
+	return nil, nil, ctx.Err()
+}
+
+// staticFetcher is a Fetcher that always
+// returns the same balances.
+type staticFetcher struct {
+	block    *rosetta.BlockIdentifier
+	balances []*rosetta.Balance
+}
+
+func (f *staticFetcher) AccountBalanceRetry(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	account *rosetta.AccountIdentifier,
+	maxElapsedTime time.Duration,
+	maxRetries uint64,
+) (*rosetta.BlockIdentifier, []*rosetta.Balance, error) {
+	return f.block, f.balances, nil
+}
+
+func TestReconcileTimeouts(t *testing.T) {
+	ctx := context.Background()
+	acct := &AccountAndCurrency{
+		Account: &rosetta.AccountIdentifier{
+			Address: "blah",
+		},
+		Currency: &rosetta.Currency{
+			Symbol:   "curr1",
+			Decimals: 4,
+		},
+	}
+
+	t.Run("Fetch timeout", func(t *testing.T) {
+		reconciler := NewStateful(
+			ctx,
+			nil,
+			nil,
+			&blockingFetcher{},
+			nil,
+			&metrics.NoOpSink{},
+			Timeouts{Fetch: 10 * time.Millisecond},
+			1,
+		)
+
+		err := reconciler.accountReconciliation(ctx, acct, false)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+		// The account is skipped if fetch errors are skipped.
+		reconciler.SetSkipFetchErrors(true)
+		assert.NoError(t, reconciler.accountReconciliation(ctx, acct, false))
+	})
+
+	t.Run("Reconcile timeout", func(t *testing.T) {
+		reconciler := NewStateful(
+			ctx,
+			nil,
+			nil,
+			&blockingFetcher{},
+			nil,
+			&metrics.NoOpSink{},
+			Timeouts{Fetch: time.Minute, Reconcile: 10 * time.Millisecond},
+			1,
+		)
+		reconciler.SetSkipFetchErrors(true)
+
+		err := reconciler.accountReconciliation(ctx, acct, false)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+
+	t.Run("Waiting for syncer", func(t *testing.T) {
+		defaultInterval := waitToCheckInterval
+		waitToCheckInterval = 10 * time.Millisecond
+		defer func() { waitToCheckInterval = defaultInterval }()
+
+		newDir, err := storage.CreateTempDir()
+		assert.NoError(t, err)
+		defer storage.RemoveTempDir(*newDir)
+
+		database, err := storage.NewBadgerStorage(ctx, *newDir)
+		assert.NoError(t, err)
+		defer database.Close(ctx)
+
+		blockStorage := storage.NewBlockStorage(ctx, database, &storage.GobCodec{}, &storage.SHA256KeyHasher{})
+		txn := blockStorage.NewDatabaseTransaction(ctx, true)
+		assert.NoError(t, blockStorage.StoreHeadBlockIdentifier(ctx, txn, &rosetta.BlockIdentifier{
+			Hash:  "block 1",
+			Index: 1,
+		}))
+		assert.NoError(t, txn.Commit(ctx))
+
+		reconciler := NewStateful(
+			ctx,
+			nil,
+			blockStorage,
+			&staticFetcher{
+				block: &rosetta.BlockIdentifier{
+					Hash:  "block 2",
+					Index: 2,
+				},
+				balances: []*rosetta.Balance{
+					{
+						AccountIdentifier: acct.Account,
+						Amounts: []*rosetta.Amount{
+							{Value: "10", Currency: acct.Currency},
+						},
+					},
+				},
+			},
+			logger.NewLogger(*newDir, false, false, false, false),
+			&metrics.NoOpSink{},
+			Timeouts{Reconcile: 20 * time.Millisecond},
+			1,
+		)
+
+		// The head never reaches the live block but the
+		// time spent waiting for it is not counted against
+		// the Reconcile timeout.
+		waitCtx, cancel := context.WithCancel(ctx)
+		time.AfterFunc(200*time.Millisecond, cancel)
+
+		assert.NoError(t, reconciler.accountReconciliation(waitCtx, acct, false))
+		assert.Error(t, waitCtx.Err())
+	})
+}
+
+func TestStoreReconciliation(t *testing.T) {
+	ctx := context.Background()
+	acct := &AccountAndCurrency{
+		Account: &rosetta.AccountIdentifier{
+			Address: "blah",
+		},
+		Currency: &rosetta.Currency{
+			Symbol:   "curr1",
+			Decimals: 4,
+		},
+	}
+	liveAmount := &rosetta.Amount{
+		Value:    "10",
+		Currency: acct.Currency,
+	}
+	liveBlock := &rosetta.BlockIdentifier{
+		Hash:  "block",
+		Index: 1,
+	}
+
+	newDir, err := storage.CreateTempDir()
+	assert.NoError(t, err)
+	defer storage.RemoveTempDir(*newDir)
+
+	database, err := storage.NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	blockStorage := storage.NewBlockStorage(ctx, database, &storage.GobCodec{}, &storage.SHA256KeyHasher{})
+	reconciler := NewStateful(ctx, nil, blockStorage, nil, nil, &metrics.NoOpSink{}, Timeouts{}, 1)
+
+	reconciler.storeReconciliation(ctx, activeReconciliation, acct, liveAmount, liveBlock, "0")
+	reconciler.storeReconciliation(ctx, activeReconciliation, acct, liveAmount, liveBlock, "")
+
+	// Labels of labeled accounts are recorded
+	reconciler.SetAccountLabels(NewAccountLabels([]*AccountLabel{
+		{Account: acct.Account, Labels: []string{"treasury"}},
+	}))
+	reconciler.storeReconciliation(ctx, inactiveReconciliation, acct, liveAmount, liveBlock, "-5")
+
+	reconciliations, _, err := blockStorage.Reconciliations(ctx, acct.Account, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []*storage.Reconciliation{
+		{
+			Type:     activeReconciliation,
+			Account:  acct.Account,
+			Currency: acct.Currency,
+			Block:    liveBlock,
+			Computed: "10",
+			Live:     "10",
+			Outcome:  storage.ReconciliationSucceeded,
+		},
+		{
+			Type:     activeReconciliation,
+			Account:  acct.Account,
+			Currency: acct.Currency,
+			Block:    liveBlock,
+			Live:     "10",
+			Outcome:  storage.ReconciliationSkipped,
+		},
+		{
+			Type:     inactiveReconciliation,
+			Account:  acct.Account,
+			Currency: acct.Currency,
+			Block:    liveBlock,
+			Computed: "5",
+			Live:     "10",
+			Outcome:  storage.ReconciliationFailed,
+			Labels:   []string{"treasury"},
+		},
+	}, reconciliations)
+}
+
+func TestReconcileCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	reconciler := NewStateful(ctx, nil, nil, nil, nil, &metrics.NoOpSink{}, Timeouts{}, 2)
+
+	done := make(chan error)
+	go func() {
+		done <- reconciler.Reconcile(ctx)
+	}()
+
+	// Reconcile returns once canceled, even
+	// though no account is queued.
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Reconcile did not return")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	ctx := context.Background()
+	acct := &AccountAndCurrency{
+		Account: &rosetta.AccountIdentifier{
+			Address: "blah",
+		},
+		Currency: &rosetta.Currency{
+			Symbol:   "curr1",
+			Decimals: 4,
+		},
+	}
+
+	reconciler := NewStateful(
+		ctx,
+		nil,
+		nil,
+		&blockingFetcher{},
+		nil,
+		&metrics.NoOpSink{},
+		Timeouts{Fetch: 10 * time.Millisecond},
+		1,
+	)
+
+	t.Run("Empty queue", func(t *testing.T) {
+		assert.NoError(t, reconciler.Drain(ctx))
+		assert.True(t, reconciler.Idle())
+	})
+
+	t.Run("Queued account", func(t *testing.T) {
+		reconciler.QueueAccounts(ctx, 1, []*AccountAndCurrency{acct})
+		assert.Equal(t, 1, reconciler.Backlog())
+		assert.False(t, reconciler.Idle())
+
+		// The queued account is reconciled (and
+		// times out fetching its live balance).
+		err := reconciler.Drain(ctx)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+		assert.Equal(t, 0, reconciler.Backlog())
+		assert.True(t, reconciler.Idle())
+	})
+	t.Run("Live balance after head", func(t *testing.T) {
+		newDir, err := storage.CreateTempDir()
+		assert.NoError(t, err)
+		defer storage.RemoveTempDir(*newDir)
+
+		database, err := storage.NewBadgerStorage(ctx, *newDir)
+		assert.NoError(t, err)
+		defer database.Close(ctx)
+
+		blockStorage := storage.NewBlockStorage(ctx, database, &storage.GobCodec{}, &storage.SHA256KeyHasher{})
+		txn := blockStorage.NewDatabaseTransaction(ctx, true)
+		assert.NoError(t, blockStorage.StoreHeadBlockIdentifier(ctx, txn, &rosetta.BlockIdentifier{
+			Hash:  "block 1",
+			Index: 1,
+		}))
+		assert.NoError(t, txn.Commit(ctx))
+
+		liveBlock := &rosetta.BlockIdentifier{
+			Hash:  "block 2",
+			Index: 2,
+		}
+		reconciler := NewStateful(
+			ctx,
+			nil,
+			blockStorage,
+			&staticFetcher{
+				block: liveBlock,
+				balances: []*rosetta.Balance{
+					{
+						AccountIdentifier: acct.Account,
+						Amounts: []*rosetta.Amount{
+							{Value: "10", Currency: acct.Currency},
+						},
+					},
+				},
+			},
+			logger.NewLogger(*newDir, false, false, false, false),
+			&metrics.NoOpSink{},
+			Timeouts{},
+			1,
+		)
+
+		// The account is skipped instead of waiting
+		// for the head to reach the live block.
+		reconciler.QueueAccounts(ctx, 1, []*AccountAndCurrency{acct})
+		assert.NoError(t, reconciler.Drain(ctx))
+
+		reconciliations, _, err := blockStorage.Reconciliations(ctx, acct.Account, 0, 10)
+		assert.NoError(t, err)
+		assert.Len(t, reconciliations, 1)
+		assert.Equal(t, storage.ReconciliationSkipped, reconciliations[0].Outcome)
+		assert.Equal(t, liveBlock, reconciliations[0].Block)
+	})
+}
+
+func TestReconciliationCoverage(t *testing.T) {
+	ctx := context.Background()
+	var (
+		account = &rosetta.AccountIdentifier{
+			Address: "blah",
+		}
+		acct1 = &AccountAndCurrency{
+			Account: account,
+			Currency: &rosetta.Currency{
+				Symbol:   "curr1",
+				Decimals: 4,
+			},
+		}
+		acct2 = &AccountAndCurrency{
+			Account: account,
+			Currency: &rosetta.Currency{
+				Symbol:   "curr2",
+				Decimals: 4,
+			},
+		}
+		block = &rosetta.Block{
+			BlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "block 1",
+				Index: 1,
+			},
+			ParentBlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "block 0",
+				Index: 0,
+			},
+		}
+	)
+
+	newDir, err := storage.CreateTempDir()
+	assert.NoError(t, err)
+	defer storage.RemoveTempDir(*newDir)
+
+	database, err := storage.NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	blockStorage := storage.NewBlockStorage(ctx, database, &storage.GobCodec{}, &storage.SHA256KeyHasher{})
+	txn := blockStorage.NewDatabaseTransaction(ctx, true)
+	assert.NoError(t, blockStorage.StoreBlock(ctx, txn, block))
+	assert.NoError(t, blockStorage.StoreHeadBlockIdentifier(ctx, txn, block.BlockIdentifier))
+	assert.NoError(t, blockStorage.UpdateBalance(ctx, txn, account, &rosetta.Amount{
+		Value:    "10",
+		Currency: acct1.Currency,
+	}, block.BlockIdentifier))
+	assert.NoError(t, txn.Commit(ctx))
+
+	// Only the live balance of acct1 is returned.
+	reconciler := NewStateful(
+		ctx,
+		nil,
+		blockStorage,
+		&staticFetcher{
+			block: block.BlockIdentifier,
+			balances: []*rosetta.Balance{
+				{
+					AccountIdentifier: account,
+					Amounts: []*rosetta.Amount{
+						{Value: "10", Currency: acct1.Currency},
+					},
+				},
+			},
+		},
+		logger.NewLogger(*newDir, false, false, false, false),
+		&metrics.NoOpSink{},
+		Timeouts{},
+		1,
+	)
+
+	t.Run("Nothing queued", func(t *testing.T) {
+		assert.Equal(t, 0, reconciler.ReconciledAccounts())
+		assert.Equal(t, 0, reconciler.ModifiedAccounts())
+		assert.Equal(t, float64(0), reconciler.ReconciliationCoverage())
+	})
+
+	t.Run("Queued accounts", func(t *testing.T) {
+		reconciler.QueueAccounts(ctx, 1, []*AccountAndCurrency{acct1, acct2})
+		assert.Equal(t, 0, reconciler.ReconciledAccounts())
+		assert.Equal(t, 2, reconciler.ModifiedAccounts())
+		assert.Equal(t, float64(0), reconciler.ReconciliationCoverage())
+	})
+
+	t.Run("Reconciled accounts", func(t *testing.T) {
+		// acct2 cannot be reconciled without
+		// its live balance.
+		assert.Error(t, reconciler.Drain(ctx))
+		assert.Equal(t, 1, reconciler.ReconciledAccounts())
+		assert.Equal(t, int64(1), reconciler.Reconciliations())
+		assert.Equal(t, 0.5, reconciler.ReconciliationCoverage())
+	})
+
+	t.Run("Accounts queued again", func(t *testing.T) {
+		reconciler.QueueAccounts(ctx, 1, []*AccountAndCurrency{acct1})
+		assert.NoError(t, reconciler.Drain(ctx))
+		assert.Equal(t, 1, reconciler.ReconciledAccounts())
+		assert.Equal(t, 2, reconciler.ModifiedAccounts())
+		assert.Equal(t, int64(2), reconciler.Reconciliations())
+		assert.Equal(t, 0.5, reconciler.ReconciliationCoverage())
+	})
+}
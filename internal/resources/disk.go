@@ -0,0 +1,159 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/coinbase/rosetta-validator/internal/metrics"
+)
+
+// pruneThreshold is the fraction of the disk limit
+// at which the DiskMonitor begins to prune.
+const pruneThreshold = 0.9
+
+// ErrDiskLimitExceeded is returned when the size of
+// the data directory exceeds its limit after pruning.
+var ErrDiskLimitExceeded = errors.New("Disk usage limit exceeded")
+
+// Pruner reclaims disk space.
+type Pruner func(ctx context.Context) error
+
+// DirectorySize returns the total size in bytes of
+// all regular files in dir.
+func DirectorySize(dir string) (uint64, error) {
+	var size uint64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Badger may remove files while we walk.
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			size += uint64(info.Size())
+		}
+
+		return nil
+	})
+
+	return size, err
+}
+
+// DiskMonitor periodically measures the size of a
+// directory. When it approaches its limit, the Pruner
+// is called to reclaim space. If the directory is still
+// over the limit after pruning, the DiskMonitor returns
+// an error so that the validator halts before writes
+// begin to fail.
+type DiskMonitor struct {
+	dir      string
+	maxBytes uint64
+	pruner   Pruner
+	sink     metrics.Sink
+}
+
+// NewDiskMonitor returns a new DiskMonitor. If pruner
+// is nil, no space is reclaimed before halting.
+func NewDiskMonitor(
+	dir string,
+	maxBytes uint64,
+	pruner Pruner,
+	sink metrics.Sink,
+) *DiskMonitor {
+	return &DiskMonitor{
+		dir:      dir,
+		maxBytes: maxBytes,
+		pruner:   pruner,
+		sink:     sink,
+	}
+}
+
+// measure returns the size of the directory and
+// records it.
+func (m *DiskMonitor) measure() (uint64, error) {
+	size, err := DirectorySize(m.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	m.sink.SetGauge(metrics.DiskUsageBytes, float64(size))
+	return size, nil
+}
+
+// Check measures the directory once, prunes it if it
+// is near its limit, and returns ErrDiskLimitExceeded
+// if it is still over its limit.
+func (m *DiskMonitor) Check(ctx context.Context) error {
+	size, err := m.measure()
+	if err != nil {
+		return err
+	}
+
+	if float64(size) < pruneThreshold*float64(m.maxBytes) {
+		return nil
+	}
+
+	if m.pruner != nil {
+		log.Printf("Pruning %s (%d of %d bytes used)\n", m.dir, size, m.maxBytes)
+		if err := m.pruner(ctx); err != nil {
+			return fmt.Errorf("%w: unable to prune %s", err, m.dir)
+		}
+
+		size, err = m.measure()
+		if err != nil {
+			return err
+		}
+	}
+
+	if size >= m.maxBytes {
+		return fmt.Errorf(
+			"%w: %s uses %d of %d bytes",
+			ErrDiskLimitExceeded,
+			m.dir,
+			size,
+			m.maxBytes,
+		)
+	}
+
+	return nil
+}
+
+// Run checks disk usage every interval until the
+// context is canceled or the limit is exceeded.
+func (m *DiskMonitor) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := m.Check(ctx); err != nil {
+				return err
+			}
+		}
+	}
+}
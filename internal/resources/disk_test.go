@@ -0,0 +1,89 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resources
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coinbase/rosetta-validator/internal/metrics"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiskMonitor(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "disk")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "data")
+	write := func(size int) {
+		assert.NoError(t, ioutil.WriteFile(path, make([]byte, size), 0600))
+	}
+
+	size, err := DirectorySize(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), size)
+
+	t.Run("Below threshold", func(t *testing.T) {
+		write(500)
+		pruned := 0
+		monitor := NewDiskMonitor(dir, 1000, func(context.Context) error {
+			pruned++
+			return nil
+		}, &metrics.NoOpSink{})
+
+		assert.NoError(t, monitor.Check(ctx))
+		assert.Equal(t, 0, pruned)
+	})
+
+	t.Run("Pruning reclaims space", func(t *testing.T) {
+		write(950)
+		monitor := NewDiskMonitor(dir, 1000, func(context.Context) error {
+			write(100)
+			return nil
+		}, &metrics.NoOpSink{})
+
+		assert.NoError(t, monitor.Check(ctx))
+
+		size, err := DirectorySize(dir)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(100), size)
+	})
+
+	t.Run("Pruning is insufficient", func(t *testing.T) {
+		write(1200)
+		monitor := NewDiskMonitor(dir, 1000, func(context.Context) error {
+			write(1100)
+			return nil
+		}, &metrics.NoOpSink{})
+
+		assert.True(t, errors.Is(monitor.Check(ctx), ErrDiskLimitExceeded))
+	})
+
+	t.Run("No pruner", func(t *testing.T) {
+		write(950)
+		monitor := NewDiskMonitor(dir, 1000, nil, &metrics.NoOpSink{})
+		assert.NoError(t, monitor.Check(ctx))
+
+		write(1000)
+		assert.True(t, errors.Is(monitor.Check(ctx), ErrDiskLimitExceeded))
+	})
+}
@@ -0,0 +1,202 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resources
+
+import (
+	"context"
+	"log"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/coinbase/rosetta-validator/internal/metrics"
+)
+
+const (
+	// throttleThreshold is the fraction of a limit at
+	// which the Monitor begins to throttle.
+	throttleThreshold = 0.8
+
+	// recoverThreshold is the fraction of every limit
+	// below which the Monitor relaxes throttling.
+	recoverThreshold = 0.5
+
+	// minScale is the smallest scale the Monitor
+	// will apply.
+	minScale = 1.0 / 16
+
+	// recoverStep is added to the scale each time
+	// usage is below recoverThreshold.
+	recoverStep = 0.125
+)
+
+// Limits are ceilings on the resources used by
+// the validator. A limit of 0 is ignored.
+type Limits struct {
+	// MaxMemory is the maximum size of the heap
+	// in bytes.
+	MaxMemory uint64
+
+	// MaxGoroutines is the maximum number of
+	// goroutines.
+	MaxGoroutines int
+}
+
+// Usage is a sample of the resources in use.
+type Usage struct {
+	Memory     uint64
+	Goroutines int
+}
+
+// Sampler returns the resources currently in use.
+type Sampler func() Usage
+
+// RuntimeSampler samples the heap size and number
+// of goroutines from the Go runtime.
+func RuntimeSampler() Usage {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	return Usage{
+		Memory:     stats.HeapAlloc,
+		Goroutines: runtime.NumGoroutine(),
+	}
+}
+
+// Adjuster is called with a scale in (0, 1] whenever it
+// changes. Components should size their buffers and
+// concurrency in proportion to the scale.
+type Adjuster func(scale float64)
+
+// Monitor periodically samples resource usage. When usage
+// approaches a limit, it halves the scale passed to its
+// Adjusters so that buffers and concurrency shrink before
+// the limit is reached. Once usage falls, the scale is
+// gradually restored.
+type Monitor struct {
+	limits  Limits
+	sampler Sampler
+	sink    metrics.Sink
+
+	mutex     sync.Mutex
+	scale     float64
+	adjusters []Adjuster
+}
+
+// NewMonitor returns a new Monitor.
+func NewMonitor(limits Limits, sampler Sampler, sink metrics.Sink) *Monitor {
+	return &Monitor{
+		limits:  limits,
+		sampler: sampler,
+		sink:    sink,
+		scale:   1,
+	}
+}
+
+// Register adds an Adjuster to the Monitor. It is
+// called immediately with the current scale.
+func (m *Monitor) Register(adjuster Adjuster) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.adjusters = append(m.adjusters, adjuster)
+	adjuster(m.scale)
+}
+
+// Scale returns the current scale.
+func (m *Monitor) Scale() float64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.scale
+}
+
+// utilization returns the largest fraction of
+// any limit used by usage.
+func (m *Monitor) utilization(usage Usage) float64 {
+	utilization := 0.0
+	if m.limits.MaxMemory > 0 {
+		memory := float64(usage.Memory) / float64(m.limits.MaxMemory)
+		if memory > utilization {
+			utilization = memory
+		}
+	}
+
+	if m.limits.MaxGoroutines > 0 {
+		goroutines := float64(usage.Goroutines) / float64(m.limits.MaxGoroutines)
+		if goroutines > utilization {
+			utilization = goroutines
+		}
+	}
+
+	return utilization
+}
+
+// Check samples resource usage once and adjusts
+// the scale if necessary.
+func (m *Monitor) Check() {
+	usage := m.sampler()
+	utilization := m.utilization(usage)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	scale := m.scale
+	switch {
+	case utilization >= throttleThreshold:
+		scale /= 2
+		if scale < minScale {
+			scale = minScale
+		}
+	case utilization < recoverThreshold:
+		scale += recoverStep
+		if scale > 1 {
+			scale = 1
+		}
+	}
+
+	m.sink.SetGauge(metrics.ResourceScale, scale)
+	if scale == m.scale {
+		return
+	}
+
+	log.Printf(
+		"Resource scale changed from %.3f to %.3f (memory: %d bytes, goroutines: %d)\n",
+		m.scale,
+		scale,
+		usage.Memory,
+		usage.Goroutines,
+	)
+	m.scale = scale
+	for _, adjuster := range m.adjusters {
+		adjuster(scale)
+	}
+}
+
+// Run checks resource usage every interval
+// until the context is canceled.
+func (m *Monitor) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			m.Check()
+		}
+	}
+}
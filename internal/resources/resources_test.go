@@ -0,0 +1,90 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resources
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coinbase/rosetta-validator/internal/metrics"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMonitor(t *testing.T) {
+	usage := Usage{}
+	monitor := NewMonitor(
+		Limits{MaxMemory: 1000, MaxGoroutines: 100},
+		func() Usage { return usage },
+		&metrics.NoOpSink{},
+	)
+
+	scales := []float64{}
+	monitor.Register(func(scale float64) {
+		scales = append(scales, scale)
+	})
+	assert.Equal(t, []float64{1}, scales)
+
+	t.Run("Usage below limits", func(t *testing.T) {
+		usage = Usage{Memory: 100, Goroutines: 10}
+		monitor.Check()
+		assert.Equal(t, float64(1), monitor.Scale())
+		assert.Len(t, scales, 1)
+	})
+
+	t.Run("Memory approaching limit", func(t *testing.T) {
+		usage = Usage{Memory: 900, Goroutines: 10}
+		monitor.Check()
+		monitor.Check()
+		assert.Equal(t, 0.25, monitor.Scale())
+		assert.Equal(t, []float64{1, 0.5, 0.25}, scales)
+	})
+
+	t.Run("Goroutines approaching limit", func(t *testing.T) {
+		usage = Usage{Memory: 100, Goroutines: 95}
+		for i := 0; i < 10; i++ {
+			monitor.Check()
+		}
+		assert.Equal(t, minScale, monitor.Scale())
+	})
+
+	t.Run("Usage between thresholds", func(t *testing.T) {
+		usage = Usage{Memory: 600, Goroutines: 10}
+		monitor.Check()
+		assert.Equal(t, minScale, monitor.Scale())
+	})
+
+	t.Run("Usage recovers", func(t *testing.T) {
+		usage = Usage{Memory: 100, Goroutines: 10}
+		monitor.Check()
+		assert.Equal(t, minScale+recoverStep, monitor.Scale())
+
+		for i := 0; i < 10; i++ {
+			monitor.Check()
+		}
+		assert.Equal(t, float64(1), monitor.Scale())
+	})
+}
+
+func TestMonitorNoLimits(t *testing.T) {
+	monitor := NewMonitor(Limits{}, RuntimeSampler, &metrics.NoOpSink{})
+	monitor.Check()
+	assert.Equal(t, float64(1), monitor.Scale())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.NoError(t, monitor.Run(ctx, time.Millisecond))
+}
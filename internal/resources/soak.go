@@ -0,0 +1,189 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resources
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// ErrSoakTestComplete is returned by SoakMonitor.Run
+// once the soak test duration has elapsed.
+var ErrSoakTestComplete = errors.New("soak test complete")
+
+// StabilitySample is a sample of the indicators used
+// to assess the long-haul stability of the validator
+// and the node it validates.
+type StabilitySample struct {
+	Goroutines int64
+	HeapBytes  int64
+
+	// FileDescriptors is -1 if the number of open
+	// file descriptors cannot be determined.
+	FileDescriptors int64
+
+	// QueueDepth is the number of accounts waiting
+	// to be reconciled.
+	QueueDepth int64
+
+	// HeadIndex is the index of the last synced
+	// block (-1 if no block has been synced).
+	HeadIndex int64
+}
+
+// StabilitySampler returns the current stability
+// indicators.
+type StabilitySampler func() StabilitySample
+
+// OpenFileDescriptors returns the number of file
+// descriptors open by the process or -1 if it cannot
+// be determined (ex: on platforms without /proc).
+func OpenFileDescriptors() int64 {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+
+	return int64(len(fds))
+}
+
+// Indicator summarizes the samples of a
+// stability indicator.
+type Indicator struct {
+	First int64 `json:"first"`
+	Last  int64 `json:"last"`
+	Min   int64 `json:"min"`
+	Max   int64 `json:"max"`
+
+	// Growth is Last - First. Steady growth of an
+	// indicator over a long soak test suggests a
+	// leak (or, for HeadIndex, sync progress).
+	Growth int64 `json:"growth"`
+}
+
+func (i *Indicator) add(value int64, first bool) {
+	if first {
+		*i = Indicator{First: value, Min: value, Max: value}
+	}
+
+	if value < i.Min {
+		i.Min = value
+	}
+
+	if value > i.Max {
+		i.Max = value
+	}
+
+	i.Last = value
+	i.Growth = i.Last - i.First
+}
+
+// StabilityReport summarizes the stability
+// indicators sampled during a soak test.
+type StabilityReport struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Samples int       `json:"samples"`
+
+	Goroutines      Indicator `json:"goroutines"`
+	HeapBytes       Indicator `json:"heap_bytes"`
+	FileDescriptors Indicator `json:"file_descriptors"`
+	QueueDepth      Indicator `json:"queue_depth"`
+	HeadIndex       Indicator `json:"head_index"`
+}
+
+// SoakMonitor periodically samples stability indicators
+// for a fixed duration and summarizes them in a
+// StabilityReport.
+type SoakMonitor struct {
+	sampler StabilitySampler
+	now     func() time.Time
+
+	mutex  sync.Mutex
+	report StabilityReport
+}
+
+// NewSoakMonitor returns a new SoakMonitor.
+func NewSoakMonitor(sampler StabilitySampler) *SoakMonitor {
+	return &SoakMonitor{
+		sampler: sampler,
+		now:     time.Now,
+	}
+}
+
+// Sample records the current stability indicators.
+func (m *SoakMonitor) Sample() {
+	sample := m.sampler()
+	now := m.now()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	first := m.report.Samples == 0
+	if first {
+		m.report.Start = now
+	}
+
+	m.report.End = now
+	m.report.Samples++
+	m.report.Goroutines.add(sample.Goroutines, first)
+	m.report.HeapBytes.add(sample.HeapBytes, first)
+	m.report.FileDescriptors.add(sample.FileDescriptors, first)
+	m.report.QueueDepth.add(sample.QueueDepth, first)
+	m.report.HeadIndex.add(sample.HeadIndex, first)
+}
+
+// Report returns a summary of the samples
+// recorded so far.
+func (m *SoakMonitor) Report() *StabilityReport {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	report := m.report
+	return &report
+}
+
+// Run samples stability indicators every interval. Once
+// duration has elapsed, it takes a final sample and returns
+// ErrSoakTestComplete (so that the rest of the validator is
+// stopped). It returns nil if the context is canceled first.
+func (m *SoakMonitor) Run(
+	ctx context.Context,
+	interval time.Duration,
+	duration time.Duration,
+) error {
+	m.Sample()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+			m.Sample()
+			return ErrSoakTestComplete
+		case <-ticker.C:
+			m.Sample()
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resources
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSoakMonitor(t *testing.T) {
+	samples := []StabilitySample{
+		{Goroutines: 10, HeapBytes: 1000, FileDescriptors: 5, QueueDepth: 0, HeadIndex: -1},
+		{Goroutines: 14, HeapBytes: 800, FileDescriptors: 7, QueueDepth: 20, HeadIndex: 50},
+		{Goroutines: 12, HeapBytes: 1500, FileDescriptors: 6, QueueDepth: 3, HeadIndex: 100},
+	}
+
+	i := 0
+	monitor := NewSoakMonitor(func() StabilitySample {
+		sample := samples[i]
+		i++
+		return sample
+	})
+
+	start := time.Unix(1000, 0)
+	now := start
+	monitor.now = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+
+	for range samples {
+		monitor.Sample()
+	}
+
+	assert.Equal(t, &StabilityReport{
+		Start:           start.Add(time.Minute),
+		End:             start.Add(3 * time.Minute),
+		Samples:         3,
+		Goroutines:      Indicator{First: 10, Last: 12, Min: 10, Max: 14, Growth: 2},
+		HeapBytes:       Indicator{First: 1000, Last: 1500, Min: 800, Max: 1500, Growth: 500},
+		FileDescriptors: Indicator{First: 5, Last: 6, Min: 5, Max: 7, Growth: 1},
+		QueueDepth:      Indicator{First: 0, Last: 3, Min: 0, Max: 20, Growth: 3},
+		HeadIndex:       Indicator{First: -1, Last: 100, Min: -1, Max: 100, Growth: 101},
+	}, monitor.Report())
+}
+
+func TestSoakMonitorRun(t *testing.T) {
+	monitor := NewSoakMonitor(func() StabilitySample {
+		return StabilitySample{Goroutines: 1}
+	})
+
+	t.Run("Duration elapses", func(t *testing.T) {
+		err := monitor.Run(context.Background(), time.Hour, 10*time.Millisecond)
+		assert.Equal(t, ErrSoakTestComplete, err)
+		assert.Equal(t, 2, monitor.Report().Samples)
+	})
+
+	t.Run("Context canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		assert.NoError(t, monitor.Run(ctx, time.Hour, time.Hour))
+	})
+}
@@ -0,0 +1,115 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"sync"
+)
+
+// Limiter bounds the number of slots each class may use
+// at once. Unlike a Scheduler, the classes do not compete
+// for a shared pool. The limits can be changed while slots
+// are in use (ex: to back off from a rate limiting node).
+type Limiter struct {
+	mutex  sync.Mutex
+	limits [numClasses]int
+	inUse  [numClasses]int
+
+	// changed is closed (and replaced) whenever a slot
+	// is released or a limit changes to wake waiters.
+	changed chan struct{}
+}
+
+// NewLimiter returns a new Limiter that allows fetchLimit
+// Fetch slots and reconcileLimit Reconcile slots.
+func NewLimiter(fetchLimit int, reconcileLimit int) *Limiter {
+	l := &Limiter{changed: make(chan struct{})}
+	l.limits[Fetch] = fetchLimit
+	l.limits[Reconcile] = reconcileLimit
+
+	return l
+}
+
+// notify wakes all waiters. The caller must hold the mutex.
+func (l *Limiter) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// Acquire blocks until a slot is available to class
+// or the context is canceled. Every successful call
+// to Acquire must be followed by a call to Release.
+func (l *Limiter) Acquire(ctx context.Context, class Class) error {
+	l.mutex.Lock()
+	for l.inUse[class] >= l.limits[class] {
+		changed := l.changed
+		l.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+
+		l.mutex.Lock()
+	}
+
+	l.inUse[class]++
+	l.mutex.Unlock()
+
+	return nil
+}
+
+// Release returns a slot acquired by class.
+func (l *Limiter) Release(class Class) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.inUse[class]--
+	l.notify()
+}
+
+// SetLimit changes the number of slots class may use. If
+// limit is reduced below the number of slots in use, no
+// slots are granted to class until enough are released.
+// A limit less than 1 is treated as 1.
+func (l *Limiter) SetLimit(class Class, limit int) {
+	if limit < 1 {
+		limit = 1
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.limits[class] = limit
+	l.notify()
+}
+
+// Limit returns the number of slots class may use.
+func (l *Limiter) Limit(class Class) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.limits[class]
+}
+
+// InUse returns the number of slots used by class.
+func (l *Limiter) InUse(class Class) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.inUse[class]
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(2, 1)
+
+	// The classes do not compete for slots.
+	assert.NoError(t, l.Acquire(context.Background(), Fetch))
+	assert.NoError(t, l.Acquire(context.Background(), Fetch))
+	assert.NoError(t, l.Acquire(context.Background(), Reconcile))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, l.Acquire(ctx, Fetch))
+	assert.Equal(t, context.DeadlineExceeded, l.Acquire(ctx, Reconcile))
+
+	t.Run("Lowered below slots in use", func(t *testing.T) {
+		l.SetLimit(Fetch, 1)
+		assert.Equal(t, 1, l.Limit(Fetch))
+
+		done := make(chan error)
+		go func() {
+			done <- l.Acquire(context.Background(), Fetch)
+		}()
+
+		// Both slots must be released before
+		// another is granted.
+		l.Release(Fetch)
+		select {
+		case <-done:
+			t.Fatal("acquired slot above limit")
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		l.Release(Fetch)
+		assert.NoError(t, <-done)
+		assert.Equal(t, 1, l.InUse(Fetch))
+	})
+
+	t.Run("Raised", func(t *testing.T) {
+		done := make(chan error)
+		go func() {
+			done <- l.Acquire(context.Background(), Reconcile)
+		}()
+
+		l.SetLimit(Reconcile, 2)
+		assert.NoError(t, <-done)
+		assert.Equal(t, 2, l.InUse(Reconcile))
+	})
+
+	t.Run("Limit less than 1", func(t *testing.T) {
+		l.SetLimit(Fetch, 0)
+		assert.Equal(t, 1, l.Limit(Fetch))
+	})
+}
@@ -0,0 +1,189 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"sync"
+)
+
+// Class identifies the kind of work a slot is used for.
+type Class int
+
+const (
+	// Fetch is block and transaction fetching.
+	Fetch Class = iota
+
+	// Reconcile is account balance fetching.
+	Reconcile
+
+	numClasses = 2
+)
+
+// Scheduler is a pool of slots shared between fetching
+// and reconciliation. Each class is entitled to a share
+// of the pool proportional to its demand (the number of
+// slots it is using or waiting for), so capacity shifts
+// to whichever class has the deeper queue. A class may
+// use all slots when the other has no demand.
+type Scheduler struct {
+	mutex    sync.Mutex
+	capacity int
+	minShare int
+	inUse    [numClasses]int
+	waiting  [numClasses]int
+
+	// changed is closed (and replaced) whenever a slot
+	// is released or the capacity changes to wake waiters.
+	changed chan struct{}
+}
+
+// New returns a new Scheduler with capacity slots. While both
+// classes have demand, each is entitled to at least minShare
+// slots.
+func New(capacity int, minShare int) *Scheduler {
+	return &Scheduler{
+		capacity: capacity,
+		minShare: minShare,
+		changed:  make(chan struct{}),
+	}
+}
+
+// other returns the class competing with class.
+func other(class Class) Class {
+	if class == Fetch {
+		return Reconcile
+	}
+
+	return Fetch
+}
+
+// share returns the number of slots class is entitled to.
+// The caller must hold the mutex.
+func (s *Scheduler) share(class Class) int {
+	demand := s.inUse[class] + s.waiting[class]
+	otherDemand := s.inUse[other(class)] + s.waiting[other(class)]
+	if otherDemand == 0 {
+		return s.capacity
+	}
+
+	minShare := s.minShare
+	if minShare > s.capacity/2 {
+		minShare = s.capacity / 2
+	}
+
+	share := s.capacity * demand / (demand + otherDemand)
+	if share < minShare {
+		share = minShare
+	}
+
+	if maxShare := s.capacity - minShare; share > maxShare {
+		share = maxShare
+	}
+
+	// Ensure a class with demand can always make
+	// progress (ex: when the capacity is 1).
+	if share < 1 {
+		share = 1
+	}
+
+	return share
+}
+
+// tryAcquire takes a slot if one is available to class.
+// The caller must hold the mutex.
+func (s *Scheduler) tryAcquire(class Class) bool {
+	total := s.inUse[Fetch] + s.inUse[Reconcile]
+	if total >= s.capacity || s.inUse[class] >= s.share(class) {
+		return false
+	}
+
+	s.inUse[class]++
+	return true
+}
+
+// notify wakes all waiters. The caller must hold the mutex.
+func (s *Scheduler) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Acquire blocks until a slot is available to class
+// or the context is canceled. Every successful call
+// to Acquire must be followed by a call to Release.
+func (s *Scheduler) Acquire(ctx context.Context, class Class) error {
+	s.mutex.Lock()
+	s.waiting[class]++
+	defer func() {
+		s.mutex.Lock()
+		s.waiting[class]--
+		s.notify()
+		s.mutex.Unlock()
+	}()
+
+	for {
+		if s.tryAcquire(class) {
+			s.mutex.Unlock()
+			return nil
+		}
+
+		changed := s.changed
+		s.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+
+		s.mutex.Lock()
+	}
+}
+
+// Release returns a slot acquired by class.
+func (s *Scheduler) Release(class Class) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.inUse[class]--
+	s.notify()
+}
+
+// SetCapacity changes the number of slots. If capacity is
+// reduced below the number of slots in use, no slots are
+// granted until enough are released.
+func (s *Scheduler) SetCapacity(capacity int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.capacity = capacity
+	s.notify()
+}
+
+// Capacity returns the number of slots.
+func (s *Scheduler) Capacity() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.capacity
+}
+
+// InUse returns the number of slots used by class.
+func (s *Scheduler) InUse(class Class) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.inUse[class]
+}
@@ -0,0 +1,96 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShare(t *testing.T) {
+	s := New(10, 2)
+
+	// Without competing demand, a class may use every slot.
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, s.Acquire(context.Background(), Fetch))
+	}
+	assert.Equal(t, 10, s.InUse(Fetch))
+
+	// Reconciliation is now waiting, so slots released by
+	// fetching are handed to reconciliation until it reaches
+	// its share.
+	acquired := make(chan struct{})
+	for i := 0; i < 10; i++ {
+		go func() {
+			assert.NoError(t, s.Acquire(context.Background(), Reconcile))
+			acquired <- struct{}{}
+		}()
+	}
+
+	assert.Eventually(t, func() bool {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		return s.waiting[Reconcile] == 10
+	}, time.Second, time.Millisecond)
+
+	for i := 0; i < 5; i++ {
+		s.Release(Fetch)
+		<-acquired
+	}
+	assert.Equal(t, 5, s.InUse(Fetch))
+	assert.Equal(t, 5, s.InUse(Reconcile))
+
+	// With equal demand, fetching cannot take back the
+	// released slots.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.Acquire(ctx, Fetch))
+}
+
+func TestMinShare(t *testing.T) {
+	s := New(4, 1)
+	s.inUse[Fetch] = 3
+	s.waiting[Reconcile] = 100
+
+	assert.Equal(t, 1, s.share(Fetch))
+	assert.Equal(t, 3, s.share(Reconcile))
+
+	// A minShare larger than half the capacity is ignored.
+	s = New(1, 1)
+	s.inUse[Fetch] = 1
+	s.waiting[Reconcile] = 1
+
+	assert.Equal(t, 1, s.share(Fetch))
+	assert.Equal(t, 1, s.share(Reconcile))
+}
+
+func TestSetCapacity(t *testing.T) {
+	s := New(1, 0)
+	assert.NoError(t, s.Acquire(context.Background(), Fetch))
+
+	done := make(chan error)
+	go func() {
+		done <- s.Acquire(context.Background(), Fetch)
+	}()
+
+	s.SetCapacity(2)
+	assert.NoError(t, <-done)
+	assert.Equal(t, 2, s.Capacity())
+	assert.Equal(t, 2, s.InUse(Fetch))
+}
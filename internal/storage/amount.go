@@ -0,0 +1,163 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+const (
+	// MaxAmountDigits is the maximum number of digits in
+	// an amount or balance. Values on some chains exceed
+	// 10^30, so this is far larger than any legitimate
+	// value but bounds the cost of arithmetic on values
+	// returned by a faulty implementation.
+	MaxAmountDigits = 100
+
+	// InvalidAmountFinding is the Finding.Type recorded
+	// for an amount that is not a base 10 integer.
+	InvalidAmountFinding = "invalid_amount"
+
+	// AmountOverflowFinding is the Finding.Type recorded
+	// for an amount or balance with more than
+	// MaxAmountDigits digits.
+	AmountOverflowFinding = "amount_overflow"
+)
+
+var (
+	// ErrInvalidAmountValue is returned when an Amount.Value
+	// is not a base 10 integer with an optional leading '-'.
+	ErrInvalidAmountValue = errors.New("Invalid amount value")
+
+	// ErrAmountOverflow is returned when an amount or the
+	// balance it results in has more than MaxAmountDigits
+	// digits.
+	ErrAmountOverflow = errors.New("Amount overflow")
+)
+
+// AmountError is returned when an amount cannot be
+// applied to a balance because of its format or size.
+type AmountError struct {
+	Account  *rosetta.AccountIdentifier
+	Currency *rosetta.Currency
+	Block    *rosetta.BlockIdentifier
+	Value    string
+	Err      error
+}
+
+// Error returns a description of the AmountError.
+func (e *AmountError) Error() string {
+	return fmt.Sprintf("%v %q for %+v at %+v", e.Err, e.Value, e.Account, e.Block)
+}
+
+// Unwrap returns ErrInvalidAmountValue or ErrAmountOverflow.
+func (e *AmountError) Unwrap() error {
+	return e.Err
+}
+
+// Finding returns a Finding recording the AmountError.
+func (e *AmountError) Finding() *Finding {
+	findingType := InvalidAmountFinding
+	if errors.Is(e.Err, ErrAmountOverflow) {
+		findingType = AmountOverflowFinding
+	}
+
+	return &Finding{
+		Type:       findingType,
+		Account:    e.Account,
+		Currency:   e.Currency,
+		Block:      e.Block,
+		Difference: e.Value,
+	}
+}
+
+// ParseAmountValue strictly parses an Amount.Value, which
+// must be a base 10 integer of arbitrary precision with an
+// optional leading '-'. Empty strings, a leading '+',
+// decimals, and scientific notation are rejected.
+func ParseAmountValue(value string) (*big.Int, error) {
+	digits := value
+	if len(digits) > 0 && digits[0] == '-' {
+		digits = digits[1:]
+	}
+
+	if len(digits) == 0 {
+		return nil, fmt.Errorf("%w: no digits", ErrInvalidAmountValue)
+	}
+
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return nil, fmt.Errorf("%w: unexpected character %q", ErrInvalidAmountValue, c)
+		}
+	}
+
+	if len(digits) > MaxAmountDigits {
+		return nil, fmt.Errorf("%w: %d digits", ErrAmountOverflow, len(digits))
+	}
+
+	parsed, ok := new(big.Int).SetString(value, 10)
+	if !ok {
+		return nil, ErrInvalidAmountValue
+	}
+
+	return parsed, nil
+}
+
+// FormatAmount renders an Amount.Value in standard units of
+// currency using Currency.Decimals (ex: "1.25 BTC" instead of
+// "125000000"). Trailing zeros in the fractional part are
+// omitted. Values that cannot be parsed are rendered as is.
+func FormatAmount(value string, currency *rosetta.Currency) string {
+	parsed, err := ParseAmountValue(value)
+	if err != nil || currency.Decimals <= 0 {
+		return value + " " + currency.Symbol
+	}
+
+	sign := ""
+	if parsed.Sign() < 0 {
+		sign = "-"
+		parsed.Neg(parsed)
+	}
+
+	digits := parsed.String()
+	decimals := int(currency.Decimals)
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+
+	whole := digits[:len(digits)-decimals]
+	fraction := strings.TrimRight(digits[len(digits)-decimals:], "0")
+	if len(fraction) > 0 {
+		whole += "." + fraction
+	}
+
+	return sign + whole + " " + currency.Symbol
+}
+
+// checkAmountSize returns ErrAmountOverflow if value
+// has more than MaxAmountDigits digits.
+func checkAmountSize(value *big.Int) error {
+	digits := len(new(big.Int).Abs(value).String())
+	if digits > MaxAmountDigits {
+		return fmt.Errorf("%w: %d digits", ErrAmountOverflow, digits)
+	}
+
+	return nil
+}
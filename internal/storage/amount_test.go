@@ -0,0 +1,166 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAmountValue(t *testing.T) {
+	var tests = map[string]struct {
+		value  string
+		result string
+		err    error
+	}{
+		"positive":         {value: "100", result: "100"},
+		"negative":         {value: "-100", result: "-100"},
+		"zero":             {value: "0", result: "0"},
+		"10^30 scale":      {value: "1" + strings.Repeat("0", 30), result: "1" + strings.Repeat("0", 30)},
+		"empty":            {value: "", err: ErrInvalidAmountValue},
+		"only sign":        {value: "-", err: ErrInvalidAmountValue},
+		"leading plus":     {value: "+100", err: ErrInvalidAmountValue},
+		"decimal":          {value: "1.5", err: ErrInvalidAmountValue},
+		"scientific":       {value: "1e18", err: ErrInvalidAmountValue},
+		"hex":              {value: "0x10", err: ErrInvalidAmountValue},
+		"whitespace":       {value: " 100", err: ErrInvalidAmountValue},
+		"underscore":       {value: "1_000", err: ErrInvalidAmountValue},
+		"too many digits":  {value: strings.Repeat("9", MaxAmountDigits+1), err: ErrAmountOverflow},
+		"max digits":       {value: strings.Repeat("9", MaxAmountDigits), result: strings.Repeat("9", MaxAmountDigits)},
+		"negative max":     {value: "-" + strings.Repeat("9", MaxAmountDigits), result: "-" + strings.Repeat("9", MaxAmountDigits)},
+		"double negative":  {value: "--1", err: ErrInvalidAmountValue},
+		"trailing garbage": {value: "100abc", err: ErrInvalidAmountValue},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			parsed, err := ParseAmountValue(test.value)
+			if test.err != nil {
+				assert.True(t, errors.Is(err, test.err))
+				assert.Nil(t, parsed)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.result, parsed.String())
+		})
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	btc := &rosetta.Currency{Symbol: "BTC", Decimals: 8}
+	var tests = map[string]struct {
+		value    string
+		currency *rosetta.Currency
+		result   string
+	}{
+		"fractional":     {value: "125000000", currency: btc, result: "1.25 BTC"},
+		"whole":          {value: "100000000", currency: btc, result: "1 BTC"},
+		"less than one":  {value: "1", currency: btc, result: "0.00000001 BTC"},
+		"zero":           {value: "0", currency: btc, result: "0 BTC"},
+		"negative":       {value: "-125000000", currency: btc, result: "-1.25 BTC"},
+		"no decimals":    {value: "100", currency: &rosetta.Currency{Symbol: "XRP"}, result: "100 XRP"},
+		"invalid amount": {value: "1.5", currency: btc, result: "1.5 BTC"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.result, FormatAmount(test.value, test.currency))
+		})
+	}
+}
+
+func TestUpdateBalanceAmounts(t *testing.T) {
+	ctx := context.Background()
+
+	newDir, err := CreateTempDir()
+	assert.NoError(t, err)
+	defer RemoveTempDir(*newDir)
+
+	database, err := NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	storage := NewBlockStorage(ctx, database, &GobCodec{}, &SHA256KeyHasher{})
+	account := &rosetta.AccountIdentifier{
+		Address: "amounts",
+	}
+	block := &rosetta.BlockIdentifier{
+		Hash:  "1",
+		Index: 1,
+	}
+	amount := func(value string) *rosetta.Amount {
+		return &rosetta.Amount{
+			Value: value,
+			Currency: &rosetta.Currency{
+				Symbol:   "BIG",
+				Decimals: 18,
+			},
+		}
+	}
+
+	txn := storage.NewDatabaseTransaction(ctx, true)
+	defer txn.Discard(ctx)
+
+	t.Run("Invalid amount", func(t *testing.T) {
+		err := storage.UpdateBalance(ctx, txn, account, amount("1e18"), block)
+
+		var amountErr *AmountError
+		assert.True(t, errors.As(err, &amountErr))
+		assert.Equal(t, InvalidAmountFinding, amountErr.Finding().Type)
+	})
+
+	t.Run("Large balances", func(t *testing.T) {
+		large := "1" + strings.Repeat("0", 30)
+		assert.NoError(t, storage.UpdateBalance(ctx, txn, account, amount(large), block))
+		assert.NoError(t, storage.UpdateBalance(ctx, txn, account, amount(large), block))
+
+		amounts, _, err := storage.GetBalance(ctx, txn, account)
+		assert.NoError(t, err)
+		assert.Equal(t, "2"+strings.Repeat("0", 30), amounts[GetCurrencyKey(amount(large).Currency)].Value)
+	})
+
+	t.Run("Balance overflow", func(t *testing.T) {
+		max := strings.Repeat("9", MaxAmountDigits)
+		err := storage.UpdateBalance(ctx, txn, account, amount(max), block)
+
+		var amountErr *AmountError
+		assert.True(t, errors.As(err, &amountErr))
+		assert.True(t, errors.Is(err, ErrAmountOverflow))
+		assert.Equal(t, AmountOverflowFinding, amountErr.Finding().Type)
+	})
+
+	t.Run("New currency for existing account", func(t *testing.T) {
+		other := &rosetta.Amount{
+			Value: "5",
+			Currency: &rosetta.Currency{
+				Symbol:   "OTHER",
+				Decimals: 0,
+			},
+		}
+		assert.NoError(t, storage.UpdateBalance(ctx, txn, account, other, block))
+
+		amounts, _, err := storage.GetBalance(ctx, txn, account)
+		assert.NoError(t, err)
+		assert.Len(t, amounts, 2)
+		assert.Equal(t, "5", amounts[GetCurrencyKey(other.Currency)].Value)
+	})
+}
@@ -16,10 +16,15 @@ package storage
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/dgraph-io/badger"
 )
 
+// gcDiscardRatio is the fraction of a value log file
+// that must be reclaimable for it to be rewritten.
+const gcDiscardRatio = 0.5
+
 // BadgerStorage is a wrapper around Badger DB
 // that implements the Database interface.
 type BadgerStorage struct {
@@ -38,12 +43,47 @@ func NewBadgerStorage(ctx context.Context, dir string) (Database, error) {
 	}, nil
 }
 
+// NewReadOnlyBadgerStorage opens an existing Badger DB in
+// dir without modifying it (ex: to inspect it). Unlike
+// NewBadgerStorage, it fails if dir does not exist.
+func NewReadOnlyBadgerStorage(ctx context.Context, dir string) (Database, error) {
+	opts := badger.DefaultOptions(dir)
+	opts.ReadOnly = true
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BadgerStorage{
+		db: db,
+	}, nil
+}
+
 // Close closes the database to prevent corruption.
 // The caller should defer this in main.
 func (b *BadgerStorage) Close(ctx context.Context) error {
 	return b.db.Close()
 }
 
+// GarbageCollect rewrites value log files until no
+// file has at least gcDiscardRatio of its space used by
+// deleted or overwritten values.
+func (b *BadgerStorage) GarbageCollect(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := b.db.RunValueLogGC(gcDiscardRatio)
+		if err == badger.ErrNoRewrite {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
 // BadgerTransaction is a wrapper around a Badger
 // DB transaction that implements the DatabaseTransaction
 // interface.
@@ -65,8 +105,15 @@ func (b *BadgerStorage) NewDatabaseTransaction(
 }
 
 // Commit attempts to commit and discard the transaction.
+// If a concurrent transaction modified a key read by the
+// transaction, ErrTransactionConflict is returned.
 func (b *BadgerTransaction) Commit(context.Context) error {
-	return b.txn.Commit()
+	err := b.txn.Commit()
+	if err == badger.ErrConflict {
+		return fmt.Errorf("%w: %v", ErrTransactionConflict, err)
+	}
+
+	return err
 }
 
 // Discard discards an open transaction. All transactions
@@ -81,7 +128,12 @@ func (b *BadgerTransaction) Set(
 	key []byte,
 	value []byte,
 ) error {
-	return b.txn.Set(key, value)
+	err := b.txn.Set(key, value)
+	if err == badger.ErrTxnTooBig {
+		return fmt.Errorf("%w: %v", ErrTransactionTooBig, err)
+	}
+
+	return err
 }
 
 // Get accesses the value of the key within a transaction.
@@ -109,9 +161,32 @@ func (b *BadgerTransaction) Get(
 	return true, value, nil
 }
 
+// ReadValue calls fn with the value of the key within a
+// transaction without copying it. The value is only valid
+// until fn returns.
+func (b *BadgerTransaction) ReadValue(
+	ctx context.Context,
+	key []byte,
+	fn func([]byte) error,
+) (bool, error) {
+	item, err := b.txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, item.Value(fn)
+}
+
 // Delete removes the key and its value within the transaction.
 func (b *BadgerTransaction) Delete(ctx context.Context, key []byte) error {
-	return b.txn.Delete(key)
+	err := b.txn.Delete(key)
+	if err == badger.ErrTxnTooBig {
+		return fmt.Errorf("%w: %v", ErrTransactionTooBig, err)
+	}
+
+	return err
 }
 
 // Set changes the value of the key to the value in its own transaction.
@@ -16,6 +16,10 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -52,6 +56,44 @@ func TestDatabase(t *testing.T) {
 	})
 }
 
+func TestReadOnlyDatabase(t *testing.T) {
+	ctx := context.Background()
+
+	newDir, err := CreateTempDir()
+	assert.NoError(t, err)
+	defer RemoveTempDir(*newDir)
+
+	t.Run("Missing directory", func(t *testing.T) {
+		missing := filepath.Join(*newDir, "missing")
+		database, err := NewReadOnlyBadgerStorage(ctx, missing)
+		assert.Error(t, err)
+		assert.Nil(t, database)
+
+		_, err = os.Stat(missing)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	database, err := NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	assert.NoError(t, database.Set(ctx, []byte("hello"), []byte("hola")))
+	assert.NoError(t, database.Close(ctx))
+
+	database, err = NewReadOnlyBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	t.Run("Get key", func(t *testing.T) {
+		exists, value, err := database.Get(ctx, []byte("hello"))
+		assert.True(t, exists)
+		assert.Equal(t, []byte("hola"), value)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Set key", func(t *testing.T) {
+		assert.Error(t, database.Set(ctx, []byte("hello"), []byte("hi")))
+	})
+}
+
 func TestDatabaseTransaction(t *testing.T) {
 	ctx := context.Background()
 
@@ -104,3 +146,69 @@ func TestDatabaseTransaction(t *testing.T) {
 		assert.NoError(t, err)
 	})
 }
+
+func TestUpdateConflictRetry(t *testing.T) {
+	ctx := context.Background()
+
+	newDir, err := CreateTempDir()
+	assert.NoError(t, err)
+	defer RemoveTempDir(*newDir)
+
+	database, err := NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	key := []byte("counter")
+	assert.NoError(t, database.Set(ctx, key, []byte("0")))
+
+	// increment reads the counter and, on the first
+	// attempts, commits a conflicting write before
+	// writing the incremented value.
+	attempts := 0
+	increment := func(conflicts int) func(DatabaseTransaction) error {
+		return func(txn DatabaseTransaction) error {
+			attempts++
+			_, value, err := txn.Get(ctx, key)
+			if err != nil {
+				return err
+			}
+
+			counter, err := strconv.Atoi(string(value))
+			if err != nil {
+				return err
+			}
+
+			if attempts <= conflicts {
+				if err := database.Set(ctx, key, []byte(strconv.Itoa(counter+10))); err != nil {
+					return err
+				}
+			}
+
+			return txn.Set(ctx, key, []byte(strconv.Itoa(counter+1)))
+		}
+	}
+
+	t.Run("Conflict detected", func(t *testing.T) {
+		txn := database.NewDatabaseTransaction(ctx, true)
+		assert.NoError(t, increment(1)(txn))
+		assert.True(t, errors.Is(txn.Commit(ctx), ErrTransactionConflict))
+	})
+
+	t.Run("Retry with fresh reads", func(t *testing.T) {
+		attempts = 0
+		assert.NoError(t, Update(ctx, database, increment(2)))
+		assert.Equal(t, 3, attempts)
+
+		// 10 (first test) + 10 + 10 + 1
+		_, value, err := database.Get(ctx, key)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("31"), value)
+	})
+
+	t.Run("Retries exhausted", func(t *testing.T) {
+		attempts = 0
+		err := Update(ctx, database, increment(maxConflictRetries+1))
+		assert.True(t, errors.Is(err, ErrTransactionConflict))
+		assert.Equal(t, maxConflictRetries+1, attempts)
+	})
+}
@@ -0,0 +1,148 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+// balanceHistoryNamespace is prepended to the balance key
+// of an account to construct the namespace of the stream
+// of its balances.
+const balanceHistoryNamespace = "balance-history"
+
+// ErrBalanceHistoryNotFound is returned when an account
+// has no recorded balance at or before a block (ex: the
+// account was first updated after the block or before
+// balance history was recorded).
+var ErrBalanceHistoryNotFound = errors.New("Balance history not found")
+
+func getBalanceHistoryNamespace(hasher KeyHasher, account *rosetta.AccountIdentifier) string {
+	return fmt.Sprintf("%s:%x", balanceHistoryNamespace, getBalanceKey(hasher, account))
+}
+
+// storeBalanceHistory appends the balances of an account
+// after an update to its balance history. When a block is
+// orphaned, its balance changes are reverted at its parent
+// block, so the entries after the parent block (those of
+// orphaned blocks) are removed first to keep entries sorted
+// by block index. An entry that can't be decoded is kept
+// (see LastIntactBalance).
+func (b *BlockStorage) storeBalanceHistory(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	account *rosetta.AccountIdentifier,
+	entry *balanceEntry,
+) error {
+	namespace := getBalanceHistoryNamespace(b.keyHasher, account)
+	length, err := b.streamLength(ctx, transaction, namespace)
+	if err != nil {
+		return err
+	}
+
+	orphaned := length
+	for ; orphaned > 0; orphaned-- {
+		exists, value, err := transaction.Get(ctx, getStreamEntryKey(b.keyHasher, namespace, orphaned-1))
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			return fmt.Errorf("balance history entry %d not found in %s", orphaned-1, namespace)
+		}
+
+		last, err := parseBalanceEntry(value)
+		if err != nil || last.Block == nil || last.Block.Index <= entry.Block.Index {
+			break
+		}
+	}
+
+	if err := b.truncateStream(ctx, transaction, namespace, orphaned); err != nil {
+		return err
+	}
+
+	return b.appendStream(ctx, transaction, namespace, entry)
+}
+
+// getBalanceHistoryEntry returns the entry at a
+// sequence number in a balance history.
+func (b *BlockStorage) getBalanceHistoryEntry(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	namespace string,
+	sequence int64,
+) (*balanceEntry, error) {
+	exists, value, err := transaction.Get(ctx, getStreamEntryKey(b.keyHasher, namespace, sequence))
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, fmt.Errorf("balance history entry %d not found in %s", sequence, namespace)
+	}
+
+	return parseBalanceEntry(value)
+}
+
+// GetBalanceAt returns all the balances of a
+// rosetta.AccountIdentifier as of the block at index and
+// the rosetta.BlockIdentifier they were last updated at.
+func (b *BlockStorage) GetBalanceAt(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	account *rosetta.AccountIdentifier,
+	index int64,
+) (map[string]*rosetta.Amount, *rosetta.BlockIdentifier, error) {
+	namespace := getBalanceHistoryNamespace(b.keyHasher, account)
+	length, err := b.streamLength(ctx, transaction, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Find the last entry at or before index. Several
+	// entries may exist for a single block, so the last
+	// one is the complete balance at that block.
+	var found *balanceEntry
+	low, high := int64(0), length-1
+	for low <= high {
+		mid := low + (high-low)/2
+		entry, err := b.getBalanceHistoryEntry(ctx, transaction, namespace, mid)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if entry.Block.Index <= index {
+			found = entry
+			low = mid + 1
+		} else {
+			high = mid - 1
+		}
+	}
+
+	if found == nil {
+		return nil, nil, fmt.Errorf(
+			"%w for %+v at block %d",
+			ErrBalanceHistoryNotFound,
+			account,
+			index,
+		)
+	}
+
+	return found.Amounts, found.Block, nil
+}
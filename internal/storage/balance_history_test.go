@@ -0,0 +1,144 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetBalanceAt(t *testing.T) {
+	ctx := context.Background()
+
+	newDir, err := CreateTempDir()
+	assert.NoError(t, err)
+	defer RemoveTempDir(*newDir)
+
+	database, err := NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	storage := NewBlockStorage(ctx, database, &GobCodec{}, &SHA256KeyHasher{})
+	account := &rosetta.AccountIdentifier{
+		Address: "history",
+	}
+	btc := &rosetta.Currency{
+		Symbol:   "BTC",
+		Decimals: 8,
+	}
+	eth := &rosetta.Currency{
+		Symbol:   "ETH",
+		Decimals: 18,
+	}
+	block1 := &rosetta.BlockIdentifier{
+		Hash:  "1",
+		Index: 1,
+	}
+	block3 := &rosetta.BlockIdentifier{
+		Hash:  "3",
+		Index: 3,
+	}
+	block3b := &rosetta.BlockIdentifier{
+		Hash:  "3b",
+		Index: 3,
+	}
+
+	update := func(currency *rosetta.Currency, value string, block *rosetta.BlockIdentifier) {
+		assert.NoError(t, storage.Update(ctx, func(txn DatabaseTransaction) error {
+			return storage.UpdateBalance(ctx, txn, account, &rosetta.Amount{
+				Value:    value,
+				Currency: currency,
+			}, block)
+		}))
+	}
+
+	balanceAt := func(index int64) (map[string]*rosetta.Amount, *rosetta.BlockIdentifier, error) {
+		txn := storage.NewDatabaseTransaction(ctx, false)
+		defer txn.Discard(ctx)
+
+		return storage.GetBalanceAt(ctx, txn, account, index)
+	}
+
+	update(btc, "10", block1)
+	update(btc, "5", block3)
+	update(eth, "7", block3)
+
+	// Orphan block 3 and replace it.
+	update(btc, "-5", block3)
+	update(eth, "-7", block3)
+	update(btc, "1", block3b)
+
+	t.Run("Before first update", func(t *testing.T) {
+		_, _, err := balanceAt(0)
+		assert.True(t, errors.Is(err, ErrBalanceHistoryNotFound))
+	})
+
+	t.Run("Between updates", func(t *testing.T) {
+		amounts, block, err := balanceAt(2)
+		assert.NoError(t, err)
+		assert.Equal(t, block1, block)
+		assert.Len(t, amounts, 1)
+		assert.Equal(t, "10", amounts[GetCurrencyKey(btc)].Value)
+	})
+
+	t.Run("After reorg", func(t *testing.T) {
+		for _, index := range []int64{3, 10} {
+			amounts, block, err := balanceAt(index)
+			assert.NoError(t, err)
+			assert.Equal(t, block3b, block)
+			assert.Equal(t, "11", amounts[GetCurrencyKey(btc)].Value)
+			assert.Equal(t, "0", amounts[GetCurrencyKey(eth)].Value)
+		}
+	})
+
+	t.Run("Reverted at parent", func(t *testing.T) {
+		reorged := &rosetta.AccountIdentifier{Address: "reorged"}
+		updateAt := func(value string, index int64, hash string) {
+			assert.NoError(t, storage.Update(ctx, func(txn DatabaseTransaction) error {
+				return storage.UpdateBalance(ctx, txn, reorged, &rosetta.Amount{
+					Value:    value,
+					Currency: btc,
+				}, &rosetta.BlockIdentifier{Hash: hash, Index: index})
+			}))
+		}
+
+		// Blocks 9 and 10 are orphaned (their changes are
+		// reverted at their parents) and replaced by 9'
+		// (which doesn't change the balance) and 10'.
+		updateAt("5", 5, "5")
+		updateAt("4", 9, "9")
+		updateAt("3", 10, "10")
+		updateAt("-3", 9, "9")
+		updateAt("-4", 8, "8")
+		updateAt("2", 10, "10'")
+
+		txn := storage.NewDatabaseTransaction(ctx, false)
+		defer txn.Discard(ctx)
+		for index, expected := range map[int64]string{5: "5", 8: "5", 9: "5", 10: "7"} {
+			amounts, _, err := storage.GetBalanceAt(ctx, txn, reorged, index)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, amounts[GetCurrencyKey(btc)].Value, "block %d", index)
+
+			amounts, _, err = storage.LastIntactBalance(ctx, txn, reorged, index)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, amounts[GetCurrencyKey(btc)].Value, "block %d", index)
+		}
+	})
+}
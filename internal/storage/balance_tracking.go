@@ -0,0 +1,84 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// balanceTrackingKey is used to lookup whether balances
+// were computed for the blocks stored in BlockStorage.
+const balanceTrackingKey = "balance-tracking"
+
+// ErrBalanceTrackingMismatch is returned when balances
+// are tracked for a Database populated without tracking
+// them (or vice versa).
+var ErrBalanceTrackingMismatch = errors.New("Balance tracking does not match stored data")
+
+func getBalanceTrackingKey(hasher KeyHasher) []byte {
+	return hasher.Hash([]byte(balanceTrackingKey))
+}
+
+// InitializeBalanceTracking records whether balances are
+// computed for the blocks stored in a new Database or
+// returns an error if the Database was populated with a
+// different setting. Stored balances are incomplete if
+// some blocks were stored without tracking balances.
+// Data stored before the setting was recorded is
+// assumed to have tracked balances.
+func (b *BlockStorage) InitializeBalanceTracking(
+	ctx context.Context,
+	tracked bool,
+) error {
+	return b.Update(ctx, func(transaction DatabaseTransaction) error {
+		exists, value, err := transaction.Get(ctx, getBalanceTrackingKey(b.keyHasher))
+		if err != nil {
+			return err
+		}
+
+		stored := tracked
+		if exists {
+			if err := decodeValue(value, &stored); err != nil {
+				return err
+			}
+		} else {
+			_, err := b.GetHeadBlockIdentifier(ctx, transaction)
+			switch {
+			case err == nil:
+				stored = true
+			case !errors.Is(err, ErrHeadBlockNotFound):
+				return err
+			}
+		}
+
+		switch {
+		case stored && !tracked:
+			return fmt.Errorf("%w: balances were tracked for stored blocks", ErrBalanceTrackingMismatch)
+		case !stored && tracked:
+			return fmt.Errorf("%w: balances were not tracked for stored blocks", ErrBalanceTrackingMismatch)
+		case exists:
+			return nil
+		}
+
+		buf, err := encodeValue(b.codec, tracked)
+		if err != nil {
+			return err
+		}
+
+		return transaction.Set(ctx, getBalanceTrackingKey(b.keyHasher), buf)
+	})
+}
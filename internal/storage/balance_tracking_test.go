@@ -0,0 +1,71 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitializeBalanceTracking(t *testing.T) {
+	ctx := context.Background()
+	newStorage := func() *BlockStorage {
+		return NewBlockStorage(ctx, NewMemoryStorage(), &GobCodec{}, &SHA256KeyHasher{})
+	}
+
+	storeHead := func(storage *BlockStorage) {
+		assert.NoError(t, storage.Update(ctx, func(tx DatabaseTransaction) error {
+			return storage.StoreHeadBlockIdentifier(ctx, tx, &rosetta.BlockIdentifier{
+				Hash:  "block 1",
+				Index: 1,
+			})
+		}))
+	}
+
+	t.Run("Tracked", func(t *testing.T) {
+		storage := newStorage()
+		assert.NoError(t, storage.InitializeBalanceTracking(ctx, true))
+		storeHead(storage)
+		assert.NoError(t, storage.InitializeBalanceTracking(ctx, true))
+
+		err := storage.InitializeBalanceTracking(ctx, false)
+		assert.True(t, errors.Is(err, ErrBalanceTrackingMismatch))
+	})
+
+	t.Run("Not tracked", func(t *testing.T) {
+		storage := newStorage()
+		assert.NoError(t, storage.InitializeBalanceTracking(ctx, false))
+		storeHead(storage)
+		assert.NoError(t, storage.InitializeBalanceTracking(ctx, false))
+
+		err := storage.InitializeBalanceTracking(ctx, true)
+		assert.True(t, errors.Is(err, ErrBalanceTrackingMismatch))
+		assert.Contains(t, err.Error(), "not tracked")
+	})
+
+	t.Run("Existing data is assumed to be tracked", func(t *testing.T) {
+		storage := newStorage()
+		storeHead(storage)
+
+		err := storage.InitializeBalanceTracking(ctx, false)
+		assert.True(t, errors.Is(err, ErrBalanceTrackingMismatch))
+		assert.NoError(t, storage.InitializeBalanceTracking(ctx, true))
+	})
+}
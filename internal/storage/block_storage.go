@@ -15,14 +15,14 @@
 package storage
 
 import (
-	"bytes"
 	"context"
 	"crypto/sha256"
-	"encoding/gob"
 	"errors"
 	"fmt"
 	"log"
 	"math/big"
+	"strconv"
+	"strings"
 
 	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
 
@@ -53,6 +53,10 @@ var (
 	// ErrDuplicateTransactionHash is returned when a transaction
 	// hash cannot be stored because it is a duplicate.
 	ErrDuplicateTransactionHash = errors.New("Duplicate transaction hash")
+
+	// ErrQueuedBlockNotFound is returned when there is no
+	// block queued for processing at an index.
+	ErrQueuedBlockNotFound = errors.New("Queued block not found")
 )
 
 const (
@@ -73,6 +77,10 @@ const (
 
 	// balanceNamespace is prepended to any stored balance.
 	balanceNamespace = "balance"
+
+	// blockQueueNamespace is prepended to the index of any
+	// block that was fetched but not yet processed.
+	blockQueueNamespace = "block-queue"
 )
 
 /*
@@ -99,33 +107,76 @@ func hashString(data string) string {
 	return fmt.Sprintf("%x", hashBytes([]byte(data)))
 }
 
-func getHeadBlockKey() []byte {
-	return hashBytes([]byte(headBlockKey))
+func getHeadBlockKey(hasher KeyHasher) []byte {
+	return hasher.Hash([]byte(headBlockKey))
 }
 
-func getBlockKey(blockIdentifier *rosetta.BlockIdentifier) []byte {
-	return hashBytes(
+func getBlockKey(hasher KeyHasher, blockIdentifier *rosetta.BlockIdentifier) []byte {
+	return hasher.Hash(
 		[]byte(fmt.Sprintf("%s:%d", blockIdentifier.Hash, blockIdentifier.Index)),
 	)
 }
 
-func getHashKey(hash string, isBlock bool) []byte {
+func getHashKey(hasher KeyHasher, hash string, isBlock bool) []byte {
 	if isBlock {
-		return hashBytes([]byte(fmt.Sprintf("%s:%s", blockHashNamespace, hash)))
+		return hasher.Hash([]byte(fmt.Sprintf("%s:%s", blockHashNamespace, hash)))
 	}
 
-	return hashBytes([]byte(fmt.Sprintf("%s:%s", transactionHashNamespace, hash)))
+	return hasher.Hash([]byte(fmt.Sprintf("%s:%s", transactionHashNamespace, hash)))
 }
 
-func getBalanceKey(account *rosetta.AccountIdentifier) []byte {
-	if account.SubAccount == nil {
-		return hashBytes(
-			[]byte(fmt.Sprintf("%s:%s", balanceNamespace, account.Address)),
-		)
+func getQueuedBlockKey(hasher KeyHasher, index int64) []byte {
+	return hasher.Hash([]byte(fmt.Sprintf("%s:%d", blockQueueNamespace, index)))
+}
+
+// accountKeyCacheKey identifies an account without
+// SubAccount.Metadata in the balanceKeys cache.
+type accountKeyCacheKey struct {
+	hasher     byte
+	address    string
+	subAccount string
+	hasSub     bool
+}
+
+// balanceKeys memoizes the balance keys of accounts
+// without SubAccount.Metadata (which is not comparable).
+var balanceKeys = newKeyCache(keyCacheSize)
+
+func getBalanceKey(hasher KeyHasher, account *rosetta.AccountIdentifier) []byte {
+	if account.SubAccount != nil && account.SubAccount.Metadata != nil {
+		// TODO: handle SubAccount.Metadata
+		// that contains pointer values.
+		return hasher.Hash([]byte(fmt.Sprintf(
+			"%s:%s:%s:%v",
+			balanceNamespace,
+			account.Address,
+			account.SubAccount.SubAccount,
+			*account.SubAccount.Metadata,
+		)))
 	}
 
-	if account.SubAccount.Metadata == nil {
-		return hashBytes([]byte(fmt.Sprintf(
+	cacheKey := accountKeyCacheKey{
+		hasher:  hasher.ID(),
+		address: account.Address,
+	}
+	if account.SubAccount != nil {
+		cacheKey.subAccount = account.SubAccount.SubAccount
+		cacheKey.hasSub = true
+	}
+
+	// Memoized keys are shared, so they must
+	// never be modified by callers.
+	if key, ok := balanceKeys.get(cacheKey); ok {
+		return key.([]byte)
+	}
+
+	var key []byte
+	if !cacheKey.hasSub {
+		key = hasher.Hash(
+			[]byte(fmt.Sprintf("%s:%s", balanceNamespace, account.Address)),
+		)
+	} else {
+		key = hasher.Hash([]byte(fmt.Sprintf(
 			"%s:%s:%s",
 			balanceNamespace,
 			account.Address,
@@ -133,30 +184,61 @@ func getBalanceKey(account *rosetta.AccountIdentifier) []byte {
 		)))
 	}
 
-	// TODO: handle SubAccount.Metadata
-	// that contains pointer values.
-	return hashBytes([]byte(fmt.Sprintf(
-		"%s:%s:%s:%v",
-		balanceNamespace,
-		account.Address,
-		account.SubAccount.SubAccount,
-		*account.SubAccount.Metadata,
-	)))
+	balanceKeys.add(cacheKey, key)
+	return key
 }
 
 // BlockStorage implements block specific storage methods
 // on top of a Database and DatabaseTransaction interface.
 type BlockStorage struct {
-	db Database
+	db        Database
+	codec     Codec
+	keyHasher KeyHasher
+
+	// streamRetention is the number of blocks below the
+	// latest entry of the block event, balance change, and
+	// finding streams for which entries are retained (0
+	// retains every entry).
+	streamRetention int64
 }
 
-// NewBlockStorage returns a new BlockStorage.
-func NewBlockStorage(ctx context.Context, db Database) *BlockStorage {
+// NewBlockStorage returns a new BlockStorage that
+// encodes new values with codec. Values encoded with
+// any other codec can still be read. Keys are derived
+// with keyHasher, which must match the KeyHasher used
+// to store any existing data (see InitializeKeySchema).
+func NewBlockStorage(
+	ctx context.Context,
+	db Database,
+	codec Codec,
+	keyHasher KeyHasher,
+) *BlockStorage {
 	return &BlockStorage{
-		db: db,
+		db:        newChunkedDatabase(db, keyHasher),
+		codec:     codec,
+		keyHasher: keyHasher,
 	}
 }
 
+// SetStreamRetention removes the entries of the streams read
+// by BlockEvents, BalanceChanges, and Findings recorded for
+// blocks more than depth blocks below the latest entry as
+// entries are appended (0 retains every entry). Recover only
+// reads the entries of the most recent blocks, so depth must
+// be at least the depth passed to Recover.
+func (b *BlockStorage) SetStreamRetention(depth int64) {
+	b.streamRetention = depth
+}
+
+// Update runs fn in a write transaction on the Database
+// backing BlockStorage, retrying on conflicts. See Update.
+func (b *BlockStorage) Update(
+	ctx context.Context,
+	fn func(DatabaseTransaction) error,
+) error {
+	return Update(ctx, b.db, fn)
+}
+
 // NewDatabaseTransaction returns a DatabaseTransaction
 // from the Database that is backing BlockStorage.
 func (b *BlockStorage) NewDatabaseTransaction(
@@ -172,7 +254,7 @@ func (b *BlockStorage) GetHeadBlockIdentifier(
 	ctx context.Context,
 	transaction DatabaseTransaction,
 ) (*rosetta.BlockIdentifier, error) {
-	exists, block, err := transaction.Get(ctx, getHeadBlockKey())
+	exists, block, err := transaction.Get(ctx, getHeadBlockKey(b.keyHasher))
 	if err != nil {
 		return nil, err
 	}
@@ -181,9 +263,8 @@ func (b *BlockStorage) GetHeadBlockIdentifier(
 		return nil, ErrHeadBlockNotFound
 	}
 
-	dec := gob.NewDecoder(bytes.NewReader(block))
 	var blockIdentifier rosetta.BlockIdentifier
-	err = dec.Decode(&blockIdentifier)
+	err = decodeValue(block, &blockIdentifier)
 	if err != nil {
 		return nil, err
 	}
@@ -198,13 +279,12 @@ func (b *BlockStorage) StoreHeadBlockIdentifier(
 	transaction DatabaseTransaction,
 	blockIdentifier *rosetta.BlockIdentifier,
 ) error {
-	buf := new(bytes.Buffer)
-	err := gob.NewEncoder(buf).Encode(blockIdentifier)
+	buf, err := encodeValue(b.codec, blockIdentifier)
 	if err != nil {
 		return err
 	}
 
-	return transaction.Set(ctx, getHeadBlockKey(), buf.Bytes())
+	return transaction.Set(ctx, getHeadBlockKey(b.keyHasher), buf)
 }
 
 // GetBlock returns a block, if it exists.
@@ -213,39 +293,148 @@ func (b *BlockStorage) GetBlock(
 	transaction DatabaseTransaction,
 	blockIdentifier *rosetta.BlockIdentifier,
 ) (*rosetta.Block, error) {
-	exists, block, err := transaction.Get(ctx, getBlockKey(blockIdentifier))
+	stored, err := b.getStoredBlock(ctx, transaction, blockIdentifier)
 	if err != nil {
 		return nil, err
 	}
 
-	if !exists {
-		return nil, fmt.Errorf("%w %+v", ErrBlockNotFound, blockIdentifier)
+	return stored.block(), nil
+}
+
+// FindBlock returns the block matching the index and/or
+// hash of identifier (or the head block if neither is set).
+// A block is found by hash using the block hash index and
+// by index by walking back from the head block. Orphaned
+// blocks are not found.
+func (b *BlockStorage) FindBlock(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	identifier *rosetta.PartialBlockIdentifier,
+) (*rosetta.Block, error) {
+	if identifier.Hash != nil {
+		blockIdentifier, err := b.blockIdentifierByHash(ctx, transaction, *identifier.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		if blockIdentifier != nil {
+			if identifier.Index != nil && *identifier.Index != blockIdentifier.Index {
+				return nil, fmt.Errorf(
+					"%w %s",
+					ErrBlockNotFound,
+					describePartialBlockIdentifier(identifier),
+				)
+			}
+
+			return b.GetBlock(ctx, transaction, blockIdentifier)
+		}
 	}
 
-	var rosettaBlock rosetta.Block
-	err = gob.NewDecoder(bytes.NewBuffer(block)).Decode(&rosettaBlock)
+	current, err := b.GetHeadBlockIdentifier(ctx, transaction)
 	if err != nil {
 		return nil, err
 	}
 
-	return &rosettaBlock, nil
+	for {
+		if identifier.Index != nil && current.Index < *identifier.Index {
+			break
+		}
+
+		block, err := b.GetBlock(ctx, transaction, current)
+		if errors.Is(err, ErrBlockNotFound) {
+			// Older blocks are not stored (ex: when
+			// validation started after genesis).
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if (identifier.Index == nil || current.Index == *identifier.Index) &&
+			(identifier.Hash == nil || current.Hash == *identifier.Hash) {
+			return block, nil
+		}
+
+		if block.ParentBlockIdentifier.Index == current.Index {
+			break
+		}
+
+		current = block.ParentBlockIdentifier
+	}
+
+	return nil, fmt.Errorf("%w %s", ErrBlockNotFound, describePartialBlockIdentifier(identifier))
+}
+
+// blockIdentifierByHash returns the identifier of the stored
+// block with hash using the block hash index. It returns nil
+// if the index of the block was not recorded (blocks stored
+// before it was) and ErrBlockNotFound if no block with hash
+// is stored.
+func (b *BlockStorage) blockIdentifierByHash(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	hash string,
+) (*rosetta.BlockIdentifier, error) {
+	exists, value, err := transaction.Get(ctx, getHashKey(b.keyHasher, hash, true))
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, fmt.Errorf("%w hash %s", ErrBlockNotFound, hash)
+	}
+
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	index, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid index of block hash %s", err, hash)
+	}
+
+	return &rosetta.BlockIdentifier{
+		Hash:  hash,
+		Index: index,
+	}, nil
+}
+
+// describePartialBlockIdentifier describes identifier
+// in errors (its fields are pointers).
+func describePartialBlockIdentifier(identifier *rosetta.PartialBlockIdentifier) string {
+	parts := []string{}
+	if identifier.Index != nil {
+		parts = append(parts, fmt.Sprintf("index %d", *identifier.Index))
+	}
+
+	if identifier.Hash != nil {
+		parts = append(parts, fmt.Sprintf("hash %s", *identifier.Hash))
+	}
+
+	if len(parts) == 0 {
+		return "head"
+	}
+
+	return strings.Join(parts, " ")
 }
 
-// storeHash stores either a block or transaction hash.
+// storeHash stores either a block or transaction hash
+// with value (ex: the index of a block).
 func (b *BlockStorage) storeHash(
 	ctx context.Context,
 	transaction DatabaseTransaction,
 	hash string,
 	isBlock bool,
+	value []byte,
 ) error {
-	key := getHashKey(hash, isBlock)
+	key := getHashKey(b.keyHasher, hash, isBlock)
 	exists, _, err := transaction.Get(ctx, key)
 	if err != nil {
 		return err
 	}
 
 	if !exists {
-		return transaction.Set(ctx, key, []byte(""))
+		return transaction.Set(ctx, key, value)
 	}
 
 	if isBlock {
@@ -263,47 +452,76 @@ func (b *BlockStorage) storeHash(
 	)
 }
 
+// HasTransaction returns true if a transaction with
+// hash is in a stored block (orphaned blocks are not
+// stored).
+func (b *BlockStorage) HasTransaction(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	hash string,
+) (bool, error) {
+	exists, _, err := transaction.Get(ctx, getHashKey(b.keyHasher, hash, false))
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
 // StoreBlock stores a block or returns an error.
 // StoreBlock also stores the block hash and all
-// its transaction hashes for duplicate detection.
+// its transaction hashes for duplicate detection
+// and appends a BlockEvent to the block stream.
 func (b *BlockStorage) StoreBlock(
 	ctx context.Context,
 	transaction DatabaseTransaction,
 	block *rosetta.Block,
 ) error {
-	buf := new(bytes.Buffer)
-	err := gob.NewEncoder(buf).Encode(block)
+	buf, err := encodeValue(b.codec, block)
 	if err != nil {
 		return err
 	}
 
 	// Store block
-	err = transaction.Set(ctx, getBlockKey(block.BlockIdentifier), buf.Bytes())
+	err = transaction.Set(ctx, getBlockKey(b.keyHasher, block.BlockIdentifier), buf)
 	if err != nil {
 		return err
 	}
 
 	// Store block hash
-	err = b.storeHash(ctx, transaction, block.BlockIdentifier.Hash, true)
+	err = b.storeHash(
+		ctx,
+		transaction,
+		block.BlockIdentifier.Hash,
+		true,
+		[]byte(strconv.FormatInt(block.BlockIdentifier.Index, 10)),
+	)
 	if err != nil {
 		return err
 	}
 
 	// Store all transaction hashes
 	for _, txn := range block.Transactions {
-		err = b.storeHash(ctx, transaction, txn.TransactionIdentifier.Hash, false)
+		err = b.storeHash(ctx, transaction, txn.TransactionIdentifier.Hash, false, []byte(""))
 		if err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return b.appendAnalyticsStream(
+		ctx,
+		transaction,
+		blockStreamNamespace,
+		block.BlockIdentifier.Index,
+		&BlockEvent{Block: block.BlockIdentifier},
+	)
 }
 
 // RemoveBlock removes a block or returns an error.
 // RemoveBlock also removes the block hash and all
 // its transaction hashes to not break duplicate
-// detection. This is called within a re-org.
+// detection. This is called within a re-org and
+// appends an orphaned BlockEvent to the block stream.
 func (b *BlockStorage) RemoveBlock(
 	ctx context.Context,
 	transaction DatabaseTransaction,
@@ -312,41 +530,177 @@ func (b *BlockStorage) RemoveBlock(
 	// Remove all transaction hashes
 	blockData, err := b.GetBlock(ctx, transaction, block)
 	for _, txn := range blockData.Transactions {
-		err = transaction.Delete(ctx, getHashKey(txn.TransactionIdentifier.Hash, false))
+		err = transaction.Delete(ctx, getHashKey(b.keyHasher, txn.TransactionIdentifier.Hash, false))
 		if err != nil {
 			return err
 		}
 	}
 
 	// Remove block hash
-	err = transaction.Delete(ctx, getHashKey(block.Hash, true))
+	err = transaction.Delete(ctx, getHashKey(b.keyHasher, block.Hash, true))
 	if err != nil {
 		return err
 	}
 
 	// Remove block
-	return transaction.Delete(ctx, getBlockKey(block))
+	err = transaction.Delete(ctx, getBlockKey(b.keyHasher, block))
+	if err != nil {
+		return err
+	}
+
+	return b.appendAnalyticsStream(
+		ctx,
+		transaction,
+		blockStreamNamespace,
+		block.Index,
+		&BlockEvent{Block: block, Orphaned: true},
+	)
 }
 
-type balanceEntry struct {
-	Amounts map[string]*rosetta.Amount
-	Block   *rosetta.BlockIdentifier
+// CreateBlockCache returns the identifiers of up to limit of
+// the most recently stored blocks (oldest first) by walking
+// back from the head block (see PastBlocks). If no head block
+// exists, an empty slice is returned.
+func (b *BlockStorage) CreateBlockCache(
+	ctx context.Context,
+	limit int,
+) ([]*rosetta.BlockIdentifier, error) {
+	transaction := b.db.NewDatabaseTransaction(ctx, false)
+	defer transaction.Discard(ctx)
+
+	head, err := b.GetHeadBlockIdentifier(ctx, transaction)
+	if errors.Is(err, ErrHeadBlockNotFound) {
+		return []*rosetta.BlockIdentifier{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return b.pastBlocks(ctx, transaction, head, limit)
+}
+
+// PastBlocks returns the identifiers of up to limit stored
+// blocks (oldest first) ending with blockIdentifier by walking
+// back through their parents. The parent of the earliest stored
+// block is included so that a reorg of that block can be
+// detected (ex: the genesis block is never stored).
+func (b *BlockStorage) PastBlocks(
+	ctx context.Context,
+	blockIdentifier *rosetta.BlockIdentifier,
+	limit int,
+) ([]*rosetta.BlockIdentifier, error) {
+	transaction := b.db.NewDatabaseTransaction(ctx, false)
+	defer transaction.Discard(ctx)
+
+	return b.pastBlocks(ctx, transaction, blockIdentifier, limit)
+}
+
+func (b *BlockStorage) pastBlocks(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	blockIdentifier *rosetta.BlockIdentifier,
+	limit int,
+) ([]*rosetta.BlockIdentifier, error) {
+	cache := []*rosetta.BlockIdentifier{}
+	current := blockIdentifier
+	for len(cache) < limit {
+		cache = append(cache, current)
+
+		block, err := b.GetBlock(ctx, transaction, current)
+		if errors.Is(err, ErrBlockNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if block.ParentBlockIdentifier.Index == current.Index {
+			break
+		}
+
+		current = block.ParentBlockIdentifier
+	}
+
+	for i, j := 0, len(cache)-1; i < j; i, j = i+1, j-1 {
+		cache[i], cache[j] = cache[j], cache[i]
+	}
+
+	return cache, nil
+}
+
+// QueueBlock durably stores a fetched block so that it
+// can be processed after a restart without fetching it
+// again. Only one block can be queued at each index.
+func (b *BlockStorage) QueueBlock(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	block *rosetta.Block,
+) error {
+	buf, err := encodeValue(b.codec, block)
+	if err != nil {
+		return err
+	}
+
+	return transaction.Set(ctx, getQueuedBlockKey(b.keyHasher, block.BlockIdentifier.Index), buf)
 }
 
-func serializeBalanceEntry(bal balanceEntry) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	err := gob.NewEncoder(buf).Encode(bal)
+// GetQueuedBlock returns the block queued at an index,
+// if it exists.
+func (b *BlockStorage) GetQueuedBlock(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	index int64,
+) (*rosetta.Block, error) {
+	exists, block, err := transaction.Get(ctx, getQueuedBlockKey(b.keyHasher, index))
 	if err != nil {
 		return nil, err
 	}
 
-	return buf.Bytes(), nil
+	if !exists {
+		return nil, fmt.Errorf("%w %d", ErrQueuedBlockNotFound, index)
+	}
+
+	var rosettaBlock rosetta.Block
+	err = decodeValue(block, &rosettaBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rosettaBlock, nil
+}
+
+// DequeueBlock removes the block queued at an index. It
+// is not an error if no block is queued at the index.
+func (b *BlockStorage) DequeueBlock(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	index int64,
+) error {
+	return transaction.Delete(ctx, getQueuedBlockKey(b.keyHasher, index))
+}
+
+// BalanceChange represents a balance change that
+// affected an *rosetta.AccountIdentifier and a
+// *rosetta.Currency at a block.
+type BalanceChange struct {
+	Account    *rosetta.AccountIdentifier
+	Currency   *rosetta.Currency
+	Block      *rosetta.BlockIdentifier
+	Difference string
+}
+
+type balanceEntry struct {
+	Amounts map[string]*rosetta.Amount
+	Block   *rosetta.BlockIdentifier
+}
+
+func serializeBalanceEntry(codec Codec, bal balanceEntry) ([]byte, error) {
+	return encodeValue(codec, bal)
 }
 
 func parseBalanceEntry(buf []byte) (*balanceEntry, error) {
-	dec := gob.NewDecoder(bytes.NewReader(buf))
 	var bal balanceEntry
-	err := dec.Decode(&bal)
+	err := decodeValue(buf, &bal)
 	if err != nil {
 		return nil, err
 	}
@@ -354,6 +708,17 @@ func parseBalanceEntry(buf []byte) (*balanceEntry, error) {
 	return &bal, nil
 }
 
+// currencyKeyCacheKey identifies a currency without
+// Metadata in the currencyKeys cache.
+type currencyKeyCacheKey struct {
+	symbol   string
+	decimals int32
+}
+
+// currencyKeys memoizes the keys of currencies
+// without Metadata (which is not comparable).
+var currencyKeys = newKeyCache(keyCacheSize)
+
 // GetCurrencyKey is used to identify a *rosetta.Currency
 // in an account's map of currencies. It is not feasible
 // to create a map of [rosetta.Currency]*rosetta.Amount
@@ -361,9 +726,19 @@ func parseBalanceEntry(buf []byte) (*balanceEntry, error) {
 // that would prevent any equality.
 func GetCurrencyKey(currency *rosetta.Currency) string {
 	if currency.Metadata == nil {
-		return hashString(
+		cacheKey := currencyKeyCacheKey{
+			symbol:   currency.Symbol,
+			decimals: currency.Decimals,
+		}
+		if key, ok := currencyKeys.get(cacheKey); ok {
+			return key.(string)
+		}
+
+		key := hashString(
 			fmt.Sprintf("%s:%d", currency.Symbol, currency.Decimals),
 		)
+		currencyKeys.add(cacheKey, key)
+		return key
 	}
 
 	// TODO: Handle currency.Metadata
@@ -378,9 +753,17 @@ func GetCurrencyKey(currency *rosetta.Currency) string {
 	)
 }
 
+// GetAccountKey is used to identify a *rosetta.AccountIdentifier
+// in a map because it contains a metadata pointer that
+// would prevent any equality.
+func GetAccountKey(account *rosetta.AccountIdentifier) string {
+	return fmt.Sprintf("%x", getBalanceKey(&SHA256KeyHasher{}, account))
+}
+
 // UpdateBalance updates a rosetta.AccountIdentifer
-// by a rosetta.Amount and sets the account's most
-// recent accessed block.
+// by a rosetta.Amount, sets the account's most
+// recent accessed block, and records the updated
+// balances in the account's balance history.
 func (b *BlockStorage) UpdateBalance(
 	ctx context.Context,
 	transaction DatabaseTransaction,
@@ -392,84 +775,86 @@ func (b *BlockStorage) UpdateBalance(
 		return errors.New("invalid amount")
 	}
 
-	key := getBalanceKey(account)
-	// Get existing balance on key
-	exists, balance, err := transaction.Get(ctx, key)
+	modification, err := ParseAmountValue(amount.Value)
 	if err != nil {
-		return err
-	}
-
-	currencyKey := GetCurrencyKey(amount.Currency)
-
-	if !exists {
-		amountMap := make(map[string]*rosetta.Amount)
-		newVal, ok := new(big.Int).SetString(amount.Value, 10)
-		if !ok {
-			return fmt.Errorf("%s is not an integer", amount.Value)
-		}
-		if newVal.Sign() == -1 {
-			return fmt.Errorf(
-				"%w %+v for %+v at %+v",
-				ErrNegativeBalance,
-				spew.Sdump(amount),
-				account,
-				block,
-			)
-		}
-		amountMap[currencyKey] = amount
-
-		serialBal, err := serializeBalanceEntry(balanceEntry{
-			Amounts: amountMap,
-			Block:   block,
-		})
-		if err != nil {
-			return err
+		return &AmountError{
+			Account:  account,
+			Currency: amount.Currency,
+			Block:    block,
+			Value:    amount.Value,
+			Err:      err,
 		}
-
-		return transaction.Set(ctx, key, serialBal)
 	}
 
-	// Modify balance
-	parseBal, err := parseBalanceEntry(balance)
+	key := getBalanceKey(b.keyHasher, account)
+	// Get existing balance on key
+	exists, balance, err := transaction.Get(ctx, key)
 	if err != nil {
 		return err
 	}
 
-	val, ok := parseBal.Amounts[currencyKey]
-	if !ok {
-		parseBal.Amounts[currencyKey] = amount
+	entry := &balanceEntry{
+		Amounts: make(map[string]*rosetta.Amount),
 	}
-
-	modification, ok := new(big.Int).SetString(amount.Value, 10)
-	if !ok {
-		return fmt.Errorf("%s is not an integer", amount.Value)
+	if exists {
+		entry, err = parseBalanceEntry(balance)
+		if err != nil {
+			return &CorruptionError{Account: account, Err: err}
+		}
 	}
 
-	existing, ok := new(big.Int).SetString(val.Value, 10)
-	if !ok {
-		return fmt.Errorf("%s is not an integer", val.Value)
+	currencyKey := GetCurrencyKey(amount.Currency)
+	existing := new(big.Int)
+	if val, ok := entry.Amounts[currencyKey]; ok {
+		existing, err = ParseAmountValue(val.Value)
+		if err != nil {
+			return &AmountError{
+				Account:  account,
+				Currency: amount.Currency,
+				Block:    entry.Block,
+				Value:    val.Value,
+				Err:      err,
+			}
+		}
 	}
 
 	newVal := new(big.Int).Add(existing, modification)
-	val.Value = newVal.String()
+	newAmount := &rosetta.Amount{
+		Value:    newVal.String(),
+		Currency: amount.Currency,
+	}
+	if err := checkAmountSize(newVal); err != nil {
+		return &AmountError{
+			Account:  account,
+			Currency: amount.Currency,
+			Block:    block,
+			Value:    newAmount.Value,
+			Err:      err,
+		}
+	}
+
 	if newVal.Sign() == -1 {
 		return fmt.Errorf(
 			"%w %+v for %+v at %+v",
 			ErrNegativeBalance,
-			spew.Sdump(val),
+			spew.Sdump(newAmount),
 			account,
 			block,
 		)
 	}
 
-	parseBal.Amounts[currencyKey] = val
-
-	parseBal.Block = block
-	serialBal, err := serializeBalanceEntry(*parseBal)
+	entry.Amounts[currencyKey] = newAmount
+	entry.Block = block
+	serialBal, err := serializeBalanceEntry(b.codec, *entry)
 	if err != nil {
 		return err
 	}
-	return transaction.Set(ctx, key, serialBal)
+
+	if err := transaction.Set(ctx, key, serialBal); err != nil {
+		return err
+	}
+
+	return b.storeBalanceHistory(ctx, transaction, account, entry)
 }
 
 // GetBalance returns all the balances of a rosetta.AccountIdentifier
@@ -479,7 +864,7 @@ func (b *BlockStorage) GetBalance(
 	transaction DatabaseTransaction,
 	account *rosetta.AccountIdentifier,
 ) (map[string]*rosetta.Amount, *rosetta.BlockIdentifier, error) {
-	key := getBalanceKey(account)
+	key := getBalanceKey(b.keyHasher, account)
 	exists, bal, err := transaction.Get(ctx, key)
 	if err != nil {
 		return nil, nil, err
@@ -491,7 +876,7 @@ func (b *BlockStorage) GetBalance(
 
 	deserialBal, err := parseBalanceEntry(bal)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, &CorruptionError{Account: account, Err: err}
 	}
 
 	return deserialBal.Amounts, deserialBal.Block, nil
@@ -16,9 +16,12 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"testing"
 
+	"github.com/coinbase/rosetta-validator/internal/generator"
+
 	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
 
 	"github.com/stretchr/testify/assert"
@@ -46,7 +49,7 @@ func TestHeadBlockIdentifier(t *testing.T) {
 	assert.NoError(t, err)
 	defer database.Close(ctx)
 
-	storage := NewBlockStorage(ctx, database)
+	storage := NewBlockStorage(ctx, database, &GobCodec{}, &SHA256KeyHasher{})
 
 	t.Run("No head block set", func(t *testing.T) {
 		txn := storage.NewDatabaseTransaction(ctx, false)
@@ -159,7 +162,7 @@ func TestBlock(t *testing.T) {
 	assert.NoError(t, err)
 	defer database.Close(ctx)
 
-	storage := NewBlockStorage(ctx, database)
+	storage := NewBlockStorage(ctx, database, &GobCodec{}, &SHA256KeyHasher{})
 
 	t.Run("Set and get block", func(t *testing.T) {
 		txn := storage.NewDatabaseTransaction(ctx, true)
@@ -171,6 +174,16 @@ func TestBlock(t *testing.T) {
 		txn.Discard(ctx)
 		assert.NoError(t, err)
 		assert.Equal(t, newBlock, block)
+
+		txn = storage.NewDatabaseTransaction(ctx, false)
+		included, err := storage.HasTransaction(ctx, txn, "blahTx")
+		assert.NoError(t, err)
+		assert.True(t, included)
+
+		included, err = storage.HasTransaction(ctx, txn, "blah")
+		assert.NoError(t, err)
+		assert.False(t, included)
+		txn.Discard(ctx)
 	})
 
 	t.Run("Get non-existent block", func(t *testing.T) {
@@ -208,12 +221,290 @@ func TestBlock(t *testing.T) {
 		assert.NoError(t, storage.RemoveBlock(ctx, txn, newBlock.BlockIdentifier))
 		assert.NoError(t, txn.Commit(ctx))
 
+		txn = storage.NewDatabaseTransaction(ctx, false)
+		included, err := storage.HasTransaction(ctx, txn, "blahTx")
+		assert.NoError(t, err)
+		assert.False(t, included)
+		txn.Discard(ctx)
+
 		txn = storage.NewDatabaseTransaction(ctx, true)
 		assert.NoError(t, storage.StoreBlock(ctx, txn, newBlock))
 		assert.NoError(t, txn.Commit(ctx))
 	})
 }
 
+func TestQueuedBlock(t *testing.T) {
+	var (
+		newBlock = &rosetta.Block{
+			BlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "blah",
+				Index: 10,
+			},
+			ParentBlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "blah parent",
+				Index: 9,
+			},
+			Timestamp: 1,
+		}
+	)
+	ctx := context.Background()
+
+	newDir, err := CreateTempDir()
+	assert.NoError(t, err)
+	defer RemoveTempDir(*newDir)
+
+	database, err := NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	storage := NewBlockStorage(ctx, database, &GobCodec{}, &SHA256KeyHasher{})
+
+	t.Run("No block queued", func(t *testing.T) {
+		txn := storage.NewDatabaseTransaction(ctx, false)
+		block, err := storage.GetQueuedBlock(ctx, txn, 10)
+		txn.Discard(ctx)
+		assert.EqualError(t, err, fmt.Errorf("%w %d", ErrQueuedBlockNotFound, 10).Error())
+		assert.Nil(t, block)
+	})
+
+	t.Run("Queue and get block", func(t *testing.T) {
+		txn := storage.NewDatabaseTransaction(ctx, true)
+		assert.NoError(t, storage.QueueBlock(ctx, txn, newBlock))
+		assert.NoError(t, txn.Commit(ctx))
+
+		txn = storage.NewDatabaseTransaction(ctx, false)
+		block, err := storage.GetQueuedBlock(ctx, txn, 10)
+		txn.Discard(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, newBlock, block)
+	})
+
+	t.Run("Dequeue block", func(t *testing.T) {
+		txn := storage.NewDatabaseTransaction(ctx, true)
+		assert.NoError(t, storage.DequeueBlock(ctx, txn, 10))
+		assert.NoError(t, txn.Commit(ctx))
+
+		txn = storage.NewDatabaseTransaction(ctx, false)
+		block, err := storage.GetQueuedBlock(ctx, txn, 10)
+		txn.Discard(ctx)
+		assert.True(t, errors.Is(err, ErrQueuedBlockNotFound))
+		assert.Nil(t, block)
+	})
+}
+
+func TestCreateBlockCache(t *testing.T) {
+	var (
+		genesis = &rosetta.BlockIdentifier{
+			Hash:  "0",
+			Index: 0,
+		}
+		block1 = &rosetta.Block{
+			BlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "1",
+				Index: 1,
+			},
+			ParentBlockIdentifier: genesis,
+		}
+		block2 = &rosetta.Block{
+			BlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "2",
+				Index: 2,
+			},
+			ParentBlockIdentifier: block1.BlockIdentifier,
+		}
+	)
+	ctx := context.Background()
+
+	newDir, err := CreateTempDir()
+	assert.NoError(t, err)
+	defer RemoveTempDir(*newDir)
+
+	database, err := NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	storage := NewBlockStorage(ctx, database, &GobCodec{}, &SHA256KeyHasher{})
+
+	t.Run("No head block", func(t *testing.T) {
+		cache, err := storage.CreateBlockCache(ctx, 10)
+		assert.NoError(t, err)
+		assert.Len(t, cache, 0)
+	})
+
+	txn := storage.NewDatabaseTransaction(ctx, true)
+	assert.NoError(t, storage.StoreBlock(ctx, txn, block1))
+	assert.NoError(t, storage.StoreBlock(ctx, txn, block2))
+	assert.NoError(t, storage.StoreHeadBlockIdentifier(ctx, txn, block2.BlockIdentifier))
+	assert.NoError(t, txn.Commit(ctx))
+
+	t.Run("Includes parent of earliest stored block", func(t *testing.T) {
+		cache, err := storage.CreateBlockCache(ctx, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, []*rosetta.BlockIdentifier{
+			genesis,
+			block1.BlockIdentifier,
+			block2.BlockIdentifier,
+		}, cache)
+	})
+
+	t.Run("Limited", func(t *testing.T) {
+		cache, err := storage.CreateBlockCache(ctx, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, []*rosetta.BlockIdentifier{
+			block1.BlockIdentifier,
+			block2.BlockIdentifier,
+		}, cache)
+	})
+
+	t.Run("Past blocks of block below head", func(t *testing.T) {
+		cache, err := storage.PastBlocks(ctx, block1.BlockIdentifier, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, []*rosetta.BlockIdentifier{
+			genesis,
+			block1.BlockIdentifier,
+		}, cache)
+	})
+}
+
+func TestFindBlock(t *testing.T) {
+	var (
+		genesis = &rosetta.BlockIdentifier{
+			Hash:  "0",
+			Index: 0,
+		}
+		block1 = &rosetta.Block{
+			BlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "1",
+				Index: 1,
+			},
+			ParentBlockIdentifier: genesis,
+		}
+		block2 = &rosetta.Block{
+			BlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "2",
+				Index: 2,
+			},
+			ParentBlockIdentifier: block1.BlockIdentifier,
+		}
+		index1 = int64(1)
+		index3 = int64(3)
+		hash1  = "1"
+		hash2  = "2"
+	)
+	ctx := context.Background()
+
+	newDir, err := CreateTempDir()
+	assert.NoError(t, err)
+	defer RemoveTempDir(*newDir)
+
+	database, err := NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	storage := NewBlockStorage(ctx, database, &GobCodec{}, &SHA256KeyHasher{})
+
+	t.Run("No head block", func(t *testing.T) {
+		txn := storage.NewDatabaseTransaction(ctx, false)
+		defer txn.Discard(ctx)
+
+		block, err := storage.FindBlock(ctx, txn, &rosetta.PartialBlockIdentifier{})
+		assert.True(t, errors.Is(err, ErrHeadBlockNotFound))
+		assert.Nil(t, block)
+	})
+
+	txn := storage.NewDatabaseTransaction(ctx, true)
+	assert.NoError(t, storage.StoreBlock(ctx, txn, block1))
+	assert.NoError(t, storage.StoreBlock(ctx, txn, block2))
+	assert.NoError(t, storage.StoreHeadBlockIdentifier(ctx, txn, block2.BlockIdentifier))
+	assert.NoError(t, txn.Commit(ctx))
+
+	var tests = map[string]struct {
+		identifier *rosetta.PartialBlockIdentifier
+
+		block *rosetta.Block
+		err   error
+	}{
+		"head": {
+			identifier: &rosetta.PartialBlockIdentifier{},
+			block:      block2,
+		},
+		"by index": {
+			identifier: &rosetta.PartialBlockIdentifier{Index: &index1},
+			block:      block1,
+		},
+		"by hash": {
+			identifier: &rosetta.PartialBlockIdentifier{Hash: &hash2},
+			block:      block2,
+		},
+		"by index and hash": {
+			identifier: &rosetta.PartialBlockIdentifier{Index: &index1, Hash: &hash1},
+			block:      block1,
+		},
+		"index and hash mismatch": {
+			identifier: &rosetta.PartialBlockIdentifier{Index: &index1, Hash: &hash2},
+			err:        ErrBlockNotFound,
+		},
+		"index after head": {
+			identifier: &rosetta.PartialBlockIdentifier{Index: &index3},
+			err:        ErrBlockNotFound,
+		},
+		"not stored": {
+			identifier: &rosetta.PartialBlockIdentifier{Hash: &genesis.Hash},
+			err:        ErrBlockNotFound,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			txn := storage.NewDatabaseTransaction(ctx, false)
+			defer txn.Discard(ctx)
+
+			block, err := storage.FindBlock(ctx, txn, test.identifier)
+			if test.err != nil {
+				assert.True(t, errors.Is(err, test.err))
+				assert.Nil(t, block)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.block, block)
+		})
+	}
+
+	t.Run("Hash stored without index", func(t *testing.T) {
+		txn := storage.NewDatabaseTransaction(ctx, true)
+		assert.NoError(t, txn.Set(ctx, getHashKey(storage.keyHasher, hash1, true), []byte("")))
+		assert.NoError(t, txn.Commit(ctx))
+
+		txn = storage.NewDatabaseTransaction(ctx, false)
+		defer txn.Discard(ctx)
+
+		block, err := storage.FindBlock(ctx, txn, &rosetta.PartialBlockIdentifier{Hash: &hash1})
+		assert.NoError(t, err)
+		assert.Equal(t, block1, block)
+	})
+
+	t.Run("By hash without walking from head", func(t *testing.T) {
+		txn := storage.NewDatabaseTransaction(ctx, true)
+		assert.NoError(t, storage.StoreBlock(ctx, txn, &rosetta.Block{
+			BlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "1a",
+				Index: 1,
+			},
+			ParentBlockIdentifier: genesis,
+		}))
+		assert.NoError(t, txn.Commit(ctx))
+
+		hash := "1a"
+		txn = storage.NewDatabaseTransaction(ctx, false)
+		defer txn.Discard(ctx)
+
+		block, err := storage.FindBlock(ctx, txn, &rosetta.PartialBlockIdentifier{Hash: &hash})
+		assert.NoError(t, err)
+		assert.Equal(t, hash, block.BlockIdentifier.Hash)
+	})
+}
+
 func TestGetBalanceKey(t *testing.T) {
 	var tests = map[string]struct {
 		account *rosetta.AccountIdentifier
@@ -275,7 +566,7 @@ func TestGetBalanceKey(t *testing.T) {
 
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
-			assert.Equal(t, hashBytes([]byte(test.key)), getBalanceKey(test.account))
+			assert.Equal(t, hashBytes([]byte(test.key)), getBalanceKey(&SHA256KeyHasher{}, test.account))
 		})
 	}
 }
@@ -384,7 +675,7 @@ func TestBalance(t *testing.T) {
 	assert.NoError(t, err)
 	defer database.Close(ctx)
 
-	storage := NewBlockStorage(ctx, database)
+	storage := NewBlockStorage(ctx, database, &GobCodec{}, &SHA256KeyHasher{})
 
 	t.Run("Get unset balance", func(t *testing.T) {
 		txn := storage.NewDatabaseTransaction(ctx, false)
@@ -615,3 +906,101 @@ func TestGetCurrencyKey(t *testing.T) {
 		})
 	}
 }
+
+func BenchmarkStoreBlock(b *testing.B) {
+	ctx := context.Background()
+	g := generator.New(generator.Config{
+		Height:                   int64(b.N),
+		TransactionsPerBlock:     10,
+		OperationsPerTransaction: 2,
+		Accounts:                 1000,
+	})
+
+	for name, codec := range codecs {
+		b.Run(name, func(b *testing.B) {
+			newDir, err := CreateTempDir()
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer RemoveTempDir(*newDir)
+
+			database, err := NewBadgerStorage(ctx, *newDir)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer database.Close(ctx)
+
+			storage := NewBlockStorage(ctx, database, codec, &SHA256KeyHasher{})
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 1; i <= b.N; i++ {
+				block := g.Block(int64(i))
+				err := storage.Update(ctx, func(txn DatabaseTransaction) error {
+					if err := storage.StoreBlock(ctx, txn, block); err != nil {
+						return err
+					}
+
+					for _, tx := range block.Transactions {
+						for _, op := range tx.Operations {
+							err := storage.UpdateBalance(ctx, txn, op.Account, op.Amount, block.BlockIdentifier)
+							if err != nil {
+								return err
+							}
+						}
+					}
+
+					return nil
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetBlock(b *testing.B) {
+	ctx := context.Background()
+	g := generator.New(generator.Config{
+		Height:                   1,
+		TransactionsPerBlock:     100,
+		OperationsPerTransaction: 2,
+		Accounts:                 1000,
+	})
+	block := g.Block(1)
+
+	for name, codec := range codecs {
+		b.Run(name, func(b *testing.B) {
+			newDir, err := CreateTempDir()
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer RemoveTempDir(*newDir)
+
+			database, err := NewBadgerStorage(ctx, *newDir)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer database.Close(ctx)
+
+			storage := NewBlockStorage(ctx, database, codec, &SHA256KeyHasher{})
+			err = storage.Update(ctx, func(txn DatabaseTransaction) error {
+				return storage.StoreBlock(ctx, txn, block)
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			txn := storage.NewDatabaseTransaction(ctx, false)
+			defer txn.Discard(ctx)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := storage.GetBlock(ctx, txn, block.BlockIdentifier); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// ErrBufferConflict is returned by FlushBufferedTransactions
+// when a key written by one BufferedTransaction was also
+// read or written by another.
+var ErrBufferConflict = errors.New("Buffered transactions conflict")
+
+// BufferedTransaction buffers the writes made on top of a
+// DatabaseTransaction shared by several goroutines (ex: to
+// apply the balance changes of disjoint accounts in
+// parallel). Reads of keys it has not written are served by
+// the shared transaction. Its writes are only applied to the
+// shared transaction by FlushBufferedTransactions.
+type BufferedTransaction struct {
+	base  DatabaseTransaction
+	mutex *sync.Mutex
+
+	reads  map[string]struct{}
+	writes map[string]*memoryValue
+}
+
+// NewBufferedTransactions returns count BufferedTransactions
+// on top of base. base must not be used until they have been
+// flushed or discarded.
+func NewBufferedTransactions(base DatabaseTransaction, count int) []*BufferedTransaction {
+	mutex := new(sync.Mutex)
+	buffers := make([]*BufferedTransaction, count)
+	for i := range buffers {
+		buffers[i] = &BufferedTransaction{
+			base:   base,
+			mutex:  mutex,
+			reads:  map[string]struct{}{},
+			writes: map[string]*memoryValue{},
+		}
+	}
+
+	return buffers
+}
+
+// Set buffers a change of the value of key.
+func (t *BufferedTransaction) Set(ctx context.Context, key []byte, value []byte) error {
+	stored := make([]byte, len(value))
+	copy(stored, value)
+	t.writes[string(key)] = &memoryValue{value: stored}
+	return nil
+}
+
+// Get returns the buffered value of key or, if it
+// has not been written, its value in the shared
+// transaction.
+func (t *BufferedTransaction) Get(ctx context.Context, key []byte) (bool, []byte, error) {
+	if v, ok := t.writes[string(key)]; ok {
+		if v.deleted {
+			return false, nil, nil
+		}
+
+		value := make([]byte, len(v.value))
+		copy(value, v.value)
+		return true, value, nil
+	}
+
+	t.reads[string(key)] = struct{}{}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.base.Get(ctx, key)
+}
+
+// Delete buffers the removal of key.
+func (t *BufferedTransaction) Delete(ctx context.Context, key []byte) error {
+	t.writes[string(key)] = &memoryValue{deleted: true}
+	return nil
+}
+
+// Commit returns an error. BufferedTransactions are
+// applied with FlushBufferedTransactions.
+func (t *BufferedTransaction) Commit(context.Context) error {
+	return errors.New("BufferedTransaction must be flushed with FlushBufferedTransactions")
+}
+
+// Discard discards the buffered writes.
+func (t *BufferedTransaction) Discard(context.Context) {
+	t.writes = map[string]*memoryValue{}
+}
+
+// FlushBufferedTransactions applies the writes buffered by
+// buffers to base. If a key written by one buffer was also
+// read or written by another (so that applying them
+// concurrently may differ from applying them one after
+// another), ErrBufferConflict is returned and nothing is
+// applied.
+func FlushBufferedTransactions(
+	ctx context.Context,
+	base DatabaseTransaction,
+	buffers []*BufferedTransaction,
+) error {
+	writers := map[string]int{}
+	for i, buffer := range buffers {
+		for key := range buffer.writes {
+			if _, ok := writers[key]; ok {
+				return ErrBufferConflict
+			}
+
+			writers[key] = i
+		}
+	}
+
+	for i, buffer := range buffers {
+		for key := range buffer.reads {
+			if writer, ok := writers[key]; ok && writer != i {
+				return ErrBufferConflict
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(writers))
+	for key := range writers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		v := buffers[writers[key]].writes[key]
+		if v.deleted {
+			if err := base.Delete(ctx, []byte(key)); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := base.Set(ctx, []byte(key), v.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
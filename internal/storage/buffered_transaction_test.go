@@ -0,0 +1,89 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBufferedTransactions(t *testing.T) {
+	ctx := context.Background()
+	database := NewMemoryStorage()
+	assert.NoError(t, database.Set(ctx, []byte("shared"), []byte("value")))
+
+	t.Run("Disjoint writes are flushed", func(t *testing.T) {
+		txn := database.NewDatabaseTransaction(ctx, true)
+		buffers := NewBufferedTransactions(txn, 2)
+
+		exists, value, err := buffers[0].Get(ctx, []byte("shared"))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, []byte("value"), value)
+
+		assert.NoError(t, buffers[0].Set(ctx, []byte("a"), []byte("1")))
+		assert.NoError(t, buffers[1].Set(ctx, []byte("b"), []byte("2")))
+
+		exists, value, err = buffers[0].Get(ctx, []byte("a"))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, []byte("1"), value)
+
+		// Writes are not visible until flushed.
+		exists, _, err = txn.Get(ctx, []byte("a"))
+		assert.NoError(t, err)
+		assert.False(t, exists)
+
+		assert.NoError(t, FlushBufferedTransactions(ctx, txn, buffers))
+		assert.NoError(t, txn.Commit(ctx))
+
+		for key, expected := range map[string]string{"a": "1", "b": "2"} {
+			exists, value, err := database.Get(ctx, []byte(key))
+			assert.NoError(t, err)
+			assert.True(t, exists)
+			assert.Equal(t, []byte(expected), value)
+		}
+	})
+
+	t.Run("Conflicting writes are not flushed", func(t *testing.T) {
+		txn := database.NewDatabaseTransaction(ctx, true)
+		buffers := NewBufferedTransactions(txn, 2)
+
+		assert.NoError(t, buffers[0].Set(ctx, []byte("c"), []byte("1")))
+		assert.NoError(t, buffers[1].Set(ctx, []byte("c"), []byte("2")))
+		err := FlushBufferedTransactions(ctx, txn, buffers)
+		assert.True(t, errors.Is(err, ErrBufferConflict))
+
+		exists, _, err := txn.Get(ctx, []byte("c"))
+		assert.NoError(t, err)
+		assert.False(t, exists)
+		txn.Discard(ctx)
+	})
+
+	t.Run("Writes read by another buffer are not flushed", func(t *testing.T) {
+		txn := database.NewDatabaseTransaction(ctx, true)
+		buffers := NewBufferedTransactions(txn, 2)
+
+		_, _, err := buffers[0].Get(ctx, []byte("shared"))
+		assert.NoError(t, err)
+		assert.NoError(t, buffers[1].Delete(ctx, []byte("shared")))
+		err = FlushBufferedTransactions(ctx, txn, buffers)
+		assert.True(t, errors.Is(err, ErrBufferConflict))
+		txn.Discard(ctx)
+	})
+}
@@ -0,0 +1,373 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	// chunkedCommitKey is used to lookup the
+	// chunkedCommit in progress (if any).
+	chunkedCommitKey = "chunked-commit"
+
+	// chunkedJournalNamespace is prepended to the
+	// number of each entry of the journal of a
+	// chunkedCommit.
+	chunkedJournalNamespace = "chunked-commit-journal"
+
+	// chunkedJournalEntrySize is the maximum number of
+	// bytes of the encoded writes of a chunkedCommit in
+	// each entry of its journal. It is well below the size
+	// limit of a Badger transaction (about 9.6MB with the
+	// default options) so that each entry can be stored
+	// regardless of the size of the values written.
+	chunkedJournalEntrySize = 1 << 20
+)
+
+var (
+	// ErrTransactionTooBig is returned by DatabaseTransaction.Set
+	// and DatabaseTransaction.Delete when the transaction has
+	// reached the size limit of the Database.
+	ErrTransactionTooBig = errors.New("Transaction too big")
+)
+
+// journalWrite is a write of a transaction
+// recorded in the journal of a chunkedCommit.
+type journalWrite struct {
+	Key     []byte
+	Value   []byte
+	Deleted bool
+}
+
+// chunkedCommit marks a transaction too big to commit at
+// once whose writes have all been journaled, so that they
+// can be applied across several transactions. If it is
+// interrupted (ex: by a crash), the journaled writes are
+// applied again by CompleteChunkedCommit.
+type chunkedCommit struct {
+	Entries int
+}
+
+func getChunkedCommitKey(hasher KeyHasher) []byte {
+	return hasher.Hash([]byte(chunkedCommitKey))
+}
+
+func getChunkedJournalKey(hasher KeyHasher, entry int) []byte {
+	return hasher.Hash([]byte(fmt.Sprintf("%s:%d", chunkedJournalNamespace, entry)))
+}
+
+// chunkedDatabase wraps the Database backing a BlockStorage
+// to commit transactions too big to commit at once (see
+// updateChunked). The writes of a chunkedCommit are applied
+// across several transactions, so no other transaction is
+// created until they have all been applied. Because a Badger
+// transaction reads from a snapshot taken when it is created,
+// other transactions see all or none of the writes of a
+// chunkedCommit.
+type chunkedDatabase struct {
+	Database
+
+	keyHasher KeyHasher
+
+	// commitMutex is held for writing
+	// while a chunkedCommit is applied.
+	commitMutex sync.RWMutex
+}
+
+func newChunkedDatabase(db Database, keyHasher KeyHasher) *chunkedDatabase {
+	return &chunkedDatabase{
+		Database:  db,
+		keyHasher: keyHasher,
+	}
+}
+
+// NewDatabaseTransaction creates a transaction once no
+// chunkedCommit is being applied.
+func (c *chunkedDatabase) NewDatabaseTransaction(
+	ctx context.Context,
+	write bool,
+) DatabaseTransaction {
+	c.commitMutex.RLock()
+	defer c.commitMutex.RUnlock()
+
+	return c.Database.NewDatabaseTransaction(ctx, write)
+}
+
+// Set changes the value of the key to the value in its own
+// transaction once no chunkedCommit is being applied.
+func (c *chunkedDatabase) Set(ctx context.Context, key []byte, value []byte) error {
+	c.commitMutex.RLock()
+	defer c.commitMutex.RUnlock()
+
+	return c.Database.Set(ctx, key, value)
+}
+
+// Get fetches the value of a key in its own transaction
+// once no chunkedCommit is being applied.
+func (c *chunkedDatabase) Get(ctx context.Context, key []byte) (bool, []byte, error) {
+	c.commitMutex.RLock()
+	defer c.commitMutex.RUnlock()
+
+	return c.Database.Get(ctx, key)
+}
+
+// GarbageCollect garbage collects the wrapped
+// Database, if it is a GarbageCollector.
+func (c *chunkedDatabase) GarbageCollect(ctx context.Context) error {
+	collector, ok := c.Database.(GarbageCollector)
+	if !ok {
+		return nil
+	}
+
+	return collector.GarbageCollect(ctx)
+}
+
+// updateChunked runs fn in a BufferedTransaction on top of
+// a read-only transaction and commits its writes with a
+// chunkedCommit. It is only used for transactions that are
+// too big to commit at once (ex: a block with hundreds of
+// thousands of operations).
+//
+// Unlike Update, conflicts with concurrent transactions are
+// not detected, so fn must only write keys that are not
+// written concurrently. The blocks and balances stored in a
+// BlockStorage are only written by its SyncHandler (one
+// block at a time), which is the only writer of transactions
+// big enough to be committed in chunks.
+func (c *chunkedDatabase) updateChunked(
+	ctx context.Context,
+	fn func(DatabaseTransaction) error,
+) error {
+	base := c.NewDatabaseTransaction(ctx, false)
+	defer base.Discard(ctx)
+
+	buffer := NewBufferedTransactions(base, 1)[0]
+	if err := fn(buffer); err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(buffer.writes))
+	for key := range buffer.writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	writes := make([]*journalWrite, len(keys))
+	for i, key := range keys {
+		v := buffer.writes[key]
+		writes[i] = &journalWrite{
+			Key:     []byte(key),
+			Value:   v.value,
+			Deleted: v.deleted,
+		}
+	}
+
+	c.commitMutex.Lock()
+	defer c.commitMutex.Unlock()
+
+	return commitChunked(ctx, c.Database, c.keyHasher, writes)
+}
+
+// commitChunked journals writes, marks the journal complete
+// with a chunkedCommit (once it is stored, the writes are
+// committed), applies the writes, and removes the journal.
+// The encoded writes are split into entries of at most
+// chunkedJournalEntrySize bytes, each stored in its own
+// transaction.
+func commitChunked(
+	ctx context.Context,
+	db Database,
+	hasher KeyHasher,
+	writes []*journalWrite,
+) error {
+	buf, err := encodeValue(&GobCodec{}, writes)
+	if err != nil {
+		return err
+	}
+
+	entries := 0
+	for start := 0; start < len(buf); start += chunkedJournalEntrySize {
+		end := start + chunkedJournalEntrySize
+		if end > len(buf) {
+			end = len(buf)
+		}
+
+		if err := db.Set(ctx, getChunkedJournalKey(hasher, entries), buf[start:end]); err != nil {
+			return err
+		}
+
+		entries++
+	}
+
+	commit, err := encodeValue(&GobCodec{}, &chunkedCommit{Entries: entries})
+	if err != nil {
+		return err
+	}
+
+	if err := db.Set(ctx, getChunkedCommitKey(hasher), commit); err != nil {
+		return err
+	}
+
+	if err := applyJournalWrites(ctx, db, writes); err != nil {
+		return err
+	}
+
+	return clearChunkedCommit(ctx, db, hasher, entries)
+}
+
+// applyJournalWrites applies writes in as few transactions
+// as possible, committing each transaction once it reaches
+// the size limit of the Database. Applying writes more than
+// once has the same result as applying them once.
+func applyJournalWrites(
+	ctx context.Context,
+	db Database,
+	writes []*journalWrite,
+) error {
+	transaction := db.NewDatabaseTransaction(ctx, true)
+	defer func() { transaction.Discard(ctx) }()
+
+	pending := 0
+	for i := 0; i < len(writes); {
+		var err error
+		if writes[i].Deleted {
+			err = transaction.Delete(ctx, writes[i].Key)
+		} else {
+			err = transaction.Set(ctx, writes[i].Key, writes[i].Value)
+		}
+
+		if errors.Is(err, ErrTransactionTooBig) && pending > 0 {
+			if err := transaction.Commit(ctx); err != nil {
+				return err
+			}
+
+			transaction = db.NewDatabaseTransaction(ctx, true)
+			pending = 0
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		pending++
+		i++
+	}
+
+	return transaction.Commit(ctx)
+}
+
+// clearChunkedCommit removes a chunkedCommit
+// and the entries of its journal.
+func clearChunkedCommit(ctx context.Context, db Database, hasher KeyHasher, entries int) error {
+	return Update(ctx, db, func(transaction DatabaseTransaction) error {
+		for entry := 0; entry < entries; entry++ {
+			if err := transaction.Delete(ctx, getChunkedJournalKey(hasher, entry)); err != nil {
+				return err
+			}
+		}
+
+		return transaction.Delete(ctx, getChunkedCommitKey(hasher))
+	})
+}
+
+// interruptedChunkedCommit returns the chunkedCommit that
+// was interrupted (if any) or, if a transaction was
+// interrupted before all of its writes were journaled, the
+// number of entries in its partial journal.
+func (b *BlockStorage) interruptedChunkedCommit(ctx context.Context) (*chunkedCommit, int, error) {
+	exists, value, err := b.db.Get(ctx, getChunkedCommitKey(b.keyHasher))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if exists {
+		var commit chunkedCommit
+		if err := decodeValue(value, &commit); err != nil {
+			return nil, 0, err
+		}
+
+		return &commit, commit.Entries, nil
+	}
+
+	entries := 0
+	for {
+		exists, _, err := b.db.Get(ctx, getChunkedJournalKey(b.keyHasher, entries))
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if !exists {
+			return nil, entries, nil
+		}
+
+		entries++
+	}
+}
+
+// CompleteChunkedCommit completes a transaction too big
+// to commit at once (see Update) that was interrupted (ex:
+// by a crash). If all of its writes were journaled, they are
+// applied (rolled forward) and true is returned. Otherwise,
+// none of its writes were applied and its partial journal
+// (if any) is removed. It must be called before any other
+// transaction is run.
+func (b *BlockStorage) CompleteChunkedCommit(ctx context.Context) (bool, error) {
+	commit, entries, err := b.interruptedChunkedCommit(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	if commit == nil {
+		if entries == 0 {
+			return false, nil
+		}
+
+		return false, clearChunkedCommit(ctx, b.db, b.keyHasher, entries)
+	}
+
+	buf := []byte{}
+	for entry := 0; entry < commit.Entries; entry++ {
+		exists, value, err := b.db.Get(ctx, getChunkedJournalKey(b.keyHasher, entry))
+		if err != nil {
+			return false, err
+		}
+
+		if !exists {
+			return false, fmt.Errorf("%s entry %d missing", chunkedJournalNamespace, entry)
+		}
+
+		buf = append(buf, value...)
+	}
+
+	var writes []*journalWrite
+	if err := decodeValue(buf, &writes); err != nil {
+		return false, err
+	}
+
+	if err := applyJournalWrites(ctx, b.db, writes); err != nil {
+		return false, err
+	}
+
+	return true, clearChunkedCommit(ctx, b.db, b.keyHasher, commit.Entries)
+}
@@ -0,0 +1,308 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// limitedStorage is a MemoryStorage whose write
+// transactions fail with ErrTransactionTooBig once
+// they contain limit writes.
+type limitedStorage struct {
+	*MemoryStorage
+
+	limit        int
+	transactions int
+}
+
+func (l *limitedStorage) NewDatabaseTransaction(ctx context.Context, write bool) DatabaseTransaction {
+	if write {
+		l.transactions++
+	}
+
+	return &limitedTransaction{
+		DatabaseTransaction: l.MemoryStorage.NewDatabaseTransaction(ctx, write),
+		limit:               l.limit,
+	}
+}
+
+type limitedTransaction struct {
+	DatabaseTransaction
+
+	limit  int
+	writes int
+}
+
+func (l *limitedTransaction) Set(ctx context.Context, key []byte, value []byte) error {
+	if l.writes == l.limit {
+		return ErrTransactionTooBig
+	}
+
+	l.writes++
+	return l.DatabaseTransaction.Set(ctx, key, value)
+}
+
+func (l *limitedTransaction) Delete(ctx context.Context, key []byte) error {
+	if l.writes == l.limit {
+		return ErrTransactionTooBig
+	}
+
+	l.writes++
+	return l.DatabaseTransaction.Delete(ctx, key)
+}
+
+func testKey(i int) []byte {
+	return []byte(fmt.Sprintf("key-%d", i))
+}
+
+func TestChunkedCommit(t *testing.T) {
+	ctx := context.Background()
+	assertValues := func(t *testing.T, db Database, count int, exists bool) {
+		for i := 0; i < count; i++ {
+			found, value, err := db.Get(ctx, testKey(i))
+			assert.NoError(t, err)
+			assert.Equal(t, exists, found)
+			if exists {
+				assert.Equal(t, []byte("value"), value)
+			}
+		}
+	}
+	hasher := &SHA256KeyHasher{}
+	assertNoJournal := func(t *testing.T, db Database) {
+		for _, key := range [][]byte{getChunkedCommitKey(hasher), getChunkedJournalKey(hasher, 0)} {
+			exists, _, err := db.Get(ctx, key)
+			assert.NoError(t, err)
+			assert.False(t, exists)
+		}
+	}
+
+	t.Run("Too big transaction", func(t *testing.T) {
+		db := &limitedStorage{MemoryStorage: NewMemoryStorage(), limit: 10}
+		assert.NoError(t, db.MemoryStorage.Set(ctx, []byte("deleted"), []byte("value")))
+		storage := NewBlockStorage(ctx, db, &GobCodec{}, hasher)
+
+		runs := 0
+		assert.NoError(t, storage.Update(ctx, func(transaction DatabaseTransaction) error {
+			runs++
+			for i := 0; i < 25; i++ {
+				if err := transaction.Set(ctx, testKey(i), []byte("value")); err != nil {
+					return err
+				}
+			}
+
+			return transaction.Delete(ctx, []byte("deleted"))
+		}))
+
+		assert.Equal(t, 2, runs)
+		assertValues(t, db, 25, true)
+		assertNoJournal(t, db)
+
+		exists, _, err := db.Get(ctx, []byte("deleted"))
+		assert.NoError(t, err)
+		assert.False(t, exists)
+
+		// 1 failed transaction, 3 transactions to apply
+		// 26 writes, and 1 to clear the journal.
+		assert.Equal(t, 5, db.transactions)
+	})
+
+	t.Run("Too big transaction outside of BlockStorage", func(t *testing.T) {
+		db := &limitedStorage{MemoryStorage: NewMemoryStorage(), limit: 10}
+		err := Update(ctx, db, func(transaction DatabaseTransaction) error {
+			for i := 0; i < 25; i++ {
+				if err := transaction.Set(ctx, testKey(i), []byte("value")); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		assert.True(t, errors.Is(err, ErrTransactionTooBig))
+		assertValues(t, db, 25, false)
+	})
+
+	t.Run("Transactions wait for chunked commit", func(t *testing.T) {
+		storage := NewBlockStorage(ctx, NewMemoryStorage(), &GobCodec{}, hasher)
+		chunked := storage.db.(*chunkedDatabase)
+		chunked.commitMutex.Lock()
+
+		created := make(chan struct{})
+		go func() {
+			storage.NewDatabaseTransaction(ctx, false).Discard(ctx)
+			close(created)
+		}()
+
+		select {
+		case <-created:
+			t.Fatal("transaction created while a chunked commit was applied")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		chunked.commitMutex.Unlock()
+		<-created
+	})
+
+	t.Run("Errors are returned", func(t *testing.T) {
+		db := &limitedStorage{MemoryStorage: NewMemoryStorage(), limit: 10}
+		storage := NewBlockStorage(ctx, db, &GobCodec{}, hasher)
+		errFailed := errors.New("failed")
+		err := storage.Update(ctx, func(transaction DatabaseTransaction) error {
+			for i := 0; i < 25; i++ {
+				if err := transaction.Set(ctx, testKey(i), []byte("value")); err != nil {
+					return err
+				}
+			}
+
+			return errFailed
+		})
+		assert.True(t, errors.Is(err, errFailed))
+		assertValues(t, db, 25, false)
+		assertNoJournal(t, db)
+	})
+
+	writes := []*journalWrite{}
+	for i := 0; i < 10000; i++ {
+		writes = append(writes, &journalWrite{Key: testKey(i), Value: []byte("value")})
+	}
+
+	journal := func(t *testing.T, db Database) {
+		buf, err := encodeValue(&GobCodec{}, writes)
+		assert.NoError(t, err)
+		for entry, chunk := range [][]byte{buf[:len(buf)/2], buf[len(buf)/2:]} {
+			assert.NoError(t, db.Set(ctx, getChunkedJournalKey(hasher, entry), chunk))
+		}
+	}
+
+	t.Run("Interrupted after journaling", func(t *testing.T) {
+		db := NewMemoryStorage()
+		storage := NewBlockStorage(ctx, db, &GobCodec{}, hasher)
+		journal(t, db)
+
+		buf, err := encodeValue(&GobCodec{}, &chunkedCommit{Entries: 2})
+		assert.NoError(t, err)
+		assert.NoError(t, db.Set(ctx, getChunkedCommitKey(hasher), buf))
+
+		completed, err := storage.CompleteChunkedCommit(ctx)
+		assert.NoError(t, err)
+		assert.True(t, completed)
+		assertValues(t, db, len(writes), true)
+		assertNoJournal(t, db)
+
+		completed, err = storage.CompleteChunkedCommit(ctx)
+		assert.NoError(t, err)
+		assert.False(t, completed)
+	})
+
+	t.Run("Interrupted while journaling", func(t *testing.T) {
+		db := NewMemoryStorage()
+		storage := NewBlockStorage(ctx, db, &GobCodec{}, hasher)
+		journal(t, db)
+
+		completed, err := storage.CompleteChunkedCommit(ctx)
+		assert.NoError(t, err)
+		assert.False(t, completed)
+		assertValues(t, db, len(writes), false)
+		assertNoJournal(t, db)
+
+		exists, _, err := db.Get(ctx, getChunkedJournalKey(hasher, 1))
+		assert.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("Recover", func(t *testing.T) {
+		db := NewMemoryStorage()
+		storage := NewBlockStorage(ctx, db, &GobCodec{}, hasher)
+		journal(t, db)
+
+		inconsistencies, err := storage.Recover(ctx, 10, false)
+		assert.NoError(t, err)
+		assert.Equal(t, []*Inconsistency{
+			{
+				Description: "transaction too big to commit at once was not committed (2 journal entries)",
+			},
+		}, inconsistencies)
+
+		inconsistencies, err = storage.Recover(ctx, 10, true)
+		assert.NoError(t, err)
+		assert.Len(t, inconsistencies, 1)
+		assert.True(t, inconsistencies[0].Repaired)
+		assertNoJournal(t, db)
+
+		inconsistencies, err = storage.Recover(ctx, 10, false)
+		assert.NoError(t, err)
+		assert.Empty(t, inconsistencies)
+	})
+}
+
+func TestBadgerChunkedCommit(t *testing.T) {
+	ctx := context.Background()
+
+	newDir, err := CreateTempDir()
+	assert.NoError(t, err)
+	defer RemoveTempDir(*newDir)
+
+	database, err := NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	hasher := &SHA256KeyHasher{}
+	storage := NewBlockStorage(ctx, database, &GobCodec{}, hasher)
+
+	// Badger limits the number of writes in a transaction
+	// (to about 100,000 with the default options) and their
+	// size (to about 9.6MB), so the large values must be
+	// journaled in several entries.
+	count := 250000
+	large := make([]byte, 3<<20)
+	assert.NoError(t, storage.Update(ctx, func(transaction DatabaseTransaction) error {
+		for i := 0; i < count; i++ {
+			if err := transaction.Set(ctx, testKey(i), []byte("value")); err != nil {
+				return err
+			}
+		}
+
+		for i := 0; i < 4; i++ {
+			key := []byte(fmt.Sprintf("large-%d", i))
+			if err := transaction.Set(ctx, key, large); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}))
+
+	for _, i := range []int{0, count / 2, count - 1} {
+		exists, value, err := database.Get(ctx, testKey(i))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, []byte("value"), value)
+	}
+
+	exists, value, err := database.Get(ctx, []byte("large-3"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, large, value)
+
+	exists, _, err = database.Get(ctx, getChunkedCommitKey(hasher))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
@@ -0,0 +1,223 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+const (
+	// GobCodecName is the name of the GobCodec. This is
+	// the default codec and the encoding of any value
+	// stored before codecs were configurable.
+	GobCodecName = "gob"
+
+	// JSONCodecName is the name of the JSONCodec.
+	JSONCodecName = "json"
+
+	// MsgpackCodecName is the name of the MsgpackCodec.
+	MsgpackCodecName = "msgpack"
+
+	// codecMarker is the first byte of any value encoded
+	// by BlockStorage. A gob stream never starts with a 0
+	// byte (it would indicate an empty message), so values
+	// stored without a codec header can be recognized as gob.
+	codecMarker = byte(0)
+
+	// maxPooledBufferSize is the capacity above which an
+	// encoding buffer is not returned to the pool, so that
+	// a single large block does not pin memory indefinitely.
+	maxPooledBufferSize = 4 << 20
+)
+
+var (
+	// ErrUnknownCodec is returned when a codec name
+	// or identifier is not recognized.
+	ErrUnknownCodec = errors.New("Unknown codec")
+)
+
+// Codec encodes and decodes values stored in BlockStorage.
+type Codec interface {
+	// ID uniquely identifies the codec in the header of
+	// encoded values. It must never change once values
+	// have been stored with the codec.
+	ID() byte
+
+	// Encode writes the encoding of v to w.
+	Encode(w io.Writer, v interface{}) error
+	Decode(data []byte, v interface{}) error
+}
+
+// GobCodec encodes values with encoding/gob. Each value
+// is encoded with a new gob.Encoder (and decoded with a new
+// gob.Decoder) because a gob stream only describes each type
+// once, so a stored value must carry its own type definitions
+// to be decoded on its own.
+type GobCodec struct{}
+
+// ID returns the identifier of the GobCodec.
+func (c *GobCodec) ID() byte { return 1 }
+
+// Encode encodes v with encoding/gob.
+func (c *GobCodec) Encode(w io.Writer, v interface{}) error {
+	return gob.NewEncoder(w).Encode(v)
+}
+
+// Decode decodes data encoded with encoding/gob into v.
+func (c *GobCodec) Decode(data []byte, v interface{}) error {
+	reader := readerPool.Get().(*bytes.Reader)
+	defer readerPool.Put(reader)
+
+	reader.Reset(data)
+	err := gob.NewDecoder(reader).Decode(v)
+	reader.Reset(nil)
+	return err
+}
+
+// JSONCodec encodes values with encoding/json. Values are
+// larger than with other codecs but are human readable.
+type JSONCodec struct{}
+
+// ID returns the identifier of the JSONCodec.
+func (c *JSONCodec) ID() byte { return 2 }
+
+// Encode encodes v with encoding/json.
+func (c *JSONCodec) Encode(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// Decode decodes data encoded with encoding/json into v.
+func (c *JSONCodec) Decode(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// MsgpackCodec encodes values with MessagePack.
+type MsgpackCodec struct{}
+
+// ID returns the identifier of the MsgpackCodec.
+func (c *MsgpackCodec) ID() byte { return 3 }
+
+// Encode encodes v with MessagePack.
+func (c *MsgpackCodec) Encode(w io.Writer, v interface{}) error {
+	encoder := msgpackEncoderPool.Get().(*msgpack.Encoder)
+	defer msgpackEncoderPool.Put(encoder)
+
+	encoder.Reset(w)
+	return encoder.Encode(v)
+}
+
+// Decode decodes data encoded with MessagePack into v.
+// msgpack.Unmarshal reuses pooled decoders.
+func (c *MsgpackCodec) Decode(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+var codecs = map[string]Codec{
+	GobCodecName:     &GobCodec{},
+	JSONCodecName:    &JSONCodec{},
+	MsgpackCodecName: &MsgpackCodec{},
+}
+
+// NewCodec returns the Codec with the provided name.
+//
+// There is no protobuf codec because the Rosetta types
+// are generated from an OpenAPI specification and have
+// no protobuf message definitions.
+func NewCodec(name string) (Codec, error) {
+	codec, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownCodec, name)
+	}
+
+	return codec, nil
+}
+
+// bufferPool holds buffers used to encode values so
+// that each encoding does not grow a new buffer.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// readerPool holds readers used to decode gob values.
+var readerPool = sync.Pool{
+	New: func() interface{} {
+		return bytes.NewReader(nil)
+	},
+}
+
+// msgpackEncoderPool holds MessagePack encoders, which
+// (unlike gob encoders) keep no state between values.
+var msgpackEncoderPool = sync.Pool{
+	New: func() interface{} {
+		return msgpack.NewEncoder(nil)
+	},
+}
+
+// encodeValue encodes v with codec and prepends a header
+// identifying the codec.
+func encodeValue(codec Codec, v interface{}) ([]byte, error) {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer func() {
+		if buf.Cap() <= maxPooledBufferSize {
+			bufferPool.Put(buf)
+		}
+	}()
+
+	buf.Reset()
+	buf.WriteByte(codecMarker)
+	buf.WriteByte(codec.ID())
+	if err := codec.Encode(buf, v); err != nil {
+		return nil, err
+	}
+
+	// The returned value is held by the database until the
+	// transaction is committed, so it cannot share memory
+	// with the pooled buffer.
+	value := make([]byte, buf.Len())
+	copy(value, buf.Bytes())
+	return value, nil
+}
+
+// decodeValue decodes data into v using the codec identified
+// in its header. This allows values encoded with any codec
+// to be read, so changing the configured codec migrates
+// stored values as they are next written.
+func decodeValue(data []byte, v interface{}) error {
+	if len(data) == 0 || data[0] != codecMarker {
+		return codecs[GobCodecName].Decode(data, v)
+	}
+
+	if len(data) < 2 {
+		return fmt.Errorf("%w: missing codec identifier", ErrUnknownCodec)
+	}
+
+	for _, codec := range codecs {
+		if codec.ID() == data[1] {
+			return codec.Decode(data[2:], v)
+		}
+	}
+
+	return fmt.Errorf("%w %d", ErrUnknownCodec, data[1])
+}
@@ -0,0 +1,170 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var codecTestBlock = &rosetta.Block{
+	BlockIdentifier: &rosetta.BlockIdentifier{
+		Hash:  "1",
+		Index: 1,
+	},
+	ParentBlockIdentifier: &rosetta.BlockIdentifier{
+		Hash:  "0",
+		Index: 0,
+	},
+	Timestamp: 1,
+	Transactions: []*rosetta.Transaction{
+		{
+			TransactionIdentifier: &rosetta.TransactionIdentifier{
+				Hash: "tx1",
+			},
+			Operations: []*rosetta.Operation{
+				{
+					OperationIdentifier: &rosetta.OperationIdentifier{
+						Index: 0,
+					},
+					Type:   "Transfer",
+					Status: "Success",
+					Account: &rosetta.AccountIdentifier{
+						Address: "acct1",
+					},
+					Amount: &rosetta.Amount{
+						Value: "100",
+						Currency: &rosetta.Currency{
+							Symbol:   "BLAH",
+							Decimals: 2,
+						},
+					},
+				},
+			},
+		},
+	},
+}
+
+func TestNewCodec(t *testing.T) {
+	for _, name := range []string{GobCodecName, JSONCodecName, MsgpackCodecName} {
+		codec, err := NewCodec(name)
+		assert.NoError(t, err)
+		assert.NotNil(t, codec)
+	}
+
+	codec, err := NewCodec("protobuf")
+	assert.True(t, errors.Is(err, ErrUnknownCodec))
+	assert.Nil(t, codec)
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, codec := range codecs {
+		buf, err := encodeValue(codec, codecTestBlock)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{codecMarker, codec.ID()}, buf[:2])
+
+		var block rosetta.Block
+		assert.NoError(t, decodeValue(buf, &block))
+		assert.Equal(t, codecTestBlock, &block)
+	}
+}
+
+func TestDecodeLegacyGob(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, (&GobCodec{}).Encode(&buf, codecTestBlock))
+
+	var block rosetta.Block
+	assert.NoError(t, decodeValue(buf.Bytes(), &block))
+	assert.Equal(t, codecTestBlock, &block)
+
+	assert.True(t, errors.Is(decodeValue([]byte{codecMarker, 100}, &block), ErrUnknownCodec))
+}
+
+func TestChangeCodec(t *testing.T) {
+	ctx := context.Background()
+
+	newDir, err := CreateTempDir()
+	assert.NoError(t, err)
+	defer RemoveTempDir(*newDir)
+
+	database, err := NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	gobStorage := NewBlockStorage(ctx, database, &GobCodec{}, &SHA256KeyHasher{})
+	txn := gobStorage.NewDatabaseTransaction(ctx, true)
+	assert.NoError(t, gobStorage.StoreBlock(ctx, txn, codecTestBlock))
+	assert.NoError(t, txn.Commit(ctx))
+
+	// Blocks stored with the previous codec remain readable.
+	jsonStorage := NewBlockStorage(ctx, database, &JSONCodec{}, &SHA256KeyHasher{})
+	txn = jsonStorage.NewDatabaseTransaction(ctx, false)
+	block, err := jsonStorage.GetBlock(ctx, txn, codecTestBlock.BlockIdentifier)
+	txn.Discard(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, codecTestBlock, block)
+}
+
+// benchmarkBlock returns a block with numTransactions
+// copies of the transaction in codecTestBlock.
+func benchmarkBlock(numTransactions int) *rosetta.Block {
+	block := *codecTestBlock
+	block.Transactions = make([]*rosetta.Transaction, numTransactions)
+	for i := range block.Transactions {
+		block.Transactions[i] = codecTestBlock.Transactions[0]
+	}
+
+	return &block
+}
+
+func BenchmarkEncodeValue(b *testing.B) {
+	block := benchmarkBlock(100)
+	for name, codec := range codecs {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := encodeValue(codec, block); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecodeValue(b *testing.B) {
+	block := benchmarkBlock(100)
+	for name, codec := range codecs {
+		buf, err := encodeValue(codec, block)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var decoded rosetta.Block
+				if err := decodeValue(buf, &decoded); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
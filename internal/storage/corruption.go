@@ -0,0 +1,158 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+// ErrCorruptedValue is matched by a CorruptionError.
+var ErrCorruptedValue = errors.New("Corrupted value")
+
+// CorruptionError is returned when a stored block or balance
+// cannot be decoded (ex: after a disk fault). Either Block or
+// Account identifies the corrupted value. It can be restored
+// with RestoreBlock or RestoreBalance instead of wiping
+// DATA_DIR.
+type CorruptionError struct {
+	Block   *rosetta.BlockIdentifier
+	Account *rosetta.AccountIdentifier
+	Err     error
+}
+
+// Error returns a description of the CorruptionError.
+func (e *CorruptionError) Error() string {
+	if e.Block != nil {
+		return fmt.Sprintf("%v: block %+v: %v", ErrCorruptedValue, e.Block, e.Err)
+	}
+
+	return fmt.Sprintf("%v: balance of %+v: %v", ErrCorruptedValue, e.Account, e.Err)
+}
+
+// Unwrap returns the error decoding the value.
+func (e *CorruptionError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true if target is ErrCorruptedValue.
+func (e *CorruptionError) Is(target error) bool {
+	return target == ErrCorruptedValue
+}
+
+// RestoreBlock replaces a stored block that cannot be
+// decoded with block (ex: fetched again from the Rosetta
+// Server). A block at or below the PrunedIndex is restored
+// without its transactions. The hashes and events stored
+// with the block are unaffected.
+func (b *BlockStorage) RestoreBlock(ctx context.Context, block *rosetta.Block) error {
+	return b.Update(ctx, func(transaction DatabaseTransaction) error {
+		key := getBlockKey(b.keyHasher, block.BlockIdentifier)
+		exists, _, err := transaction.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			return fmt.Errorf("%w %+v", ErrBlockNotFound, block.BlockIdentifier)
+		}
+
+		pruned, err := b.PrunedIndex(ctx, transaction)
+		if err != nil {
+			return err
+		}
+
+		var restored interface{} = block
+		if block.BlockIdentifier.Index <= pruned {
+			restored = prunedBlock(block)
+		}
+
+		buf, err := encodeValue(b.codec, restored)
+		if err != nil {
+			return err
+		}
+
+		return transaction.Set(ctx, key, buf)
+	})
+}
+
+// LastIntactBalance returns the last balances of account in
+// its balance history at or before the block at index that
+// can still be decoded, and the block they were updated at.
+// As every balance update is recorded in balance history,
+// this is the current balance unless the latest entries are
+// corrupted too. ErrBalanceHistoryNotFound is returned if no
+// such entry exists.
+func (b *BlockStorage) LastIntactBalance(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	account *rosetta.AccountIdentifier,
+	index int64,
+) (map[string]*rosetta.Amount, *rosetta.BlockIdentifier, error) {
+	var found *balanceEntry
+	namespace := getBalanceHistoryNamespace(b.keyHasher, account)
+	err := b.readStreamReverse(ctx, transaction, namespace, func(value []byte) (bool, error) {
+		entry, err := parseBalanceEntry(value)
+		if err != nil || entry.Block == nil || entry.Block.Index > index {
+			return true, nil
+		}
+
+		found = entry
+		return false, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if found == nil {
+		return nil, nil, fmt.Errorf(
+			"%w for %+v at block %d",
+			ErrBalanceHistoryNotFound,
+			account,
+			index,
+		)
+	}
+
+	return found.Amounts, found.Block, nil
+}
+
+// RestoreBalance replaces the balance of account (which may
+// not be decodable) with amounts last updated at block and
+// records it in balance history.
+func (b *BlockStorage) RestoreBalance(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	account *rosetta.AccountIdentifier,
+	amounts map[string]*rosetta.Amount,
+	block *rosetta.BlockIdentifier,
+) error {
+	entry := &balanceEntry{
+		Amounts: amounts,
+		Block:   block,
+	}
+	buf, err := serializeBalanceEntry(b.codec, *entry)
+	if err != nil {
+		return err
+	}
+
+	if err := transaction.Set(ctx, getBalanceKey(b.keyHasher, account), buf); err != nil {
+		return err
+	}
+
+	return b.storeBalanceHistory(ctx, transaction, account, entry)
+}
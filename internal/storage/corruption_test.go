@@ -0,0 +1,158 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCorruption(t *testing.T) {
+	ctx := context.Background()
+
+	newDir, err := CreateTempDir()
+	assert.NoError(t, err)
+	defer RemoveTempDir(*newDir)
+
+	database, err := NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	storage := NewBlockStorage(ctx, database, &GobCodec{}, &SHA256KeyHasher{})
+	corrupt := func(key []byte) {
+		assert.NoError(t, storage.Update(ctx, func(txn DatabaseTransaction) error {
+			return txn.Set(ctx, key, []byte{codecMarker, 99})
+		}))
+	}
+
+	block := &rosetta.Block{
+		BlockIdentifier: &rosetta.BlockIdentifier{Hash: "1", Index: 1},
+		ParentBlockIdentifier: &rosetta.BlockIdentifier{
+			Hash:  "0",
+			Index: 0,
+		},
+		Timestamp: 1,
+	}
+	account := &rosetta.AccountIdentifier{Address: "addr"}
+	currency := &rosetta.Currency{Symbol: "BLAH", Decimals: 2}
+	for i, value := range []string{"100", "50"} {
+		assert.NoError(t, storage.Update(ctx, func(txn DatabaseTransaction) error {
+			return storage.UpdateBalance(ctx, txn, account, &rosetta.Amount{
+				Value:    value,
+				Currency: currency,
+			}, &rosetta.BlockIdentifier{Hash: "block", Index: int64(i + 1)})
+		}))
+	}
+
+	t.Run("Corrupted block", func(t *testing.T) {
+		assert.NoError(t, storage.Update(ctx, func(txn DatabaseTransaction) error {
+			return storage.StoreBlock(ctx, txn, block)
+		}))
+		corrupt(getBlockKey(storage.keyHasher, block.BlockIdentifier))
+
+		txn := storage.NewDatabaseTransaction(ctx, false)
+		_, err := storage.GetBlock(ctx, txn, block.BlockIdentifier)
+		txn.Discard(ctx)
+		assert.True(t, errors.Is(err, ErrCorruptedValue))
+		var corruptionErr *CorruptionError
+		assert.True(t, errors.As(err, &corruptionErr))
+		assert.Equal(t, block.BlockIdentifier, corruptionErr.Block)
+		assert.True(t, errors.Is(err, ErrUnknownCodec))
+
+		assert.NoError(t, storage.RestoreBlock(ctx, block))
+		txn = storage.NewDatabaseTransaction(ctx, false)
+		restored, err := storage.GetBlock(ctx, txn, block.BlockIdentifier)
+		txn.Discard(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, block, restored)
+	})
+
+	t.Run("Unstored block is not restored", func(t *testing.T) {
+		err := storage.RestoreBlock(ctx, &rosetta.Block{
+			BlockIdentifier: &rosetta.BlockIdentifier{Hash: "2", Index: 2},
+		})
+		assert.True(t, errors.Is(err, ErrBlockNotFound))
+	})
+
+	t.Run("Corrupted balance", func(t *testing.T) {
+		corrupt(getBalanceKey(storage.keyHasher, account))
+
+		txn := storage.NewDatabaseTransaction(ctx, false)
+		_, _, err := storage.GetBalance(ctx, txn, account)
+		txn.Discard(ctx)
+		var corruptionErr *CorruptionError
+		assert.True(t, errors.As(err, &corruptionErr))
+		assert.Equal(t, account, corruptionErr.Account)
+
+		err = storage.Update(ctx, func(txn DatabaseTransaction) error {
+			return storage.UpdateBalance(ctx, txn, account, &rosetta.Amount{
+				Value:    "1",
+				Currency: currency,
+			}, &rosetta.BlockIdentifier{Hash: "block", Index: 3})
+		})
+		assert.True(t, errors.Is(err, ErrCorruptedValue))
+	})
+
+	t.Run("Last intact balance", func(t *testing.T) {
+		txn := storage.NewDatabaseTransaction(ctx, false)
+		amounts, updated, err := storage.LastIntactBalance(ctx, txn, account, 2)
+		txn.Discard(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), updated.Index)
+		assert.Equal(t, "150", amounts[GetCurrencyKey(currency)].Value)
+
+		// Corrupted entries and entries after
+		// the index are skipped.
+		namespace := getBalanceHistoryNamespace(storage.keyHasher, account)
+		corrupt(getStreamEntryKey(storage.keyHasher, namespace, 1))
+		txn = storage.NewDatabaseTransaction(ctx, false)
+		amounts, updated, err = storage.LastIntactBalance(ctx, txn, account, 2)
+		txn.Discard(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), updated.Index)
+		assert.Equal(t, "100", amounts[GetCurrencyKey(currency)].Value)
+
+		txn = storage.NewDatabaseTransaction(ctx, false)
+		_, _, err = storage.LastIntactBalance(ctx, txn, account, 0)
+		txn.Discard(ctx)
+		assert.True(t, errors.Is(err, ErrBalanceHistoryNotFound))
+	})
+
+	t.Run("Restore balance", func(t *testing.T) {
+		restoredBlock := &rosetta.BlockIdentifier{Hash: "block", Index: 2}
+		assert.NoError(t, storage.Update(ctx, func(txn DatabaseTransaction) error {
+			return storage.RestoreBalance(ctx, txn, account, map[string]*rosetta.Amount{
+				GetCurrencyKey(currency): {Value: "150", Currency: currency},
+			}, restoredBlock)
+		}))
+
+		txn := storage.NewDatabaseTransaction(ctx, false)
+		defer txn.Discard(ctx)
+		amounts, updated, err := storage.GetBalance(ctx, txn, account)
+		assert.NoError(t, err)
+		assert.Equal(t, restoredBlock, updated)
+		assert.Equal(t, "150", amounts[GetCurrencyKey(currency)].Value)
+
+		amounts, updated, err = storage.LastIntactBalance(ctx, txn, account, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, restoredBlock, updated)
+		assert.Equal(t, "150", amounts[GetCurrencyKey(currency)].Value)
+	})
+}
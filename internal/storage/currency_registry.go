@@ -0,0 +1,248 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+const (
+	// currencyNamespace is prepended to the currency key
+	// of a currency to lookup its RegisteredCurrency.
+	currencyNamespace = "currency"
+
+	// currencyRegistryNamespace is the namespace of the
+	// stream of the currency keys of every registered
+	// currency, in the order they were discovered.
+	currencyRegistryNamespace = "currency-registry"
+)
+
+// ErrCurrencyNotFound is returned when a currency
+// has not been registered.
+var ErrCurrencyNotFound = errors.New("Currency not found")
+
+// RegisteredCurrency is a currency discovered in the
+// operations of a block. The balances of untracked
+// currencies are not computed or reconciled.
+type RegisteredCurrency struct {
+	Key       string
+	Currency  *rosetta.Currency
+	FirstSeen *rosetta.BlockIdentifier
+	Tracked   bool
+}
+
+func getCurrencyRecordKey(hasher KeyHasher, currencyKey string) []byte {
+	return hasher.Hash([]byte(fmt.Sprintf("%s:%s", currencyNamespace, currencyKey)))
+}
+
+// getCurrency returns the RegisteredCurrency with
+// currencyKey or ErrCurrencyNotFound.
+func (b *BlockStorage) getCurrency(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	currencyKey string,
+) (*RegisteredCurrency, error) {
+	exists, value, err := transaction.Get(ctx, getCurrencyRecordKey(b.keyHasher, currencyKey))
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, fmt.Errorf("%w %s", ErrCurrencyNotFound, currencyKey)
+	}
+
+	var currency RegisteredCurrency
+	if err := decodeValue(value, &currency); err != nil {
+		return nil, err
+	}
+
+	return &currency, nil
+}
+
+// storeCurrency stores a RegisteredCurrency.
+func (b *BlockStorage) storeCurrency(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	currency *RegisteredCurrency,
+) error {
+	buf, err := encodeValue(b.codec, currency)
+	if err != nil {
+		return err
+	}
+
+	return transaction.Set(ctx, getCurrencyRecordKey(b.keyHasher, currency.Key), buf)
+}
+
+// RegisterCurrency returns the RegisteredCurrency of
+// currency, registering it (as tracked if tracked is
+// true) if it has not been seen before. It returns true
+// if the currency was registered.
+func (b *BlockStorage) RegisterCurrency(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	currency *rosetta.Currency,
+	block *rosetta.BlockIdentifier,
+	tracked bool,
+) (*RegisteredCurrency, bool, error) {
+	key := GetCurrencyKey(currency)
+	registered, err := b.getCurrency(ctx, transaction, key)
+	if err == nil {
+		return registered, false, nil
+	}
+	if !errors.Is(err, ErrCurrencyNotFound) {
+		return nil, false, err
+	}
+
+	registered = &RegisteredCurrency{
+		Key:       key,
+		Currency:  currency,
+		FirstSeen: block,
+		Tracked:   tracked,
+	}
+	if err := b.storeCurrency(ctx, transaction, registered); err != nil {
+		return nil, false, err
+	}
+
+	if err := b.appendStream(ctx, transaction, currencyRegistryNamespace, key); err != nil {
+		return nil, false, err
+	}
+
+	return registered, true, nil
+}
+
+// UnregisterCurrencies removes the registration of every
+// currency first seen in block (ex: when it is orphaned) and
+// returns their currency keys. Currencies are registered in
+// the order their blocks are added, so the currencies of the
+// most recently added block are the last registered.
+func (b *BlockStorage) UnregisterCurrencies(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	block *rosetta.BlockIdentifier,
+) ([]string, error) {
+	length, err := b.streamLength(ctx, transaction, currencyRegistryNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for ; length > 0; length-- {
+		exists, value, err := transaction.Get(
+			ctx,
+			getStreamEntryKey(b.keyHasher, currencyRegistryNamespace, length-1),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if !exists {
+			return nil, fmt.Errorf("%s entry %d missing", currencyRegistryNamespace, length-1)
+		}
+
+		var key string
+		if err := decodeValue(value, &key); err != nil {
+			return nil, err
+		}
+
+		currency, err := b.getCurrency(ctx, transaction, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if currency.FirstSeen == nil || *currency.FirstSeen != *block {
+			break
+		}
+
+		if err := transaction.Delete(ctx, getCurrencyRecordKey(b.keyHasher, key)); err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err := b.truncateStream(ctx, transaction, currencyRegistryNamespace, length); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// SetCurrencyTracked opts the currency with currencyKey in
+// to (or out of) balance tracking and reconciliation. Balance
+// changes of a currency are not applied while it is untracked,
+// so its computed balances are incorrect if it is tracked
+// again without re-syncing. A running SyncHandler caches
+// whether each currency is tracked, so it must only be
+// called while no SyncHandler uses the BlockStorage.
+func (b *BlockStorage) SetCurrencyTracked(
+	ctx context.Context,
+	currencyKey string,
+	tracked bool,
+) error {
+	return b.Update(ctx, func(transaction DatabaseTransaction) error {
+		currency, err := b.getCurrency(ctx, transaction, currencyKey)
+		if err != nil {
+			return err
+		}
+
+		currency.Tracked = tracked
+		return b.storeCurrency(ctx, transaction, currency)
+	})
+}
+
+// Currencies returns every RegisteredCurrency in
+// the order they were discovered.
+func (b *BlockStorage) Currencies(ctx context.Context) ([]*RegisteredCurrency, error) {
+	transaction := b.db.NewDatabaseTransaction(ctx, false)
+	defer transaction.Discard(ctx)
+
+	length, err := b.streamLength(ctx, transaction, currencyRegistryNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	currencies := make([]*RegisteredCurrency, 0, length)
+	for sequence := int64(0); sequence < length; sequence++ {
+		exists, value, err := transaction.Get(
+			ctx,
+			getStreamEntryKey(b.keyHasher, currencyRegistryNamespace, sequence),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if !exists {
+			return nil, fmt.Errorf("%s entry %d missing", currencyRegistryNamespace, sequence)
+		}
+
+		var key string
+		if err := decodeValue(value, &key); err != nil {
+			return nil, err
+		}
+
+		currency, err := b.getCurrency(ctx, transaction, key)
+		if err != nil {
+			return nil, err
+		}
+
+		currencies = append(currencies, currency)
+	}
+
+	return currencies, nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCurrencyRegistry(t *testing.T) {
+	var (
+		native = &rosetta.Currency{
+			Symbol:   "ETH",
+			Decimals: 18,
+		}
+		token = &rosetta.Currency{
+			Symbol:   "USDC",
+			Decimals: 6,
+		}
+		block = &rosetta.BlockIdentifier{
+			Hash:  "1",
+			Index: 1,
+		}
+		laterBlock = &rosetta.BlockIdentifier{
+			Hash:  "2",
+			Index: 2,
+		}
+	)
+	ctx := context.Background()
+
+	newDir, err := CreateTempDir()
+	assert.NoError(t, err)
+	defer RemoveTempDir(*newDir)
+
+	database, err := NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	storage := NewBlockStorage(ctx, database, &GobCodec{}, &SHA256KeyHasher{})
+
+	t.Run("No currencies", func(t *testing.T) {
+		currencies, err := storage.Currencies(ctx)
+		assert.NoError(t, err)
+		assert.Len(t, currencies, 0)
+
+		err = storage.SetCurrencyTracked(ctx, GetCurrencyKey(native), false)
+		assert.True(t, errors.Is(err, ErrCurrencyNotFound))
+	})
+
+	t.Run("Register currencies", func(t *testing.T) {
+		txn := storage.NewDatabaseTransaction(ctx, true)
+		registered, discovered, err := storage.RegisterCurrency(ctx, txn, native, block, true)
+		assert.NoError(t, err)
+		assert.True(t, discovered)
+		assert.True(t, registered.Tracked)
+
+		registered, discovered, err = storage.RegisterCurrency(ctx, txn, token, block, false)
+		assert.NoError(t, err)
+		assert.True(t, discovered)
+		assert.False(t, registered.Tracked)
+
+		// Currencies are only registered once
+		registered, discovered, err = storage.RegisterCurrency(ctx, txn, native, laterBlock, false)
+		assert.NoError(t, err)
+		assert.False(t, discovered)
+		assert.True(t, registered.Tracked)
+		assert.Equal(t, block, registered.FirstSeen)
+		assert.NoError(t, txn.Commit(ctx))
+
+		currencies, err := storage.Currencies(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, []*RegisteredCurrency{
+			{Key: GetCurrencyKey(native), Currency: native, FirstSeen: block, Tracked: true},
+			{Key: GetCurrencyKey(token), Currency: token, FirstSeen: block, Tracked: false},
+		}, currencies)
+	})
+
+	t.Run("Toggle tracking", func(t *testing.T) {
+		assert.NoError(t, storage.SetCurrencyTracked(ctx, GetCurrencyKey(token), true))
+		assert.NoError(t, storage.SetCurrencyTracked(ctx, GetCurrencyKey(native), false))
+
+		currencies, err := storage.Currencies(ctx)
+		assert.NoError(t, err)
+		assert.False(t, currencies[0].Tracked)
+		assert.True(t, currencies[1].Tracked)
+	})
+}
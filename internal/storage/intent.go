@@ -0,0 +1,152 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+// reorgIntentKey is used to lookup the ReorgIntent
+// of a reorg that is in progress.
+const reorgIntentKey = "reorg-intent"
+
+// ReorgIntent records a reorg in progress. A reorg orphans
+// blocks one at a time (each in its own transaction) until
+// the fork point is found, so the ReorgIntent is updated in
+// the same transaction as each orphaned block and cleared in
+// the transaction that adds the first block after the fork
+// point.
+type ReorgIntent struct {
+	// Head is the head block when the reorg began.
+	Head *rosetta.BlockIdentifier
+
+	// Orphaned are the blocks orphaned so far,
+	// in the order they were orphaned.
+	Orphaned []*rosetta.BlockIdentifier
+}
+
+func getReorgIntentKey(hasher KeyHasher) []byte {
+	return hasher.Hash([]byte(reorgIntentKey))
+}
+
+// GetReorgIntent returns the ReorgIntent of the reorg
+// in progress or nil if no reorg is in progress.
+func (b *BlockStorage) GetReorgIntent(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+) (*ReorgIntent, error) {
+	exists, value, err := transaction.Get(ctx, getReorgIntentKey(b.keyHasher))
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, nil
+	}
+
+	var intent ReorgIntent
+	if err := decodeValue(value, &intent); err != nil {
+		return nil, err
+	}
+
+	return &intent, nil
+}
+
+// RecordOrphanedBlock adds an orphaned block to the
+// ReorgIntent, starting a new ReorgIntent if no reorg
+// is in progress.
+func (b *BlockStorage) RecordOrphanedBlock(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	block *rosetta.BlockIdentifier,
+) error {
+	intent, err := b.GetReorgIntent(ctx, transaction)
+	if err != nil {
+		return err
+	}
+
+	if intent == nil {
+		intent = &ReorgIntent{
+			Head: block,
+		}
+	}
+
+	intent.Orphaned = append(intent.Orphaned, block)
+	buf, err := encodeValue(b.codec, intent)
+	if err != nil {
+		return err
+	}
+
+	return transaction.Set(ctx, getReorgIntentKey(b.keyHasher), buf)
+}
+
+// ClearReorgIntent marks the reorg in progress (if any)
+// as complete and returns its ReorgIntent or nil if no
+// reorg was in progress.
+func (b *BlockStorage) ClearReorgIntent(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+) (*ReorgIntent, error) {
+	intent, err := b.GetReorgIntent(ctx, transaction)
+	if err != nil || intent == nil {
+		return nil, err
+	}
+
+	return intent, transaction.Delete(ctx, getReorgIntentKey(b.keyHasher))
+}
+
+// ResumeReorg prepares to resume a reorg that was
+// interrupted (ex: by a restart) and returns its
+// ReorgIntent or nil if no reorg was in progress. Blocks
+// queued after the head block were fetched before the fork
+// point was found and may build on orphaned blocks, so they
+// are discarded to be fetched again.
+func (b *BlockStorage) ResumeReorg(ctx context.Context) (*ReorgIntent, error) {
+	var intent *ReorgIntent
+	err := b.Update(ctx, func(transaction DatabaseTransaction) error {
+		var err error
+		intent, err = b.GetReorgIntent(ctx, transaction)
+		if err != nil || intent == nil {
+			return err
+		}
+
+		head, err := b.GetHeadBlockIdentifier(ctx, transaction)
+		if errors.Is(err, ErrHeadBlockNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for index := head.Index + 1; ; index++ {
+			_, err := b.GetQueuedBlock(ctx, transaction, index)
+			if errors.Is(err, ErrQueuedBlockNotFound) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			if err := b.DequeueBlock(ctx, transaction, index); err != nil {
+				return err
+			}
+		}
+	})
+
+	return intent, err
+}
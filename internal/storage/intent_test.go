@@ -0,0 +1,105 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResumeReorg(t *testing.T) {
+	ctx := context.Background()
+
+	newDir, err := CreateTempDir()
+	assert.NoError(t, err)
+	defer RemoveTempDir(*newDir)
+
+	database, err := NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	storage := NewBlockStorage(ctx, database, &GobCodec{}, &SHA256KeyHasher{})
+	head := &rosetta.BlockIdentifier{
+		Hash:  "1",
+		Index: 1,
+	}
+	orphaned := &rosetta.BlockIdentifier{
+		Hash:  "2",
+		Index: 2,
+	}
+	queued := &rosetta.Block{
+		BlockIdentifier: &rosetta.BlockIdentifier{
+			Hash:  "2b",
+			Index: 2,
+		},
+		ParentBlockIdentifier: head,
+	}
+
+	assert.NoError(t, storage.Update(ctx, func(txn DatabaseTransaction) error {
+		if err := storage.StoreHeadBlockIdentifier(ctx, txn, head); err != nil {
+			return err
+		}
+
+		return storage.QueueBlock(ctx, txn, queued)
+	}))
+
+	queuedBlock := func() error {
+		txn := storage.NewDatabaseTransaction(ctx, false)
+		defer txn.Discard(ctx)
+
+		_, err := storage.GetQueuedBlock(ctx, txn, 2)
+		return err
+	}
+
+	t.Run("No reorg in progress", func(t *testing.T) {
+		intent, err := storage.ResumeReorg(ctx)
+		assert.NoError(t, err)
+		assert.Nil(t, intent)
+		assert.NoError(t, queuedBlock())
+	})
+
+	t.Run("Interrupted reorg", func(t *testing.T) {
+		assert.NoError(t, storage.Update(ctx, func(txn DatabaseTransaction) error {
+			return storage.RecordOrphanedBlock(ctx, txn, orphaned)
+		}))
+
+		intent, err := storage.ResumeReorg(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, &ReorgIntent{
+			Head:     orphaned,
+			Orphaned: []*rosetta.BlockIdentifier{orphaned},
+		}, intent)
+		assert.True(t, errors.Is(queuedBlock(), ErrQueuedBlockNotFound))
+	})
+
+	t.Run("Completed reorg", func(t *testing.T) {
+		var completed *ReorgIntent
+		assert.NoError(t, storage.Update(ctx, func(txn DatabaseTransaction) error {
+			var err error
+			completed, err = storage.ClearReorgIntent(ctx, txn)
+			return err
+		}))
+		assert.Equal(t, orphaned, completed.Head)
+
+		intent, err := storage.ResumeReorg(ctx)
+		assert.NoError(t, err)
+		assert.Nil(t, intent)
+	})
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"container/list"
+	"sync"
+)
+
+const (
+	// keyCacheSize is the maximum number of computed
+	// keys memoized by each keyCache.
+	keyCacheSize = 10000
+)
+
+type keyCacheEntry struct {
+	key   interface{}
+	value interface{}
+}
+
+// keyCache is a bounded LRU of computed storage keys. Keys
+// must be comparable values (not pointers to identifiers
+// that may be modified).
+type keyCache struct {
+	maxSize int
+
+	mutex   sync.Mutex
+	entries map[interface{}]*list.Element
+	order   *list.List
+}
+
+func newKeyCache(maxSize int) *keyCache {
+	return &keyCache{
+		maxSize: maxSize,
+		entries: make(map[interface{}]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get returns the value memoized for key, if any.
+func (c *keyCache) get(key interface{}) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+	return elem.Value.(*keyCacheEntry).value, true
+}
+
+// add memoizes value for key, evicting the least
+// recently used entry if the cache is full.
+func (c *keyCache) add(key interface{}, value interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&keyCacheEntry{key: key, value: value})
+	for c.order.Len() > c.maxSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*keyCacheEntry).key)
+	}
+}
+
+// len returns the number of memoized keys.
+func (c *keyCache) len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.order.Len()
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"testing"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyCache(t *testing.T) {
+	cache := newKeyCache(2)
+	cache.add("a", 1)
+	cache.add("b", 2)
+
+	// Reading "a" makes "b" the least recently used.
+	value, ok := cache.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+
+	cache.add("c", 3)
+	assert.Equal(t, 2, cache.len())
+
+	_, ok = cache.get("b")
+	assert.False(t, ok)
+
+	value, ok = cache.get("c")
+	assert.True(t, ok)
+	assert.Equal(t, 3, value)
+}
+
+func TestMemoizedKeys(t *testing.T) {
+	account := &rosetta.AccountIdentifier{
+		Address: "memoized",
+		SubAccount: &rosetta.SubAccountIdentifier{
+			SubAccount: "sub",
+		},
+	}
+
+	key := getBalanceKey(&SHA256KeyHasher{}, account)
+	assert.Equal(t, key, getBalanceKey(&SHA256KeyHasher{}, account))
+
+	// Accounts are memoized by value, so modifying
+	// an identifier changes its key.
+	account.SubAccount.SubAccount = "other"
+	assert.NotEqual(t, key, getBalanceKey(&SHA256KeyHasher{}, account))
+
+	account.SubAccount = nil
+	assert.Equal(t, hashBytes([]byte(balanceNamespace+":memoized")), getBalanceKey(&SHA256KeyHasher{}, account))
+
+	currency := &rosetta.Currency{
+		Symbol:   "MEMO",
+		Decimals: 8,
+	}
+	assert.Equal(t, hashString("MEMO:8"), GetCurrencyKey(currency))
+	assert.Equal(t, hashString("MEMO:8"), GetCurrencyKey(currency))
+}
+
+func BenchmarkGetBalanceKey(b *testing.B) {
+	account := &rosetta.AccountIdentifier{
+		Address: "hot",
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		getBalanceKey(&SHA256KeyHasher{}, account)
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+)
+
+const (
+	// SHA256KeyHasherName is the name of the SHA256KeyHasher.
+	// This is the default and the key hash of any data stored
+	// before the key hash was configurable.
+	SHA256KeyHasherName = "sha256"
+
+	// FNVKeyHasherName is the name of the FNVKeyHasher.
+	FNVKeyHasherName = "fnv"
+)
+
+var (
+	// ErrUnknownKeyHasher is returned when a key hasher
+	// name or identifier is not recognized.
+	ErrUnknownKeyHasher = errors.New("Unknown key hasher")
+
+	// ErrKeyHasherMismatch is returned when the configured
+	// key hasher differs from the one used to store data.
+	ErrKeyHasherMismatch = errors.New("Key hasher does not match stored data")
+
+	// keySchemaKey stores the identifier of the KeyHasher
+	// used to derive all other keys. It is not hashed and
+	// is shorter than any derived key, so it cannot collide
+	// with one.
+	keySchemaKey = []byte("key-schema")
+)
+
+// KeyHasher derives fixed-size storage keys from
+// arbitrarily large identifiers.
+type KeyHasher interface {
+	// ID identifies the KeyHasher in the key schema
+	// record. It must never change once data has been
+	// stored with the KeyHasher.
+	ID() byte
+	Hash(data []byte) []byte
+}
+
+// SHA256KeyHasher derives keys with SHA256.
+type SHA256KeyHasher struct{}
+
+// ID returns the identifier of the SHA256KeyHasher.
+func (h *SHA256KeyHasher) ID() byte { return 1 }
+
+// Hash returns the SHA256 hash of data.
+func (h *SHA256KeyHasher) Hash(data []byte) []byte {
+	return hashBytes(data)
+}
+
+// FNVKeyHasher derives keys with 128-bit FNV-1a. It is
+// much cheaper than SHA256 but is not cryptographic, so
+// keys could be made to collide by a malicious Rosetta
+// Server.
+type FNVKeyHasher struct{}
+
+// ID returns the identifier of the FNVKeyHasher.
+func (h *FNVKeyHasher) ID() byte { return 2 }
+
+// Hash returns the 128-bit FNV-1a hash of data.
+func (h *FNVKeyHasher) Hash(data []byte) []byte {
+	hasher := fnv.New128a()
+	_, _ = hasher.Write(data)
+	return hasher.Sum(nil)
+}
+
+var keyHashers = map[string]KeyHasher{
+	SHA256KeyHasherName: &SHA256KeyHasher{},
+	FNVKeyHasherName:    &FNVKeyHasher{},
+}
+
+// NewKeyHasher returns the KeyHasher with the provided name.
+func NewKeyHasher(name string) (KeyHasher, error) {
+	hasher, ok := keyHashers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownKeyHasher, name)
+	}
+
+	return hasher, nil
+}
+
+// keyHasherName returns the name of the KeyHasher
+// with the provided identifier.
+func keyHasherName(id byte) string {
+	for name, hasher := range keyHashers {
+		if hasher.ID() == id {
+			return name
+		}
+	}
+
+	return fmt.Sprintf("unknown (%d)", id)
+}
+
+// InitializeKeySchema records the KeyHasher used by
+// BlockStorage in a new Database or returns an error if
+// the Database was populated with a different KeyHasher.
+// Data stored before the key hash was configurable is
+// recognized as SHA256 by the presence of a head block.
+func (b *BlockStorage) InitializeKeySchema(ctx context.Context) error {
+	return b.Update(ctx, func(transaction DatabaseTransaction) error {
+		storedID, exists, err := b.checkKeySchema(ctx, transaction)
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			return nil
+		}
+
+		return transaction.Set(ctx, keySchemaKey, []byte{storedID})
+	})
+}
+
+// CheckKeySchema returns an error if the Database was
+// populated with a different KeyHasher than the one used
+// by BlockStorage. Unlike InitializeKeySchema, it does not
+// write to the Database (ex: when it is opened read-only).
+func (b *BlockStorage) CheckKeySchema(ctx context.Context) error {
+	transaction := b.db.NewDatabaseTransaction(ctx, false)
+	defer transaction.Discard(ctx)
+
+	_, _, err := b.checkKeySchema(ctx, transaction)
+	return err
+}
+
+// checkKeySchema returns the identifier of the KeyHasher
+// the Database was populated with (or of the KeyHasher used
+// by BlockStorage if it is empty) and whether it is recorded.
+func (b *BlockStorage) checkKeySchema(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+) (byte, bool, error) {
+	exists, value, err := transaction.Get(ctx, keySchemaKey)
+	if err != nil {
+		return 0, false, err
+	}
+
+	storedID := b.keyHasher.ID()
+	switch {
+	case exists && len(value) == 1:
+		storedID = value[0]
+	case exists:
+		return 0, false, fmt.Errorf("%w: invalid key schema %x", ErrUnknownKeyHasher, value)
+	default:
+		legacyHasher := &SHA256KeyHasher{}
+		legacy, _, err := transaction.Get(ctx, getHeadBlockKey(legacyHasher))
+		if err != nil {
+			return 0, false, err
+		}
+
+		if legacy {
+			storedID = legacyHasher.ID()
+		}
+	}
+
+	if storedID != b.keyHasher.ID() {
+		return 0, false, fmt.Errorf(
+			"%w: data stored with %s",
+			ErrKeyHasherMismatch,
+			keyHasherName(storedID),
+		)
+	}
+
+	return storedID, exists, nil
+}
@@ -0,0 +1,125 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewKeyHasher(t *testing.T) {
+	sha, err := NewKeyHasher(SHA256KeyHasherName)
+	assert.NoError(t, err)
+	assert.Equal(t, hashBytes([]byte("key")), sha.Hash([]byte("key")))
+
+	fnv, err := NewKeyHasher(FNVKeyHasherName)
+	assert.NoError(t, err)
+	assert.Len(t, fnv.Hash([]byte("key")), 16)
+	assert.Equal(t, fnv.Hash([]byte("key")), fnv.Hash([]byte("key")))
+	assert.NotEqual(t, fnv.Hash([]byte("key")), fnv.Hash([]byte("other")))
+	assert.NotEqual(t, sha.ID(), fnv.ID())
+
+	_, err = NewKeyHasher("md5")
+	assert.True(t, errors.Is(err, ErrUnknownKeyHasher))
+}
+
+func TestInitializeKeySchema(t *testing.T) {
+	ctx := context.Background()
+	head := &rosetta.BlockIdentifier{
+		Hash:  "head",
+		Index: 1,
+	}
+
+	newStorage := func(t *testing.T) (Database, func()) {
+		newDir, err := CreateTempDir()
+		assert.NoError(t, err)
+
+		database, err := NewBadgerStorage(ctx, *newDir)
+		assert.NoError(t, err)
+
+		return database, func() {
+			database.Close(ctx)
+			RemoveTempDir(*newDir)
+		}
+	}
+
+	t.Run("New database", func(t *testing.T) {
+		database, cleanup := newStorage(t)
+		defer cleanup()
+
+		fnvStorage := NewBlockStorage(ctx, database, &GobCodec{}, &FNVKeyHasher{})
+		assert.NoError(t, fnvStorage.InitializeKeySchema(ctx))
+		assert.NoError(t, fnvStorage.InitializeKeySchema(ctx))
+
+		shaStorage := NewBlockStorage(ctx, database, &GobCodec{}, &SHA256KeyHasher{})
+		assert.True(t, errors.Is(shaStorage.InitializeKeySchema(ctx), ErrKeyHasherMismatch))
+		assert.True(t, errors.Is(shaStorage.CheckKeySchema(ctx), ErrKeyHasherMismatch))
+		assert.NoError(t, fnvStorage.CheckKeySchema(ctx))
+	})
+
+	t.Run("Check does not record schema", func(t *testing.T) {
+		database, cleanup := newStorage(t)
+		defer cleanup()
+
+		fnvStorage := NewBlockStorage(ctx, database, &GobCodec{}, &FNVKeyHasher{})
+		assert.NoError(t, fnvStorage.CheckKeySchema(ctx))
+
+		exists, _, err := database.Get(ctx, keySchemaKey)
+		assert.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("Legacy database", func(t *testing.T) {
+		database, cleanup := newStorage(t)
+		defer cleanup()
+
+		shaStorage := NewBlockStorage(ctx, database, &GobCodec{}, &SHA256KeyHasher{})
+		txn := shaStorage.NewDatabaseTransaction(ctx, true)
+		assert.NoError(t, shaStorage.StoreHeadBlockIdentifier(ctx, txn, head))
+		assert.NoError(t, txn.Commit(ctx))
+
+		fnvStorage := NewBlockStorage(ctx, database, &GobCodec{}, &FNVKeyHasher{})
+		assert.True(t, errors.Is(fnvStorage.InitializeKeySchema(ctx), ErrKeyHasherMismatch))
+		assert.NoError(t, shaStorage.InitializeKeySchema(ctx))
+	})
+
+	t.Run("FNV keys", func(t *testing.T) {
+		database, cleanup := newStorage(t)
+		defer cleanup()
+
+		fnvStorage := NewBlockStorage(ctx, database, &GobCodec{}, &FNVKeyHasher{})
+		assert.NoError(t, fnvStorage.InitializeKeySchema(ctx))
+
+		txn := fnvStorage.NewDatabaseTransaction(ctx, true)
+		assert.NoError(t, fnvStorage.StoreHeadBlockIdentifier(ctx, txn, head))
+		assert.NoError(t, txn.Commit(ctx))
+
+		txn = fnvStorage.NewDatabaseTransaction(ctx, false)
+		defer txn.Discard(ctx)
+
+		stored, err := fnvStorage.GetHeadBlockIdentifier(ctx, txn)
+		assert.NoError(t, err)
+		assert.Equal(t, head, stored)
+
+		exists, _, err := txn.Get(ctx, getHeadBlockKey(&SHA256KeyHasher{}))
+		assert.NoError(t, err)
+		assert.False(t, exists)
+	})
+}
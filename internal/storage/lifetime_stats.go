@@ -0,0 +1,162 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+const (
+	// lifetimeStatsKey is used to lookup the LifetimeStats
+	// recorded before each statistic had its own counter.
+	// They are never updated but are included in the
+	// LifetimeStats returned by GetLifetimeStats.
+	lifetimeStatsKey = "lifetime-stats"
+
+	// Each statistic in the LifetimeStats is stored under
+	// its own counter so that the transactions updating
+	// different statistics (ex: the syncer adding blocks and
+	// the logger recording reconciliations) never conflict.
+	blocksSyncedCounter    = "blocks-synced"
+	blocksOrphanedCounter  = "blocks-orphaned"
+	reconciliationsCounter = "reconciliations"
+	restartsCounter        = "restarts"
+)
+
+// LifetimeStats are cumulative statistics of every run of
+// the validator against a database (unlike the statistics
+// of a single run, which are reset by each restart).
+type LifetimeStats struct {
+	BlocksSynced    int64 `json:"blocks_synced"`
+	BlocksOrphaned  int64 `json:"blocks_orphaned"`
+	Reconciliations int64 `json:"reconciliations"`
+	Restarts        int64 `json:"restarts"`
+}
+
+// String returns a summary of the LifetimeStats.
+func (s *LifetimeStats) String() string {
+	return fmt.Sprintf(
+		"%d blocks synced, %d blocks orphaned, %d reconciliations, %d restarts",
+		s.BlocksSynced,
+		s.BlocksOrphaned,
+		s.Reconciliations,
+		s.Restarts,
+	)
+}
+
+// counters returns a pointer to each statistic
+// keyed by the name of its counter.
+func (s *LifetimeStats) counters() map[string]*int64 {
+	return map[string]*int64{
+		blocksSyncedCounter:    &s.BlocksSynced,
+		blocksOrphanedCounter:  &s.BlocksOrphaned,
+		reconciliationsCounter: &s.Reconciliations,
+		restartsCounter:        &s.Restarts,
+	}
+}
+
+func getLifetimeStatsKey(hasher KeyHasher) []byte {
+	return hasher.Hash([]byte(lifetimeStatsKey))
+}
+
+func getLifetimeCounterKey(hasher KeyHasher, counter string) []byte {
+	return hasher.Hash([]byte(fmt.Sprintf("%s:%s", lifetimeStatsKey, counter)))
+}
+
+// lifetimeCounter returns the value of a counter
+// (0 if it has not been recorded).
+func (b *BlockStorage) lifetimeCounter(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	counter string,
+) (int64, error) {
+	exists, value, err := transaction.Get(ctx, getLifetimeCounterKey(b.keyHasher, counter))
+	if err != nil {
+		return 0, err
+	}
+
+	if !exists {
+		return 0, nil
+	}
+
+	return strconv.ParseInt(string(value), 10, 64)
+}
+
+// GetLifetimeStats returns the LifetimeStats (all zero
+// if none have been recorded).
+func (b *BlockStorage) GetLifetimeStats(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+) (*LifetimeStats, error) {
+	stats := &LifetimeStats{}
+	exists, value, err := transaction.Get(ctx, getLifetimeStatsKey(b.keyHasher))
+	if err != nil {
+		return nil, err
+	}
+
+	if exists {
+		if err := decodeValue(value, stats); err != nil {
+			return nil, err
+		}
+	}
+
+	for counter, stat := range stats.counters() {
+		count, err := b.lifetimeCounter(ctx, transaction, counter)
+		if err != nil {
+			return nil, err
+		}
+
+		*stat += count
+	}
+
+	return stats, nil
+}
+
+// AddLifetimeStats adds delta to the LifetimeStats. Only
+// the counters of the non-zero statistics in delta are
+// read and written, so transactions adding different
+// statistics do not conflict. Each statistic should only
+// be added by one goroutine (ex: blocks are only added by
+// the syncer) because a transaction committed in chunks
+// overwrites the counters it read without detecting
+// conflicts.
+func (b *BlockStorage) AddLifetimeStats(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	delta *LifetimeStats,
+) error {
+	for counter, stat := range delta.counters() {
+		if *stat == 0 {
+			continue
+		}
+
+		count, err := b.lifetimeCounter(ctx, transaction, counter)
+		if err != nil {
+			return err
+		}
+
+		if err := transaction.Set(
+			ctx,
+			getLifetimeCounterKey(b.keyHasher, counter),
+			[]byte(strconv.FormatInt(count+*stat, 10)),
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
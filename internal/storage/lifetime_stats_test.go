@@ -0,0 +1,113 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLifetimeStats(t *testing.T) {
+	ctx := context.Background()
+
+	newDir, err := CreateTempDir()
+	assert.NoError(t, err)
+	defer RemoveTempDir(*newDir)
+
+	database, err := NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+
+	storage := NewBlockStorage(ctx, database, &GobCodec{}, &SHA256KeyHasher{})
+	txn := storage.NewDatabaseTransaction(ctx, false)
+	stats, err := storage.GetLifetimeStats(ctx, txn)
+	txn.Discard(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &LifetimeStats{}, stats)
+
+	for _, delta := range []*LifetimeStats{
+		{BlocksSynced: 1},
+		{BlocksSynced: 1},
+		{BlocksOrphaned: 1},
+		{Reconciliations: 5, Restarts: 1},
+	} {
+		assert.NoError(t, storage.Update(ctx, func(txn DatabaseTransaction) error {
+			return storage.AddLifetimeStats(ctx, txn, delta)
+		}))
+	}
+
+	// The LifetimeStats are retained when the
+	// database is reopened.
+	assert.NoError(t, database.Close(ctx))
+	database, err = NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	storage = NewBlockStorage(ctx, database, &GobCodec{}, &SHA256KeyHasher{})
+	txn = storage.NewDatabaseTransaction(ctx, false)
+	defer txn.Discard(ctx)
+	stats, err = storage.GetLifetimeStats(ctx, txn)
+	assert.NoError(t, err)
+	assert.Equal(t, &LifetimeStats{
+		BlocksSynced:    2,
+		BlocksOrphaned:  1,
+		Reconciliations: 5,
+		Restarts:        1,
+	}, stats)
+	assert.Equal(t, "2 blocks synced, 1 blocks orphaned, 5 reconciliations, 1 restarts", stats.String())
+}
+
+func TestLifetimeStatsConflicts(t *testing.T) {
+	ctx := context.Background()
+
+	newDir, err := CreateTempDir()
+	assert.NoError(t, err)
+	defer RemoveTempDir(*newDir)
+
+	database, err := NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	storage := NewBlockStorage(ctx, database, &GobCodec{}, &SHA256KeyHasher{})
+
+	// LifetimeStats recorded before each statistic had
+	// its own counter are included.
+	legacy, err := encodeValue(storage.codec, &LifetimeStats{BlocksSynced: 10, Restarts: 2})
+	assert.NoError(t, err)
+	assert.NoError(t, storage.Update(ctx, func(txn DatabaseTransaction) error {
+		return txn.Set(ctx, getLifetimeStatsKey(storage.keyHasher), legacy)
+	}))
+
+	// Adding different statistics in concurrent
+	// transactions does not conflict.
+	blockTxn := storage.NewDatabaseTransaction(ctx, true)
+	assert.NoError(t, storage.AddLifetimeStats(ctx, blockTxn, &LifetimeStats{BlocksSynced: 1}))
+
+	reconcileTxn := storage.NewDatabaseTransaction(ctx, true)
+	assert.NoError(t, storage.AddLifetimeStats(ctx, reconcileTxn, &LifetimeStats{Reconciliations: 3}))
+	assert.NoError(t, reconcileTxn.Commit(ctx))
+	assert.NoError(t, blockTxn.Commit(ctx))
+
+	txn := storage.NewDatabaseTransaction(ctx, false)
+	defer txn.Discard(ctx)
+	stats, err := storage.GetLifetimeStats(ctx, txn)
+	assert.NoError(t, err)
+	assert.Equal(t, &LifetimeStats{
+		BlocksSynced:    11,
+		Reconciliations: 3,
+		Restarts:        2,
+	}, stats)
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// lockFile is the file in a directory that
+// is locked by the process holding its DirLock.
+const lockFile = "validator.lock"
+
+// ErrDirLocked is returned when a directory is
+// locked by another running process.
+var ErrDirLocked = errors.New("Directory is locked")
+
+// DirLock prevents more than one process from using
+// a directory (ex: two validators sharing DATA_DIR)
+// by holding an exclusive flock on a lock file. The
+// operating system releases the flock when the process
+// exits, so a lock is never left behind by a crash.
+type DirLock struct {
+	path string
+	file *os.File
+}
+
+// LockDir acquires the DirLock of dir. The pid of the
+// process holding the lock is written to the lock file
+// so that it can be reported to other processes, but
+// it is never used to decide if the lock is held.
+func LockDir(dir string) (*DirLock, error) {
+	path := filepath.Join(dir, lockFile)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, err
+		}
+
+		if pid := lockOwner(path); pid > 0 {
+			return nil, fmt.Errorf("%w by process %d", ErrDirLocked, pid)
+		}
+
+		return nil, ErrDirLocked
+	}
+
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if _, err := f.WriteString(strconv.Itoa(os.Getpid())); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &DirLock{path: path, file: f}, nil
+}
+
+// Path returns the path of the lock file.
+func (l *DirLock) Path() string {
+	return l.path
+}
+
+// Unlock releases the DirLock. The lock file is not
+// removed because another process may have opened it
+// and be waiting to lock it.
+func (l *DirLock) Unlock() error {
+	return l.file.Close()
+}
+
+// lockOwner returns the pid recorded in the lock file
+// at path (or 0 if it is empty or unreadable, as when
+// the process holding it has not yet recorded its pid).
+func lockOwner(path string) int {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return 0
+	}
+
+	return pid
+}
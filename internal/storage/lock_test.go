@@ -0,0 +1,70 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockDir(t *testing.T) {
+	newDir, err := CreateTempDir()
+	assert.NoError(t, err)
+	defer RemoveTempDir(*newDir)
+
+	path := filepath.Join(*newDir, lockFile)
+
+	t.Run("Lock and unlock", func(t *testing.T) {
+		lock, err := LockDir(*newDir)
+		assert.NoError(t, err)
+
+		contents, err := ioutil.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, strconv.Itoa(os.Getpid()), string(contents))
+
+		assert.NoError(t, lock.Unlock())
+
+		lock, err = LockDir(*newDir)
+		assert.NoError(t, err)
+		assert.NoError(t, lock.Unlock())
+	})
+
+	t.Run("Locked by running process", func(t *testing.T) {
+		lock, err := LockDir(*newDir)
+		assert.NoError(t, err)
+		defer lock.Unlock()
+
+		otherLock, err := LockDir(*newDir)
+		assert.True(t, errors.Is(err, ErrDirLocked))
+		assert.Nil(t, otherLock)
+	})
+
+	t.Run("Stale lock", func(t *testing.T) {
+		// A lock file left behind by a process that is no
+		// longer running is not locked, even if its pid has
+		// been reused (ex: pid 1 in a container).
+		assert.NoError(t, ioutil.WriteFile(path, []byte("1"), 0600))
+
+		lock, err := LockDir(*newDir)
+		assert.NoError(t, err)
+		assert.NoError(t, lock.Unlock())
+	})
+}
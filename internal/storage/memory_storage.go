@@ -0,0 +1,196 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrTransactionReadOnly is returned when a value is
+// set or deleted in a read-only transaction.
+var ErrTransactionReadOnly = errors.New("Transaction is read-only")
+
+// memoryValue is a value in a MemoryStorage and the
+// sequence number of the commit that last changed it.
+type memoryValue struct {
+	value    []byte
+	deleted  bool
+	sequence uint64
+}
+
+// MemoryStorage is an in-memory implementation of the
+// Database interface (ex: for ephemeral validation that
+// shouldn't write to disk). Like BadgerStorage, a write
+// transaction fails to commit with ErrTransactionConflict
+// if a key it read was changed after it was created.
+type MemoryStorage struct {
+	mutex    sync.RWMutex
+	values   map[string]*memoryValue
+	sequence uint64
+}
+
+// NewMemoryStorage returns a new, empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		values: map[string]*memoryValue{},
+	}
+}
+
+// Close discards the contents of the MemoryStorage.
+func (m *MemoryStorage) Close(ctx context.Context) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.values = map[string]*memoryValue{}
+	return nil
+}
+
+// get returns the value of key. It must be
+// called with the mutex held.
+func (m *MemoryStorage) get(key string) (bool, []byte) {
+	v, ok := m.values[key]
+	if !ok || v.deleted {
+		return false, nil
+	}
+
+	value := make([]byte, len(v.value))
+	copy(value, v.value)
+	return true, value
+}
+
+// Set changes the value of the key to the value in its own transaction.
+func (m *MemoryStorage) Set(ctx context.Context, key []byte, value []byte) error {
+	txn := m.NewDatabaseTransaction(ctx, true)
+	defer txn.Discard(ctx)
+
+	if err := txn.Set(ctx, key, value); err != nil {
+		return err
+	}
+
+	return txn.Commit(ctx)
+}
+
+// Get fetches the value of a key in its own transaction.
+func (m *MemoryStorage) Get(ctx context.Context, key []byte) (bool, []byte, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	exists, value := m.get(string(key))
+	return exists, value, nil
+}
+
+// NewDatabaseTransaction creates a new MemoryTransaction.
+func (m *MemoryStorage) NewDatabaseTransaction(
+	ctx context.Context,
+	write bool,
+) DatabaseTransaction {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return &MemoryTransaction{
+		storage:  m,
+		write:    write,
+		sequence: m.sequence,
+		reads:    map[string]struct{}{},
+		writes:   map[string]*memoryValue{},
+	}
+}
+
+// MemoryTransaction is a transaction of a MemoryStorage.
+// Its writes are buffered until it is committed.
+type MemoryTransaction struct {
+	storage  *MemoryStorage
+	write    bool
+	sequence uint64
+	reads    map[string]struct{}
+	writes   map[string]*memoryValue
+}
+
+// Set changes the value of the key to the value within a transaction.
+func (t *MemoryTransaction) Set(ctx context.Context, key []byte, value []byte) error {
+	if !t.write {
+		return ErrTransactionReadOnly
+	}
+
+	stored := make([]byte, len(value))
+	copy(stored, value)
+	t.writes[string(key)] = &memoryValue{value: stored}
+	return nil
+}
+
+// Get accesses the value of the key within a transaction.
+func (t *MemoryTransaction) Get(ctx context.Context, key []byte) (bool, []byte, error) {
+	if v, ok := t.writes[string(key)]; ok {
+		if v.deleted {
+			return false, nil, nil
+		}
+
+		value := make([]byte, len(v.value))
+		copy(value, v.value)
+		return true, value, nil
+	}
+
+	t.reads[string(key)] = struct{}{}
+
+	t.storage.mutex.RLock()
+	defer t.storage.mutex.RUnlock()
+
+	exists, value := t.storage.get(string(key))
+	return exists, value, nil
+}
+
+// Delete removes the key and its value within the transaction.
+func (t *MemoryTransaction) Delete(ctx context.Context, key []byte) error {
+	if !t.write {
+		return ErrTransactionReadOnly
+	}
+
+	t.writes[string(key)] = &memoryValue{deleted: true}
+	return nil
+}
+
+// Commit applies the writes of the transaction. If a
+// concurrent transaction changed a key read by the
+// transaction, ErrTransactionConflict is returned.
+func (t *MemoryTransaction) Commit(ctx context.Context) error {
+	if len(t.writes) == 0 {
+		return nil
+	}
+
+	t.storage.mutex.Lock()
+	defer t.storage.mutex.Unlock()
+
+	for key := range t.reads {
+		if v, ok := t.storage.values[key]; ok && v.sequence > t.sequence {
+			return ErrTransactionConflict
+		}
+	}
+
+	t.storage.sequence++
+	for key, v := range t.writes {
+		v.sequence = t.storage.sequence
+		t.storage.values[key] = v
+	}
+	t.writes = map[string]*memoryValue{}
+
+	return nil
+}
+
+// Discard discards the writes of the transaction.
+func (t *MemoryTransaction) Discard(ctx context.Context) {
+	t.writes = map[string]*memoryValue{}
+}
@@ -0,0 +1,111 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	ctx := context.Background()
+
+	database := NewMemoryStorage()
+	defer database.Close(ctx)
+
+	t.Run("No key exists", func(t *testing.T) {
+		exists, value, err := database.Get(ctx, []byte("hello"))
+		assert.False(t, exists)
+		assert.Nil(t, value)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Set and get within a transaction", func(t *testing.T) {
+		txn := database.NewDatabaseTransaction(ctx, true)
+		assert.NoError(t, txn.Set(ctx, []byte("hello"), []byte("hola")))
+
+		exists, value, err := txn.Get(ctx, []byte("hello"))
+		assert.True(t, exists)
+		assert.Equal(t, []byte("hola"), value)
+		assert.NoError(t, err)
+
+		// Ensure tx does not affect db
+		exists, value, err = database.Get(ctx, []byte("hello"))
+		assert.False(t, exists)
+		assert.Nil(t, value)
+		assert.NoError(t, err)
+
+		assert.NoError(t, txn.Commit(ctx))
+
+		exists, value, err = database.Get(ctx, []byte("hello"))
+		assert.True(t, exists)
+		assert.Equal(t, []byte("hola"), value)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Discard transaction", func(t *testing.T) {
+		txn := database.NewDatabaseTransaction(ctx, true)
+		assert.NoError(t, txn.Set(ctx, []byte("hello"), []byte("world")))
+
+		txn.Discard(ctx)
+
+		exists, value, err := database.Get(ctx, []byte("hello"))
+		assert.True(t, exists)
+		assert.Equal(t, []byte("hola"), value)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Write in read-only transaction", func(t *testing.T) {
+		txn := database.NewDatabaseTransaction(ctx, false)
+		defer txn.Discard(ctx)
+
+		assert.True(t, errors.Is(txn.Set(ctx, []byte("hello"), []byte("world")), ErrTransactionReadOnly))
+		assert.True(t, errors.Is(txn.Delete(ctx, []byte("hello")), ErrTransactionReadOnly))
+	})
+
+	t.Run("Conflict detected", func(t *testing.T) {
+		txn := database.NewDatabaseTransaction(ctx, true)
+		_, _, err := txn.Get(ctx, []byte("hello"))
+		assert.NoError(t, err)
+
+		assert.NoError(t, database.Set(ctx, []byte("hello"), []byte("bonjour")))
+
+		assert.NoError(t, txn.Set(ctx, []byte("hello"), []byte("world")))
+		assert.True(t, errors.Is(txn.Commit(ctx), ErrTransactionConflict))
+
+		_, value, err := database.Get(ctx, []byte("hello"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("bonjour"), value)
+	})
+
+	t.Run("Delete within a transaction", func(t *testing.T) {
+		txn := database.NewDatabaseTransaction(ctx, true)
+		assert.NoError(t, txn.Delete(ctx, []byte("hello")))
+
+		exists, _, err := txn.Get(ctx, []byte("hello"))
+		assert.False(t, exists)
+		assert.NoError(t, err)
+
+		assert.NoError(t, txn.Commit(ctx))
+
+		exists, value, err := database.Get(ctx, []byte("hello"))
+		assert.False(t, exists)
+		assert.Nil(t, value)
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/coinbase/rosetta-validator/internal/metrics"
+)
+
+// MeteredStorage wraps a Database and records
+// transaction commit metrics to a metrics.Sink.
+type MeteredStorage struct {
+	Database
+
+	sink metrics.Sink
+}
+
+// NewMeteredStorage returns a new MeteredStorage.
+func NewMeteredStorage(db Database, sink metrics.Sink) *MeteredStorage {
+	return &MeteredStorage{
+		Database: db,
+		sink:     sink,
+	}
+}
+
+// NewDatabaseTransaction returns a DatabaseTransaction
+// whose commits are metered.
+func (m *MeteredStorage) NewDatabaseTransaction(
+	ctx context.Context,
+	write bool,
+) DatabaseTransaction {
+	return &meteredTransaction{
+		DatabaseTransaction: m.Database.NewDatabaseTransaction(ctx, write),
+		sink:                m.sink,
+	}
+}
+
+// GarbageCollect garbage collects the wrapped
+// Database, if it is a GarbageCollector.
+func (m *MeteredStorage) GarbageCollect(ctx context.Context) error {
+	collector, ok := m.Database.(GarbageCollector)
+	if !ok {
+		return nil
+	}
+
+	return collector.GarbageCollect(ctx)
+}
+
+type meteredTransaction struct {
+	DatabaseTransaction
+
+	sink metrics.Sink
+}
+
+// Commit commits the wrapped transaction and
+// records its latency and any error.
+func (t *meteredTransaction) Commit(ctx context.Context) error {
+	start := time.Now()
+	err := t.DatabaseTransaction.Commit(ctx)
+	t.sink.ObserveHistogram(metrics.StorageCommitSeconds, time.Since(start).Seconds())
+	if errors.Is(err, ErrTransactionConflict) {
+		t.sink.IncrCounter(metrics.StorageCommitConflicts, 1)
+	} else if err != nil {
+		t.sink.IncrCounter(metrics.StorageCommitErrors, 1)
+	}
+
+	return err
+}
+
+// ReadValue reads the value of key from the wrapped
+// transaction without copying it, if it supports it.
+func (t *meteredTransaction) ReadValue(
+	ctx context.Context,
+	key []byte,
+	fn func([]byte) error,
+) (bool, error) {
+	return readValue(ctx, t.DatabaseTransaction, key, fn)
+}
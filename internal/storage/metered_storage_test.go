@@ -0,0 +1,51 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coinbase/rosetta-validator/internal/metrics"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMeteredStorage(t *testing.T) {
+	ctx := context.Background()
+
+	newDir, err := CreateTempDir()
+	assert.NoError(t, err)
+	defer RemoveTempDir(*newDir)
+
+	database, err := NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	sink := metrics.NewPrometheusSink("")
+	metered := NewMeteredStorage(database, sink)
+
+	txn := metered.NewDatabaseTransaction(ctx, true)
+	assert.NoError(t, txn.Set(ctx, []byte("key"), []byte("value")))
+	assert.NoError(t, txn.Commit(ctx))
+
+	exists, value, err := metered.Get(ctx, []byte("key"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, []byte("value"), value)
+
+	assert.Contains(t, string(sink.Render()), "storage_commit_seconds_count 1\n")
+	assert.NotContains(t, string(sink.Render()), metrics.StorageCommitErrors)
+}
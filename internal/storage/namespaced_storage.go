@@ -0,0 +1,110 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+)
+
+// NamespacedStorage wraps a Database so that multiple
+// BlockStorages (ex: one per sub-network) can share it
+// without their keys colliding. Every key is prefixed
+// with the namespace.
+type NamespacedStorage struct {
+	Database
+
+	namespace []byte
+}
+
+// NewNamespacedStorage returns a new NamespacedStorage.
+func NewNamespacedStorage(db Database, namespace string) *NamespacedStorage {
+	return &NamespacedStorage{
+		Database:  db,
+		namespace: []byte(namespace + "/"),
+	}
+}
+
+// key returns key prefixed with the namespace.
+func (n *NamespacedStorage) key(key []byte) []byte {
+	namespaced := make([]byte, 0, len(n.namespace)+len(key))
+	namespaced = append(namespaced, n.namespace...)
+	return append(namespaced, key...)
+}
+
+// NewDatabaseTransaction returns a DatabaseTransaction
+// whose keys are prefixed with the namespace.
+func (n *NamespacedStorage) NewDatabaseTransaction(
+	ctx context.Context,
+	write bool,
+) DatabaseTransaction {
+	return &namespacedTransaction{
+		DatabaseTransaction: n.Database.NewDatabaseTransaction(ctx, write),
+		storage:             n,
+	}
+}
+
+// Set stores value at key in the namespace.
+func (n *NamespacedStorage) Set(ctx context.Context, key []byte, value []byte) error {
+	return n.Database.Set(ctx, n.key(key), value)
+}
+
+// Get returns the value at key in the namespace.
+func (n *NamespacedStorage) Get(ctx context.Context, key []byte) (bool, []byte, error) {
+	return n.Database.Get(ctx, n.key(key))
+}
+
+// GarbageCollect garbage collects the wrapped
+// Database, if it is a GarbageCollector. This
+// reclaims space in every namespace.
+func (n *NamespacedStorage) GarbageCollect(ctx context.Context) error {
+	collector, ok := n.Database.(GarbageCollector)
+	if !ok {
+		return nil
+	}
+
+	return collector.GarbageCollect(ctx)
+}
+
+type namespacedTransaction struct {
+	DatabaseTransaction
+
+	storage *NamespacedStorage
+}
+
+// Set stores value at key in the namespace.
+func (t *namespacedTransaction) Set(ctx context.Context, key []byte, value []byte) error {
+	return t.DatabaseTransaction.Set(ctx, t.storage.key(key), value)
+}
+
+// Get returns the value at key in the namespace.
+func (t *namespacedTransaction) Get(ctx context.Context, key []byte) (bool, []byte, error) {
+	return t.DatabaseTransaction.Get(ctx, t.storage.key(key))
+}
+
+// ReadValue reads the value at key in the namespace
+// without copying it, if the wrapped transaction
+// supports it.
+func (t *namespacedTransaction) ReadValue(
+	ctx context.Context,
+	key []byte,
+	fn func([]byte) error,
+) (bool, error) {
+	return readValue(ctx, t.DatabaseTransaction, t.storage.key(key), fn)
+}
+
+// Delete deletes key in the namespace.
+func (t *namespacedTransaction) Delete(ctx context.Context, key []byte) error {
+	return t.DatabaseTransaction.Delete(ctx, t.storage.key(key))
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespacedStorage(t *testing.T) {
+	ctx := context.Background()
+
+	newDir, err := CreateTempDir()
+	assert.NoError(t, err)
+	defer RemoveTempDir(*newDir)
+
+	database, err := NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	shard1 := NewNamespacedStorage(database, "shard 1")
+	shard2 := NewNamespacedStorage(database, "shard 2")
+
+	t.Run("Keys are isolated", func(t *testing.T) {
+		assert.NoError(t, database.Set(ctx, []byte("key"), []byte("root")))
+		assert.NoError(t, shard1.Set(ctx, []byte("key"), []byte("shard 1")))
+
+		txn := shard2.NewDatabaseTransaction(ctx, true)
+		exists, _, err := txn.Get(ctx, []byte("key"))
+		assert.NoError(t, err)
+		assert.False(t, exists)
+		assert.NoError(t, txn.Set(ctx, []byte("key"), []byte("shard 2")))
+		assert.NoError(t, txn.Commit(ctx))
+
+		for db, expected := range map[Database]string{
+			database: "root",
+			shard1:   "shard 1",
+			shard2:   "shard 2",
+		} {
+			exists, value, err := db.Get(ctx, []byte("key"))
+			assert.NoError(t, err)
+			assert.True(t, exists)
+			assert.Equal(t, []byte(expected), value)
+		}
+
+		exists, value, err := database.Get(ctx, []byte("shard 1/key"))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, []byte("shard 1"), value)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		txn := shard1.NewDatabaseTransaction(ctx, true)
+		assert.NoError(t, txn.Delete(ctx, []byte("key")))
+		assert.NoError(t, txn.Commit(ctx))
+
+		exists, _, err := shard1.Get(ctx, []byte("key"))
+		assert.NoError(t, err)
+		assert.False(t, exists)
+
+		exists, _, err = shard2.Get(ctx, []byte("key"))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+	})
+}
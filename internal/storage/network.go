@@ -0,0 +1,167 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+const (
+	// networkKey is used to lookup the network
+	// whose data is stored in BlockStorage.
+	networkKey = "network"
+
+	// networkStatusKey is used to lookup the network
+	// status (and options) of the Rosetta Server when
+	// the validator last started.
+	networkStatusKey = "network-status"
+)
+
+var (
+	// ErrNetworkMismatch is returned when the network of the
+	// Rosetta Server differs from the one used to store data.
+	ErrNetworkMismatch = errors.New("Network does not match stored data")
+
+	// ErrNetworkStatusNotFound is returned by GetNetworkStatus
+	// if no network status has been stored.
+	ErrNetworkStatusNotFound = errors.New("Network status not found")
+)
+
+func getNetworkKey(hasher KeyHasher) []byte {
+	return hasher.Hash([]byte(networkKey))
+}
+
+func getNetworkStatusKey(hasher KeyHasher) []byte {
+	return hasher.Hash([]byte(networkStatusKey))
+}
+
+// InitializeNetwork records the network whose data is
+// stored in a new Database or returns an error if the
+// Database was populated with data of a different
+// network. Data stored before the network was recorded
+// is assumed to be of network.
+func (b *BlockStorage) InitializeNetwork(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+) error {
+	return b.Update(ctx, func(transaction DatabaseTransaction) error {
+		exists, value, err := transaction.Get(ctx, getNetworkKey(b.keyHasher))
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			var stored rosetta.NetworkIdentifier
+			if err := decodeValue(value, &stored); err != nil {
+				return err
+			}
+
+			if networkName(&stored) != networkName(network) {
+				return fmt.Errorf(
+					"%w: data stored for %s",
+					ErrNetworkMismatch,
+					networkName(&stored),
+				)
+			}
+
+			return nil
+		}
+
+		buf, err := encodeValue(b.codec, network)
+		if err != nil {
+			return err
+		}
+
+		return transaction.Set(ctx, getNetworkKey(b.keyHasher), buf)
+	})
+}
+
+// networkName identifies a network by its blockchain,
+// network, and sub-network (ignoring metadata).
+func networkName(network *rosetta.NetworkIdentifier) string {
+	name := fmt.Sprintf("%s/%s", network.Blockchain, network.Network)
+	if network.SubNetworkIdentifier != nil {
+		name = fmt.Sprintf("%s/%s", name, network.SubNetworkIdentifier.SubNetwork)
+	}
+
+	return name
+}
+
+// StoreNetworkStatus stores the network status (and
+// options) of the Rosetta Server so that stored blocks
+// can be asserted without it (see GetNetworkStatus).
+func (b *BlockStorage) StoreNetworkStatus(
+	ctx context.Context,
+	networkStatus *rosetta.NetworkStatusResponse,
+) error {
+	buf, err := encodeValue(b.codec, networkStatus)
+	if err != nil {
+		return err
+	}
+
+	return b.Update(ctx, func(transaction DatabaseTransaction) error {
+		return transaction.Set(ctx, getNetworkStatusKey(b.keyHasher), buf)
+	})
+}
+
+// GetNetworkStatus returns the network status stored
+// by StoreNetworkStatus.
+func (b *BlockStorage) GetNetworkStatus(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+) (*rosetta.NetworkStatusResponse, error) {
+	exists, value, err := transaction.Get(ctx, getNetworkStatusKey(b.keyHasher))
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, ErrNetworkStatusNotFound
+	}
+
+	var networkStatus rosetta.NetworkStatusResponse
+	if err := decodeValue(value, &networkStatus); err != nil {
+		return nil, err
+	}
+
+	return &networkStatus, nil
+}
+
+// HasValidatorData returns a boolean indicating if db was
+// populated by a validator (it has a recorded key schema or
+// network) so that a directory that merely looks like a
+// Database is never mistaken for DATA_DIR.
+func HasValidatorData(ctx context.Context, db Database) (bool, error) {
+	transaction := db.NewDatabaseTransaction(ctx, false)
+	defer transaction.Discard(ctx)
+
+	exists, _, err := transaction.Get(ctx, keySchemaKey)
+	if err != nil || exists {
+		return exists, err
+	}
+
+	for _, hasher := range keyHashers {
+		exists, _, err := transaction.Get(ctx, getNetworkKey(hasher))
+		if err != nil || exists {
+			return exists, err
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitializeNetwork(t *testing.T) {
+	var (
+		mainnet = &rosetta.NetworkIdentifier{
+			Blockchain: "bitcoin",
+			Network:    "mainnet",
+		}
+		testnet = &rosetta.NetworkIdentifier{
+			Blockchain: "bitcoin",
+			Network:    "testnet",
+		}
+		metadata = map[string]interface{}{"shard": 1.0}
+		shard    = &rosetta.NetworkIdentifier{
+			Blockchain: "bitcoin",
+			Network:    "mainnet",
+			SubNetworkIdentifier: &rosetta.SubNetworkIdentifier{
+				SubNetwork: "shard-1",
+				Metadata:   &metadata,
+			},
+		}
+	)
+	ctx := context.Background()
+
+	newDir, err := CreateTempDir()
+	assert.NoError(t, err)
+	defer RemoveTempDir(*newDir)
+
+	database, err := NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	storage := NewBlockStorage(ctx, database, &GobCodec{}, &SHA256KeyHasher{})
+
+	t.Run("New database", func(t *testing.T) {
+		assert.NoError(t, storage.InitializeNetwork(ctx, mainnet))
+		assert.NoError(t, storage.InitializeNetwork(ctx, mainnet))
+	})
+
+	t.Run("Different network", func(t *testing.T) {
+		err := storage.InitializeNetwork(ctx, testnet)
+		assert.True(t, errors.Is(err, ErrNetworkMismatch))
+		assert.Contains(t, err.Error(), "bitcoin/mainnet")
+	})
+
+	t.Run("Different sub-network", func(t *testing.T) {
+		assert.True(t, errors.Is(storage.InitializeNetwork(ctx, shard), ErrNetworkMismatch))
+	})
+
+	t.Run("Sub-network metadata is ignored", func(t *testing.T) {
+		subStorage := NewBlockStorage(
+			ctx,
+			NewNamespacedStorage(database, "shard-1"),
+			&GobCodec{},
+			&SHA256KeyHasher{},
+		)
+		assert.NoError(t, subStorage.InitializeNetwork(ctx, shard))
+
+		withoutMetadata := *shard
+		withoutMetadata.SubNetworkIdentifier = &rosetta.SubNetworkIdentifier{
+			SubNetwork: "shard-1",
+		}
+		assert.NoError(t, subStorage.InitializeNetwork(ctx, &withoutMetadata))
+	})
+}
+
+func TestNetworkStatus(t *testing.T) {
+	ctx := context.Background()
+	storage := NewBlockStorage(ctx, NewMemoryStorage(), &GobCodec{}, &SHA256KeyHasher{})
+
+	txn := storage.NewDatabaseTransaction(ctx, false)
+	_, err := storage.GetNetworkStatus(ctx, txn)
+	txn.Discard(ctx)
+	assert.True(t, errors.Is(err, ErrNetworkStatusNotFound))
+
+	metadata := map[string]interface{}{"peers": 8.0}
+	networkStatus := &rosetta.NetworkStatusResponse{
+		NetworkStatus: &rosetta.NetworkStatus{
+			NetworkInformation: &rosetta.NetworkInformation{
+				GenesisBlockIdentifier: &rosetta.BlockIdentifier{Hash: "0", Index: 0},
+				CurrentBlockIdentifier: &rosetta.BlockIdentifier{Hash: "10", Index: 10},
+			},
+		},
+		Options: &rosetta.Options{
+			OperationStatuses: []*rosetta.OperationStatus{
+				{Status: "SUCCESS", Successful: true},
+			},
+			OperationTypes: []string{"TRANSFER"},
+		},
+		Metadata: &metadata,
+	}
+	assert.NoError(t, storage.StoreNetworkStatus(ctx, networkStatus))
+
+	txn = storage.NewDatabaseTransaction(ctx, false)
+	stored, err := storage.GetNetworkStatus(ctx, txn)
+	txn.Discard(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, networkStatus, stored)
+}
+
+func TestHasValidatorData(t *testing.T) {
+	ctx := context.Background()
+	network := &rosetta.NetworkIdentifier{
+		Blockchain: "bitcoin",
+		Network:    "mainnet",
+	}
+
+	t.Run("Empty database", func(t *testing.T) {
+		database := NewMemoryStorage()
+
+		hasData, err := HasValidatorData(ctx, database)
+		assert.NoError(t, err)
+		assert.False(t, hasData)
+	})
+
+	t.Run("Key schema", func(t *testing.T) {
+		database := NewMemoryStorage()
+		storage := NewBlockStorage(ctx, database, &GobCodec{}, &FNVKeyHasher{})
+		assert.NoError(t, storage.InitializeKeySchema(ctx))
+
+		hasData, err := HasValidatorData(ctx, database)
+		assert.NoError(t, err)
+		assert.True(t, hasData)
+	})
+
+	t.Run("Network", func(t *testing.T) {
+		database := NewMemoryStorage()
+		storage := NewBlockStorage(ctx, database, &GobCodec{}, &FNVKeyHasher{})
+		assert.NoError(t, storage.InitializeNetwork(ctx, network))
+
+		hasData, err := HasValidatorData(ctx, database)
+		assert.NoError(t, err)
+		assert.True(t, hasData)
+	})
+}
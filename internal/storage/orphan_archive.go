@@ -0,0 +1,101 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+// orphanArchiveNamespace is prepended to the sequence
+// number of any OrphanedBlock.
+const orphanArchiveNamespace = "orphan-archive"
+
+var (
+	// ErrNoReorgInProgress is returned by ArchiveOrphanedBlock
+	// when the block was not recorded in a ReorgIntent.
+	ErrNoReorgInProgress = errors.New("no reorg in progress")
+)
+
+// OrphanedBlock is a block retained after it was
+// orphaned by a reorg so that the reorg can be
+// investigated after the fact.
+type OrphanedBlock struct {
+	Block *rosetta.Block
+
+	// ReorgHead is the head block when the reorg began.
+	// Blocks orphaned by the same reorg share a ReorgHead.
+	ReorgHead *rosetta.BlockIdentifier
+
+	// ReorgDepth is the number of blocks orphaned by the
+	// reorg when this block was orphaned (including this
+	// block), so the deepest OrphanedBlock of a reorg has
+	// the depth of the reorg.
+	ReorgDepth int64
+
+	OrphanedAt time.Time
+}
+
+// ArchiveOrphanedBlock appends a block to the orphan archive
+// read by OrphanedBlocks. It must be called in the same
+// transaction as RecordOrphanedBlock (and before the block is
+// removed).
+func (b *BlockStorage) ArchiveOrphanedBlock(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	block *rosetta.Block,
+) error {
+	intent, err := b.GetReorgIntent(ctx, transaction)
+	if err != nil {
+		return err
+	}
+
+	if intent == nil {
+		return ErrNoReorgInProgress
+	}
+
+	return b.appendStream(ctx, transaction, orphanArchiveNamespace, &OrphanedBlock{
+		Block:      block,
+		ReorgHead:  intent.Head,
+		ReorgDepth: int64(len(intent.Orphaned)),
+		OrphanedAt: time.Now(),
+	})
+}
+
+// OrphanedBlocks returns up to limit OrphanedBlocks, starting
+// at cursor, in the order they were orphaned and the cursor to
+// resume reading from. A cursor of 0 reads from the first
+// orphaned block.
+func (b *BlockStorage) OrphanedBlocks(
+	ctx context.Context,
+	cursor int64,
+	limit int,
+) ([]*OrphanedBlock, int64, error) {
+	orphans := []*OrphanedBlock{}
+	next, err := b.readStream(ctx, orphanArchiveNamespace, cursor, limit, func(value []byte) error {
+		var orphan OrphanedBlock
+		if err := decodeValue(value, &orphan); err != nil {
+			return err
+		}
+
+		orphans = append(orphans, &orphan)
+		return nil
+	})
+
+	return orphans, next, err
+}
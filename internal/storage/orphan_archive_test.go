@@ -0,0 +1,106 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrphanArchive(t *testing.T) {
+	ctx := context.Background()
+
+	newDir, err := CreateTempDir()
+	assert.NoError(t, err)
+	defer RemoveTempDir(*newDir)
+
+	database, err := NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	storage := NewBlockStorage(ctx, database, &GobCodec{}, &SHA256KeyHasher{})
+	genesis := &rosetta.BlockIdentifier{
+		Hash:  "0",
+		Index: 0,
+	}
+	blocks := []*rosetta.Block{
+		{
+			BlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "1",
+				Index: 1,
+			},
+			ParentBlockIdentifier: genesis,
+			Timestamp:             1,
+		},
+		{
+			BlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "2",
+				Index: 2,
+			},
+			ParentBlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "1",
+				Index: 1,
+			},
+			Timestamp: 2,
+		},
+	}
+
+	t.Run("No reorg in progress", func(t *testing.T) {
+		err := storage.Update(ctx, func(txn DatabaseTransaction) error {
+			return storage.ArchiveOrphanedBlock(ctx, txn, blocks[1])
+		})
+		assert.True(t, errors.Is(err, ErrNoReorgInProgress))
+
+		orphans, next, err := storage.OrphanedBlocks(ctx, 0, 10)
+		assert.NoError(t, err)
+		assert.Empty(t, orphans)
+		assert.Equal(t, int64(0), next)
+	})
+
+	t.Run("Reorg", func(t *testing.T) {
+		for i := len(blocks) - 1; i >= 0; i-- {
+			block := blocks[i]
+			assert.NoError(t, storage.Update(ctx, func(txn DatabaseTransaction) error {
+				if err := storage.RecordOrphanedBlock(ctx, txn, block.BlockIdentifier); err != nil {
+					return err
+				}
+
+				return storage.ArchiveOrphanedBlock(ctx, txn, block)
+			}))
+		}
+
+		orphans, next, err := storage.OrphanedBlocks(ctx, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), next)
+		assert.Len(t, orphans, 2)
+		for i, orphan := range orphans {
+			assert.Equal(t, blocks[len(blocks)-1-i], orphan.Block)
+			assert.Equal(t, blocks[1].BlockIdentifier, orphan.ReorgHead)
+			assert.Equal(t, int64(i+1), orphan.ReorgDepth)
+			assert.False(t, orphan.OrphanedAt.IsZero())
+		}
+
+		orphans, next, err = storage.OrphanedBlocks(ctx, 1, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), next)
+		assert.Len(t, orphans, 1)
+		assert.Equal(t, blocks[0], orphans[0].Block)
+	})
+}
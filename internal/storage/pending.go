@@ -0,0 +1,105 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+// pendingBlocksKey is used to lookup the PendingBlocks
+// whose balance changes have not yet been applied.
+const pendingBlocksKey = "pending-blocks"
+
+// PendingBlocks records the blocks on the canonical chain
+// whose balance changes are delayed until they are
+// confirmed (see SyncHandler.SetConfirmationDepth). Blocks
+// are confirmed in the order they were added, so orphaning
+// a pending block never requires reverting balances.
+type PendingBlocks struct {
+	// Confirmed is the last block whose balance
+	// changes have been applied.
+	Confirmed *rosetta.BlockIdentifier
+
+	// Blocks are the pending blocks after Confirmed,
+	// in the order they were added.
+	Blocks []*rosetta.BlockIdentifier
+}
+
+func getPendingBlocksKey(hasher KeyHasher) []byte {
+	return hasher.Hash([]byte(pendingBlocksKey))
+}
+
+// GetPendingBlocks returns the PendingBlocks or nil if
+// the balance changes of every block have been applied.
+func (b *BlockStorage) GetPendingBlocks(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+) (*PendingBlocks, error) {
+	exists, value, err := transaction.Get(ctx, getPendingBlocksKey(b.keyHasher))
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, nil
+	}
+
+	var pending PendingBlocks
+	if err := decodeValue(value, &pending); err != nil {
+		return nil, err
+	}
+
+	return &pending, nil
+}
+
+// StorePendingBlocks stores the PendingBlocks, removing
+// them if there are no longer any pending blocks.
+func (b *BlockStorage) StorePendingBlocks(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	pending *PendingBlocks,
+) error {
+	if pending == nil || len(pending.Blocks) == 0 {
+		return transaction.Delete(ctx, getPendingBlocksKey(b.keyHasher))
+	}
+
+	buf, err := encodeValue(b.codec, pending)
+	if err != nil {
+		return err
+	}
+
+	return transaction.Set(ctx, getPendingBlocksKey(b.keyHasher), buf)
+}
+
+// GetConfirmedBlockIdentifier returns the last block whose
+// balance changes have been applied. This is the head block
+// unless balance changes are delayed by a confirmation depth.
+func (b *BlockStorage) GetConfirmedBlockIdentifier(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+) (*rosetta.BlockIdentifier, error) {
+	pending, err := b.GetPendingBlocks(ctx, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	if pending != nil {
+		return pending.Confirmed, nil
+	}
+
+	return b.GetHeadBlockIdentifier(ctx, transaction)
+}
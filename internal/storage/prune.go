@@ -0,0 +1,290 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+const (
+	// prunedIndexKey is used to lookup the index of the
+	// most recent block whose transactions were pruned.
+	prunedIndexKey = "pruned-index"
+
+	// pruneBatchSize is the number of blocks pruned in
+	// each transaction to stay below Badger's
+	// transaction size limit.
+	pruneBatchSize = 500
+)
+
+// ErrBlockPruned is returned by GetUnprunedBlock when the
+// transactions of a block were removed by PruneBlocks.
+var ErrBlockPruned = errors.New("Block transactions were pruned")
+
+// storedBlock is the encoding of a stored block. Its fields
+// match those of rosetta.Block, so blocks stored before
+// blocks could be pruned are decoded without Pruned set.
+type storedBlock struct {
+	BlockIdentifier       *rosetta.BlockIdentifier `json:"block_identifier"`
+	ParentBlockIdentifier *rosetta.BlockIdentifier `json:"parent_block_identifier"`
+	Timestamp             int64                    `json:"timestamp"`
+	Transactions          []*rosetta.Transaction   `json:"transactions"`
+	Metadata              *map[string]interface{}  `json:"metadata,omitempty"`
+
+	// Pruned is set once the transactions of the
+	// block have been removed by PruneBlocks.
+	Pruned bool `json:"pruned,omitempty" msgpack:",omitempty"`
+}
+
+// block returns the rosetta.Block of the storedBlock.
+func (s *storedBlock) block() *rosetta.Block {
+	return &rosetta.Block{
+		BlockIdentifier:       s.BlockIdentifier,
+		ParentBlockIdentifier: s.ParentBlockIdentifier,
+		Timestamp:             s.Timestamp,
+		Transactions:          s.Transactions,
+		Metadata:              s.Metadata,
+	}
+}
+
+// prunedBlock returns the storedBlock of block
+// without its transactions.
+func prunedBlock(block *rosetta.Block) *storedBlock {
+	return &storedBlock{
+		BlockIdentifier:       block.BlockIdentifier,
+		ParentBlockIdentifier: block.ParentBlockIdentifier,
+		Timestamp:             block.Timestamp,
+		Metadata:              block.Metadata,
+		Pruned:                true,
+	}
+}
+
+// getStoredBlock returns a stored block, if it exists.
+func (b *BlockStorage) getStoredBlock(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	blockIdentifier *rosetta.BlockIdentifier,
+) (*storedBlock, error) {
+	var stored storedBlock
+	exists, err := readValue(ctx, transaction, getBlockKey(b.keyHasher, blockIdentifier), func(value []byte) error {
+		if err := decodeValue(value, &stored); err != nil {
+			return &CorruptionError{Block: blockIdentifier, Err: err}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, fmt.Errorf("%w %+v", ErrBlockNotFound, blockIdentifier)
+	}
+
+	return &stored, nil
+}
+
+// GetUnprunedBlock returns a block like GetBlock but returns
+// ErrBlockPruned instead of a block whose transactions were
+// removed by PruneBlocks (ex: to re-derive balances from it).
+func (b *BlockStorage) GetUnprunedBlock(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	blockIdentifier *rosetta.BlockIdentifier,
+) (*rosetta.Block, error) {
+	stored, err := b.getStoredBlock(ctx, transaction, blockIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	if stored.Pruned {
+		return nil, fmt.Errorf("%w %+v", ErrBlockPruned, blockIdentifier)
+	}
+
+	return stored.block(), nil
+}
+
+func getPrunedIndexKey(hasher KeyHasher) []byte {
+	return hasher.Hash([]byte(prunedIndexKey))
+}
+
+// PrunedIndex returns the index of the most recent
+// pruned block or -1 if no blocks have been pruned.
+func (b *BlockStorage) PrunedIndex(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+) (int64, error) {
+	exists, value, err := transaction.Get(ctx, getPrunedIndexKey(b.keyHasher))
+	if err != nil {
+		return 0, err
+	}
+
+	if !exists {
+		return -1, nil
+	}
+
+	return strconv.ParseInt(string(value), 10, 64)
+}
+
+// unprunedBlocks returns the identifiers of all blocks at or
+// below index that have not been pruned (oldest first) by
+// walking back from head. Blocks are read in batches of
+// pruneBatchSize so that no read transaction is held open
+// (pinning every version it can read) for the entire walk.
+func (b *BlockStorage) unprunedBlocks(
+	ctx context.Context,
+	head *rosetta.BlockIdentifier,
+	index int64,
+) ([]*rosetta.BlockIdentifier, error) {
+	transaction := b.db.NewDatabaseTransaction(ctx, false)
+	pruned, err := b.PrunedIndex(ctx, transaction)
+	transaction.Discard(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := []*rosetta.BlockIdentifier{}
+	current := head
+	for done := false; !done; {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		transaction := b.db.NewDatabaseTransaction(ctx, false)
+		for read := 0; read < pruneBatchSize; read++ {
+			if current.Index <= pruned {
+				done = true
+				break
+			}
+
+			block, err := b.getStoredBlock(ctx, transaction, current)
+			if errors.Is(err, ErrBlockNotFound) {
+				done = true
+				break
+			}
+			if err != nil {
+				transaction.Discard(ctx)
+				return nil, err
+			}
+
+			if block.Pruned {
+				done = true
+				break
+			}
+
+			if current.Index <= index {
+				blocks = append(blocks, current)
+			}
+
+			if block.ParentBlockIdentifier.Index == current.Index {
+				done = true
+				break
+			}
+
+			current = block.ParentBlockIdentifier
+		}
+		transaction.Discard(ctx)
+	}
+
+	for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+		blocks[i], blocks[j] = blocks[j], blocks[i]
+	}
+
+	return blocks, nil
+}
+
+// PruneBlocks removes the transactions of all blocks more
+// than depth blocks below the head block (and the entries of
+// the analytics streams recorded for them) and returns the
+// number of blocks pruned. Block identifiers and the hashes
+// used for duplicate detection are retained, so depth must
+// be larger than the deepest possible reorg (a pruned block
+// cannot be fully removed). Space is not reclaimed until
+// the Database is garbage collected.
+func (b *BlockStorage) PruneBlocks(ctx context.Context, depth int64) (int, error) {
+	transaction := b.db.NewDatabaseTransaction(ctx, false)
+	head, err := b.GetHeadBlockIdentifier(ctx, transaction)
+	transaction.Discard(ctx)
+	if errors.Is(err, ErrHeadBlockNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := b.pruneStreams(ctx, head.Index-depth); err != nil {
+		return 0, err
+	}
+
+	blocks, err := b.unprunedBlocks(ctx, head, head.Index-depth)
+	if err != nil {
+		return 0, err
+	}
+
+	for start := 0; start < len(blocks); start += pruneBatchSize {
+		end := start + pruneBatchSize
+		if end > len(blocks) {
+			end = len(blocks)
+		}
+
+		batch := blocks[start:end]
+		err := b.Update(ctx, func(transaction DatabaseTransaction) error {
+			for _, blockIdentifier := range batch {
+				block, err := b.GetBlock(ctx, transaction, blockIdentifier)
+				if err != nil {
+					return err
+				}
+
+				buf, err := encodeValue(b.codec, prunedBlock(block))
+				if err != nil {
+					return err
+				}
+
+				err = transaction.Set(ctx, getBlockKey(b.keyHasher, blockIdentifier), buf)
+				if err != nil {
+					return err
+				}
+			}
+
+			return transaction.Set(
+				ctx,
+				getPrunedIndexKey(b.keyHasher),
+				[]byte(strconv.FormatInt(batch[len(batch)-1].Index, 10)),
+			)
+		})
+		if err != nil {
+			return start, err
+		}
+	}
+
+	return len(blocks), nil
+}
+
+// GarbageCollect reclaims the space used by deleted
+// and overwritten values (ex: pruned transactions), if
+// the Database requires it.
+func (b *BlockStorage) GarbageCollect(ctx context.Context) error {
+	collector, ok := b.db.(GarbageCollector)
+	if !ok {
+		return nil
+	}
+
+	return collector.GarbageCollect(ctx)
+}
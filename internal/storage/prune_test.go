@@ -0,0 +1,159 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/coinbase/rosetta-validator/internal/generator"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPruneBlocks(t *testing.T) {
+	ctx := context.Background()
+
+	newDir, err := CreateTempDir()
+	assert.NoError(t, err)
+	defer RemoveTempDir(*newDir)
+
+	database, err := NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	storage := NewBlockStorage(ctx, database, &GobCodec{}, &SHA256KeyHasher{})
+	g := generator.New(generator.Config{
+		Height:                   20,
+		TransactionsPerBlock:     2,
+		OperationsPerTransaction: 1,
+		Accounts:                 10,
+	})
+
+	storeBlocks := func(start int64, end int64) {
+		for i := start; i <= end; i++ {
+			block := g.Block(i)
+			assert.NoError(t, storage.Update(ctx, func(txn DatabaseTransaction) error {
+				if err := storage.StoreBlock(ctx, txn, block); err != nil {
+					return err
+				}
+
+				return storage.StoreHeadBlockIdentifier(ctx, txn, block.BlockIdentifier)
+			}))
+		}
+	}
+
+	transactionCount := func(index int64) int {
+		txn := storage.NewDatabaseTransaction(ctx, false)
+		defer txn.Discard(ctx)
+
+		block, err := storage.GetBlock(ctx, txn, g.Block(index).BlockIdentifier)
+		assert.NoError(t, err)
+		return len(block.Transactions)
+	}
+
+	t.Run("No blocks", func(t *testing.T) {
+		pruned, err := storage.PruneBlocks(ctx, 5)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, pruned)
+	})
+
+	t.Run("Prune below depth", func(t *testing.T) {
+		storeBlocks(1, 10)
+
+		pruned, err := storage.PruneBlocks(ctx, 5)
+		assert.NoError(t, err)
+		assert.Equal(t, 5, pruned)
+		assert.Equal(t, 0, transactionCount(5))
+		assert.Equal(t, 2, transactionCount(6))
+
+		events, _, err := storage.BlockEvents(ctx, 0, 20)
+		assert.NoError(t, err)
+		assert.Len(t, events, 5)
+		assert.Equal(t, int64(6), events[0].Block.Index)
+
+		pruned, err = storage.PruneBlocks(ctx, 5)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, pruned)
+	})
+
+	t.Run("Pruned flag", func(t *testing.T) {
+		txn := storage.NewDatabaseTransaction(ctx, false)
+		defer txn.Discard(ctx)
+
+		_, err := storage.GetUnprunedBlock(ctx, txn, g.Block(5).BlockIdentifier)
+		assert.True(t, errors.Is(err, ErrBlockPruned))
+
+		block, err := storage.GetUnprunedBlock(ctx, txn, g.Block(6).BlockIdentifier)
+		assert.NoError(t, err)
+		assert.Equal(t, g.Block(6), block)
+	})
+
+	t.Run("Restore pruned block", func(t *testing.T) {
+		assert.NoError(t, storage.RestoreBlock(ctx, g.Block(5)))
+		assert.Equal(t, 0, transactionCount(5))
+	})
+
+	t.Run("Prune incrementally", func(t *testing.T) {
+		storeBlocks(11, 20)
+
+		pruned, err := storage.PruneBlocks(ctx, 5)
+		assert.NoError(t, err)
+		assert.Equal(t, 10, pruned)
+		assert.Equal(t, 0, transactionCount(15))
+		assert.Equal(t, 2, transactionCount(16))
+
+		cache, err := storage.CreateBlockCache(ctx, 20)
+		assert.NoError(t, err)
+		assert.Len(t, cache, 20)
+	})
+
+	t.Run("Garbage collect", func(t *testing.T) {
+		assert.NoError(t, storage.GarbageCollect(ctx))
+	})
+}
+
+func TestStoredBlockCodecs(t *testing.T) {
+	block := generator.New(generator.Config{
+		Height:                   2,
+		TransactionsPerBlock:     2,
+		OperationsPerTransaction: 1,
+		Accounts:                 10,
+	}).Block(1)
+
+	for name, codec := range codecs {
+		t.Run(name, func(t *testing.T) {
+			// Blocks stored before blocks could be
+			// pruned are decoded without Pruned set.
+			buf, err := encodeValue(codec, block)
+			assert.NoError(t, err)
+
+			var stored storedBlock
+			assert.NoError(t, decodeValue(buf, &stored))
+			assert.False(t, stored.Pruned)
+			assert.Equal(t, block, stored.block())
+
+			buf, err = encodeValue(codec, prunedBlock(block))
+			assert.NoError(t, err)
+
+			stored = storedBlock{}
+			assert.NoError(t, decodeValue(buf, &stored))
+			assert.True(t, stored.Pruned)
+			assert.Nil(t, stored.Transactions)
+			assert.Equal(t, block.BlockIdentifier, stored.BlockIdentifier)
+		})
+	}
+}
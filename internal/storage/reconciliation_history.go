@@ -0,0 +1,106 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+const (
+	// reconciliationHistoryNamespace is prepended to the
+	// balance key of an account to construct the namespace
+	// of the stream of its reconciliations.
+	reconciliationHistoryNamespace = "reconciliation-history"
+
+	// ReconciliationSucceeded is the Outcome of a
+	// Reconciliation where the computed and live
+	// balances are equal.
+	ReconciliationSucceeded = "success"
+
+	// ReconciliationFailed is the Outcome of a
+	// Reconciliation where the computed and live
+	// balances differ.
+	ReconciliationFailed = "failure"
+
+	// ReconciliationSkipped is the Outcome of a
+	// Reconciliation that could not be performed (ex: the
+	// live block was orphaned or the account was updated
+	// after the live block).
+	ReconciliationSkipped = "skipped"
+)
+
+// Reconciliation records a single check of an account's
+// computed balance against its live balance at Block.
+// Computed is empty if the Reconciliation was skipped.
+type Reconciliation struct {
+	Type     string
+	Account  *rosetta.AccountIdentifier
+	Currency *rosetta.Currency
+	Block    *rosetta.BlockIdentifier
+	Computed string
+	Live     string
+	Outcome  string
+
+	// Labels are the labels of Account (if any).
+	Labels []string
+}
+
+func getReconciliationHistoryNamespace(
+	hasher KeyHasher,
+	account *rosetta.AccountIdentifier,
+) string {
+	return fmt.Sprintf("%s:%x", reconciliationHistoryNamespace, getBalanceKey(hasher, account))
+}
+
+// StoreReconciliation appends a Reconciliation to the
+// reconciliation history of its account in its own
+// transaction.
+func (b *BlockStorage) StoreReconciliation(
+	ctx context.Context,
+	reconciliation *Reconciliation,
+) error {
+	namespace := getReconciliationHistoryNamespace(b.keyHasher, reconciliation.Account)
+	return b.Update(ctx, func(transaction DatabaseTransaction) error {
+		return b.appendStream(ctx, transaction, namespace, reconciliation)
+	})
+}
+
+// Reconciliations returns up to limit Reconciliations of an
+// account, starting at cursor, in the order they were
+// performed and the cursor to resume reading from. A cursor
+// of 0 reads from the first reconciliation.
+func (b *BlockStorage) Reconciliations(
+	ctx context.Context,
+	account *rosetta.AccountIdentifier,
+	cursor int64,
+	limit int,
+) ([]*Reconciliation, int64, error) {
+	namespace := getReconciliationHistoryNamespace(b.keyHasher, account)
+	reconciliations := []*Reconciliation{}
+	next, err := b.readStream(ctx, namespace, cursor, limit, func(value []byte) error {
+		var reconciliation Reconciliation
+		if err := decodeValue(value, &reconciliation); err != nil {
+			return err
+		}
+
+		reconciliations = append(reconciliations, &reconciliation)
+		return nil
+	})
+
+	return reconciliations, next, err
+}
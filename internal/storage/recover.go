@@ -0,0 +1,388 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+// Inconsistency is a violation of an invariant between
+// the head block, stored blocks, block events, and
+// balances found by Recover.
+type Inconsistency struct {
+	Description string
+	Repaired    bool
+}
+
+// lastAddedBlock returns the most recently added block
+// that was not later orphaned and is still stored, or nil
+// if there is no such block.
+func (b *BlockStorage) lastAddedBlock(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+) (*rosetta.BlockIdentifier, error) {
+	orphaned := map[string]bool{}
+	var last *rosetta.BlockIdentifier
+	err := b.readStreamReverse(ctx, transaction, blockStreamNamespace, func(value []byte) (bool, error) {
+		var event BlockEvent
+		if err := decodeValue(value, &event); err != nil {
+			return false, err
+		}
+
+		key := fmt.Sprintf("%s:%d", event.Block.Hash, event.Block.Index)
+		if event.Orphaned {
+			orphaned[key] = true
+			return true, nil
+		}
+
+		if orphaned[key] {
+			return true, nil
+		}
+
+		_, err := b.GetBlock(ctx, transaction, event.Block)
+		if errors.Is(err, ErrBlockNotFound) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		last = event.Block
+		return false, nil
+	})
+
+	return last, err
+}
+
+// removeBlocksAfter removes the stored blocks from last
+// back to (but not including) the block at index.
+func (b *BlockStorage) removeBlocksAfter(
+	ctx context.Context,
+	last *rosetta.BlockIdentifier,
+	index int64,
+) error {
+	return b.Update(ctx, func(transaction DatabaseTransaction) error {
+		current := last
+		for current.Index > index {
+			block, err := b.GetBlock(ctx, transaction, current)
+			if errors.Is(err, ErrBlockNotFound) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			if err := b.RemoveBlock(ctx, transaction, current); err != nil {
+				return err
+			}
+
+			current = block.ParentBlockIdentifier
+		}
+
+		return nil
+	})
+}
+
+// recoverHead checks that the head block is stored and is
+// the most recently added block. If repair is true, blocks
+// added after a stored head block are removed (their
+// balance changes are rolled back by recoverBalance) and a
+// head block that is not stored is replaced by the most
+// recently added block.
+func (b *BlockStorage) recoverHead(
+	ctx context.Context,
+	repair bool,
+) (*rosetta.BlockIdentifier, *Inconsistency, error) {
+	transaction := b.db.NewDatabaseTransaction(ctx, false)
+	defer transaction.Discard(ctx)
+
+	head, err := b.GetHeadBlockIdentifier(ctx, transaction)
+	if err != nil && !errors.Is(err, ErrHeadBlockNotFound) {
+		return nil, nil, err
+	}
+
+	headStored := false
+	if head != nil {
+		_, err := b.GetBlock(ctx, transaction, head)
+		if err != nil && !errors.Is(err, ErrBlockNotFound) {
+			return nil, nil, err
+		}
+
+		headStored = err == nil
+	}
+
+	// Data stored before block events were recorded
+	// can only be checked for a stored head block.
+	expected, err := b.lastAddedBlock(ctx, transaction)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	inconsistency := &Inconsistency{}
+	switch {
+	case expected == nil && (head == nil || headStored):
+		return head, nil, nil
+	case expected == nil:
+		inconsistency.Description = fmt.Sprintf("head block %+v is not stored", head)
+		return head, inconsistency, nil
+	case headStored && *head == *expected:
+		return head, nil, nil
+	case headStored && expected.Index > head.Index:
+		inconsistency.Description = fmt.Sprintf(
+			"block %+v was added after head block %+v",
+			expected,
+			head,
+		)
+		if repair {
+			err = b.removeBlocksAfter(ctx, expected, head.Index)
+		}
+	default:
+		inconsistency.Description = fmt.Sprintf(
+			"head block %+v is not the last added block %+v",
+			head,
+			expected,
+		)
+		if repair {
+			head = expected
+			err = b.Update(ctx, func(transaction DatabaseTransaction) error {
+				return b.StoreHeadBlockIdentifier(ctx, transaction, expected)
+			})
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	inconsistency.Repaired = repair
+	return head, inconsistency, nil
+}
+
+// recentAccounts returns the accounts with balance changes
+// in blocks after minIndex.
+func (b *BlockStorage) recentAccounts(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	minIndex int64,
+) ([]*rosetta.AccountIdentifier, error) {
+	seen := map[string]bool{}
+	accounts := []*rosetta.AccountIdentifier{}
+	err := b.readStreamReverse(ctx, transaction, balanceStreamNamespace, func(value []byte) (bool, error) {
+		var change BalanceChange
+		if err := decodeValue(value, &change); err != nil {
+			return false, err
+		}
+
+		if change.Block.Index <= minIndex {
+			return false, nil
+		}
+
+		key := GetAccountKey(change.Account)
+		if !seen[key] {
+			seen[key] = true
+			accounts = append(accounts, change.Account)
+		}
+
+		return true, nil
+	})
+
+	return accounts, err
+}
+
+// equalAmounts returns true if a and b contain the same
+// value for each currency. A missing currency is 0.
+func equalAmounts(a map[string]*rosetta.Amount, b map[string]*rosetta.Amount) bool {
+	value := func(amounts map[string]*rosetta.Amount, key string) string {
+		if amount, ok := amounts[key]; ok {
+			return amount.Value
+		}
+
+		return "0"
+	}
+
+	for key := range a {
+		if value(a, key) != value(b, key) {
+			return false
+		}
+	}
+
+	for key := range b {
+		if value(a, key) != value(b, key) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// recoverBalance checks that a balance last updated after
+// the head block is equal to its balance at the head block
+// (as it is when the later block was orphaned). If repair
+// is true, the balance is restored from its balance history
+// at the head block.
+func (b *BlockStorage) recoverBalance(
+	ctx context.Context,
+	account *rosetta.AccountIdentifier,
+	head *rosetta.BlockIdentifier,
+	repair bool,
+) (*Inconsistency, error) {
+	var inconsistency *Inconsistency
+	err := b.Update(ctx, func(transaction DatabaseTransaction) error {
+		inconsistency = nil
+		amounts, block, err := b.GetBalance(ctx, transaction, account)
+		if err != nil {
+			return err
+		}
+
+		if block.Index <= head.Index {
+			return nil
+		}
+
+		headAmounts, headBlock, err := b.GetBalanceAt(ctx, transaction, account, head.Index)
+		if errors.Is(err, ErrBalanceHistoryNotFound) {
+			// The account did not exist at the head block.
+			headAmounts = map[string]*rosetta.Amount{}
+			headBlock = head
+		} else if err != nil {
+			return err
+		}
+
+		if equalAmounts(amounts, headAmounts) {
+			return nil
+		}
+
+		inconsistency = &Inconsistency{
+			Description: fmt.Sprintf(
+				"balance of %+v was updated at block %+v after head block %+v",
+				account,
+				block,
+				head,
+			),
+			Repaired: repair,
+		}
+		if !repair {
+			return nil
+		}
+
+		return b.RestoreBalance(ctx, transaction, account, headAmounts, headBlock)
+	})
+
+	return inconsistency, err
+}
+
+// recoverChunkedCommit checks for a transaction too big to
+// commit at once that was interrupted. If repair is true, it
+// is completed (see CompleteChunkedCommit).
+func (b *BlockStorage) recoverChunkedCommit(
+	ctx context.Context,
+	repair bool,
+) (*Inconsistency, error) {
+	commit, entries, err := b.interruptedChunkedCommit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var inconsistency *Inconsistency
+	switch {
+	case commit != nil:
+		inconsistency = &Inconsistency{
+			Description: fmt.Sprintf(
+				"transaction committed in chunks (%d journal entries) was not fully applied",
+				entries,
+			),
+		}
+	case entries > 0:
+		inconsistency = &Inconsistency{
+			Description: fmt.Sprintf(
+				"transaction too big to commit at once was not committed (%d journal entries)",
+				entries,
+			),
+		}
+	default:
+		return nil, nil
+	}
+
+	if !repair {
+		return inconsistency, nil
+	}
+
+	if _, err := b.CompleteChunkedCommit(ctx); err != nil {
+		return nil, err
+	}
+
+	inconsistency.Repaired = true
+	return inconsistency, nil
+}
+
+// Recover checks that the head block, stored blocks, block
+// events, and the balances updated in the last depth blocks
+// are consistent (ex: after an unclean shutdown). The head
+// block marks the last fully processed block, so if repair
+// is true, any later blocks are removed and their balance
+// changes are rolled back using balance history. A block
+// too big to commit at once that was interrupted is first
+// rolled forward (or back, if it was not committed). All
+// Inconsistencies found are returned.
+func (b *BlockStorage) Recover(
+	ctx context.Context,
+	depth int64,
+	repair bool,
+) ([]*Inconsistency, error) {
+	inconsistencies := []*Inconsistency{}
+	inconsistency, err := b.recoverChunkedCommit(ctx, repair)
+	if err != nil {
+		return nil, err
+	}
+
+	if inconsistency != nil {
+		inconsistencies = append(inconsistencies, inconsistency)
+	}
+
+	head, inconsistency, err := b.recoverHead(ctx, repair)
+	if err != nil {
+		return nil, err
+	}
+
+	if inconsistency != nil {
+		inconsistencies = append(inconsistencies, inconsistency)
+	}
+
+	if head == nil {
+		return inconsistencies, nil
+	}
+
+	transaction := b.db.NewDatabaseTransaction(ctx, false)
+	accounts, err := b.recentAccounts(ctx, transaction, head.Index-depth)
+	transaction.Discard(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, account := range accounts {
+		inconsistency, err := b.recoverBalance(ctx, account, head, repair)
+		if err != nil {
+			return nil, err
+		}
+
+		if inconsistency != nil {
+			inconsistencies = append(inconsistencies, inconsistency)
+		}
+	}
+
+	return inconsistencies, nil
+}
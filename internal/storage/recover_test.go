@@ -0,0 +1,161 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecover(t *testing.T) {
+	ctx := context.Background()
+
+	newDir, err := CreateTempDir()
+	assert.NoError(t, err)
+	defer RemoveTempDir(*newDir)
+
+	database, err := NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	storage := NewBlockStorage(ctx, database, &GobCodec{}, &SHA256KeyHasher{})
+	account := &rosetta.AccountIdentifier{
+		Address: "recover",
+	}
+	currency := &rosetta.Currency{
+		Symbol:   "BLAH",
+		Decimals: 2,
+	}
+	blockIdentifier := func(index int64) *rosetta.BlockIdentifier {
+		return &rosetta.BlockIdentifier{
+			Hash:  string(rune('a' + index)),
+			Index: index,
+		}
+	}
+
+	// addBlock stores a block that credits account by 10,
+	// optionally without moving the head block (as if the
+	// block was only partially processed).
+	addBlock := func(index int64, updateHead bool) {
+		block := &rosetta.Block{
+			BlockIdentifier:       blockIdentifier(index),
+			ParentBlockIdentifier: blockIdentifier(index - 1),
+		}
+		assert.NoError(t, storage.Update(ctx, func(txn DatabaseTransaction) error {
+			if err := storage.StoreBlock(ctx, txn, block); err != nil {
+				return err
+			}
+
+			err := storage.UpdateBalance(ctx, txn, account, &rosetta.Amount{
+				Value:    "10",
+				Currency: currency,
+			}, block.BlockIdentifier)
+			if err != nil {
+				return err
+			}
+
+			err = storage.StoreBalanceChanges(ctx, txn, []*BalanceChange{
+				{
+					Account:    account,
+					Currency:   currency,
+					Block:      block.BlockIdentifier,
+					Difference: "10",
+				},
+			})
+			if err != nil {
+				return err
+			}
+
+			if !updateHead {
+				return nil
+			}
+
+			return storage.StoreHeadBlockIdentifier(ctx, txn, block.BlockIdentifier)
+		}))
+	}
+
+	balance := func() string {
+		txn := storage.NewDatabaseTransaction(ctx, false)
+		defer txn.Discard(ctx)
+
+		amounts, _, err := storage.GetBalance(ctx, txn, account)
+		assert.NoError(t, err)
+		return amounts[GetCurrencyKey(currency)].Value
+	}
+
+	t.Run("Empty storage", func(t *testing.T) {
+		inconsistencies, err := storage.Recover(ctx, 10, true)
+		assert.NoError(t, err)
+		assert.Len(t, inconsistencies, 0)
+	})
+
+	t.Run("Consistent storage", func(t *testing.T) {
+		for i := int64(1); i <= 3; i++ {
+			addBlock(i, true)
+		}
+
+		inconsistencies, err := storage.Recover(ctx, 10, true)
+		assert.NoError(t, err)
+		assert.Len(t, inconsistencies, 0)
+	})
+
+	t.Run("Partial block", func(t *testing.T) {
+		addBlock(4, false)
+
+		inconsistencies, err := storage.Recover(ctx, 10, false)
+		assert.NoError(t, err)
+		assert.Len(t, inconsistencies, 2)
+		assert.False(t, inconsistencies[0].Repaired)
+		assert.Equal(t, "40", balance())
+
+		inconsistencies, err = storage.Recover(ctx, 10, true)
+		assert.NoError(t, err)
+		assert.Len(t, inconsistencies, 2)
+		assert.True(t, inconsistencies[0].Repaired)
+		assert.True(t, inconsistencies[1].Repaired)
+		assert.Equal(t, "30", balance())
+
+		txn := storage.NewDatabaseTransaction(ctx, false)
+		_, err = storage.GetBlock(ctx, txn, blockIdentifier(4))
+		txn.Discard(ctx)
+		assert.True(t, errors.Is(err, ErrBlockNotFound))
+
+		inconsistencies, err = storage.Recover(ctx, 10, true)
+		assert.NoError(t, err)
+		assert.Len(t, inconsistencies, 0)
+	})
+
+	t.Run("Head block not stored", func(t *testing.T) {
+		assert.NoError(t, storage.Update(ctx, func(txn DatabaseTransaction) error {
+			return storage.StoreHeadBlockIdentifier(ctx, txn, blockIdentifier(9))
+		}))
+
+		inconsistencies, err := storage.Recover(ctx, 10, true)
+		assert.NoError(t, err)
+		assert.Len(t, inconsistencies, 1)
+
+		txn := storage.NewDatabaseTransaction(ctx, false)
+		defer txn.Discard(ctx)
+
+		head, err := storage.GetHeadBlockIdentifier(ctx, txn)
+		assert.NoError(t, err)
+		assert.Equal(t, blockIdentifier(3), head)
+	})
+}
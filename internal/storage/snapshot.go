@@ -0,0 +1,128 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+// ErrSnapshotBlockMismatch is returned by ImportSnapshot
+// when the block it is given is not the block of the
+// BalanceSnapshot.
+var ErrSnapshotBlockMismatch = errors.New("snapshot block mismatch")
+
+// SnapshotBalance is the balance of an
+// account in a BalanceSnapshot.
+type SnapshotBalance struct {
+	Account *rosetta.AccountIdentifier `json:"account_identifier"`
+	Amounts []*rosetta.Amount          `json:"amounts"`
+}
+
+// BalanceSnapshot is a trusted snapshot of every balance
+// as of a block (including the balance changes of the
+// block) from which validation can begin instead of genesis
+// (ex: on chains with millions of blocks). Unlike a
+// StateBundle, it can be produced by any trusted source
+// (ex: a node's state dump).
+type BalanceSnapshot struct {
+	BlockIdentifier *rosetta.BlockIdentifier `json:"block_identifier"`
+	Balances        []*SnapshotBalance       `json:"balances"`
+}
+
+// LoadBalanceSnapshot reads a JSON BalanceSnapshot
+// from a file.
+func LoadBalanceSnapshot(path string) (*BalanceSnapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var snapshot BalanceSnapshot
+	if err := json.NewDecoder(f).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("%w: unable to parse balance snapshot %s", err, path)
+	}
+
+	if snapshot.BlockIdentifier == nil {
+		return nil, fmt.Errorf("balance snapshot %s does not include a block_identifier", path)
+	}
+
+	return &snapshot, nil
+}
+
+// ImportSnapshot stores the balances in a BalanceSnapshot
+// so that syncing resumes after its block. block must be
+// the block of the snapshot (ex: as fetched from the Rosetta
+// Server). The currencies of the balances are registered as
+// tracked. Like ImportState, it may only be called before
+// any block has been synced.
+func (b *BlockStorage) ImportSnapshot(
+	ctx context.Context,
+	snapshot *BalanceSnapshot,
+	block *rosetta.Block,
+) error {
+	if block.BlockIdentifier.Index != snapshot.BlockIdentifier.Index ||
+		block.BlockIdentifier.Hash != snapshot.BlockIdentifier.Hash {
+		return fmt.Errorf(
+			"%w: snapshot is of block %+v, not %+v",
+			ErrSnapshotBlockMismatch,
+			snapshot.BlockIdentifier,
+			block.BlockIdentifier,
+		)
+	}
+
+	bundle := &StateBundle{
+		Head:       block.BlockIdentifier,
+		Blocks:     []*rosetta.Block{block},
+		Balances:   []*AccountBalance{},
+		Currencies: []*RegisteredCurrency{},
+	}
+
+	seen := map[string]bool{}
+	for _, balance := range snapshot.Balances {
+		bundle.Balances = append(bundle.Balances, &AccountBalance{
+			Account: balance.Account,
+			Amounts: balance.Amounts,
+			Block:   block.BlockIdentifier,
+		})
+
+		for _, amount := range balance.Amounts {
+			if amount == nil || amount.Currency == nil {
+				continue
+			}
+
+			key := GetCurrencyKey(amount.Currency)
+			if seen[key] {
+				continue
+			}
+
+			seen[key] = true
+			bundle.Currencies = append(bundle.Currencies, &RegisteredCurrency{
+				Key:       key,
+				Currency:  amount.Currency,
+				FirstSeen: block.BlockIdentifier,
+				Tracked:   true,
+			})
+		}
+	}
+
+	return b.ImportState(ctx, bundle)
+}
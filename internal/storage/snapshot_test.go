@@ -0,0 +1,132 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBalanceSnapshot(t *testing.T) {
+	ctx := context.Background()
+
+	newDir, err := CreateTempDir()
+	assert.NoError(t, err)
+	defer RemoveTempDir(*newDir)
+
+	currency := &rosetta.Currency{
+		Symbol:   "BLAH",
+		Decimals: 2,
+	}
+	account := &rosetta.AccountIdentifier{
+		Address: "acct1",
+	}
+	block := &rosetta.Block{
+		BlockIdentifier: &rosetta.BlockIdentifier{
+			Hash:  "1000",
+			Index: 1000,
+		},
+		ParentBlockIdentifier: &rosetta.BlockIdentifier{
+			Hash:  "999",
+			Index: 999,
+		},
+	}
+
+	snapshotFile := path.Join(*newDir, "snapshot.json")
+	assert.NoError(t, ioutil.WriteFile(snapshotFile, []byte(`{
+		"block_identifier": {"index": 1000, "hash": "1000"},
+		"balances": [
+			{
+				"account_identifier": {"address": "acct1"},
+				"amounts": [{"value": "100", "currency": {"symbol": "BLAH", "decimals": 2}}]
+			}
+		]
+	}`), 0600))
+
+	database, err := NewBadgerStorage(ctx, path.Join(*newDir, "db"))
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	storage := NewBlockStorage(ctx, database, &GobCodec{}, &SHA256KeyHasher{})
+
+	t.Run("Missing block identifier", func(t *testing.T) {
+		missingFile := path.Join(*newDir, "missing.json")
+		assert.NoError(t, ioutil.WriteFile(missingFile, []byte(`{"balances": []}`), 0600))
+
+		snapshot, err := LoadBalanceSnapshot(missingFile)
+		assert.Nil(t, snapshot)
+		assert.Contains(t, err.Error(), "does not include a block_identifier")
+	})
+
+	snapshot, err := LoadBalanceSnapshot(snapshotFile)
+	assert.NoError(t, err)
+	assert.Equal(t, block.BlockIdentifier, snapshot.BlockIdentifier)
+
+	t.Run("Block mismatch", func(t *testing.T) {
+		err := storage.ImportSnapshot(ctx, snapshot, &rosetta.Block{
+			BlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "1000a",
+				Index: 1000,
+			},
+		})
+		assert.True(t, errors.Is(err, ErrSnapshotBlockMismatch))
+	})
+
+	t.Run("Import", func(t *testing.T) {
+		assert.NoError(t, storage.ImportSnapshot(ctx, snapshot, block))
+
+		txn := storage.NewDatabaseTransaction(ctx, false)
+		defer txn.Discard(ctx)
+
+		head, err := storage.GetHeadBlockIdentifier(ctx, txn)
+		assert.NoError(t, err)
+		assert.Equal(t, block.BlockIdentifier, head)
+
+		amounts, balanceBlock, err := storage.GetBalance(ctx, txn, account)
+		assert.NoError(t, err)
+		assert.Equal(t, block.BlockIdentifier, balanceBlock)
+		assert.Equal(t, map[string]*rosetta.Amount{
+			GetCurrencyKey(currency): {
+				Value:    "100",
+				Currency: currency,
+			},
+		}, amounts)
+
+		currencies, err := storage.Currencies(ctx)
+		assert.NoError(t, err)
+		assert.Len(t, currencies, 1)
+		assert.True(t, currencies[0].Tracked)
+		assert.Equal(t, block.BlockIdentifier, currencies[0].FirstSeen)
+
+		cache, err := storage.CreateBlockCache(ctx, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, []*rosetta.BlockIdentifier{
+			block.ParentBlockIdentifier,
+			block.BlockIdentifier,
+		}, cache)
+	})
+
+	t.Run("Already synced", func(t *testing.T) {
+		err := storage.ImportSnapshot(ctx, snapshot, block)
+		assert.True(t, errors.Is(err, ErrStateNotEmpty))
+	})
+}
@@ -0,0 +1,340 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+const (
+	// importBatchSize is the number of accounts
+	// imported in each transaction by ImportState.
+	importBatchSize = 1000
+
+	// accountIndexNamespace is prepended to the sequence
+	// number of each account in the account index (and to
+	// the balance key of each indexed account).
+	accountIndexNamespace = "balance-account"
+)
+
+var (
+	// ErrStateNotEmpty is returned by ImportState if
+	// a block has already been synced.
+	ErrStateNotEmpty = errors.New("Cannot import state: a block has already been synced")
+
+	// ErrReorgInProgress is returned by ExportState if
+	// a reorg has not been completed.
+	ErrReorgInProgress = errors.New("Cannot export state: a reorg is in progress")
+)
+
+// AccountBalance is the balance of an account
+// in a StateBundle.
+type AccountBalance struct {
+	Account *rosetta.AccountIdentifier
+	Amounts []*rosetta.Amount
+
+	// Block is the block the balance
+	// was last updated at.
+	Block *rosetta.BlockIdentifier
+}
+
+// StateBundle is a compact snapshot of validated state
+// that another validator can import to begin validating
+// from Head without syncing the blocks before it.
+type StateBundle struct {
+	// Head is the last block whose balance
+	// changes are included in Balances.
+	Head *rosetta.BlockIdentifier
+
+	// Blocks are the most recent blocks up to and
+	// including Head, oldest first. They are the
+	// checkpoints an importing validator resumes from
+	// and allow it to handle reorgs of up to
+	// len(Blocks)-1 blocks.
+	Blocks []*rosetta.Block
+
+	Balances   []*AccountBalance
+	Currencies []*RegisteredCurrency
+}
+
+func getAccountIndexKey(hasher KeyHasher, account *rosetta.AccountIdentifier) []byte {
+	return hasher.Hash([]byte(fmt.Sprintf(
+		"%s:%x",
+		accountIndexNamespace,
+		getBalanceKey(hasher, account),
+	)))
+}
+
+// indexAccount appends account to the account index
+// read by BalanceAccounts, unless it is already indexed.
+func (b *BlockStorage) indexAccount(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	account *rosetta.AccountIdentifier,
+) error {
+	key := getAccountIndexKey(b.keyHasher, account)
+	exists, _, err := transaction.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return nil
+	}
+
+	if err := transaction.Set(ctx, key, []byte("")); err != nil {
+		return err
+	}
+
+	return b.appendStream(ctx, transaction, accountIndexNamespace, account)
+}
+
+// BalanceAccounts returns every account with a balance
+// change. Accounts are read from the account index and
+// from the balance change stream (which includes accounts
+// whose balance changed before the index was added).
+func (b *BlockStorage) BalanceAccounts(
+	ctx context.Context,
+) ([]*rosetta.AccountIdentifier, error) {
+	seen := map[string]bool{}
+	accounts := []*rosetta.AccountIdentifier{}
+	add := func(account *rosetta.AccountIdentifier) {
+		key := string(getBalanceKey(b.keyHasher, account))
+		if seen[key] {
+			return
+		}
+
+		seen[key] = true
+		accounts = append(accounts, account)
+	}
+
+	for cursor := int64(0); ; {
+		var read int
+		var err error
+		cursor, err = b.readStream(ctx, accountIndexNamespace, cursor, importBatchSize, func(value []byte) error {
+			var account rosetta.AccountIdentifier
+			if err := decodeValue(value, &account); err != nil {
+				return err
+			}
+
+			add(&account)
+			read++
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if read == 0 {
+			break
+		}
+	}
+
+	for cursor := int64(0); ; {
+		var changes []*BalanceChange
+		var err error
+		changes, cursor, err = b.BalanceChanges(ctx, cursor, importBatchSize)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(changes) == 0 {
+			return accounts, nil
+		}
+
+		for _, change := range changes {
+			add(change.Account)
+		}
+	}
+}
+
+// ExportState returns a StateBundle of the balances as of
+// the last confirmed block and up to blocks of the most
+// recent blocks ending at it.
+func (b *BlockStorage) ExportState(
+	ctx context.Context,
+	blocks int,
+) (*StateBundle, error) {
+	transaction := b.db.NewDatabaseTransaction(ctx, false)
+	defer transaction.Discard(ctx)
+
+	intent, err := b.GetReorgIntent(ctx, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	if intent != nil {
+		return nil, ErrReorgInProgress
+	}
+
+	// Balances only include the balance changes of
+	// confirmed blocks, so pending blocks are omitted.
+	head, err := b.GetConfirmedBlockIdentifier(ctx, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &StateBundle{
+		Head:     head,
+		Blocks:   []*rosetta.Block{},
+		Balances: []*AccountBalance{},
+	}
+
+	current := head
+	for len(bundle.Blocks) < blocks {
+		block, err := b.GetUnprunedBlock(ctx, transaction, current)
+		if errors.Is(err, ErrBlockNotFound) || errors.Is(err, ErrBlockPruned) {
+			// Older blocks have been pruned.
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		bundle.Blocks = append([]*rosetta.Block{block}, bundle.Blocks...)
+		if block.ParentBlockIdentifier.Index == current.Index {
+			break
+		}
+
+		current = block.ParentBlockIdentifier
+	}
+
+	if len(bundle.Blocks) == 0 {
+		return nil, fmt.Errorf("%w %+v", ErrBlockNotFound, head)
+	}
+
+	accounts, err := b.BalanceAccounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, account := range accounts {
+		amounts, block, err := b.GetBalance(ctx, transaction, account)
+		if err != nil {
+			return nil, err
+		}
+
+		balance := &AccountBalance{
+			Account: account,
+			Amounts: []*rosetta.Amount{},
+			Block:   block,
+		}
+		for _, amount := range amounts {
+			balance.Amounts = append(balance.Amounts, amount)
+		}
+
+		bundle.Balances = append(bundle.Balances, balance)
+	}
+
+	bundle.Currencies, err = b.Currencies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return bundle, nil
+}
+
+// ImportState stores the state in a StateBundle so that
+// syncing resumes after bundle.Head. Balances are imported
+// in batches and the head is stored last, so a failed
+// import leaves partial balances behind (and must be
+// retried with a new database).
+func (b *BlockStorage) ImportState(
+	ctx context.Context,
+	bundle *StateBundle,
+) error {
+	if bundle.Head == nil || len(bundle.Blocks) == 0 {
+		return errors.New("state bundle does not include a head block")
+	}
+
+	last := bundle.Blocks[len(bundle.Blocks)-1].BlockIdentifier
+	if last.Hash != bundle.Head.Hash || last.Index != bundle.Head.Index {
+		return fmt.Errorf("state bundle does not include head block %+v", bundle.Head)
+	}
+
+	transaction := b.db.NewDatabaseTransaction(ctx, false)
+	_, err := b.GetHeadBlockIdentifier(ctx, transaction)
+	transaction.Discard(ctx)
+	if err == nil {
+		return ErrStateNotEmpty
+	}
+	if !errors.Is(err, ErrHeadBlockNotFound) {
+		return err
+	}
+
+	for start := 0; start < len(bundle.Balances); start += importBatchSize {
+		end := start + importBatchSize
+		if end > len(bundle.Balances) {
+			end = len(bundle.Balances)
+		}
+
+		err := b.Update(ctx, func(transaction DatabaseTransaction) error {
+			for _, balance := range bundle.Balances[start:end] {
+				changes := []*BalanceChange{}
+				for _, amount := range balance.Amounts {
+					err := b.UpdateBalance(ctx, transaction, balance.Account, amount, balance.Block)
+					if err != nil {
+						return err
+					}
+
+					changes = append(changes, &BalanceChange{
+						Account:    balance.Account,
+						Currency:   amount.Currency,
+						Block:      balance.Block,
+						Difference: amount.Value,
+					})
+				}
+
+				// The imported balances are recorded as balance changes
+				// so that the accounts are included in later exports.
+				if err := b.StoreBalanceChanges(ctx, transaction, changes); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return b.Update(ctx, func(transaction DatabaseTransaction) error {
+		for _, currency := range bundle.Currencies {
+			_, _, err := b.RegisterCurrency(
+				ctx,
+				transaction,
+				currency.Currency,
+				currency.FirstSeen,
+				currency.Tracked,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, block := range bundle.Blocks {
+			if err := b.StoreBlock(ctx, transaction, block); err != nil {
+				return err
+			}
+		}
+
+		return b.StoreHeadBlockIdentifier(ctx, transaction, bundle.Head)
+	})
+}
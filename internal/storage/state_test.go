@@ -0,0 +1,159 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateExportImport(t *testing.T) {
+	var (
+		currency = &rosetta.Currency{
+			Symbol:   "BLAH",
+			Decimals: 2,
+		}
+		account = &rosetta.AccountIdentifier{
+			Address: "acct1",
+		}
+		genesis = &rosetta.Block{
+			BlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "0",
+				Index: 0,
+			},
+			ParentBlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "0",
+				Index: 0,
+			},
+		}
+		block1 = &rosetta.Block{
+			BlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "1",
+				Index: 1,
+			},
+			ParentBlockIdentifier: genesis.BlockIdentifier,
+		}
+		block2 = &rosetta.Block{
+			BlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "2",
+				Index: 2,
+			},
+			ParentBlockIdentifier: block1.BlockIdentifier,
+		}
+	)
+	ctx := context.Background()
+
+	openStorage := func() (*BlockStorage, func()) {
+		newDir, err := CreateTempDir()
+		assert.NoError(t, err)
+
+		database, err := NewBadgerStorage(ctx, *newDir)
+		assert.NoError(t, err)
+
+		return NewBlockStorage(ctx, database, &GobCodec{}, &SHA256KeyHasher{}), func() {
+			database.Close(ctx)
+			RemoveTempDir(*newDir)
+		}
+	}
+
+	source, closeSource := openStorage()
+	defer closeSource()
+
+	txn := source.NewDatabaseTransaction(ctx, true)
+	for _, block := range []*rosetta.Block{genesis, block1, block2} {
+		assert.NoError(t, source.StoreBlock(ctx, txn, block))
+		assert.NoError(t, source.StoreHeadBlockIdentifier(ctx, txn, block.BlockIdentifier))
+	}
+
+	for _, change := range []*BalanceChange{
+		{Account: account, Currency: currency, Block: block1.BlockIdentifier, Difference: "100"},
+		{Account: account, Currency: currency, Block: block2.BlockIdentifier, Difference: "-30"},
+	} {
+		amount := &rosetta.Amount{Value: change.Difference, Currency: change.Currency}
+		assert.NoError(t, source.UpdateBalance(ctx, txn, change.Account, amount, change.Block))
+		assert.NoError(t, source.StoreBalanceChanges(ctx, txn, []*BalanceChange{change}))
+	}
+	_, _, err := source.RegisterCurrency(ctx, txn, currency, block1.BlockIdentifier, true)
+	assert.NoError(t, err)
+	assert.NoError(t, txn.Commit(ctx))
+
+	var bundle *StateBundle
+	t.Run("Export", func(t *testing.T) {
+		bundle, err = source.ExportState(ctx, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, block2.BlockIdentifier, bundle.Head)
+		assert.Equal(t, []*rosetta.Block{block1, block2}, bundle.Blocks)
+		assert.Equal(t, []*AccountBalance{
+			{
+				Account: account,
+				Amounts: []*rosetta.Amount{{Value: "70", Currency: currency}},
+				Block:   block2.BlockIdentifier,
+			},
+		}, bundle.Balances)
+		assert.Len(t, bundle.Currencies, 1)
+	})
+
+	t.Run("Import", func(t *testing.T) {
+		destination, closeDestination := openStorage()
+		defer closeDestination()
+
+		assert.NoError(t, destination.ImportState(ctx, bundle))
+
+		txn := destination.NewDatabaseTransaction(ctx, false)
+		defer txn.Discard(ctx)
+
+		head, err := destination.GetHeadBlockIdentifier(ctx, txn)
+		assert.NoError(t, err)
+		assert.Equal(t, block2.BlockIdentifier, head)
+
+		amounts, _, err := destination.GetBalance(ctx, txn, account)
+		assert.NoError(t, err)
+		assert.Equal(t, "70", amounts[GetCurrencyKey(currency)].Value)
+
+		cache, err := destination.CreateBlockCache(ctx, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, []*rosetta.BlockIdentifier{
+			genesis.BlockIdentifier,
+			block1.BlockIdentifier,
+			block2.BlockIdentifier,
+		}, cache)
+
+		// The imported state can be exported again
+		reexported, err := destination.ExportState(ctx, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, bundle, reexported)
+	})
+
+	t.Run("Import into synced storage", func(t *testing.T) {
+		err := source.ImportState(ctx, bundle)
+		assert.True(t, errors.Is(err, ErrStateNotEmpty))
+	})
+
+	t.Run("Import without head block", func(t *testing.T) {
+		destination, closeDestination := openStorage()
+		defer closeDestination()
+
+		err := destination.ImportState(ctx, &StateBundle{
+			Head:   block2.BlockIdentifier,
+			Blocks: []*rosetta.Block{block1},
+		})
+		assert.Error(t, err)
+	})
+}
@@ -16,6 +16,26 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"time"
+)
+
+const (
+	// maxConflictRetries is the number of times Update
+	// retries a transaction that failed to commit because
+	// of a conflicting concurrent transaction.
+	maxConflictRetries = 5
+
+	// conflictBackoff is the time Update waits before the
+	// first retry. It doubles after each retry.
+	conflictBackoff = 10 * time.Millisecond
+)
+
+var (
+	// ErrTransactionConflict is returned by
+	// DatabaseTransaction.Commit when a concurrent
+	// transaction modified a key read by the transaction.
+	ErrTransactionConflict = errors.New("Transaction conflict")
 )
 
 // Database is an interface that provides transactional
@@ -27,6 +47,13 @@ type Database interface {
 	Get(context.Context, []byte) (bool, []byte, error)
 }
 
+// GarbageCollector is implemented by a Database that
+// must explicitly reclaim the space used by deleted
+// or overwritten values.
+type GarbageCollector interface {
+	GarbageCollect(context.Context) error
+}
+
 // DatabaseTransaction is an interface that provides
 // access to a KV store within some transaction
 // context provided by a Database.
@@ -37,3 +64,87 @@ type DatabaseTransaction interface {
 	Commit(context.Context) error
 	Discard(context.Context)
 }
+
+// valueReader is implemented by a DatabaseTransaction that
+// can pass a stored value to fn without copying it (ex: to
+// decode a block). The value must not be used after fn
+// returns.
+type valueReader interface {
+	ReadValue(ctx context.Context, key []byte, fn func([]byte) error) (bool, error)
+}
+
+// readValue calls fn with the value of key, if it exists,
+// without copying it if transaction is a valueReader. fn
+// must not retain the value.
+func readValue(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	key []byte,
+	fn func([]byte) error,
+) (bool, error) {
+	if reader, ok := transaction.(valueReader); ok {
+		return reader.ReadValue(ctx, key, fn)
+	}
+
+	exists, value, err := transaction.Get(ctx, key)
+	if err != nil || !exists {
+		return exists, err
+	}
+
+	return true, fn(value)
+}
+
+// Update runs fn in a new write transaction and commits it.
+// If the commit fails with ErrTransactionConflict, fn is run
+// again in a fresh transaction (so it reads the values written
+// by the conflicting transaction) up to maxConflictRetries
+// times. If fn fails with ErrTransactionTooBig and db backs
+// a BlockStorage, it is run again and its writes are committed
+// in chunks (see updateChunked). Because fn may run more than
+// once, it must not have side effects outside of the
+// transaction.
+func Update(
+	ctx context.Context,
+	db Database,
+	fn func(DatabaseTransaction) error,
+) error {
+	backoff := conflictBackoff
+	for retries := 0; ; retries++ {
+		err := runTransaction(ctx, db, fn)
+		if chunked, ok := db.(*chunkedDatabase); ok && errors.Is(err, ErrTransactionTooBig) {
+			return chunked.updateChunked(ctx, fn)
+		}
+
+		if !errors.Is(err, ErrTransactionConflict) || retries == maxConflictRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func runTransaction(
+	ctx context.Context,
+	db Database,
+	fn func(DatabaseTransaction) error,
+) error {
+	transaction := db.NewDatabaseTransaction(ctx, true)
+	defer transaction.Discard(ctx)
+
+	if err := fn(transaction); err != nil {
+		return err
+	}
+
+	// Don't commit changes the caller is no
+	// longer waiting for (ex: after a timeout).
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return transaction.Commit(ctx)
+}
@@ -0,0 +1,551 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+const (
+	// blockStreamNamespace is prepended to the sequence
+	// number of any BlockEvent.
+	blockStreamNamespace = "block-stream"
+
+	// balanceStreamNamespace is prepended to the sequence
+	// number of any BalanceChange.
+	balanceStreamNamespace = "balance-stream"
+
+	// findingStreamNamespace is prepended to the sequence
+	// number of any Finding.
+	findingStreamNamespace = "finding-stream"
+
+	// streamLengthKey is appended to a stream namespace to
+	// lookup the number of entries in the stream.
+	streamLengthKey = "length"
+
+	// streamStartKey is appended to a stream namespace to
+	// lookup the sequence number of its first entry that
+	// has not been pruned.
+	streamStartKey = "start"
+
+	// ReplicaMismatchFinding is the Finding.Type recorded
+	// when two replicas return different responses to
+	// an identical block request.
+	ReplicaMismatchFinding = "replica_mismatch"
+)
+
+// analyticsStreams are the namespaces of the streams
+// trimmed to the retention depth (see SetStreamRetention).
+var analyticsStreams = []string{
+	blockStreamNamespace,
+	balanceStreamNamespace,
+	findingStreamNamespace,
+}
+
+// BlockEvent records a block being added to or
+// orphaned from the canonical chain.
+type BlockEvent struct {
+	Block    *rosetta.BlockIdentifier
+	Orphaned bool
+}
+
+// Finding records a failed balance validation (ex: a
+// failed reconciliation or an invalid amount).
+type Finding struct {
+	Type       string
+	Account    *rosetta.AccountIdentifier
+	Currency   *rosetta.Currency
+	Block      *rosetta.BlockIdentifier
+	Difference string
+
+	// Labels are the labels of Account (if any).
+	Labels []string
+}
+
+func getStreamLengthKey(hasher KeyHasher, namespace string) []byte {
+	return hasher.Hash([]byte(fmt.Sprintf("%s:%s", namespace, streamLengthKey)))
+}
+
+func getStreamStartKey(hasher KeyHasher, namespace string) []byte {
+	return hasher.Hash([]byte(fmt.Sprintf("%s:%s", namespace, streamStartKey)))
+}
+
+func getStreamEntryKey(hasher KeyHasher, namespace string, sequence int64) []byte {
+	return hasher.Hash([]byte(fmt.Sprintf("%s:%d", namespace, sequence)))
+}
+
+// streamLength returns the number of entries
+// appended to a stream.
+func (b *BlockStorage) streamLength(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	namespace string,
+) (int64, error) {
+	exists, value, err := transaction.Get(ctx, getStreamLengthKey(b.keyHasher, namespace))
+	if err != nil {
+		return 0, err
+	}
+
+	if !exists {
+		return 0, nil
+	}
+
+	return strconv.ParseInt(string(value), 10, 64)
+}
+
+// streamStart returns the sequence number of the
+// first entry of a stream that has not been pruned.
+func (b *BlockStorage) streamStart(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	namespace string,
+) (int64, error) {
+	exists, value, err := transaction.Get(ctx, getStreamStartKey(b.keyHasher, namespace))
+	if err != nil {
+		return 0, err
+	}
+
+	if !exists {
+		return 0, nil
+	}
+
+	return strconv.ParseInt(string(value), 10, 64)
+}
+
+func (b *BlockStorage) setStreamStart(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	namespace string,
+	start int64,
+) error {
+	return transaction.Set(
+		ctx,
+		getStreamStartKey(b.keyHasher, namespace),
+		[]byte(strconv.FormatInt(start, 10)),
+	)
+}
+
+// appendStream appends an entry to a stream. Entries are
+// only removed from the end of a stream (see truncateStream)
+// or pruned from its start (see pruneStream), so the sequence
+// number of an entry (its position in the stream) never
+// changes.
+func (b *BlockStorage) appendStream(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	namespace string,
+	entry interface{},
+) error {
+	length, err := b.streamLength(ctx, transaction, namespace)
+	if err != nil {
+		return err
+	}
+
+	buf, err := encodeValue(b.codec, entry)
+	if err != nil {
+		return err
+	}
+
+	err = transaction.Set(ctx, getStreamEntryKey(b.keyHasher, namespace, length), buf)
+	if err != nil {
+		return err
+	}
+
+	return transaction.Set(
+		ctx,
+		getStreamLengthKey(b.keyHasher, namespace),
+		[]byte(strconv.FormatInt(length+1, 10)),
+	)
+}
+
+// truncateStream removes the entries of a stream
+// after the first length entries.
+func (b *BlockStorage) truncateStream(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	namespace string,
+	length int64,
+) error {
+	current, err := b.streamLength(ctx, transaction, namespace)
+	if err != nil {
+		return err
+	}
+
+	if length >= current {
+		return nil
+	}
+
+	start, err := b.streamStart(ctx, transaction, namespace)
+	if err != nil {
+		return err
+	}
+
+	if length < start {
+		if err := b.setStreamStart(ctx, transaction, namespace, length); err != nil {
+			return err
+		}
+	} else {
+		start = length
+	}
+
+	for sequence := start; sequence < current; sequence++ {
+		err := transaction.Delete(ctx, getStreamEntryKey(b.keyHasher, namespace, sequence))
+		if err != nil {
+			return err
+		}
+	}
+
+	return transaction.Set(
+		ctx,
+		getStreamLengthKey(b.keyHasher, namespace),
+		[]byte(strconv.FormatInt(length, 10)),
+	)
+}
+
+// readStream calls decode with up to limit entries of a
+// stream, starting at cursor (or the first entry that has
+// not been pruned, if cursor was pruned). It returns the
+// cursor to provide to the next call to readStream to
+// resume reading after the last returned entry. Entries
+// are not copied, so decode must not retain them.
+func (b *BlockStorage) readStream(
+	ctx context.Context,
+	namespace string,
+	cursor int64,
+	limit int,
+	decode func([]byte) error,
+) (int64, error) {
+	transaction := b.db.NewDatabaseTransaction(ctx, false)
+	defer transaction.Discard(ctx)
+
+	length, err := b.streamLength(ctx, transaction, namespace)
+	if err != nil {
+		return cursor, err
+	}
+
+	start, err := b.streamStart(ctx, transaction, namespace)
+	if err != nil {
+		return cursor, err
+	}
+
+	if cursor < start {
+		cursor = start
+	}
+
+	for i := 0; i < limit && cursor < length; i++ {
+		exists, err := readValue(ctx, transaction, getStreamEntryKey(b.keyHasher, namespace, cursor), decode)
+		if err != nil {
+			return cursor, err
+		}
+
+		if !exists {
+			return cursor, fmt.Errorf("%s entry %d missing", namespace, cursor)
+		}
+
+		cursor++
+	}
+
+	return cursor, nil
+}
+
+// readStreamReverse calls decode with each entry of a
+// stream, starting with the most recent, until decode
+// returns false or every entry that has not been pruned
+// has been read. Like readStream, decode must not retain
+// the entries.
+func (b *BlockStorage) readStreamReverse(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	namespace string,
+	decode func([]byte) (bool, error),
+) error {
+	length, err := b.streamLength(ctx, transaction, namespace)
+	if err != nil {
+		return err
+	}
+
+	start, err := b.streamStart(ctx, transaction, namespace)
+	if err != nil {
+		return err
+	}
+
+	for cursor := length - 1; cursor >= start; cursor-- {
+		more := false
+		exists, err := readValue(ctx, transaction, getStreamEntryKey(b.keyHasher, namespace, cursor), func(value []byte) error {
+			var err error
+			more, err = decode(value)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			return fmt.Errorf("%s entry %d missing", namespace, cursor)
+		}
+
+		if !more {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// streamEntry decodes the block (and account, if any)
+// of an entry of any analytics stream.
+type streamEntry struct {
+	Account *rosetta.AccountIdentifier
+	Block   *rosetta.BlockIdentifier
+}
+
+// pruneStream removes up to pruneBatchSize entries from the
+// start of a stream that were recorded for blocks at or
+// below index and returns the number of entries removed.
+// Entries are appended in the order blocks are processed,
+// so pruning stops at the first entry of a later block. The
+// accounts of pruned balance changes are indexed first (see
+// BalanceAccounts).
+func (b *BlockStorage) pruneStream(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	namespace string,
+	index int64,
+) (int, error) {
+	length, err := b.streamLength(ctx, transaction, namespace)
+	if err != nil {
+		return 0, err
+	}
+
+	start, err := b.streamStart(ctx, transaction, namespace)
+	if err != nil {
+		return 0, err
+	}
+
+	sequence := start
+	for ; sequence < length && sequence-start < pruneBatchSize; sequence++ {
+		key := getStreamEntryKey(b.keyHasher, namespace, sequence)
+		exists, value, err := transaction.Get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+
+		if !exists {
+			return 0, fmt.Errorf("%s entry %d missing", namespace, sequence)
+		}
+
+		var entry streamEntry
+		if err := decodeValue(value, &entry); err != nil {
+			return 0, err
+		}
+
+		if entry.Block != nil && entry.Block.Index > index {
+			break
+		}
+
+		if namespace == balanceStreamNamespace {
+			if err := b.indexAccount(ctx, transaction, entry.Account); err != nil {
+				return 0, err
+			}
+		}
+
+		if err := transaction.Delete(ctx, key); err != nil {
+			return 0, err
+		}
+	}
+
+	if sequence == start {
+		return 0, nil
+	}
+
+	return int(sequence - start), b.setStreamStart(ctx, transaction, namespace, sequence)
+}
+
+// appendAnalyticsStream appends an entry recorded for the
+// block at index to an analytics stream and removes the
+// entries recorded for blocks more than the retention depth
+// below it (see SetStreamRetention).
+func (b *BlockStorage) appendAnalyticsStream(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	namespace string,
+	index int64,
+	entry interface{},
+) error {
+	if err := b.appendStream(ctx, transaction, namespace, entry); err != nil {
+		return err
+	}
+
+	if b.streamRetention <= 0 {
+		return nil
+	}
+
+	_, err := b.pruneStream(ctx, transaction, namespace, index-b.streamRetention)
+	return err
+}
+
+// pruneStreams removes the entries of the analytics streams
+// (see SetStreamRetention) recorded for blocks at or below
+// index.
+func (b *BlockStorage) pruneStreams(ctx context.Context, index int64) error {
+	for _, namespace := range analyticsStreams {
+		for {
+			var pruned int
+			err := b.Update(ctx, func(transaction DatabaseTransaction) error {
+				var err error
+				pruned, err = b.pruneStream(ctx, transaction, namespace, index)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+
+			if pruned < pruneBatchSize {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// BlockEvents returns up to limit BlockEvents, starting at
+// cursor, in the order they occurred and the cursor to resume
+// reading from. A cursor of 0 reads from the first event.
+func (b *BlockStorage) BlockEvents(
+	ctx context.Context,
+	cursor int64,
+	limit int,
+) ([]*BlockEvent, int64, error) {
+	events := []*BlockEvent{}
+	next, err := b.readStream(ctx, blockStreamNamespace, cursor, limit, func(value []byte) error {
+		var event BlockEvent
+		if err := decodeValue(value, &event); err != nil {
+			return err
+		}
+
+		events = append(events, &event)
+		return nil
+	})
+
+	return events, next, err
+}
+
+// StoreBalanceChanges appends balance changes to the
+// stream read by BalanceChanges and indexes their
+// accounts (see BalanceAccounts).
+func (b *BlockStorage) StoreBalanceChanges(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	changes []*BalanceChange,
+) error {
+	for _, change := range changes {
+		if err := b.indexAccount(ctx, transaction, change.Account); err != nil {
+			return err
+		}
+
+		err := b.appendAnalyticsStream(
+			ctx,
+			transaction,
+			balanceStreamNamespace,
+			change.Block.Index,
+			change,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// BalanceChanges returns up to limit BalanceChanges, starting
+// at cursor, in the order they were applied and the cursor to
+// resume reading from. A cursor of 0 reads from the first
+// change.
+func (b *BlockStorage) BalanceChanges(
+	ctx context.Context,
+	cursor int64,
+	limit int,
+) ([]*BalanceChange, int64, error) {
+	changes := []*BalanceChange{}
+	next, err := b.readStream(ctx, balanceStreamNamespace, cursor, limit, func(value []byte) error {
+		var change BalanceChange
+		if err := decodeValue(value, &change); err != nil {
+			return err
+		}
+
+		changes = append(changes, &change)
+		return nil
+	})
+
+	return changes, next, err
+}
+
+// StoreFinding appends a Finding to the stream
+// read by Findings in its own transaction.
+func (b *BlockStorage) StoreFinding(
+	ctx context.Context,
+	finding *Finding,
+) error {
+	return b.Update(ctx, func(transaction DatabaseTransaction) error {
+		if finding.Block == nil {
+			return b.appendStream(ctx, transaction, findingStreamNamespace, finding)
+		}
+
+		return b.appendAnalyticsStream(
+			ctx,
+			transaction,
+			findingStreamNamespace,
+			finding.Block.Index,
+			finding,
+		)
+	})
+}
+
+// Findings returns up to limit Findings, starting at cursor,
+// in the order they were found and the cursor to resume
+// reading from. A cursor of 0 reads from the first finding.
+func (b *BlockStorage) Findings(
+	ctx context.Context,
+	cursor int64,
+	limit int,
+) ([]*Finding, int64, error) {
+	findings := []*Finding{}
+	next, err := b.readStream(ctx, findingStreamNamespace, cursor, limit, func(value []byte) error {
+		var finding Finding
+		if err := decodeValue(value, &finding); err != nil {
+			return err
+		}
+
+		findings = append(findings, &finding)
+		return nil
+	})
+
+	return findings, next, err
+}
+
+// FindingCount returns the number of Findings that
+// have been stored (including those that were pruned).
+func (b *BlockStorage) FindingCount(ctx context.Context) (int64, error) {
+	transaction := b.db.NewDatabaseTransaction(ctx, false)
+	defer transaction.Discard(ctx)
+
+	return b.streamLength(ctx, transaction, findingStreamNamespace)
+}
@@ -0,0 +1,278 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreams(t *testing.T) {
+	var (
+		block = &rosetta.Block{
+			BlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "1",
+				Index: 1,
+			},
+			ParentBlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "0",
+				Index: 0,
+			},
+		}
+		account = &rosetta.AccountIdentifier{
+			Address: "acct1",
+		}
+		currency = &rosetta.Currency{
+			Symbol:   "BLAH",
+			Decimals: 2,
+		}
+		change = &BalanceChange{
+			Account:    account,
+			Currency:   currency,
+			Block:      block.BlockIdentifier,
+			Difference: "100",
+		}
+		finding = &Finding{
+			Type:       "ACTIVE",
+			Account:    account,
+			Currency:   currency,
+			Block:      block.BlockIdentifier,
+			Difference: "-1",
+		}
+	)
+	ctx := context.Background()
+
+	newDir, err := CreateTempDir()
+	assert.NoError(t, err)
+	defer RemoveTempDir(*newDir)
+
+	database, err := NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	storage := NewBlockStorage(ctx, database, &GobCodec{}, &SHA256KeyHasher{})
+
+	t.Run("Empty streams", func(t *testing.T) {
+		events, cursor, err := storage.BlockEvents(ctx, 0, 10)
+		assert.NoError(t, err)
+		assert.Len(t, events, 0)
+		assert.Equal(t, int64(0), cursor)
+
+		changes, cursor, err := storage.BalanceChanges(ctx, 0, 10)
+		assert.NoError(t, err)
+		assert.Len(t, changes, 0)
+		assert.Equal(t, int64(0), cursor)
+
+		findings, cursor, err := storage.Findings(ctx, 0, 10)
+		assert.NoError(t, err)
+		assert.Len(t, findings, 0)
+		assert.Equal(t, int64(0), cursor)
+
+		count, err := storage.FindingCount(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), count)
+	})
+
+	t.Run("Block events", func(t *testing.T) {
+		txn := storage.NewDatabaseTransaction(ctx, true)
+		assert.NoError(t, storage.StoreBlock(ctx, txn, block))
+		assert.NoError(t, storage.RemoveBlock(ctx, txn, block.BlockIdentifier))
+		assert.NoError(t, storage.StoreBlock(ctx, txn, block))
+		assert.NoError(t, txn.Commit(ctx))
+
+		events, cursor, err := storage.BlockEvents(ctx, 0, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, []*BlockEvent{
+			{Block: block.BlockIdentifier},
+			{Block: block.BlockIdentifier, Orphaned: true},
+		}, events)
+		assert.Equal(t, int64(2), cursor)
+
+		// Resume from the returned cursor
+		events, cursor, err = storage.BlockEvents(ctx, cursor, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, []*BlockEvent{
+			{Block: block.BlockIdentifier},
+		}, events)
+		assert.Equal(t, int64(3), cursor)
+
+		events, cursor, err = storage.BlockEvents(ctx, cursor, 2)
+		assert.NoError(t, err)
+		assert.Len(t, events, 0)
+		assert.Equal(t, int64(3), cursor)
+	})
+
+	t.Run("Discarded balance changes", func(t *testing.T) {
+		txn := storage.NewDatabaseTransaction(ctx, true)
+		assert.NoError(t, storage.StoreBalanceChanges(ctx, txn, []*BalanceChange{change}))
+		txn.Discard(ctx)
+
+		changes, cursor, err := storage.BalanceChanges(ctx, 0, 10)
+		assert.NoError(t, err)
+		assert.Len(t, changes, 0)
+		assert.Equal(t, int64(0), cursor)
+	})
+
+	t.Run("Balance changes", func(t *testing.T) {
+		txn := storage.NewDatabaseTransaction(ctx, true)
+		assert.NoError(t, storage.StoreBalanceChanges(ctx, txn, []*BalanceChange{change, change}))
+		assert.NoError(t, txn.Commit(ctx))
+
+		changes, cursor, err := storage.BalanceChanges(ctx, 1, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, []*BalanceChange{change}, changes)
+		assert.Equal(t, int64(2), cursor)
+	})
+
+	t.Run("Findings", func(t *testing.T) {
+		assert.NoError(t, storage.StoreFinding(ctx, finding))
+
+		findings, cursor, err := storage.Findings(ctx, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, []*Finding{finding}, findings)
+		assert.Equal(t, int64(1), cursor)
+
+		count, err := storage.FindingCount(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("Reconciliations", func(t *testing.T) {
+		otherAccount := &rosetta.AccountIdentifier{
+			Address: "acct2",
+		}
+		reconciliation := &Reconciliation{
+			Type:     "ACTIVE",
+			Account:  account,
+			Currency: currency,
+			Block:    block.BlockIdentifier,
+			Computed: "100",
+			Live:     "100",
+			Outcome:  ReconciliationSucceeded,
+		}
+		assert.NoError(t, storage.StoreReconciliation(ctx, reconciliation))
+		assert.NoError(t, storage.StoreReconciliation(ctx, &Reconciliation{
+			Type:     "ACTIVE",
+			Account:  otherAccount,
+			Currency: currency,
+			Block:    block.BlockIdentifier,
+			Outcome:  ReconciliationSkipped,
+		}))
+
+		reconciliations, cursor, err := storage.Reconciliations(ctx, account, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, []*Reconciliation{reconciliation}, reconciliations)
+		assert.Equal(t, int64(1), cursor)
+	})
+}
+
+func TestStreamRetention(t *testing.T) {
+	ctx := context.Background()
+
+	newDir, err := CreateTempDir()
+	assert.NoError(t, err)
+	defer RemoveTempDir(*newDir)
+
+	database, err := NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	storage := NewBlockStorage(ctx, database, &GobCodec{}, &SHA256KeyHasher{})
+	storage.SetStreamRetention(2)
+
+	identifier := func(index int64) *rosetta.BlockIdentifier {
+		return &rosetta.BlockIdentifier{
+			Hash:  fmt.Sprintf("%d", index),
+			Index: index,
+		}
+	}
+	account := func(index int64) *rosetta.AccountIdentifier {
+		return &rosetta.AccountIdentifier{
+			Address: fmt.Sprintf("acct%d", index),
+		}
+	}
+
+	for i := int64(1); i <= 5; i++ {
+		txn := storage.NewDatabaseTransaction(ctx, true)
+		assert.NoError(t, storage.StoreBlock(ctx, txn, &rosetta.Block{
+			BlockIdentifier:       identifier(i),
+			ParentBlockIdentifier: identifier(i - 1),
+		}))
+		assert.NoError(t, storage.StoreBalanceChanges(ctx, txn, []*BalanceChange{
+			{
+				Account:    account(i),
+				Currency:   &rosetta.Currency{Symbol: "BLAH", Decimals: 2},
+				Block:      identifier(i),
+				Difference: "100",
+			},
+		}))
+		assert.NoError(t, txn.Commit(ctx))
+	}
+
+	t.Run("Pruned cursor resumes from oldest entry", func(t *testing.T) {
+		events, cursor, err := storage.BlockEvents(ctx, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, []*BlockEvent{
+			{Block: identifier(4)},
+			{Block: identifier(5)},
+		}, events)
+		assert.Equal(t, int64(5), cursor)
+
+		changes, cursor, err := storage.BalanceChanges(ctx, 1, 10)
+		assert.NoError(t, err)
+		assert.Len(t, changes, 2)
+		assert.Equal(t, identifier(4), changes[0].Block)
+		assert.Equal(t, int64(5), cursor)
+	})
+
+	t.Run("Accounts of pruned changes", func(t *testing.T) {
+		accounts, err := storage.BalanceAccounts(ctx)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []*rosetta.AccountIdentifier{
+			account(1),
+			account(2),
+			account(3),
+			account(4),
+			account(5),
+		}, accounts)
+	})
+
+	t.Run("Finding count includes pruned findings", func(t *testing.T) {
+		for i := int64(1); i <= 2; i++ {
+			assert.NoError(t, storage.StoreFinding(ctx, &Finding{
+				Type:       "ACTIVE",
+				Account:    account(i),
+				Block:      identifier(i),
+				Difference: "-1",
+			}))
+		}
+
+		txn := storage.NewDatabaseTransaction(ctx, true)
+		assert.NoError(t, storage.StoreBlock(ctx, txn, &rosetta.Block{
+			BlockIdentifier:       identifier(6),
+			ParentBlockIdentifier: identifier(5),
+		}))
+		assert.NoError(t, txn.Commit(ctx))
+
+		count, err := storage.FindingCount(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), count)
+	})
+}
@@ -0,0 +1,231 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+const (
+	// violationStreamNamespace is prepended to the
+	// sequence number of any Violation.
+	violationStreamNamespace = "violation-stream"
+
+	// violationBlockNamespace is prepended to the
+	// block of the sequence numbers of its Violations.
+	violationBlockNamespace = "violation-block"
+
+	// violationReadLimit is the number of Violations
+	// read at a time by ViolationCount.
+	violationReadLimit = 1000
+
+	// removedViolation is the Type of the entry that
+	// replaces a removed Violation.
+	removedViolation = "removed"
+)
+
+const (
+	// BlockViolation is the Type of a Violation of a block
+	// that failed assertion (ex: an invalid timestamp).
+	BlockViolation = "invalid_block"
+
+	// TransactionViolation is the Type of a Violation of a
+	// transaction that failed assertion (ex: a missing hash).
+	TransactionViolation = "invalid_transaction"
+
+	// OperationViolation is the Type of a Violation of an
+	// operation that failed assertion (ex: an unknown status
+	// or a duplicate index). Its balance change is skipped.
+	OperationViolation = "invalid_operation"
+
+	// NegativeBalanceViolation is the Type of a Violation
+	// of a balance change that would make a balance
+	// negative. The balance change is skipped.
+	NegativeBalanceViolation = "negative_balance"
+)
+
+// Violation is a failed correctness check of a block
+// that was recorded instead of stopping the validator
+// (see SyncHandler.SetContinueOnError). Transaction,
+// Operation, Account, and Currency are set if the
+// Violation concerns them.
+type Violation struct {
+	Type        string
+	Block       *rosetta.BlockIdentifier
+	Transaction *rosetta.TransactionIdentifier
+	Operation   *rosetta.OperationIdentifier
+	Account     *rosetta.AccountIdentifier
+	Currency    *rosetta.Currency
+	Message     string
+}
+
+// getViolationBlockKey returns the key of the sequence
+// numbers of the Violations of a block.
+func getViolationBlockKey(hasher KeyHasher, block *rosetta.BlockIdentifier) []byte {
+	return hasher.Hash(
+		[]byte(fmt.Sprintf("%s:%s:%d", violationBlockNamespace, block.Hash, block.Index)),
+	)
+}
+
+// violationSequences returns the sequence numbers
+// of the Violations of block.
+func (b *BlockStorage) violationSequences(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	block *rosetta.BlockIdentifier,
+) ([]int64, error) {
+	exists, value, err := transaction.Get(ctx, getViolationBlockKey(b.keyHasher, block))
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	var sequences []int64
+	if err := decodeValue(value, &sequences); err != nil {
+		return nil, err
+	}
+
+	return sequences, nil
+}
+
+// StoreViolations appends Violations to the violations
+// read by Violations in transaction, so that they are only
+// recorded if the block they concern is committed.
+func (b *BlockStorage) StoreViolations(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	violations []*Violation,
+) error {
+	for _, violation := range violations {
+		sequence, err := b.streamLength(ctx, transaction, violationStreamNamespace)
+		if err != nil {
+			return err
+		}
+
+		err = b.appendStream(ctx, transaction, violationStreamNamespace, violation)
+		if err != nil {
+			return err
+		}
+
+		sequences, err := b.violationSequences(ctx, transaction, violation.Block)
+		if err != nil {
+			return err
+		}
+
+		buf, err := encodeValue(b.codec, append(sequences, sequence))
+		if err != nil {
+			return err
+		}
+
+		err = transaction.Set(ctx, getViolationBlockKey(b.keyHasher, violation.Block), buf)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RemoveViolations removes the Violations of a block
+// (ex: because it was orphaned). The removed Violations
+// are replaced in the stream, so the sequence numbers of
+// the remaining Violations don't change.
+func (b *BlockStorage) RemoveViolations(
+	ctx context.Context,
+	transaction DatabaseTransaction,
+	block *rosetta.BlockIdentifier,
+) error {
+	sequences, err := b.violationSequences(ctx, transaction, block)
+	if err != nil {
+		return err
+	}
+
+	if len(sequences) == 0 {
+		return nil
+	}
+
+	removed, err := encodeValue(b.codec, &Violation{Type: removedViolation})
+	if err != nil {
+		return err
+	}
+
+	for _, sequence := range sequences {
+		key := getStreamEntryKey(b.keyHasher, violationStreamNamespace, sequence)
+		if err := transaction.Set(ctx, key, removed); err != nil {
+			return err
+		}
+	}
+
+	return transaction.Delete(ctx, getViolationBlockKey(b.keyHasher, block))
+}
+
+// Violations returns the Violations among up to limit
+// recorded Violations, starting at cursor, that have not
+// been removed, in the order they were recorded, and the
+// cursor to resume reading from. A cursor of 0 reads from
+// the first violation. Fewer Violations than limit (or
+// none) may be returned before the last one is read, so
+// reading is complete once the returned cursor is cursor.
+func (b *BlockStorage) Violations(
+	ctx context.Context,
+	cursor int64,
+	limit int,
+) ([]*Violation, int64, error) {
+	violations := []*Violation{}
+	next, err := b.readStream(ctx, violationStreamNamespace, cursor, limit, func(value []byte) error {
+		var violation Violation
+		if err := decodeValue(value, &violation); err != nil {
+			return err
+		}
+
+		if violation.Type != removedViolation {
+			violations = append(violations, &violation)
+		}
+
+		return nil
+	})
+
+	return violations, next, err
+}
+
+// ViolationCursor returns the cursor after the last
+// recorded Violation.
+func (b *BlockStorage) ViolationCursor(ctx context.Context) (int64, error) {
+	transaction := b.db.NewDatabaseTransaction(ctx, false)
+	defer transaction.Discard(ctx)
+
+	return b.streamLength(ctx, transaction, violationStreamNamespace)
+}
+
+// ViolationCount returns the number of Violations
+// recorded after cursor that have not been removed.
+func (b *BlockStorage) ViolationCount(ctx context.Context, cursor int64) (int64, error) {
+	count := int64(0)
+	for {
+		violations, next, err := b.Violations(ctx, cursor, violationReadLimit)
+		if err != nil {
+			return 0, err
+		}
+
+		if next == cursor {
+			return count, nil
+		}
+
+		count += int64(len(violations))
+		cursor = next
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestViolations(t *testing.T) {
+	ctx := context.Background()
+
+	newDir, err := CreateTempDir()
+	assert.NoError(t, err)
+	defer RemoveTempDir(*newDir)
+
+	database, err := NewBadgerStorage(ctx, *newDir)
+	assert.NoError(t, err)
+	defer database.Close(ctx)
+
+	storage := NewBlockStorage(ctx, database, &GobCodec{}, &SHA256KeyHasher{})
+	count, err := storage.ViolationCount(ctx, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+
+	block := &rosetta.BlockIdentifier{Hash: "1", Index: 1}
+	orphaned := &rosetta.BlockIdentifier{Hash: "2", Index: 2}
+	violations := []*Violation{
+		{
+			Type:        OperationViolation,
+			Block:       block,
+			Transaction: &rosetta.TransactionIdentifier{Hash: "tx"},
+			Operation:   &rosetta.OperationIdentifier{Index: 1},
+			Message:     "Operation.Status Pending is invalid",
+		},
+		{
+			Type:    BlockViolation,
+			Block:   orphaned,
+			Message: "Timestamp is invalid 0",
+		},
+		{
+			Type:     NegativeBalanceViolation,
+			Block:    block,
+			Account:  &rosetta.AccountIdentifier{Address: "addr"},
+			Currency: &rosetta.Currency{Symbol: "BLAH", Decimals: 2},
+			Message:  "Negative balance -1",
+		},
+	}
+
+	// Violations are only recorded if
+	// their transaction is committed.
+	err = storage.Update(ctx, func(txn DatabaseTransaction) error {
+		assert.NoError(t, storage.StoreViolations(ctx, txn, violations))
+		return errors.New("discarded")
+	})
+	assert.EqualError(t, err, "discarded")
+
+	cursor, err := storage.ViolationCursor(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), cursor)
+
+	err = storage.Update(ctx, func(txn DatabaseTransaction) error {
+		return storage.StoreViolations(ctx, txn, violations)
+	})
+	assert.NoError(t, err)
+
+	count, err = storage.ViolationCount(ctx, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	stored, next, err := storage.Violations(ctx, 0, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, violations[:1], stored)
+	assert.Equal(t, int64(1), next)
+
+	stored, next, err = storage.Violations(ctx, next, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, violations[1:], stored)
+	assert.Equal(t, int64(3), next)
+
+	// Removing the violations of a block keeps
+	// the cursors of the remaining violations.
+	err = storage.Update(ctx, func(txn DatabaseTransaction) error {
+		return storage.RemoveViolations(ctx, txn, orphaned)
+	})
+	assert.NoError(t, err)
+
+	stored, next, err = storage.Violations(ctx, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, violations[2:], stored)
+	assert.Equal(t, int64(3), next)
+
+	count, err = storage.ViolationCount(ctx, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	count, err = storage.ViolationCount(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	cursor, err = storage.ViolationCursor(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), cursor)
+}
@@ -0,0 +1,96 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// BlockTimeWindowSize is the number of blocks
+// summarized by each BlockTimeWindow.
+const BlockTimeWindowSize = 10000
+
+// BlockTimeWindow summarizes the time between the
+// timestamps of consecutive blocks in a range of blocks.
+// Block times that are implausible for the chain (ex: a
+// median of 12ms on a chain with 12s blocks) usually mean
+// the Rosetta Server returns timestamps in the wrong unit.
+type BlockTimeWindow struct {
+	StartIndex int64 `json:"start_index"`
+	EndIndex   int64 `json:"end_index"`
+
+	MinMS    int64 `json:"min_ms"`
+	MedianMS int64 `json:"median_ms"`
+	P99MS    int64 `json:"p99_ms"`
+}
+
+// blockTimes accumulates the block times of
+// the current BlockTimeWindow.
+type blockTimes struct {
+	startIndex int64
+	endIndex   int64
+	times      []time.Duration
+}
+
+// observe adds the block time of the block at index and
+// returns the BlockTimeWindow it completes (if any).
+func (b *blockTimes) observe(index int64, blockTime time.Duration) *BlockTimeWindow {
+	if len(b.times) == 0 {
+		b.startIndex = index
+	}
+
+	b.endIndex = index
+	b.times = append(b.times, blockTime)
+	if len(b.times) < BlockTimeWindowSize {
+		return nil
+	}
+
+	window := b.window()
+	b.times = nil
+	return window
+}
+
+// window returns the BlockTimeWindow of the block times
+// observed so far or nil if none have been observed.
+func (b *blockTimes) window() *BlockTimeWindow {
+	if len(b.times) == 0 {
+		return nil
+	}
+
+	sorted := make([]time.Duration, len(b.times))
+	copy(sorted, b.times)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	return &BlockTimeWindow{
+		StartIndex: b.startIndex,
+		EndIndex:   b.endIndex,
+		MinMS:      sorted[0].Milliseconds(),
+		MedianMS:   percentile(sorted, 0.5).Milliseconds(),
+		P99MS:      percentile(sorted, 0.99).Milliseconds(),
+	}
+}
+
+// percentile returns the nearest-rank percentile
+// p (0 < p <= 1) of sorted.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
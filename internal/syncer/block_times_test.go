@@ -0,0 +1,116 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/coinbase/rosetta-validator/internal/metrics"
+	mockSyncer "github.com/coinbase/rosetta-validator/mocks/syncer"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestBlockTimes(t *testing.T) {
+	times := &blockTimes{}
+	assert.Nil(t, times.window())
+
+	// Block i (starting at 1) takes i seconds, except
+	// for the last block of the window, which goes
+	// back in time.
+	for i := int64(1); i < BlockTimeWindowSize; i++ {
+		assert.Nil(t, times.observe(i, time.Duration(i)*time.Second))
+	}
+
+	assert.Equal(t, &BlockTimeWindow{
+		StartIndex: 1,
+		EndIndex:   BlockTimeWindowSize - 1,
+		MinMS:      1000,
+		MedianMS:   5000000,
+		P99MS:      9900000,
+	}, times.window())
+
+	window := times.observe(BlockTimeWindowSize, -time.Second)
+	assert.Equal(t, &BlockTimeWindow{
+		StartIndex: 1,
+		EndIndex:   BlockTimeWindowSize,
+		MinMS:      -1000,
+		MedianMS:   4999000,
+		P99MS:      9899000,
+	}, window)
+
+	// The next window starts empty.
+	assert.Nil(t, times.window())
+	assert.Nil(t, times.observe(BlockTimeWindowSize+1, time.Second))
+	assert.Equal(t, &BlockTimeWindow{
+		StartIndex: BlockTimeWindowSize + 1,
+		EndIndex:   BlockTimeWindowSize + 1,
+		MinMS:      1000,
+		MedianMS:   1000,
+		P99MS:      1000,
+	}, times.window())
+}
+
+func TestSummaryBlockTimes(t *testing.T) {
+	ctx := context.Background()
+	genesis := &rosetta.BlockIdentifier{Hash: "0", Index: 0}
+	block := func(index int64, timestamp int64) *rosetta.Block {
+		return &rosetta.Block{
+			BlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  fmt.Sprintf("%d", index),
+				Index: index,
+			},
+			ParentBlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  fmt.Sprintf("%d", index-1),
+				Index: index - 1,
+			},
+			Timestamp: timestamp,
+		}
+	}
+
+	handler := &mockSyncer.Handler{}
+	syncer := New(ctx, nil, nil, handler, nil, &metrics.NoOpSink{}, Timeouts{}, nil, []*rosetta.BlockIdentifier{
+		genesis,
+	})
+
+	handler.On("BlockAdded", mock.Anything, mock.Anything).Return(nil).Times(3)
+	assert.NoError(t, syncer.ProcessBlock(ctx, block(1, 1600000000000)))
+	assert.NoError(t, syncer.ProcessBlock(ctx, block(2, 1600000012000)))
+	assert.NoError(t, syncer.ProcessBlock(ctx, block(3, 1600000027000)))
+
+	// The timestamp of the parent of the first
+	// block is not known.
+	assert.Equal(t, RangeSummary{
+		StartIndex:      1,
+		EndIndex:        3,
+		BlocksProcessed: 3,
+		BlockTimes: []*BlockTimeWindow{
+			{
+				StartIndex: 2,
+				EndIndex:   3,
+				MinMS:      12000,
+				MedianMS:   12000,
+				P99MS:      15000,
+			},
+		},
+	}, syncer.Summary())
+	handler.AssertExpectations(t)
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"errors"
+	"time"
+)
+
+// FetchError is returned by SyncCycle when a request to the
+// node fails (after its retries are exhausted). Syncing may
+// succeed once the node recovers (see SetCircuitBreaker).
+type FetchError struct {
+	Err error
+}
+
+// Error returns a description of the FetchError.
+func (e *FetchError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error of the failed request.
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// fetchError wraps a non-nil err in a FetchError.
+func fetchError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &FetchError{Err: err}
+}
+
+// circuitBreaker pauses syncing after a FetchError. It is
+// open until a SyncCycle succeeds, waiting between attempts
+// for a backoff that doubles (up to maxBackoff) after each
+// failed attempt. Syncing fails once it has been open for
+// timeout.
+type circuitBreaker struct {
+	backoff    time.Duration
+	maxBackoff time.Duration
+	timeout    time.Duration
+
+	// opened is the time the circuitBreaker opened
+	// (zero if it is closed) and next is the
+	// backoff before the next attempt.
+	opened time.Time
+	next   time.Duration
+}
+
+// enabled returns true if the circuitBreaker
+// pauses syncing after a FetchError.
+func (b *circuitBreaker) enabled() bool {
+	return b.timeout > 0
+}
+
+// isOpen returns true if syncing is paused.
+func (b *circuitBreaker) isOpen() bool {
+	return !b.opened.IsZero()
+}
+
+// trip records a failed SyncCycle at now and returns the
+// time to wait before the next attempt. It returns false
+// if err is not a FetchError or the circuitBreaker has
+// been open for its timeout.
+func (b *circuitBreaker) trip(err error, now time.Time) (time.Duration, bool) {
+	var fetchErr *FetchError
+	if !b.enabled() || !errors.As(err, &fetchErr) {
+		return 0, false
+	}
+
+	if !b.isOpen() {
+		b.opened = now
+		b.next = b.backoff
+	}
+
+	if now.Sub(b.opened) >= b.timeout {
+		return 0, false
+	}
+
+	wait := b.next
+	b.next *= 2
+	if b.next > b.maxBackoff {
+		b.next = b.maxBackoff
+	}
+
+	return wait, true
+}
+
+// reset closes the circuitBreaker and returns
+// the time it was open.
+func (b *circuitBreaker) reset(now time.Time) time.Duration {
+	open := now.Sub(b.opened)
+	b.opened = time.Time{}
+	return open
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/coinbase/rosetta-validator/internal/metrics"
+	mockSyncer "github.com/coinbase/rosetta-validator/mocks/syncer"
+
+	"github.com/coinbase/rosetta-sdk-go/fetcher"
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	unavailable := fetchError(errors.New("503"))
+	b := &circuitBreaker{}
+
+	t.Run("Disabled", func(t *testing.T) {
+		_, ok := b.trip(unavailable, now)
+		assert.False(t, ok)
+		assert.False(t, b.isOpen())
+	})
+
+	b = &circuitBreaker{
+		backoff:    time.Second,
+		maxBackoff: 3 * time.Second,
+		timeout:    time.Minute,
+	}
+
+	t.Run("Not a fetch error", func(t *testing.T) {
+		_, ok := b.trip(errors.New("invalid block"), now)
+		assert.False(t, ok)
+		assert.False(t, b.isOpen())
+	})
+
+	t.Run("Exponential backoff", func(t *testing.T) {
+		for _, expected := range []time.Duration{
+			time.Second,
+			2 * time.Second,
+			3 * time.Second,
+			3 * time.Second,
+		} {
+			wait, ok := b.trip(unavailable, now)
+			assert.True(t, ok)
+			assert.Equal(t, expected, wait)
+			assert.True(t, b.isOpen())
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		_, ok := b.trip(unavailable, now.Add(time.Minute))
+		assert.False(t, ok)
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		assert.Equal(t, 10*time.Second, b.reset(now.Add(10*time.Second)))
+		assert.False(t, b.isOpen())
+
+		// The backoff starts over once the
+		// circuitBreaker opens again.
+		wait, ok := b.trip(unavailable, now.Add(2*time.Minute))
+		assert.True(t, ok)
+		assert.Equal(t, time.Second, wait)
+	})
+}
+
+func TestSyncCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	mockFetcher := &mockSyncer.Fetcher{}
+	syncer := New(ctx, nil, mockFetcher, nil, nil, &metrics.NoOpSink{}, Timeouts{}, nil, nil)
+	syncer.SetExitAtTip(true)
+
+	networkStatus := mockFetcher.On(
+		"NetworkStatusRetry",
+		mock.Anything,
+		mock.Anything,
+		fetcher.DefaultElapsedTime,
+		uint64(fetcher.DefaultRetries),
+	)
+
+	t.Run("Disabled", func(t *testing.T) {
+		networkStatus.Return(nil, errors.New("503")).Once()
+		err := syncer.Sync(ctx)
+		var fetchErr *FetchError
+		assert.True(t, errors.As(err, &fetchErr))
+	})
+
+	syncer.SetCircuitBreaker(time.Millisecond, 2*time.Millisecond, time.Minute)
+
+	t.Run("Resume once recovered", func(t *testing.T) {
+		mockFetcher.On(
+			"NetworkStatusRetry",
+			mock.Anything,
+			mock.Anything,
+			fetcher.DefaultElapsedTime,
+			uint64(fetcher.DefaultRetries),
+		).Return(nil, errors.New("503")).Times(3)
+		mockFetcher.On(
+			"NetworkStatusRetry",
+			mock.Anything,
+			mock.Anything,
+			fetcher.DefaultElapsedTime,
+			uint64(fetcher.DefaultRetries),
+		).Return(&rosetta.NetworkStatusResponse{
+			NetworkStatus: &rosetta.NetworkStatus{
+				NetworkInformation: &rosetta.NetworkInformation{
+					GenesisBlockIdentifier: blockSequenceNoReorg[0].BlockIdentifier,
+					CurrentBlockIdentifier: blockSequenceNoReorg[0].BlockIdentifier,
+				},
+			},
+		}, nil).Once()
+
+		err := syncer.Sync(ctx)
+		assert.True(t, errors.Is(err, ErrTipReached))
+		assert.False(t, syncer.breaker.isOpen())
+	})
+
+	mockFetcher.AssertExpectations(t)
+}
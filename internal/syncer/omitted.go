@@ -0,0 +1,279 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/coinbase/rosetta-sdk-go/fetcher"
+	"golang.org/x/sync/errgroup"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+const (
+	// omittedRetryInterval is the time waited before
+	// the first retry of a failed block request. It
+	// doubles with each retry.
+	omittedRetryInterval = 500 * time.Millisecond
+)
+
+// UnsafeFetcher is the subset of *fetcher.Fetcher
+// methods used by the OmittedBlockFetcher.
+type UnsafeFetcher interface {
+	Fetcher
+
+	BlockRange(
+		ctx context.Context,
+		network *rosetta.NetworkIdentifier,
+		startIndex int64,
+		endIndex int64,
+	) (map[int64]*fetcher.BlockAndLatency, error)
+
+	UnsafeBlock(
+		ctx context.Context,
+		network *rosetta.NetworkIdentifier,
+		blockIdentifier *rosetta.PartialBlockIdentifier,
+	) (*rosetta.Block, error)
+}
+
+// BlockAsserter asserts the correctness of a
+// block (ex: an *asserter.Asserter).
+type BlockAsserter interface {
+	Block(ctx context.Context, block *rosetta.Block) error
+}
+
+// OmittedBlockFetcher wraps an UnsafeFetcher for chains that
+// legitimately skip indices. A block response without a block
+// is returned as a nil block (instead of failing assertion),
+// which the Syncer skips. Every other block is asserted.
+type OmittedBlockFetcher struct {
+	UnsafeFetcher
+
+	asserter    BlockAsserter
+	concurrency uint64
+
+	maxElapsedTime time.Duration
+	maxRetries     uint64
+}
+
+// NewOmittedBlockFetcher returns a new OmittedBlockFetcher
+// that fetches up to concurrency blocks at once.
+func NewOmittedBlockFetcher(
+	blockFetcher UnsafeFetcher,
+	asserter BlockAsserter,
+	concurrency uint64,
+) *OmittedBlockFetcher {
+	if concurrency == 0 {
+		concurrency = 1
+	}
+
+	return &OmittedBlockFetcher{
+		UnsafeFetcher:  blockFetcher,
+		asserter:       asserter,
+		concurrency:    concurrency,
+		maxElapsedTime: fetcher.DefaultElapsedTime,
+		maxRetries:     fetcher.DefaultRetries,
+	}
+}
+
+// SetRetries changes the number of times (and the time
+// spent) retrying a failed block request in BlockRange
+// with exponential backoff. It must be called before any
+// blocks are fetched.
+func (f *OmittedBlockFetcher) SetRetries(maxElapsedTime time.Duration, maxRetries uint64) {
+	f.maxElapsedTime = maxElapsedTime
+	f.maxRetries = maxRetries
+}
+
+// block fetches the block at blockIdentifier, returning
+// nil if it was omitted.
+func (f *OmittedBlockFetcher) block(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	blockIdentifier *rosetta.PartialBlockIdentifier,
+) (*rosetta.Block, error) {
+	block, err := f.UnsafeBlock(ctx, network, blockIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	if block == nil {
+		return nil, nil
+	}
+
+	if err := f.asserter.Block(ctx, block); err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// BlockRetry fetches a single block, retrying with
+// exponential backoff up to maxRetries times (or until
+// maxElapsedTime has been spent retrying). It returns a
+// nil block if the block was omitted.
+func (f *OmittedBlockFetcher) BlockRetry(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	blockIdentifier *rosetta.PartialBlockIdentifier,
+	maxElapsedTime time.Duration,
+	maxRetries uint64,
+) (*rosetta.Block, error) {
+	return retryBlock(ctx, blockIdentifier, maxElapsedTime, maxRetries, func() (*rosetta.Block, error) {
+		return f.block(ctx, network, blockIdentifier)
+	})
+}
+
+// BlockRange concurrently fetches the blocks from startIndex
+// to endIndex, inclusive. Omitted blocks are included in the
+// returned map with a nil Block.
+func (f *OmittedBlockFetcher) BlockRange(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	startIndex int64,
+	endIndex int64,
+) (map[int64]*fetcher.BlockAndLatency, error) {
+	return concurrentBlockRange(ctx, startIndex, endIndex, f.concurrency, func(
+		ctx context.Context,
+		blockIdentifier *rosetta.PartialBlockIdentifier,
+	) (*rosetta.Block, error) {
+		return f.BlockRetry(ctx, network, blockIdentifier, f.maxElapsedTime, f.maxRetries)
+	})
+}
+
+// retryBlock calls fetch until it succeeds, retrying with
+// exponential backoff up to maxRetries times (or until
+// maxElapsedTime has been spent retrying).
+func retryBlock(
+	ctx context.Context,
+	blockIdentifier *rosetta.PartialBlockIdentifier,
+	maxElapsedTime time.Duration,
+	maxRetries uint64,
+	fetch func() (*rosetta.Block, error),
+) (*rosetta.Block, error) {
+	deadline := time.Now().Add(maxElapsedTime)
+	backoff := omittedRetryInterval
+	for attempt := uint64(0); ; attempt++ {
+		block, err := fetch()
+		if err == nil {
+			return block, nil
+		}
+
+		if attempt >= maxRetries || time.Now().Add(backoff).After(deadline) {
+			return nil, fmt.Errorf("%w: exhausted retries for block", err)
+		}
+
+		log.Printf("block %s fetch error: %v\n", describeBlockIdentifier(blockIdentifier), err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// concurrentBlockRange fetches the blocks from startIndex
+// to endIndex, inclusive, with up to concurrency calls to
+// fetch at once.
+func concurrentBlockRange(
+	ctx context.Context,
+	startIndex int64,
+	endIndex int64,
+	concurrency uint64,
+	fetch func(context.Context, *rosetta.PartialBlockIdentifier) (*rosetta.Block, error),
+) (map[int64]*fetcher.BlockAndLatency, error) {
+	type indexAndBlock struct {
+		index int64
+		block *fetcher.BlockAndLatency
+	}
+
+	indices := make(chan int64)
+	results := make(chan *indexAndBlock)
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		defer close(indices)
+		for i := startIndex; i <= endIndex; i++ {
+			select {
+			case indices <- i:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		return nil
+	})
+
+	for i := uint64(0); i < concurrency; i++ {
+		g.Go(func() error {
+			for index := range indices {
+				index := index
+				start := time.Now()
+				block, err := fetch(ctx, &rosetta.PartialBlockIdentifier{Index: &index})
+				if err != nil {
+					return err
+				}
+
+				select {
+				case results <- &indexAndBlock{
+					index: index,
+					block: &fetcher.BlockAndLatency{
+						Block:   block,
+						Latency: time.Since(start).Seconds(),
+					},
+				}:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+
+			return nil
+		})
+	}
+
+	go func() {
+		_ = g.Wait()
+		close(results)
+	}()
+
+	blocks := make(map[int64]*fetcher.BlockAndLatency)
+	for result := range results {
+		blocks[result.index] = result.block
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return blocks, nil
+}
+
+// describeBlockIdentifier returns a description of
+// blockIdentifier for logging.
+func describeBlockIdentifier(blockIdentifier *rosetta.PartialBlockIdentifier) string {
+	if blockIdentifier.Index != nil {
+		return fmt.Sprintf("%d", *blockIdentifier.Index)
+	}
+
+	if blockIdentifier.Hash != nil {
+		return *blockIdentifier.Hash
+	}
+
+	return "head"
+}
@@ -0,0 +1,142 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coinbase/rosetta-validator/internal/metrics"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// omittingFetcher is an UnsafeFetcher of a chain
+// that omits even indices after genesis. Each
+// index in failures fails that many times.
+type omittingFetcher struct {
+	UnsafeFetcher
+
+	mutex    sync.Mutex
+	failures map[int64]int
+}
+
+func (f *omittingFetcher) UnsafeBlock(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	blockIdentifier *rosetta.PartialBlockIdentifier,
+) (*rosetta.Block, error) {
+	index := *blockIdentifier.Index
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.failures[index] > 0 {
+		f.failures[index]--
+		return nil, errors.New("unavailable")
+	}
+
+	if index > 0 && index%2 == 0 {
+		return nil, nil
+	}
+
+	parentIndex := index - 2
+	if parentIndex < 0 {
+		parentIndex = 0
+	}
+
+	return &rosetta.Block{
+		BlockIdentifier: &rosetta.BlockIdentifier{
+			Index: index,
+			Hash:  fmt.Sprintf("block %d", index),
+		},
+		ParentBlockIdentifier: &rosetta.BlockIdentifier{
+			Index: parentIndex,
+			Hash:  fmt.Sprintf("block %d", parentIndex),
+		},
+	}, nil
+}
+
+// blockAsserter is a BlockAsserter that
+// rejects blocks at index 99.
+type blockAsserter struct{}
+
+func (a *blockAsserter) Block(ctx context.Context, block *rosetta.Block) error {
+	if block.BlockIdentifier.Index == 99 {
+		return errors.New("invalid block")
+	}
+
+	return nil
+}
+
+func TestOmittedBlockFetcher(t *testing.T) {
+	ctx := context.Background()
+	f := NewOmittedBlockFetcher(
+		&omittingFetcher{failures: map[int64]int{3: 1}},
+		&blockAsserter{},
+		2,
+	)
+
+	t.Run("Block range", func(t *testing.T) {
+		blocks, err := f.BlockRange(ctx, nil, 1, 4)
+		assert.NoError(t, err)
+		assert.Len(t, blocks, 4)
+		assert.Equal(t, int64(1), blocks[1].Block.BlockIdentifier.Index)
+		assert.Nil(t, blocks[2].Block)
+		assert.Equal(t, int64(3), blocks[3].Block.BlockIdentifier.Index)
+		assert.Nil(t, blocks[4].Block)
+	})
+
+	t.Run("Block range retries", func(t *testing.T) {
+		f := NewOmittedBlockFetcher(
+			&omittingFetcher{failures: map[int64]int{3: 1}},
+			&blockAsserter{},
+			2,
+		)
+		f.SetRetries(time.Minute, 0)
+
+		_, err := f.BlockRange(ctx, nil, 1, 4)
+		assert.EqualError(t, err, "unavailable: exhausted retries for block")
+	})
+
+	t.Run("Invalid block", func(t *testing.T) {
+		index := int64(99)
+		_, err := f.BlockRetry(
+			ctx,
+			nil,
+			&rosetta.PartialBlockIdentifier{Index: &index},
+			time.Minute,
+			0,
+		)
+		assert.EqualError(t, err, "invalid block: exhausted retries for block")
+	})
+
+	t.Run("Sync", func(t *testing.T) {
+		handler := &orderHandler{}
+		syncer := New(ctx, nil, f, handler, &benchmarkLogger{}, &metrics.NoOpSink{}, Timeouts{}, nil, []*rosetta.BlockIdentifier{
+			{Index: 0, Hash: "block 0"},
+		})
+
+		assert.NoError(t, syncer.SyncBlockRange(ctx, 1, 6))
+		assert.Equal(t, []int64{1, 3, 5}, handler.added)
+		assert.Equal(t, int64(7), syncer.nextIndex)
+		assert.Equal(t, int64(3), syncer.Summary().BlocksOmitted)
+	})
+}
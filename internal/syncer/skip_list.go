@@ -0,0 +1,169 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/coinbase/rosetta-validator/internal/utils"
+
+	"github.com/coinbase/rosetta-sdk-go/fetcher"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+// SkipListFetcher wraps an UnsafeFetcher so that the blocks
+// in a utils.SkipList are returned even if they fail
+// assertion (the assertion error is logged). Every other
+// block is fetched by the wrapped UnsafeFetcher.
+type SkipListFetcher struct {
+	UnsafeFetcher
+
+	asserter BlockAsserter
+	skipList *utils.SkipList
+
+	maxElapsedTime time.Duration
+	maxRetries     uint64
+}
+
+// NewSkipListFetcher returns a new SkipListFetcher.
+func NewSkipListFetcher(
+	blockFetcher UnsafeFetcher,
+	asserter BlockAsserter,
+	skipList *utils.SkipList,
+) *SkipListFetcher {
+	return &SkipListFetcher{
+		UnsafeFetcher:  blockFetcher,
+		asserter:       asserter,
+		skipList:       skipList,
+		maxElapsedTime: fetcher.DefaultElapsedTime,
+		maxRetries:     fetcher.DefaultRetries,
+	}
+}
+
+// SetRetries changes the number of times (and the time
+// spent) retrying a failed request for a listed block in
+// BlockRange with exponential backoff. Blocks between
+// listed indices are retried by the wrapped UnsafeFetcher.
+// It must be called before any blocks are fetched.
+func (f *SkipListFetcher) SetRetries(maxElapsedTime time.Duration, maxRetries uint64) {
+	f.maxElapsedTime = maxElapsedTime
+	f.maxRetries = maxRetries
+}
+
+// listedBlock fetches a block at a listed index, retrying
+// with exponential backoff up to maxRetries times (or until
+// maxElapsedTime has been spent retrying). An assertion
+// error is only skipped if the fetched block is listed
+// (its hash may not match).
+func (f *SkipListFetcher) listedBlock(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	blockIdentifier *rosetta.PartialBlockIdentifier,
+	maxElapsedTime time.Duration,
+	maxRetries uint64,
+) (*rosetta.Block, error) {
+	block, err := retryBlock(ctx, blockIdentifier, maxElapsedTime, maxRetries, func() (*rosetta.Block, error) {
+		return f.UnsafeBlock(ctx, network, blockIdentifier)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.asserter.Block(ctx, block)
+	if err == nil {
+		return block, nil
+	}
+
+	if block == nil || !f.skipList.Contains(block.BlockIdentifier) {
+		return nil, err
+	}
+
+	log.Printf("Skipping assertion error in listed block %+v: %v\n", block.BlockIdentifier, err)
+	return block, nil
+}
+
+// BlockRetry fetches a single block. Listed blocks
+// are fetched with listedBlock.
+func (f *SkipListFetcher) BlockRetry(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	blockIdentifier *rosetta.PartialBlockIdentifier,
+	maxElapsedTime time.Duration,
+	maxRetries uint64,
+) (*rosetta.Block, error) {
+	if blockIdentifier.Index == nil || !f.skipList.ContainsIndex(*blockIdentifier.Index) {
+		return f.UnsafeFetcher.BlockRetry(ctx, network, blockIdentifier, maxElapsedTime, maxRetries)
+	}
+
+	return f.listedBlock(ctx, network, blockIdentifier, maxElapsedTime, maxRetries)
+}
+
+// BlockRange fetches the blocks from startIndex to endIndex,
+// inclusive. Blocks between listed indices are fetched with
+// the BlockRange of the wrapped UnsafeFetcher and listed
+// blocks are fetched with listedBlock.
+func (f *SkipListFetcher) BlockRange(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	startIndex int64,
+	endIndex int64,
+) (map[int64]*fetcher.BlockAndLatency, error) {
+	blocks := make(map[int64]*fetcher.BlockAndLatency)
+	rangeStart := startIndex
+	for index := startIndex; index <= endIndex+1; index++ {
+		if index <= endIndex && !f.skipList.ContainsIndex(index) {
+			continue
+		}
+
+		if rangeStart < index {
+			fetched, err := f.UnsafeFetcher.BlockRange(ctx, network, rangeStart, index-1)
+			if err != nil {
+				return nil, err
+			}
+
+			for i, block := range fetched {
+				blocks[i] = block
+			}
+		}
+		rangeStart = index + 1
+
+		if index > endIndex {
+			break
+		}
+
+		index := index
+		start := time.Now()
+		block, err := f.listedBlock(
+			ctx,
+			network,
+			&rosetta.PartialBlockIdentifier{Index: &index},
+			f.maxElapsedTime,
+			f.maxRetries,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		blocks[index] = &fetcher.BlockAndLatency{
+			Block:   block,
+			Latency: time.Since(start).Seconds(),
+		}
+	}
+
+	return blocks, nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coinbase/rosetta-validator/internal/utils"
+
+	"github.com/coinbase/rosetta-sdk-go/fetcher"
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// rangeFetcher is an UnsafeFetcher that records the
+// ranges fetched with BlockRange. Blocks fetched with
+// BlockRange and BlockRetry are not asserted.
+type rangeFetcher struct {
+	Fetcher
+
+	mutex  sync.Mutex
+	ranges [][2]int64
+}
+
+func (f *rangeFetcher) UnsafeBlock(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	blockIdentifier *rosetta.PartialBlockIdentifier,
+) (*rosetta.Block, error) {
+	index := *blockIdentifier.Index
+	return &rosetta.Block{
+		BlockIdentifier: &rosetta.BlockIdentifier{
+			Index: index,
+			Hash:  fmt.Sprintf("block %d", index),
+		},
+	}, nil
+}
+
+func (f *rangeFetcher) BlockRetry(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	blockIdentifier *rosetta.PartialBlockIdentifier,
+	maxElapsedTime time.Duration,
+	maxRetries uint64,
+) (*rosetta.Block, error) {
+	return f.UnsafeBlock(ctx, network, blockIdentifier)
+}
+
+func (f *rangeFetcher) BlockRange(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	startIndex int64,
+	endIndex int64,
+) (map[int64]*fetcher.BlockAndLatency, error) {
+	f.mutex.Lock()
+	f.ranges = append(f.ranges, [2]int64{startIndex, endIndex})
+	f.mutex.Unlock()
+
+	blocks := map[int64]*fetcher.BlockAndLatency{}
+	for i := startIndex; i <= endIndex; i++ {
+		index := i
+		block, _ := f.UnsafeBlock(ctx, network, &rosetta.PartialBlockIdentifier{Index: &index})
+		blocks[i] = &fetcher.BlockAndLatency{Block: block}
+	}
+
+	return blocks, nil
+}
+
+func TestSkipListFetcher(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Block range", func(t *testing.T) {
+		skipList, err := utils.ParseSkipList([]string{"97", "99:block 99"})
+		assert.NoError(t, err)
+		unsafe := &rangeFetcher{}
+		f := NewSkipListFetcher(unsafe, &blockAsserter{}, skipList)
+
+		blocks, err := f.BlockRange(ctx, nil, 96, 101)
+		assert.NoError(t, err)
+		assert.Len(t, blocks, 6)
+		for i := int64(96); i <= 101; i++ {
+			assert.Equal(t, i, blocks[i].Block.BlockIdentifier.Index)
+		}
+		assert.Equal(t, [][2]int64{{96, 96}, {98, 98}, {100, 101}}, unsafe.ranges)
+	})
+
+	t.Run("Hash mismatch", func(t *testing.T) {
+		skipList, err := utils.ParseSkipList([]string{"99:other"})
+		assert.NoError(t, err)
+		f := NewSkipListFetcher(&rangeFetcher{}, &blockAsserter{}, skipList)
+
+		_, err = f.BlockRange(ctx, nil, 98, 100)
+		assert.EqualError(t, err, "invalid block")
+	})
+
+	t.Run("Block retry", func(t *testing.T) {
+		skipList, err := utils.ParseSkipList([]string{"99"})
+		assert.NoError(t, err)
+		f := NewSkipListFetcher(&rangeFetcher{}, &blockAsserter{}, skipList)
+
+		index := int64(99)
+		block, err := f.BlockRetry(
+			ctx,
+			nil,
+			&rosetta.PartialBlockIdentifier{Index: &index},
+			time.Minute,
+			0,
+		)
+		assert.NoError(t, err)
+		assert.Equal(t, index, block.BlockIdentifier.Index)
+	})
+}
@@ -0,0 +1,223 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/coinbase/rosetta-validator/internal/utils"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+var (
+	// ErrParentMismatch is returned by SpotCheck when the
+	// block fetched for the ParentBlockIdentifier of a block
+	// has a different identifier.
+	ErrParentMismatch = errors.New("Block does not match parent block identifier")
+
+	// ErrDuplicateHash is returned by SpotCheck when a block
+	// or transaction hash appears more than once in the
+	// checked blocks.
+	ErrDuplicateHash = errors.New("Duplicate hash in checked blocks")
+)
+
+// SpotCheckSummary summarizes the blocks checked
+// by SpotCheck.
+type SpotCheckSummary struct {
+	// Tip is the first block checked and Oldest
+	// is the last (nil if no block was checked).
+	Tip    *rosetta.BlockIdentifier
+	Oldest *rosetta.BlockIdentifier
+
+	Blocks       int64
+	Transactions int64
+}
+
+// fetchBlock fetches the block identified by
+// blockIdentifier (with retries).
+func (s *Syncer) fetchBlock(
+	ctx context.Context,
+	blockIdentifier *rosetta.PartialBlockIdentifier,
+) (*rosetta.Block, error) {
+	fetchCtx, cancel := utils.ContextWithTimeout(ctx, s.timeouts.Fetch)
+	defer cancel()
+
+	block, err := s.fetcher.BlockRetry(
+		fetchCtx,
+		s.network,
+		blockIdentifier,
+		s.maxElapsedTime,
+		s.maxRetries,
+	)
+	if err != nil {
+		return nil, &FetchError{Err: fmt.Errorf("%w: unable to fetch block %d", err, *blockIdentifier.Index)}
+	}
+
+	return block, nil
+}
+
+// isParent returns true if block is identified
+// by the ParentBlockIdentifier of child.
+func isParent(block *rosetta.Block, child *rosetta.Block) bool {
+	return block != nil &&
+		block.BlockIdentifier.Hash == child.ParentBlockIdentifier.Hash &&
+		block.BlockIdentifier.Index == child.ParentBlockIdentifier.Index
+}
+
+// checkHashes returns an error if the hash of block (or
+// of one of its transactions) is in hashes, which it is
+// then added to.
+func checkHashes(block *rosetta.Block, hashes map[string]*rosetta.BlockIdentifier) error {
+	if seen, ok := hashes[block.BlockIdentifier.Hash]; ok {
+		return fmt.Errorf(
+			"%w: block %+v has the hash of block %+v",
+			ErrDuplicateHash,
+			block.BlockIdentifier,
+			seen,
+		)
+	}
+	hashes[block.BlockIdentifier.Hash] = block.BlockIdentifier
+
+	for _, transaction := range block.Transactions {
+		hash := transaction.TransactionIdentifier.Hash
+		if seen, ok := hashes[hash]; ok {
+			return fmt.Errorf(
+				"%w: transaction %s in block %+v was seen in block %+v",
+				ErrDuplicateHash,
+				hash,
+				block.BlockIdentifier,
+				seen,
+			)
+		}
+		hashes[hash] = block.BlockIdentifier
+	}
+
+	return nil
+}
+
+// SpotCheck checks the depth most recent blocks of the node
+// without syncing them. Starting at the tip reported by the
+// node, it walks back through the ParentBlockIdentifier of
+// each block (stopping at genesis). Each block is asserted by
+// the Fetcher, must match the ParentBlockIdentifier of its
+// child, and must not repeat a block or transaction hash of
+// another checked block. Timestamps are checked as when
+// syncing (see SetTimestampValidation). Blocks are not
+// passed to the Handler.
+func (s *Syncer) SpotCheck(ctx context.Context, depth int64) (*SpotCheckSummary, error) {
+	fetchCtx, cancel := utils.ContextWithTimeout(ctx, s.timeouts.Fetch)
+	networkStatus, err := s.fetcher.NetworkStatusRetry(
+		fetchCtx,
+		nil,
+		s.maxElapsedTime,
+		s.maxRetries,
+	)
+	cancel()
+	if err != nil {
+		return nil, fetchError(err)
+	}
+
+	networkInformation, err := s.networkInformation(networkStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	s.genesis = networkInformation.GenesisBlockIdentifier
+	if err := s.verifyGenesis(ctx, s.genesis); err != nil {
+		return nil, err
+	}
+
+	// The tip is fetched by index because it may
+	// have been orphaned since it was reported.
+	tip := networkInformation.CurrentBlockIdentifier
+	log.Printf("%sSpot-checking %d blocks from tip %d\n", s.logPrefix(), depth, tip.Index)
+	next := &rosetta.PartialBlockIdentifier{Index: &tip.Index}
+
+	summary := &SpotCheckSummary{}
+	hashes := map[string]*rosetta.BlockIdentifier{}
+	var child *rosetta.Block
+	for summary.Blocks < depth {
+		block, err := s.fetchBlock(ctx, next)
+		if err != nil {
+			return summary, err
+		}
+
+		if child == nil && block == nil {
+			return summary, fmt.Errorf("%w: tip block %d was omitted", ErrParentMismatch, tip.Index)
+		}
+
+		if child != nil && !isParent(block, child) {
+			return summary, fmt.Errorf(
+				"%w: fetched %+v for parent %+v of block %+v",
+				ErrParentMismatch,
+				block,
+				child.ParentBlockIdentifier,
+				child.BlockIdentifier,
+			)
+		}
+
+		if child != nil {
+			if err := s.checkSpotTimestamp(child, block.Timestamp); err != nil {
+				return summary, err
+			}
+		}
+
+		if err := checkHashes(block, hashes); err != nil {
+			return summary, err
+		}
+
+		if summary.Tip == nil {
+			summary.Tip = block.BlockIdentifier
+		}
+		summary.Oldest = block.BlockIdentifier
+		summary.Blocks++
+		summary.Transactions += int64(len(block.Transactions))
+
+		if block.BlockIdentifier.Index <= s.genesis.Index {
+			return summary, nil
+		}
+
+		child = block
+		next = &rosetta.PartialBlockIdentifier{
+			Index: &block.ParentBlockIdentifier.Index,
+			Hash:  &block.ParentBlockIdentifier.Hash,
+		}
+	}
+
+	// The parent of the oldest block was not
+	// fetched, so only a zero timestamp is checked.
+	return summary, s.checkSpotTimestamp(child, 0)
+}
+
+// checkSpotTimestamp checks the timestamp of a block
+// given the timestamp of its parent. Errors in blocks
+// in the skip list are logged.
+func (s *Syncer) checkSpotTimestamp(block *rosetta.Block, parentTimestamp int64) error {
+	if block == nil {
+		return nil
+	}
+
+	err := s.checkParentTimestamp(block, parentTimestamp)
+	if err != nil && s.skipList.Contains(block.BlockIdentifier) {
+		log.Printf("Skipping timestamp error in listed block: %v\n", err)
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/coinbase/rosetta-validator/internal/metrics"
+	"github.com/coinbase/rosetta-validator/internal/utils"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// chainFetcher is a Fetcher of a chain of blocks
+// (indexed by index) whose tip is the last block.
+type chainFetcher struct {
+	Fetcher
+
+	blocks []*rosetta.Block
+}
+
+func newChainFetcher(length int64) *chainFetcher {
+	f := &chainFetcher{}
+	for i := int64(0); i < length; i++ {
+		parent := i - 1
+		if parent < 0 {
+			parent = 0
+		}
+
+		f.blocks = append(f.blocks, &rosetta.Block{
+			BlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  fmt.Sprintf("block %d", i),
+				Index: i,
+			},
+			ParentBlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  fmt.Sprintf("block %d", parent),
+				Index: parent,
+			},
+			Timestamp: 1600000000 + i,
+			Transactions: []*rosetta.Transaction{
+				{
+					TransactionIdentifier: &rosetta.TransactionIdentifier{
+						Hash: fmt.Sprintf("transaction %d", i),
+					},
+				},
+			},
+		})
+	}
+
+	return f
+}
+
+func (f *chainFetcher) NetworkStatusRetry(
+	ctx context.Context,
+	metadata *map[string]interface{},
+	maxElapsedTime time.Duration,
+	maxRetries uint64,
+) (*rosetta.NetworkStatusResponse, error) {
+	return &rosetta.NetworkStatusResponse{
+		NetworkStatus: &rosetta.NetworkStatus{
+			NetworkInformation: &rosetta.NetworkInformation{
+				GenesisBlockIdentifier: f.blocks[0].BlockIdentifier,
+				CurrentBlockIdentifier: f.blocks[len(f.blocks)-1].BlockIdentifier,
+			},
+		},
+	}, nil
+}
+
+// BlockRetry returns the block at the requested
+// index, ignoring the requested hash.
+func (f *chainFetcher) BlockRetry(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	blockIdentifier *rosetta.PartialBlockIdentifier,
+	maxElapsedTime time.Duration,
+	maxRetries uint64,
+) (*rosetta.Block, error) {
+	return f.blocks[*blockIdentifier.Index], nil
+}
+
+func TestSpotCheck(t *testing.T) {
+	ctx := context.Background()
+	newSyncer := func(f Fetcher) *Syncer {
+		return New(ctx, nil, f, nil, nil, &metrics.NoOpSink{}, Timeouts{}, nil, nil)
+	}
+
+	t.Run("Depth", func(t *testing.T) {
+		summary, err := newSyncer(newChainFetcher(100)).SpotCheck(ctx, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, &SpotCheckSummary{
+			Tip:          &rosetta.BlockIdentifier{Hash: "block 99", Index: 99},
+			Oldest:       &rosetta.BlockIdentifier{Hash: "block 90", Index: 90},
+			Blocks:       10,
+			Transactions: 10,
+		}, summary)
+	})
+
+	t.Run("Stops at genesis", func(t *testing.T) {
+		summary, err := newSyncer(newChainFetcher(5)).SpotCheck(ctx, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(5), summary.Blocks)
+		assert.Equal(t, int64(0), summary.Oldest.Index)
+	})
+
+	t.Run("Parent mismatch", func(t *testing.T) {
+		f := newChainFetcher(100)
+		f.blocks[95].BlockIdentifier = &rosetta.BlockIdentifier{Hash: "other", Index: 95}
+		summary, err := newSyncer(f).SpotCheck(ctx, 10)
+		assert.True(t, errors.Is(err, ErrParentMismatch))
+		assert.Equal(t, int64(4), summary.Blocks)
+	})
+
+	t.Run("Duplicate transaction hash", func(t *testing.T) {
+		f := newChainFetcher(100)
+		f.blocks[92].Transactions[0].TransactionIdentifier.Hash = "transaction 97"
+		_, err := newSyncer(f).SpotCheck(ctx, 10)
+		assert.True(t, errors.Is(err, ErrDuplicateHash))
+	})
+
+	t.Run("Timestamps", func(t *testing.T) {
+		f := newChainFetcher(100)
+		f.blocks[95].Timestamp = 1700000000
+		syncer := newSyncer(f)
+		syncer.SetTimestampValidation(utils.Seconds, time.Hour)
+		_, err := syncer.SpotCheck(ctx, 10)
+		assert.True(t, errors.Is(err, ErrTimestampBeforeParent))
+
+		skipList, err := utils.ParseSkipList([]string{"96"})
+		assert.NoError(t, err)
+		syncer.SetSkipList(skipList)
+		_, err = syncer.SpotCheck(ctx, 10)
+		assert.NoError(t, err)
+
+		f.blocks[90].Timestamp = 0
+		_, err = syncer.SpotCheck(ctx, 10)
+		assert.True(t, errors.Is(err, ErrZeroTimestamp))
+	})
+}
@@ -16,15 +16,17 @@ package syncer
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
-	"strings"
+	"math/rand"
+	"sync/atomic"
 	"time"
 
 	"github.com/coinbase/rosetta-validator/internal/logger"
-	"github.com/coinbase/rosetta-validator/internal/reconciler"
-	"github.com/coinbase/rosetta-validator/internal/storage"
+	"github.com/coinbase/rosetta-validator/internal/metrics"
+	"github.com/coinbase/rosetta-validator/internal/utils"
 
 	"github.com/coinbase/rosetta-sdk-go/fetcher"
 
@@ -32,52 +34,633 @@ import (
 )
 
 const (
-	// maxSync is the maximum number of blocks
-	// to try and sync in a given SyncCycle.
-	maxSync = 500
+	// DefaultMaxSync is the default maximum number
+	// of blocks to try and sync in a given SyncCycle.
+	DefaultMaxSync = 500
+
+	// DefaultTipPollInterval is the default time Sync
+	// waits before polling the node for a new tip once
+	// every block up to the tip has been processed.
+	DefaultTipPollInterval = time.Second
+
+	// DefaultCircuitBreakerBackoff is the default time
+	// Sync waits before the first attempt to resume
+	// syncing after a request to the node fails.
+	DefaultCircuitBreakerBackoff = 5 * time.Second
+
+	// PastBlockSize is the maximum number of processed
+	// block identifiers the Syncer keeps in memory to
+	// handle reorgs. A deeper reorg can only be handled
+	// if older identifiers can be loaded from a
+	// BlockHistory (see SetBlockHistory).
+	PastBlockSize = 1000
+)
+
+var (
+	// ErrCannotRemoveGenesisBlock is returned when
+	// a reorg would require removing the genesis block.
+	ErrCannotRemoveGenesisBlock = errors.New("Can't reorg genesis block")
+
+	// ErrOutOfPastBlocks is returned when a reorg is
+	// deeper than the block identifiers known to the Syncer
+	// (including those in its BlockHistory, if any).
+	ErrOutOfPastBlocks = errors.New("Reorg deeper than known blocks")
+
+	// ErrMaxReorgDepthExceeded is returned when a reorg
+	// would orphan more blocks than the maximum reorg
+	// depth (see SetMaxReorgDepth).
+	ErrMaxReorgDepthExceeded = errors.New("Reorg deeper than max reorg depth")
+
+	// ErrZeroTimestamp is returned when a block after
+	// genesis has a zero timestamp (see
+	// SetTimestampValidation).
+	ErrZeroTimestamp = errors.New("Block timestamp is zero")
+
+	// ErrTimestampBeforeParent is returned when the
+	// timestamp of a block is before the timestamp of its
+	// parent by more than the timestamp tolerance (see
+	// SetTimestampValidation).
+	ErrTimestampBeforeParent = errors.New("Block timestamp before parent timestamp")
+
+	// ErrGenesisMismatch is returned when the genesis
+	// block of the node does not have the expected hash
+	// (see SetExpectedGenesisHash).
+	ErrGenesisMismatch = errors.New("Genesis block does not match expected hash")
+
+	// ErrEndIndexReached is returned by Sync once
+	// every block up to the end index has been
+	// processed (see SetEndIndex).
+	ErrEndIndexReached = errors.New("End index reached")
+
+	// ErrTipReached is returned by Sync once the
+	// tip reported by the node has been processed
+	// (see SetExitAtTip).
+	ErrTipReached = errors.New("Tip reached")
+
+	// ErrSubNetworkNotFound is returned when the network
+	// status does not include the status of the sub-network
+	// being synced.
+	ErrSubNetworkNotFound = errors.New("Sub-network status not found")
 )
 
+// Fetcher is the subset of *fetcher.Fetcher methods
+// used by the Syncer to retrieve blocks. A nil block
+// indicates that the chain omitted the index (see
+// OmittedBlockFetcher), which the Syncer skips.
+type Fetcher interface {
+	NetworkStatusRetry(
+		ctx context.Context,
+		metadata *map[string]interface{},
+		maxElapsedTime time.Duration,
+		maxRetries uint64,
+	) (*rosetta.NetworkStatusResponse, error)
+
+	BlockRetry(
+		ctx context.Context,
+		network *rosetta.NetworkIdentifier,
+		blockIdentifier *rosetta.PartialBlockIdentifier,
+		maxElapsedTime time.Duration,
+		maxRetries uint64,
+	) (*rosetta.Block, error)
+}
+
+// Handler is called by the Syncer as blocks are
+// added to and removed from the canonical chain.
+// The Syncer only considers a block processed once
+// the Handler returns without error.
+type Handler interface {
+	// BlockAdded is called with each block that
+	// extends the current head.
+	BlockAdded(ctx context.Context, block *rosetta.Block) error
+
+	// BlockRemoved is called with the current head
+	// when it is orphaned in a reorg.
+	BlockRemoved(ctx context.Context, block *rosetta.BlockIdentifier) error
+}
+
+// Queue durably stores fetched blocks so that they
+// can be processed after a restart without fetching
+// them again. A queued block is only used once: the
+// Handler must remove it from the Queue in the same
+// transaction that adds it (or that removes its parent
+// if it caused a reorg) so that a block is never
+// processed again after a crash.
+type Queue interface {
+	QueueBlocks(ctx context.Context, blocks []*rosetta.Block) error
+
+	// QueuedBlock returns the block queued at an index
+	// or nil if no block is queued at the index.
+	QueuedBlock(ctx context.Context, index int64) (*rosetta.Block, error)
+}
+
+// BlockHistory provides the identifiers of processed
+// blocks that are no longer held in memory by the
+// Syncer (ex: *storage.BlockStorage).
+type BlockHistory interface {
+	// PastBlocks returns the identifiers of up to limit
+	// processed blocks (oldest first) ending with
+	// blockIdentifier.
+	PastBlocks(
+		ctx context.Context,
+		blockIdentifier *rosetta.BlockIdentifier,
+		limit int,
+	) ([]*rosetta.BlockIdentifier, error)
+}
+
+// TipObserver is notified of the tip reported by
+// the node in each SyncCycle and whether every block
+// up to it has been processed.
+type TipObserver interface {
+	ObserveTip(tip *rosetta.BlockIdentifier, caughtUp bool)
+}
+
+// TipRegressionObserver is notified when the tip reported
+// by the node falls below the highest tip it had reported.
+type TipRegressionObserver interface {
+	ObserveTipRegression(previous *rosetta.BlockIdentifier, tip *rosetta.BlockIdentifier)
+}
+
+// BlockGapObserver is notified of the time between the
+// timestamps of each added block and its parent.
+type BlockGapObserver interface {
+	ObserveBlockGap(block *rosetta.BlockIdentifier, gap time.Duration)
+}
+
+// BlockEvents notifies the Syncer of blocks added to
+// (or removed from) the chain of the node (ex: an
+// *events.Stream), so that it does not need to poll
+// the node at the tip.
+type BlockEvents interface {
+	// Connected returns a boolean indicating if
+	// events are currently being received.
+	Connected() bool
+
+	// Events returns a channel that receives a
+	// value after each event.
+	Events() <-chan struct{}
+}
+
+// TimestampViolation describes a block with a timestamp
+// more than the tolerance (SlackMS) before the timestamp
+// of its parent. It is logged as JSON.
+type TimestampViolation struct {
+	Block           *rosetta.BlockIdentifier `json:"block_identifier"`
+	Timestamp       time.Time                `json:"timestamp"`
+	Parent          *rosetta.BlockIdentifier `json:"parent_block_identifier"`
+	ParentTimestamp time.Time                `json:"parent_timestamp"`
+	RegressionMS    int64                    `json:"regression_ms"`
+	SlackMS         int64                    `json:"slack_ms"`
+}
+
+// Logger is used by the Syncer to record
+// block processing benchmarks.
+type Logger interface {
+	BlockStats(ctx context.Context, blocks []*logger.BlockStats) error
+}
+
+// Timeouts bound the time spent in each stage of
+// syncing. A timeout of 0 disables the bound for
+// that stage.
+type Timeouts struct {
+	// Fetch bounds each request for the network status or
+	// blocks (including the assertion of their responses).
+	Fetch time.Duration
+
+	// Process bounds each call to the Handler.
+	Process time.Duration
+}
+
 // Syncer contains the logic that orchestrates
-// block fetching, storage, and reconciliation.
+// block fetching and reorg handling. Processed
+// blocks are delivered to a Handler.
 type Syncer struct {
-	network    *rosetta.NetworkIdentifier
-	storage    *storage.BlockStorage
-	fetcher    *fetcher.Fetcher
-	logger     *logger.Logger
-	reconciler *reconciler.Reconciler
+	network  *rosetta.NetworkIdentifier
+	fetcher  Fetcher
+	handler  Handler
+	logger   Logger
+	metrics  metrics.Sink
+	timeouts Timeouts
+
+	// queue is optional. If it is nil, fetched
+	// blocks are only held in memory.
+	queue Queue
+
+	// history is optional. If it is nil, reorgs
+	// deeper than pastBlocks cannot be handled.
+	history BlockHistory
+
+	// pastBlocks contains the identifiers of the most
+	// recently processed blocks (the head is last). They
+	// are used to detect reorgs and to determine which
+	// block to remove when one occurs.
+	pastBlocks []*rosetta.BlockIdentifier
+	nextIndex  int64
+	genesis    *rosetta.BlockIdentifier
+
+	// maxSync is the maximum number of blocks fetched
+	// (and held in memory) in a SyncCycle. It is accessed
+	// atomically because it may be changed while syncing.
+	maxSync int64
+
+	// prefetch is the number of blocks fetched ahead of
+	// the block being processed (0 fetches every block in
+	// a SyncCycle before processing any of them).
+	prefetch int64
+
+	// tipObserver and gapObserver are optional.
+	tipObserver TipObserver
+	gapObserver BlockGapObserver
+
+	// startIndex and endIndex bound the blocks
+	// synced. They are -1 if not set.
+	startIndex int64
+	endIndex   int64
+
+	// exitAtTip stops syncing once the tip
+	// reported by the node is processed.
+	exitAtTip bool
+
+	// atTip is true once every block up to the tip
+	// reported by the node has been processed (until
+	// the node reports a new tip). While at the tip,
+	// Sync polls the node every tipPollInterval plus
+	// a random duration of up to tipPollJitter. It is
+	// accessed atomically (see AtTip).
+	atTip           int32
+	tipPollInterval time.Duration
+	tipPollJitter   time.Duration
+
+	// blockEvents is optional. While it is connected,
+	// Sync waits for an event at the tip instead of
+	// polling.
+	blockEvents BlockEvents
+
+	// maxElapsedTime and maxRetries bound the
+	// retries of each failed request.
+	maxElapsedTime time.Duration
+	maxRetries     uint64
+
+	// blockConcurrency is the number of blocks
+	// fetched at once.
+	blockConcurrency uint64
+
+	// maxReorgDepth is the maximum number of blocks
+	// a reorg may orphan (0 if unbounded). reorgDepth
+	// is the number of blocks orphaned by the current
+	// reorg (0 if there is none).
+	maxReorgDepth int64
+	reorgDepth    int64
+
+	// timestampUnit is the unit of block timestamps
+	// (empty if timestamps are not validated). A block may
+	// not be more than timestampTolerance before its parent.
+	// headTimestamp is the timestamp of the head block (0
+	// if it is not known, ex: after an orphan).
+	timestampUnit      utils.TimestampUnit
+	timestampTolerance time.Duration
+	headTimestamp      int64
+
+	// logTimestampViolations logs blocks before their
+	// parent (see TimestampViolation) instead of
+	// stopping syncing.
+	logTimestampViolations bool
+
+	// expectedGenesisHash is the hash the genesis block
+	// must have (empty if it is not checked). The genesis
+	// block is only fetched and checked once.
+	expectedGenesisHash string
+	genesisVerified     bool
+
+	// breaker pauses syncing after a request to
+	// the node fails (if it is enabled).
+	breaker circuitBreaker
+
+	// skipList contains the blocks whose timestamp
+	// errors are logged instead of stopping syncing.
+	skipList *utils.SkipList
+
+	// summary counts the blocks processed
+	// by the Syncer.
+	summary RangeSummary
+
+	// blockTimes accumulates the block times of the
+	// blocks added since the last BlockTimeWindow.
+	blockTimes blockTimes
+
+	// highestTip is the highest tip reported by the node
+	// (or the head, if it is higher). tipRegressed is true
+	// while the node reports a lower tip, during which
+	// syncing is paused if pauseOnTipRegression is set.
+	// regressionObserver is optional.
+	highestTip           *rosetta.BlockIdentifier
+	tipRegressed         bool
+	pauseOnTipRegression bool
+	regressionObserver   TipRegressionObserver
 }
 
-// New returns a new Syncer.
+// RangeSummary summarizes the blocks processed by a
+// Syncer (ex: to report the progress of a validation
+// job bounded by an end index).
+type RangeSummary struct {
+	// StartIndex is the index of the first block
+	// processed (-1 if no block was processed) and
+	// EndIndex is the index of the head block once
+	// syncing stopped (-1 if there is none).
+	StartIndex int64 `json:"start_index"`
+	EndIndex   int64 `json:"end_index"`
+
+	BlocksProcessed int64 `json:"blocks_processed"`
+	BlocksOrphaned  int64 `json:"blocks_orphaned"`
+	BlocksOmitted   int64 `json:"blocks_omitted"`
+
+	// BlockTimes summarizes the time between the timestamps
+	// of consecutive blocks (BlockTimeWindowSize blocks at a
+	// time). The last BlockTimeWindow may be partial.
+	BlockTimes []*BlockTimeWindow `json:"block_times,omitempty"`
+}
+
+// New returns a new Syncer. pastBlocks should contain the
+// identifiers of blocks processed by a previous run (oldest
+// first), if any.
 func New(
 	ctx context.Context,
 	network *rosetta.NetworkIdentifier,
-	storage *storage.BlockStorage,
-	fetcher *fetcher.Fetcher,
-	logger *logger.Logger,
-	reconciler *reconciler.Reconciler,
+	f Fetcher,
+	handler Handler,
+	logger Logger,
+	sink metrics.Sink,
+	timeouts Timeouts,
+	queue Queue,
+	pastBlocks []*rosetta.BlockIdentifier,
 ) *Syncer {
-	return &Syncer{
+	s := &Syncer{
 		network:    network,
-		storage:    storage,
-		fetcher:    fetcher,
+		fetcher:    f,
+		handler:    handler,
 		logger:     logger,
-		reconciler: reconciler,
+		metrics:    sink,
+		timeouts:   timeouts,
+		queue:      queue,
+		pastBlocks: pastBlocks,
+		maxSync:    DefaultMaxSync,
+		startIndex: -1,
+		endIndex:   -1,
+		summary: RangeSummary{
+			StartIndex: -1,
+			EndIndex:   -1,
+		},
+
+		maxElapsedTime:   fetcher.DefaultElapsedTime,
+		maxRetries:       fetcher.DefaultRetries,
+		blockConcurrency: fetcher.DefaultBlockConcurrency,
+
+		tipPollInterval: DefaultTipPollInterval,
 	}
+
+	if head := s.head(); head != nil {
+		s.nextIndex = head.Index + 1
+	}
+
+	return s
+}
+
+// SetBlockHistory loads the identifiers of older blocks
+// from history when a reorg is deeper than the block
+// identifiers held in memory. It must be called before
+// syncing.
+func (s *Syncer) SetBlockHistory(history BlockHistory) {
+	s.history = history
+}
+
+// SetTipObserver notifies observer of the tip reported
+// by the node in each SyncCycle. It must be called
+// before syncing.
+func (s *Syncer) SetTipObserver(observer TipObserver) {
+	s.tipObserver = observer
+}
+
+// SetTipRegressionObserver notifies observer when the tip
+// reported by the node falls below the highest tip it had
+// reported. It must be called before syncing.
+func (s *Syncer) SetTipRegressionObserver(observer TipRegressionObserver) {
+	s.regressionObserver = observer
+}
+
+// SetPauseOnTipRegression pauses syncing while the tip
+// reported by the node is below the highest tip it had
+// reported (ex: while the node resyncs after a rollback),
+// since the data it serves may not be trustworthy. It must
+// be called before syncing.
+func (s *Syncer) SetPauseOnTipRegression(pause bool) {
+	s.pauseOnTipRegression = pause
+}
+
+// SetBlockGapObserver notifies observer of the time between
+// the timestamps of each added block and its parent. This
+// is only known if timestamps are validated (see
+// SetTimestampValidation) and the parent was added since
+// syncing started. It must be called before syncing.
+func (s *Syncer) SetBlockGapObserver(observer BlockGapObserver) {
+	s.gapObserver = observer
+}
+
+// SetStartIndex starts syncing at the block at index
+// instead of the block after genesis. It only applies if
+// no block has been processed and must be called before
+// syncing. Reorgs of the first processed block cannot be
+// handled because its parent is unknown.
+func (s *Syncer) SetStartIndex(index int64) {
+	s.startIndex = index
+}
+
+// SetEndIndex stops syncing after the block at index has
+// been processed. Once it has been, SyncCycle returns
+// ErrEndIndexReached. It must be called before syncing.
+func (s *Syncer) SetEndIndex(index int64) {
+	s.endIndex = index
+}
+
+// SetExitAtTip stops syncing once every block up to the
+// tip reported by the node has been processed. Once it
+// has been, SyncCycle returns ErrTipReached. It must be
+// called before syncing.
+func (s *Syncer) SetExitAtTip(exitAtTip bool) {
+	s.exitAtTip = exitAtTip
+}
+
+// SetTipPolling changes the time Sync waits before polling
+// the node for a new tip once every block up to the tip has
+// been processed. A random duration of up to jitter is added
+// to each wait so that many validators syncing from the same
+// node do not poll it at once. It must be called before
+// syncing.
+func (s *Syncer) SetTipPolling(interval time.Duration, jitter time.Duration) {
+	s.tipPollInterval = interval
+	s.tipPollJitter = jitter
+}
+
+// SetBlockEvents makes Sync wait for a block event at the
+// tip instead of polling the node while events are being
+// received. It must be called before syncing.
+func (s *Syncer) SetBlockEvents(events BlockEvents) {
+	s.blockEvents = events
+}
+
+// SetRetries changes the number of times (and the time
+// spent) retrying a failed request for the network status
+// or a block with exponential backoff. It must be called
+// before syncing.
+func (s *Syncer) SetRetries(maxElapsedTime time.Duration, maxRetries uint64) {
+	s.maxElapsedTime = maxElapsedTime
+	s.maxRetries = maxRetries
+}
+
+// SetBlockConcurrency changes the number of blocks
+// fetched at once. It must be called before syncing.
+func (s *Syncer) SetBlockConcurrency(concurrency uint64) {
+	s.blockConcurrency = concurrency
+}
+
+// SetMaxReorgDepth stops syncing with ErrMaxReorgDepthExceeded
+// instead of orphaning more than maxReorgDepth consecutive
+// blocks (0 allows reorgs of any depth).
+// On most chains, such a deep reorg indicates a bug in the
+// Rosetta Server rather than a real reorg. It must be called
+// before syncing.
+func (s *Syncer) SetMaxReorgDepth(maxReorgDepth int64) {
+	s.maxReorgDepth = maxReorgDepth
+}
+
+// SetTimestampValidation stops syncing if a block after
+// genesis has a zero timestamp (ErrZeroTimestamp) or a
+// timestamp (in unit) more than tolerance before the
+// timestamp of its parent (ErrTimestampBeforeParent).
+// The parent is only compared if it was added since
+// syncing started and was not orphaned. It must be called
+// before syncing.
+func (s *Syncer) SetTimestampValidation(unit utils.TimestampUnit, tolerance time.Duration) {
+	s.timestampUnit = unit
+	s.timestampTolerance = tolerance
+}
+
+// SetLogTimestampViolations logs a TimestampViolation (and
+// increments the TimestampViolations counter) for each block
+// more than the tolerance before its parent instead of
+// returning ErrTimestampBeforeParent, for chains that allow
+// timestamps to regress. Zero timestamps are still rejected.
+// It must be called before syncing.
+func (s *Syncer) SetLogTimestampViolations(logViolations bool) {
+	s.logTimestampViolations = logViolations
+}
+
+// SetCircuitBreaker pauses syncing (instead of returning an
+// error) when a request to the node fails after its retries
+// are exhausted (ex: because the node is returning 5xx
+// responses). SyncCycle is attempted again after backoff,
+// which doubles after each failed attempt up to maxBackoff.
+// Syncing resumes once a SyncCycle succeeds and fails if it
+// has been paused for timeout. A timeout of 0 disables the
+// circuit breaker. It must be called before syncing.
+func (s *Syncer) SetCircuitBreaker(backoff time.Duration, maxBackoff time.Duration, timeout time.Duration) {
+	if backoff <= 0 {
+		backoff = DefaultCircuitBreakerBackoff
+	}
+
+	if maxBackoff < backoff {
+		maxBackoff = backoff
+	}
+
+	s.breaker = circuitBreaker{
+		backoff:    backoff,
+		maxBackoff: maxBackoff,
+		timeout:    timeout,
+	}
+}
+
+// SetExpectedGenesisHash stops syncing (with ErrGenesisMismatch)
+// if the genesis block reported by the node or the genesis
+// block fetched from it does not have hash (ex: because the
+// node serves the wrong network). It must be called before
+// syncing.
+func (s *Syncer) SetExpectedGenesisHash(hash string) {
+	s.expectedGenesisHash = hash
+}
+
+// SetSkipList logs (instead of returning) the timestamp
+// errors of the blocks in skipList. Assertion errors of
+// the blocks are skipped by a SkipListFetcher. It must be
+// called before syncing.
+func (s *Syncer) SetSkipList(skipList *utils.SkipList) {
+	s.skipList = skipList
+}
+
+// SetMaxSync changes the maximum number of blocks
+// fetched in a SyncCycle (ex: to reduce memory usage).
+// It is safe to call while syncing and takes effect in
+// the next SyncCycle.
+func (s *Syncer) SetMaxSync(maxSync int64) {
+	if maxSync < 1 {
+		maxSync = 1
+	}
+
+	atomic.StoreInt64(&s.maxSync, maxSync)
+}
+
+// SetPrefetch fetches blocks in ranges of prefetch blocks
+// while the previously fetched range is processed, so that
+// fetching from a high-latency node overlaps with processing.
+// At most two ranges (and the range being processed) are
+// held in memory. 0 fetches every block in a SyncCycle before
+// processing any of them. It must be called before syncing.
+func (s *Syncer) SetPrefetch(prefetch int64) {
+	s.prefetch = prefetch
+}
+
+// MaxSync returns the maximum number of blocks
+// fetched in a SyncCycle.
+func (s *Syncer) MaxSync() int64 {
+	return atomic.LoadInt64(&s.maxSync)
+}
+
+// AtTip returns true if every block up to the tip
+// last reported by the node has been processed.
+func (s *Syncer) AtTip() bool {
+	return atomic.LoadInt32(&s.atTip) == 1
+}
+
+// Summary returns a summary of the blocks processed by
+// the Syncer. It must be called after syncing has stopped.
+func (s *Syncer) Summary() RangeSummary {
+	summary := s.summary
+	if head := s.head(); head != nil {
+		summary.EndIndex = head.Index
+	}
+
+	if window := s.blockTimes.window(); window != nil {
+		summary.BlockTimes = append([]*BlockTimeWindow{}, s.summary.BlockTimes...)
+		summary.BlockTimes = append(summary.BlockTimes, window)
+	}
+
+	return summary
+}
+
+// head returns the most recently processed block
+// identifier or nil if no block has been processed.
+func (s *Syncer) head() *rosetta.BlockIdentifier {
+	if len(s.pastBlocks) == 0 {
+		return nil
+	}
+
+	return s.pastBlocks[len(s.pastBlocks)-1]
 }
 
 // checkReorg determines if the block provided
 // has the current head block identifier as its
 // parent. If not, it is considered a reorg.
-func (s *Syncer) checkReorg(
-	ctx context.Context,
-	tx storage.DatabaseTransaction,
-	block *rosetta.Block,
-) (bool, error) {
-	head, err := s.storage.GetHeadBlockIdentifier(ctx, tx)
-	if err == storage.ErrHeadBlockNotFound {
+func (s *Syncer) checkReorg(block *rosetta.Block) (bool, error) {
+	head := s.head()
+	if head == nil {
 		return false, nil
-	} else if err != nil {
-		return false, err
 	}
 
 	if block.ParentBlockIdentifier.Index != head.Index {
@@ -95,182 +678,385 @@ func (s *Syncer) checkReorg(
 	return false, nil
 }
 
-// storeBlockBalanceChanges updates the balance
-// of each modified account if the operation affecting
-// that account is successful. These modified
-// accounts are returned to the reconciler
-// for active reconciliation.
-func (s *Syncer) storeBlockBalanceChanges(
-	ctx context.Context,
-	dbTx storage.DatabaseTransaction,
-	block *rosetta.Block,
-	orphan bool,
-) ([]*reconciler.AccountAndCurrency, error) {
-	modifiedAccounts := make([]*reconciler.AccountAndCurrency, 0)
-	for _, tx := range block.Transactions {
-		for _, op := range tx.Operations {
-			successful, err := s.fetcher.Asserter.OperationSuccessful(op)
-			if err != nil {
-				// Could only occur if responses not validated
-				return nil, err
-			}
+// verifyGenesis fetches the genesis block the first time it
+// is called and returns an error if it or the genesis block
+// identifier reported by the node does not have the expected
+// hash (see SetExpectedGenesisHash).
+func (s *Syncer) verifyGenesis(ctx context.Context, genesis *rosetta.BlockIdentifier) error {
+	if len(s.expectedGenesisHash) == 0 || s.genesisVerified {
+		return nil
+	}
 
-			if !successful {
-				continue
-			}
+	if genesis.Hash != s.expectedGenesisHash {
+		return fmt.Errorf(
+			"%w: node reports genesis block %+v, expected hash %s",
+			ErrGenesisMismatch,
+			genesis,
+			s.expectedGenesisHash,
+		)
+	}
 
-			if op.Account == nil {
-				continue
-			}
+	fetchCtx, cancel := utils.ContextWithTimeout(ctx, s.timeouts.Fetch)
+	block, err := s.fetcher.BlockRetry(
+		fetchCtx,
+		s.network,
+		&rosetta.PartialBlockIdentifier{Index: &genesis.Index},
+		s.maxElapsedTime,
+		s.maxRetries,
+	)
+	cancel()
+	if err != nil {
+		return &FetchError{Err: fmt.Errorf("%w: unable to fetch genesis block", err)}
+	}
 
-			amount := op.Amount
-			blockIdentifier := block.BlockIdentifier
-			if orphan {
-				if strings.HasPrefix(amount.Value, "-") {
-					amount.Value = amount.Value[1:]
-				} else {
-					amount.Value = "-" + amount.Value
-				}
+	if block == nil || block.BlockIdentifier.Hash != s.expectedGenesisHash {
+		return fmt.Errorf(
+			"%w: fetched genesis block %+v, expected hash %s",
+			ErrGenesisMismatch,
+			block,
+			s.expectedGenesisHash,
+		)
+	}
 
-				blockIdentifier = block.ParentBlockIdentifier
-			}
+	log.Printf("%sVerified genesis block %+v\n", s.logPrefix(), genesis)
+	s.genesisVerified = true
+	return nil
+}
 
-			accountAndCurrency := &reconciler.AccountAndCurrency{
-				Account:  op.Account,
-				Currency: amount.Currency,
-			}
-			if !reconciler.ContainsAccountAndCurrency(modifiedAccounts, accountAndCurrency) {
-				modifiedAccounts = append(modifiedAccounts, accountAndCurrency)
-			}
+// checkTimestamp returns an error if the timestamp
+// of a block that would be added to the head is
+// invalid (see SetTimestampValidation).
+func (s *Syncer) checkTimestamp(block *rosetta.Block) error {
+	return s.checkParentTimestamp(block, s.headTimestamp)
+}
 
-			err = s.storage.UpdateBalance(
-				ctx,
-				dbTx,
-				op.Account,
-				amount,
-				blockIdentifier,
-			)
-			if err != nil {
-				return nil, err
-			}
+// checkParentTimestamp returns an error if the timestamp
+// of a block is invalid given the timestamp of its parent
+// (0 if it is not known).
+func (s *Syncer) checkParentTimestamp(block *rosetta.Block, parentTimestamp int64) error {
+	if len(s.timestampUnit) == 0 {
+		return nil
+	}
+
+	if s.genesis != nil && block.BlockIdentifier.Index <= s.genesis.Index {
+		return nil
+	}
+
+	if block.Timestamp == 0 {
+		return fmt.Errorf("%w: block %+v", ErrZeroTimestamp, block.BlockIdentifier)
+	}
+
+	if parentTimestamp == 0 {
+		return nil
+	}
+
+	timestamp := s.timestampUnit.Time(block.Timestamp)
+	parentTime := s.timestampUnit.Time(parentTimestamp)
+	if parentTime.Sub(timestamp) > s.timestampTolerance {
+		if s.logTimestampViolations {
+			return s.logTimestampViolation(&TimestampViolation{
+				Block:           block.BlockIdentifier,
+				Timestamp:       timestamp,
+				Parent:          block.ParentBlockIdentifier,
+				ParentTimestamp: parentTime,
+				RegressionMS:    parentTime.Sub(timestamp).Milliseconds(),
+				SlackMS:         s.timestampTolerance.Milliseconds(),
+			})
 		}
+
+		return fmt.Errorf(
+			"%w: block %+v at %s is %s before parent %+v at %s (tolerance %s)",
+			ErrTimestampBeforeParent,
+			block.BlockIdentifier,
+			timestamp.Format(time.RFC3339Nano),
+			parentTime.Sub(timestamp),
+			block.ParentBlockIdentifier,
+			parentTime.Format(time.RFC3339Nano),
+			s.timestampTolerance,
+		)
 	}
 
-	return modifiedAccounts, nil
+	return nil
 }
 
-// OrphanBlock removes a block from the database and reverts all its balance
-// changes.
-func (s *Syncer) OrphanBlock(
-	ctx context.Context,
-	tx storage.DatabaseTransaction,
-	blockIdentifier *rosetta.BlockIdentifier,
-) ([]*reconciler.AccountAndCurrency, error) {
-	log.Printf("Orphaning block %+v\n", blockIdentifier)
-	block, err := s.storage.GetBlock(ctx, tx, blockIdentifier)
+// logTimestampViolation logs violation and records
+// it in the TimestampViolations counter.
+func (s *Syncer) logTimestampViolation(violation *TimestampViolation) error {
+	encoded, err := json.Marshal(violation)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	err = s.storage.StoreHeadBlockIdentifier(ctx, tx, block.ParentBlockIdentifier)
-	if err != nil {
-		return nil, err
-	}
+	log.Printf("%sTimestamp violation: %s\n", s.logPrefix(), encoded)
+	s.metrics.IncrCounter(metrics.TimestampViolations, 1)
+	return nil
+}
 
-	modifiedAccounts, err := s.storeBlockBalanceChanges(ctx, tx, block, true)
-	if err != nil {
-		return nil, err
+// observeBlockGap notifies the BlockGapObserver (if any)
+// of the time between the timestamps of a block added to
+// the head and the head.
+func (s *Syncer) observeBlockGap(block *rosetta.Block) {
+	if s.gapObserver == nil || len(s.timestampUnit) == 0 {
+		return
 	}
 
-	err = s.storage.RemoveBlock(ctx, tx, blockIdentifier)
-	if err != nil {
-		return nil, err
+	if s.headTimestamp == 0 || block.Timestamp == 0 {
+		return
 	}
 
-	return modifiedAccounts, nil
+	gap := s.timestampUnit.Time(block.Timestamp).Sub(s.timestampUnit.Time(s.headTimestamp))
+	s.gapObserver.ObserveBlockGap(block.BlockIdentifier, gap)
 }
 
-// AddBlock adds a block to the database and stores all balance changes.
-func (s *Syncer) AddBlock(
-	ctx context.Context,
-	tx storage.DatabaseTransaction,
-	block *rosetta.Block,
-) ([]*reconciler.AccountAndCurrency, error) {
-	log.Printf("Adding block %+v\n", block.BlockIdentifier)
-	err := s.storage.StoreBlock(ctx, tx, block)
-	if err != nil {
-		return nil, err
+// recordBlockTime records the time between the timestamps
+// of a block added to the head and the head, logging and
+// summarizing each completed BlockTimeWindow. Timestamps
+// are assumed to be in milliseconds (as required by the
+// Rosetta specification) if timestamps are not validated.
+func (s *Syncer) recordBlockTime(block *rosetta.Block) {
+	if s.headTimestamp == 0 || block.Timestamp == 0 {
+		return
 	}
 
-	err = s.storage.StoreHeadBlockIdentifier(ctx, tx, block.BlockIdentifier)
-	if err != nil {
-		return nil, err
+	unit := s.timestampUnit
+	if len(unit) == 0 {
+		unit = utils.Milliseconds
 	}
 
-	modifiedAccounts, err := s.storeBlockBalanceChanges(ctx, tx, block, false)
-	if err != nil {
-		return nil, err
+	blockTime := unit.Time(block.Timestamp).Sub(unit.Time(s.headTimestamp))
+	window := s.blockTimes.observe(block.BlockIdentifier.Index, blockTime)
+	if window == nil {
+		return
 	}
 
-	return modifiedAccounts, nil
+	log.Printf(
+		"%sBlock times %d-%d: min %dms, median %dms, p99 %dms\n",
+		s.logPrefix(),
+		window.StartIndex,
+		window.EndIndex,
+		window.MinMS,
+		window.MedianMS,
+		window.P99MS,
+	)
+	s.summary.BlockTimes = append(s.summary.BlockTimes, window)
 }
 
 // ProcessBlock determines if a block should be added or the current
-// head should be orphaned.
+// head should be removed and notifies the Handler.
 func (s *Syncer) ProcessBlock(
 	ctx context.Context,
-	currIndex int64,
 	block *rosetta.Block,
-) ([]*reconciler.AccountAndCurrency, int64, error) {
-	tx := s.storage.NewDatabaseTransaction(ctx, true)
-	defer tx.Discard(ctx)
-
-	reorg, err := s.checkReorg(ctx, tx, block)
+) error {
+	reorg, err := s.checkReorg(block)
 	if err != nil {
-		return nil, currIndex, err
+		return err
 	}
 
-	var modifiedAccounts []*reconciler.AccountAndCurrency
-	var newIndex int64
-	if reorg {
-		if currIndex == 0 {
-			return nil, 0, errors.New("Can't reorg genesis block")
+	// Once the Handler has been called, it is allowed to
+	// finish (within the process timeout) even if ctx is
+	// canceled so that the block is committed on shutdown.
+	if !reorg {
+		if err := s.checkTimestamp(block); err != nil {
+			if !s.skipList.Contains(block.BlockIdentifier) {
+				return err
+			}
+
+			log.Printf("Skipping timestamp error in listed block: %v\n", err)
 		}
 
-		head, err := s.storage.GetHeadBlockIdentifier(ctx, tx)
+		processCtx, cancel := utils.ContextWithTimeout(utils.WithoutCancel(ctx), s.timeouts.Process)
+		err = s.handler.BlockAdded(processCtx, block)
+		cancel()
 		if err != nil {
-			return nil, currIndex, err
+			return err
 		}
 
-		modifiedAccounts, err = s.OrphanBlock(ctx, tx, head)
-		if err != nil {
-			return nil, currIndex, err
+		s.observeBlockGap(block)
+		s.recordBlockTime(block)
+		s.pastBlocks = append(s.pastBlocks, block.BlockIdentifier)
+		if len(s.pastBlocks) > PastBlockSize {
+			s.pastBlocks = s.pastBlocks[1:]
+		}
+		s.nextIndex = block.BlockIdentifier.Index + 1
+		s.reorgDepth = 0
+		s.headTimestamp = block.Timestamp
+		if s.summary.StartIndex < 0 {
+			s.summary.StartIndex = block.BlockIdentifier.Index
 		}
+		s.summary.BlocksProcessed++
+		s.metrics.IncrCounter(metrics.BlocksSynced, 1)
+		s.metrics.SetGauge(metrics.SyncHeadIndex, float64(block.BlockIdentifier.Index))
+		return nil
+	}
 
-		newIndex = currIndex - 1
-		err = s.logger.BlockStream(ctx, block, true)
-		if err != nil {
-			log.Printf("Unable to log block %v\n", err)
+	head := s.head()
+	if s.genesis != nil && head.Index == s.genesis.Index {
+		return ErrCannotRemoveGenesisBlock
+	}
+
+	if len(s.pastBlocks) == 1 {
+		if err := s.loadPastBlocks(ctx, head); err != nil {
+			return err
 		}
-	} else {
-		modifiedAccounts, err = s.AddBlock(ctx, tx, block)
+	}
+
+	if s.maxReorgDepth > 0 && s.reorgDepth >= s.maxReorgDepth {
+		return fmt.Errorf(
+			"%w: cannot remove %+v after orphaning %d blocks",
+			ErrMaxReorgDepthExceeded,
+			head,
+			s.reorgDepth,
+		)
+	}
+
+	processCtx, cancel := utils.ContextWithTimeout(utils.WithoutCancel(ctx), s.timeouts.Process)
+	err = s.handler.BlockRemoved(processCtx, head)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	s.pastBlocks = s.pastBlocks[:len(s.pastBlocks)-1]
+	s.nextIndex = head.Index
+	s.reorgDepth++
+	s.headTimestamp = 0
+	s.summary.BlocksOrphaned++
+	s.metrics.IncrCounter(metrics.BlocksOrphaned, 1)
+	s.metrics.SetGauge(metrics.SyncHeadIndex, float64(s.head().Index))
+	return nil
+}
+
+// loadPastBlocks replaces the block identifiers held in
+// memory (of which only head remains) with those loaded
+// from the BlockHistory so that a reorg can continue
+// past them.
+func (s *Syncer) loadPastBlocks(
+	ctx context.Context,
+	head *rosetta.BlockIdentifier,
+) error {
+	if s.history == nil {
+		return fmt.Errorf("%w: cannot remove %+v", ErrOutOfPastBlocks, head)
+	}
+
+	pastBlocks, err := s.history.PastBlocks(ctx, head, PastBlockSize)
+	if err != nil {
+		return fmt.Errorf("%w: unable to load blocks before %+v", err, head)
+	}
+
+	if len(pastBlocks) < 2 || pastBlocks[len(pastBlocks)-1].Hash != head.Hash {
+		return fmt.Errorf("%w: cannot remove %+v", ErrOutOfPastBlocks, head)
+	}
+
+	log.Printf("%sLoaded %d past blocks to continue reorg\n", s.logPrefix(), len(pastBlocks)-1)
+	s.pastBlocks = pastBlocks
+	return nil
+}
+
+// processQueuedBlocks processes blocks that were queued
+// by a previous SyncCycle (possibly before a restart)
+// until no block is queued at the next index.
+func (s *Syncer) processQueuedBlocks(ctx context.Context) error {
+	for ctx.Err() == nil {
+		block, err := s.queue.QueuedBlock(ctx, s.nextIndex)
 		if err != nil {
-			return nil, currIndex, err
+			return err
 		}
 
-		newIndex = currIndex + 1
-		err = s.logger.BlockStream(ctx, block, false)
-		if err != nil {
-			log.Printf("Unable to log block %v\n", err)
+		if block == nil {
+			return nil
+		}
+
+		log.Printf("%sProcessing queued block %d\n", s.logPrefix(), s.nextIndex)
+		if err := s.ProcessBlock(ctx, block); err != nil {
+			return err
 		}
 	}
 
-	err = tx.Commit(ctx)
+	return ctx.Err()
+}
+
+// fetchedBlocks are the blocks fetched from
+// a range ending at endIndex.
+type fetchedBlocks struct {
+	blocks   map[int64]*fetcher.BlockAndLatency
+	endIndex int64
+	err      error
+}
+
+// fetchBlocks fetches the blocks from startIndex to endIndex,
+// inclusive, and queues them (if the durable queue is
+// configured). The Fetch timeout bounds the request for
+// each block (not the whole range).
+func (s *Syncer) fetchBlocks(
+	ctx context.Context,
+	startIndex int64,
+	endIndex int64,
+) (map[int64]*fetcher.BlockAndLatency, error) {
+	blockMap, err := concurrentBlockRange(ctx, startIndex, endIndex, s.blockConcurrency, func(
+		ctx context.Context,
+		blockIdentifier *rosetta.PartialBlockIdentifier,
+	) (*rosetta.Block, error) {
+		fetchCtx, cancel := utils.ContextWithTimeout(ctx, s.timeouts.Fetch)
+		defer cancel()
+
+		return s.fetcher.BlockRetry(
+			fetchCtx,
+			s.network,
+			blockIdentifier,
+			s.maxElapsedTime,
+			s.maxRetries,
+		)
+	})
 	if err != nil {
-		return nil, currIndex, err
+		return nil, fetchError(err)
 	}
 
-	return modifiedAccounts, newIndex, nil
+	if s.queue != nil {
+		blocks := make([]*rosetta.Block, 0, len(blockMap))
+		for _, block := range blockMap {
+			if block.Block != nil {
+				blocks = append(blocks, block.Block)
+			}
+		}
+
+		if err := s.queue.QueueBlocks(ctx, blocks); err != nil {
+			return nil, err
+		}
+	}
+
+	return blockMap, nil
+}
+
+// prefetchBlocks fetches the blocks from startIndex to
+// endIndex, inclusive, in ranges of prefetch blocks and
+// sends each range to results in order. It stops after
+// the first error and closes results when it returns.
+func (s *Syncer) prefetchBlocks(
+	ctx context.Context,
+	startIndex int64,
+	endIndex int64,
+	prefetch int64,
+	results chan<- *fetchedBlocks,
+) {
+	defer close(results)
+
+	for start := startIndex; start <= endIndex; start += prefetch {
+		end := start + prefetch - 1
+		if end > endIndex {
+			end = endIndex
+		}
+
+		blockMap, err := s.fetchBlocks(ctx, start, end)
+		select {
+		case results <- &fetchedBlocks{blocks: blockMap, endIndex: end, err: err}:
+		case <-ctx.Done():
+			return
+		}
+
+		if err != nil {
+			return
+		}
+	}
 }
 
 // SyncBlockRange syncs blocks from startIndex to endIndex, inclusive.
@@ -280,28 +1066,70 @@ func (s *Syncer) SyncBlockRange(
 	startIndex int64,
 	endIndex int64,
 ) error {
-	allBlocks := make([]*fetcher.BlockAndLatency, 0)
-	blockMap, err := s.fetcher.BlockRange(ctx, s.network, startIndex, endIndex)
-	if err != nil {
-		return err
+	prefetch := s.prefetch
+	if prefetch < 1 || prefetch > endIndex-startIndex+1 {
+		prefetch = endIndex - startIndex + 1
 	}
 
-	currIndex := startIndex
-	for currIndex <= endIndex {
-		block, ok := blockMap[currIndex]
+	// The buffer allows the next range to be fetched
+	// while the previous one is waiting to be processed.
+	prefetchCtx, cancel := context.WithCancel(ctx)
+	results := make(chan *fetchedBlocks, 1)
+	prefetched := make(chan struct{})
+	go func() {
+		s.prefetchBlocks(prefetchCtx, startIndex, endIndex, prefetch, results)
+		close(prefetched)
+	}()
+	defer func() {
+		cancel()
+		<-prefetched
+	}()
+
+	allBlocks := make([]*logger.BlockStats, 0)
+	blockMap := map[int64]*fetcher.BlockAndLatency{}
+	fetchedIndex := startIndex - 1
+
+	s.nextIndex = startIndex
+	for s.nextIndex <= endIndex {
+		// Stop between blocks once ctx is canceled.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Blocks are processed strictly in order, so wait
+		// for the range containing the next block.
+		for s.nextIndex > fetchedIndex {
+			result, ok := <-results
+			if !ok {
+				return ctx.Err()
+			}
+
+			if result.err != nil {
+				return result.err
+			}
+
+			for index, block := range result.blocks {
+				blockMap[index] = block
+			}
+			fetchedIndex = result.endIndex
+		}
+
+		block, ok := blockMap[s.nextIndex]
 		if !ok { // could happen in a reorg
 			start := time.Now()
+			fetchCtx, cancel := utils.ContextWithTimeout(ctx, s.timeouts.Fetch)
 			blockValue, err := s.fetcher.BlockRetry(
-				ctx,
+				fetchCtx,
 				s.network,
 				&rosetta.PartialBlockIdentifier{
-					Index: &currIndex,
+					Index: &s.nextIndex,
 				},
-				fetcher.DefaultElapsedTime,
-				fetcher.DefaultRetries,
+				s.maxElapsedTime,
+				s.maxRetries,
 			)
+			cancel()
 			if err != nil {
-				return err
+				return fetchError(err)
 			}
 
 			block = &fetcher.BlockAndLatency{
@@ -312,38 +1140,92 @@ func (s *Syncer) SyncBlockRange(
 			// Anytime we re-fetch an index, we
 			// will need to make another call to the node
 			// as it is likely in a reorg.
-			delete(blockMap, currIndex)
+			delete(blockMap, s.nextIndex)
 		}
+		s.metrics.ObserveHistogram(metrics.BlockFetchSeconds, block.Latency)
 
-		// Can't return modifiedAccounts without creating new variable
-		modifiedAccounts, newIndex, err := s.ProcessBlock(
-			ctx,
-			currIndex,
-			block.Block,
-		)
-		if err != nil {
+		if block.Block == nil {
+			s.nextIndex++
+			s.summary.BlocksOmitted++
+			continue
+		}
+
+		applyStart := time.Now()
+		if err := s.ProcessBlock(ctx, block.Block); err != nil {
 			return err
 		}
+		applyLatency := time.Since(applyStart).Seconds()
+		s.metrics.ObserveHistogram(metrics.BlockApplySeconds, applyLatency)
+
+		// A block that caused a reorg was not added.
+		if s.nextIndex > block.Block.BlockIdentifier.Index {
+			allBlocks = append(allBlocks, blockStats(block, applyLatency))
+		}
+	}
+
+	return s.logger.BlockStats(ctx, allBlocks)
+}
 
-		currIndex = newIndex
-		allBlocks = append(allBlocks, block)
-		s.reconciler.QueueAccounts(ctx, block.Block.BlockIdentifier.Index, modifiedAccounts)
+// blockStats returns the BlockStats of a fetched
+// block that took applyLatency seconds to add.
+func blockStats(block *fetcher.BlockAndLatency, applyLatency float64) *logger.BlockStats {
+	stats := &logger.BlockStats{
+		Index:        block.Block.BlockIdentifier.Index,
+		Transactions: len(block.Block.Transactions),
+		FetchLatency: block.Latency,
+		ApplyLatency: applyLatency,
+	}
+	for _, transaction := range block.Block.Transactions {
+		stats.Operations += len(transaction.Operations)
 	}
 
-	return s.logger.BlockLatency(ctx, allBlocks)
+	return stats
 }
 
-// SyncCycle is a single iteration of processing up to maxSync blocks.
+// networkInformation returns the information about the
+// network being synced in networkStatus. If the Syncer
+// syncs a sub-network, this is the information in the
+// status of that sub-network.
+func (s *Syncer) networkInformation(
+	networkStatus *rosetta.NetworkStatusResponse,
+) (*rosetta.NetworkInformation, error) {
+	if s.network == nil || s.network.SubNetworkIdentifier == nil {
+		return networkStatus.NetworkStatus.NetworkInformation, nil
+	}
+
+	subNetwork := s.network.SubNetworkIdentifier.SubNetwork
+	for _, status := range networkStatus.SubNetworkStatus {
+		if status.SubNetworkIdentifier.SubNetwork == subNetwork {
+			return status.NetworkInformation, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrSubNetworkNotFound, subNetwork)
+}
+
+// logPrefix returns the prefix of log messages, which
+// identifies the sub-network being synced (if any).
+func (s *Syncer) logPrefix() string {
+	if s.network == nil || s.network.SubNetworkIdentifier == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("[%s] ", s.network.SubNetworkIdentifier.SubNetwork)
+}
+
+// SyncCycle is a single iteration of processing up to MaxSync blocks.
 // SyncCycle is called repeatedly by Sync until there is an error.
 func (s *Syncer) SyncCycle(ctx context.Context, printNetwork bool) error {
+	fetchCtx, cancel := utils.ContextWithTimeout(ctx, s.timeouts.Fetch)
 	networkStatus, err := s.fetcher.NetworkStatusRetry(
-		ctx,
+		fetchCtx,
 		nil,
-		fetcher.DefaultElapsedTime,
-		fetcher.DefaultRetries,
+		s.maxElapsedTime,
+		s.maxRetries,
 	)
+	cancel()
 	if err != nil {
-		return err
+		return fetchError(err)
 	}
 
 	if printNetwork {
@@ -353,40 +1235,256 @@ func (s *Syncer) SyncCycle(ctx context.Context, printNetwork bool) error {
 		}
 	}
 
-	tx := s.storage.NewDatabaseTransaction(ctx, false)
-	defer tx.Discard(ctx)
+	networkInformation, err := s.networkInformation(networkStatus)
+	if err != nil {
+		return err
+	}
 
-	head, err := s.storage.GetHeadBlockIdentifier(ctx, tx)
-	if err == storage.ErrHeadBlockNotFound {
-		head = networkStatus.NetworkStatus.NetworkInformation.GenesisBlockIdentifier
-	} else if err != nil {
+	// If no blocks have been processed, start syncing from
+	// the start index (if it is after genesis) or the block
+	// after genesis.
+	s.genesis = networkInformation.GenesisBlockIdentifier
+	if err := s.verifyGenesis(ctx, s.genesis); err != nil {
 		return err
 	}
 
-	currIndex := head.Index + 1
-	endIndex := networkStatus.NetworkStatus.NetworkInformation.CurrentBlockIdentifier.Index
-	if endIndex-currIndex > maxSync {
+	if s.head() == nil {
+		if s.startIndex > s.genesis.Index+1 {
+			s.nextIndex = s.startIndex
+		} else {
+			s.pastBlocks = []*rosetta.BlockIdentifier{s.genesis}
+			s.nextIndex = s.genesis.Index + 1
+		}
+	}
+
+	if s.queue != nil {
+		if err := s.processQueuedBlocks(ctx); err != nil {
+			return err
+		}
+	}
+
+	currIndex := s.nextIndex
+	if s.endIndex >= 0 && currIndex > s.endIndex {
+		return ErrEndIndexReached
+	}
+
+	tip := networkInformation.CurrentBlockIdentifier
+	if s.tipObserver != nil {
+		s.tipObserver.ObserveTip(tip, currIndex > tip.Index)
+	}
+
+	if s.checkTipRegression(tip) && s.pauseOnTipRegression {
+		return nil
+	}
+
+	endIndex := tip.Index
+	if maxSync := s.MaxSync(); endIndex-currIndex > maxSync {
 		endIndex = currIndex + maxSync
 	}
 
+	if s.endIndex >= 0 && endIndex > s.endIndex {
+		endIndex = s.endIndex
+	}
+
 	if currIndex > endIndex {
-		log.Printf("Next block %d > Blockchain Head %d", currIndex, endIndex)
+		if s.exitAtTip {
+			return ErrTipReached
+		}
+
+		if atomic.SwapInt32(&s.atTip, 1) == 0 {
+			if s.blockEvents != nil && s.blockEvents.Connected() {
+				log.Printf("%sAt tip %d, waiting for block events\n", s.logPrefix(), tip.Index)
+			} else {
+				log.Printf(
+					"%sAt tip %d, polling every %s\n",
+					s.logPrefix(),
+					tip.Index,
+					s.tipPollInterval,
+				)
+			}
+		}
+
 		return nil
 	}
 
-	log.Printf("Syncing blocks %d-%d\n", currIndex, endIndex)
-	return s.SyncBlockRange(ctx, currIndex, endIndex)
+	if atomic.SwapInt32(&s.atTip, 0) == 1 {
+		log.Printf("%sNew tip %d\n", s.logPrefix(), tip.Index)
+	}
+
+	log.Printf("%sSyncing blocks %d-%d\n", s.logPrefix(), currIndex, endIndex)
+	if err := s.SyncBlockRange(ctx, currIndex, endIndex); err != nil {
+		return err
+	}
+
+	if s.endIndex >= 0 && s.nextIndex > s.endIndex {
+		return ErrEndIndexReached
+	}
+
+	return nil
+}
+
+// checkTipRegression returns true if tip is below the
+// highest tip reported by the node (or the head). The
+// first tip of each regression is logged, counted in the
+// NodeHeadRegressions metric, and passed to the
+// TipRegressionObserver (if any).
+func (s *Syncer) checkTipRegression(tip *rosetta.BlockIdentifier) bool {
+	if head := s.head(); s.highestTip == nil && head != nil {
+		s.highestTip = head
+	}
+
+	if s.highestTip == nil || tip.Index >= s.highestTip.Index {
+		if s.tipRegressed {
+			log.Printf("%sNode head recovered to %d\n", s.logPrefix(), tip.Index)
+			s.metrics.SetGauge(metrics.NodeHeadRegressed, 0)
+			s.tipRegressed = false
+		}
+
+		s.highestTip = tip
+		return false
+	}
+
+	if s.tipRegressed {
+		return true
+	}
+
+	s.tipRegressed = true
+	if s.pauseOnTipRegression {
+		log.Printf(
+			"%sNode head moved backwards from %d to %d, pausing syncing until it recovers\n",
+			s.logPrefix(),
+			s.highestTip.Index,
+			tip.Index,
+		)
+	} else {
+		log.Printf(
+			"%sNode head moved backwards from %d to %d\n",
+			s.logPrefix(),
+			s.highestTip.Index,
+			tip.Index,
+		)
+	}
+
+	s.metrics.IncrCounter(metrics.NodeHeadRegressions, 1)
+	s.metrics.SetGauge(metrics.NodeHeadRegressed, 1)
+	if s.regressionObserver != nil {
+		s.regressionObserver.ObserveTipRegression(s.highestTip, tip)
+	}
+
+	return true
+}
+
+// tipPollDelay returns the time to wait before
+// polling the node for a new tip.
+func (s *Syncer) tipPollDelay() time.Duration {
+	delay := s.tipPollInterval
+	if s.tipPollJitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(s.tipPollJitter)))
+	}
+
+	return delay
+}
+
+// waitForTip waits for a new tip once every block up to
+// the tip has been processed: until a block event is
+// received or, if block events are not being received,
+// until the node should be polled.
+func (s *Syncer) waitForTip(ctx context.Context) {
+	var events <-chan struct{}
+	if s.blockEvents != nil {
+		events = s.blockEvents.Events()
+	}
+
+	var poll <-chan time.Time
+	if s.blockEvents == nil || !s.blockEvents.Connected() {
+		poll = time.After(s.tipPollDelay())
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-events:
+	case <-poll:
+	}
+}
+
+// pause waits before the next SyncCycle after one failed
+// with err (see SetCircuitBreaker). It returns err if
+// syncing should stop instead.
+func (s *Syncer) pause(ctx context.Context, err error) error {
+	wasOpen := s.breaker.isOpen()
+	wait, ok := s.breaker.trip(err, time.Now())
+	if !ok {
+		var fetchErr *FetchError
+		if wasOpen && errors.As(err, &fetchErr) {
+			return fmt.Errorf("%w: syncing paused for %s", err, s.breaker.timeout)
+		}
+
+		return err
+	}
+
+	if wasOpen {
+		log.Printf(
+			"%sRosetta Server still unavailable (%v), retrying in %s\n",
+			s.logPrefix(),
+			err,
+			wait,
+		)
+	} else {
+		log.Printf(
+			"%sRosetta Server unavailable (%v), pausing syncing for %s\n",
+			s.logPrefix(),
+			err,
+			wait,
+		)
+		s.metrics.SetGauge(metrics.SyncPaused, 1)
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(wait):
+	}
+
+	return nil
 }
 
-// Sync cycles endlessly until there is an error.
+// Sync cycles endlessly until there is an error. Once
+// every block up to the tip has been processed, it waits
+// between cycles instead of polling the node continuously
+// (see SetTipPolling and SetBlockEvents). If the circuit breaker is enabled,
+// it pauses after a failed request to the node instead of
+// returning an error (see SetCircuitBreaker).
 func (s *Syncer) Sync(ctx context.Context) error {
 	printNetwork := true
 	for ctx.Err() == nil {
 		err := s.SyncCycle(ctx, printNetwork)
+		var fetchErr *FetchError
+		if s.breaker.isOpen() && !errors.As(err, &fetchErr) {
+			log.Printf(
+				"%sRosetta Server recovered after %s, resuming syncing\n",
+				s.logPrefix(),
+				s.breaker.reset(time.Now()).Round(time.Second),
+			)
+			s.metrics.SetGauge(metrics.SyncPaused, 0)
+		}
+
 		if err != nil {
-			return err
+			if ctx.Err() != nil {
+				return err
+			}
+
+			if err := s.pause(ctx, err); err != nil {
+				return err
+			}
+
+			continue
 		}
 		printNetwork = false
+
+		if !s.AtTip() && !(s.tipRegressed && s.pauseOnTipRegression) {
+			continue
+		}
+
+		s.waitForTip(ctx)
 	}
 
 	return nil
@@ -16,93 +16,25 @@ package syncer
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/coinbase/rosetta-validator/internal/generator"
 	"github.com/coinbase/rosetta-validator/internal/logger"
-	"github.com/coinbase/rosetta-validator/internal/reconciler"
-	"github.com/coinbase/rosetta-validator/internal/storage"
+	"github.com/coinbase/rosetta-validator/internal/metrics"
+	"github.com/coinbase/rosetta-validator/internal/utils"
+	mockSyncer "github.com/coinbase/rosetta-validator/mocks/syncer"
 
-	"github.com/coinbase/rosetta-sdk-go/asserter"
 	"github.com/coinbase/rosetta-sdk-go/fetcher"
 	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 var (
-	currency = &rosetta.Currency{
-		Symbol:   "Blah",
-		Decimals: 2,
-	}
-
-	recipient = &rosetta.AccountIdentifier{
-		Address: "acct1",
-	}
-
-	recipientAmount = &rosetta.Amount{
-		Value:    "100",
-		Currency: currency,
-	}
-
-	recipientOperation = &rosetta.Operation{
-		OperationIdentifier: &rosetta.OperationIdentifier{
-			Index: 0,
-		},
-		Type:    "Transfer",
-		Status:  "Success",
-		Account: recipient,
-		Amount:  recipientAmount,
-	}
-
-	recipientFailureOperation = &rosetta.Operation{
-		OperationIdentifier: &rosetta.OperationIdentifier{
-			Index: 1,
-		},
-		Type:    "Transfer",
-		Status:  "Failure",
-		Account: recipient,
-		Amount:  recipientAmount,
-	}
-
-	recipientTransaction = &rosetta.Transaction{
-		TransactionIdentifier: &rosetta.TransactionIdentifier{
-			Hash: "tx1",
-		},
-		Operations: []*rosetta.Operation{
-			recipientOperation,
-			recipientFailureOperation,
-		},
-	}
-
-	sender = &rosetta.AccountIdentifier{
-		Address: "acct2",
-	}
-
-	senderAmount = &rosetta.Amount{
-		Value:    "-100",
-		Currency: currency,
-	}
-
-	senderOperation = &rosetta.Operation{
-		OperationIdentifier: &rosetta.OperationIdentifier{
-			Index: 0,
-		},
-		Type:    "Transfer",
-		Status:  "Success",
-		Account: sender,
-		Amount:  senderAmount,
-	}
-
-	senderTransaction = &rosetta.Transaction{
-		TransactionIdentifier: &rosetta.TransactionIdentifier{
-			Hash: "tx2",
-		},
-		Operations: []*rosetta.Operation{
-			senderOperation,
-		},
-	}
-
 	blockSequenceNoReorg = []*rosetta.Block{
 		&rosetta.Block{ // genesis
 			BlockIdentifier: &rosetta.BlockIdentifier{
@@ -133,22 +65,6 @@ var (
 				Hash:  "1",
 				Index: 1,
 			},
-			Transactions: []*rosetta.Transaction{
-				recipientTransaction,
-			},
-		},
-		&rosetta.Block{
-			BlockIdentifier: &rosetta.BlockIdentifier{
-				Hash:  "3",
-				Index: 3,
-			},
-			ParentBlockIdentifier: &rosetta.BlockIdentifier{
-				Hash:  "2",
-				Index: 2,
-			},
-			Transactions: []*rosetta.Transaction{
-				senderTransaction,
-			},
 		},
 	}
 
@@ -172,9 +88,6 @@ var (
 				Hash:  "0",
 				Index: 0,
 			},
-			Transactions: []*rosetta.Transaction{
-				recipientTransaction,
-			},
 		},
 		&rosetta.Block{
 			BlockIdentifier: &rosetta.BlockIdentifier{
@@ -217,335 +130,1029 @@ var (
 			},
 		},
 	}
-
-	operationStatuses = []*rosetta.OperationStatus{
-		&rosetta.OperationStatus{
-			Status:     "Success",
-			Successful: true,
-		},
-		&rosetta.OperationStatus{
-			Status:     "Failure",
-			Successful: false,
-		},
-	}
-
-	networkStatusResponse = &rosetta.NetworkStatusResponse{
-		NetworkStatus: &rosetta.NetworkStatus{
-			NetworkInformation: &rosetta.NetworkInformation{
-				GenesisBlockIdentifier: &rosetta.BlockIdentifier{
-					Index: 0,
-				},
-			},
-		},
-		Options: &rosetta.Options{
-			OperationStatuses: operationStatuses,
-		},
-	}
 )
 
 func TestNoReorgProcessBlock(t *testing.T) {
 	ctx := context.Background()
+	handler := &mockSyncer.Handler{}
+	syncer := New(ctx, nil, nil, handler, nil, &metrics.NoOpSink{}, Timeouts{}, nil, nil)
+
+	t.Run("No block exists", func(t *testing.T) {
+		handler.On("BlockAdded", ctx, blockSequenceNoReorg[0]).Return(nil).Once()
+		assert.NoError(t, syncer.ProcessBlock(ctx, blockSequenceNoReorg[0]))
+		assert.Equal(t, int64(1), syncer.nextIndex)
+		assert.Equal(t, blockSequenceNoReorg[0].BlockIdentifier, syncer.head())
+	})
 
-	newDir, err := storage.CreateTempDir()
-	assert.NoError(t, err)
-	defer storage.RemoveTempDir(*newDir)
+	t.Run("Block exists, no reorg", func(t *testing.T) {
+		handler.On("BlockAdded", ctx, blockSequenceNoReorg[1]).Return(nil).Once()
+		assert.NoError(t, syncer.ProcessBlock(ctx, blockSequenceNoReorg[1]))
+		assert.Equal(t, int64(2), syncer.nextIndex)
+		assert.Equal(t, blockSequenceNoReorg[1].BlockIdentifier, syncer.head())
+	})
+
+	t.Run("Handler error", func(t *testing.T) {
+		handlerErr := errors.New("handler failed")
+		handler.On("BlockAdded", ctx, blockSequenceNoReorg[2]).Return(handlerErr).Once()
+		assert.True(t, errors.Is(syncer.ProcessBlock(ctx, blockSequenceNoReorg[2]), handlerErr))
+		assert.Equal(t, int64(2), syncer.nextIndex)
+		assert.Equal(t, blockSequenceNoReorg[1].BlockIdentifier, syncer.head())
+	})
 
-	database, err := storage.NewBadgerStorage(ctx, *newDir)
-	assert.NoError(t, err)
-	defer database.Close(ctx)
+	handler.AssertExpectations(t)
+}
 
-	blockStorage := storage.NewBlockStorage(ctx, database)
-	logger := logger.NewLogger(*newDir, false, false)
-	fetcher := &fetcher.Fetcher{
-		Asserter: asserter.New(ctx, networkStatusResponse),
-	}
-	rec := reconciler.New(ctx, nil, blockStorage, fetcher, logger, 1)
-	syncer := New(ctx, nil, blockStorage, fetcher, logger, rec)
-	currIndex := int64(0)
+func TestReorgProcessBlock(t *testing.T) {
+	ctx := context.Background()
+	handler := &mockSyncer.Handler{}
+	syncer := New(ctx, nil, nil, handler, nil, &metrics.NoOpSink{}, Timeouts{}, nil, nil)
 
 	t.Run("No block exists", func(t *testing.T) {
-		modifiedAccounts, newIndex, err := syncer.ProcessBlock(
-			ctx,
-			currIndex,
-			blockSequenceNoReorg[0],
+		handler.On("BlockAdded", ctx, blockSequenceReorg[0]).Return(nil).Once()
+		assert.NoError(t, syncer.ProcessBlock(ctx, blockSequenceReorg[0]))
+		assert.Equal(t, int64(1), syncer.nextIndex)
+	})
+
+	t.Run("Block exists, no reorg", func(t *testing.T) {
+		handler.On("BlockAdded", ctx, blockSequenceReorg[1]).Return(nil).Once()
+		assert.NoError(t, syncer.ProcessBlock(ctx, blockSequenceReorg[1]))
+		assert.Equal(t, int64(2), syncer.nextIndex)
+	})
+
+	t.Run("Orphan block", func(t *testing.T) {
+		handler.On("BlockRemoved", ctx, blockSequenceReorg[1].BlockIdentifier).Return(nil).Once()
+		assert.NoError(t, syncer.ProcessBlock(ctx, blockSequenceReorg[2]))
+		assert.Equal(t, int64(1), syncer.nextIndex)
+		assert.Equal(t, blockSequenceReorg[0].BlockIdentifier, syncer.head())
+
+		for _, block := range []*rosetta.Block{
+			blockSequenceReorg[3],
+			blockSequenceReorg[2],
+			blockSequenceReorg[4],
+		} {
+			handler.On("BlockAdded", ctx, block).Return(nil).Once()
+			assert.NoError(t, syncer.ProcessBlock(ctx, block))
+			assert.Equal(t, block.BlockIdentifier, syncer.head())
+		}
+		assert.Equal(t, int64(4), syncer.nextIndex)
+	})
+
+	t.Run("Out of order block", func(t *testing.T) {
+		assert.EqualError(
+			t,
+			syncer.ProcessBlock(ctx, blockSequenceReorg[5]),
+			"Got block 5 instead of 4",
 		)
-		currIndex = newIndex
-		assert.Equal(t, int64(1), currIndex)
-		assert.Equal(t, 0, len(modifiedAccounts))
-		assert.NoError(t, err)
+		assert.Equal(t, int64(4), syncer.nextIndex)
+		assert.Equal(t, blockSequenceReorg[4].BlockIdentifier, syncer.head())
+	})
 
-		tx := syncer.storage.NewDatabaseTransaction(ctx, false)
-		head, err := syncer.storage.GetHeadBlockIdentifier(ctx, tx)
-		tx.Discard(ctx)
-		assert.Equal(t, blockSequenceNoReorg[0].BlockIdentifier, head)
-		assert.NoError(t, err)
+	handler.AssertExpectations(t)
+}
+
+func TestReorgLimits(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Genesis block", func(t *testing.T) {
+		syncer := New(ctx, nil, nil, &mockSyncer.Handler{}, nil, &metrics.NoOpSink{}, Timeouts{}, nil, []*rosetta.BlockIdentifier{
+			blockSequenceReorg[0].BlockIdentifier,
+		})
+		syncer.genesis = blockSequenceReorg[0].BlockIdentifier
+
+		err := syncer.ProcessBlock(ctx, &rosetta.Block{
+			BlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "1",
+				Index: 1,
+			},
+			ParentBlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "0a",
+				Index: 0,
+			},
+		})
+		assert.True(t, errors.Is(err, ErrCannotRemoveGenesisBlock))
 	})
 
-	t.Run("Block exists, no reorg", func(t *testing.T) {
-		modifiedAccounts, newIndex, err := syncer.ProcessBlock(
+	t.Run("Out of past blocks", func(t *testing.T) {
+		syncer := New(ctx, nil, nil, &mockSyncer.Handler{}, nil, &metrics.NoOpSink{}, Timeouts{}, nil, []*rosetta.BlockIdentifier{
+			blockSequenceReorg[1].BlockIdentifier,
+		})
+
+		err := syncer.ProcessBlock(ctx, blockSequenceReorg[2])
+		assert.True(t, errors.Is(err, ErrOutOfPastBlocks))
+		assert.Equal(t, int64(2), syncer.nextIndex)
+	})
+
+	t.Run("Past blocks loaded from history", func(t *testing.T) {
+		handler := &mockSyncer.Handler{}
+		history := &mockSyncer.BlockHistory{}
+		syncer := New(ctx, nil, nil, handler, nil, &metrics.NoOpSink{}, Timeouts{}, nil, []*rosetta.BlockIdentifier{
+			blockSequenceReorg[1].BlockIdentifier,
+		})
+		syncer.SetBlockHistory(history)
+
+		history.On(
+			"PastBlocks",
 			ctx,
-			currIndex,
-			blockSequenceNoReorg[1],
-		)
-		currIndex = newIndex
-		assert.Equal(t, int64(2), currIndex)
-		assert.Equal(t, 0, len(modifiedAccounts))
-		assert.NoError(t, err)
+			blockSequenceReorg[1].BlockIdentifier,
+			PastBlockSize,
+		).Return([]*rosetta.BlockIdentifier{
+			blockSequenceReorg[0].BlockIdentifier,
+			blockSequenceReorg[1].BlockIdentifier,
+		}, nil).Once()
+		handler.On("BlockRemoved", mock.Anything, blockSequenceReorg[1].BlockIdentifier).Return(nil).Once()
 
-		tx := syncer.storage.NewDatabaseTransaction(ctx, false)
-		head, err := syncer.storage.GetHeadBlockIdentifier(ctx, tx)
-		tx.Discard(ctx)
-		assert.Equal(t, blockSequenceNoReorg[1].BlockIdentifier, head)
-		assert.NoError(t, err)
+		assert.NoError(t, syncer.ProcessBlock(ctx, blockSequenceReorg[2]))
+		assert.Equal(t, int64(1), syncer.nextIndex)
+		assert.Equal(t, blockSequenceReorg[0].BlockIdentifier, syncer.head())
+		history.AssertExpectations(t)
+		handler.AssertExpectations(t)
 	})
 
-	t.Run("Block with transaction", func(t *testing.T) {
-		modifiedAccounts, newIndex, err := syncer.ProcessBlock(
+	t.Run("History exhausted", func(t *testing.T) {
+		history := &mockSyncer.BlockHistory{}
+		syncer := New(ctx, nil, nil, &mockSyncer.Handler{}, nil, &metrics.NoOpSink{}, Timeouts{}, nil, []*rosetta.BlockIdentifier{
+			blockSequenceReorg[1].BlockIdentifier,
+		})
+		syncer.SetBlockHistory(history)
+
+		history.On(
+			"PastBlocks",
 			ctx,
-			currIndex,
-			blockSequenceNoReorg[2],
-		)
-		currIndex = newIndex
-		assert.Equal(t, int64(3), currIndex)
-		assert.Equal(t, []*reconciler.AccountAndCurrency{
-			&reconciler.AccountAndCurrency{
-				Account: &rosetta.AccountIdentifier{
-					Address: "acct1",
-				},
-				Currency: currency,
+			blockSequenceReorg[1].BlockIdentifier,
+			PastBlockSize,
+		).Return([]*rosetta.BlockIdentifier{
+			blockSequenceReorg[1].BlockIdentifier,
+		}, nil).Once()
+
+		err := syncer.ProcessBlock(ctx, blockSequenceReorg[2])
+		assert.True(t, errors.Is(err, ErrOutOfPastBlocks))
+		assert.Equal(t, int64(2), syncer.nextIndex)
+		history.AssertExpectations(t)
+	})
+
+	t.Run("Max reorg depth", func(t *testing.T) {
+		handler := &mockSyncer.Handler{}
+		syncer := New(ctx, nil, nil, handler, nil, &metrics.NoOpSink{}, Timeouts{}, nil, []*rosetta.BlockIdentifier{
+			blockSequenceReorg[0].BlockIdentifier,
+			blockSequenceReorg[1].BlockIdentifier,
+			blockSequenceReorg[2].BlockIdentifier,
+		})
+		syncer.SetMaxReorgDepth(1)
+
+		// Block 2 is orphaned by its replacement.
+		handler.On("BlockRemoved", mock.Anything, blockSequenceReorg[2].BlockIdentifier).Return(nil).Once()
+		assert.NoError(t, syncer.ProcessBlock(ctx, &rosetta.Block{
+			BlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "3",
+				Index: 3,
 			},
-		}, modifiedAccounts)
-		assert.NoError(t, err)
+			ParentBlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "2a",
+				Index: 2,
+			},
+		}))
 
-		tx := syncer.storage.NewDatabaseTransaction(ctx, false)
-		head, err := syncer.storage.GetHeadBlockIdentifier(ctx, tx)
-		assert.Equal(t, blockSequenceNoReorg[2].BlockIdentifier, head)
-		assert.NoError(t, err)
+		// Orphaning block 1 as well exceeds the depth.
+		err := syncer.ProcessBlock(ctx, &rosetta.Block{
+			BlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "2a",
+				Index: 2,
+			},
+			ParentBlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  "1a",
+				Index: 1,
+			},
+		})
+		assert.True(t, errors.Is(err, ErrMaxReorgDepthExceeded))
+		assert.Equal(t, blockSequenceReorg[1].BlockIdentifier, syncer.head())
+		handler.AssertExpectations(t)
+	})
+}
 
-		amounts, block, err := syncer.storage.GetBalance(ctx, tx, recipient)
-		tx.Discard(ctx)
+func TestTimestampValidation(t *testing.T) {
+	ctx := context.Background()
+	genesis := &rosetta.BlockIdentifier{Hash: "0", Index: 0}
+	block := func(index int64, timestamp int64) *rosetta.Block {
+		return &rosetta.Block{
+			BlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  fmt.Sprintf("%d", index),
+				Index: index,
+			},
+			ParentBlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  fmt.Sprintf("%d", index-1),
+				Index: index - 1,
+			},
+			Timestamp: timestamp,
+		}
+	}
 
-		// Ensure amount only increases by successful operation
-		assert.Equal(t, map[string]*rosetta.Amount{
-			storage.GetCurrencyKey(currency): recipientAmount,
-		}, amounts)
-		assert.Equal(t, blockSequenceNoReorg[2].BlockIdentifier, block)
-		assert.NoError(t, err)
+	handler := &mockSyncer.Handler{}
+	syncer := New(ctx, nil, nil, handler, nil, &metrics.NoOpSink{}, Timeouts{}, nil, []*rosetta.BlockIdentifier{
+		genesis,
 	})
+	syncer.genesis = genesis
+	syncer.SetTimestampValidation(utils.Milliseconds, time.Second)
 
-	t.Run("Block with invalid transaction", func(t *testing.T) {
-		modifiedAccounts, newIndex, err := syncer.ProcessBlock(
-			ctx,
-			currIndex,
-			blockSequenceNoReorg[3],
-		)
-		currIndex = newIndex
-		assert.Equal(t, int64(3), currIndex)
-		assert.Equal(t, 0, len(modifiedAccounts))
-		assert.Contains(t, err.Error(), storage.ErrNegativeBalance.Error())
-
-		tx := syncer.storage.NewDatabaseTransaction(ctx, false)
-		head, err := syncer.storage.GetHeadBlockIdentifier(ctx, tx)
-		assert.Equal(t, blockSequenceNoReorg[2].BlockIdentifier, head)
+	t.Run("Zero timestamp", func(t *testing.T) {
+		err := syncer.ProcessBlock(ctx, block(1, 0))
+		assert.True(t, errors.Is(err, ErrZeroTimestamp))
+	})
+
+	t.Run("Within tolerance", func(t *testing.T) {
+		handler.On("BlockAdded", mock.Anything, mock.Anything).Return(nil).Twice()
+		assert.NoError(t, syncer.ProcessBlock(ctx, block(1, 1600000010000)))
+		assert.NoError(t, syncer.ProcessBlock(ctx, block(2, 1600000009000)))
+	})
+
+	t.Run("Before parent", func(t *testing.T) {
+		err := syncer.ProcessBlock(ctx, block(3, 1600000007999))
+		assert.True(t, errors.Is(err, ErrTimestampBeforeParent))
+		assert.Contains(t, err.Error(), "1.001s before parent")
+		assert.Equal(t, int64(3), syncer.nextIndex)
+	})
+
+	t.Run("Listed block", func(t *testing.T) {
+		skipList, err := utils.ParseSkipList([]string{"3"})
 		assert.NoError(t, err)
+		syncer.SetSkipList(skipList)
+
+		handler.On("BlockAdded", mock.Anything, mock.Anything).Return(nil).Once()
+		assert.NoError(t, syncer.ProcessBlock(ctx, block(3, 1600000007999)))
+		assert.Equal(t, int64(4), syncer.nextIndex)
+	})
 
-		amounts, block, err := syncer.storage.GetBalance(ctx, tx, sender)
-		tx.Discard(ctx)
-		assert.Nil(t, amounts)
-		assert.Nil(t, block)
-		assert.EqualError(t, err, fmt.Errorf(
-			"%w %+v",
-			storage.ErrAccountNotFound,
-			sender,
-		).Error())
+	t.Run("Violations logged", func(t *testing.T) {
+		syncer.SetLogTimestampViolations(true)
+
+		handler.On("BlockAdded", mock.Anything, mock.Anything).Return(nil).Once()
+		assert.NoError(t, syncer.ProcessBlock(ctx, block(4, 1600000005000)))
+		assert.Equal(t, int64(5), syncer.nextIndex)
+
+		err := syncer.ProcessBlock(ctx, block(5, 0))
+		assert.True(t, errors.Is(err, ErrZeroTimestamp))
 	})
+
+	handler.AssertExpectations(t)
 }
 
-func TestReorgProcessBlock(t *testing.T) {
+// gapRecorder records the gap observed for each block.
+type gapRecorder map[int64]time.Duration
+
+func (r gapRecorder) ObserveBlockGap(block *rosetta.BlockIdentifier, gap time.Duration) {
+	r[block.Index] = gap
+}
+
+func TestBlockGapObserver(t *testing.T) {
+	ctx := context.Background()
+	genesis := &rosetta.BlockIdentifier{Hash: "0", Index: 0}
+	block := func(index int64, timestamp int64) *rosetta.Block {
+		return &rosetta.Block{
+			BlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  fmt.Sprintf("%d", index),
+				Index: index,
+			},
+			ParentBlockIdentifier: &rosetta.BlockIdentifier{
+				Hash:  fmt.Sprintf("%d", index-1),
+				Index: index - 1,
+			},
+			Timestamp: timestamp,
+		}
+	}
+
+	handler := &mockSyncer.Handler{}
+	syncer := New(ctx, nil, nil, handler, nil, &metrics.NoOpSink{}, Timeouts{}, nil, []*rosetta.BlockIdentifier{
+		genesis,
+	})
+	syncer.genesis = genesis
+	syncer.SetTimestampValidation(utils.Milliseconds, time.Second)
+	gaps := gapRecorder{}
+	syncer.SetBlockGapObserver(gaps)
+
+	handler.On("BlockAdded", mock.Anything, mock.Anything).Return(nil).Times(3)
+	assert.NoError(t, syncer.ProcessBlock(ctx, block(1, 1600000000000)))
+	assert.NoError(t, syncer.ProcessBlock(ctx, block(2, 1600000015000)))
+	assert.NoError(t, syncer.ProcessBlock(ctx, block(3, 1600001815000)))
+
+	// The timestamp of the parent of the first
+	// block is not known.
+	assert.Equal(t, gapRecorder{
+		2: 15 * time.Second,
+		3: 30 * time.Minute,
+	}, gaps)
+	handler.AssertExpectations(t)
+}
+
+func TestProcessTimeout(t *testing.T) {
 	ctx := context.Background()
+	handler := &mockSyncer.Handler{}
+	syncer := New(
+		ctx,
+		nil,
+		nil,
+		handler,
+		nil,
+		&metrics.NoOpSink{},
+		Timeouts{Process: 10 * time.Millisecond},
+		nil,
+		nil,
+	)
+
+	handler.On("BlockAdded", mock.Anything, blockSequenceNoReorg[0]).Return(
+		func(ctx context.Context, block *rosetta.Block) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	).Once()
 
-	newDir, err := storage.CreateTempDir()
-	assert.NoError(t, err)
-	defer storage.RemoveTempDir(*newDir)
+	err := syncer.ProcessBlock(ctx, blockSequenceNoReorg[0])
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Nil(t, syncer.head())
+	handler.AssertExpectations(t)
+}
 
-	database, err := storage.NewBadgerStorage(ctx, *newDir)
-	assert.NoError(t, err)
-	defer database.Close(ctx)
+func TestDurableQueueProcessBlock(t *testing.T) {
+	ctx := context.Background()
+	handler := &mockSyncer.Handler{}
+	queue := &mockSyncer.Queue{}
+	syncer := New(ctx, nil, nil, handler, nil, &metrics.NoOpSink{}, Timeouts{}, queue, []*rosetta.BlockIdentifier{
+		blockSequenceNoReorg[0].BlockIdentifier,
+	})
 
-	blockStorage := storage.NewBlockStorage(ctx, database)
-	logger := logger.NewLogger(*newDir, false, false)
-	fetcher := &fetcher.Fetcher{
-		Asserter: asserter.New(ctx, networkStatusResponse),
+	// Blocks 1 and 2 were queued before a restart.
+	for _, block := range blockSequenceNoReorg[1:] {
+		index := block.BlockIdentifier.Index
+		queue.On("QueuedBlock", ctx, index).Return(block, nil).Once()
+		handler.On("BlockAdded", ctx, block).Return(nil).Once()
 	}
-	rec := reconciler.New(ctx, nil, blockStorage, fetcher, logger, 1)
-	syncer := New(ctx, nil, blockStorage, fetcher, logger, rec)
-	currIndex := int64(0)
+	queue.On("QueuedBlock", ctx, int64(3)).Return(nil, nil).Once()
 
-	t.Run("No block exists", func(t *testing.T) {
-		modifiedAccounts, newIndex, err := syncer.ProcessBlock(
-			ctx,
-			currIndex,
-			blockSequenceReorg[0],
-		)
-		currIndex = newIndex
-		assert.Equal(t, int64(1), currIndex)
-		assert.Equal(t, 0, len(modifiedAccounts))
-		assert.NoError(t, err)
+	t.Run("Process queued blocks", func(t *testing.T) {
+		assert.NoError(t, syncer.processQueuedBlocks(ctx))
+		assert.Equal(t, int64(3), syncer.nextIndex)
+		assert.Equal(t, blockSequenceNoReorg[2].BlockIdentifier, syncer.head())
+	})
 
-		tx := syncer.storage.NewDatabaseTransaction(ctx, false)
-		head, err := syncer.storage.GetHeadBlockIdentifier(ctx, tx)
-		tx.Discard(ctx)
-		assert.Equal(t, blockSequenceReorg[0].BlockIdentifier, head)
-		assert.NoError(t, err)
+	handler.AssertExpectations(t)
+	queue.AssertExpectations(t)
+}
+
+// blockStatsOf matches the BlockStats
+// of the blocks at indexes.
+func blockStatsOf(indexes ...int64) interface{} {
+	return mock.MatchedBy(func(blocks []*logger.BlockStats) bool {
+		if len(blocks) != len(indexes) {
+			return false
+		}
+
+		for i, block := range blocks {
+			if block.Index != indexes[i] {
+				return false
+			}
+		}
+
+		return true
 	})
+}
 
-	t.Run("Block exists, no reorg", func(t *testing.T) {
-		modifiedAccounts, newIndex, err := syncer.ProcessBlock(
-			ctx,
-			currIndex,
-			blockSequenceReorg[1],
-		)
-		currIndex = newIndex
-		assert.Equal(t, int64(2), currIndex)
-		assert.Equal(t, []*reconciler.AccountAndCurrency{
-			&reconciler.AccountAndCurrency{
-				Account: &rosetta.AccountIdentifier{
-					Address: "acct1",
-				},
-				Currency: currency,
+func TestVerifyGenesis(t *testing.T) {
+	ctx := context.Background()
+	genesis := blockSequenceNoReorg[0]
+	mockFetcher := &mockSyncer.Fetcher{}
+	syncer := New(ctx, nil, mockFetcher, nil, nil, &metrics.NoOpSink{}, Timeouts{}, nil, nil)
+
+	t.Run("Not configured", func(t *testing.T) {
+		assert.NoError(t, syncer.verifyGenesis(ctx, genesis.BlockIdentifier))
+	})
+
+	t.Run("Reported genesis mismatch", func(t *testing.T) {
+		syncer.SetExpectedGenesisHash("other")
+		err := syncer.verifyGenesis(ctx, genesis.BlockIdentifier)
+		assert.True(t, errors.Is(err, ErrGenesisMismatch))
+	})
+
+	syncer.SetExpectedGenesisHash(genesis.BlockIdentifier.Hash)
+	fetchGenesis := func(block *rosetta.Block) {
+		mockFetcher.On(
+			"BlockRetry",
+			mock.Anything,
+			mock.Anything,
+			&rosetta.PartialBlockIdentifier{Index: &genesis.BlockIdentifier.Index},
+			fetcher.DefaultElapsedTime,
+			uint64(fetcher.DefaultRetries),
+		).Return(block, nil).Once()
+	}
+
+	t.Run("Fetched genesis mismatch", func(t *testing.T) {
+		fetchGenesis(blockSequenceNoReorg[1])
+		err := syncer.verifyGenesis(ctx, genesis.BlockIdentifier)
+		assert.True(t, errors.Is(err, ErrGenesisMismatch))
+		assert.False(t, syncer.genesisVerified)
+	})
+
+	t.Run("Genesis matches", func(t *testing.T) {
+		fetchGenesis(genesis)
+		assert.NoError(t, syncer.verifyGenesis(ctx, genesis.BlockIdentifier))
+		assert.True(t, syncer.genesisVerified)
+
+		// The genesis block is only fetched once.
+		assert.NoError(t, syncer.verifyGenesis(ctx, genesis.BlockIdentifier))
+	})
+
+	mockFetcher.AssertExpectations(t)
+}
+
+// expectBlocks expects each of blocks to be
+// fetched once with BlockRetry.
+func expectBlocks(f *mockSyncer.Fetcher, network interface{}, blocks ...*rosetta.Block) {
+	for _, block := range blocks {
+		index := block.BlockIdentifier.Index
+		f.On(
+			"BlockRetry",
+			mock.Anything,
+			network,
+			&rosetta.PartialBlockIdentifier{Index: &index},
+			fetcher.DefaultElapsedTime,
+			uint64(fetcher.DefaultRetries),
+		).Return(block, nil).Once()
+	}
+}
+
+func TestSyncCycle(t *testing.T) {
+	ctx := context.Background()
+	mockFetcher := &mockSyncer.Fetcher{}
+	handler := &mockSyncer.Handler{}
+	logger := &mockSyncer.Logger{}
+	syncer := New(ctx, nil, mockFetcher, handler, logger, &metrics.NoOpSink{}, Timeouts{}, nil, nil)
+
+	mockFetcher.On(
+		"NetworkStatusRetry",
+		mock.Anything,
+		mock.Anything,
+		fetcher.DefaultElapsedTime,
+		uint64(fetcher.DefaultRetries),
+	).Return(&rosetta.NetworkStatusResponse{
+		NetworkStatus: &rosetta.NetworkStatus{
+			NetworkInformation: &rosetta.NetworkInformation{
+				GenesisBlockIdentifier: blockSequenceNoReorg[0].BlockIdentifier,
+				CurrentBlockIdentifier: blockSequenceNoReorg[2].BlockIdentifier,
 			},
-		}, modifiedAccounts)
-		assert.NoError(t, err)
+		},
+	}, nil)
 
-		tx := syncer.storage.NewDatabaseTransaction(ctx, false)
-		head, err := syncer.storage.GetHeadBlockIdentifier(ctx, tx)
-		assert.Equal(t, blockSequenceReorg[1].BlockIdentifier, head)
-		assert.NoError(t, err)
+	t.Run("Sync to current block", func(t *testing.T) {
+		expectBlocks(mockFetcher, mock.Anything, blockSequenceNoReorg[1], blockSequenceNoReorg[2])
+		handler.On("BlockAdded", ctx, blockSequenceNoReorg[1]).Return(nil).Once()
+		handler.On("BlockAdded", ctx, blockSequenceNoReorg[2]).Return(nil).Once()
+		logger.On("BlockStats", ctx, blockStatsOf(1, 2)).Return(nil).Once()
 
-		amounts, block, err := syncer.storage.GetBalance(ctx, tx, recipient)
-		tx.Discard(ctx)
-		assert.Equal(t, map[string]*rosetta.Amount{
-			storage.GetCurrencyKey(currency): recipientAmount,
-		}, amounts)
-		assert.Equal(t, blockSequenceReorg[1].BlockIdentifier, block)
-		assert.NoError(t, err)
+		assert.NoError(t, syncer.SyncCycle(ctx, false))
+		assert.Equal(t, blockSequenceNoReorg[2].BlockIdentifier, syncer.head())
+		assert.Equal(t, RangeSummary{
+			StartIndex:      1,
+			EndIndex:        2,
+			BlocksProcessed: 2,
+		}, syncer.Summary())
 	})
 
-	t.Run("Orphan block", func(t *testing.T) {
-		// Orphan block
-		modifiedAccounts, newIndex, err := syncer.ProcessBlock(
-			ctx,
-			currIndex,
-			blockSequenceReorg[2],
-		)
-		currIndex = newIndex
-		assert.Equal(t, int64(1), currIndex)
-		assert.Equal(t, []*reconciler.AccountAndCurrency{
-			&reconciler.AccountAndCurrency{
-				Account: &rosetta.AccountIdentifier{
-					Address: "acct1",
+	t.Run("Already at current block", func(t *testing.T) {
+		assert.NoError(t, syncer.SyncCycle(ctx, false))
+		assert.True(t, syncer.AtTip())
+	})
+
+	t.Run("Exit at tip", func(t *testing.T) {
+		syncer.SetExitAtTip(true)
+		err := syncer.SyncCycle(ctx, false)
+		assert.True(t, errors.Is(err, ErrTipReached))
+	})
+
+	mockFetcher.AssertExpectations(t)
+	handler.AssertExpectations(t)
+	logger.AssertExpectations(t)
+}
+
+// regressionRecorder records the previous and regressed
+// tip of each regression.
+type regressionRecorder [][2]int64
+
+func (r *regressionRecorder) ObserveTipRegression(previous *rosetta.BlockIdentifier, tip *rosetta.BlockIdentifier) {
+	*r = append(*r, [2]int64{previous.Index, tip.Index})
+}
+
+func TestSyncCycleTipRegression(t *testing.T) {
+	ctx := context.Background()
+	mockFetcher := &mockSyncer.Fetcher{}
+	handler := &mockSyncer.Handler{}
+	logger := &mockSyncer.Logger{}
+	syncer := New(ctx, nil, mockFetcher, handler, logger, &metrics.NoOpSink{}, Timeouts{}, nil, nil)
+	syncer.SetPauseOnTipRegression(true)
+	regressions := &regressionRecorder{}
+	syncer.SetTipRegressionObserver(regressions)
+
+	status := func(tip int) {
+		mockFetcher.On(
+			"NetworkStatusRetry",
+			mock.Anything,
+			mock.Anything,
+			fetcher.DefaultElapsedTime,
+			uint64(fetcher.DefaultRetries),
+		).Return(&rosetta.NetworkStatusResponse{
+			NetworkStatus: &rosetta.NetworkStatus{
+				NetworkInformation: &rosetta.NetworkInformation{
+					GenesisBlockIdentifier: blockSequenceNoReorg[0].BlockIdentifier,
+					CurrentBlockIdentifier: blockSequenceNoReorg[tip].BlockIdentifier,
 				},
-				Currency: currency,
 			},
-		}, modifiedAccounts)
-		assert.NoError(t, err)
+		}, nil).Once()
+	}
 
-		// Assert head is back to genesis
-		tx := syncer.storage.NewDatabaseTransaction(ctx, false)
-		head, err := syncer.storage.GetHeadBlockIdentifier(ctx, tx)
-		assert.Equal(t, blockSequenceReorg[0].BlockIdentifier, head)
-		assert.NoError(t, err)
+	// Sync block 1 while the tip is at 1.
+	status(1)
+	expectBlocks(mockFetcher, mock.Anything, blockSequenceNoReorg[1])
+	handler.On("BlockAdded", ctx, blockSequenceNoReorg[1]).Return(nil).Once()
+	logger.On("BlockStats", ctx, blockStatsOf(1)).Return(nil).Once()
+	assert.NoError(t, syncer.SyncCycle(ctx, false))
+
+	t.Run("Tip moves backwards", func(t *testing.T) {
+		status(0)
+		assert.NoError(t, syncer.SyncCycle(ctx, false))
+		assert.True(t, syncer.tipRegressed)
+		assert.Equal(t, regressionRecorder{{1, 0}}, *regressions)
+	})
+
+	t.Run("Paused until the tip recovers", func(t *testing.T) {
+		// The tip advancing from the regressed tip is not
+		// a new regression, and nothing is synced until it
+		// is back at the previous high.
+		status(0)
+		assert.NoError(t, syncer.SyncCycle(ctx, false))
+		assert.True(t, syncer.tipRegressed)
+		assert.Len(t, *regressions, 1)
+		assert.Equal(t, blockSequenceNoReorg[1].BlockIdentifier, syncer.head())
+	})
+
+	t.Run("Tip recovers", func(t *testing.T) {
+		status(2)
+		expectBlocks(mockFetcher, mock.Anything, blockSequenceNoReorg[2])
+		handler.On("BlockAdded", ctx, blockSequenceNoReorg[2]).Return(nil).Once()
+		logger.On("BlockStats", ctx, blockStatsOf(2)).Return(nil).Once()
+
+		assert.NoError(t, syncer.SyncCycle(ctx, false))
+		assert.False(t, syncer.tipRegressed)
+		assert.Equal(t, blockSequenceNoReorg[2].BlockIdentifier, syncer.head())
+	})
+
+	mockFetcher.AssertExpectations(t)
+	handler.AssertExpectations(t)
+	logger.AssertExpectations(t)
+}
+
+func TestSyncTipPolling(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	mockFetcher := &mockSyncer.Fetcher{}
+	handler := &mockSyncer.Handler{}
+	logger := &mockSyncer.Logger{}
+	syncer := New(ctx, nil, mockFetcher, handler, logger, &metrics.NoOpSink{}, Timeouts{}, nil, nil)
+	syncer.SetTipPolling(time.Hour, time.Second)
+
+	mockFetcher.On(
+		"NetworkStatusRetry",
+		mock.Anything,
+		mock.Anything,
+		fetcher.DefaultElapsedTime,
+		uint64(fetcher.DefaultRetries),
+	).Return(&rosetta.NetworkStatusResponse{
+		NetworkStatus: &rosetta.NetworkStatus{
+			NetworkInformation: &rosetta.NetworkInformation{
+				GenesisBlockIdentifier: blockSequenceNoReorg[0].BlockIdentifier,
+				CurrentBlockIdentifier: blockSequenceNoReorg[0].BlockIdentifier,
+			},
+		},
+	}, nil)
+
+	// The node is only polled once before the
+	// context expires.
+	assert.NoError(t, syncer.Sync(ctx))
+	assert.True(t, syncer.AtTip())
+	mockFetcher.AssertNumberOfCalls(t, "NetworkStatusRetry", 1)
+}
+
+type staticBlockEvents struct {
+	connected bool
+	events    chan struct{}
+}
+
+func (e *staticBlockEvents) Connected() bool {
+	return e.connected
+}
+
+func (e *staticBlockEvents) Events() <-chan struct{} {
+	return e.events
+}
+
+func TestSyncBlockEvents(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	mockFetcher := &mockSyncer.Fetcher{}
+	handler := &mockSyncer.Handler{}
+	logger := &mockSyncer.Logger{}
+	syncer := New(ctx, nil, mockFetcher, handler, logger, &metrics.NoOpSink{}, Timeouts{}, nil, nil)
+	syncer.SetTipPolling(time.Millisecond, 0)
+
+	events := &staticBlockEvents{connected: true, events: make(chan struct{}, 2)}
+	events.events <- struct{}{}
+	events.events <- struct{}{}
+	syncer.SetBlockEvents(events)
+
+	mockFetcher.On(
+		"NetworkStatusRetry",
+		mock.Anything,
+		mock.Anything,
+		fetcher.DefaultElapsedTime,
+		uint64(fetcher.DefaultRetries),
+	).Return(&rosetta.NetworkStatusResponse{
+		NetworkStatus: &rosetta.NetworkStatus{
+			NetworkInformation: &rosetta.NetworkInformation{
+				GenesisBlockIdentifier: blockSequenceNoReorg[0].BlockIdentifier,
+				CurrentBlockIdentifier: blockSequenceNoReorg[0].BlockIdentifier,
+			},
+		},
+	}, nil)
+
+	// The node is polled once and then only after
+	// each event (not every TipPollInterval).
+	assert.NoError(t, syncer.Sync(ctx))
+	assert.True(t, syncer.AtTip())
+	mockFetcher.AssertNumberOfCalls(t, "NetworkStatusRetry", 3)
+}
+
+func TestSyncCycleStartEndIndex(t *testing.T) {
+	ctx := context.Background()
+	mockFetcher := &mockSyncer.Fetcher{}
+	handler := &mockSyncer.Handler{}
+	logger := &mockSyncer.Logger{}
+	syncer := New(ctx, nil, mockFetcher, handler, logger, &metrics.NoOpSink{}, Timeouts{}, nil, nil)
+	syncer.SetStartIndex(2)
+	syncer.SetEndIndex(2)
+
+	tip := &rosetta.BlockIdentifier{
+		Hash:  "5",
+		Index: 5,
+	}
+	mockFetcher.On(
+		"NetworkStatusRetry",
+		mock.Anything,
+		mock.Anything,
+		fetcher.DefaultElapsedTime,
+		uint64(fetcher.DefaultRetries),
+	).Return(&rosetta.NetworkStatusResponse{
+		NetworkStatus: &rosetta.NetworkStatus{
+			NetworkInformation: &rosetta.NetworkInformation{
+				GenesisBlockIdentifier: blockSequenceNoReorg[0].BlockIdentifier,
+				CurrentBlockIdentifier: tip,
+			},
+		},
+	}, nil)
+
+	t.Run("Sync to end index", func(t *testing.T) {
+		expectBlocks(mockFetcher, mock.Anything, blockSequenceNoReorg[2])
+		handler.On("BlockAdded", ctx, blockSequenceNoReorg[2]).Return(nil).Once()
+		logger.On("BlockStats", ctx, mock.Anything).Return(nil).Once()
+
+		err := syncer.SyncCycle(ctx, false)
+		assert.True(t, errors.Is(err, ErrEndIndexReached))
+		assert.Equal(t, []*rosetta.BlockIdentifier{blockSequenceNoReorg[2].BlockIdentifier}, syncer.pastBlocks)
+	})
+
+	t.Run("Already at end index", func(t *testing.T) {
+		err := syncer.SyncCycle(ctx, false)
+		assert.True(t, errors.Is(err, ErrEndIndexReached))
+	})
+
+	mockFetcher.AssertExpectations(t)
+	handler.AssertExpectations(t)
+	logger.AssertExpectations(t)
+}
+
+func TestSyncCycleSubNetwork(t *testing.T) {
+	ctx := context.Background()
+	mockFetcher := &mockSyncer.Fetcher{}
+	handler := &mockSyncer.Handler{}
+	logger := &mockSyncer.Logger{}
+	network := &rosetta.NetworkIdentifier{
+		Blockchain: "blockchain",
+		Network:    "network",
+		SubNetworkIdentifier: &rosetta.SubNetworkIdentifier{
+			SubNetwork: "shard 1",
+		},
+	}
+	syncer := New(ctx, network, mockFetcher, handler, logger, &metrics.NoOpSink{}, Timeouts{}, nil, nil)
 
-		// Assert that balance change was reverted
-		// only by the successful operation
-		zeroAmount := map[string]*rosetta.Amount{
-			storage.GetCurrencyKey(currency): &rosetta.Amount{
-				Value:    "0",
-				Currency: currency,
+	// The sub-network is synced to its own tip,
+	// not the tip of the network.
+	networkStatus := &rosetta.NetworkStatusResponse{
+		NetworkStatus: &rosetta.NetworkStatus{
+			NetworkInformation: &rosetta.NetworkInformation{
+				GenesisBlockIdentifier: blockSequenceNoReorg[0].BlockIdentifier,
+				CurrentBlockIdentifier: &rosetta.BlockIdentifier{
+					Hash:  "5",
+					Index: 5,
+				},
+			},
+		},
+		SubNetworkStatus: []*rosetta.SubNetworkStatus{
+			{
+				SubNetworkIdentifier: &rosetta.SubNetworkIdentifier{
+					SubNetwork: "shard 0",
+				},
+				NetworkInformation: &rosetta.NetworkInformation{},
+			},
+			{
+				SubNetworkIdentifier: network.SubNetworkIdentifier,
+				NetworkInformation: &rosetta.NetworkInformation{
+					GenesisBlockIdentifier: blockSequenceNoReorg[0].BlockIdentifier,
+					CurrentBlockIdentifier: blockSequenceNoReorg[1].BlockIdentifier,
+				},
 			},
+		},
+	}
+
+	t.Run("Sync to sub-network tip", func(t *testing.T) {
+		mockFetcher.On(
+			"NetworkStatusRetry",
+			mock.Anything,
+			mock.Anything,
+			fetcher.DefaultElapsedTime,
+			uint64(fetcher.DefaultRetries),
+		).Return(networkStatus, nil).Once()
+		expectBlocks(mockFetcher, network, blockSequenceNoReorg[1])
+		handler.On("BlockAdded", ctx, blockSequenceNoReorg[1]).Return(nil).Once()
+		logger.On("BlockStats", ctx, mock.Anything).Return(nil).Once()
+
+		assert.NoError(t, syncer.SyncCycle(ctx, false))
+		assert.Equal(t, blockSequenceNoReorg[1].BlockIdentifier, syncer.head())
+	})
+
+	t.Run("Missing sub-network status", func(t *testing.T) {
+		networkStatus.SubNetworkStatus = networkStatus.SubNetworkStatus[:1]
+		mockFetcher.On(
+			"NetworkStatusRetry",
+			mock.Anything,
+			mock.Anything,
+			fetcher.DefaultElapsedTime,
+			uint64(fetcher.DefaultRetries),
+		).Return(networkStatus, nil).Once()
+
+		err := syncer.SyncCycle(ctx, false)
+		assert.True(t, errors.Is(err, ErrSubNetworkNotFound))
+	})
+
+	mockFetcher.AssertExpectations(t)
+	handler.AssertExpectations(t)
+	logger.AssertExpectations(t)
+}
+
+func TestSyncBlockRangeCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mockFetcher := &mockSyncer.Fetcher{}
+	handler := &mockSyncer.Handler{}
+	syncer := New(ctx, nil, mockFetcher, handler, nil, &metrics.NoOpSink{}, Timeouts{}, nil, nil)
+
+	expectBlocks(mockFetcher, mock.Anything, blockSequenceNoReorg[0], blockSequenceNoReorg[1], blockSequenceNoReorg[2])
+
+	// The block being processed when ctx is canceled is
+	// still processed, but no more blocks are.
+	handler.On("BlockAdded", mock.Anything, blockSequenceNoReorg[0]).Return(nil).Once()
+	handler.On("BlockAdded", mock.Anything, blockSequenceNoReorg[1]).Run(func(args mock.Arguments) {
+		cancel()
+		assert.NoError(t, args.Get(0).(context.Context).Err())
+	}).Return(nil).Once()
+
+	err := syncer.SyncBlockRange(ctx, 0, 2)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, blockSequenceNoReorg[1].BlockIdentifier, syncer.head())
+
+	mockFetcher.AssertExpectations(t)
+	handler.AssertExpectations(t)
+}
+
+// orderHandler is a Handler that records
+// the index of each block added.
+type orderHandler struct {
+	added []int64
+}
+
+func (h *orderHandler) BlockAdded(ctx context.Context, block *rosetta.Block) error {
+	h.added = append(h.added, block.BlockIdentifier.Index)
+	return nil
+}
+
+func (h *orderHandler) BlockRemoved(ctx context.Context, block *rosetta.BlockIdentifier) error {
+	h.added = h.added[:len(h.added)-1]
+	return nil
+}
+
+func TestSyncBlockRangePrefetch(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Applied in order", func(t *testing.T) {
+		g := generator.New(generator.Config{
+			Height:                   100,
+			TransactionsPerBlock:     1,
+			OperationsPerTransaction: 1,
+			ReorgRate:                0.1,
+			Seed:                     1,
+		})
+		handler := &orderHandler{}
+		syncer := New(ctx, nil, g, handler, &benchmarkLogger{}, &metrics.NoOpSink{}, Timeouts{}, nil, nil)
+		syncer.SetPrefetch(7)
+
+		for syncer.head() == nil || syncer.head().Index < 100 {
+			assert.NoError(t, syncer.SyncCycle(ctx, false))
 		}
-		amounts, block, err := syncer.storage.GetBalance(ctx, tx, recipient)
-		assert.Equal(t, zeroAmount, amounts)
-		assert.Equal(t, blockSequenceReorg[0].BlockIdentifier, block)
-		assert.NoError(t, err)
 
-		// Assert block is gone
-		orphanBlock, err := syncer.storage.GetBlock(ctx, tx, blockSequenceReorg[1].BlockIdentifier)
-		assert.Nil(t, orphanBlock)
-		assert.EqualError(t, err, fmt.Errorf(
-			"%w %+v",
-			storage.ErrBlockNotFound,
-			blockSequenceReorg[1].BlockIdentifier,
-		).Error())
-		tx.Discard(ctx)
+		assert.Len(t, handler.added, 100)
+		for i, index := range handler.added {
+			assert.Equal(t, int64(i+1), index)
+		}
 
-		// Process new block
-		modifiedAccounts, currIndex, err = syncer.ProcessBlock(
-			ctx,
-			currIndex,
-			blockSequenceReorg[3],
-		)
-		assert.Equal(t, int64(2), currIndex)
-		assert.Equal(t, 0, len(modifiedAccounts))
-		assert.NoError(t, err)
+		summary := syncer.Summary()
+		assert.Equal(t, int64(1), summary.StartIndex)
+		assert.Equal(t, int64(100), summary.EndIndex)
+		assert.Equal(t, int64(100), summary.BlocksProcessed-summary.BlocksOrphaned)
+		assert.True(t, summary.BlocksOrphaned > 0)
+	})
 
-		tx = syncer.storage.NewDatabaseTransaction(ctx, false)
-		head, err = syncer.storage.GetHeadBlockIdentifier(ctx, tx)
-		tx.Discard(ctx)
-		assert.Equal(t, blockSequenceReorg[3].BlockIdentifier, head)
-		assert.NoError(t, err)
+	t.Run("Fetch error", func(t *testing.T) {
+		mockFetcher := &mockSyncer.Fetcher{}
+		handler := &mockSyncer.Handler{}
+		syncer := New(ctx, nil, mockFetcher, handler, nil, &metrics.NoOpSink{}, Timeouts{}, nil, nil)
+		syncer.SetPrefetch(2)
 
-		modifiedAccounts, currIndex, err = syncer.ProcessBlock(
-			ctx,
-			currIndex,
-			blockSequenceReorg[2],
-		)
-		assert.Equal(t, int64(3), currIndex)
-		assert.Equal(t, 0, len(modifiedAccounts))
-		assert.NoError(t, err)
+		err := errors.New("unavailable")
+		expectBlocks(mockFetcher, mock.Anything, blockSequenceNoReorg[0], blockSequenceNoReorg[1])
+		failedIndex := int64(2)
+		mockFetcher.On(
+			"BlockRetry",
+			mock.Anything,
+			mock.Anything,
+			&rosetta.PartialBlockIdentifier{Index: &failedIndex},
+			fetcher.DefaultElapsedTime,
+			uint64(fetcher.DefaultRetries),
+		).Return(nil, err).Once()
 
-		tx = syncer.storage.NewDatabaseTransaction(ctx, false)
-		head, err = syncer.storage.GetHeadBlockIdentifier(ctx, tx)
-		assert.Equal(t, blockSequenceReorg[2].BlockIdentifier, head)
-		assert.NoError(t, err)
+		// Blocks fetched before the error are processed.
+		handler.On("BlockAdded", mock.Anything, blockSequenceNoReorg[0]).Return(nil).Once()
+		handler.On("BlockAdded", mock.Anything, blockSequenceNoReorg[1]).Return(nil).Once()
 
-		amounts, block, err = syncer.storage.GetBalance(ctx, tx, recipient)
-		tx.Discard(ctx)
-		assert.Equal(t, zeroAmount, amounts)
-		assert.Equal(t, blockSequenceReorg[0].BlockIdentifier, block)
-		assert.NoError(t, err)
+		assert.True(t, errors.Is(syncer.SyncBlockRange(ctx, 0, 2), err))
+		assert.Equal(t, blockSequenceNoReorg[1].BlockIdentifier, syncer.head())
 
-		modifiedAccounts, currIndex, err = syncer.ProcessBlock(
-			ctx,
-			currIndex,
-			blockSequenceReorg[4],
-		)
-		assert.Equal(t, int64(4), currIndex)
-		assert.Equal(t, 0, len(modifiedAccounts))
-		assert.NoError(t, err)
+		mockFetcher.AssertExpectations(t)
+		handler.AssertExpectations(t)
+	})
+}
 
-		tx = syncer.storage.NewDatabaseTransaction(ctx, false)
-		head, err = syncer.storage.GetHeadBlockIdentifier(ctx, tx)
-		tx.Discard(ctx)
-		assert.Equal(t, blockSequenceReorg[4].BlockIdentifier, head)
-		assert.NoError(t, err)
+func TestSetRetries(t *testing.T) {
+	ctx := context.Background()
+	mockFetcher := &mockSyncer.Fetcher{}
+	syncer := New(ctx, nil, mockFetcher, nil, nil, &metrics.NoOpSink{}, Timeouts{}, nil, nil)
+	syncer.SetRetries(5*time.Minute, 3)
+
+	err := errors.New("unavailable")
+	mockFetcher.On(
+		"NetworkStatusRetry",
+		mock.Anything,
+		mock.Anything,
+		5*time.Minute,
+		uint64(3),
+	).Return(nil, err).Once()
+
+	assert.True(t, errors.Is(syncer.SyncCycle(ctx, false), err))
+	mockFetcher.AssertExpectations(t)
+}
+
+// slowFetcher is a Fetcher of generated
+// blocks that takes delay to fetch each block.
+type slowFetcher struct {
+	*generator.Generator
+
+	delay time.Duration
+}
+
+func (f *slowFetcher) BlockRetry(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	blockIdentifier *rosetta.PartialBlockIdentifier,
+	maxElapsedTime time.Duration,
+	maxRetries uint64,
+) (*rosetta.Block, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(f.delay):
+	}
+
+	return f.Generator.BlockRetry(ctx, network, blockIdentifier, maxElapsedTime, maxRetries)
+}
+
+func TestSyncBlockRangeFetchTimeout(t *testing.T) {
+	ctx := context.Background()
+	f := &slowFetcher{
+		Generator: generator.New(generator.Config{
+			Height:                   10,
+			TransactionsPerBlock:     1,
+			OperationsPerTransaction: 1,
+			Seed:                     1,
+		}),
+		delay: 30 * time.Millisecond,
+	}
+
+	t.Run("Each block fetched in time", func(t *testing.T) {
+		// Fetching the range takes longer than the timeout
+		// but fetching each block does not.
+		syncer := New(ctx, nil, f, &benchmarkHandler{}, &benchmarkLogger{}, &metrics.NoOpSink{}, Timeouts{Fetch: 100 * time.Millisecond}, nil, nil)
+		syncer.SetBlockConcurrency(1)
+
+		start := time.Now()
+		assert.NoError(t, syncer.SyncBlockRange(ctx, 0, 5))
+		assert.True(t, time.Since(start) > 100*time.Millisecond)
+		assert.Equal(t, int64(5), syncer.head().Index)
 	})
 
-	t.Run("Out of order block", func(t *testing.T) {
-		modifiedAccounts, newIndex, err := syncer.ProcessBlock(
-			ctx,
-			currIndex,
-			blockSequenceReorg[5],
-		)
-		currIndex = newIndex
-		assert.Equal(t, int64(4), currIndex)
-		assert.Equal(t, 0, len(modifiedAccounts))
-		assert.EqualError(t, err, "Got block 5 instead of 4")
-
-		tx := syncer.storage.NewDatabaseTransaction(ctx, false)
-		head, err := syncer.storage.GetHeadBlockIdentifier(ctx, tx)
-		tx.Discard(ctx)
-		assert.Equal(t, blockSequenceReorg[4].BlockIdentifier, head)
-		assert.NoError(t, err)
+	t.Run("Block not fetched in time", func(t *testing.T) {
+		syncer := New(ctx, nil, f, &benchmarkHandler{}, &benchmarkLogger{}, &metrics.NoOpSink{}, Timeouts{Fetch: 10 * time.Millisecond}, nil, nil)
+
+		err := syncer.SyncBlockRange(ctx, 0, 5)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
 	})
 }
+
+func TestSetMaxSync(t *testing.T) {
+	syncer := New(context.Background(), nil, nil, nil, nil, &metrics.NoOpSink{}, Timeouts{}, nil, nil)
+	assert.Equal(t, int64(DefaultMaxSync), syncer.MaxSync())
+
+	syncer.SetMaxSync(10)
+	assert.Equal(t, int64(10), syncer.MaxSync())
+
+	syncer.SetMaxSync(0)
+	assert.Equal(t, int64(1), syncer.MaxSync())
+}
+
+// benchmarkHandler is a Handler that does nothing.
+type benchmarkHandler struct{}
+
+func (h *benchmarkHandler) BlockAdded(ctx context.Context, block *rosetta.Block) error {
+	return nil
+}
+
+func (h *benchmarkHandler) BlockRemoved(ctx context.Context, block *rosetta.BlockIdentifier) error {
+	return nil
+}
+
+// benchmarkLogger is a Logger that does nothing.
+type benchmarkLogger struct{}
+
+func (l *benchmarkLogger) BlockStats(ctx context.Context, blocks []*logger.BlockStats) error {
+	return nil
+}
+
+func BenchmarkSync(b *testing.B) {
+	ctx := context.Background()
+	for _, reorgRate := range []float64{0, 0.01, 0.1} {
+		b.Run(fmt.Sprintf("reorg rate %.2f", reorgRate), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				g := generator.New(generator.Config{
+					Height:                   1000,
+					TransactionsPerBlock:     10,
+					OperationsPerTransaction: 2,
+					Accounts:                 100,
+					ReorgRate:                reorgRate,
+					Seed:                     int64(i),
+				})
+				syncer := New(
+					ctx,
+					nil,
+					g,
+					&benchmarkHandler{},
+					&benchmarkLogger{},
+					&metrics.NoOpSink{},
+					Timeouts{},
+					nil,
+					nil,
+				)
+
+				for syncer.head() == nil || syncer.head().Index < 1000 {
+					if err := syncer.SyncCycle(ctx, false); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/coinbase/rosetta-sdk-go/asserter"
+	"github.com/coinbase/rosetta-sdk-go/fetcher"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+// ViolationFetcher wraps an UnsafeFetcher so that blocks that
+// fail assertion are returned (the assertion error is logged)
+// instead of failing the fetch, so that the Handler can record
+// each violation and syncing continues (see
+// processor.SyncHandler.SetContinueOnError). A block response
+// without a block is only returned (as a nil block, which the
+// Syncer skips) if allowOmitted is set.
+//
+// A block can't be stored without its identifiers, so the
+// assertion error of a block with an invalid BlockIdentifier
+// or ParentBlockIdentifier is returned. Nil transactions and
+// operations (which can't be encoded) are replaced with empty
+// ones, which the Handler records as violations.
+type ViolationFetcher struct {
+	UnsafeFetcher
+
+	asserter     BlockAsserter
+	concurrency  uint64
+	allowOmitted bool
+
+	maxElapsedTime time.Duration
+	maxRetries     uint64
+}
+
+// NewViolationFetcher returns a new ViolationFetcher
+// that fetches up to concurrency blocks at once.
+func NewViolationFetcher(
+	blockFetcher UnsafeFetcher,
+	asserter BlockAsserter,
+	concurrency uint64,
+	allowOmitted bool,
+) *ViolationFetcher {
+	if concurrency == 0 {
+		concurrency = 1
+	}
+
+	return &ViolationFetcher{
+		UnsafeFetcher:  blockFetcher,
+		asserter:       asserter,
+		concurrency:    concurrency,
+		allowOmitted:   allowOmitted,
+		maxElapsedTime: fetcher.DefaultElapsedTime,
+		maxRetries:     fetcher.DefaultRetries,
+	}
+}
+
+// SetRetries changes the number of times (and the time
+// spent) retrying a failed block request in BlockRange
+// with exponential backoff. It must be called before any
+// blocks are fetched.
+func (f *ViolationFetcher) SetRetries(maxElapsedTime time.Duration, maxRetries uint64) {
+	f.maxElapsedTime = maxElapsedTime
+	f.maxRetries = maxRetries
+}
+
+// BlockRetry fetches a single block, retrying failed
+// requests with exponential backoff up to maxRetries times
+// (or until maxElapsedTime has been spent retrying). A block
+// that fails assertion is not fetched again.
+func (f *ViolationFetcher) BlockRetry(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	blockIdentifier *rosetta.PartialBlockIdentifier,
+	maxElapsedTime time.Duration,
+	maxRetries uint64,
+) (*rosetta.Block, error) {
+	block, err := retryBlock(ctx, blockIdentifier, maxElapsedTime, maxRetries, func() (*rosetta.Block, error) {
+		return f.UnsafeBlock(ctx, network, blockIdentifier)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if block == nil {
+		if f.allowOmitted {
+			return nil, nil
+		}
+
+		return nil, f.asserter.Block(ctx, block)
+	}
+
+	err = f.asserter.Block(ctx, block)
+	if err == nil {
+		return block, nil
+	}
+
+	if asserter.BlockIdentifier(block.BlockIdentifier) != nil ||
+		asserter.BlockIdentifier(block.ParentBlockIdentifier) != nil {
+		return nil, err
+	}
+
+	log.Printf("Continuing after assertion error in block %+v: %v\n", block.BlockIdentifier, err)
+	replaceNil(block)
+	return block, nil
+}
+
+// replaceNil replaces the nil transactions and
+// operations of block with empty ones.
+func replaceNil(block *rosetta.Block) {
+	for i, tx := range block.Transactions {
+		if tx == nil {
+			tx = &rosetta.Transaction{}
+			block.Transactions[i] = tx
+		}
+
+		for j, op := range tx.Operations {
+			if op == nil {
+				tx.Operations[j] = &rosetta.Operation{}
+			}
+		}
+	}
+}
+
+// BlockRange concurrently fetches the blocks from
+// startIndex to endIndex, inclusive.
+func (f *ViolationFetcher) BlockRange(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	startIndex int64,
+	endIndex int64,
+) (map[int64]*fetcher.BlockAndLatency, error) {
+	return concurrentBlockRange(ctx, startIndex, endIndex, f.concurrency, func(
+		ctx context.Context,
+		blockIdentifier *rosetta.PartialBlockIdentifier,
+	) (*rosetta.Block, error) {
+		return f.BlockRetry(ctx, network, blockIdentifier, f.maxElapsedTime, f.maxRetries)
+	})
+}
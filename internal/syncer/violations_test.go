@@ -0,0 +1,114 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestViolationFetcher(t *testing.T) {
+	ctx := context.Background()
+	f := NewViolationFetcher(
+		&omittingFetcher{failures: map[int64]int{3: 1}},
+		&blockAsserter{},
+		2,
+		true,
+	)
+
+	t.Run("Block range", func(t *testing.T) {
+		blocks, err := f.BlockRange(ctx, nil, 1, 4)
+		assert.NoError(t, err)
+		assert.Len(t, blocks, 4)
+		assert.Equal(t, int64(1), blocks[1].Block.BlockIdentifier.Index)
+		assert.Nil(t, blocks[2].Block)
+		assert.Equal(t, int64(3), blocks[3].Block.BlockIdentifier.Index)
+		assert.Nil(t, blocks[4].Block)
+	})
+
+	t.Run("Invalid block", func(t *testing.T) {
+		index := int64(99)
+		block, err := f.BlockRetry(
+			ctx,
+			nil,
+			&rosetta.PartialBlockIdentifier{Index: &index},
+			time.Minute,
+			0,
+		)
+		assert.NoError(t, err)
+		assert.Equal(t, index, block.BlockIdentifier.Index)
+	})
+}
+
+// staticFetcher is an UnsafeFetcher
+// that always returns block.
+type staticFetcher struct {
+	UnsafeFetcher
+
+	block *rosetta.Block
+}
+
+func (f *staticFetcher) UnsafeBlock(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	blockIdentifier *rosetta.PartialBlockIdentifier,
+) (*rosetta.Block, error) {
+	return f.block, nil
+}
+
+func TestViolationFetcherMalformedBlock(t *testing.T) {
+	ctx := context.Background()
+	index := int64(99)
+	fetch := func(block *rosetta.Block) (*rosetta.Block, error) {
+		f := NewViolationFetcher(&staticFetcher{block: block}, &blockAsserter{}, 1, false)
+		return f.BlockRetry(
+			ctx,
+			nil,
+			&rosetta.PartialBlockIdentifier{Index: &index},
+			time.Minute,
+			0,
+		)
+	}
+
+	t.Run("Nil transaction and operation", func(t *testing.T) {
+		block, err := fetch(&rosetta.Block{
+			BlockIdentifier:       &rosetta.BlockIdentifier{Index: index, Hash: "99"},
+			ParentBlockIdentifier: &rosetta.BlockIdentifier{Index: index - 1, Hash: "98"},
+			Transactions: []*rosetta.Transaction{
+				nil,
+				&rosetta.Transaction{
+					TransactionIdentifier: &rosetta.TransactionIdentifier{Hash: "tx"},
+					Operations:            []*rosetta.Operation{nil},
+				},
+			},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, &rosetta.Transaction{}, block.Transactions[0])
+		assert.Equal(t, &rosetta.Operation{}, block.Transactions[1].Operations[0])
+	})
+
+	t.Run("Missing parent", func(t *testing.T) {
+		block, err := fetch(&rosetta.Block{
+			BlockIdentifier: &rosetta.BlockIdentifier{Index: index, Hash: "99"},
+		})
+		assert.EqualError(t, err, "invalid block")
+		assert.Nil(t, block)
+	})
+}
@@ -0,0 +1,232 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// tokenExpiryBuffer is subtracted from the lifetime
+	// of a fetched token so that it is refreshed before
+	// it expires in flight. Tokens that live for less than
+	// twice as long are refreshed halfway through instead.
+	tokenExpiryBuffer = 30 * time.Second
+
+	// authorizationHeader is the header populated with
+	// the bearer token on each request.
+	authorizationHeader = "Authorization"
+)
+
+// ErrMissingAccessToken is returned when a token endpoint
+// responds without an access token.
+var ErrMissingAccessToken = errors.New("token response missing access_token")
+
+// TokenProvider returns a bearer token to attach to
+// requests made to the Rosetta Server. Implementations
+// are responsible for caching and refreshing tokens and
+// must be safe for concurrent use.
+type TokenProvider interface {
+	// Token returns a valid token.
+	Token(ctx context.Context) (string, error)
+
+	// Invalidate discards any cached token so that the
+	// next call to Token returns a fresh token.
+	Invalidate()
+}
+
+// ClientCredentialsProvider is a TokenProvider that
+// fetches short-lived tokens using the OAuth2 client
+// credentials grant and refreshes them before they expire.
+type ClientCredentialsProvider struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	client       *http.Client
+
+	mutex  sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// NewClientCredentialsProvider returns a new
+// ClientCredentialsProvider.
+func NewClientCredentialsProvider(
+	tokenURL string,
+	clientID string,
+	clientSecret string,
+	scopes []string,
+	client *http.Client,
+) *ClientCredentialsProvider {
+	return &ClientCredentialsProvider{
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scopes:       scopes,
+		client:       client,
+	}
+}
+
+// tokenResponse is the response returned by an
+// OAuth2 token endpoint.
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// Token returns the cached token if it has not expired
+// and otherwise fetches a new token.
+func (p *ClientCredentialsProvider) Token(ctx context.Context) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(p.token) > 0 && time.Now().Before(p.expiry) {
+		return p.token, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", p.clientID)
+	form.Set("client_secret", p.clientSecret)
+	if len(p.scopes) > 0 {
+		form.Set("scope", strings.Join(p.scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		p.tokenURL,
+		strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed with status %d", resp.StatusCode)
+	}
+
+	var token tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+
+	if len(token.AccessToken) == 0 {
+		return "", ErrMissingAccessToken
+	}
+
+	p.token = token.AccessToken
+	p.expiry = time.Now().Add(tokenLifetime(token.ExpiresIn))
+
+	return p.token, nil
+}
+
+// tokenLifetime returns how long a token that expires in
+// expiresIn seconds is cached. The refresh margin is at most
+// half the token's lifetime so that short-lived tokens are
+// still reused.
+func tokenLifetime(expiresIn int64) time.Duration {
+	lifetime := time.Duration(expiresIn) * time.Second
+	buffer := tokenExpiryBuffer
+	if buffer > lifetime/2 {
+		buffer = lifetime / 2
+	}
+
+	return lifetime - buffer
+}
+
+// Invalidate discards the cached token.
+func (p *ClientCredentialsProvider) Invalidate() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.token = ""
+}
+
+// AuthTransport is an http.RoundTripper that attaches
+// a bearer token from a TokenProvider to each request.
+// If the server rejects a token as unauthorized, the
+// token is invalidated and the request is retried once
+// with a fresh token.
+type AuthTransport struct {
+	next     http.RoundTripper
+	provider TokenProvider
+}
+
+// NewAuthTransport returns a new AuthTransport.
+func NewAuthTransport(next http.RoundTripper, provider TokenProvider) *AuthTransport {
+	return &AuthTransport{
+		next:     next,
+		provider: provider,
+	}
+}
+
+func (t *AuthTransport) authorizedRoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.provider.Token(req.Context())
+	if err != nil {
+		return nil, fmt.Errorf("unable to get auth token: %w", err)
+	}
+
+	// RoundTrippers must not modify the provided request.
+	authReq := req.Clone(req.Context())
+	authReq.Header.Set(authorizationHeader, "Bearer "+token)
+
+	return t.next.RoundTrip(authReq)
+}
+
+// RoundTrip attaches a bearer token to the request
+// and forwards it.
+func (t *AuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.authorizedRoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// The request body has already been consumed, so
+	// we can only retry if it can be recreated.
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	resp.Body.Close()
+	t.provider.Invalidate()
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return t.authorizedRoundTrip(retry)
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientCredentialsProvider(t *testing.T) {
+	ctx := context.Background()
+	issued := 0
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "client_credentials", r.Form.Get("grant_type"))
+		assert.Equal(t, "id", r.Form.Get("client_id"))
+		assert.Equal(t, "secret", r.Form.Get("client_secret"))
+		assert.Equal(t, "read write", r.Form.Get("scope"))
+
+		issued++
+		fmt.Fprintf(w, `{"access_token":"token%d","token_type":"bearer","expires_in":3600}`, issued)
+	}))
+	defer tokenServer.Close()
+
+	provider := NewClientCredentialsProvider(
+		tokenServer.URL,
+		"id",
+		"secret",
+		[]string{"read", "write"},
+		http.DefaultClient,
+	)
+
+	t.Run("Token is fetched", func(t *testing.T) {
+		token, err := provider.Token(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, "token1", token)
+	})
+
+	t.Run("Token is cached", func(t *testing.T) {
+		token, err := provider.Token(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, "token1", token)
+	})
+
+	t.Run("Token is refreshed after invalidation", func(t *testing.T) {
+		provider.Invalidate()
+		token, err := provider.Token(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, "token2", token)
+	})
+}
+
+func TestTokenLifetime(t *testing.T) {
+	assert.Equal(t, 3570*time.Second, tokenLifetime(3600))
+	assert.Equal(t, 30*time.Second, tokenLifetime(60))
+	assert.Equal(t, 5*time.Second, tokenLifetime(10))
+	assert.Equal(t, time.Duration(0), tokenLifetime(0))
+}
+
+type testTokenProvider struct {
+	tokens []string
+}
+
+func (p *testTokenProvider) Token(ctx context.Context) (string, error) {
+	return p.tokens[0], nil
+}
+
+func (p *testTokenProvider) Invalidate() {
+	p.tokens = p.tokens[1:]
+}
+
+func TestAuthTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(authorizationHeader) != "Bearer fresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}))
+	defer server.Close()
+
+	provider := &testTokenProvider{tokens: []string{"stale", "fresh"}}
+	client := &http.Client{Transport: NewAuthTransport(http.DefaultTransport, provider)}
+
+	t.Run("Unauthorized request is retried with fresh token", func(t *testing.T) {
+		resp, err := client.Post(server.URL, "application/json", strings.NewReader("{}"))
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, []string{"fresh"}, provider.tokens)
+	})
+}
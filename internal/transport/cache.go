@@ -0,0 +1,204 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+const (
+	// blockPath is the Rosetta endpoint for fetching
+	// a single block.
+	blockPath = "/block"
+
+	// blockTransactionPath is the Rosetta endpoint for
+	// fetching a single transaction in a block.
+	blockTransactionPath = "/block/transaction"
+)
+
+// cachedResponse is the subset of an *http.Response
+// needed to replay it to the caller.
+type cachedResponse struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+}
+
+type cacheEntry struct {
+	key      string
+	response *cachedResponse
+}
+
+// CachingTransport is an http.RoundTripper that caches
+// responses to requests for immutable data in a bounded
+// LRU. Only requests that identify a block by hash are
+// considered immutable. Requests for blocks by index
+// are never cached because the block at an index may
+// change in a reorg.
+type CachingTransport struct {
+	next    http.RoundTripper
+	maxSize int
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// NewCachingTransport returns a new CachingTransport that
+// holds at most maxSize responses.
+func NewCachingTransport(next http.RoundTripper, maxSize int) *CachingTransport {
+	return &CachingTransport{
+		next:    next,
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// partialBlockRequest is used to inspect the block
+// identifier of a /block or /block/transaction request.
+type partialBlockRequest struct {
+	BlockIdentifier *struct {
+		Hash *string `json:"hash"`
+	} `json:"block_identifier"`
+}
+
+// isImmutable returns a boolean indicating if the response
+// to a request with the provided path and body can be cached.
+func isImmutable(path string, body []byte) bool {
+	if !isBlockRequest(path) {
+		return false
+	}
+
+	var req partialBlockRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return false
+	}
+
+	return req.BlockIdentifier != nil &&
+		req.BlockIdentifier.Hash != nil &&
+		len(*req.BlockIdentifier.Hash) > 0
+}
+
+func (t *CachingTransport) get(key string) (*cachedResponse, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	elem, ok := t.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	t.order.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).response, true
+}
+
+func (t *CachingTransport) add(key string, response *cachedResponse) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if elem, ok := t.entries[key]; ok {
+		t.order.MoveToFront(elem)
+		elem.Value.(*cacheEntry).response = response
+		return
+	}
+
+	t.entries[key] = t.order.PushFront(&cacheEntry{key: key, response: response})
+	for t.order.Len() > t.maxSize {
+		oldest := t.order.Back()
+		t.order.Remove(oldest)
+		delete(t.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Len returns the number of cached responses.
+func (t *CachingTransport) Len() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.order.Len()
+}
+
+// RoundTrip serves immutable requests from the cache
+// when possible and otherwise forwards the request.
+func (t *CachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost || req.Body == nil || t.maxSize <= 0 {
+		return t.next.RoundTrip(req)
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body.Close()
+
+	// RoundTrippers must not modify the provided request.
+	req = req.Clone(req.Context())
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	if !isImmutable(req.URL.Path, body) {
+		return t.next.RoundTrip(req)
+	}
+
+	key := req.URL.String() + ":" + string(body)
+	if cached, ok := t.get(key); ok {
+		return cached.toResponse(req), nil
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	cached := &cachedResponse{
+		statusCode: resp.StatusCode,
+		header:     resp.Header.Clone(),
+		body:       respBody,
+	}
+	t.add(key, cached)
+
+	return cached.toResponse(req), nil
+}
+
+// toResponse constructs a new *http.Response from
+// a cachedResponse.
+func (c *cachedResponse) toResponse(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        http.StatusText(c.statusCode),
+		StatusCode:    c.statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        c.header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(c.body)),
+		ContentLength: int64(len(c.body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsImmutable(t *testing.T) {
+	var tests = map[string]struct {
+		path   string
+		body   string
+		result bool
+	}{
+		"block by hash": {
+			path:   "/block",
+			body:   `{"block_identifier":{"hash":"abc"}}`,
+			result: true,
+		},
+		"block by index": {
+			path:   "/block",
+			body:   `{"block_identifier":{"index":10}}`,
+			result: false,
+		},
+		"block transaction": {
+			path:   "/block/transaction",
+			body:   `{"block_identifier":{"index":10,"hash":"abc"}}`,
+			result: true,
+		},
+		"network status": {
+			path:   "/network/status",
+			body:   `{}`,
+			result: false,
+		},
+		"invalid body": {
+			path:   "/block",
+			body:   `{`,
+			result: false,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.result, isImmutable(test.path, []byte(test.body)))
+		})
+	}
+}
+
+func TestCachingTransport(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body, _ := ioutil.ReadAll(r.Body)
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	cache := NewCachingTransport(http.DefaultTransport, 1)
+	client := &http.Client{Transport: cache}
+
+	post := func(path string, body string) string {
+		resp, err := client.Post(server.URL+path, "application/json", strings.NewReader(body))
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		respBody, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return string(respBody)
+	}
+
+	t.Run("Mutable requests are forwarded", func(t *testing.T) {
+		body := `{"block_identifier":{"index":1}}`
+		assert.Equal(t, body, post("/block", body))
+		assert.Equal(t, body, post("/block", body))
+		assert.Equal(t, 2, requests)
+		assert.Equal(t, 0, cache.Len())
+	})
+
+	t.Run("Immutable requests are cached", func(t *testing.T) {
+		body := `{"block_identifier":{"hash":"a"}}`
+		assert.Equal(t, body, post("/block", body))
+		assert.Equal(t, body, post("/block", body))
+		assert.Equal(t, 3, requests)
+		assert.Equal(t, 1, cache.Len())
+	})
+
+	t.Run("Oldest entry is evicted", func(t *testing.T) {
+		body := `{"block_identifier":{"hash":"b"}}`
+		assert.Equal(t, body, post("/block", body))
+		assert.Equal(t, 4, requests)
+		assert.Equal(t, 1, cache.Len())
+
+		assert.Equal(t, `{"block_identifier":{"hash":"a"}}`, post("/block", `{"block_identifier":{"hash":"a"}}`))
+		assert.Equal(t, 5, requests)
+	})
+}
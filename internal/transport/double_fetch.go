@@ -0,0 +1,115 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+// ErrNondeterministicResponse is returned when the same
+// block request returns different responses when it is
+// fetched twice.
+var ErrNondeterministicResponse = errors.New("block responses differ across fetches")
+
+// DoubleFetchTransport is an http.RoundTripper that sends
+// every block request twice (once with next and once with
+// check) and compares the responses. A node should always
+// return the same block for the same request, so any
+// difference is a determinism bug in the implementation.
+type DoubleFetchTransport struct {
+	next  http.RoundTripper
+	check http.RoundTripper
+
+	mismatches chan error
+}
+
+// NewDoubleFetchTransport returns a new DoubleFetchTransport.
+// check should not share connections with next so that
+// both fetches are served independently.
+func NewDoubleFetchTransport(
+	next http.RoundTripper,
+	check http.RoundTripper,
+) *DoubleFetchTransport {
+	return &DoubleFetchTransport{
+		next:       next,
+		check:      check,
+		mismatches: make(chan error, 1),
+	}
+}
+
+// Mismatches returns a channel that receives the first
+// mismatch found. Mismatches are also returned from
+// RoundTrip but the caller may retry the request and
+// never surface the error.
+func (t *DoubleFetchTransport) Mismatches() <-chan error {
+	return t.mismatches
+}
+
+// RoundTrip sends block requests with both next and
+// check and returns an error if the responses differ.
+// If the second fetch fails, its response is returned
+// so the caller may retry. All other requests are
+// forwarded to next.
+func (t *DoubleFetchTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || !isBlockRequest(req.URL.Path) {
+		return t.next.RoundTrip(req)
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body.Close()
+
+	firstReq := req.Clone(req.Context())
+	firstReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp, respBody, err := doRequest(t.next, firstReq)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	checkReq := req.Clone(req.Context())
+	checkReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+	checkResp, checkBody, err := doRequest(t.check, checkReq)
+	if err != nil || checkResp.StatusCode != http.StatusOK {
+		return checkResp, err
+	}
+
+	if !equivalentJSON(respBody, checkBody) {
+		err := fmt.Errorf(
+			"%w: %s request %s returned %s and then %s",
+			ErrNondeterministicResponse,
+			req.URL.Path,
+			string(body),
+			string(respBody),
+			string(checkBody),
+		)
+		log.Println(err)
+
+		select {
+		case t.mismatches <- err:
+		default:
+		}
+
+		return nil, err
+	}
+
+	return resp, nil
+}
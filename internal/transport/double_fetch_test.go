@@ -0,0 +1,89 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoubleFetchTransport(t *testing.T) {
+	hits := 0
+	responses := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := responses[hits%len(responses)]
+		hits++
+		_, _ = w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	post := func(client *http.Client, path string) (string, error) {
+		resp, err := client.Post(server.URL+path, "application/json", strings.NewReader("{}"))
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	t.Run("Deterministic responses", func(t *testing.T) {
+		hits = 0
+		responses = []string{`{"block":{"hash":"a"}}`, `{"block": {"hash": "a"}}`}
+		doubleFetch := NewDoubleFetchTransport(http.DefaultTransport, &http.Transport{})
+		client := &http.Client{Transport: doubleFetch}
+
+		body, err := post(client, "/block")
+		assert.NoError(t, err)
+		assert.Equal(t, `{"block":{"hash":"a"}}`, body)
+		assert.Equal(t, 2, hits)
+		assert.Len(t, doubleFetch.Mismatches(), 0)
+	})
+
+	t.Run("Non-block requests are fetched once", func(t *testing.T) {
+		hits = 0
+		responses = []string{`{}`}
+		doubleFetch := NewDoubleFetchTransport(http.DefaultTransport, &http.Transport{})
+		client := &http.Client{Transport: doubleFetch}
+
+		_, err := post(client, "/network/status")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, hits)
+	})
+
+	t.Run("Nondeterministic responses", func(t *testing.T) {
+		hits = 0
+		responses = []string{`{"block":{"hash":"a"}}`, `{"block":{"hash":"b"}}`}
+		doubleFetch := NewDoubleFetchTransport(http.DefaultTransport, &http.Transport{})
+		client := &http.Client{Transport: doubleFetch}
+
+		_, err := post(client, "/block/transaction")
+		assert.True(t, errors.Is(err, ErrNondeterministicResponse))
+		assert.Equal(t, 2, hits)
+
+		// Only the first mismatch is delivered.
+		_, err = post(client, "/block")
+		assert.True(t, errors.Is(err, ErrNondeterministicResponse))
+		assert.Len(t, doubleFetch.Mismatches(), 1)
+		assert.True(t, errors.Is(<-doubleFetch.Mismatches(), ErrNondeterministicResponse))
+	})
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ParseHeaders parses a semicolon-separated list of
+// "Name: value" entries (ex: "Authorization: Bearer abc;
+// X-Api-Key: def") into an http.Header.
+func ParseHeaders(s string) (http.Header, error) {
+	headers := http.Header{}
+	if len(strings.TrimSpace(s)) == 0 {
+		return headers, nil
+	}
+
+	for _, entry := range strings.Split(s, ";") {
+		if len(strings.TrimSpace(entry)) == 0 {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || len(name) == 0 || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid header %q", entry)
+		}
+
+		headers.Add(name, strings.TrimSpace(parts[1]))
+	}
+
+	return headers, nil
+}
+
+// HeaderTransport is an http.RoundTripper that sets
+// a fixed set of headers (ex: for an API gateway in
+// front of the Rosetta Server) on each request,
+// replacing any existing values.
+type HeaderTransport struct {
+	next    http.RoundTripper
+	headers http.Header
+}
+
+// NewHeaderTransport returns a new HeaderTransport.
+func NewHeaderTransport(next http.RoundTripper, headers http.Header) *HeaderTransport {
+	return &HeaderTransport{
+		next:    next,
+		headers: headers,
+	}
+}
+
+// RoundTrip sets the headers on the request
+// and forwards it.
+func (t *HeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the provided request.
+	headerReq := req.Clone(req.Context())
+	for name, values := range t.headers {
+		headerReq.Header[name] = values
+	}
+
+	return t.next.RoundTrip(headerReq)
+}
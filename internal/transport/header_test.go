@@ -0,0 +1,97 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHeaders(t *testing.T) {
+	var tests = map[string]struct {
+		s       string
+		headers http.Header
+		err     bool
+	}{
+		"empty": {
+			s:       " ",
+			headers: http.Header{},
+		},
+		"single header": {
+			s: "Authorization: Bearer abc",
+			headers: http.Header{
+				"Authorization": []string{"Bearer abc"},
+			},
+		},
+		"multiple headers": {
+			s: "x-api-key: def; X-Api-Key: ghi;Accept: a, b;",
+			headers: http.Header{
+				"X-Api-Key": []string{"def", "ghi"},
+				"Accept":    []string{"a, b"},
+			},
+		},
+		"missing value": {
+			s:   "Authorization",
+			err: true,
+		},
+		"invalid name": {
+			s:   "Bearer abc: def",
+			err: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			headers, err := ParseHeaders(test.s)
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.headers, headers)
+		})
+	}
+}
+
+func TestHeaderTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer abc", r.Header.Get("Authorization"))
+		assert.Equal(t, []string{"def", "ghi"}, r.Header["X-Api-Key"])
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	headers, err := ParseHeaders("Authorization: Bearer abc; X-Api-Key: def; X-Api-Key: ghi")
+	assert.NoError(t, err)
+	client := &http.Client{Transport: NewHeaderTransport(http.DefaultTransport, headers)}
+
+	req, err := http.NewRequest(http.MethodPost, server.URL, nil)
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer old")
+
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, resp.Body.Close())
+
+	// The provided request is not modified.
+	assert.Equal(t, "Bearer old", req.Header.Get("Authorization"))
+}
@@ -0,0 +1,49 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// NewProxy returns the Proxy function of an http.Transport
+// that sends every request through the proxy at proxyURL
+// (an http, https, or socks5 URL). If proxyURL is empty, the
+// proxy is selected using the standard environment variables
+// (HTTP_PROXY, HTTPS_PROXY, and NO_PROXY).
+func NewProxy(proxyURL string) (func(*http.Request) (*url.URL, error), error) {
+	if len(proxyURL) == 0 {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to parse proxy URL", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+
+	if len(u.Host) == 0 {
+		return nil, fmt.Errorf("proxy URL %q has no host", proxyURL)
+	}
+
+	return http.ProxyURL(u), nil
+}
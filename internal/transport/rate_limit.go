@@ -0,0 +1,112 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"net/http"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimitedTransport is an http.RoundTripper that
+// waits for a token from a shared token bucket before
+// forwarding each request. Because all fetcher traffic
+// (blocks, transactions, and balances) shares a single
+// http.Client, wrapping its transport throttles all
+// requests made to the Rosetta Server, unless it is
+// restricted to some paths (see SetPaths).
+type RateLimitedTransport struct {
+	next    http.RoundTripper
+	limiter *rate.Limiter
+	paths   []string
+}
+
+// limit converts requestsPerSecond to a rate.Limit,
+// where 0 disables rate limiting.
+func limit(requestsPerSecond float64) rate.Limit {
+	if requestsPerSecond <= 0 {
+		return rate.Inf
+	}
+
+	return rate.Limit(requestsPerSecond)
+}
+
+// NewRateLimitedTransport returns a new RateLimitedTransport
+// that allows requestsPerSecond requests on average with
+// bursts of up to burst requests. If requestsPerSecond is 0,
+// requests are not limited until a limit is set.
+func NewRateLimitedTransport(
+	next http.RoundTripper,
+	requestsPerSecond float64,
+	burst int,
+) *RateLimitedTransport {
+	return &RateLimitedTransport{
+		next:    next,
+		limiter: rate.NewLimiter(limit(requestsPerSecond), burst),
+	}
+}
+
+// SetPaths restricts rate limiting to requests whose URL
+// path ends with one of paths (ex: "/block" to limit block
+// fetches but not the transactions fetched for each block).
+// It must be called before any requests are made.
+func (t *RateLimitedTransport) SetPaths(paths ...string) {
+	t.paths = paths
+}
+
+// SetLimit changes the allowed requests per second (0
+// disables rate limiting). This can be called while
+// requests are in flight.
+func (t *RateLimitedTransport) SetLimit(requestsPerSecond float64) {
+	t.limiter.SetLimit(limit(requestsPerSecond))
+}
+
+// Limit returns the currently allowed requests per second,
+// or 0 if rate limiting is disabled.
+func (t *RateLimitedTransport) Limit() float64 {
+	if t.limiter.Limit() == rate.Inf {
+		return 0
+	}
+
+	return float64(t.limiter.Limit())
+}
+
+// limited returns whether req is rate limited.
+func (t *RateLimitedTransport) limited(req *http.Request) bool {
+	if len(t.paths) == 0 {
+		return true
+	}
+
+	for _, path := range t.paths {
+		if strings.HasSuffix(req.URL.Path, path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RoundTrip waits for the rate limiter (if the request
+// is rate limited) and then forwards the request.
+func (t *RateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.limited(req) {
+		if err := t.limiter.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitedTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	limited := NewRateLimitedTransport(http.DefaultTransport, 20, 1)
+	client := &http.Client{Transport: limited}
+
+	t.Run("Requests are throttled", func(t *testing.T) {
+		start := time.Now()
+		for i := 0; i < 5; i++ {
+			resp, err := client.Get(server.URL)
+			assert.NoError(t, err)
+			resp.Body.Close()
+		}
+
+		// The first request consumes the burst, the remaining
+		// 4 requests each wait 50ms.
+		assert.True(t, time.Since(start) >= 150*time.Millisecond)
+	})
+
+	t.Run("Limit can be changed", func(t *testing.T) {
+		limited.SetLimit(5)
+		assert.Equal(t, float64(5), limited.Limit())
+	})
+
+	t.Run("Limit can be disabled", func(t *testing.T) {
+		limited.SetLimit(0)
+		assert.Equal(t, float64(0), limited.Limit())
+
+		start := time.Now()
+		for i := 0; i < 5; i++ {
+			resp, err := client.Get(server.URL)
+			assert.NoError(t, err)
+			resp.Body.Close()
+		}
+		assert.True(t, time.Since(start) < 150*time.Millisecond)
+	})
+}
+
+func TestRateLimitedTransportPaths(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	limited := NewRateLimitedTransport(http.DefaultTransport, 20, 1)
+	limited.SetPaths("/block")
+	client := &http.Client{Transport: limited}
+
+	get := func(path string, n int) time.Duration {
+		start := time.Now()
+		for i := 0; i < n; i++ {
+			resp, err := client.Get(server.URL + path)
+			assert.NoError(t, err)
+			resp.Body.Close()
+		}
+
+		return time.Since(start)
+	}
+
+	t.Run("Other paths are not throttled", func(t *testing.T) {
+		assert.True(t, get("/block/transaction", 5) < 150*time.Millisecond)
+		assert.True(t, get("/account/balance", 5) < 150*time.Millisecond)
+	})
+
+	t.Run("Paths are throttled", func(t *testing.T) {
+		assert.True(t, get("/rosetta/block", 5) >= 150*time.Millisecond)
+	})
+}
@@ -0,0 +1,233 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxExchangeSize is the largest recorded exchange
+// (a single JSON line) that can be replayed.
+const maxExchangeSize = 256 << 20
+
+// ErrReplayExhausted is returned by a ReplayTransport when
+// a request was not recorded (or was made more times than
+// it was recorded).
+var ErrReplayExhausted = errors.New("request not found in replay archive")
+
+// Exchange is a request to the Rosetta Server and its
+// response, as recorded by a RecordingTransport. If the
+// request failed without a response, Error is set.
+type Exchange struct {
+	Time       time.Time     `json:"time"`
+	Duration   time.Duration `json:"duration"`
+	Method     string        `json:"method"`
+	Path       string        `json:"path"`
+	Request    string        `json:"request"`
+	StatusCode int           `json:"status_code,omitempty"`
+	Response   string        `json:"response,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// exchangeKey identifies the requests of Exchanges
+// that can be replayed to each other.
+func exchangeKey(method string, path string, body []byte) string {
+	return method + " " + path + " " + string(body)
+}
+
+// readRequestBody reads the body of req and returns a
+// clone of req that can be read again (RoundTrippers
+// must not modify the provided request).
+func readRequestBody(req *http.Request) (*http.Request, []byte, error) {
+	if req.Body == nil {
+		return req, nil, nil
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Body.Close()
+
+	req = req.Clone(req.Context())
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	return req, body, nil
+}
+
+// RecordingTransport is an http.RoundTripper that appends
+// every request and its response to an archive of JSON
+// Exchanges (one per line) that can be replayed by a
+// ReplayTransport to reproduce a validation run without
+// the Rosetta Server.
+type RecordingTransport struct {
+	next http.RoundTripper
+
+	mutex   sync.Mutex
+	archive io.Writer
+}
+
+// NewRecordingTransport returns a new RecordingTransport
+// that records Exchanges to archive.
+func NewRecordingTransport(next http.RoundTripper, archive io.Writer) *RecordingTransport {
+	return &RecordingTransport{
+		next:    next,
+		archive: archive,
+	}
+}
+
+// record appends an Exchange to the archive.
+func (t *RecordingTransport) record(exchange *Exchange) error {
+	line, err := json.Marshal(exchange)
+	if err != nil {
+		return err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	_, err = t.archive.Write(append(line, '\n'))
+	return err
+}
+
+// RoundTrip forwards the request and records it
+// along with its response (or error).
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req, body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	exchange := &Exchange{
+		Time:    time.Now(),
+		Method:  req.Method,
+		Path:    req.URL.Path,
+		Request: string(body),
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	exchange.Duration = time.Since(exchange.Time)
+	if err != nil {
+		exchange.Error = err.Error()
+		if recordErr := t.record(exchange); recordErr != nil {
+			return nil, fmt.Errorf("unable to record exchange: %w", recordErr)
+		}
+
+		return nil, err
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	exchange.StatusCode = resp.StatusCode
+	exchange.Response = string(respBody)
+	if err := t.record(exchange); err != nil {
+		return nil, fmt.Errorf("unable to record exchange: %w", err)
+	}
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+	resp.ContentLength = int64(len(respBody))
+	return resp, nil
+}
+
+// ReplayTransport is an http.RoundTripper that serves
+// responses from an archive recorded by a RecordingTransport
+// instead of sending requests. Each request is answered with
+// the responses recorded for it, in the order they were
+// recorded, so requests that are repeated (ex: fetching the
+// network status) replay the progression of the recording.
+type ReplayTransport struct {
+	mutex     sync.Mutex
+	exchanges map[string][]*Exchange
+}
+
+// NewReplayTransport returns a new ReplayTransport
+// that replays the Exchanges read from archive.
+func NewReplayTransport(archive io.Reader) (*ReplayTransport, error) {
+	exchanges := map[string][]*Exchange{}
+	scanner := bufio.NewScanner(archive)
+	scanner.Buffer(nil, maxExchangeSize)
+	for line := 1; scanner.Scan(); line++ {
+		var exchange Exchange
+		if err := json.Unmarshal(scanner.Bytes(), &exchange); err != nil {
+			return nil, fmt.Errorf("invalid exchange on line %d: %w", line, err)
+		}
+
+		key := exchangeKey(exchange.Method, exchange.Path, []byte(exchange.Request))
+		exchanges[key] = append(exchanges[key], &exchange)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &ReplayTransport{
+		exchanges: exchanges,
+	}, nil
+}
+
+// next removes and returns the next recorded
+// Exchange for a request.
+func (t *ReplayTransport) next(key string) (*Exchange, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	recorded := t.exchanges[key]
+	if len(recorded) == 0 {
+		return nil, false
+	}
+
+	t.exchanges[key] = recorded[1:]
+	return recorded[0], true
+}
+
+// RoundTrip returns the next recorded response
+// to the request.
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req, body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	exchange, ok := t.next(exchangeKey(req.Method, req.URL.Path, body))
+	if !ok {
+		return nil, fmt.Errorf("%w: %s %s", ErrReplayExhausted, req.Method, req.URL.Path)
+	}
+
+	if len(exchange.Error) > 0 {
+		return nil, errors.New(exchange.Error)
+	}
+
+	return (&cachedResponse{
+		statusCode: exchange.StatusCode,
+		header:     http.Header{"Content-Type": []string{"application/json"}},
+		body:       []byte(exchange.Response),
+	}).toResponse(req), nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body, _ := ioutil.ReadAll(r.Body)
+		_, _ = w.Write([]byte(fmt.Sprintf("%s:%s:%d", r.URL.Path, body, requests)))
+	}))
+	defer server.Close()
+
+	post := func(client *http.Client, url string, path string, body string) (string, error) {
+		resp, err := client.Post(url+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		respBody, err := ioutil.ReadAll(resp.Body)
+		return string(respBody), err
+	}
+
+	var archive bytes.Buffer
+	recorder := &http.Client{Transport: NewRecordingTransport(http.DefaultTransport, &archive)}
+	recorded := []string{}
+	for _, request := range [][]string{
+		{"/network/status", "{}"},
+		{"/block", `{"index":1}`},
+		{"/network/status", "{}"},
+	} {
+		response, err := post(recorder, server.URL, request[0], request[1])
+		assert.NoError(t, err)
+		recorded = append(recorded, response)
+	}
+	assert.Equal(t, []string{
+		"/network/status:{}:1",
+		`/block:{"index":1}:2`,
+		"/network/status:{}:3",
+	}, recorded)
+	assert.Equal(t, 3, strings.Count(archive.String(), "\n"))
+
+	// Requests are replayed without contacting the server,
+	// in the order they were recorded for each request.
+	replay, err := NewReplayTransport(&archive)
+	assert.NoError(t, err)
+	replayer := &http.Client{Transport: replay}
+	for _, request := range [][]string{
+		{"/network/status", "{}", recorded[0]},
+		{"/network/status", "{}", recorded[2]},
+		{"/block", `{"index":1}`, recorded[1]},
+	} {
+		response, err := post(replayer, "http://replay.invalid", request[0], request[1])
+		assert.NoError(t, err)
+		assert.Equal(t, request[2], response)
+	}
+	assert.Equal(t, 3, requests)
+
+	_, err = post(replayer, "http://replay.invalid", "/network/status", "{}")
+	assert.True(t, errors.Is(err, ErrReplayExhausted))
+
+	_, err = post(replayer, "http://replay.invalid", "/block", `{"index":2}`)
+	assert.True(t, errors.Is(err, ErrReplayExhausted))
+}
+
+func TestReplayErrors(t *testing.T) {
+	archive := strings.NewReader(
+		`{"method":"POST","path":"/block","request":"{}","error":"connection refused"}` + "\n",
+	)
+	replay, err := NewReplayTransport(archive)
+	assert.NoError(t, err)
+
+	_, err = (&http.Client{Transport: replay}).Post("http://replay.invalid/block", "application/json", strings.NewReader("{}"))
+	assert.Contains(t, err.Error(), "connection refused")
+
+	_, err = NewReplayTransport(strings.NewReader("{\n"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,334 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync/atomic"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+// networkStatusPath is the Rosetta endpoint for
+// fetching the current head of the network.
+const networkStatusPath = "/network/status"
+
+// ErrReplicaMismatch is wrapped by a ReplicaMismatch.
+var ErrReplicaMismatch = errors.New("replica responses differ")
+
+// ReplicaMismatch describes two replicas returning
+// different responses to an identical block request.
+type ReplicaMismatch struct {
+	Path    string
+	Request string
+
+	// Block is the block_identifier of the request
+	// (nil if it could not be decoded).
+	Block *rosetta.PartialBlockIdentifier
+
+	// Response and CheckResponse are the entire
+	// response bodies of Replica and CheckReplica.
+	Replica       string
+	Response      string
+	CheckReplica  string
+	CheckResponse string
+}
+
+// responseDigest returns a short digest of a
+// response body to tell responses apart in logs.
+func responseDigest(response string) string {
+	digest := sha256.Sum256([]byte(response))
+	return fmt.Sprintf("%x", digest[:8])
+}
+
+// Error returns a description of the ReplicaMismatch. The
+// responses are identified by their digests because they
+// may be very large.
+func (m *ReplicaMismatch) Error() string {
+	block := "unknown"
+	if m.Block != nil && m.Block.Index != nil {
+		block = fmt.Sprintf("%d", *m.Block.Index)
+		if m.Block.Hash != nil {
+			block = fmt.Sprintf("%s (%s)", block, *m.Block.Hash)
+		}
+	}
+
+	return fmt.Sprintf(
+		"%v: %s request for block %s returned response %s from %s and %s from %s",
+		ErrReplicaMismatch,
+		m.Path,
+		block,
+		responseDigest(m.Response),
+		m.Replica,
+		responseDigest(m.CheckResponse),
+		m.CheckReplica,
+	)
+}
+
+// Unwrap returns ErrReplicaMismatch.
+func (m *ReplicaMismatch) Unwrap() error {
+	return ErrReplicaMismatch
+}
+
+// MismatchHandler is invoked with each ReplicaMismatch
+// found by a ReplicaTransport.
+type MismatchHandler func(mismatch *ReplicaMismatch)
+
+// ReplicaTransport is an http.RoundTripper that distributes
+// requests across replicas of the same Rosetta Server in
+// round-robin order. Every checkInterval-th block request
+// is also sent to the next replica and the responses are
+// compared, catching replicas that have drifted apart.
+// Requests for the network status are always sent to
+// the primary so that the head used to decide what to
+// sync does not move backwards when replicas lag.
+type ReplicaTransport struct {
+	next          http.RoundTripper
+	primary       *url.URL
+	replicas      []*url.URL
+	checkInterval uint64
+
+	requests      uint64
+	blockRequests uint64
+
+	// handler holds a MismatchHandler.
+	handler atomic.Value
+}
+
+// NewReplicaTransport returns a new ReplicaTransport. Requests
+// are expected to be addressed to primary (the address the
+// fetcher was constructed with), which is also included in
+// the round-robin rotation. If checkInterval is 0, no
+// consistency checks are performed.
+func NewReplicaTransport(
+	next http.RoundTripper,
+	primary string,
+	replicas []string,
+	checkInterval uint64,
+) (*ReplicaTransport, error) {
+	primaryURL, err := url.Parse(primary)
+	if err != nil {
+		return nil, err
+	}
+
+	replicaURLs := []*url.URL{primaryURL}
+	for _, replica := range replicas {
+		replicaURL, err := url.Parse(replica)
+		if err != nil {
+			return nil, err
+		}
+
+		replicaURLs = append(replicaURLs, replicaURL)
+	}
+
+	return &ReplicaTransport{
+		next:          next,
+		primary:       primaryURL,
+		replicas:      replicaURLs,
+		checkInterval: checkInterval,
+	}, nil
+}
+
+// SetMismatchHandler sets the handler invoked with each
+// ReplicaMismatch. A mismatch does not fail the request
+// (the response of the replica in rotation is returned)
+// because retrying it would not resolve the drift.
+func (t *ReplicaTransport) SetMismatchHandler(handler MismatchHandler) {
+	t.handler.Store(handler)
+}
+
+// rewrite returns a copy of req addressed to replica.
+func (t *ReplicaTransport) rewrite(
+	req *http.Request,
+	replica *url.URL,
+	body []byte,
+) *http.Request {
+	replicaReq := req.Clone(req.Context())
+	replicaReq.URL.Scheme = replica.Scheme
+	replicaReq.URL.Host = replica.Host
+	replicaReq.URL.Path = replica.Path + strings.TrimPrefix(req.URL.Path, t.primary.Path)
+	replicaReq.Host = replica.Host
+	if body != nil {
+		replicaReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+		replicaReq.ContentLength = int64(len(body))
+	}
+
+	return replicaReq
+}
+
+// doRequest sends req with next and reads the
+// entire response body.
+func doRequest(next http.RoundTripper, req *http.Request) (*http.Response, []byte, error) {
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+	return resp, respBody, nil
+}
+
+// equivalentJSON returns a boolean indicating if a and b
+// encode the same JSON value (ignoring key order and
+// whitespace).
+func equivalentJSON(a []byte, b []byte) bool {
+	var aValue, bValue interface{}
+	if err := json.Unmarshal(a, &aValue); err != nil {
+		return bytes.Equal(a, b)
+	}
+
+	if err := json.Unmarshal(b, &bValue); err != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(aValue, bValue)
+}
+
+// mismatchBlock returns the block_identifier of a block
+// request body or nil if it cannot be decoded.
+func mismatchBlock(body []byte) *rosetta.PartialBlockIdentifier {
+	var req struct {
+		BlockIdentifier *rosetta.PartialBlockIdentifier `json:"block_identifier"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil
+	}
+
+	return req.BlockIdentifier
+}
+
+// identifiedRequest returns body with its block_identifier
+// replaced by the identifier of the block in respBody, or
+// nil if respBody does not contain a block.
+func identifiedRequest(body []byte, respBody []byte) []byte {
+	var req map[string]interface{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil
+	}
+
+	var resp struct {
+		Block *struct {
+			BlockIdentifier *rosetta.BlockIdentifier `json:"block_identifier"`
+		} `json:"block"`
+	}
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		return nil
+	}
+
+	if resp.Block == nil || resp.Block.BlockIdentifier == nil {
+		return nil
+	}
+
+	req["block_identifier"] = resp.Block.BlockIdentifier
+	identified, err := json.Marshal(req)
+	if err != nil {
+		return nil
+	}
+
+	return identified
+}
+
+// RoundTrip forwards the request to the next replica
+// in rotation (or to the primary for network status
+// requests) and performs a consistency check on
+// sampled block requests.
+func (t *ReplicaTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+
+	if strings.HasSuffix(req.URL.Path, networkStatusPath) {
+		return t.next.RoundTrip(t.rewrite(req, t.primary, body))
+	}
+
+	count := atomic.AddUint64(&t.requests, 1)
+	replicaIndex := int(count % uint64(len(t.replicas)))
+	replica := t.replicas[replicaIndex]
+
+	if !isBlockRequest(req.URL.Path) || t.checkInterval == 0 || len(t.replicas) < 2 {
+		return t.next.RoundTrip(t.rewrite(req, replica, body))
+	}
+
+	if atomic.AddUint64(&t.blockRequests, 1)%t.checkInterval != 0 {
+		return t.next.RoundTrip(t.rewrite(req, replica, body))
+	}
+
+	resp, respBody, err := doRequest(t.next, t.rewrite(req, replica, body))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	// Near the tip, replicas can return different blocks at
+	// the same index (ex: during a reorg or before a block has
+	// propagated), so a block requested only by index is
+	// requested from the other replica by the identifier of
+	// the block returned.
+	checkRequest := body
+	if block := mismatchBlock(body); block != nil && block.Hash == nil {
+		if identified := identifiedRequest(body, respBody); identified != nil {
+			checkRequest = identified
+		}
+	}
+
+	checkReplica := t.replicas[(replicaIndex+1)%len(t.replicas)]
+	checkResp, checkBody, err := doRequest(t.next, t.rewrite(req, checkReplica, checkRequest))
+
+	// A replica that cannot serve the request (ex: it has
+	// not yet seen the block) has not drifted.
+	if err != nil || checkResp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+
+	if !equivalentJSON(respBody, checkBody) {
+		mismatch := &ReplicaMismatch{
+			Path:          req.URL.Path,
+			Request:       string(checkRequest),
+			Block:         mismatchBlock(checkRequest),
+			Replica:       replica.Host,
+			Response:      string(respBody),
+			CheckReplica:  checkReplica.Host,
+			CheckResponse: string(checkBody),
+		}
+		log.Println(mismatch)
+
+		if handler, ok := t.handler.Load().(MismatchHandler); ok && handler != nil {
+			handler(mismatch)
+		}
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,176 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEquivalentJSON(t *testing.T) {
+	assert.True(t, equivalentJSON([]byte(`{"a":1,"b":2}`), []byte(`{"b":2, "a":1}`)))
+	assert.False(t, equivalentJSON([]byte(`{"a":1}`), []byte(`{"a":2}`)))
+	assert.False(t, equivalentJSON([]byte(`{"a":1}`), []byte(`{`)))
+}
+
+func TestReplicaTransport(t *testing.T) {
+	hits := map[string]int{}
+	newServer := func(name string, response string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[name]++
+			_, _ = w.Write([]byte(response))
+		}))
+	}
+
+	primary := newServer("primary", `{"block":{"hash":"a"}}`)
+	defer primary.Close()
+	replica := newServer("replica", `{"block": {"hash": "a"}}`)
+	defer replica.Close()
+	drifted := newServer("drifted", `{"block":{"hash":"b"}}`)
+	defer drifted.Close()
+
+	post := func(client *http.Client, path string, request string) (string, error) {
+		resp, err := client.Post(primary.URL+path, "application/json", strings.NewReader(request))
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	t.Run("Requests are distributed", func(t *testing.T) {
+		replicas, err := NewReplicaTransport(http.DefaultTransport, primary.URL, []string{replica.URL}, 0)
+		assert.NoError(t, err)
+		client := &http.Client{Transport: replicas}
+
+		for i := 0; i < 4; i++ {
+			_, err := post(client, "/account/balance", "{}")
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, 2, hits["primary"])
+		assert.Equal(t, 2, hits["replica"])
+	})
+
+	t.Run("Network status is sent to primary", func(t *testing.T) {
+		replicas, err := NewReplicaTransport(http.DefaultTransport, primary.URL, []string{replica.URL}, 0)
+		assert.NoError(t, err)
+		client := &http.Client{Transport: replicas}
+
+		for i := 0; i < 4; i++ {
+			_, err := post(client, "/network/status", "{}")
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, 6, hits["primary"])
+		assert.Equal(t, 2, hits["replica"])
+	})
+
+	t.Run("Consistent replicas", func(t *testing.T) {
+		replicas, err := NewReplicaTransport(http.DefaultTransport, primary.URL, []string{replica.URL}, 1)
+		assert.NoError(t, err)
+		client := &http.Client{Transport: replicas}
+
+		var mismatches []*ReplicaMismatch
+		replicas.SetMismatchHandler(func(mismatch *ReplicaMismatch) {
+			mismatches = append(mismatches, mismatch)
+		})
+
+		body, err := post(client, "/block", "{}")
+		assert.NoError(t, err)
+		assert.Equal(t, `{"block": {"hash": "a"}}`, body)
+		assert.Len(t, mismatches, 0)
+	})
+
+	t.Run("Drifted replica", func(t *testing.T) {
+		replicas, err := NewReplicaTransport(http.DefaultTransport, primary.URL, []string{drifted.URL}, 1)
+		assert.NoError(t, err)
+		client := &http.Client{Transport: replicas}
+
+		var mismatches []*ReplicaMismatch
+		replicas.SetMismatchHandler(func(mismatch *ReplicaMismatch) {
+			mismatches = append(mismatches, mismatch)
+		})
+
+		body, err := post(client, "/block", `{"block_identifier":{"index":10}}`)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"block":{"hash":"b"}}`, body)
+
+		assert.Len(t, mismatches, 1)
+		assert.True(t, errors.Is(mismatches[0], ErrReplicaMismatch))
+		assert.Equal(t, int64(10), *mismatches[0].Block.Index)
+		assert.Nil(t, mismatches[0].Block.Hash)
+
+		// The responses are not logged.
+		assert.NotContains(t, mismatches[0].Error(), `"hash"`)
+		assert.Contains(t, mismatches[0].Error(), "block 10")
+	})
+}
+
+func TestReplicaTransportForks(t *testing.T) {
+	// Each replica returns its own block at an index
+	// (as if they were on different forks) and any
+	// block requested by hash.
+	newServer := func(hash string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				BlockIdentifier struct {
+					Index int64  `json:"index"`
+					Hash  string `json:"hash"`
+				} `json:"block_identifier"`
+			}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			if len(req.BlockIdentifier.Hash) > 0 {
+				hash = req.BlockIdentifier.Hash
+			}
+
+			fmt.Fprintf(
+				w,
+				`{"block":{"block_identifier":{"index":%d,"hash":"%s"}}}`,
+				req.BlockIdentifier.Index,
+				hash,
+			)
+		}))
+	}
+
+	primary := newServer("a")
+	defer primary.Close()
+	forked := newServer("b")
+	defer forked.Close()
+
+	replicas, err := NewReplicaTransport(http.DefaultTransport, primary.URL, []string{forked.URL}, 1)
+	assert.NoError(t, err)
+	client := &http.Client{Transport: replicas}
+
+	var mismatches []*ReplicaMismatch
+	replicas.SetMismatchHandler(func(mismatch *ReplicaMismatch) {
+		mismatches = append(mismatches, mismatch)
+	})
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Post(primary.URL+"/block", "application/json", strings.NewReader(`{"block_identifier":{"index":10}}`))
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+	assert.Len(t, mismatches, 0)
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/coinbase/rosetta-validator/internal/scheduler"
+)
+
+const (
+	// accountBalancePath is the Rosetta endpoint for
+	// fetching an account balance.
+	accountBalancePath = "/account/balance"
+)
+
+// Slots grants slots for each scheduler.Class of work
+// (ex: a *scheduler.Scheduler or a *scheduler.Limiter).
+type Slots interface {
+	Acquire(ctx context.Context, class scheduler.Class) error
+	Release(class scheduler.Class)
+}
+
+// ScheduledTransport is an http.RoundTripper that holds a
+// slot from Slots for the duration of each request.
+// Account balance requests are scheduled as
+// reconciliation work and all other requests as fetch
+// work.
+type ScheduledTransport struct {
+	next  http.RoundTripper
+	slots Slots
+}
+
+// NewScheduledTransport returns a new ScheduledTransport.
+func NewScheduledTransport(
+	next http.RoundTripper,
+	slots Slots,
+) *ScheduledTransport {
+	return &ScheduledTransport{
+		next:  next,
+		slots: slots,
+	}
+}
+
+// RoundTrip waits for a slot and forwards the request.
+// The slot is released once the response has been
+// received (the body may still be unread).
+func (t *ScheduledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	class := scheduler.Fetch
+	if strings.HasSuffix(req.URL.Path, accountBalancePath) {
+		class = scheduler.Reconcile
+	}
+
+	if err := t.slots.Acquire(req.Context(), class); err != nil {
+		return nil, err
+	}
+	defer t.slots.Release(class)
+
+	return t.next.RoundTrip(req)
+}
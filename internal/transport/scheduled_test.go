@@ -0,0 +1,62 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coinbase/rosetta-validator/internal/scheduler"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduledTransport(t *testing.T) {
+	pool := scheduler.New(1, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == accountBalancePath {
+			assert.Equal(t, 1, pool.InUse(scheduler.Reconcile))
+		} else {
+			assert.Equal(t, 1, pool.InUse(scheduler.Fetch))
+		}
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: NewScheduledTransport(http.DefaultTransport, pool)}
+
+	for _, path := range []string{"/block", accountBalancePath} {
+		resp, err := client.Post(server.URL+path, "application/json", nil)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	assert.Equal(t, 0, pool.InUse(scheduler.Fetch))
+	assert.Equal(t, 0, pool.InUse(scheduler.Reconcile))
+
+	t.Run("Canceled while waiting", func(t *testing.T) {
+		assert.NoError(t, pool.Acquire(context.Background(), scheduler.Fetch))
+		defer pool.Release(scheduler.Fetch)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/block", nil)
+		assert.NoError(t, err)
+		_, err = client.Do(req)
+		assert.Error(t, err)
+	})
+}
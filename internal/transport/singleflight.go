@@ -0,0 +1,100 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// SingleflightTransport is an http.RoundTripper that collapses
+// identical concurrent requests for blocks and transactions
+// into a single request to the Rosetta Server. Requests are
+// identical if they have the same URL and body (and so the
+// same network and block identifier). Every caller receives
+// its own copy of the response.
+//
+// If the request that is forwarded fails (ex: because its
+// context is canceled), every caller waiting on it receives
+// the same error.
+type SingleflightTransport struct {
+	next  http.RoundTripper
+	group singleflight.Group
+}
+
+// NewSingleflightTransport returns a new SingleflightTransport.
+func NewSingleflightTransport(next http.RoundTripper) *SingleflightTransport {
+	return &SingleflightTransport{
+		next: next,
+	}
+}
+
+// isBlockRequest returns a boolean indicating if a request
+// to path fetches a block or a transaction in a block.
+func isBlockRequest(path string) bool {
+	return strings.HasSuffix(path, blockPath) || strings.HasSuffix(path, blockTransactionPath)
+}
+
+// RoundTrip forwards the request unless an identical
+// request is in flight, in which case it waits for
+// and returns a copy of that request's response.
+func (t *SingleflightTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost || req.Body == nil || !isBlockRequest(req.URL.Path) {
+		return t.next.RoundTrip(req)
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body.Close()
+
+	// RoundTrippers must not modify the provided request.
+	req = req.Clone(req.Context())
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	key := req.URL.String() + ":" + string(body)
+	shared, err, _ := t.group.Do(key, func() (interface{}, error) {
+		resp, err := t.next.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+
+		respBody, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		return &cachedResponse{
+			statusCode: resp.StatusCode,
+			header:     resp.Header.Clone(),
+			body:       respBody,
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return shared.(*cachedResponse).toResponse(req), nil
+}
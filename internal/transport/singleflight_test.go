@@ -0,0 +1,87 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSingleflightTransport(t *testing.T) {
+	var requests int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path == blockPath {
+			<-release
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: NewSingleflightTransport(http.DefaultTransport)}
+	post := func(path string, body string) string {
+		resp, err := client.Post(server.URL+path, "application/json", strings.NewReader(body))
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		respBody, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return string(respBody)
+	}
+
+	t.Run("Concurrent identical requests", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.Equal(t, `{"index":1}`, post(blockPath, `{"index":1}`))
+			}()
+		}
+
+		// Wait for the forwarded request to reach the server
+		// (and give the other callers time to join it) before
+		// allowing it to respond.
+		assert.Eventually(t, func() bool {
+			return atomic.LoadInt32(&requests) == 1
+		}, time.Second, time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	})
+
+	t.Run("Sequential requests are not deduplicated", func(t *testing.T) {
+		post(blockPath, `{"index":1}`)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+	})
+
+	t.Run("Other endpoints are forwarded", func(t *testing.T) {
+		assert.Equal(t, `{}`, post("/network/status", `{}`))
+		assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+	})
+}
@@ -0,0 +1,161 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidThrottleSchedule is returned when a
+// ThrottleSchedule cannot be parsed.
+var ErrInvalidThrottleSchedule = errors.New("Invalid throttle schedule")
+
+// ThrottleWindow limits throughput to Fraction of the
+// maximum from Start until End (offsets from midnight in
+// local time). If End is before Start, the window spans
+// midnight. If End equals Start, the window spans the
+// entire day.
+type ThrottleWindow struct {
+	Start    time.Duration
+	End      time.Duration
+	Fraction float64
+}
+
+// contains returns true if the offset from midnight
+// is within the window.
+func (w *ThrottleWindow) contains(offset time.Duration) bool {
+	if w.Start == w.End {
+		return true
+	}
+
+	if w.Start < w.End {
+		return offset >= w.Start && offset < w.End
+	}
+
+	return offset >= w.Start || offset < w.End
+}
+
+// ThrottleSchedule is a list of ThrottleWindows. Outside
+// of any window, throughput is not reduced.
+type ThrottleSchedule []*ThrottleWindow
+
+// parseTimeOfDay parses a time of day (ex: 09:30) into an
+// offset from midnight.
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidThrottleSchedule, err)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// ParseThrottleSchedule parses a comma-separated list of
+// windows formatted as start-end=fraction
+// (ex: 09:00-17:00=0.2,17:00-22:00=0.5). When windows
+// overlap, the first matching window applies.
+func ParseThrottleSchedule(value string) (ThrottleSchedule, error) {
+	schedule := ThrottleSchedule{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+
+		parts := strings.Split(entry, "=")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidThrottleSchedule, entry)
+		}
+
+		times := strings.Split(parts[0], "-")
+		if len(times) != 2 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidThrottleSchedule, entry)
+		}
+
+		start, err := parseTimeOfDay(times[0])
+		if err != nil {
+			return nil, err
+		}
+
+		end, err := parseTimeOfDay(times[1])
+		if err != nil {
+			return nil, err
+		}
+
+		fraction, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || fraction <= 0 || fraction > 1 {
+			return nil, fmt.Errorf(
+				"%w: fraction must be in (0, 1] in %s",
+				ErrInvalidThrottleSchedule,
+				entry,
+			)
+		}
+
+		schedule = append(schedule, &ThrottleWindow{
+			Start:    start,
+			End:      end,
+			Fraction: fraction,
+		})
+	}
+
+	return schedule, nil
+}
+
+// Fraction returns the fraction of the maximum
+// throughput allowed at t.
+func (s ThrottleSchedule) Fraction(t time.Time) float64 {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	for _, window := range s {
+		if window.contains(offset) {
+			return window.Fraction
+		}
+	}
+
+	return 1
+}
+
+// Run sets the limit of the RateLimitedTransport to the
+// fraction of requestsPerSecond allowed by the schedule,
+// checking every interval until the context is canceled.
+func (s ThrottleSchedule) Run(
+	ctx context.Context,
+	limited *RateLimitedTransport,
+	requestsPerSecond float64,
+	interval time.Duration,
+) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		limit := s.Fraction(time.Now()) * requestsPerSecond
+		if limit != limited.Limit() {
+			log.Printf("Throttling requests to %.2f per second\n", limit)
+			limited.SetLimit(limit)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseThrottleSchedule(t *testing.T) {
+	schedule, err := ParseThrottleSchedule("09:00-17:00=0.2, 22:00-06:00=0.5")
+	assert.NoError(t, err)
+	assert.Equal(t, ThrottleSchedule{
+		{Start: 9 * time.Hour, End: 17 * time.Hour, Fraction: 0.2},
+		{Start: 22 * time.Hour, End: 6 * time.Hour, Fraction: 0.5},
+	}, schedule)
+
+	at := func(hour int, minute int) time.Time {
+		return time.Date(2020, 4, 1, hour, minute, 0, 0, time.Local)
+	}
+
+	assert.Equal(t, 0.2, schedule.Fraction(at(9, 0)))
+	assert.Equal(t, 0.2, schedule.Fraction(at(16, 59)))
+	assert.Equal(t, float64(1), schedule.Fraction(at(17, 0)))
+	assert.Equal(t, 0.5, schedule.Fraction(at(23, 30)))
+	assert.Equal(t, 0.5, schedule.Fraction(at(2, 0)))
+	assert.Equal(t, float64(1), schedule.Fraction(at(6, 0)))
+
+	empty, err := ParseThrottleSchedule("")
+	assert.NoError(t, err)
+	assert.Equal(t, float64(1), empty.Fraction(at(12, 0)))
+
+	for _, invalid := range []string{
+		"09:00=0.2",
+		"09:00-17:00",
+		"9am-5pm=0.2",
+		"09:00-17:00=0",
+		"09:00-17:00=2",
+	} {
+		_, err := ParseThrottleSchedule(invalid)
+		assert.True(t, errors.Is(err, ErrInvalidThrottleSchedule), invalid)
+	}
+}
+
+func TestThrottleScheduleRun(t *testing.T) {
+	limited := NewRateLimitedTransport(http.DefaultTransport, 10, 1)
+	schedule := ThrottleSchedule{
+		{Start: 0, End: 0, Fraction: 0.5},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- schedule.Run(ctx, limited, 10, time.Millisecond)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return limited.Limit() == 5
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// NewTLSConfig returns the *tls.Config used to connect to a
+// Rosetta Server. If caFile is set, the server certificate must
+// be signed by one of the PEM-encoded certificates it contains
+// (instead of the system roots). If certFile and keyFile are
+// set, the PEM-encoded certificate and key are presented to the
+// server as a client certificate (mutual TLS). If
+// insecureSkipVerify is true, the server certificate is not
+// verified (this should only be used in development). If none
+// are set, nil is returned so that the defaults are used.
+func NewTLSConfig(
+	caFile string,
+	certFile string,
+	keyFile string,
+	insecureSkipVerify bool,
+) (*tls.Config, error) {
+	if len(caFile) == 0 && len(certFile) == 0 && len(keyFile) == 0 && !insecureSkipVerify {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+
+	if len(caFile) > 0 {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: unable to read CA bundle", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if len(certFile) > 0 || len(keyFile) > 0 {
+		if len(certFile) == 0 || len(keyFile) == 0 {
+			return nil, errors.New("client certificate and key must both be set")
+		}
+
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: unable to load client certificate", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestCertificate writes a self-signed certificate for
+// 127.0.0.1 (usable by both servers and clients) and its key
+// to dir.
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "rosetta"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	assert.NoError(t, ioutil.WriteFile(
+		certFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		0600,
+	))
+	assert.NoError(t, ioutil.WriteFile(
+		keyFile,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		0600,
+	))
+
+	return certFile, keyFile
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeTestCertificate(t, dir)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	assert.NoError(t, err)
+	ca, err := ioutil.ReadFile(certFile)
+	assert.NoError(t, err)
+	pool := x509.NewCertPool()
+	assert.True(t, pool.AppendCertsFromPEM(ca))
+
+	// The server requires a client certificate
+	// signed by the same CA.
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(tlsConfig *tls.Config) error {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return err
+		}
+
+		return resp.Body.Close()
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		tlsConfig, err := NewTLSConfig("", "", "", false)
+		assert.NoError(t, err)
+		assert.Nil(t, tlsConfig)
+	})
+
+	t.Run("mutual TLS", func(t *testing.T) {
+		tlsConfig, err := NewTLSConfig(certFile, certFile, keyFile, false)
+		assert.NoError(t, err)
+		assert.NoError(t, get(tlsConfig))
+	})
+
+	t.Run("missing client certificate", func(t *testing.T) {
+		tlsConfig, err := NewTLSConfig(certFile, "", "", false)
+		assert.NoError(t, err)
+		assert.Error(t, get(tlsConfig))
+	})
+
+	t.Run("unknown CA", func(t *testing.T) {
+		assert.Error(t, get(&tls.Config{}))
+
+		tlsConfig, err := NewTLSConfig("", certFile, keyFile, true)
+		assert.NoError(t, err)
+		assert.NoError(t, get(tlsConfig))
+	})
+
+	t.Run("invalid files", func(t *testing.T) {
+		_, err := NewTLSConfig(filepath.Join(dir, "missing.pem"), "", "", false)
+		assert.Error(t, err)
+
+		_, err = NewTLSConfig(keyFile, "", "", false)
+		assert.Error(t, err)
+
+		_, err = NewTLSConfig("", certFile, "", false)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+// ErrInvalidSkipListEntry is returned by ParseSkipList
+// if an entry is not an index or index:hash.
+var ErrInvalidSkipListEntry = errors.New("invalid skip list entry")
+
+// SkipList is a set of known-problem blocks (ex: historically
+// malformed blocks acknowledged upstream) whose assertion and
+// balance errors are logged and skipped instead of stopping
+// validation. A nil *SkipList contains no blocks.
+type SkipList struct {
+	// hashes are the hashes of the listed blocks by
+	// index ("" if any block at the index is listed).
+	hashes map[int64]string
+}
+
+// ParseSkipList returns a SkipList of entries, each of
+// which is a block index or an index:hash (to only list
+// the block at index with hash).
+func ParseSkipList(entries []string) (*SkipList, error) {
+	list := &SkipList{hashes: map[int64]string{}}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+
+		indexAndHash := strings.SplitN(entry, ":", 2)
+		index, err := strconv.ParseInt(indexAndHash[0], 10, 64)
+		if err != nil || index < 0 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidSkipListEntry, entry)
+		}
+
+		hash := ""
+		if len(indexAndHash) == 2 {
+			hash = indexAndHash[1]
+			if len(hash) == 0 {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidSkipListEntry, entry)
+			}
+		}
+
+		list.hashes[index] = hash
+	}
+
+	return list, nil
+}
+
+// Len returns the number of listed blocks.
+func (l *SkipList) Len() int {
+	if l == nil {
+		return 0
+	}
+
+	return len(l.hashes)
+}
+
+// ContainsIndex returns true if a block at
+// index may be listed (its hash is not checked).
+func (l *SkipList) ContainsIndex(index int64) bool {
+	if l == nil {
+		return false
+	}
+
+	_, ok := l.hashes[index]
+	return ok
+}
+
+// Contains returns true if block is listed.
+func (l *SkipList) Contains(block *rosetta.BlockIdentifier) bool {
+	if l == nil || block == nil {
+		return false
+	}
+
+	hash, ok := l.hashes[block.Index]
+	return ok && (len(hash) == 0 || hash == block.Hash)
+}
@@ -0,0 +1,49 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"errors"
+	"testing"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSkipList(t *testing.T) {
+	list, err := ParseSkipList([]string{"10", " 20:abc", ""})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, list.Len())
+
+	assert.True(t, list.ContainsIndex(10))
+	assert.True(t, list.ContainsIndex(20))
+	assert.False(t, list.ContainsIndex(30))
+
+	assert.True(t, list.Contains(&rosetta.BlockIdentifier{Index: 10, Hash: "any"}))
+	assert.True(t, list.Contains(&rosetta.BlockIdentifier{Index: 20, Hash: "abc"}))
+	assert.False(t, list.Contains(&rosetta.BlockIdentifier{Index: 20, Hash: "def"}))
+	assert.False(t, list.Contains(&rosetta.BlockIdentifier{Index: 30, Hash: "abc"}))
+
+	var empty *SkipList
+	assert.Equal(t, 0, empty.Len())
+	assert.False(t, empty.ContainsIndex(10))
+	assert.False(t, empty.Contains(&rosetta.BlockIdentifier{Index: 10, Hash: "any"}))
+
+	for _, entry := range []string{"abc", "-1", "10:"} {
+		_, err := ParseSkipList([]string{entry})
+		assert.True(t, errors.Is(err, ErrInvalidSkipListEntry), entry)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimestampUnit is the resolution of the block timestamps
+// returned by a Rosetta Server. The Rosetta specification
+// requires milliseconds, but some implementations return
+// other units.
+type TimestampUnit string
+
+const (
+	// Seconds is a timestamp in seconds since the Unix epoch.
+	Seconds TimestampUnit = "s"
+
+	// Milliseconds is a timestamp in milliseconds since the
+	// Unix epoch (required by the Rosetta specification).
+	Milliseconds TimestampUnit = "ms"
+
+	// Microseconds is a timestamp in microseconds
+	// since the Unix epoch.
+	Microseconds TimestampUnit = "us"
+
+	// Nanoseconds is a timestamp in nanoseconds
+	// since the Unix epoch.
+	Nanoseconds TimestampUnit = "ns"
+)
+
+var (
+	// ErrUnknownTimestampUnit is returned when a
+	// TimestampUnit is not recognized.
+	ErrUnknownTimestampUnit = errors.New("unknown timestamp unit")
+
+	// ErrTimestampUnitMismatch is returned when a timestamp
+	// appears to be in a different unit than configured.
+	ErrTimestampUnitMismatch = errors.New("timestamp unit mismatch")
+
+	// timestampUnitDurations is the duration of each
+	// TimestampUnit.
+	timestampUnitDurations = map[TimestampUnit]time.Duration{
+		Seconds:      time.Second,
+		Milliseconds: time.Millisecond,
+		Microseconds: time.Microsecond,
+		Nanoseconds:  time.Nanosecond,
+	}
+)
+
+// ParseTimestampUnit returns the TimestampUnit
+// named s ("s", "ms", "us", or "ns").
+func ParseTimestampUnit(s string) (TimestampUnit, error) {
+	unit := TimestampUnit(s)
+	if _, ok := timestampUnitDurations[unit]; !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownTimestampUnit, s)
+	}
+
+	return unit, nil
+}
+
+// ParseTimestampUnits parses a comma-separated list of
+// "blockchain/network=unit" entries into a map from
+// "blockchain/network" to TimestampUnit.
+func ParseTimestampUnits(s string) (map[string]TimestampUnit, error) {
+	units := map[string]TimestampUnit{}
+	if len(strings.TrimSpace(s)) == 0 {
+		return units, nil
+	}
+
+	for _, entry := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 || !strings.Contains(parts[0], "/") {
+			return nil, fmt.Errorf("invalid timestamp unit %q", entry)
+		}
+
+		unit, err := ParseTimestampUnit(parts[1])
+		if err != nil {
+			return nil, err
+		}
+
+		units[parts[0]] = unit
+	}
+
+	return units, nil
+}
+
+// DetectTimestampUnit guesses the TimestampUnit of a
+// timestamp from its magnitude, assuming it is between
+// 1973 and 5138 (the range in which every unit can be
+// told apart).
+func DetectTimestampUnit(timestamp int64) TimestampUnit {
+	switch {
+	case timestamp < 1e11:
+		return Seconds
+	case timestamp < 1e14:
+		return Milliseconds
+	case timestamp < 1e17:
+		return Microseconds
+	default:
+		return Nanoseconds
+	}
+}
+
+// Time returns the time.Time of a timestamp in unit u.
+func (u TimestampUnit) Time(timestamp int64) time.Time {
+	duration := timestampUnitDurations[u]
+	perSecond := int64(time.Second / duration)
+	return time.Unix(timestamp/perSecond, (timestamp%perSecond)*int64(duration)).UTC()
+}
+
+// Validate returns ErrTimestampUnitMismatch if a
+// timestamp appears to be in a unit other than u.
+func (u TimestampUnit) Validate(timestamp int64) error {
+	if detected := DetectTimestampUnit(timestamp); detected != u {
+		return fmt.Errorf(
+			"%w: timestamp %d appears to be in %s, not %s",
+			ErrTimestampUnitMismatch,
+			timestamp,
+			detected,
+			u,
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTimestampUnits(t *testing.T) {
+	units, err := ParseTimestampUnits("bitcoin/mainnet=s, ethereum/ropsten=ms")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]TimestampUnit{
+		"bitcoin/mainnet":  Seconds,
+		"ethereum/ropsten": Milliseconds,
+	}, units)
+
+	units, err = ParseTimestampUnits("")
+	assert.NoError(t, err)
+	assert.Len(t, units, 0)
+
+	_, err = ParseTimestampUnits("bitcoin/mainnet=minutes")
+	assert.True(t, errors.Is(err, ErrUnknownTimestampUnit))
+
+	_, err = ParseTimestampUnits("bitcoin=s")
+	assert.Error(t, err)
+}
+
+func TestTimestampUnit(t *testing.T) {
+	expected := time.Date(2020, time.April, 1, 12, 0, 0, 0, time.UTC)
+	var tests = map[TimestampUnit]int64{
+		Seconds:      expected.Unix(),
+		Milliseconds: expected.UnixNano() / int64(time.Millisecond),
+		Microseconds: expected.UnixNano() / int64(time.Microsecond),
+		Nanoseconds:  expected.UnixNano(),
+	}
+
+	for unit, timestamp := range tests {
+		t.Run(string(unit), func(t *testing.T) {
+			assert.Equal(t, expected, unit.Time(timestamp))
+			assert.Equal(t, unit, DetectTimestampUnit(timestamp))
+			assert.NoError(t, unit.Validate(timestamp))
+		})
+	}
+
+	err := Milliseconds.Validate(expected.Unix())
+	assert.True(t, errors.Is(err, ErrTimestampUnitMismatch))
+}
@@ -0,0 +1,61 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContextWithTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("No timeout", func(t *testing.T) {
+		timeoutCtx, cancel := ContextWithTimeout(ctx, 0)
+		defer cancel()
+
+		_, ok := timeoutCtx.Deadline()
+		assert.False(t, ok)
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		timeoutCtx, cancel := ContextWithTimeout(ctx, time.Millisecond)
+		defer cancel()
+
+		<-timeoutCtx.Done()
+		assert.Equal(t, context.DeadlineExceeded, timeoutCtx.Err())
+		assert.NoError(t, ctx.Err())
+	})
+}
+
+func TestWithoutCancel(t *testing.T) {
+	type key struct{}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "value"))
+	cancel()
+
+	detached := WithoutCancel(ctx)
+	assert.NoError(t, detached.Err())
+	assert.Nil(t, detached.Done())
+	assert.Equal(t, "value", detached.Value(key{}))
+
+	_, ok := detached.Deadline()
+	assert.False(t, ok)
+
+	background := context.Background()
+	assert.Equal(t, background, WithoutCancel(background))
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package validation constructs the storage and syncer
+// used to validate a network. It is shared by
+// rosetta-validator check and pkg/validator so that
+// both start (and resume) validation the same way.
+package validation
+
+import (
+	"context"
+	"log"
+
+	"github.com/coinbase/rosetta-validator/internal/metrics"
+	"github.com/coinbase/rosetta-validator/internal/storage"
+	"github.com/coinbase/rosetta-validator/internal/syncer"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+)
+
+// OpenStorage returns the BlockStorage of network in db.
+// It completes any commit that was interrupted while being
+// applied in chunks, checks that db was populated with the
+// same KeyHasher, network, and balance tracking (see
+// BlockStorage.InitializeBalanceTracking), and stores
+// networkStatus so that stored blocks can be asserted
+// without the Rosetta Server.
+func OpenStorage(
+	ctx context.Context,
+	db storage.Database,
+	codec storage.Codec,
+	keyHasher storage.KeyHasher,
+	network *rosetta.NetworkIdentifier,
+	networkStatus *rosetta.NetworkStatusResponse,
+	trackBalances bool,
+) (*storage.BlockStorage, error) {
+	blockStorage := storage.NewBlockStorage(ctx, db, codec, keyHasher)
+	completed, err := blockStorage.CompleteChunkedCommit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if completed {
+		log.Printf("Completed interrupted commit of %+v (too big to commit at once)\n", network)
+	}
+
+	if err := blockStorage.InitializeKeySchema(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := blockStorage.InitializeNetwork(ctx, network); err != nil {
+		return nil, err
+	}
+
+	if err := blockStorage.InitializeBalanceTracking(ctx, trackBalances); err != nil {
+		return nil, err
+	}
+
+	if err := blockStorage.StoreNetworkStatus(ctx, networkStatus); err != nil {
+		return nil, err
+	}
+
+	return blockStorage, nil
+}
+
+// NewSyncer resumes any reorg that was interrupted in
+// blockStorage and returns a Syncer of network that
+// continues from the head block stored in blockStorage
+// (loading older blocks from it in deep reorgs), along
+// with the resumed ReorgIntent (if any). queue may be nil.
+func NewSyncer(
+	ctx context.Context,
+	network *rosetta.NetworkIdentifier,
+	blockStorage *storage.BlockStorage,
+	f syncer.Fetcher,
+	handler syncer.Handler,
+	logger syncer.Logger,
+	sink metrics.Sink,
+	timeouts syncer.Timeouts,
+	queue syncer.Queue,
+) (*syncer.Syncer, *storage.ReorgIntent, error) {
+	intent, err := blockStorage.ResumeReorg(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pastBlocks, err := blockStorage.CreateBlockCache(ctx, syncer.PastBlockSize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s := syncer.New(ctx, network, f, handler, logger, sink, timeouts, queue, pastBlocks)
+	s.SetBlockHistory(blockStorage)
+
+	return s, intent, nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validation
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/coinbase/rosetta-validator/internal/metrics"
+	"github.com/coinbase/rosetta-validator/internal/storage"
+	"github.com/coinbase/rosetta-validator/internal/syncer"
+	mockSyncer "github.com/coinbase/rosetta-validator/mocks/syncer"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	network = &rosetta.NetworkIdentifier{
+		Blockchain: "blah",
+		Network:    "testnet",
+	}
+
+	networkStatus = &rosetta.NetworkStatusResponse{
+		NetworkStatus: &rosetta.NetworkStatus{
+			NetworkIdentifier: &rosetta.PartialNetworkIdentifier{
+				Network: network.Network,
+			},
+			NetworkInformation: &rosetta.NetworkInformation{
+				GenesisBlockIdentifier: &rosetta.BlockIdentifier{
+					Hash:  "0",
+					Index: 0,
+				},
+			},
+		},
+	}
+
+	head = &rosetta.BlockIdentifier{
+		Hash:  "1",
+		Index: 1,
+	}
+)
+
+func TestOpenStorage(t *testing.T) {
+	ctx := context.Background()
+	db := storage.NewMemoryStorage()
+
+	blockStorage, err := OpenStorage(ctx, db, &storage.GobCodec{}, &storage.SHA256KeyHasher{}, network, networkStatus, true)
+	assert.NoError(t, err)
+
+	txn := blockStorage.NewDatabaseTransaction(ctx, true)
+	assert.NoError(t, blockStorage.StoreBlock(ctx, txn, &rosetta.Block{
+		BlockIdentifier:       head,
+		ParentBlockIdentifier: networkStatus.NetworkStatus.NetworkInformation.GenesisBlockIdentifier,
+	}))
+	assert.NoError(t, blockStorage.StoreHeadBlockIdentifier(ctx, txn, head))
+	assert.NoError(t, txn.Commit(ctx))
+
+	t.Run("Network status stored", func(t *testing.T) {
+		txn := blockStorage.NewDatabaseTransaction(ctx, false)
+		defer txn.Discard(ctx)
+
+		stored, err := blockStorage.GetNetworkStatus(ctx, txn)
+		assert.NoError(t, err)
+		assert.Equal(t, networkStatus, stored)
+	})
+
+	t.Run("Reopened", func(t *testing.T) {
+		_, err := OpenStorage(ctx, db, &storage.GobCodec{}, &storage.SHA256KeyHasher{}, network, networkStatus, true)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Different key hasher", func(t *testing.T) {
+		_, err := OpenStorage(ctx, db, &storage.GobCodec{}, &storage.FNVKeyHasher{}, network, networkStatus, true)
+		assert.True(t, errors.Is(err, storage.ErrKeyHasherMismatch))
+	})
+
+	t.Run("Different network", func(t *testing.T) {
+		other := &rosetta.NetworkIdentifier{
+			Blockchain: "blah",
+			Network:    "mainnet",
+		}
+		_, err := OpenStorage(ctx, db, &storage.GobCodec{}, &storage.SHA256KeyHasher{}, other, networkStatus, true)
+		assert.True(t, errors.Is(err, storage.ErrNetworkMismatch))
+	})
+
+	t.Run("Different balance tracking", func(t *testing.T) {
+		_, err := OpenStorage(ctx, db, &storage.GobCodec{}, &storage.SHA256KeyHasher{}, network, networkStatus, false)
+		assert.True(t, errors.Is(err, storage.ErrBalanceTrackingMismatch))
+	})
+
+	t.Run("Syncer continues from head", func(t *testing.T) {
+		s, intent, err := NewSyncer(
+			ctx,
+			network,
+			blockStorage,
+			&mockSyncer.Fetcher{},
+			&mockSyncer.Handler{},
+			&mockSyncer.Logger{},
+			&metrics.NoOpSink{},
+			syncer.Timeouts{},
+			nil,
+		)
+		assert.NoError(t, err)
+		assert.Nil(t, intent)
+		assert.Equal(t, head.Index, s.Summary().EndIndex)
+	})
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processor
+
+import (
+	context "context"
+
+	fetcher "github.com/coinbase/rosetta-sdk-go/fetcher"
+	gen "github.com/coinbase/rosetta-sdk-go/gen"
+
+	mock "github.com/stretchr/testify/mock"
+
+	time "time"
+)
+
+// CorruptionFetcher is an autogenerated mock type for the CorruptionFetcher type
+type CorruptionFetcher struct {
+	mock.Mock
+}
+
+// BlockRange provides a mock function with given fields: ctx, network, startIndex, endIndex
+func (_m *CorruptionFetcher) BlockRange(ctx context.Context, network *gen.NetworkIdentifier, startIndex int64, endIndex int64) (map[int64]*fetcher.BlockAndLatency, error) {
+	ret := _m.Called(ctx, network, startIndex, endIndex)
+
+	if len(ret) == 0 {
+		panic("no return value specified for BlockRange")
+	}
+
+	var r0 map[int64]*fetcher.BlockAndLatency
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *gen.NetworkIdentifier, int64, int64) (map[int64]*fetcher.BlockAndLatency, error)); ok {
+		return rf(ctx, network, startIndex, endIndex)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *gen.NetworkIdentifier, int64, int64) map[int64]*fetcher.BlockAndLatency); ok {
+		r0 = rf(ctx, network, startIndex, endIndex)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[int64]*fetcher.BlockAndLatency)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *gen.NetworkIdentifier, int64, int64) error); ok {
+		r1 = rf(ctx, network, startIndex, endIndex)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// BlockRetry provides a mock function with given fields: ctx, network, blockIdentifier, maxElapsedTime, maxRetries
+func (_m *CorruptionFetcher) BlockRetry(ctx context.Context, network *gen.NetworkIdentifier, blockIdentifier *gen.PartialBlockIdentifier, maxElapsedTime time.Duration, maxRetries uint64) (*gen.Block, error) {
+	ret := _m.Called(ctx, network, blockIdentifier, maxElapsedTime, maxRetries)
+
+	if len(ret) == 0 {
+		panic("no return value specified for BlockRetry")
+	}
+
+	var r0 *gen.Block
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *gen.NetworkIdentifier, *gen.PartialBlockIdentifier, time.Duration, uint64) (*gen.Block, error)); ok {
+		return rf(ctx, network, blockIdentifier, maxElapsedTime, maxRetries)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *gen.NetworkIdentifier, *gen.PartialBlockIdentifier, time.Duration, uint64) *gen.Block); ok {
+		r0 = rf(ctx, network, blockIdentifier, maxElapsedTime, maxRetries)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*gen.Block)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *gen.NetworkIdentifier, *gen.PartialBlockIdentifier, time.Duration, uint64) error); ok {
+		r1 = rf(ctx, network, blockIdentifier, maxElapsedTime, maxRetries)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	context "context"
+
+	gen "github.com/coinbase/rosetta-sdk-go/gen"
+	mock "github.com/stretchr/testify/mock"
+
+	time "time"
+)
+
+// Fetcher is an autogenerated mock type for the Fetcher type
+type Fetcher struct {
+	mock.Mock
+}
+
+// AccountBalanceRetry provides a mock function with given fields: ctx, network, account, maxElapsedTime, maxRetries
+func (_m *Fetcher) AccountBalanceRetry(ctx context.Context, network *gen.NetworkIdentifier, account *gen.AccountIdentifier, maxElapsedTime time.Duration, maxRetries uint64) (*gen.BlockIdentifier, []*gen.Balance, error) {
+	ret := _m.Called(ctx, network, account, maxElapsedTime, maxRetries)
+
+	if len(ret) == 0 {
+		panic("no return value specified for AccountBalanceRetry")
+	}
+
+	var r0 *gen.BlockIdentifier
+	var r1 []*gen.Balance
+	var r2 error
+	if rf, ok := ret.Get(0).(func(context.Context, *gen.NetworkIdentifier, *gen.AccountIdentifier, time.Duration, uint64) (*gen.BlockIdentifier, []*gen.Balance, error)); ok {
+		return rf(ctx, network, account, maxElapsedTime, maxRetries)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *gen.NetworkIdentifier, *gen.AccountIdentifier, time.Duration, uint64) *gen.BlockIdentifier); ok {
+		r0 = rf(ctx, network, account, maxElapsedTime, maxRetries)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*gen.BlockIdentifier)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *gen.NetworkIdentifier, *gen.AccountIdentifier, time.Duration, uint64) []*gen.Balance); ok {
+		r1 = rf(ctx, network, account, maxElapsedTime, maxRetries)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).([]*gen.Balance)
+		}
+	}
+
+	if rf, ok := ret.Get(2).(func(context.Context, *gen.NetworkIdentifier, *gen.AccountIdentifier, time.Duration, uint64) error); ok {
+		r2 = rf(ctx, network, account, maxElapsedTime, maxRetries)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
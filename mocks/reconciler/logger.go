@@ -0,0 +1,63 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	context "context"
+
+	gen "github.com/coinbase/rosetta-sdk-go/gen"
+	mock "github.com/stretchr/testify/mock"
+)
+
+// Logger is an autogenerated mock type for the Logger type
+type Logger struct {
+	mock.Mock
+}
+
+// AccountLatency provides a mock function with given fields: ctx, account, latency, balances
+func (_m *Logger) AccountLatency(ctx context.Context, account *gen.AccountIdentifier, latency float64, balances int) error {
+	ret := _m.Called(ctx, account, latency, balances)
+
+	if len(ret) == 0 {
+		panic("no return value specified for AccountLatency")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *gen.AccountIdentifier, float64, int) error); ok {
+		r0 = rf(ctx, account, latency, balances)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ReconcileStream provides a mock function with given fields: ctx, reconciliationType, account, currency, difference, block
+func (_m *Logger) ReconcileStream(ctx context.Context, reconciliationType string, account *gen.AccountIdentifier, currency *gen.Currency, difference string, block *gen.BlockIdentifier) error {
+	ret := _m.Called(ctx, reconciliationType, account, currency, difference, block)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ReconcileStream")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, *gen.AccountIdentifier, *gen.Currency, string, *gen.BlockIdentifier) error); ok {
+		r0 = rf(ctx, reconciliationType, account, currency, difference, block)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
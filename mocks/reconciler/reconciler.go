@@ -0,0 +1,50 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	context "context"
+
+	reconciler "github.com/coinbase/rosetta-validator/internal/reconciler"
+	mock "github.com/stretchr/testify/mock"
+)
+
+// Reconciler is an autogenerated mock type for the Reconciler type
+type Reconciler struct {
+	mock.Mock
+}
+
+// QueueAccounts provides a mock function with given fields: ctx, blockIndex, accounts
+func (_m *Reconciler) QueueAccounts(ctx context.Context, blockIndex int64, accounts []*reconciler.AccountAndCurrency) {
+	_m.Called(ctx, blockIndex, accounts)
+}
+
+// Reconcile provides a mock function with given fields: ctx
+func (_m *Reconciler) Reconcile(ctx context.Context) error {
+	ret := _m.Called(ctx)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Reconcile")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
@@ -0,0 +1,57 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	context "context"
+
+	gen "github.com/coinbase/rosetta-sdk-go/gen"
+	mock "github.com/stretchr/testify/mock"
+)
+
+// BlockHistory is an autogenerated mock type for the BlockHistory type
+type BlockHistory struct {
+	mock.Mock
+}
+
+// PastBlocks provides a mock function with given fields: ctx, blockIdentifier, limit
+func (_m *BlockHistory) PastBlocks(ctx context.Context, blockIdentifier *gen.BlockIdentifier, limit int) ([]*gen.BlockIdentifier, error) {
+	ret := _m.Called(ctx, blockIdentifier, limit)
+
+	if len(ret) == 0 {
+		panic("no return value specified for PastBlocks")
+	}
+
+	var r0 []*gen.BlockIdentifier
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *gen.BlockIdentifier, int) ([]*gen.BlockIdentifier, error)); ok {
+		return rf(ctx, blockIdentifier, limit)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *gen.BlockIdentifier, int) []*gen.BlockIdentifier); ok {
+		r0 = rf(ctx, blockIdentifier, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*gen.BlockIdentifier)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *gen.BlockIdentifier, int) error); ok {
+		r1 = rf(ctx, blockIdentifier, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
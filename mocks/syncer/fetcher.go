@@ -0,0 +1,89 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	context "context"
+
+	gen "github.com/coinbase/rosetta-sdk-go/gen"
+	mock "github.com/stretchr/testify/mock"
+
+	time "time"
+)
+
+// Fetcher is an autogenerated mock type for the Fetcher type
+type Fetcher struct {
+	mock.Mock
+}
+
+// BlockRetry provides a mock function with given fields: ctx, network, blockIdentifier, maxElapsedTime, maxRetries
+func (_m *Fetcher) BlockRetry(ctx context.Context, network *gen.NetworkIdentifier, blockIdentifier *gen.PartialBlockIdentifier, maxElapsedTime time.Duration, maxRetries uint64) (*gen.Block, error) {
+	ret := _m.Called(ctx, network, blockIdentifier, maxElapsedTime, maxRetries)
+
+	if len(ret) == 0 {
+		panic("no return value specified for BlockRetry")
+	}
+
+	var r0 *gen.Block
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *gen.NetworkIdentifier, *gen.PartialBlockIdentifier, time.Duration, uint64) (*gen.Block, error)); ok {
+		return rf(ctx, network, blockIdentifier, maxElapsedTime, maxRetries)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *gen.NetworkIdentifier, *gen.PartialBlockIdentifier, time.Duration, uint64) *gen.Block); ok {
+		r0 = rf(ctx, network, blockIdentifier, maxElapsedTime, maxRetries)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*gen.Block)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *gen.NetworkIdentifier, *gen.PartialBlockIdentifier, time.Duration, uint64) error); ok {
+		r1 = rf(ctx, network, blockIdentifier, maxElapsedTime, maxRetries)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// NetworkStatusRetry provides a mock function with given fields: ctx, metadata, maxElapsedTime, maxRetries
+func (_m *Fetcher) NetworkStatusRetry(ctx context.Context, metadata *map[string]interface{}, maxElapsedTime time.Duration, maxRetries uint64) (*gen.NetworkStatusResponse, error) {
+	ret := _m.Called(ctx, metadata, maxElapsedTime, maxRetries)
+
+	if len(ret) == 0 {
+		panic("no return value specified for NetworkStatusRetry")
+	}
+
+	var r0 *gen.NetworkStatusResponse
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *map[string]interface{}, time.Duration, uint64) (*gen.NetworkStatusResponse, error)); ok {
+		return rf(ctx, metadata, maxElapsedTime, maxRetries)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *map[string]interface{}, time.Duration, uint64) *gen.NetworkStatusResponse); ok {
+		r0 = rf(ctx, metadata, maxElapsedTime, maxRetries)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*gen.NetworkStatusResponse)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *map[string]interface{}, time.Duration, uint64) error); ok {
+		r1 = rf(ctx, metadata, maxElapsedTime, maxRetries)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
@@ -0,0 +1,63 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	context "context"
+
+	gen "github.com/coinbase/rosetta-sdk-go/gen"
+	mock "github.com/stretchr/testify/mock"
+)
+
+// Handler is an autogenerated mock type for the Handler type
+type Handler struct {
+	mock.Mock
+}
+
+// BlockAdded provides a mock function with given fields: ctx, block
+func (_m *Handler) BlockAdded(ctx context.Context, block *gen.Block) error {
+	ret := _m.Called(ctx, block)
+
+	if len(ret) == 0 {
+		panic("no return value specified for BlockAdded")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *gen.Block) error); ok {
+		r0 = rf(ctx, block)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// BlockRemoved provides a mock function with given fields: ctx, block
+func (_m *Handler) BlockRemoved(ctx context.Context, block *gen.BlockIdentifier) error {
+	ret := _m.Called(ctx, block)
+
+	if len(ret) == 0 {
+		panic("no return value specified for BlockRemoved")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *gen.BlockIdentifier) error); ok {
+		r0 = rf(ctx, block)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
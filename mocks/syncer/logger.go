@@ -0,0 +1,45 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	context "context"
+
+	logger "github.com/coinbase/rosetta-validator/internal/logger"
+	mock "github.com/stretchr/testify/mock"
+)
+
+// Logger is an autogenerated mock type for the Logger type
+type Logger struct {
+	mock.Mock
+}
+
+// BlockStats provides a mock function with given fields: ctx, blocks
+func (_m *Logger) BlockStats(ctx context.Context, blocks []*logger.BlockStats) error {
+	ret := _m.Called(ctx, blocks)
+
+	if len(ret) == 0 {
+		panic("no return value specified for BlockStats")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, []*logger.BlockStats) error); ok {
+		r0 = rf(ctx, blocks)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	context "context"
+
+	gen "github.com/coinbase/rosetta-sdk-go/gen"
+	mock "github.com/stretchr/testify/mock"
+)
+
+// Queue is an autogenerated mock type for the Queue type
+type Queue struct {
+	mock.Mock
+}
+
+// QueueBlocks provides a mock function with given fields: ctx, blocks
+func (_m *Queue) QueueBlocks(ctx context.Context, blocks []*gen.Block) error {
+	ret := _m.Called(ctx, blocks)
+
+	if len(ret) == 0 {
+		panic("no return value specified for QueueBlocks")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, []*gen.Block) error); ok {
+		r0 = rf(ctx, blocks)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// QueuedBlock provides a mock function with given fields: ctx, index
+func (_m *Queue) QueuedBlock(ctx context.Context, index int64) (*gen.Block, error) {
+	ret := _m.Called(ctx, index)
+
+	if len(ret) == 0 {
+		panic("no return value specified for QueuedBlock")
+	}
+
+	var r0 *gen.Block
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) (*gen.Block, error)); ok {
+		return rf(ctx, index)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) *gen.Block); ok {
+		r0 = rf(ctx, index)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*gen.Block)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, index)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
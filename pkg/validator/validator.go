@@ -0,0 +1,375 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package validator embeds the validation performed by
+// rosetta-validator check in another Go program. A
+// Validator syncs every block of a Rosetta Server,
+// validating each one and computing the balance changes of
+// its operations, and reconciles the computed balances
+// against the live balances returned by the Rosetta Server.
+//
+// It is a subset of rosetta-validator check: sub-networks,
+// checkpoints, metrics, and resource limits are not
+// supported.
+package validator
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/coinbase/rosetta-validator/internal/logger"
+	"github.com/coinbase/rosetta-validator/internal/metrics"
+	"github.com/coinbase/rosetta-validator/internal/processor"
+	"github.com/coinbase/rosetta-validator/internal/reconciler"
+	"github.com/coinbase/rosetta-validator/internal/storage"
+	"github.com/coinbase/rosetta-validator/internal/syncer"
+	"github.com/coinbase/rosetta-validator/internal/utils"
+	"github.com/coinbase/rosetta-validator/internal/validation"
+
+	"github.com/coinbase/rosetta-sdk-go/fetcher"
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	// ErrBalanceMismatch is returned by Run when a computed
+	// balance differs from the live balance returned by the
+	// Rosetta Server.
+	ErrBalanceMismatch = reconciler.ErrBalanceMismatch
+
+	// ErrNetworkMismatch is returned by Run when the data
+	// directory contains data of another network.
+	ErrNetworkMismatch = storage.ErrNetworkMismatch
+
+	// ErrDirLocked is returned by Run when the data
+	// directory is used by another process.
+	ErrDirLocked = storage.ErrDirLocked
+)
+
+// BlockHook is implemented to enforce invariants specific
+// to a chain (ex: every block contains exactly one coinbase
+// operation). ValidateBlock is called with each block after
+// it has been asserted and before it is stored. Returning an
+// error stops validation.
+type BlockHook = processor.BlockHook
+
+// BlockHookError is returned by Run when a BlockHook
+// rejects a block. It wraps the error returned by the
+// BlockHook.
+type BlockHookError = processor.BlockHookError
+
+const (
+	defaultUserAgent              = "rosetta-validator"
+	defaultBlockConcurrency       = 8
+	defaultTransactionConcurrency = 8
+	defaultAccountConcurrency     = 8
+	defaultHTTPTimeout            = 10 * time.Second
+)
+
+// Validator validates a Rosetta Server. It is
+// constructed with New and run with Run.
+type Validator struct {
+	serverAddress          string
+	userAgent              string
+	httpClient             *http.Client
+	dataDir                string
+	logDir                 string
+	blockConcurrency       uint64
+	transactionConcurrency uint64
+	accountConcurrency     int
+	timestampUnit          utils.TimestampUnit
+	startIndex             int64
+	endIndex               int64
+	exitAtTip              bool
+	hooks                  []BlockHook
+}
+
+// Option configures a Validator.
+type Option func(*Validator)
+
+// WithDataDir stores validated data in dir (which is
+// created if needed) so that validation resumes from it.
+// By default, validated data is kept in memory and
+// discarded when Run returns.
+func WithDataDir(dir string) Option {
+	return func(v *Validator) {
+		v.dataDir = dir
+	}
+}
+
+// WithLogDir writes the logs of processed blocks (and
+// benchmarks) to dir. By default, logs are written to the
+// data directory or, if there is none, a temporary
+// directory that is removed when Run returns.
+func WithLogDir(dir string) Option {
+	return func(v *Validator) {
+		v.logDir = dir
+	}
+}
+
+// WithHTTPClient sends requests to the Rosetta Server
+// with client (ex: to share a transport or add
+// authentication).
+func WithHTTPClient(client *http.Client) Option {
+	return func(v *Validator) {
+		v.httpClient = client
+	}
+}
+
+// WithUserAgent identifies the Validator in the
+// User-Agent header of its requests.
+func WithUserAgent(userAgent string) Option {
+	return func(v *Validator) {
+		v.userAgent = userAgent
+	}
+}
+
+// WithConcurrency changes the number of blocks,
+// transactions, and accounts fetched concurrently.
+func WithConcurrency(blocks uint64, transactions uint64, accounts int) Option {
+	return func(v *Validator) {
+		v.blockConcurrency = blocks
+		v.transactionConcurrency = transactions
+		v.accountConcurrency = accounts
+	}
+}
+
+// WithTimestampUnit rejects blocks with timestamps that
+// appear to be in a unit other than unit ("s", "ms",
+// "us", or "ns"). By default, timestamps are not
+// validated.
+func WithTimestampUnit(unit string) Option {
+	return func(v *Validator) {
+		v.timestampUnit = utils.TimestampUnit(unit)
+	}
+}
+
+// WithStartIndex starts syncing at the block at index
+// instead of the block after genesis if no block has been
+// processed.
+func WithStartIndex(index int64) Option {
+	return func(v *Validator) {
+		v.startIndex = index
+	}
+}
+
+// WithEndIndex stops validation once the block at
+// index has been processed.
+func WithEndIndex(index int64) Option {
+	return func(v *Validator) {
+		v.endIndex = index
+	}
+}
+
+// WithExitAtTip stops validation once every block up to
+// the tip reported by the Rosetta Server has been
+// processed.
+func WithExitAtTip() Option {
+	return func(v *Validator) {
+		v.exitAtTip = true
+	}
+}
+
+// WithBlockHook validates each block with hook (in
+// addition to the checks performed by the Validator).
+// Hooks are called in the order they were added.
+func WithBlockHook(hook BlockHook) Option {
+	return func(v *Validator) {
+		v.hooks = append(v.hooks, hook)
+	}
+}
+
+// New returns a Validator of the Rosetta Server
+// at serverAddress configured by options.
+func New(serverAddress string, options ...Option) (*Validator, error) {
+	v := &Validator{
+		serverAddress:          serverAddress,
+		userAgent:              defaultUserAgent,
+		httpClient:             &http.Client{Timeout: defaultHTTPTimeout},
+		blockConcurrency:       defaultBlockConcurrency,
+		transactionConcurrency: defaultTransactionConcurrency,
+		accountConcurrency:     defaultAccountConcurrency,
+		startIndex:             -1,
+		endIndex:               -1,
+	}
+
+	for _, option := range options {
+		option(v)
+	}
+
+	if len(v.serverAddress) == 0 {
+		return nil, errors.New("server address is required")
+	}
+
+	if v.blockConcurrency == 0 || v.transactionConcurrency == 0 || v.accountConcurrency < 1 {
+		return nil, errors.New("concurrency must be at least 1")
+	}
+
+	if len(v.timestampUnit) > 0 {
+		unit, err := utils.ParseTimestampUnit(string(v.timestampUnit))
+		if err != nil {
+			return nil, err
+		}
+		v.timestampUnit = unit
+	}
+
+	return v, nil
+}
+
+// Run validates the Rosetta Server until ctx is canceled,
+// validation fails, or an end condition (WithEndIndex or
+// WithExitAtTip) is reached. Once an end condition is
+// reached, the accounts queued for reconciliation are
+// reconciled before Run returns nil.
+func (v *Validator) Run(ctx context.Context) error {
+	f := fetcher.New(
+		ctx,
+		v.serverAddress,
+		v.userAgent,
+		v.httpClient,
+		v.blockConcurrency,
+		v.transactionConcurrency,
+	)
+
+	networkResponse, err := f.InitializeAsserter(ctx)
+	if err != nil {
+		return err
+	}
+
+	network := &rosetta.NetworkIdentifier{
+		Network:    networkResponse.NetworkStatus.NetworkIdentifier.Network,
+		Blockchain: networkResponse.NetworkStatus.NetworkIdentifier.Blockchain,
+	}
+
+	logDir := v.logDir
+	if len(logDir) == 0 {
+		logDir = v.dataDir
+	}
+
+	if len(logDir) == 0 {
+		dir, err := ioutil.TempDir("", "rosetta-validator")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+
+		logDir = dir
+	}
+
+	var database storage.Database = storage.NewMemoryStorage()
+	if len(v.dataDir) > 0 {
+		if err := os.MkdirAll(v.dataDir, os.FileMode(0700)); err != nil {
+			return err
+		}
+
+		lock, err := storage.LockDir(v.dataDir)
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+
+		database, err = storage.NewBadgerStorage(ctx, v.dataDir)
+		if err != nil {
+			return err
+		}
+	}
+	defer database.Close(context.Background())
+
+	blockStorage, err := validation.OpenStorage(
+		ctx,
+		database,
+		&storage.GobCodec{},
+		&storage.SHA256KeyHasher{},
+		network,
+		networkResponse,
+		true,
+	)
+	if err != nil {
+		return err
+	}
+	blockStorage.SetStreamRetention(syncer.PastBlockSize)
+
+	logger := logger.NewLogger(logDir, false, false, false, false)
+	logger.SetTimestampUnit(v.timestampUnit)
+
+	sink := &metrics.NoOpSink{}
+	var r reconciler.Reconciler = &reconciler.NoOpReconciler{}
+	var stateful *reconciler.StatefulReconciler
+	if reconciler.ShouldReconcile(networkResponse) {
+		stateful = reconciler.NewStateful(
+			ctx,
+			network,
+			blockStorage,
+			f,
+			logger,
+			sink,
+			reconciler.Timeouts{},
+			v.accountConcurrency,
+		)
+		r = stateful
+	}
+
+	handler := processor.NewSyncHandler(ctx, blockStorage, f.Asserter, logger, r, nil)
+	handler.SetTimestampUnit(v.timestampUnit)
+	for _, hook := range v.hooks {
+		handler.AddBlockHook(hook)
+	}
+
+	s, _, err := validation.NewSyncer(
+		ctx,
+		network,
+		blockStorage,
+		f,
+		handler,
+		logger,
+		sink,
+		syncer.Timeouts{},
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+	s.SetBlockConcurrency(v.blockConcurrency)
+	if v.startIndex >= 0 {
+		s.SetStartIndex(v.startIndex)
+	}
+	if v.endIndex >= 0 {
+		s.SetEndIndex(v.endIndex)
+	}
+	s.SetExitAtTip(v.exitAtTip)
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		return r.Reconcile(gctx)
+	})
+	g.Go(func() error {
+		return s.Sync(gctx)
+	})
+
+	err = g.Wait()
+	if !errors.Is(err, syncer.ErrEndIndexReached) && !errors.Is(err, syncer.ErrTipReached) {
+		return err
+	}
+
+	if stateful != nil {
+		return stateful.Drain(ctx)
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2020 Coinbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coinbase/rosetta-validator/internal/utils"
+
+	rosetta "github.com/coinbase/rosetta-sdk-go/gen"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type noOpHook struct{}
+
+func (h *noOpHook) ValidateBlock(ctx context.Context, block *rosetta.Block) error {
+	return nil
+}
+
+func TestNew(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		v, err := New("http://localhost:8080")
+		assert.NoError(t, err)
+		assert.Equal(t, defaultUserAgent, v.userAgent)
+		assert.Equal(t, uint64(defaultBlockConcurrency), v.blockConcurrency)
+		assert.Equal(t, defaultAccountConcurrency, v.accountConcurrency)
+		assert.Equal(t, "", v.dataDir)
+		assert.Equal(t, int64(-1), v.startIndex)
+		assert.Equal(t, int64(-1), v.endIndex)
+		assert.False(t, v.exitAtTip)
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		v, err := New(
+			"http://localhost:8080",
+			WithDataDir("/data"),
+			WithUserAgent("embedded"),
+			WithConcurrency(16, 4, 2),
+			WithTimestampUnit("s"),
+			WithStartIndex(10),
+			WithEndIndex(20),
+			WithExitAtTip(),
+			WithBlockHook(&noOpHook{}),
+		)
+		assert.NoError(t, err)
+		assert.Equal(t, "/data", v.dataDir)
+		assert.Equal(t, "embedded", v.userAgent)
+		assert.Equal(t, uint64(16), v.blockConcurrency)
+		assert.Equal(t, uint64(4), v.transactionConcurrency)
+		assert.Equal(t, 2, v.accountConcurrency)
+		assert.Equal(t, utils.Seconds, v.timestampUnit)
+		assert.Equal(t, int64(10), v.startIndex)
+		assert.Equal(t, int64(20), v.endIndex)
+		assert.True(t, v.exitAtTip)
+		assert.Len(t, v.hooks, 1)
+	})
+
+	t.Run("No server address", func(t *testing.T) {
+		v, err := New("")
+		assert.Error(t, err)
+		assert.Nil(t, v)
+	})
+
+	t.Run("Invalid concurrency", func(t *testing.T) {
+		v, err := New("http://localhost:8080", WithConcurrency(0, 1, 1))
+		assert.Error(t, err)
+		assert.Nil(t, v)
+	})
+
+	t.Run("Invalid timestamp unit", func(t *testing.T) {
+		v, err := New("http://localhost:8080", WithTimestampUnit("hours"))
+		assert.Error(t, err)
+		assert.Nil(t, v)
+	})
+}